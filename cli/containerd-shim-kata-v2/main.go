@@ -6,8 +6,17 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/containerd/containerd/runtime/v2/shim"
 	"github.com/kata-containers/runtime/containerd-shim-v2"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+)
+
+// version and commit are set at build time.
+var (
+	version = "unknown"
+	commit  = "unknown"
 )
 
 func shimConfig(config *shim.Config) {
@@ -16,5 +25,7 @@ func shimConfig(config *shim.Config) {
 }
 
 func main() {
+	vc.SetRuntimeVersion(fmt.Sprintf("%s (commit %s)", version, commit))
+
 	shim.Run("io.containerd.kata.v2", containerdshim.New, shimConfig)
 }
@@ -139,6 +139,10 @@ func create(ctx context.Context, containerID, bundlePath, console, pidFilePath s
 			return err
 		}
 	case vc.PodContainer:
+		if err = oci.CheckAnnotations(ociSpec, runtimeConfig.HypervisorConfig); err != nil {
+			return err
+		}
+
 		process, err = katautils.CreateContainer(ctx, vci, nil, ociSpec, rootFs, containerID, bundlePath, console, disableOutput, false)
 		if err != nil {
 			return err
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -30,6 +31,8 @@ var factorySubCmds = []cli.Command{
 	initFactoryCommand,
 	destroyFactoryCommand,
 	statusFactoryCommand,
+	warmFactoryCommand,
+	evictFactoryCommand,
 }
 
 var factoryCLICommand = cli.Command{
@@ -99,6 +102,26 @@ func (s *cacheServer) Status(ctx context.Context, empty *types.Empty) (*pb.GrpcS
 	return &stat, nil
 }
 
+// Warm sets the number of VMs VMCache keeps ready.
+func (s *cacheServer) Warm(ctx context.Context, req *pb.GrpcWarmRequest) (*pb.GrpcStatus, error) {
+	kataLog.WithField("count", req.Count).Info("VM cache server warms VMs")
+	if err := s.factory.WarmVMs(ctx, uint(req.Count)); err != nil {
+		return nil, err
+	}
+
+	return s.Status(ctx, &types.Empty{})
+}
+
+// Evict replaces the VM req.Id of VMCache, or all of them if it is empty.
+func (s *cacheServer) Evict(ctx context.Context, req *pb.GrpcEvictRequest) (*types.Empty, error) {
+	kataLog.WithField("vm", req.Id).Info("VM cache server evicts VMs")
+	if err := s.factory.EvictVM(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	return &types.Empty{}, nil
+}
+
 func getUnixListener(path string) (net.Listener, error) {
 	err := os.MkdirAll(filepath.Dir(path), 0755)
 	if err != nil {
@@ -302,7 +325,7 @@ var statusFactoryCommand = cli.Command{
 				} else {
 					fmt.Fprintf(defaultOutputFile, "VM cache server pid = %d\n", status.Pid)
 					for _, vs := range status.Vmstatus {
-						fmt.Fprintf(defaultOutputFile, "VM pid = %d Cpu = %d Memory = %dMiB\n", vs.Pid, vs.Cpu, vs.Memory)
+						fmt.Fprintf(defaultOutputFile, "VM id = %s pid = %d Cpu = %d Memory = %dMiB\n", vs.Id, vs.Pid, vs.Cpu, vs.Memory)
 					}
 				}
 			}
@@ -333,3 +356,81 @@ var statusFactoryCommand = cli.Command{
 		return nil
 	},
 }
+
+// vmCacheClient connects to the VMCache server of the runtime configuration.
+func vmCacheClient(c *cli.Context) (pb.CacheServiceClient, *grpc.ClientConn, error) {
+	runtimeConfig, ok := c.App.Metadata["runtimeConfig"].(oci.RuntimeConfig)
+	if !ok {
+		return nil, nil, errors.New("invalid runtime config")
+	}
+
+	if runtimeConfig.FactoryConfig.VMCacheNumber == 0 {
+		return nil, nil, errors.New("VMCache is not enabled")
+	}
+
+	conn, err := grpc.Dial(fmt.Sprintf("unix://%s", runtimeConfig.FactoryConfig.VMCacheEndpoint), grpc.WithInsecure())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to connect %q", runtimeConfig.FactoryConfig.VMCacheEndpoint)
+	}
+
+	return pb.NewCacheServiceClient(conn), conn, nil
+}
+
+var warmFactoryCommand = cli.Command{
+	Name:      "warm",
+	Usage:     "set the number of VMs the VMCache server keeps ready",
+	ArgsUsage: "<count>",
+	Action: func(c *cli.Context) error {
+		ctx, err := cliContextToContext(c)
+		if err != nil {
+			return err
+		}
+
+		count, err := strconv.ParseUint(c.Args().First(), 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid VM count %q", c.Args().First())
+		}
+
+		client, conn, err := vmCacheClient(c)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		status, err := client.Warm(ctx, &pb.GrpcWarmRequest{Count: uint32(count)})
+		if err != nil {
+			return errors.Wrapf(err, "failed to call gRPC Warm")
+		}
+
+		fmt.Fprintf(defaultOutputFile, "VM cache server keeps %d VMs ready, %d VMs are ready\n", count, len(status.Vmstatus))
+		return nil
+	},
+}
+
+var evictFactoryCommand = cli.Command{
+	Name:  "evict",
+	Usage: "replace a VM of the VMCache server",
+	ArgsUsage: `[<vm-id>]
+
+Where "<vm-id>" is the id of the VM to replace, as listed by the status
+command. All the VMs are replaced when it is omitted.`,
+	Action: func(c *cli.Context) error {
+		ctx, err := cliContextToContext(c)
+		if err != nil {
+			return err
+		}
+
+		client, conn, err := vmCacheClient(c)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if _, err := client.Evict(ctx, &pb.GrpcEvictRequest{Id: c.Args().First()}); err != nil {
+			return errors.Wrapf(err, "failed to call gRPC Evict")
+		}
+
+		fmt.Fprintln(defaultOutputFile, "VM cache server VMs evicted")
+		return nil
+	},
+}
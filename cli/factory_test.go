@@ -38,6 +38,25 @@ func TestFactoryCLIFunctionNoRuntimeConfig(t *testing.T) {
 	err = fn(ctx)
 	// no runtime config in the Metadata
 	assert.Error(err)
+
+	// warm and evict read their arguments
+	set := flag.NewFlagSet("", 0)
+	set.Parse([]string{"2"})
+	ctx = createCLIContext(set)
+	ctx.App.Name = "foo"
+	ctx.App.Metadata["foo"] = "bar"
+
+	fn, ok = warmFactoryCommand.Action.(func(context *cli.Context) error)
+	assert.True(ok)
+	err = fn(ctx)
+	// no runtime config in the Metadata
+	assert.Error(err)
+
+	fn, ok = evictFactoryCommand.Action.(func(context *cli.Context) error)
+	assert.True(ok)
+	err = fn(ctx)
+	// no runtime config in the Metadata
+	assert.Error(err)
 }
 
 func TestFactoryCLIFunctionInit(t *testing.T) {
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/agentctl"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var kataAgentCtlCLICommand = cli.Command{
+	Name:  "kata-agent-ctl",
+	Usage: "send individual RPCs to the agent of a sandbox (debug only)",
+	Subcommands: []cli.Command{
+		agentListRPCsCommand,
+		agentCallCommand,
+	},
+	Action: func(context *cli.Context) error {
+		return cli.ShowSubcommandHelp(context)
+	},
+}
+
+var agentYamuxFlag = cli.BoolFlag{
+	Name:  "yamux",
+	Usage: "multiplex the connection, required when the runtime uses the built-in proxy",
+}
+
+var agentListRPCsCommand = cli.Command{
+	Name:      "list-rpcs",
+	Usage:     "list the RPCs supported by the agent of a sandbox",
+	ArgsUsage: `list-rpcs <sandbox-id>`,
+	Flags:     []cli.Flag{agentYamuxFlag},
+	Action: func(context *cli.Context) error {
+		ctx, err := cliContextToContext(context)
+		if err != nil {
+			return err
+		}
+
+		return agentListRPCs(ctx, context.Args().First(), context.Bool("yamux"))
+	},
+}
+
+var agentCallCommand = cli.Command{
+	Name:      "call",
+	Usage:     "send a JSON encoded request to the agent of a sandbox",
+	ArgsUsage: `call <sandbox-id> <rpc> [file or - for stdin]`,
+	Flags:     []cli.Flag{agentYamuxFlag},
+	Action: func(context *cli.Context) error {
+		ctx, err := cliContextToContext(context)
+		if err != nil {
+			return err
+		}
+
+		args := context.Args()
+		return agentCall(ctx, args.First(), args.Get(1), args.Get(2), context.Bool("yamux"))
+	},
+}
+
+func agentDial(ctx context.Context, sandboxID string, yamux bool) (*agentctl.Conn, error) {
+	url, err := agentctl.AgentURL(ctx, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	kataLog = kataLog.WithFields(logrus.Fields{
+		"sandbox": sandboxID,
+		"agent":   url,
+	})
+	setExternalLoggers(ctx, kataLog)
+
+	return agentctl.Dial(ctx, url, yamux)
+}
+
+func agentListRPCs(ctx context.Context, sandboxID string, yamux bool) error {
+	conn, err := agentDial(ctx, sandboxID, yamux)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, name := range conn.RPCs() {
+		fmt.Fprintln(defaultOutputFile, name)
+	}
+
+	return nil
+}
+
+func agentCall(ctx context.Context, sandboxID, rpc, input string, yamux bool) error {
+	if rpc == "" {
+		return fmt.Errorf("Missing agent RPC name")
+	}
+
+	var (
+		req []byte
+		err error
+	)
+
+	switch input {
+	case "":
+	case "-":
+		req, err = ioutil.ReadAll(os.Stdin)
+	default:
+		req, err = ioutil.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := agentDial(ctx, sandboxID, yamux)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	out, err := conn.Call(ctx, rpc, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(defaultOutputFile, string(out))
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentCallMissingArgs(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	err := agentCall(ctx, testSandboxID, "", "", false)
+	assert.Error(err)
+
+	err = agentCall(ctx, "", "Check", "", false)
+	assert.Error(err)
+
+	err = agentListRPCs(ctx, "", false)
+	assert.Error(err)
+}
+
+func TestAgentCallInvalidInput(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	err = agentCall(ctx, testSandboxID, "Check", filepath.Join(tmpdir, "request.json"), false)
+	assert.Error(err)
+}
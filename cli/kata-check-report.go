@@ -0,0 +1,339 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kata-containers/runtime/pkg/katautils"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/qmp"
+)
+
+// variables rather than consts to allow tests to modify them
+var (
+	sysHugePagesDir = "/sys/kernel/mm/hugepages"
+
+	// kvmModules are the modules whose nested parameter tells whether
+	// nested virtualization is enabled, depending on the architecture.
+	kvmModules = []string{"kvm_intel", "kvm_amd", "kvm_hv", "kvm"}
+
+	// vhostModules are the modules of the vhost devices the hypervisors
+	// may use.
+	vhostModules = []string{"vhost", "vhost_net", "vhost_vsock", "vhost_scsi"}
+
+	// qemuProbeTimeout bounds each probe of the QEMU binary.
+	qemuProbeTimeout = 10 * time.Second
+)
+
+// HostReport is the machine-readable host compatibility report printed by
+// kata-check --json, e.g. for node feature discovery to label the nodes.
+type HostReport struct {
+	Arch           string
+	HypervisorType string
+
+	// Capable is set if the host can run Kata Containers, Error is the
+	// reason why it cannot otherwise.
+	Capable bool
+	Error   string `json:",omitempty"`
+
+	KVM           KVMReport
+	KernelModules map[string]bool
+	HugePages     []HugePagesReport
+	Hypervisor    HypervisorReport
+	Virtiofsd     *BinaryReport `json:",omitempty"`
+}
+
+// KVMReport describes the KVM support of the host.
+type KVMReport struct {
+	Device string
+
+	// Available is set if the KVM device exists, Usable if a VM could be
+	// created, which is only tried as root.
+	Available bool
+	Usable    bool
+	Error     string `json:",omitempty"`
+
+	// Nested is set if the KVM module allows nested virtualization.
+	Nested       bool
+	NestedModule string `json:",omitempty"`
+}
+
+// HugePagesReport describes the huge pages of a size.
+type HugePagesReport struct {
+	SizeKB uint64
+	Total  uint64
+	Free   uint64
+}
+
+// BinaryReport describes a binary used by the runtime.
+type BinaryReport struct {
+	Path    string
+	Version string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// HypervisorReport describes the hypervisor binary and, for QEMU, the
+// machine types and QMP commands it supports.
+type HypervisorReport struct {
+	BinaryReport
+
+	Machines    []string `json:",omitempty"`
+	QMPCommands []string `json:",omitempty"`
+}
+
+// getHostReport probes the host the way the runtime configuration uses it.
+func getHostReport(runtimeConfig oci.RuntimeConfig, details vmContainerCapableDetails) HostReport {
+	report := HostReport{
+		Arch:           goruntime.GOARCH,
+		HypervisorType: string(runtimeConfig.HypervisorType),
+		KVM:            getKVMReport(),
+		KernelModules:  make(map[string]bool),
+		HugePages:      getHugePagesReport(sysHugePagesDir),
+	}
+
+	if err := hostIsVMContainerCapable(details); err != nil {
+		report.Error = err.Error()
+	} else {
+		report.Capable = true
+	}
+
+	for module := range details.requiredKernelModules {
+		report.KernelModules[module] = katautils.FileExists(filepath.Join(sysModuleDir, module))
+	}
+	for _, module := range vhostModules {
+		report.KernelModules[module] = katautils.FileExists(filepath.Join(sysModuleDir, module))
+	}
+
+	hypervisorPath := runtimeConfig.HypervisorConfig.HypervisorPath
+	report.Hypervisor.BinaryReport = getBinaryReport(hypervisorPath)
+	if runtimeConfig.HypervisorType == vc.QemuHypervisor && report.Hypervisor.Error == "" {
+		machines, err := getQemuMachines(hypervisorPath)
+		if err != nil {
+			kataLog.WithError(err).Warn("Could not list the QEMU machine types")
+		}
+		report.Hypervisor.Machines = machines
+
+		commands, err := getQemuQMPCommands(hypervisorPath)
+		if err != nil {
+			kataLog.WithError(err).Warn("Could not query the QEMU QMP schema")
+		}
+		report.Hypervisor.QMPCommands = commands
+	}
+
+	if runtimeConfig.HypervisorConfig.SharedFS == config.VirtioFS && runtimeConfig.HypervisorConfig.VirtioFSDaemon != "" {
+		virtiofsd := getBinaryReport(runtimeConfig.HypervisorConfig.VirtioFSDaemon)
+		report.Virtiofsd = &virtiofsd
+	}
+
+	return report
+}
+
+func getKVMReport() KVMReport {
+	report := KVMReport{
+		Device:    kvmDevice,
+		Available: katautils.FileExists(kvmDevice),
+	}
+
+	if report.Available && os.Geteuid() == 0 {
+		if err := kvmIsUsable(); err != nil {
+			report.Error = err.Error()
+		} else {
+			report.Usable = true
+		}
+	}
+
+	for _, module := range kvmModules {
+		value, err := katautils.GetFileContents(filepath.Join(sysModuleDir, module, moduleParamDir, "nested"))
+		if err != nil {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		report.Nested = value == "Y" || value == "1"
+		report.NestedModule = module
+		break
+	}
+
+	return report
+}
+
+// getHugePagesReport returns the huge pages of each size from the sysfs
+// directory dir.
+func getHugePagesReport(dir string) []HugePagesReport {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var reports []HugePagesReport
+	for _, entry := range entries {
+		// hugepages-2048kB
+		size := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "hugepages-"), "kB")
+		sizeKB, err := strconv.ParseUint(size, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		report := HugePagesReport{SizeKB: sizeKB}
+		for file, value := range map[string]*uint64{
+			"nr_hugepages":   &report.Total,
+			"free_hugepages": &report.Free,
+		} {
+			content, err := katautils.GetFileContents(filepath.Join(dir, entry.Name(), file))
+			if err != nil {
+				continue
+			}
+			*value, _ = strconv.ParseUint(strings.TrimSpace(content), 10, 64)
+		}
+
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].SizeKB < reports[j].SizeKB
+	})
+
+	return reports
+}
+
+func getBinaryReport(path string) BinaryReport {
+	report := BinaryReport{Path: path}
+
+	if !katautils.FileExists(path) {
+		report.Error = fmt.Sprintf("%s does not exist", path)
+		return report
+	}
+
+	version, err := getCommandVersion(path)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	// The first line, e.g. "QEMU emulator version 4.1.0"
+	report.Version = strings.TrimSpace(strings.SplitN(version, "\n", 2)[0])
+
+	return report
+}
+
+// getQemuMachines returns the machine types supported by QEMU.
+func getQemuMachines(qemuPath string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qemuProbeTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, qemuPath, "-M", "help").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseQemuMachines(string(out)), nil
+}
+
+// parseQemuMachines parses the output of qemu -M help:
+//
+//   Supported machines are:
+//   pc                   Standard PC (alias of pc-i440fx-4.1)
+//   q35                  Standard PC (Q35 + ICH9, 2009) (alias of pc-q35-4.1)
+//
+func parseQemuMachines(out string) []string {
+	var machines []string
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasSuffix(line, ":") {
+			continue
+		}
+
+		machines = append(machines, fields[0])
+	}
+
+	sort.Strings(machines)
+
+	return machines
+}
+
+// getQemuQMPCommands returns the QMP commands supported by QEMU, started
+// without any machine to query its QMP schema.
+func getQemuQMPCommands(qemuPath string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), qemuProbeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, qemuPath, "-machine", "none", "-nodefaults", "-nographic", "-S", "-qmp", "stdio")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	return queryQMPCommands(stdin, stdout)
+}
+
+// queryQMPCommands negotiates the QMP capabilities and lists the commands
+// of the QMP schema, before quitting.
+func queryQMPCommands(w io.Writer, r io.Reader) ([]string, error) {
+	ctx := context.Background()
+
+	client, err := qmp.New(ctx, w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Name     string `json:"name"`
+		MetaType string `json:"meta-type"`
+	}
+	if err := client.Execute(ctx, "query-qmp-schema", nil, &entries); err != nil {
+		return nil, err
+	}
+
+	var commands []string
+	for _, e := range entries {
+		if e.MetaType == "command" {
+			commands = append(commands, e.Name)
+		}
+	}
+	sort.Strings(commands)
+
+	// QEMU exits on quit or once its input is closed.
+	client.Execute(ctx, "quit", nil, nil)
+
+	return commands, nil
+}
+
+func writeHostReport(report HostReport, file *os.File) error {
+	encoder := json.NewEncoder(file)
+
+	// Make it more human readable
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
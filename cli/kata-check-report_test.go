@@ -0,0 +1,118 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQemuMachines(t *testing.T) {
+	assert := assert.New(t)
+
+	out := `Supported machines are:
+q35                  Standard PC (Q35 + ICH9, 2009) (alias of pc-q35-4.1)
+pc                   Standard PC (i440FX + PIIX, 1996) (alias of pc-i440fx-4.1)
+none                 empty machine
+`
+	assert.Equal([]string{"none", "pc", "q35"}, parseQemuMachines(out))
+	assert.Empty(parseQemuMachines(""))
+}
+
+func TestGetHugePagesReport(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hugepages")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for name, counts := range map[string][2]string{
+		"hugepages-1048576kB": {"0\n", "0\n"},
+		"hugepages-2048kB":    {"512\n", "128\n"},
+	} {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, name), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name, "nr_hugepages"), []byte(counts[0]), 0644))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name, "free_hugepages"), []byte(counts[1]), 0644))
+	}
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "not-hugepages"), 0755))
+
+	assert.Equal([]HugePagesReport{
+		{SizeKB: 2048, Total: 512, Free: 128},
+		{SizeKB: 1048576},
+	}, getHugePagesReport(dir))
+
+	assert.Empty(getHugePagesReport(filepath.Join(dir, "missing")))
+}
+
+// fakeQMP answers the QMP commands like QEMU does.
+func fakeQMP(in io.Reader, out io.Writer) {
+	out.Write([]byte(`{"QMP": {"version": {"qemu": {"major": 4}}, "capabilities": []}}` + "\n"))
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var cmd struct {
+			Execute string
+		}
+		json.Unmarshal(scanner.Bytes(), &cmd)
+
+		switch cmd.Execute {
+		case "qmp_capabilities":
+			out.Write([]byte(`{"return": {}}` + "\n"))
+		case "query-qmp-schema":
+			out.Write([]byte(`{"timestamp": {"seconds": 1}, "event": "RESUME"}` + "\n"))
+			out.Write([]byte(`{"return": [{"name": "query-status", "meta-type": "command"}, {"name": "1", "meta-type": "object"}, {"name": "device_add", "meta-type": "command"}]}` + "\n"))
+		case "quit":
+			out.Write([]byte(`{"return": {}}` + "\n"))
+			return
+		default:
+			out.Write([]byte(`{"error": {"class": "CommandNotFound", "desc": "unknown"}}` + "\n"))
+		}
+	}
+}
+
+func TestQueryQMPCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	// Buffered like the standard input and output of QEMU.
+	inR, inW, err := os.Pipe()
+	assert.NoError(err)
+	outR, outW, err := os.Pipe()
+	assert.NoError(err)
+	defer outR.Close()
+
+	go func() {
+		defer inR.Close()
+		defer outW.Close()
+		fakeQMP(inR, outW)
+	}()
+
+	commands, err := queryQMPCommands(inW, outR)
+	assert.NoError(err)
+	assert.Equal([]string{"device_add", "query-status"}, commands)
+	inW.Close()
+
+	// QEMU exiting early
+	inR, inW, err = os.Pipe()
+	assert.NoError(err)
+	defer inR.Close()
+	defer inW.Close()
+	outR, outW, err = os.Pipe()
+	assert.NoError(err)
+	defer outR.Close()
+
+	outW.Write([]byte(`{"QMP": {}}` + "\n"))
+	outW.Close()
+
+	_, err = queryQMPCommands(inW, outR)
+	assert.Error(err)
+}
@@ -309,6 +309,10 @@ var kataCheckCLICommand = cli.Command{
 			Name:  "verbose, v",
 			Usage: "display the list of checks performed",
 		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the host compatibility report in JSON format",
+		},
 	},
 
 	Action: func(context *cli.Context) error {
@@ -341,6 +345,12 @@ var kataCheckCLICommand = cli.Command{
 			requiredKernelModules: archRequiredKernelModules,
 		}
 
+		// The report tells why the host is not capable rather than
+		// failing.
+		if context.Bool("json") {
+			return writeHostReport(getHostReport(runtimeConfig, details), defaultOutputFile)
+		}
+
 		err = hostIsVMContainerCapable(details)
 
 		if err != nil {
@@ -0,0 +1,85 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/agentctl"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// kataExecDialTimeout bounds the connection to the debug console.
+const kataExecDialTimeout = 5 * time.Second
+
+var kataExecCLICommand = cli.Command{
+	Name:      "kata-exec",
+	Usage:     "open a shell on the debug console of the guest of a sandbox (debug only)",
+	ArgsUsage: `<sandbox-id>`,
+	Description: `The agent of the sandbox must start the debug console, given the
+   agent.debug_console kernel parameter. The console is reached over vsock when
+   agent.debug_console_vport is given as well, and over the guest console
+   otherwise. Exit the shell to end the session.`,
+	Action: func(context *cli.Context) error {
+		ctx, err := cliContextToContext(context)
+		if err != nil {
+			return err
+		}
+
+		return kataExec(ctx, context.Args().First())
+	},
+}
+
+func kataExec(ctx context.Context, sandboxID string) error {
+	url, err := agentctl.ConsoleURL(ctx, sandboxID)
+	if err != nil {
+		return err
+	}
+
+	kataLog = kataLog.WithFields(logrus.Fields{
+		"sandbox": sandboxID,
+		"console": url,
+	})
+	setExternalLoggers(ctx, kataLog)
+
+	conn, err := agentctl.DialConsole(url, kataExecDialTimeout)
+	if err != nil {
+		return fmt.Errorf("Could not connect to the debug console %s, is the agent started with agent.debug_console? %v", url, err)
+	}
+	defer conn.Close()
+
+	// The keys, Ctrl-C included, are for the shell of the guest.
+	if isTerminal(os.Stdin.Fd()) {
+		restore, err := setRawTerminal(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	return relayConsole(conn, os.Stdin, defaultOutputFile)
+}
+
+// relayConsole copies in to the console and the console to out, until the
+// console is closed.
+func relayConsole(console io.ReadWriter, in io.Reader, out io.Writer) error {
+	go func() {
+		io.Copy(console, in)
+
+		// Let the guest see the end of a piped input.
+		if c, ok := console.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	_, err := io.Copy(out, console)
+	return err
+}
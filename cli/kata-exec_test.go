@@ -0,0 +1,49 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKataExecMissingSandboxID(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error(kataExec(context.Background(), ""))
+}
+
+func TestRelayConsole(t *testing.T) {
+	assert := assert.New(t)
+
+	console, guest := net.Pipe()
+
+	go func() {
+		defer guest.Close()
+
+		cmd := make([]byte, len("uname\n"))
+		if _, err := guest.Read(cmd); err != nil {
+			return
+		}
+		guest.Write([]byte("Linux\n"))
+	}()
+
+	var out bytes.Buffer
+	assert.NoError(relayConsole(console, strings.NewReader("uname\n"), &out))
+	assert.Equal("Linux\n", out.String())
+
+	// Nothing is relayed from a closed console
+	console, guest = net.Pipe()
+	guest.Close()
+	out.Reset()
+	assert.NoError(relayConsole(console, strings.NewReader(""), &out))
+	assert.Empty(out.String())
+}
@@ -69,6 +69,9 @@ type fullContainerState struct {
 	CurrentHypervisorDetails hypervisorDetails `json:"currentHypervisor"`
 	LatestHypervisorDetails  hypervisorDetails `json:"latestHypervisor"`
 	StaleAssets              []string
+	// ShutdownReason is the reason why the sandbox of the container
+	// was stopped, if any.
+	ShutdownReason string `json:"shutdownReason,omitempty"`
 }
 
 type formatState interface {
@@ -225,7 +228,7 @@ func (f formatTabular) Write(state []fullContainerState, showAll bool, file *os.
 	fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER")
 
 	if showAll {
-		fmt.Fprint(w, "\tHYPERVISOR\tKERNEL\tIMAGE\tLATEST-KERNEL\tLATEST-IMAGE\tSTALE\n")
+		fmt.Fprint(w, "\tHYPERVISOR\tKERNEL\tIMAGE\tLATEST-KERNEL\tLATEST-IMAGE\tSTALE\tSHUTDOWN-REASON\n")
 	} else {
 		fmt.Fprintf(w, "\n")
 	}
@@ -265,7 +268,12 @@ func (f formatTabular) Write(state []fullContainerState, showAll bool, file *os.
 				all += fmt.Sprintf("\t%s", current.ImageAsset.Path)
 			}
 
-			all += fmt.Sprintf("\t%s\n", stale)
+			shutdownReason := item.ShutdownReason
+			if shutdownReason == "" {
+				shutdownReason = "-"
+			}
+
+			all += fmt.Sprintf("\t%s\t%s\n", stale, shutdownReason)
 
 			fmt.Fprint(w, all)
 		} else {
@@ -352,6 +360,7 @@ func getContainers(ctx context.Context, context *cli.Context) ([]fullContainerSt
 				CurrentHypervisorDetails: currentHypervisorDetails,
 				LatestHypervisorDetails:  latestHypervisorDetails,
 				StaleAssets:              staleAssets,
+				ShutdownReason:           string(sandbox.State.ShutdownReason),
 			})
 		}
 	}
@@ -133,7 +133,10 @@ var runtimeCommands = []cli.Command{
 	kataCheckCLICommand,
 	kataEnvCLICommand,
 	kataNetworkCLICommand,
+	kataAgentCtlCLICommand,
+	kataExecCLICommand,
 	factoryCLICommand,
+	migrateCLICommand,
 }
 
 // runtimeBeforeSubcommands is the function to run before command-line
@@ -251,6 +254,7 @@ func beforeSubcommands(c *cli.Context) error {
 	var err error
 
 	katautils.SetConfigOptions(name, defaultRuntimeConfiguration, defaultSysConfRuntimeConfiguration)
+	vc.SetRuntimeVersion(fmt.Sprintf("%s (commit %s)", version, commit))
 
 	handleShowConfig(c)
 
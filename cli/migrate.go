@@ -0,0 +1,302 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kata-containers/runtime/pkg/katautils"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var migrateSubCmds = []cli.Command{
+	prepareMigrateCommand,
+	sendMigrateCommand,
+	receiveMigrateCommand,
+}
+
+var migrateCLICommand = cli.Command{
+	Name:  "migrate",
+	Usage: "live migrate a sandbox between hosts",
+	Description: `The migrate command live migrates the VM of a sandbox to another host.
+
+   The source host prepares the migration first, writing the sandbox
+   configuration the target host needs. Once the configuration has been
+   copied to it, the sandbox is received on the target host, which waits for
+   the VM state on the migration URI, and then sent from the source host,
+   which checks the sandbox configuration did not change since.
+
+   The tcp migration streams are authenticated and encrypted with TLS: both
+   hosts verify the x509 certificate of their peer, from the credentials of
+   the --tls-dir directory.
+
+   The containers storage has to be shared between both hosts, at the same
+   paths, and the sandbox must not have hotplugged resources, nor use
+   virtio-fs or a vsock agent. The container processes IO and the shims are
+   not migrated. Only QEMU supports it.
+
+   The migration is experimental, the "migration" experimental feature has
+   to be enabled in the runtime configuration of both hosts.`,
+	Subcommands: migrateSubCmds,
+	Action: func(context *cli.Context) {
+		cli.ShowSubcommandHelp(context)
+	},
+}
+
+var migrationFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "uri",
+		Usage: `the migration stream URI, "tcp:<host>:<port>" or "unix:<path>"`,
+	},
+	cli.StringFlag{
+		Name:  "tls-dir",
+		Usage: "the directory of the x509 credentials of the migration stream, required by the tcp URIs",
+	},
+	cli.UintFlag{
+		Name:  "multifd",
+		Usage: "the number of parallel channels to stream the VM memory through",
+	},
+	cli.BoolFlag{
+		Name:  "compress",
+		Usage: "compress the migration stream",
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "the time given to the migration to complete",
+	},
+	sandboxConfigFlag,
+}
+
+var sandboxConfigFlag = cli.StringFlag{
+	Name:  "sandbox-config",
+	Usage: "path to the sandbox configuration file written by the source host",
+}
+
+var prepareMigrateCommand = cli.Command{
+	Name:  "prepare",
+	Usage: "write the sandbox configuration the target host needs",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name of a container of the sandbox to migrate.`,
+	Flags: []cli.Flag{sandboxConfigFlag},
+	Action: func(c *cli.Context) error {
+		ctx, err := cliContextToContext(c)
+		if err != nil {
+			return err
+		}
+
+		if c.String("sandbox-config") == "" {
+			return errors.New("Missing sandbox configuration file")
+		}
+
+		return prepareMigration(ctx, c.Args().First(), c.String("sandbox-config"))
+	},
+}
+
+var sendMigrateCommand = cli.Command{
+	Name:  "send",
+	Usage: "migrate the sandbox of a container to the target host",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name of a container of the sandbox to migrate.`,
+	Flags: migrationFlags,
+	Action: func(c *cli.Context) error {
+		ctx, err := cliContextToContext(c)
+		if err != nil {
+			return err
+		}
+
+		config, err := migrationConfig(c)
+		if err != nil {
+			return err
+		}
+
+		return sendSandbox(ctx, c.Args().First(), config, c.String("sandbox-config"))
+	},
+}
+
+var receiveMigrateCommand = cli.Command{
+	Name:  "receive",
+	Usage: "receive a sandbox migrated from the source host",
+	Flags: append(migrationFlags, cli.StringFlag{
+		Name:  "netns",
+		Usage: "path to the network namespace of the sandbox on this host",
+	}),
+	Action: func(c *cli.Context) error {
+		ctx, err := cliContextToContext(c)
+		if err != nil {
+			return err
+		}
+
+		runtimeConfig, ok := c.App.Metadata["runtimeConfig"].(oci.RuntimeConfig)
+		if !ok {
+			return errors.New("invalid runtime config")
+		}
+
+		// The sandbox configuration given by the source host enables
+		// the migration there, it has to be enabled on this host too.
+		if err := checkMigrationEnabled(runtimeConfig); err != nil {
+			return err
+		}
+
+		config, err := migrationConfig(c)
+		if err != nil {
+			return err
+		}
+
+		return receiveSandbox(ctx, config, c.String("sandbox-config"), c.String("netns"))
+	},
+}
+
+func migrationConfig(c *cli.Context) (vc.MigrationConfig, error) {
+	if c.String("uri") == "" {
+		return vc.MigrationConfig{}, errors.New("Missing migration URI")
+	}
+
+	if c.String("sandbox-config") == "" {
+		return vc.MigrationConfig{}, errors.New("Missing sandbox configuration file")
+	}
+
+	return vc.MigrationConfig{
+		URI:             c.String("uri"),
+		TLSDir:          c.String("tls-dir"),
+		MultifdChannels: uint32(c.Uint("multifd")),
+		Compress:        c.Bool("compress"),
+		Timeout:         c.Duration("timeout"),
+	}, nil
+}
+
+// checkMigrationEnabled returns an error if the migration experimental
+// feature is not enabled by runtimeConfig.
+func checkMigrationEnabled(runtimeConfig oci.RuntimeConfig) error {
+	for _, f := range runtimeConfig.Experimental {
+		if f == vc.MigrationFeature {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Live migration requires the %q experimental feature", vc.MigrationFeature.Name)
+}
+
+// migratedSandboxID returns the sandbox of the container to migrate.
+func migratedSandboxID(ctx context.Context, span opentracing.Span, containerID string) (string, error) {
+	kataLog = kataLog.WithField("container", containerID)
+	setExternalLoggers(ctx, kataLog)
+	span.SetTag("container", containerID)
+
+	status, sandboxID, err := getExistingContainerInfo(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+
+	containerID = status.ID
+
+	kataLog = kataLog.WithFields(logrus.Fields{
+		"container": containerID,
+		"sandbox":   sandboxID,
+	})
+
+	setExternalLoggers(ctx, kataLog)
+	span.SetTag("container", containerID)
+	span.SetTag("sandbox", sandboxID)
+
+	return sandboxID, nil
+}
+
+func prepareMigration(ctx context.Context, containerID, sandboxConfigPath string) error {
+	span, _ := katautils.Trace(ctx, "prepare-migration")
+	defer span.Finish()
+
+	sandboxID, err := migratedSandboxID(ctx, span, containerID)
+	if err != nil {
+		return err
+	}
+
+	sandboxConfig, err := vci.PrepareMigration(ctx, sandboxID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(sandboxConfig)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(sandboxConfigPath, data, 0600); err != nil {
+		return fmt.Errorf("Could not write the sandbox configuration: %v", err)
+	}
+
+	return nil
+}
+
+func sendSandbox(ctx context.Context, containerID string, config vc.MigrationConfig, sandboxConfigPath string) error {
+	span, _ := katautils.Trace(ctx, "migrate")
+	defer span.Finish()
+
+	sandboxID, err := migratedSandboxID(ctx, span, containerID)
+	if err != nil {
+		return err
+	}
+
+	// The configuration given to the target host must still be the one of
+	// the sandbox.
+	data, err := ioutil.ReadFile(sandboxConfigPath)
+	if err != nil {
+		return err
+	}
+	config.SandboxConfigDigest = vc.SandboxConfigDigest(data)
+
+	return vci.MigrateSandbox(ctx, sandboxID, config)
+}
+
+func receiveSandbox(ctx context.Context, config vc.MigrationConfig, sandboxConfigPath, netNSPath string) error {
+	span, _ := katautils.Trace(ctx, "receive")
+	defer span.Finish()
+
+	data, err := ioutil.ReadFile(sandboxConfigPath)
+	if err != nil {
+		return err
+	}
+
+	var sandboxConfig vc.SandboxConfig
+	if err := json.Unmarshal(data, &sandboxConfig); err != nil {
+		return fmt.Errorf("Could not parse the sandbox configuration %s: %v", sandboxConfigPath, err)
+	}
+
+	// The network namespace of the source host does not exist here.
+	if netNSPath != "" {
+		if _, err := os.Stat(netNSPath); err != nil {
+			return err
+		}
+		sandboxConfig.NetworkConfig.NetNSPath = netNSPath
+	}
+
+	kataLog = kataLog.WithField("sandbox", sandboxConfig.ID)
+	setExternalLoggers(ctx, kataLog)
+	span.SetTag("sandbox", sandboxConfig.ID)
+
+	sandbox, err := vci.ReceiveSandbox(ctx, sandboxConfig, config)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range sandbox.GetAllContainers() {
+		if err := katautils.AddContainerIDMapping(ctx, c.ID(), sandbox.ID()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kata-containers/runtime/pkg/katautils"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+const (
+	testMigrationURI    = "tcp:192.168.0.2:4444"
+	testMigrationTLSDir = "/etc/pki/qemu"
+)
+
+func newMigrationFlagSet(uri, sandboxConfigPath string, args ...string) *flag.FlagSet {
+	set := flag.NewFlagSet("", 0)
+	set.String("uri", uri, "")
+	set.String("tls-dir", testMigrationTLSDir, "")
+	set.Uint("multifd", 4, "")
+	set.Bool("compress", true, "")
+	set.Duration("timeout", time.Minute, "")
+	set.String("sandbox-config", sandboxConfigPath, "")
+	set.String("netns", "", "")
+	set.Parse(args)
+
+	return set
+}
+
+func TestMigratePrepareCLIFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	sandboxConfigPath := filepath.Join(tmpdir, "sandbox.json")
+
+	path, err := createTempContainerIDMapping(testContainerID, testSandboxID)
+	assert.NoError(err)
+	defer os.RemoveAll(path)
+
+	testingImpl.StatusContainerFunc = func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStatus, error) {
+		return newSingleContainerStatus(testContainerID, types.ContainerState{State: types.StateRunning}, map[string]string{}, &specs.Spec{}), nil
+	}
+
+	testingImpl.PrepareMigrationFunc = func(ctx context.Context, sandboxID string) (*vc.SandboxConfig, error) {
+		return &vc.SandboxConfig{
+			ID:         sandboxID,
+			Containers: []vc.ContainerConfig{{ID: testContainerID}},
+		}, nil
+	}
+
+	defer func() {
+		testingImpl.StatusContainerFunc = nil
+		testingImpl.PrepareMigrationFunc = nil
+	}()
+
+	// Missing sandbox configuration file
+	execCLICommandFunc(assert, prepareMigrateCommand, newMigrationFlagSet(testMigrationURI, "", testContainerID), true)
+
+	execCLICommandFunc(assert, prepareMigrateCommand, newMigrationFlagSet(testMigrationURI, sandboxConfigPath, testContainerID), false)
+
+	data, err := ioutil.ReadFile(sandboxConfigPath)
+	assert.NoError(err)
+
+	var sandboxConfig vc.SandboxConfig
+	assert.NoError(json.Unmarshal(data, &sandboxConfig))
+	assert.Equal(testSandboxID, sandboxConfig.ID)
+	assert.Len(sandboxConfig.Containers, 1)
+}
+
+func TestMigrateSendCLIFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	sandboxConfigPath := filepath.Join(tmpdir, "sandbox.json")
+
+	path, err := createTempContainerIDMapping(testContainerID, testSandboxID)
+	assert.NoError(err)
+	defer os.RemoveAll(path)
+
+	testingImpl.StatusContainerFunc = func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStatus, error) {
+		return newSingleContainerStatus(testContainerID, types.ContainerState{State: types.StateRunning}, map[string]string{}, &specs.Spec{}), nil
+	}
+
+	var migrationConfig vc.MigrationConfig
+	testingImpl.MigrateSandboxFunc = func(ctx context.Context, sandboxID string, config vc.MigrationConfig) error {
+		migrationConfig = config
+		return nil
+	}
+
+	defer func() {
+		testingImpl.StatusContainerFunc = nil
+		testingImpl.MigrateSandboxFunc = nil
+	}()
+
+	// Missing URI
+	execCLICommandFunc(assert, sendMigrateCommand, newMigrationFlagSet("", sandboxConfigPath, testContainerID), true)
+
+	// Missing prepared sandbox configuration
+	execCLICommandFunc(assert, sendMigrateCommand, newMigrationFlagSet(testMigrationURI, sandboxConfigPath, testContainerID), true)
+
+	data := []byte(`{"ID":"` + testSandboxID + `"}`)
+	assert.NoError(ioutil.WriteFile(sandboxConfigPath, data, 0600))
+
+	execCLICommandFunc(assert, sendMigrateCommand, newMigrationFlagSet(testMigrationURI, sandboxConfigPath, testContainerID), false)
+	assert.Equal(vc.MigrationConfig{
+		URI:                 testMigrationURI,
+		TLSDir:              testMigrationTLSDir,
+		MultifdChannels:     4,
+		Compress:            true,
+		Timeout:             time.Minute,
+		SandboxConfigDigest: vc.SandboxConfigDigest(data),
+	}, migrationConfig)
+}
+
+func TestMigrateReceiveCLIFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	path, err := ioutil.TempDir("", "containers-mapping")
+	assert.NoError(err)
+	defer os.RemoveAll(path)
+	ctrsMapTreePath = path
+	katautils.SetCtrsMapTreePath(ctrsMapTreePath)
+
+	sandboxConfigPath := filepath.Join(tmpdir, "sandbox.json")
+
+	runtimeConfig, err := newTestRuntimeConfig(tmpdir, testConsole, true)
+	assert.NoError(err)
+
+	execReceive := func(expectedErr bool) {
+		ctx := createCLIContext(newMigrationFlagSet(testMigrationURI, sandboxConfigPath))
+		ctx.App.Name = "foo"
+		ctx.App.Metadata["runtimeConfig"] = runtimeConfig
+
+		fn, ok := receiveMigrateCommand.Action.(func(context *cli.Context) error)
+		assert.True(ok)
+
+		err := fn(ctx)
+		if expectedErr {
+			assert.Error(err)
+		} else {
+			assert.NoError(err)
+		}
+	}
+
+	// Migration not enabled on this host
+	execReceive(true)
+
+	runtimeConfig.Experimental = []exp.Feature{vc.MigrationFeature}
+
+	// Missing sandbox configuration file
+	execReceive(true)
+
+	data, err := json.Marshal(vc.SandboxConfig{
+		ID:         testSandboxID,
+		Containers: []vc.ContainerConfig{{ID: testContainerID}},
+	})
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(sandboxConfigPath, data, 0600))
+
+	testingImpl.ReceiveSandboxFunc = func(ctx context.Context, sandboxConfig vc.SandboxConfig, config vc.MigrationConfig) (vc.VCSandbox, error) {
+		return &vcmock.Sandbox{
+			MockID:         sandboxConfig.ID,
+			MockContainers: []*vcmock.Container{{MockID: sandboxConfig.Containers[0].ID}},
+		}, nil
+	}
+
+	defer func() {
+		testingImpl.ReceiveSandboxFunc = nil
+	}()
+
+	execReceive(false)
+
+	sandboxID, err := katautils.FetchContainerIDMapping(testContainerID)
+	assert.NoError(err)
+	assert.Equal(testSandboxID, sandboxID)
+}
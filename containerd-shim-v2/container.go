@@ -33,6 +33,13 @@ type container struct {
 	exit     uint32
 	status   task.Status
 	terminal bool
+
+	// resizer coalesces the resizes of the pty of the init process.
+	resizer *ptyResizer
+
+	// recovered is set if the container was created by a previous shim
+	// process.
+	recovered bool
 }
 
 func newContainer(s *service, r *taskAPI.CreateTaskRequest, containerType vc.ContainerType, spec *specs.Spec) (*container, error) {
@@ -10,11 +10,13 @@ package containerdshim
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	containerd_types "github.com/containerd/containerd/api/types"
 	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/containerd/typeurl"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -26,6 +28,7 @@ import (
 
 	"github.com/kata-containers/runtime/pkg/katautils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 )
@@ -59,7 +62,7 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest) (*con
 			return nil, fmt.Errorf("cannot create another sandbox in sandbox: %s", s.sandbox.ID())
 		}
 
-		_, err := loadRuntimeConfig(s, r)
+		_, err := loadRuntimeConfig(s, r, ociSpec)
 		if err != nil {
 			return nil, err
 		}
@@ -73,7 +76,7 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest) (*con
 		}()
 
 		s.mount = true
-		if err = checkAndMount(s, r); err != nil {
+		if err = checkAndMount(s, r, ociSpec); err != nil {
 			return nil, err
 		}
 
@@ -85,18 +88,37 @@ func create(ctx context.Context, s *service, r *taskAPI.CreateTaskRequest) (*con
 		// ctx will be canceled after this rpc service call, but the sandbox will live
 		// across multiple rpc service calls.
 		//
-		sandbox, _, err := katautils.CreateSandbox(s.ctx, vci, *ociSpec, *s.config, rootFs, r.ID, bundlePath, "", disableOutput, false, true)
+		sandbox, _, err := katautils.CreateSandbox(sandboxContext(s, ctx), vci, *ociSpec, *s.config, rootFs, r.ID, bundlePath, "", disableOutput, false, true)
 		if err != nil {
 			return nil, err
 		}
 		s.sandbox = sandbox
+		s.sandbox.SetOperationLock(&s.mu)
+		shimSandboxes.Set(1)
+
+		// The task API is blocked during long hotplug operations, let
+		// the management layers follow them from the introspection
+		// socket.
+		if introspection, err := startIntrospection(sandbox, &s.mu, debugIntrospection(s), metricsIntrospection(s)); err != nil {
+			logrus.WithError(err).Warn("failed to start the introspection server")
+		} else {
+			s.introspection = introspection
+		}
 
 	case vc.PodContainer:
 		if s.sandbox == nil {
 			return nil, fmt.Errorf("BUG: Cannot start the container, since the sandbox hasn't been created")
 		}
 
-		if s.mount {
+		if err = oci.CheckAnnotations(*ociSpec, s.config.HypervisorConfig); err != nil {
+			return nil, err
+		}
+
+		// The rootfs layered on a read-only image or encrypted is not
+		// mounted on the host.
+		if isLayeredRootfs(s, r) || isEncryptedRootfs(ociSpec) {
+			rootFs.Mounted = false
+		} else if s.mount {
 			defer func() {
 				if err != nil {
 					if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
@@ -147,41 +169,120 @@ func loadSpec(r *taskAPI.CreateTaskRequest) (*specs.Spec, string, error) {
 	return &ociSpec, bundlePath, nil
 }
 
-func loadRuntimeConfig(s *service, r *taskAPI.CreateTaskRequest) (*oci.RuntimeConfig, error) {
-	var configPath string
+// Sources of the runtime configuration of a sandbox, from the highest to the
+// lowest precedence.
+const (
+	// configSourceOptions is the configuration file set in the runtime
+	// options of the CRI runtime handler.
+	configSourceOptions = "runtime-options"
+
+	// configSourceNamespace is the configuration file specific to the
+	// containerd namespace of the sandbox.
+	configSourceNamespace = "namespace"
+
+	// configSourceEnv is the configuration file set by KATA_CONF_FILE.
+	configSourceEnv = "env"
+
+	// configSourceDefault is the default configuration file.
+	configSourceDefault = "default"
+)
+
+// configSnapshotFile is the copy of the runtime configuration file the
+// sandbox is created with, in its bundle.
+const configSnapshotFile = "kata-configuration.toml"
+
+// saveRuntimeConfigSnapshot writes the content of the runtime configuration
+// file the sandbox is created with to its bundle, for the sandbox to be
+// recovered with that configuration, even once the file changed.
+func saveRuntimeConfigSnapshot(content []byte, bundle string) (string, error) {
+	snapshotPath := filepath.Join(bundle, configSnapshotFile)
+	if err := ioutil.WriteFile(snapshotPath, content, 0600); err != nil {
+		return "", err
+	}
 
+	return snapshotPath, nil
+}
+
+// runtimeConfigPath returns the configuration file of the sandbox, along with
+// its source. An empty path stands for the default configuration file.
+func runtimeConfigPath(s *service, r *taskAPI.CreateTaskRequest) (string, string, error) {
 	if r.Options != nil {
 		v, err := typeurl.UnmarshalAny(r.Options)
 		if err != nil {
-			return nil, err
+			return "", "", err
 		}
 		option, ok := v.(*crioption.Options)
 		// cri default runtime handler will pass a linux runc options,
 		// and we'll ignore it.
-		if ok {
-			configPath = option.ConfigPath
+		if ok && option.ConfigPath != "" {
+			return option.ConfigPath, configSourceOptions, nil
+		}
+	}
+
+	// The namespace is set on the service context by containerd when
+	// starting the shim.
+	if namespace, ok := namespaces.Namespace(s.ctx); ok {
+		if configPath := katautils.GetNamespaceConfigFile(namespace); configPath != "" {
+			return configPath, configSourceNamespace, nil
 		}
 	}
 
 	// Try to get the config file from the env KATA_CONF_FILE
-	if configPath == "" {
-		configPath = os.Getenv("KATA_CONF_FILE")
+	if configPath := os.Getenv("KATA_CONF_FILE"); configPath != "" {
+		return configPath, configSourceEnv, nil
 	}
 
-	_, runtimeConfig, err := katautils.LoadConfiguration(configPath, false, true)
+	return "", configSourceDefault, nil
+}
+
+func loadRuntimeConfig(s *service, r *taskAPI.CreateTaskRequest, ociSpec *specs.Spec) (*oci.RuntimeConfig, error) {
+	configPath, source, err := runtimeConfigPath(s, r)
 	if err != nil {
 		return nil, err
 	}
 
+	resolved, configData, runtimeConfig, err := katautils.LoadConfigurationData(configPath, false, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load the %s runtime configuration", source)
+	}
+
+	namespace, _ := namespaces.Namespace(s.ctx)
+	logrus.WithFields(logrus.Fields{
+		"config":    resolved,
+		"source":    source,
+		"namespace": namespace,
+	}).Info("loaded runtime configuration")
+
 	// For the unit test, the config will be predefined
 	if s.config == nil {
 		s.config = &runtimeConfig
+
+		// Keep track of the configuration serving the sandbox.
+		if ociSpec.Annotations == nil {
+			ociSpec.Annotations = make(map[string]string)
+		}
+		ociSpec.Annotations[vcAnnotations.ConfigPathKey] = resolved
+		ociSpec.Annotations[vcAnnotations.ConfigSourceKey] = source
+
+		if snapshotPath, err := saveRuntimeConfigSnapshot(configData, r.Bundle); err != nil {
+			logrus.WithError(err).Warn("failed to save the runtime configuration snapshot")
+		} else {
+			ociSpec.Annotations[vcAnnotations.ConfigSnapshotKey] = snapshotPath
+		}
 	}
 
+	startTracing(s.config)
+	s.setEventFilter()
+
 	return &runtimeConfig, nil
 }
 
-func checkAndMount(s *service, r *taskAPI.CreateTaskRequest) error {
+func checkAndMount(s *service, r *taskAPI.CreateTaskRequest, ociSpec *specs.Spec) error {
+	if isEncryptedRootfs(ociSpec) {
+		s.mount = false
+		return nil
+	}
+
 	if len(r.Rootfs) == 1 {
 		m := r.Rootfs[0]
 
@@ -189,6 +290,11 @@ func checkAndMount(s *service, r *taskAPI.CreateTaskRequest) error {
 			s.mount = false
 			return nil
 		}
+
+		if isLayeredRootfs(s, r) {
+			s.mount = false
+			return nil
+		}
 	}
 	rootfs := filepath.Join(r.Bundle, "rootfs")
 	if err := doMount(r.Rootfs, rootfs); err != nil {
@@ -197,6 +303,20 @@ func checkAndMount(s *service, r *taskAPI.CreateTaskRequest) error {
 	return nil
 }
 
+// isLayeredRootfs returns true if the rootfs is a read-only image the guest
+// stacks a scratch disk on, rather than a rootfs mounted on the host.
+func isLayeredRootfs(s *service, r *taskAPI.CreateTaskRequest) bool {
+	return len(r.Rootfs) == 1 && vc.IsLayeredRootfs(r.Rootfs[0].Type) &&
+		!s.config.HypervisorConfig.DisableBlockDeviceUse
+}
+
+// isEncryptedRootfs returns true if the rootfs is a LUKS encrypted block
+// device, only opened by the agent inside the guest.
+func isEncryptedRootfs(ociSpec *specs.Spec) bool {
+	return ociSpec.Annotations[vcAnnotations.RootfsLUKSKeyName] != "" ||
+		ociSpec.Annotations[vcAnnotations.RootfsLUKSKeyID] != ""
+}
+
 func doMount(mounts []*containerd_types.Mount, rootfs string) error {
 	if len(mounts) == 0 {
 		return nil
@@ -15,6 +15,8 @@ import (
 
 	"github.com/containerd/containerd/namespaces"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	crioption "github.com/containerd/cri-containerd/pkg/api/runtimeoptions/v1"
+	"github.com/containerd/typeurl"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 
@@ -369,3 +371,71 @@ func TestCreateContainerConfigFail(t *testing.T) {
 	_, err = s.Create(ctx, req)
 	assert.Error(err)
 }
+
+func TestRuntimeConfigPath(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	saved := katautils.GetDefaultConfigFilePaths()
+	defer katautils.SetConfigOptions("", saved[1], saved[0])
+
+	katautils.SetConfigOptions("", filepath.Join(tmpdir, "conf", "configuration.toml"), filepath.Join(tmpdir, "sysconf", "configuration.toml"))
+
+	namespaceConfig := filepath.Join(tmpdir, "sysconf", "namespaces", "test", "configuration.toml")
+	err = os.MkdirAll(filepath.Dir(namespaceConfig), os.FileMode(0750))
+	assert.NoError(err)
+	err = ioutil.WriteFile(namespaceConfig, nil, os.FileMode(0640))
+	assert.NoError(err)
+
+	savedEnv, envSet := os.LookupEnv("KATA_CONF_FILE")
+	defer func() {
+		if envSet {
+			os.Setenv("KATA_CONF_FILE", savedEnv)
+		} else {
+			os.Unsetenv("KATA_CONF_FILE")
+		}
+	}()
+	os.Unsetenv("KATA_CONF_FILE")
+
+	s := &service{
+		ctx: namespaces.WithNamespace(context.Background(), "prod"),
+	}
+	r := &taskAPI.CreateTaskRequest{}
+
+	path, source, err := runtimeConfigPath(s, r)
+	assert.NoError(err)
+	assert.Empty(path)
+	assert.Equal(configSourceDefault, source)
+
+	os.Setenv("KATA_CONF_FILE", "/foo/configuration.toml")
+	path, source, err = runtimeConfigPath(s, r)
+	assert.NoError(err)
+	assert.Equal("/foo/configuration.toml", path)
+	assert.Equal(configSourceEnv, source)
+
+	// The namespace configuration has priority over KATA_CONF_FILE
+	s.ctx = namespaces.WithNamespace(context.Background(), "test")
+	path, source, err = runtimeConfigPath(s, r)
+	assert.NoError(err)
+	assert.Equal(namespaceConfig, path)
+	assert.Equal(configSourceNamespace, source)
+
+	// The runtime options have priority over everything else
+	r.Options, err = typeurl.MarshalAny(&crioption.Options{ConfigPath: "/bar/configuration.toml"})
+	assert.NoError(err)
+	path, source, err = runtimeConfigPath(s, r)
+	assert.NoError(err)
+	assert.Equal("/bar/configuration.toml", path)
+	assert.Equal(configSourceOptions, source)
+
+	// Empty runtime options are ignored
+	r.Options, err = typeurl.MarshalAny(&crioption.Options{})
+	assert.NoError(err)
+	path, source, err = runtimeConfigPath(s, r)
+	assert.NoError(err)
+	assert.Equal(namespaceConfig, path)
+	assert.Equal(configSourceNamespace, source)
+}
@@ -17,7 +17,8 @@ import (
 )
 
 func deleteContainer(ctx context.Context, s *service, c *container) error {
-	if !c.cType.IsSandbox() {
+	// The containers are deleted along with the sandbox being torn down.
+	if !c.cType.IsSandbox() && s.teardown == nil {
 		status, err := s.sandbox.StatusContainer(c.id)
 		if err != nil {
 			return err
@@ -47,6 +48,7 @@ func deleteContainer(ctx context.Context, s *service, c *container) error {
 	}
 
 	delete(s.containers, c.id)
+	updateContainersMetric(s)
 
 	return nil
 }
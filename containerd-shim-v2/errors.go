@@ -28,15 +28,27 @@ func toGRPC(err error) error {
 		return err
 	}
 
-	err = errors.Cause(err)
+	cause := errors.Cause(err)
+	if kind := vc.HotplugErrorKind(err); kind != nil {
+		// The hotplug errors are mapped by the class of their failure.
+		cause = kind
+	}
+
 	switch {
-	case isInvalidArgument(err):
-		return status.Errorf(codes.InvalidArgument, err.Error())
-	case isNotFound(err):
-		return status.Errorf(codes.NotFound, err.Error())
+	case isInvalidArgument(cause):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case isNotFound(cause):
+		return status.Error(codes.NotFound, err.Error())
+	case isResourceExhausted(cause):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case cause == vc.ErrNotSupported:
+		return status.Error(codes.Unimplemented, err.Error())
+	case cause == vc.ErrHotplugFailed:
+		// The hotplugs which failed transiently can be retried.
+		return status.Error(codes.Unavailable, err.Error())
 	}
 
-	return err
+	return cause
 }
 
 // toGRPCf maps the error to grpc error codes, assembling the formatting string
@@ -60,3 +72,7 @@ func isNotFound(err error) bool {
 	return err == vc.ErrNoSuchContainer || err == syscall.ENOENT ||
 		strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "not exist")
 }
+
+func isResourceExhausted(err error) bool {
+	return err == errExecLimitReached || err == vc.ErrResourceExhausted
+}
@@ -10,7 +10,10 @@ import (
 	"testing"
 
 	vc "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestToGRPC(t *testing.T) {
@@ -27,3 +30,36 @@ func TestToGRPC(t *testing.T) {
 		assert.True(isGRPCError(err))
 	}
 }
+
+func TestToGRPCHotplugErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for kind, code := range map[error]codes.Code{
+		vc.ErrResourceExhausted: codes.ResourceExhausted,
+		vc.ErrNotSupported:      codes.Unimplemented,
+		vc.ErrHotplugFailed:     codes.Unavailable,
+	} {
+		err := toGRPC(errors.Wrap(&vc.HotplugError{
+			Op:     "add",
+			Device: "block",
+			ID:     "drive-1",
+			Kind:   kind,
+			Err:    errors.New("device_add failed"),
+		}, "attaching devices"))
+
+		st, ok := status.FromError(err)
+		assert.True(ok)
+		assert.Equal(code, st.Code())
+		// the description of the device is kept
+		assert.Equal("attaching devices: failed to add block device drive-1: device_add failed", st.Message())
+	}
+
+	// the hotplugs which failed for an unknown reason are not retried
+	cause := errors.New("device_add failed")
+	err := toGRPC(&vc.HotplugError{
+		Op:     "add",
+		Device: "block",
+		Err:    cause,
+	})
+	assert.Equal(cause, err)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	cdruntime "github.com/containerd/containerd/runtime"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/sirupsen/logrus"
+)
+
+// filterableEvents are the topics of the task events which can be
+// suppressed or sampled. containerd tracks the tasks from the others, which
+// are always published.
+var filterableEvents = map[string]bool{
+	cdruntime.TaskExecAddedEventTopic:    true,
+	cdruntime.TaskExecStartedEventTopic:  true,
+	cdruntime.TaskPausedEventTopic:       true,
+	cdruntime.TaskResumedEventTopic:      true,
+	cdruntime.TaskCheckpointedEventTopic: true,
+}
+
+// eventFilter drops the task events of the suppressed topics, and all but
+// one in rate of the sampled topics, to reduce the event pressure on
+// containerd, e.g. from the exec storms of health probes.
+type eventFilter struct {
+	suppressed map[string]bool
+	sampled    map[string]bool
+	rate       uint64
+
+	// counts are the number of events of each sampled topic.
+	counts map[string]uint64
+}
+
+func newEventFilter(config *oci.RuntimeConfig) *eventFilter {
+	f := &eventFilter{
+		suppressed: make(map[string]bool),
+		sampled:    make(map[string]bool),
+		rate:       1,
+		counts:     make(map[string]uint64),
+	}
+
+	if config == nil {
+		return f
+	}
+
+	if config.EventSampleRate > 1 {
+		f.rate = uint64(config.EventSampleRate)
+	}
+
+	for _, topics := range []struct {
+		names []string
+		set   map[string]bool
+	}{
+		{config.SuppressedEvents, f.suppressed},
+		{config.SampledEvents, f.sampled},
+	} {
+		for _, topic := range topics.names {
+			if !filterableEvents[topic] {
+				logrus.WithField("topic", topic).Warn("Ignoring filter of event topic always published")
+				continue
+			}
+			topics.set[topic] = true
+		}
+	}
+
+	return f
+}
+
+// setEventFilter sets the filter of the forwarded events from the
+// configuration, once loaded. The events are sent with s.mu held, the
+// goroutine forwarding them cannot take it to read the configuration.
+func (s *service) setEventFilter() {
+	if s.eventFilter.Load() == nil {
+		s.eventFilter.Store(newEventFilter(s.config))
+	}
+}
+
+// allow returns true if the event of topic is to be published.
+func (f *eventFilter) allow(topic string) bool {
+	if f.suppressed[topic] {
+		return false
+	}
+
+	if !f.sampled[topic] {
+		return true
+	}
+
+	n := f.counts[topic]
+	f.counts[topic]++
+
+	return n%f.rate == 0
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	cdruntime "github.com/containerd/containerd/runtime"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	// Everything is published by default.
+	f := newEventFilter(nil)
+	assert.True(f.allow(cdruntime.TaskExecAddedEventTopic))
+	assert.True(f.allow(cdruntime.TaskExitEventTopic))
+
+	f = newEventFilter(&oci.RuntimeConfig{
+		SuppressedEvents: []string{cdruntime.TaskExecAddedEventTopic, cdruntime.TaskExitEventTopic, cdruntime.TaskOOMEventTopic},
+		SampledEvents:    []string{cdruntime.TaskExecStartedEventTopic},
+		EventSampleRate:  3,
+	})
+
+	assert.False(f.allow(cdruntime.TaskExecAddedEventTopic))
+	assert.False(f.allow(cdruntime.TaskExecAddedEventTopic))
+
+	// containerd relies on the exit events, and the OOM kills are always
+	// reported, they are never filtered.
+	assert.True(f.allow(cdruntime.TaskExitEventTopic))
+	assert.True(f.allow(cdruntime.TaskOOMEventTopic))
+
+	var published int
+	for i := 0; i < 7; i++ {
+		if f.allow(cdruntime.TaskExecStartedEventTopic) {
+			published++
+		}
+	}
+	assert.Equal(3, published)
+
+	assert.True(f.allow(cdruntime.TaskPausedEventTopic))
+}
+
+func TestServiceEventFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{}
+	assert.Nil(s.eventFilter.Load())
+
+	s.config = &oci.RuntimeConfig{SuppressedEvents: []string{cdruntime.TaskExecAddedEventTopic}}
+	s.setEventFilter()
+
+	filter, ok := s.eventFilter.Load().(*eventFilter)
+	assert.True(ok)
+	assert.False(filter.allow(cdruntime.TaskExecAddedEventTopic))
+
+	// The filter of the first configuration is kept.
+	s.config = &oci.RuntimeConfig{}
+	s.setEventFilter()
+	assert.Equal(filter, s.eventFilter.Load())
+}
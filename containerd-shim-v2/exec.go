@@ -33,6 +33,9 @@ type exec struct {
 	exitCh   chan uint32
 
 	exitTime time.Time
+
+	// resizer coalesces the resizes of the pty of the process.
+	resizer *ptyResizer
 }
 
 type tty struct {
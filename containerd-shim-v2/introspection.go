@@ -0,0 +1,435 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+)
+
+// introspectionSocket is the name of the introspection socket, in the
+// sandbox runtime directory.
+const introspectionSocket = "introspect.sock"
+
+// metricsHandler serves the metrics of the default Prometheus registry.
+var metricsHandler = promhttp.Handler()
+
+// introspection serves the state of the sandbox over HTTP on a unix socket.
+// Unlike the task API, it is mostly not serialized with the sandbox
+// operations, so that management layers can follow and cancel the long ones:
+//
+//   GET  /hotplug/jobs                        lists the hotplug jobs
+//   POST /hotplug/jobs/cancel?id=ID           cancels a running hotplug job
+//   GET  /hotplug/reservations                lists the hotplug reservations
+//                                             of the caller
+//   POST /hotplug/reservations?bridge_slots=N&memory_slots=N&ttl=SECONDS
+//                                             reserves hotplug capacity for
+//                                             the devices of a container
+//   POST /hotplug/reservations/release?id=ID  releases a hotplug reservation
+//                                             of the caller
+//   GET  /provenance                          returns the components the
+//                                             sandbox has been started with
+//   GET  /network                             returns the network interfaces
+//                                             and routes of the sandbox
+//   POST /network                             applies the NetworkUpdate of
+//                                             the body to the sandbox, for
+//                                             the root peer only
+//   GET  /debug                               returns the hypervisor
+//                                             configuration, the devices, the
+//                                             agent status and the last QMP
+//                                             commands, if enabled
+//   GET  /debug/pprof/                        returns the profiles of the
+//                                             shim, if the debug state is
+//                                             enabled
+//   GET  /metrics                             returns the metrics of the shim
+//                                             in the Prometheus text format,
+//                                             if enabled
+//
+// The reservations are made between the task API operations, as they
+// depend on the devices these operations hotplug. They are bound to the
+// uid of the caller, as read from the credentials of the socket peer. The
+// network is read and updated between these operations too, through the
+// agent they use.
+type introspection struct {
+	sandbox  vc.VCSandbox
+	lock     sync.Locker
+	path     string
+	listener net.Listener
+}
+
+// debugIntrospection returns true if the introspection server serves the
+// debug state of the sandbox.
+func debugIntrospection(s *service) bool {
+	return s.config != nil && s.config.EnableDebugIntrospection
+}
+
+// metricsIntrospection returns true if the introspection server serves the
+// metrics of the shim.
+func metricsIntrospection(s *service) bool {
+	return s.config != nil && s.config.EnableMetrics
+}
+
+func introspectionSocketPath(sandboxID string) (string, error) {
+	return utils.BuildSocketPath(store.SandboxRuntimeRootPath(sandboxID), introspectionSocket)
+}
+
+func startIntrospection(sandbox vc.VCSandbox, lock sync.Locker, debug, exportMetrics bool) (*introspection, error) {
+	path, err := introspectionSocketPath(sandbox.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &introspection{
+		sandbox:  sandbox,
+		lock:     lock,
+		path:     path,
+		listener: listener,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hotplug/jobs", i.listHotplugJobs)
+	mux.HandleFunc("/hotplug/jobs/cancel", i.cancelHotplugJob)
+	mux.HandleFunc("/hotplug/reservations", i.hotplugReservations)
+	mux.HandleFunc("/hotplug/reservations/release", i.releaseHotplugReservation)
+	mux.HandleFunc("/provenance", i.provenance)
+	mux.HandleFunc("/network", i.network)
+	if debug {
+		mux.HandleFunc("/debug", i.debugInfo)
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if exportMetrics {
+		mux.HandleFunc("/metrics", i.metrics)
+	}
+
+	server := &http.Server{
+		Handler:     mux,
+		ConnContext: withPeerOwner,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logrus.WithError(err).Debug("introspection server stopped")
+		}
+	}()
+
+	return i, nil
+}
+
+type peerOwnerKey struct{}
+
+// withPeerOwner returns ctx with the owner of the hotplug reservations the
+// requests of conn make, the uid of its peer. It is left unset if the
+// credentials cannot be read, and the reservations are refused.
+func withPeerOwner(ctx context.Context, conn net.Conn) context.Context {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	rs, err := uc.SyscallConn()
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get the introspection connection")
+		return ctx
+	}
+
+	var cred *unix.Ucred
+	if err := rs.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil || cred == nil {
+		logrus.WithError(err).Warn("failed to get the introspection peer credentials")
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerOwnerKey{}, fmt.Sprintf("uid=%d", cred.Uid))
+}
+
+// rootPeerOwner is the owner of the requests of the root peer.
+const rootPeerOwner = "uid=0"
+
+// maxNetworkUpdateSize bounds the size of the network updates.
+const maxNetworkUpdateSize = 1 << 20
+
+// peerOwner returns the owner of the hotplug reservations of r, or an
+// empty string if the peer is unknown.
+func peerOwner(r *http.Request) string {
+	owner, _ := r.Context().Value(peerOwnerKey{}).(string)
+	return owner
+}
+
+func (i *introspection) stop() {
+	i.listener.Close()
+	os.Remove(i.path)
+}
+
+func (i *introspection) listHotplugJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(i.sandbox.HotplugJobs()); err != nil {
+		logrus.WithError(err).Warn("failed to send hotplug jobs")
+	}
+}
+
+func (i *introspection) cancelHotplugJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing hotplug job id", http.StatusBadRequest)
+		return
+	}
+
+	if err := i.sandbox.CancelHotplugJob(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logrus.WithField("hotplug-job", id).Info("hotplug job cancellation requested")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (i *introspection) hotplugReservations(w http.ResponseWriter, r *http.Request) {
+	owner := peerOwner(r)
+	if owner == "" {
+		http.Error(w, "unknown peer credentials", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(i.sandbox.HotplugReservations(owner)); err != nil {
+			logrus.WithError(err).Warn("failed to send hotplug reservations")
+		}
+	case http.MethodPost:
+		i.reserveHotplug(w, r, owner)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (i *introspection) reserveHotplug(w http.ResponseWriter, r *http.Request, owner string) {
+	query := r.URL.Query()
+
+	var values [3]uint64
+	for n, key := range []string{"bridge_slots", "memory_slots", "ttl"} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid "+key+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		values[n] = v
+	}
+
+	i.lock.Lock()
+	res, err := i.sandbox.ReserveHotplug(owner, uint32(values[0]), uint32(values[1]), time.Duration(values[2])*time.Second)
+	i.lock.Unlock()
+
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == vc.ErrHotplugCapacity {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logrus.WithError(err).Warn("failed to send hotplug reservation")
+	}
+}
+
+func (i *introspection) releaseHotplugReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner := peerOwner(r)
+	if owner == "" {
+		http.Error(w, "unknown peer credentials", http.StatusForbidden)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing hotplug reservation id", http.StatusBadRequest)
+		return
+	}
+
+	if err := i.sandbox.ReleaseHotplugReservation(id, owner); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logrus.WithField("hotplug-reservation", id).Info("hotplug reservation released")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (i *introspection) provenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := i.sandbox.Provenance()
+	if p == nil {
+		http.Error(w, "provenance not recorded", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logrus.WithError(err).Warn("failed to send provenance")
+	}
+}
+
+// network returns the network interfaces and routes of the sandbox, as seen
+// by the agent, or updates them for the chained network plugins.
+func (i *introspection) network(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		i.listNetwork(w)
+	case http.MethodPost:
+		i.updateNetwork(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (i *introspection) listNetwork(w http.ResponseWriter) {
+	interfaces, routes, err := i.sandboxNetwork()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Interfaces []*vcTypes.Interface `json:"interfaces"`
+		Routes     []*vcTypes.Route     `json:"routes"`
+	}{interfaces, routes}); err != nil {
+		logrus.WithError(err).Warn("failed to send network")
+	}
+}
+
+// sandboxNetwork returns the network interfaces and routes of the sandbox,
+// asking the agent between the task API operations.
+func (i *introspection) sandboxNetwork() ([]*vcTypes.Interface, []*vcTypes.Route, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	interfaces, err := i.sandbox.ListInterfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	routes, err := i.sandbox.ListRoutes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return interfaces, routes, nil
+}
+
+func (i *introspection) updateNetwork(w http.ResponseWriter, r *http.Request) {
+	if peerOwner(r) != rootPeerOwner {
+		http.Error(w, "network updates are restricted to root", http.StatusForbidden)
+		return
+	}
+
+	var u NetworkUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNetworkUpdateSize)).Decode(&u); err != nil {
+		http.Error(w, "invalid network update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	i.lock.Lock()
+	err := updateNetwork(i.sandbox, &u)
+	i.lock.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// debugInfo returns the state of the sandbox, without waiting for the task
+// API operations, so that the stuck sandboxes can be debugged.
+func (i *introspection) debugInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(i.sandbox.DebugInfo()); err != nil {
+		logrus.WithError(err).Warn("failed to send debug info")
+	}
+}
+
+// metrics returns the metrics of the shim, the hotplug operations, the QMP
+// commands and the agent RPCs of the sandbox included, for a node level
+// monitor to scrape them.
+func (i *introspection) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metricsHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+)
+
+func TestIntrospectionHotplugJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	w := httptest.NewRecorder()
+	i.listHotplugJobs(w, httptest.NewRequest(http.MethodGet, "/hotplug/jobs", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("null", strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	i.listHotplugJobs(w, httptest.NewRequest(http.MethodPost, "/hotplug/jobs", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	i.cancelHotplugJob(w, httptest.NewRequest(http.MethodPost, "/hotplug/jobs/cancel", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	i.cancelHotplugJob(w, httptest.NewRequest(http.MethodGet, "/hotplug/jobs/cancel?id=foo", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	i.cancelHotplugJob(w, httptest.NewRequest(http.MethodPost, "/hotplug/jobs/cancel?id=foo", nil))
+	assert.Equal(http.StatusNoContent, w.Code)
+}
+
+func TestIntrospectionProvenance(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	w := httptest.NewRecorder()
+	i.provenance(w, httptest.NewRequest(http.MethodGet, "/provenance", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	i.provenance(w, httptest.NewRequest(http.MethodPost, "/provenance", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestIntrospectionNetwork(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+		lock:    &sync.Mutex{},
+	}
+
+	request := func(owner, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/network", strings.NewReader(body))
+		return r.WithContext(context.WithValue(r.Context(), peerOwnerKey{}, owner))
+	}
+
+	w := httptest.NewRecorder()
+	i.network(w, httptest.NewRequest(http.MethodGet, "/network", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"interfaces":null,"routes":null}`, w.Body.String())
+
+	update := `{"add_interface":{"Name":"net1","HwAddr":"02:00:ca:fe:00:01"},"routes":[{"Dest":"10.1.0.0/16","Gateway":"10.0.0.1","Device":"net1"}]}`
+
+	w = httptest.NewRecorder()
+	i.network(w, request(rootPeerOwner, update))
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	// the network is updated by root only
+	w = httptest.NewRecorder()
+	i.network(w, request("uid=1000", update))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	i.network(w, httptest.NewRequest(http.MethodPost, "/network", strings.NewReader(update)))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	i.network(w, request(rootPeerOwner, "{}"))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	i.network(w, request(rootPeerOwner, "not json"))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	i.network(w, httptest.NewRequest(http.MethodDelete, "/network", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestIntrospectionHotplugReservations(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+		lock:    &sync.Mutex{},
+	}
+
+	request := func(method, target string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		return r.WithContext(context.WithValue(r.Context(), peerOwnerKey{}, "uid=1000"))
+	}
+
+	// the callers are identified by their credentials
+	w := httptest.NewRecorder()
+	i.hotplugReservations(w, httptest.NewRequest(http.MethodGet, "/hotplug/reservations", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	i.releaseHotplugReservation(w, httptest.NewRequest(http.MethodPost, "/hotplug/reservations/release?id=foo", nil))
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	i.hotplugReservations(w, request(http.MethodGet, "/hotplug/reservations"))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("null", strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	i.hotplugReservations(w, request(http.MethodPost, "/hotplug/reservations?bridge_slots=2&ttl=60"))
+	assert.Equal(http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	i.hotplugReservations(w, request(http.MethodPost, "/hotplug/reservations?bridge_slots=-1"))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	i.hotplugReservations(w, request(http.MethodDelete, "/hotplug/reservations"))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	i.releaseHotplugReservation(w, request(http.MethodPost, "/hotplug/reservations/release"))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	i.releaseHotplugReservation(w, request(http.MethodGet, "/hotplug/reservations/release?id=foo"))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	i.releaseHotplugReservation(w, request(http.MethodPost, "/hotplug/reservations/release?id=foo"))
+	assert.Equal(http.StatusNoContent, w.Code)
+}
+
+func TestIntrospectionPeerOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "introspection")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	assert.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", listener.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := listener.Accept()
+	assert.NoError(err)
+	defer conn.Close()
+
+	ctx := withPeerOwner(context.Background(), conn)
+	r := httptest.NewRequest(http.MethodGet, "/hotplug/reservations", nil).WithContext(ctx)
+	assert.Equal(fmt.Sprintf("uid=%d", os.Getuid()), peerOwner(r))
+}
+
+func TestIntrospectionDebugInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	w := httptest.NewRecorder()
+	i.debugInfo(w, httptest.NewRequest(http.MethodGet, "/debug", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"ID":"`+testSandboxID+`"`)
+
+	w = httptest.NewRecorder()
+	i.debugInfo(w, httptest.NewRequest(http.MethodPost, "/debug", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestIntrospectionMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	i := &introspection{
+		sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+	}
+
+	s := &service{
+		containers: map[string]*container{testContainerID: {}},
+	}
+	updateContainersMetric(s)
+
+	w := httptest.NewRecorder()
+	i.metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(string(expfmt.FmtText), w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "# TYPE kata_shim_containers gauge\nkata_shim_containers 1\n")
+	assert.Contains(w.Body.String(), "# TYPE go_goroutines gauge\n")
+
+	w = httptest.NewRecorder()
+	i.metrics(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
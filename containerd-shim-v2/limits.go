@@ -0,0 +1,118 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultExecLimit is the default number of exec processes a shim can
+// watch concurrently.
+const defaultExecLimit = 1024
+
+// errExecLimitReached is returned when starting an exec process would
+// exceed the exec limit of the shim.
+var errExecLimitReached = errors.New("too many running exec processes")
+
+// execLimiter bounds the number of running exec processes. Each of them
+// costs the shim an IO copy and a wait goroutine, plus the agent streams.
+type execLimiter struct {
+	sync.Mutex
+
+	limit    uint32
+	running  uint32
+	peak     uint32
+	rejected uint64
+}
+
+// execLimiterStats are the exec limiter metrics.
+type execLimiterStats struct {
+	Limit    uint32
+	Running  uint32
+	Peak     uint32
+	Rejected uint64
+}
+
+// getExecLimiter returns the exec limiter of the shim, configured with
+// the runtime exec limit. It must be called with the service lock held.
+func (s *service) getExecLimiter() *execLimiter {
+	if s.execLimiter == nil {
+		var limit uint32
+		if s.config != nil {
+			limit = s.config.ExecLimit
+		}
+		s.execLimiter = newExecLimiter(limit)
+	}
+
+	return s.execLimiter
+}
+
+func (st execLimiterStats) fields() logrus.Fields {
+	return logrus.Fields{
+		"exec-limit":    st.Limit,
+		"exec-running":  st.Running,
+		"exec-peak":     st.Peak,
+		"exec-rejected": st.Rejected,
+	}
+}
+
+func newExecLimiter(limit uint32) *execLimiter {
+	if limit == 0 {
+		limit = defaultExecLimit
+	}
+
+	return &execLimiter{
+		limit: limit,
+	}
+}
+
+// acquire reserves a slot for a new exec process, it never blocks.
+func (l *execLimiter) acquire() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.running >= l.limit {
+		l.rejected++
+		logrus.WithFields(l.statsLocked().fields()).Warn("exec limit reached")
+		return errExecLimitReached
+	}
+
+	l.running++
+	if l.running > l.peak {
+		l.peak = l.running
+	}
+
+	return nil
+}
+
+// release frees the slot of an exited exec process.
+func (l *execLimiter) release() {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.running > 0 {
+		l.running--
+	}
+}
+
+func (l *execLimiter) stats() execLimiterStats {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.statsLocked()
+}
+
+func (l *execLimiter) statsLocked() execLimiterStats {
+	return execLimiterStats{
+		Limit:    l.limit,
+		Running:  l.running,
+		Peak:     l.peak,
+		Rejected: l.rejected,
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExecLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	l := newExecLimiter(2)
+
+	assert.NoError(l.acquire())
+	assert.NoError(l.acquire())
+	assert.Equal(errExecLimitReached, l.acquire())
+
+	l.release()
+	assert.NoError(l.acquire())
+
+	l.release()
+	l.release()
+	l.release()
+
+	st := l.stats()
+	assert.Equal(uint32(2), st.Limit)
+	assert.Equal(uint32(0), st.Running)
+	assert.Equal(uint32(2), st.Peak)
+	assert.Equal(uint64(1), st.Rejected)
+}
+
+func TestGetExecLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{}
+	assert.Equal(uint32(defaultExecLimit), s.getExecLimiter().stats().Limit)
+
+	s = &service{
+		config: &oci.RuntimeConfig{
+			ExecLimit: 8,
+		},
+	}
+	l := s.getExecLimiter()
+	assert.Equal(uint32(8), l.stats().Limit)
+	assert.True(l == s.getExecLimiter())
+}
+
+func TestExecLimitReachedToGRPC(t *testing.T) {
+	assert := assert.New(t)
+
+	err := toGRPC(errExecLimitReached)
+	st, ok := status.FromError(err)
+	assert.True(ok)
+	assert.Equal(codes.ResourceExhausted, st.Code())
+}
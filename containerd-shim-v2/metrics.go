@@ -6,21 +6,92 @@
 package containerdshim
 
 import (
+	"os"
+	"time"
+
 	"github.com/containerd/cgroups"
 	"github.com/containerd/typeurl"
 
 	google_protobuf "github.com/gogo/protobuf/types"
 	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	shimSandboxes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kata_shim_sandboxes",
+		Help: "Number of the sandboxes run by the shim.",
+	})
+	shimContainers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kata_shim_containers",
+		Help: "Number of the containers of the sandbox, the sandbox container included.",
+	})
+
+	// The sandbox overhead is a separate entry, not to account the guest
+	// memory and vCPUs of the containers twice.
+	sandboxOverheadRSS = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kata_shim_sandbox_overhead_rss_bytes",
+		Help: "Resident memory of the host processes of the sandbox, the guest memory touched by the hypervisor included.",
+	}, []string{"process"})
+	sandboxOverheadCPUTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kata_shim_sandbox_overhead_cpu_seconds",
+		Help: "CPU time of the host processes of the sandbox, the vCPUs of the hypervisor included.",
+	}, []string{"process"})
+
+	sandboxDiskUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kata_shim_sandbox_disk_usage_bytes",
+		Help: "Host disk space used by the sandbox, its temporary files and the scratch disks of its containers.",
+	}, []string{"item"})
 )
 
-func marshalMetrics(s *service, containerID string) (*google_protobuf.Any, error) {
-	stats, err := s.sandbox.StatsContainer(containerID)
+func init() {
+	prometheus.MustRegister(shimSandboxes, shimContainers, sandboxOverheadRSS, sandboxOverheadCPUTime, sandboxDiskUsage)
+}
+
+// updateContainersMetric sets the number of containers of the sandbox, to be
+// called with the service lock held.
+func updateContainersMetric(s *service) {
+	shimContainers.Set(float64(len(s.containers)))
+}
+
+func marshalMetrics(s *service, c *container) (*google_protobuf.Any, error) {
+	stats, err := s.sandbox.StatsContainer(c.id)
 	if err != nil {
-		return nil, err
+		// The host network counters do not depend on the agent, report
+		// them on their own rather than nothing.
+		netStats, netErr := s.sandbox.NetworkStats()
+		if netErr != nil || len(netStats) == 0 {
+			return nil, err
+		}
+
+		logrus.WithError(err).Warn("failed to get container stats, only reporting the host network stats")
+		stats = vc.ContainerStats{NetworkStats: netStats}
 	}
 
 	metrics := statsToMetrics(&stats)
 
+	// The VMM overhead is accounted to the sandbox container, like the
+	// pod overhead of the host cgroups.
+	if c.cType.IsSandbox() {
+		logrus.WithFields(s.getExecLimiter().stats().fields()).Debug("exec limiter stats")
+
+		hMetrics, err := s.sandbox.Metrics()
+		if err != nil {
+			logrus.WithError(err).Warn("failed to get hypervisor metrics")
+		} else {
+			addHypervisorMetrics(metrics, &hMetrics)
+			setSandboxDiskUsage(&hMetrics)
+
+			if s.config != nil && s.config.SandboxOverheadMetrics {
+				if err := setSandboxOverhead(&hMetrics); err != nil {
+					logrus.WithError(err).Warn("failed to get shim resources")
+				}
+			}
+		}
+	}
+
 	data, err := typeurl.MarshalAny(metrics)
 	if err != nil {
 		return nil, err
@@ -47,6 +118,72 @@ func statsToMetrics(stats *vc.ContainerStats) *cgroups.Metrics {
 	return metrics
 }
 
+// addHypervisorMetrics adds the hypervisor resources which are not used
+// by the guest: the CPU time spent outside of the vCPUs and the memory of
+// virtiofsd. The hypervisor RSS is not added as it includes the guest memory.
+func addHypervisorMetrics(metrics *cgroups.Metrics, hMetrics *vc.HypervisorMetrics) {
+	if metrics.CPU == nil {
+		metrics.CPU = &cgroups.CPUStat{}
+	}
+	if metrics.CPU.Usage == nil {
+		metrics.CPU.Usage = &cgroups.CPUUsage{}
+	}
+	metrics.CPU.Usage.Total += uint64(hMetrics.OverheadCPUTime().Nanoseconds())
+
+	if metrics.Memory == nil {
+		metrics.Memory = &cgroups.MemoryStat{}
+	}
+	if metrics.Memory.Usage == nil {
+		metrics.Memory.Usage = &cgroups.MemoryEntry{}
+	}
+	metrics.Memory.Usage.Usage += hMetrics.VirtiofsdRSS
+	metrics.Memory.RSS += hMetrics.VirtiofsdRSS
+}
+
+// shimResources returns the resident memory and CPU time of the shim.
+var shimResources = func() (uint64, time.Duration, error) {
+	proc, err := procfs.NewProc(os.Getpid())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stat, err := proc.NewStat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(stat.ResidentMemory()), time.Duration(stat.CPUTime() * float64(time.Second)), nil
+}
+
+// setSandboxOverhead sets the whole host footprint of the VM, apart from
+// the metrics of the containers: the resident memory and CPU time of the
+// hypervisor, including the guest memory it touched and its vCPUs, of
+// virtiofsd and of the shim.
+func setSandboxOverhead(hMetrics *vc.HypervisorMetrics) error {
+	shimRSS, shimCPUTime, err := shimResources()
+
+	sandboxOverheadRSS.WithLabelValues("hypervisor").Set(float64(hMetrics.RSS))
+	sandboxOverheadCPUTime.WithLabelValues("hypervisor").Set(hMetrics.CPUTime.Seconds())
+	sandboxOverheadRSS.WithLabelValues("virtiofsd").Set(float64(hMetrics.VirtiofsdRSS))
+	sandboxOverheadCPUTime.WithLabelValues("virtiofsd").Set(hMetrics.VirtiofsdCPUTime.Seconds())
+
+	if err != nil {
+		return err
+	}
+
+	sandboxOverheadRSS.WithLabelValues("shim").Set(float64(shimRSS))
+	sandboxOverheadCPUTime.WithLabelValues("shim").Set(shimCPUTime.Seconds())
+
+	return nil
+}
+
+// setSandboxDiskUsage sets the host disk space used by the sandbox, which
+// is not accounted in the metrics of the containers.
+func setSandboxDiskUsage(hMetrics *vc.HypervisorMetrics) {
+	sandboxDiskUsage.WithLabelValues("tmp").Set(float64(hMetrics.HostTmpUsage))
+	sandboxDiskUsage.WithLabelValues("scratch").Set(float64(hMetrics.HostScratchUsage))
+}
+
 func setHugetlbStats(vcHugetlb map[string]vc.HugetlbStats) []*cgroups.HugetlbStat {
 	var hugetlbStats []*cgroups.HugetlbStat
 	for _, v := range vcHugetlb {
@@ -8,7 +8,10 @@ package containerdshim
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/containerd/cgroups"
 	"github.com/containerd/containerd/namespaces"
@@ -54,3 +57,70 @@ func TestStatNetworkMetric(t *testing.T) {
 	metrics := statsToMetrics(&resp)
 	assert.Equal(expectedNetwork, metrics.Network)
 }
+
+func TestAddHypervisorMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	hMetrics := &vc.HypervisorMetrics{
+		CPUTime:          3 * time.Second,
+		VCPUTime:         map[int]time.Duration{0: time.Second},
+		VirtiofsdCPUTime: time.Second,
+		VirtiofsdRSS:     1024,
+	}
+
+	metrics := statsToMetrics(&vc.ContainerStats{})
+	addHypervisorMetrics(metrics, hMetrics)
+
+	assert.Equal(uint64(3*time.Second), metrics.CPU.Usage.Total)
+	assert.Equal(uint64(1024), metrics.Memory.Usage.Usage)
+	assert.Equal(uint64(1024), metrics.Memory.RSS)
+}
+
+func TestSetSandboxDiskUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	setSandboxDiskUsage(&vc.HypervisorMetrics{
+		HostTmpUsage:     4096,
+		HostScratchUsage: 1 << 20,
+	})
+
+	w := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_disk_usage_bytes{item="tmp"} 4096`)
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_disk_usage_bytes{item="scratch"} 1.048576e+06`)
+}
+
+func TestSetSandboxOverhead(t *testing.T) {
+	assert := assert.New(t)
+
+	savedShimResources := shimResources
+	shimResources = func() (uint64, time.Duration, error) {
+		return 256, time.Second, nil
+	}
+	defer func() {
+		shimResources = savedShimResources
+	}()
+
+	hMetrics := &vc.HypervisorMetrics{
+		RSS:              4096,
+		CPUTime:          3 * time.Second,
+		VCPUTime:         map[int]time.Duration{0: time.Second},
+		VirtiofsdCPUTime: time.Second,
+		VirtiofsdRSS:     1024,
+	}
+
+	assert.NoError(setSandboxOverhead(hMetrics))
+
+	// the vCPUs and the guest memory are included
+	w := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_overhead_cpu_seconds{process="hypervisor"} 3`)
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_overhead_rss_bytes{process="hypervisor"} 4096`)
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_overhead_rss_bytes{process="virtiofsd"} 1024`)
+	assert.Contains(w.Body.String(), `kata_shim_sandbox_overhead_rss_bytes{process="shim"} 256`)
+
+	// the shim resources are read from procfs
+	rss, _, err := savedShimResources()
+	assert.NoError(err)
+	assert.NotZero(rss)
+}
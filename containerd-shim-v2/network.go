@@ -0,0 +1,81 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"errors"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NetworkUpdate is posted to the network endpoint of the introspection
+// socket to hotplug a network interface into the sandbox, or hot unplug one
+// from it, to replace its routes and to change the rates its traffic is
+// limited to. It lets the chained network plugins attach secondary networks
+// to a running sandbox.
+type NetworkUpdate struct {
+	// AddInterface is the network interface hotplugged into the sandbox.
+	// It must exist in the sandbox network namespace.
+	AddInterface *vcTypes.Interface `json:"add_interface,omitempty"`
+
+	// RemoveInterface is the network interface hot unplugged from the
+	// sandbox, identified by its MAC address.
+	RemoveInterface *vcTypes.Interface `json:"remove_interface,omitempty"`
+
+	// Routes replace the routes of the sandbox, once the network
+	// interface is added or removed.
+	Routes []*vcTypes.Route `json:"routes,omitempty"`
+
+	// Bandwidth replaces the rates the traffic of the sandbox network
+	// interfaces is limited to.
+	Bandwidth *vc.BandwidthConfig `json:"bandwidth,omitempty"`
+}
+
+func (u *NetworkUpdate) validate() error {
+	if u.AddInterface != nil && u.RemoveInterface != nil {
+		return errors.New("Network update cannot both add and remove an interface")
+	}
+
+	if u.AddInterface == nil && u.RemoveInterface == nil && u.Routes == nil && u.Bandwidth == nil {
+		return errors.New("Network update must add or remove an interface, or update the routes or the bandwidth")
+	}
+
+	return nil
+}
+
+func updateNetwork(sandbox vc.VCSandbox, u *NetworkUpdate) error {
+	if u.AddInterface != nil {
+		inf, err := sandbox.AddInterface(u.AddInterface)
+		if err != nil {
+			return err
+		}
+		logrus.WithField("interface", inf).Info("network interface added")
+	}
+
+	if u.RemoveInterface != nil {
+		if _, err := sandbox.RemoveInterface(u.RemoveInterface); err != nil {
+			return err
+		}
+		logrus.WithField("interface", u.RemoveInterface.HwAddr).Info("network interface removed")
+	}
+
+	if u.Routes != nil {
+		if _, err := sandbox.UpdateRoutes(u.Routes); err != nil {
+			return err
+		}
+	}
+
+	if u.Bandwidth != nil {
+		if err := sandbox.UpdateBandwidth(*u.Bandwidth); err != nil {
+			return err
+		}
+		logrus.WithField("bandwidth", *u.Bandwidth).Info("network bandwidth updated")
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateNetwork(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &vcmock.Sandbox{MockID: testSandboxID}
+
+	inf := &vcTypes.Interface{Name: "net1", HwAddr: "02:00:ca:fe:00:01"}
+	routes := []*vcTypes.Route{{Dest: "10.1.0.0/16", Gateway: "10.0.0.1", Device: "net1"}}
+
+	for _, u := range []*NetworkUpdate{
+		{AddInterface: inf, Routes: routes},
+		{Routes: []*vcTypes.Route{}},
+		{RemoveInterface: inf},
+		{Bandwidth: &vc.BandwidthConfig{IngressRate: 10000000}},
+	} {
+		assert.NoError(u.validate())
+		assert.NoError(updateNetwork(sandbox, u))
+	}
+
+	assert.Error((&NetworkUpdate{}).validate())
+	assert.Error((&NetworkUpdate{AddInterface: inf, RemoveInterface: inf}).validate())
+}
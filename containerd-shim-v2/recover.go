@@ -0,0 +1,175 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/kata-containers/runtime/pkg/katautils"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/sirupsen/logrus"
+)
+
+// sandboxStateExists returns true if the configuration of the sandbox id is
+// persisted.
+var sandboxStateExists = func(id string) bool {
+	path, err := store.SandboxConfigurationItemPath(id, store.Configuration)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// taskStatus returns the task status of a container in the state.
+func taskStatus(state types.StateString) task.Status {
+	switch state {
+	case types.StateReady:
+		return task.StatusCreated
+	case types.StateRunning:
+		return task.StatusRunning
+	case types.StatePaused:
+		return task.StatusPaused
+	case types.StateStopped:
+		return task.StatusStopped
+	}
+
+	return task.StatusUnknown
+}
+
+// maybeRecoverSandbox recovers the sandbox left by a previous shim process
+// of the same id, which died while the VM kept running, the first time it
+// is called. Recovering is idempotent, the sandbox is recovered at most
+// once. It must be called with the service lock held.
+func maybeRecoverSandbox(s *service) {
+	if s.sandbox != nil || s.recoverTried {
+		return
+	}
+	s.recoverTried = true
+
+	if err := recoverSandbox(s); err != nil {
+		logrus.WithError(err).WithField("sandbox", s.id).Warn("failed to recover sandbox")
+	}
+}
+
+// recoverSandbox reconnects to the persisted sandbox of the shim id and
+// rebuilds the containers of the service from its state. The processes
+// still running are waited for again, but their IO and the exec processes
+// are lost with the previous shim.
+func recoverSandbox(s *service) error {
+	if !sandboxStateExists(s.id) {
+		return nil
+	}
+
+	sandbox, err := vci.FetchSandbox(s.ctx, s.id)
+	if err != nil {
+		return err
+	}
+
+	logger := logrus.WithField("sandbox", s.id)
+
+	if s.config == nil {
+		configPath, _ := sandbox.Annotations(vcAnnotations.ConfigSnapshotKey)
+		if _, err := os.Stat(configPath); configPath == "" || err != nil {
+			// Sandboxes created before the configuration snapshots.
+			configPath, _ = sandbox.Annotations(vcAnnotations.ConfigPathKey)
+		}
+
+		if configPath != "" {
+			if _, runtimeConfig, err := katautils.LoadConfiguration(configPath, false, true); err != nil {
+				logger.WithError(err).Warn("failed to reload the runtime configuration of the recovered sandbox")
+			} else {
+				s.config = &runtimeConfig
+				startTracing(s.config)
+				s.setEventFilter()
+			}
+		}
+	}
+
+	s.sandbox = sandbox
+	s.sandbox.SetOperationLock(&s.mu)
+	shimSandboxes.Set(1)
+
+	// The rootfs of the containers are mounted by the shim, unless the
+	// rootfs of the sandbox container is a block device.
+	s.mount = true
+
+	var recovered []string
+	var running []*container
+	for _, vcc := range sandbox.GetAllContainers() {
+		status, err := sandbox.StatusContainer(vcc.ID())
+		if err != nil {
+			logger.WithError(err).WithField("container", vcc.ID()).Warn("failed to recover container")
+			continue
+		}
+
+		cType, err := oci.GetContainerType(status.Annotations)
+		if err != nil {
+			logger.WithError(err).WithField("container", vcc.ID()).Warn("failed to recover container")
+			continue
+		}
+
+		bundle := status.Annotations[vcAnnotations.BundlePathKey]
+		if bundle == "" && status.RootFs != "" {
+			bundle = filepath.Dir(status.RootFs)
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: status.ID, Bundle: bundle}, cType, status.Spec)
+		if err != nil {
+			return err
+		}
+
+		c.recovered = true
+		c.status = taskStatus(status.State.State)
+
+		if cType.IsSandbox() && status.State.BlockDeviceID != "" {
+			s.mount = false
+		}
+
+		switch c.status {
+		case task.StatusRunning, task.StatusPaused:
+			close(c.exitIOch)
+			running = append(running, c)
+		case task.StatusStopped:
+			// The exit status was lost with the previous shim.
+			c.exit = exitCode255
+			c.exitTime = time.Now()
+			c.exitCh <- uint32(exitCode255)
+		}
+
+		s.containers[c.id] = c
+		recovered = append(recovered, c.id)
+	}
+	updateContainersMetric(s)
+
+	s.monitor, err = sandbox.Monitor()
+	if err != nil {
+		return err
+	}
+	go watchSandbox(s)
+
+	if introspection, err := startIntrospection(sandbox, &s.mu, debugIntrospection(s), metricsIntrospection(s)); err != nil {
+		logger.WithError(err).Warn("failed to start the introspection server")
+	} else {
+		s.introspection = introspection
+	}
+
+	for _, c := range running {
+		go wait(s, c, "")
+	}
+
+	logger.WithField("containers", recovered).Info("sandbox recovered")
+
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// persistedSandbox reports the state of its containers, and blocks the
+// waits for their processes until their exit status is sent.
+type persistedSandbox struct {
+	*vcmock.Sandbox
+
+	states map[string]types.StateString
+	exits  chan int32
+}
+
+func (s *persistedSandbox) StatusContainer(id string) (vc.ContainerStatus, error) {
+	cType := vc.PodContainer
+	if id == testSandboxID {
+		cType = vc.PodSandbox
+	}
+
+	return vc.ContainerStatus{
+		ID:    id,
+		State: types.ContainerState{State: s.states[id]},
+		Annotations: map[string]string{
+			vcAnnotations.ContainerTypeKey: string(cType),
+			vcAnnotations.BundlePathKey:    "/run/bundles/" + id,
+		},
+	}, nil
+}
+
+func (s *persistedSandbox) WaitProcess(containerID, processID string) (int32, error) {
+	return <-s.exits, nil
+}
+
+func TestRecoverSandbox(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &persistedSandbox{
+		Sandbox: &vcmock.Sandbox{
+			MockID: testSandboxID,
+			MockContainers: []*vcmock.Container{
+				{MockID: testSandboxID},
+				{MockID: testContainerID},
+			},
+		},
+		states: map[string]types.StateString{
+			testSandboxID:   types.StateRunning,
+			testContainerID: types.StateStopped,
+		},
+		exits: make(chan int32),
+	}
+
+	fetched := 0
+	savedVci := vci
+	vci = &vcmock.VCMock{
+		FetchSandboxFunc: func(ctx context.Context, id string) (vc.VCSandbox, error) {
+			fetched++
+			return sandbox, nil
+		},
+	}
+
+	exists := false
+	savedSandboxStateExists := sandboxStateExists
+	sandboxStateExists = func(id string) bool {
+		return exists
+	}
+
+	defer func() {
+		vci = savedVci
+		sandboxStateExists = savedSandboxStateExists
+	}()
+
+	newService := func() *service {
+		return &service{
+			id:         testSandboxID,
+			ctx:        context.Background(),
+			containers: make(map[string]*container),
+			events:     make(chan interface{}, chSize),
+			ec:         make(chan exit, bufferSize),
+		}
+	}
+
+	// nothing is recovered without a persisted sandbox
+	s := newService()
+	_, err := s.State(context.Background(), &taskAPI.StateRequest{ID: testSandboxID})
+	assert.True(errdefs.IsNotFound(errdefs.FromGRPC(err)))
+	assert.Equal(0, fetched)
+
+	// the sandbox is recovered once
+	exists = true
+	s = newService()
+	_, err = s.Connect(context.Background(), &taskAPI.ConnectRequest{ID: testSandboxID})
+	assert.NoError(err)
+	_, err = s.Connect(context.Background(), &taskAPI.ConnectRequest{ID: testSandboxID})
+	assert.NoError(err)
+	assert.Equal(1, fetched)
+	assert.Equal(sandbox, s.sandbox)
+	assert.True(s.mount)
+
+	s.mu.Lock()
+	assert.Len(s.containers, 2)
+	sc := s.containers[testSandboxID]
+	assert.True(sc.recovered)
+	assert.Equal(vc.PodSandbox, sc.cType)
+	assert.Equal("/run/bundles/"+testSandboxID, sc.bundle)
+	s.mu.Unlock()
+
+	state, err := s.State(context.Background(), &taskAPI.StateRequest{ID: testSandboxID})
+	assert.NoError(err)
+	assert.Equal(task.StatusRunning, state.Status)
+
+	// the exit status of the containers stopped meanwhile is lost
+	resp, err := s.Wait(context.Background(), &taskAPI.WaitRequest{ID: testContainerID})
+	assert.NoError(err)
+	assert.Equal(uint32(exitCode255), resp.ExitStatus)
+
+	// the tasks created again are not recreated
+	_, err = s.Create(context.Background(), &taskAPI.CreateTaskRequest{ID: testSandboxID})
+	assert.NoError(err)
+
+	// and the processes still running are waited for
+	sandbox.exits <- 3
+	resp, err = s.Wait(context.Background(), &taskAPI.WaitRequest{ID: testSandboxID})
+	assert.NoError(err)
+	assert.Equal(uint32(3), resp.ExitStatus)
+
+	s.mu.Lock()
+	t1 := s.teardown
+	s.mu.Unlock()
+	<-t1.done
+}
+
+func TestTaskStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(task.StatusCreated, taskStatus(types.StateReady))
+	assert.Equal(task.StatusRunning, taskStatus(types.StateRunning))
+	assert.Equal(task.StatusPaused, taskStatus(types.StatePaused))
+	assert.Equal(task.StatusStopped, taskStatus(types.StateStopped))
+	assert.Equal(task.StatusUnknown, taskStatus(""))
+}
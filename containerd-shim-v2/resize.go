@@ -0,0 +1,135 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultResizePtyDebounce is the default time the resizes of a pty
+	// are coalesced over before the last one is sent to the agent.
+	defaultResizePtyDebounce = 20 * time.Millisecond
+)
+
+type winsize struct {
+	height uint32
+	width  uint32
+}
+
+// ptyResizer coalesces the resizes of the pty of a process, which come in
+// storms when a terminal window is dragged. Only the last size requested
+// within the debounce time is sent to the agent, once the previous resize
+// completed, so that the agent applies them in order.
+type ptyResizer struct {
+	sync.Mutex
+
+	resize   func(height, width uint32) error
+	debounce time.Duration
+
+	// pending is the last size requested and not sent yet.
+	pending *winsize
+	// timer sends the pending size once the debounce time elapsed.
+	timer    *time.Timer
+	inflight bool
+
+	requested uint64
+	sent      uint64
+}
+
+func newPtyResizer(debounce time.Duration, resize func(height, width uint32) error) *ptyResizer {
+	if debounce == 0 {
+		debounce = defaultResizePtyDebounce
+	}
+
+	return &ptyResizer{
+		resize:   resize,
+		debounce: debounce,
+	}
+}
+
+// getPtyResizer returns the pty resizer of the process, configured with
+// the runtime resize settings. It must be called with the service lock held.
+func (s *service) getPtyResizer(c *container, execs *exec) *ptyResizer {
+	resizer := &c.resizer
+	processID := c.id
+	if execs != nil {
+		resizer = &execs.resizer
+		processID = execs.id
+	}
+
+	if *resizer == nil {
+		var debounce time.Duration
+		if s.config != nil {
+			debounce = time.Duration(s.config.ResizePtyDebounce) * time.Millisecond
+		}
+
+		containerID := c.id
+		*resizer = newPtyResizer(debounce, func(height, width uint32) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if err := s.checkTeardown(); err != nil {
+				return err
+			}
+
+			return s.sandbox.WinsizeProcess(containerID, processID, height, width)
+		})
+	}
+
+	return *resizer
+}
+
+// request records the last size of the pty, and schedules sending it to
+// the agent. It never blocks on the agent.
+func (r *ptyResizer) request(height, width uint32) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.requested++
+	r.pending = &winsize{height: height, width: width}
+	r.scheduleLocked()
+}
+
+func (r *ptyResizer) scheduleLocked() {
+	if r.pending == nil || r.timer != nil || r.inflight {
+		return
+	}
+
+	r.timer = time.AfterFunc(r.debounce, r.flush)
+}
+
+// flush sends the pending size to the agent, and schedules the next one
+// requested meanwhile.
+func (r *ptyResizer) flush() {
+	r.Lock()
+	r.timer = nil
+	if r.pending == nil || r.inflight {
+		r.Unlock()
+		return
+	}
+
+	size := *r.pending
+	r.pending = nil
+	r.inflight = true
+	r.sent++
+	r.Unlock()
+
+	if err := r.resize(size.height, size.width); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"height": size.height,
+			"width":  size.width,
+		}).Warn("failed to resize pty")
+	}
+
+	r.Lock()
+	r.inflight = false
+	r.scheduleLocked()
+	r.Unlock()
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type resizeRecorder struct {
+	sync.Mutex
+	sizes   []winsize
+	release chan struct{}
+}
+
+func (r *resizeRecorder) resize(height, width uint32) error {
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.sizes = append(r.sizes, winsize{height: height, width: width})
+
+	return nil
+}
+
+func (r *resizeRecorder) get() []winsize {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]winsize{}, r.sizes...)
+}
+
+// waitFor polls cond until it is true, for up to a second.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+func TestPtyResizerCoalesce(t *testing.T) {
+	assert := assert.New(t)
+
+	rec := &resizeRecorder{}
+	r := newPtyResizer(10*time.Millisecond, rec.resize)
+
+	for i := uint32(1); i <= 10; i++ {
+		r.request(i, i*2)
+	}
+
+	assert.True(waitFor(func() bool { return len(rec.get()) == 1 }))
+	assert.Equal([]winsize{{height: 10, width: 20}}, rec.get())
+}
+
+func TestPtyResizerInflight(t *testing.T) {
+	assert := assert.New(t)
+
+	rec := &resizeRecorder{release: make(chan struct{})}
+	r := newPtyResizer(time.Millisecond, rec.resize)
+
+	r.request(1, 1)
+	assert.True(waitFor(func() bool {
+		r.Lock()
+		defer r.Unlock()
+		return r.inflight
+	}))
+
+	// The resizes requested while one is in flight wait for it.
+	r.request(2, 2)
+	r.request(3, 3)
+	time.Sleep(10 * time.Millisecond)
+	r.Lock()
+	assert.Nil(r.timer)
+	r.Unlock()
+
+	close(rec.release)
+	assert.True(waitFor(func() bool { return len(rec.get()) == 2 }))
+	assert.Equal([]winsize{{height: 1, width: 1}, {height: 3, width: 3}}, rec.get())
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	sysexec "os/exec"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -117,10 +118,28 @@ type service struct {
 	events     chan interface{}
 	monitor    chan error
 
+	// eventFilter holds the *eventFilter of the forwarded events, set
+	// once the configuration is loaded.
+	eventFilter atomic.Value
+
 	cancel func()
 
 	ec chan exit
 	id string
+
+	// execLimiter bounds the number of running exec processes.
+	execLimiter *execLimiter
+
+	// introspection serves the sandbox state out of the task API.
+	introspection *introspection
+
+	// teardown is the background teardown of the sandbox, nil until the
+	// sandbox container exits.
+	teardown *teardown
+
+	// recoverTried is set once the sandbox of a previous shim process was
+	// looked for.
+	recoverTried bool
 }
 
 func newCommand(ctx context.Context, containerdBinary, id, containerdAddress string) (*sysexec.Cmd, error) {
@@ -220,8 +239,15 @@ func (s *service) StartShim(ctx context.Context, id, containerdBinary, container
 
 func (s *service) forward(publisher events.Publisher) {
 	for e := range s.events {
+		topic := getTopic(e)
+
+		// The filter is only used by this goroutine.
+		if filter, ok := s.eventFilter.Load().(*eventFilter); ok && !filter.allow(topic) {
+			continue
+		}
+
 		ctx, cancel := context.WithTimeout(s.ctx, timeOut)
-		err := publisher.Publish(ctx, getTopic(e), e)
+		err := publisher.Publish(ctx, topic, e)
 		cancel()
 		if err != nil {
 			logrus.WithError(err).Error("post event")
@@ -335,6 +361,24 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Create")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
+	// containerd creates the tasks again when the shim is restarted.
+	if c, err := s.getContainer(r.ID); err == nil {
+		if !c.recovered {
+			return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "container %s", r.ID)
+		}
+
+		return &taskAPI.CreateTaskResponse{
+			Pid: s.pid,
+		}, nil
+	}
+
 	var c *container
 
 	c, err = create(ctx, s, r)
@@ -345,6 +389,7 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 	c.status = task.StatusCreated
 
 	s.containers[r.ID] = c
+	updateContainersMetric(s)
 
 	s.send(&eventstypes.TaskCreate{
 		ContainerID: r.ID,
@@ -374,6 +419,13 @@ func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (_ *taskAP
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Start")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -397,7 +449,7 @@ func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (_ *taskAP
 		//start an exec
 		_, err = startExec(ctx, s, r.ID, r.ExecID)
 		if err != nil {
-			return nil, errdefs.ToGRPC(err)
+			return nil, toGRPC(err)
 		}
 		s.send(&eventstypes.TaskExecStarted{
 			ContainerID: c.id,
@@ -420,6 +472,9 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *task
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Delete")
+	defer span.Finish()
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -467,6 +522,13 @@ func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *p
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Exec")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -500,29 +562,32 @@ func (s *service) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (_
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "ResizePty")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	processID := c.id
+	var execs *exec
 	if r.ExecID != "" {
-		execs, err := c.getExec(r.ExecID)
+		execs, err = c.getExec(r.ExecID)
 		if err != nil {
 			return nil, err
 		}
 		execs.tty.height = r.Height
 		execs.tty.width = r.Width
-
-		processID = execs.id
-
-	}
-	err = s.sandbox.WinsizeProcess(c.id, processID, r.Height, r.Width)
-	if err != nil {
-		return nil, err
 	}
 
-	return empty, err
+	// Resize storms are coalesced, the last size wins.
+	s.getPtyResizer(c, execs).request(r.Height, r.Width)
+
+	return empty, nil
 }
 
 // State returns runtime state information for a process
@@ -582,6 +647,13 @@ func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (_ *ptypes
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Pause")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -616,6 +688,13 @@ func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (_ *ptyp
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Resume")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -648,6 +727,9 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.E
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Kill")
+	defer span.Finish()
+
 	signum := syscall.Signal(r.Signal)
 
 	c, err := s.getContainer(r.ID)
@@ -655,6 +737,12 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.E
 		return nil, err
 	}
 
+	// The containers are stopped along with the sandbox.
+	if s.teardown != nil {
+		logrus.WithField("sandbox", s.sandbox.ID()).WithField("Container", c.id).Debug("Sandbox is being torn down, signal ignored")
+		return empty, nil
+	}
+
 	// According to CRI specs, kubelet will call StopPodSandbox()
 	// at least once before calling RemovePodSandbox, and this call
 	// is idempotent, and must not return an error if all relevant
@@ -718,6 +806,9 @@ func (s *service) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (_ *pt
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "CloseIO")
+	defer span.Finish()
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -759,6 +850,8 @@ func (s *service) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (_ *ta
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	maybeRecoverSandbox(s)
+
 	return &taskAPI.ConnectResponse{
 		ShimPid: s.pid,
 		//Since kata cannot get the container's pid in VM, thus only return the shim's pid.
@@ -776,10 +869,26 @@ func (s *service) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (_ *
 		s.mu.Unlock()
 		return empty, nil
 	}
+	t := s.teardown
 	s.mu.Unlock()
 
+	// The shim exits once the sandbox is torn down.
+	if t != nil {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return empty, ctx.Err()
+		}
+	}
+
+	if s.introspection != nil {
+		s.introspection.stop()
+	}
+
 	s.cancel()
 
+	katautils.StopTracing(s.ctx)
+
 	os.Exit(0)
 
 	// This will never be called, but this is only there to make sure the
@@ -795,12 +904,16 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (_ *taskAP
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	c, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := marshalMetrics(s, c.id)
+	data, err := marshalMetrics(s, c)
 	if err != nil {
 		return nil, err
 	}
@@ -819,6 +932,13 @@ func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (_ *
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	span, ctx := trace(ctx, "Update")
+	defer span.Finish()
+
+	if err := s.checkTeardown(); err != nil {
+		return nil, err
+	}
+
 	var resources *specs.LinuxResources
 	v, err := typeurl.UnmarshalAny(r.Resources)
 	if err != nil {
@@ -903,6 +1023,8 @@ func (s *service) checkProcesses(e exit) {
 }
 
 func (s *service) getContainer(id string) (*container, error) {
+	maybeRecoverSandbox(s)
+
 	c := s.containers[id]
 
 	if c == nil {
@@ -76,7 +76,7 @@ func startContainer(ctx context.Context, s *service, c *container) error {
 	return nil
 }
 
-func startExec(ctx context.Context, s *service, containerID, execID string) (*exec, error) {
+func startExec(ctx context.Context, s *service, containerID, execID string) (_ *exec, err error) {
 	//start an exec
 	c, err := s.getContainer(containerID)
 	if err != nil {
@@ -88,6 +88,17 @@ func startExec(ctx context.Context, s *service, containerID, execID string) (*ex
 		return nil, err
 	}
 
+	limiter := s.getExecLimiter()
+	if err := limiter.acquire(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the slot is released by the wait goroutine once started
+		if err != nil {
+			limiter.release()
+		}
+	}()
+
 	_, proc, err := s.sandbox.EnterContainer(containerID, *execs.cmds)
 	if err != nil {
 		err := fmt.Errorf("cannot enter container %s, with err %s", containerID, err)
@@ -115,7 +126,10 @@ func startExec(ctx context.Context, s *service, containerID, execID string) (*ex
 
 	go ioCopy(execs.exitIOch, tty, stdin, stdout, stderr)
 
-	go wait(s, c, execID)
+	go func() {
+		defer limiter.release()
+		wait(s, c, execID)
+	}()
 
 	return execs, nil
 }
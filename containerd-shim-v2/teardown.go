@@ -0,0 +1,146 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/sirupsen/logrus"
+)
+
+// drainPollInterval is the period the containers are checked at while the
+// sandbox drains.
+var drainPollInterval = 100 * time.Millisecond
+
+// teardown is the background teardown of the sandbox, started when the
+// sandbox container exits.
+type teardown struct {
+	// done is closed once the sandbox is stopped and deleted.
+	done chan struct{}
+
+	drainTimeout time.Duration
+}
+
+// checkTeardown returns an error if the sandbox is being torn down, for the
+// requests reaching it. It must be called with the service lock held.
+func (s *service) checkTeardown() error {
+	if s.teardown != nil {
+		return errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox %s is being torn down", s.id)
+	}
+
+	return nil
+}
+
+// startTeardown tears the sandbox down in the background, so that the exit
+// of the sandbox container is reported without waiting for the VM to stop.
+// It must be called with the service lock held.
+func startTeardown(s *service) {
+	if s.teardown != nil {
+		return
+	}
+
+	t := &teardown{
+		done: make(chan struct{}),
+	}
+	if s.config != nil {
+		t.drainTimeout = time.Duration(s.config.SandboxDrainTimeout) * time.Second
+	}
+	s.teardown = t
+
+	if s.introspection != nil {
+		s.introspection.stop()
+		s.introspection = nil
+	}
+
+	go t.run(s)
+}
+
+func (t *teardown) run(s *service) {
+	defer close(t.done)
+
+	logger := logrus.WithField("sandbox", s.sandbox.ID())
+
+	if t.drainTimeout > 0 {
+		t.drain(s, logger)
+	}
+
+	// The other requests touching the sandbox are refused, so the sandbox
+	// is stopped without the service lock, which the reports of the exits
+	// need.
+	logger.Info("stopping sandbox")
+	if err := s.sandbox.Stop(true); err != nil {
+		logger.WithError(err).Error("failed to stop sandbox")
+	}
+
+	if err := s.sandbox.Delete(); err != nil {
+		logger.WithError(err).Error("failed to delete sandbox")
+	}
+
+	logger.Info("sandbox torn down")
+}
+
+// runningContainers returns the IDs of the containers still running, other
+// than the sandbox container. It must be called with the service lock held.
+func runningContainers(s *service) []string {
+	var ids []string
+	for _, c := range s.containers {
+		if c.cType.IsSandbox() {
+			continue
+		}
+
+		if c.status == task.StatusRunning || c.status == task.StatusPaused {
+			ids = append(ids, c.id)
+		}
+	}
+
+	return ids
+}
+
+// signalContainers sends signum to all the processes of the containers.
+func signalContainers(s *service, ids []string, signum syscall.Signal, logger *logrus.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if err := s.sandbox.SignalProcess(id, id, signum, true); err != nil {
+			logger.WithError(err).WithField("container", id).Warnf("failed to signal container with %v", signum)
+		}
+	}
+}
+
+// drain gives the running containers the drain timeout to exit once
+// signalled with SIGTERM, and kills them afterwards.
+func (t *teardown) drain(s *service, logger *logrus.Entry) {
+	s.mu.Lock()
+	ids := runningContainers(s)
+	s.mu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	logger.WithField("containers", ids).WithField("timeout", t.drainTimeout).Info("draining sandbox")
+	signalContainers(s, ids, syscall.SIGTERM, logger)
+
+	deadline := time.Now().Add(t.drainTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+
+		s.mu.Lock()
+		ids = runningContainers(s)
+		s.mu.Unlock()
+
+		if len(ids) == 0 {
+			return
+		}
+	}
+
+	logger.WithField("containers", ids).Warn("containers did not exit in time, killing them")
+	signalContainers(s, ids, syscall.SIGKILL, logger)
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// signalledSandbox records the signals sent to the containers, and blocks
+// its stop until released.
+type signalledSandbox struct {
+	*vcmock.Sandbox
+
+	sync.Mutex
+	signals map[string][]syscall.Signal
+	release chan struct{}
+}
+
+func (s *signalledSandbox) SignalProcess(containerID, processID string, signal syscall.Signal, all bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.signals[containerID] = append(s.signals[containerID], signal)
+
+	return nil
+}
+
+func (s *signalledSandbox) Stop(force bool) error {
+	<-s.release
+	return nil
+}
+
+func (s *signalledSandbox) containerSignals(id string) []syscall.Signal {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.signals[id]
+}
+
+func newTeardownService(t *testing.T, drainTimeout uint32) (*service, *signalledSandbox) {
+	sandbox := &signalledSandbox{
+		Sandbox: &vcmock.Sandbox{MockID: testSandboxID},
+		signals: make(map[string][]syscall.Signal),
+		release: make(chan struct{}),
+	}
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    sandbox,
+		containers: make(map[string]*container),
+		events:     make(chan interface{}, chSize),
+		config:     &oci.RuntimeConfig{SandboxDrainTimeout: drainTimeout},
+	}
+
+	for _, id := range []string{testSandboxID, testContainerID} {
+		cType := vc.PodContainer
+		if id == testSandboxID {
+			cType = vc.PodSandbox
+		}
+
+		c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: id}, cType, &specs.Spec{})
+		assert.NoError(t, err)
+		c.status = task.StatusRunning
+		s.containers[id] = c
+	}
+
+	return s, sandbox
+}
+
+func TestTeardownDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	savedInterval := drainPollInterval
+	drainPollInterval = time.Millisecond
+	defer func() {
+		drainPollInterval = savedInterval
+	}()
+
+	s, sandbox := newTeardownService(t, 5)
+
+	s.mu.Lock()
+	startTeardown(s)
+	t1 := s.teardown
+	// starting twice is harmless
+	startTeardown(s)
+	assert.Equal(t1, s.teardown)
+	s.mu.Unlock()
+
+	// the container is asked to exit, and exits
+	for len(sandbox.containerSignals(testContainerID)) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.mu.Lock()
+	s.containers[testContainerID].status = task.StatusStopped
+	s.mu.Unlock()
+
+	close(sandbox.release)
+	<-s.teardown.done
+
+	assert.Equal([]syscall.Signal{syscall.SIGTERM}, sandbox.containerSignals(testContainerID))
+	assert.Empty(sandbox.containerSignals(testSandboxID))
+}
+
+func TestTeardownDrainTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	savedInterval := drainPollInterval
+	drainPollInterval = time.Millisecond
+	defer func() {
+		drainPollInterval = savedInterval
+	}()
+
+	// the containers are stopped with the sandbox without a drain timeout
+	s, sandbox := newTeardownService(t, 0)
+	close(sandbox.release)
+
+	s.mu.Lock()
+	startTeardown(s)
+	s.mu.Unlock()
+	<-s.teardown.done
+
+	assert.Empty(sandbox.containerSignals(testContainerID))
+
+	// and killed once it expires otherwise
+	s, sandbox = newTeardownService(t, 0)
+	close(sandbox.release)
+
+	t1 := &teardown{done: make(chan struct{}), drainTimeout: 10 * time.Millisecond}
+	s.teardown = t1
+	t1.run(s)
+
+	assert.Equal([]syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}, sandbox.containerSignals(testContainerID))
+}
+
+func TestTeardownRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	s, sandbox := newTeardownService(t, 0)
+
+	s.mu.Lock()
+	startTeardown(s)
+	s.mu.Unlock()
+
+	ctx := context.Background()
+
+	// the requests reaching the sandbox are refused while it is torn down
+	_, err := s.Start(ctx, &taskAPI.StartRequest{ID: testContainerID})
+	assert.True(errdefs.IsFailedPrecondition(errdefs.FromGRPC(err)))
+
+	_, err = s.Pause(ctx, &taskAPI.PauseRequest{ID: testContainerID})
+	assert.True(errdefs.IsFailedPrecondition(errdefs.FromGRPC(err)))
+
+	// the signals are ignored
+	_, err = s.Kill(ctx, &taskAPI.KillRequest{ID: testContainerID, Signal: uint32(syscall.SIGKILL)})
+	assert.NoError(err)
+	assert.Empty(sandbox.containerSignals(testContainerID))
+
+	// and the container is deleted with the sandbox
+	_, err = s.Delete(ctx, &taskAPI.DeleteRequest{ID: testContainerID})
+	assert.NoError(err)
+	assert.NotContains(s.containers, testContainerID)
+
+	// the shim waits for the teardown to shut down
+	delete(s.containers, testSandboxID)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.Shutdown(ctx, &taskAPI.ShutdownRequest{ID: testSandboxID})
+	assert.Error(err)
+
+	close(sandbox.release)
+	<-s.teardown.done
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/ttrpc"
+	"github.com/kata-containers/runtime/pkg/katautils"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+)
+
+var tracerOnce sync.Once
+
+// startTracing creates the tracer of the shim once the runtime
+// configuration enabling it is loaded.
+func startTracing(config *oci.RuntimeConfig) {
+	if config == nil || !config.Trace {
+		return
+	}
+
+	tracerOnce.Do(func() {
+		if _, err := katautils.CreateTracer("kata-shim-v2"); err != nil {
+			logrus.WithError(err).Warn("failed to create the tracer")
+		}
+	})
+}
+
+// trace starts the span of the task request name, child of the span of
+// containerd when the ttrpc metadata of ctx carry its trace context. The
+// span is only carried by the returned context of the request: the spans of
+// the sandbox, whose context outlives the requests, are children of the
+// span of the request that created it.
+func trace(ctx context.Context, name string) (opentracing.Span, context.Context) {
+	var opts []opentracing.StartSpanOption
+	if traceParent, ok := ttrpc.GetMetadataValue(ctx, tracing.TraceParentKey); ok {
+		if parent := tracing.Extract(traceParent); parent != nil {
+			opts = append(opts, opentracing.ChildOf(parent))
+		}
+	}
+
+	span := opentracing.StartSpan(name, opts...)
+
+	span.SetTag("source", "runtime")
+	span.SetTag("component", "shimv2")
+
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// sandboxContext returns the context of the sandbox, which outlives the
+// request ctx, with the span of the request.
+func sandboxContext(s *service, ctx context.Context) context.Context {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return opentracing.ContextWithSpan(s.ctx, span)
+	}
+
+	return s.ctx
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestServiceTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Extractor(tracing.TraceContext, tracing.Propagator{}))
+	defer closer.Close()
+
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	s := &service{
+		ctx: context.Background(),
+	}
+
+	traceParent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := ttrpc.WithMetadata(context.Background(), ttrpc.MD{
+		tracing.TraceParentKey: []string{traceParent},
+	})
+
+	span, ctx := trace(ctx, "Create")
+	assert.Equal(span, opentracing.SpanFromContext(ctx))
+	assert.Equal(span, opentracing.SpanFromContext(sandboxContext(s, ctx)))
+
+	// The long-lived context of the shim does not carry the span.
+	assert.Nil(opentracing.SpanFromContext(s.ctx))
+
+	// The span of the request is the child of the span of containerd.
+	sc := span.Context().(jaeger.SpanContext)
+	assert.Equal(jaeger.TraceID{High: 0x0af7651916cd43dd, Low: 0x8448eb211c80319c}, sc.TraceID())
+	assert.Equal(jaeger.SpanID(0xb7ad6b7169203331), sc.ParentID())
+
+	// The spans of the sandbox it creates are children of the span of the
+	// request.
+	child, _ := opentracing.StartSpanFromContext(sandboxContext(s, ctx), "createSandbox")
+	assert.Equal(sc.SpanID(), child.Context().(jaeger.SpanContext).ParentID())
+	span.Finish()
+
+	// Without trace context, the request starts a trace.
+	span, _ = trace(context.Background(), "Start")
+	assert.Equal(jaeger.SpanID(0), span.Context().(jaeger.SpanContext).ParentID())
+	span.Finish()
+}
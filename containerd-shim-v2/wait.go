@@ -7,10 +7,14 @@ package containerdshim
 
 import (
 	"path"
+	"syscall"
 	"time"
 
+	eventstypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/mount"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -47,6 +51,13 @@ func wait(s *service, c *container, execID string) (int32, error) {
 
 	s.mu.Lock()
 	if execID == "" {
+		// The sandbox watcher already reported the exit of this
+		// container after a guest failure.
+		if c.status == task.StatusStopped {
+			s.mu.Unlock()
+			return int32(c.exit), nil
+		}
+
 		// Take care of the use case where it is a sandbox.
 		// Right after the container representing the sandbox has
 		// been deleted, let's make sure we stop and delete the
@@ -57,14 +68,8 @@ func wait(s *service, c *container, execID string) (int32, error) {
 			if s.monitor != nil {
 				s.monitor <- nil
 			}
-			if err = s.sandbox.Stop(true); err != nil {
-				logrus.WithField("sandbox", s.sandbox.ID()).Error("failed to stop sandbox")
-			}
-
-			if err = s.sandbox.Delete(); err != nil {
-				logrus.WithField("sandbox", s.sandbox.ID()).Error("failed to delete sandbox")
-			}
-		} else {
+			startTeardown(s)
+		} else if s.teardown == nil {
 			if _, err = s.sandbox.StopContainer(c.id, false); err != nil {
 				logrus.WithError(err).WithField("container", c.id).Warn("stop container failed")
 			}
@@ -76,6 +81,11 @@ func wait(s *service, c *container, execID string) (int32, error) {
 		c.exitCh <- uint32(ret)
 
 	} else {
+		if execs.status == task.StatusStopped {
+			s.mu.Unlock()
+			return execs.exitCode, nil
+		}
+
 		execs.status = task.StatusStopped
 		execs.exitCode = ret
 		execs.exitTime = timeStamp
@@ -89,6 +99,46 @@ func wait(s *service, c *container, execID string) (int32, error) {
 	return ret, nil
 }
 
+// exitCodeKilled is the exit status of the processes killed by SIGKILL, as
+// reported for the ones of the containers of a sandbox whose hypervisor was
+// killed.
+const exitCodeKilled = 128 + int(syscall.SIGKILL)
+
+// sandboxFailure is how the shim acts on a failure reported by the sandbox
+// monitor.
+type sandboxFailure struct {
+	name string
+
+	// exitCode is reported for the processes of the containers.
+	exitCode int
+
+	// oom reports the containers as killed by the OOM killer.
+	oom bool
+}
+
+// classifySandboxFailure returns how the shim acts on the error reported by
+// the sandbox monitor.
+func classifySandboxFailure(err error) sandboxFailure {
+	switch errors.Cause(err) {
+	case vc.ErrVMMOOMKilled:
+		// The host OOM killer killed the processes of the guest
+		// along with the hypervisor.
+		return sandboxFailure{name: "vmm-oom-killed", exitCode: exitCodeKilled, oom: true}
+	case vc.ErrVMMDead:
+		return sandboxFailure{name: "vmm-dead", exitCode: exitCode255}
+	case vc.ErrGuestPanicked:
+		return sandboxFailure{name: "guest-panicked", exitCode: exitCode255}
+	case vc.ErrHypervisorError:
+		return sandboxFailure{name: "hypervisor-error", exitCode: exitCode255}
+	case vc.ErrAgentUnresponsive:
+		// The processes may still be running in the guest, they
+		// are killed along with the hypervisor.
+		return sandboxFailure{name: "agent-unresponsive", exitCode: exitCodeKilled}
+	}
+
+	return sandboxFailure{name: "unknown", exitCode: exitCode255}
+}
+
 func watchSandbox(s *service) {
 	if s.monitor == nil {
 		return
@@ -102,7 +152,8 @@ func watchSandbox(s *service) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	// sandbox malfunctioning, cleanup as much as we can
-	logrus.WithError(err).Warn("sandbox stopped unexpectedly")
+	failure := classifySandboxFailure(err)
+	logrus.WithError(err).WithField("failure", failure.name).Warn("sandbox stopped unexpectedly")
 	err = s.sandbox.Stop(true)
 	if err != nil {
 		logrus.WithError(err).Warn("stop sandbox failed")
@@ -122,6 +173,38 @@ func watchSandbox(s *service) {
 		}
 	}
 
-	// Existing container/exec will be cleaned up by its waiters.
-	// No need to send async events here.
+	// The guest is gone, so the processes will never report their exit
+	// status: report them as failed so that containerd does not wait
+	// on orphaned tasks. Their waiters will not send any other event.
+	timeStamp := time.Now()
+	for _, c := range s.containers {
+		for execID, execs := range c.execs {
+			if execs.status != task.StatusRunning {
+				continue
+			}
+			execs.status = task.StatusStopped
+			execs.exitCode = int32(failure.exitCode)
+			execs.exitTime = timeStamp
+			execs.exitCh <- uint32(failure.exitCode)
+
+			go cReap(s, failure.exitCode, c.id, execID, timeStamp)
+		}
+
+		if c.status != task.StatusRunning && c.status != task.StatusPaused {
+			continue
+		}
+
+		// Let the container managers report the containers as OOM
+		// killed, before they exit.
+		if failure.oom {
+			s.send(&eventstypes.TaskOOM{ContainerID: c.id})
+		}
+
+		c.status = task.StatusStopped
+		c.exit = uint32(failure.exitCode)
+		c.exitTime = timeStamp
+		c.exitCh <- uint32(failure.exitCode)
+
+		go cReap(s, failure.exitCode, c.id, "", timeStamp)
+	}
 }
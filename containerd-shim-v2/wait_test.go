@@ -0,0 +1,92 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package containerdshim
+
+import (
+	"testing"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/api/types/task"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifySandboxFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(sandboxFailure{"vmm-oom-killed", exitCodeKilled, true}, classifySandboxFailure(errors.Wrap(vc.ErrVMMOOMKilled, "qemu")))
+	assert.Equal(sandboxFailure{"vmm-dead", exitCode255, false}, classifySandboxFailure(errors.Wrap(vc.ErrVMMDead, "qemu")))
+	assert.Equal(sandboxFailure{"guest-panicked", exitCode255, false}, classifySandboxFailure(errors.Wrap(vc.ErrGuestPanicked, "guest failure")))
+	assert.Equal(sandboxFailure{"hypervisor-error", exitCode255, false}, classifySandboxFailure(vc.ErrHypervisorError))
+	assert.Equal(sandboxFailure{"agent-unresponsive", exitCodeKilled, false}, classifySandboxFailure(errors.Wrap(vc.ErrAgentUnresponsive, "timeout")))
+	assert.Equal(sandboxFailure{"unknown", exitCode255, false}, classifySandboxFailure(errors.New("guest failure")))
+}
+
+func TestWatchSandboxGuestFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		ec:         make(chan exit, bufferSize),
+		monitor:    make(chan error, 1),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testSandboxID}, vc.PodSandbox, nil)
+	assert.NoError(err)
+	c.status = task.StatusRunning
+	c.execs[testContainerID] = &exec{
+		status: task.StatusRunning,
+		exitCh: make(chan uint32, 1),
+	}
+	s.containers[testSandboxID] = c
+
+	s.monitor <- errors.New("guest failure")
+	watchSandbox(s)
+
+	assert.Equal(task.StatusStopped, c.status)
+	assert.Equal(uint32(exitCode255), <-c.exitCh)
+	assert.Equal(task.StatusStopped, c.execs[testContainerID].status)
+	assert.Equal(uint32(exitCode255), <-c.execs[testContainerID].exitCh)
+
+	for i := 0; i < 2; i++ {
+		e := <-s.ec
+		assert.Equal(testSandboxID, e.id)
+		assert.Equal(exitCode255, e.status)
+	}
+}
+
+func TestWatchSandboxOOMKilled(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{
+		id:         testSandboxID,
+		sandbox:    &vcmock.Sandbox{MockID: testSandboxID},
+		containers: make(map[string]*container),
+		events:     make(chan interface{}, 1),
+		ec:         make(chan exit, bufferSize),
+		monitor:    make(chan error, 1),
+	}
+
+	c, err := newContainer(s, &taskAPI.CreateTaskRequest{ID: testSandboxID}, vc.PodSandbox, nil)
+	assert.NoError(err)
+	c.status = task.StatusRunning
+	s.containers[testSandboxID] = c
+
+	s.monitor <- errors.Wrap(vc.ErrVMMOOMKilled, "failed to ping hypervisor process")
+	watchSandbox(s)
+
+	assert.Equal(&eventstypes.TaskOOM{ContainerID: testSandboxID}, <-s.events)
+	assert.Equal(uint32(exitCodeKilled), <-c.exitCh)
+
+	e := <-s.ec
+	assert.Equal(testSandboxID, e.id)
+	assert.Equal(exitCodeKilled, e.status)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+)
+
+const monitorName = "kata-monitor"
+
+// version is the monitor version. This variable is populated at build time.
+var version = "unknown"
+
+var monitorLog = logrus.New()
+
+type monitorParams struct {
+	listenAddress string
+	runtimeRoot   string
+	scrapeTimeout time.Duration
+	logLevel      string
+}
+
+const componentDescription = `is a node level daemon which finds the shims of the running sandboxes
+from their introspection sockets. It serves the metrics of all the shims,
+labelled with their sandbox, on a single Prometheus endpoint, and proxies
+the profiles of the individual shims.
+`
+
+func parseOptions() monitorParams {
+	var showVersion, help bool
+
+	params := monitorParams{}
+
+	flag.BoolVar(&help, "h", false, "describe component usage")
+	flag.BoolVar(&help, "help", false, "")
+	flag.BoolVar(&showVersion, "v", false, "display program version and exit")
+	flag.BoolVar(&showVersion, "version", false, "")
+	flag.StringVar(&params.listenAddress, "listen-address", "127.0.0.1:8090", "address the HTTP endpoint listens on")
+	flag.StringVar(&params.runtimeRoot, "runtime-root", store.RunStoragePath,
+		"runtime directory of the sandboxes, set from run_root_path in the runtime configuration")
+	flag.DurationVar(&params.scrapeTimeout, "scrape-timeout", 5*time.Second, "timeout of the metrics requests to each shim")
+	flag.StringVar(&params.logLevel, "log", "warn",
+		"log messages above specified level: debug, warn, error, fatal or panic")
+
+	flag.Parse()
+
+	if help {
+		fmt.Printf("\n%s %s\n", monitorName, componentDescription)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("%s version %s\n", monitorName, version)
+		os.Exit(0)
+	}
+
+	return params
+}
+
+func main() {
+	params := parseOptions()
+
+	level, err := logrus.ParseLevel(params.logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	monitorLog.SetLevel(level)
+	monitorLog.Formatter = &logrus.TextFormatter{TimestampFormat: time.RFC3339Nano}
+
+	km := newKataMonitor(params.runtimeRoot, params.scrapeTimeout)
+
+	monitorLog.WithFields(logrus.Fields{
+		"version":        version,
+		"listen-address": params.listenAddress,
+		"runtime-root":   params.runtimeRoot,
+	}).Info("announce")
+
+	if err := http.ListenAndServe(params.listenAddress, km.handler()); err != nil {
+		monitorLog.WithError(err).Fatal("failed to serve")
+	}
+}
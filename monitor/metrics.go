@@ -0,0 +1,92 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// sandboxLabel is the label of the metrics of the shims naming their
+// sandbox.
+const sandboxLabel = "sandbox"
+
+// aggregator merges the metrics of the shims, written in the Prometheus
+// text format, into a single exposition where each metric is labelled with
+// the sandbox it comes from.
+type aggregator struct {
+	families map[string]*dto.MetricFamily
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		families: make(map[string]*dto.MetricFamily),
+	}
+}
+
+// add parses the metrics of the shim of the sandbox sandboxID. The first
+// shim defines the help and the type of a family, the metrics of another
+// type are not merged.
+func (a *aggregator) add(sandboxID string, r io.Reader) error {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return err
+	}
+
+	var mismatched []string
+	for name, f := range families {
+		for _, m := range f.Metric {
+			label := &dto.LabelPair{
+				Name:  proto.String(sandboxLabel),
+				Value: proto.String(sandboxID),
+			}
+			m.Label = append([]*dto.LabelPair{label}, m.Label...)
+		}
+
+		merged, ok := a.families[name]
+		if !ok {
+			a.families[name] = f
+			continue
+		}
+
+		if merged.GetType() != f.GetType() {
+			mismatched = append(mismatched, name)
+			continue
+		}
+
+		merged.Metric = append(merged.Metric, f.Metric...)
+	}
+
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("Mismatched type of the metrics %v", mismatched)
+	}
+
+	return nil
+}
+
+// write writes the aggregated metrics, sorted by family name.
+func (a *aggregator) write(w io.Writer) error {
+	var names []string
+	for name := range a.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := expfmt.MetricFamilyToText(w, a.families[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testShimMetrics = `# HELP kata_shim_containers Number of the containers.
+# TYPE kata_shim_containers gauge
+kata_shim_containers 2
+# HELP kata_agent_rpc_duration_seconds Duration of the RPCs.
+# TYPE kata_agent_rpc_duration_seconds histogram
+kata_agent_rpc_duration_seconds_bucket{request="grpc.CheckRequest",le="+Inf"} 3
+kata_agent_rpc_duration_seconds_sum{request="grpc.CheckRequest"} 0.25
+kata_agent_rpc_duration_seconds_count{request="grpc.CheckRequest"} 3
+`
+
+func TestAggregator(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newAggregator()
+	assert.NoError(a.add("sandbox-b", strings.NewReader(testShimMetrics)))
+	assert.NoError(a.add("sandbox-a", strings.NewReader("# TYPE kata_shim_containers gauge\nkata_shim_containers{} 1\nuntyped_metric 4\n")))
+
+	assert.Error(a.add("sandbox-c", strings.NewReader("{} 1\n")))
+	assert.Error(a.add("sandbox-d", strings.NewReader("# TYPE kata_shim_containers counter\nkata_shim_containers 3\n")))
+
+	var buf bytes.Buffer
+	assert.NoError(a.write(&buf))
+	assert.Equal(`# HELP kata_agent_rpc_duration_seconds Duration of the RPCs.
+# TYPE kata_agent_rpc_duration_seconds histogram
+kata_agent_rpc_duration_seconds_bucket{sandbox="sandbox-b",request="grpc.CheckRequest",le="+Inf"} 3
+kata_agent_rpc_duration_seconds_sum{sandbox="sandbox-b",request="grpc.CheckRequest"} 0.25
+kata_agent_rpc_duration_seconds_count{sandbox="sandbox-b",request="grpc.CheckRequest"} 3
+# HELP kata_shim_containers Number of the containers.
+# TYPE kata_shim_containers gauge
+kata_shim_containers{sandbox="sandbox-b"} 2
+kata_shim_containers{sandbox="sandbox-a"} 1
+# TYPE untyped_metric untyped
+untyped_metric{sandbox="sandbox-a"} 4
+`, buf.String())
+}
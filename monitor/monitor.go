@@ -0,0 +1,275 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// shimSocket is the introspection socket of the shims, in the runtime
+// directory of their sandbox.
+const shimSocket = "introspect.sock"
+
+// kataMonitor discovers the shims of the node from their introspection
+// sockets and serves:
+//
+//   GET /metrics                          the metrics of the monitor and of
+//                                         all the shims, labelled with their
+//                                         sandbox
+//   GET /sandboxes                        lists the sandboxes found
+//   GET /sandboxes/ID/debug/pprof/...     proxies the profiles of the shim
+//                                         of the sandbox ID
+//
+// The shims serve their metrics when enable_metrics is set, and their
+// profiles when enable_debug_introspection is.
+type kataMonitor struct {
+	runtimeRoot   string
+	scrapeTimeout time.Duration
+
+	registry       *prometheus.Registry
+	sandboxes      prometheus.Gauge
+	scrapeFailures prometheus.Counter
+	scrapeDuration prometheus.Histogram
+}
+
+func newKataMonitor(runtimeRoot string, scrapeTimeout time.Duration) *kataMonitor {
+	km := &kataMonitor{
+		runtimeRoot:   runtimeRoot,
+		scrapeTimeout: scrapeTimeout,
+		registry:      prometheus.NewRegistry(),
+		sandboxes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kata_monitor_sandboxes",
+			Help: "Number of the sandboxes found on the node.",
+		}),
+		scrapeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kata_monitor_scrape_failures_total",
+			Help: "Number of the shims whose metrics could not be read.",
+		}),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "kata_monitor_scrape_duration_seconds",
+			Help: "Duration of the scrapes of all the shims.",
+		}),
+	}
+
+	km.registry.MustRegister(km.sandboxes, km.scrapeFailures, km.scrapeDuration)
+
+	return km
+}
+
+func (km *kataMonitor) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", km.serveMetrics)
+	mux.HandleFunc("/sandboxes", km.listSandboxes)
+	mux.HandleFunc("/sandboxes/", km.proxyPprof)
+
+	return mux
+}
+
+func (km *kataMonitor) socketPath(sandboxID string) string {
+	return filepath.Join(km.runtimeRoot, sandboxID, shimSocket)
+}
+
+// listSandboxIDs returns the sandboxes whose shim has an introspection
+// socket, sorted.
+func (km *kataMonitor) listSandboxIDs() ([]string, error) {
+	entries, err := ioutil.ReadDir(km.runtimeRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		info, err := os.Stat(km.socketPath(entry.Name()))
+		if err != nil || info.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+
+		ids = append(ids, entry.Name())
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// shimTransport returns the transport of the requests to the shim of the
+// sandbox, whatever the host of their URL.
+func (km *kataMonitor) shimTransport(sandboxID string) *http.Transport {
+	path := km.socketPath(sandboxID)
+
+	return &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
+
+// scrape returns the metrics of the shim of the sandbox.
+func (km *kataMonitor) scrape(ctx context.Context, sandboxID string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, km.scrapeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, "http://shim/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: km.shimTransport(sandboxID)}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics of the shim: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (km *kataMonitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, err := km.listSandboxIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	km.sandboxes.Set(float64(len(ids)))
+
+	start := time.Now()
+
+	// The shims are scraped in parallel, their metrics are aggregated in
+	// the order of the sandboxes.
+	results := make([][]byte, len(ids))
+	var wg sync.WaitGroup
+	for n, id := range ids {
+		wg.Add(1)
+		go func(n int, id string) {
+			defer wg.Done()
+
+			data, err := km.scrape(r.Context(), id)
+			if err != nil {
+				monitorLog.WithError(err).WithField("sandbox", id).Warn("failed to scrape the shim")
+				km.scrapeFailures.Inc()
+				return
+			}
+			results[n] = data
+		}(n, id)
+	}
+	wg.Wait()
+
+	km.scrapeDuration.Observe(time.Since(start).Seconds())
+
+	a := newAggregator()
+	for n, id := range ids {
+		if results[n] == nil {
+			continue
+		}
+
+		if err := a.add(id, bytes.NewReader(results[n])); err != nil {
+			monitorLog.WithError(err).WithField("sandbox", id).Warn("invalid metrics of the shim")
+			km.scrapeFailures.Inc()
+		}
+	}
+
+	families, err := km.registry.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	for _, f := range families {
+		if _, err := expfmt.MetricFamilyToText(w, f); err != nil {
+			monitorLog.WithError(err).Warn("failed to send the monitor metrics")
+			return
+		}
+	}
+	if err := a.write(w); err != nil {
+		monitorLog.WithError(err).Warn("failed to send the shim metrics")
+	}
+}
+
+func (km *kataMonitor) listSandboxes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, err := km.listSandboxIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if ids == nil {
+		ids = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ids); err != nil {
+		monitorLog.WithError(err).Warn("failed to send the sandboxes")
+	}
+}
+
+// proxyPprof forwards /sandboxes/ID/debug/pprof/... to the shim of the
+// sandbox ID.
+func (km *kataMonitor) proxyPprof(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/sandboxes/"), "/", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "debug/pprof/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := parts[0]
+	if id == "" || id == "." || id == ".." {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, err := os.Stat(km.socketPath(id)); err != nil {
+		http.Error(w, fmt.Sprintf("sandbox %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "shim"})
+	proxy.Transport = km.shimTransport(id)
+
+	r.URL.Path = "/" + parts[1]
+	r.URL.RawPath = ""
+	proxy.ServeHTTP(w, r)
+}
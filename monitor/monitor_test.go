@@ -0,0 +1,102 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestShim serves the introspection socket of a fake shim.
+func startTestShim(t *testing.T, runtimeRoot, sandboxID string, handler http.Handler) net.Listener {
+	dir := filepath.Join(runtimeRoot, sandboxID)
+	assert.NoError(t, os.MkdirAll(dir, 0750))
+
+	l, err := net.Listen("unix", filepath.Join(dir, shimSocket))
+	assert.NoError(t, err)
+
+	go http.Serve(l, handler)
+
+	return l
+}
+
+func TestKataMonitor(t *testing.T) {
+	assert := assert.New(t)
+
+	runtimeRoot, err := ioutil.TempDir("", "kata-monitor")
+	assert.NoError(err)
+	defer os.RemoveAll(runtimeRoot)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testShimMetrics))
+	})
+	mux.HandleFunc("/debug/pprof/goroutine", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("goroutine profile debug=" + r.URL.Query().Get("debug")))
+	})
+	l := startTestShim(t, runtimeRoot, "sandbox-a", mux)
+	defer l.Close()
+
+	// a shim not serving its metrics
+	l = startTestShim(t, runtimeRoot, "sandbox-b", http.NotFoundHandler())
+	defer l.Close()
+
+	// a sandbox without shim
+	assert.NoError(os.MkdirAll(filepath.Join(runtimeRoot, "sandbox-c"), 0750))
+
+	km := newKataMonitor(runtimeRoot, time.Second)
+	handler := km.handler()
+
+	ids, err := km.listSandboxIDs()
+	assert.NoError(err)
+	assert.Equal([]string{"sandbox-a", "sandbox-b"}, ids)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sandboxes", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`["sandbox-a","sandbox-b"]`, strings.TrimSpace(w.Body.String()))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "kata_monitor_sandboxes 2\n")
+	assert.Contains(w.Body.String(), "kata_monitor_scrape_failures_total 1\n")
+	assert.Contains(w.Body.String(), `kata_shim_containers{sandbox="sandbox-a"} 2`+"\n")
+	assert.NotContains(w.Body.String(), `sandbox="sandbox-b"`)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	// pprof proxy
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sandboxes/sandbox-a/debug/pprof/goroutine?debug=2", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("goroutine profile debug=2", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sandboxes/sandbox-c/debug/pprof/goroutine", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sandboxes/sandbox-a/metrics", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// no runtime directory yet
+	km = newKataMonitor(filepath.Join(runtimeRoot, "missing"), time.Second)
+	ids, err = km.listSandboxIDs()
+	assert.NoError(err)
+	assert.Empty(ids)
+}
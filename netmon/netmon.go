@@ -51,8 +51,8 @@ var (
 	// version is the netmon version. This variable is populated at build time.
 	version = "unknown"
 
-	// For simplicity the code will only focus on IPv4 addresses for now.
-	netlinkFamily = netlink.FAMILY_V4
+	// Both IPv4 and IPv6 addresses and routes are monitored.
+	netlinkFamily = netlink.FAMILY_ALL
 
 	storageParentPath = "/var/run/kata-containers/netmon/sbs"
 )
@@ -272,10 +272,20 @@ func convertInterface(linkAttrs *netlink.LinkAttrs, linkType string, addrs []net
 			continue
 		}
 
+		// IPv6 link-local addresses are generated by the guest
+		// kernel itself.
+		family := netlink.FAMILY_V4
+		if addr.IP.To4() == nil {
+			if addr.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			family = netlink.FAMILY_V6
+		}
+
 		netMask, _ := addr.Mask.Size()
 
 		ipAddr := &vcTypes.IPAddress{
-			Family:  netlinkFamily,
+			Family:  family,
 			Address: addr.IP.String(),
 			Mask:    fmt.Sprintf("%d", netMask),
 		}
@@ -303,35 +313,26 @@ func convertInterface(linkAttrs *netlink.LinkAttrs, linkType string, addrs []net
 func convertRoutes(netRoutes []netlink.Route) []vcTypes.Route {
 	var routes []vcTypes.Route
 
-	// Ignore routes with IPv6 addresses as this is not supported
-	// by Kata yet.
 	for _, netRoute := range netRoutes {
-		dst := ""
-
 		if netRoute.Protocol == unix.RTPROT_KERNEL {
 			continue
 		}
 
+		dst := ""
 		if netRoute.Dst != nil {
-			if netRoute.Dst.IP.To4() != nil {
-				dst = netRoute.Dst.String()
-			} else {
-				netmonLog.WithField("destination", netRoute.Dst.IP.String()).Warn("Not IPv4 format")
-			}
+			dst = netRoute.Dst.String()
 		}
 
 		src := ""
-		if netRoute.Src.To4() != nil {
+		if netRoute.Src != nil {
 			src = netRoute.Src.String()
-		} else {
-			netmonLog.WithField("source", netRoute.Src.String()).Warn("Not IPv4 format")
 		}
 
+		// An IPv6 gateway can be link-local, such as the default
+		// route going through fe80::1.
 		gw := ""
-		if netRoute.Gw.To4() != nil {
+		if netRoute.Gw != nil {
 			gw = netRoute.Gw.String()
-		} else {
-			netmonLog.WithField("gateway", netRoute.Gw.String()).Warn("Not IPv4 format")
 		}
 
 		dev := ""
@@ -36,7 +36,9 @@ const (
 	testMTU                = 12345
 	testHwAddr             = "02:00:ca:fe:00:48"
 	testIPAddress          = "192.168.0.15"
+	testIPv6Address        = "fd00::15"
 	testIPAddressWithMask  = "192.168.0.15/32"
+	testIPv6Prefix         = "fd00::/64"
 	testScope              = 1
 	testTxQLen             = -1
 	testIfaceIndex         = 5
@@ -186,7 +188,7 @@ func TestConvertInterface(t *testing.T) {
 		HwAddr: testHwAddr,
 		IPAddresses: []*vcTypes.IPAddress{
 			{
-				Family:  netlinkFamily,
+				Family:  netlink.FAMILY_V4,
 				Address: testIPAddress,
 				Mask:    "0",
 			},
@@ -228,6 +230,60 @@ func TestConvertRoutes(t *testing.T) {
 		"Got %+v\nExpected %+v", got, expected)
 }
 
+func TestConvertInterfaceDualStack(t *testing.T) {
+	addrs := []netlink.Addr{
+		{IPNet: &net.IPNet{IP: net.ParseIP(testIPAddress), Mask: net.CIDRMask(24, 32)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP(testIPv6Address), Mask: net.CIDRMask(64, 128)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)}},
+	}
+
+	expected := []*vcTypes.IPAddress{
+		{Family: netlink.FAMILY_V4, Address: testIPAddress, Mask: "24"},
+		{Family: netlink.FAMILY_V6, Address: testIPv6Address, Mask: "64"},
+	}
+
+	got := convertInterface(&netlink.LinkAttrs{Name: testIfaceName}, "", addrs)
+	assert.Equal(t, expected, got.IPAddresses)
+}
+
+func TestConvertRoutesIPv6(t *testing.T) {
+	_, dst, err := net.ParseCIDR(testIPv6Prefix)
+	assert.Nil(t, err)
+
+	routes := []netlink.Route{
+		// default route through a link-local gateway
+		{
+			Gw:        net.ParseIP("fe80::1"),
+			LinkIndex: -1,
+		},
+		{
+			Dst:       dst,
+			Src:       net.ParseIP(testIPv6Address),
+			Gw:        net.ParseIP("fd00::1"),
+			LinkIndex: -1,
+		},
+		{
+			Dst:       dst,
+			LinkIndex: -1,
+			Protocol:  unix.RTPROT_KERNEL,
+		},
+	}
+
+	expected := []vcTypes.Route{
+		{
+			Gateway: "fe80::1",
+		},
+		{
+			Dest:    dst.String(),
+			Gateway: "fd00::1",
+			Source:  testIPv6Address,
+		},
+	}
+
+	got := convertRoutes(routes)
+	assert.Equal(t, expected, got)
+}
+
 type testTeardownNetwork func()
 
 func testSetupNetwork(t *testing.T) testTeardownNetwork {
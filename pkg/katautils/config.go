@@ -10,8 +10,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	goruntime "runtime"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	vc "github.com/kata-containers/runtime/virtcontainers"
@@ -51,6 +54,7 @@ const (
 	firecrackerHypervisorTableType = "firecracker"
 	qemuHypervisorTableType        = "qemu"
 	acrnHypervisorTableType        = "acrn"
+	mockHypervisorTableType        = "mock"
 
 	// supported proxy component types
 	kataProxyTableType = "kata"
@@ -60,9 +64,14 @@ const (
 
 	// supported agent component types
 	kataAgentTableType = "kata"
+	noopAgentTableType = "noop"
 
 	// the maximum amount of PCI bridges that can be cold plugged in a VM
 	maxPCIBridges uint32 = 5
+
+	// the maximum amount of PCI bridges a VM can grow up to by hot
+	// plugging them
+	maxHotplugPCIBridges uint32 = 16
 )
 
 type tomlConfig struct {
@@ -73,6 +82,8 @@ type tomlConfig struct {
 	Runtime    runtime
 	Factory    factory
 	Netmon     netmon
+	Autoscale  autoscale
+	Sizing     sizing
 }
 
 type factory struct {
@@ -85,6 +96,7 @@ type factory struct {
 type hypervisor struct {
 	Path                    string   `toml:"path"`
 	JailerPath              string   `toml:"jailer_path"`
+	JailerChrootBase        string   `toml:"jailer_chroot_base"`
 	Kernel                  string   `toml:"kernel"`
 	CtlPath                 string   `toml:"ctlpath"`
 	Initrd                  string   `toml:"initrd"`
@@ -99,6 +111,7 @@ type hypervisor struct {
 	VirtioFSDaemon          string   `toml:"virtio_fs_daemon"`
 	VirtioFSCache           string   `toml:"virtio_fs_cache"`
 	VirtioFSExtraArgs       []string `toml:"virtio_fs_extra_args"`
+	VirtioFSVolumePaths     []string `toml:"virtio_fs_volume_paths"`
 	VirtioFSCacheSize       uint32   `toml:"virtio_fs_cache_size"`
 	BlockDeviceCacheSet     bool     `toml:"block_device_cache_set"`
 	BlockDeviceCacheDirect  bool     `toml:"block_device_cache_direct"`
@@ -109,10 +122,14 @@ type hypervisor struct {
 	MemSlots                uint32   `toml:"memory_slots"`
 	MemOffset               uint32   `toml:"memory_offset"`
 	DefaultBridges          uint32   `toml:"default_bridges"`
+	MaxBridges              uint32   `toml:"max_bridges"`
 	Msize9p                 uint32   `toml:"msize_9p"`
 	DisableBlockDeviceUse   bool     `toml:"disable_block_device_use"`
 	MemPrealloc             bool     `toml:"enable_mem_prealloc"`
+	MemPreallocAsync        bool     `toml:"enable_mem_prealloc_async"`
 	HugePages               bool     `toml:"enable_hugepages"`
+	HugePageSize            string   `toml:"hugepage_size"`
+	HugePagesPath           string   `toml:"hugepages_path"`
 	FileBackedMemRootDir    string   `toml:"file_mem_backend"`
 	Swap                    bool     `toml:"enable_swap"`
 	Debug                   bool     `toml:"enable_debug"`
@@ -121,7 +138,33 @@ type hypervisor struct {
 	UseVSock                bool     `toml:"use_vsock"`
 	HotplugVFIOOnRootBus    bool     `toml:"hotplug_vfio_on_root_bus"`
 	DisableVhostNet         bool     `toml:"disable_vhost_net"`
+	NetworkMaxQueues        uint32   `toml:"network_max_queues"`
 	GuestHookPath           string   `toml:"guest_hook_path"`
+	OOMScoreAdj             int      `toml:"oom_score_adj"`
+	PinVCPUs                bool     `toml:"enable_vcpu_pinning"`
+	VCPUCPUSet              string   `toml:"vcpu_cpuset"`
+	KSMMode                 string   `toml:"ksm_mode"`
+	AgentHealthCheck        bool     `toml:"enable_agent_health_check"`
+	LogMaxSize              uint32   `toml:"log_max_size"`
+	LogReplayMaxSize        uint32   `toml:"log_replay_max_size"`
+	LogScrubParams          []string `toml:"log_scrub_kernel_params"`
+	HostMemoryCap           bool     `toml:"enable_host_memory_cap"`
+	HostMemoryCapOverhead   uint32   `toml:"host_memory_cap_overhead"`
+	ConfidentialGuest       string   `toml:"confidential_guest"`
+	SEVCertChain            string   `toml:"sev_cert_chain"`
+	SEVGuestPolicy          uint32   `toml:"sev_guest_policy"`
+	SeccompSandbox          string   `toml:"seccompsandbox"`
+	DropCapabilities        bool     `toml:"drop_capabilities"`
+	RlimitNofile            string   `toml:"rlimit_nofile"`
+	RlimitMemlock           string   `toml:"rlimit_memlock"`
+	RlimitCore              string   `toml:"rlimit_core"`
+	VMMEnv                  []string `toml:"vmm_env"`
+	VMMBindMounts           []string `toml:"vmm_bind_mounts"`
+	VMMSlice                string   `toml:"vmm_slice"`
+	VMMUser                 string   `toml:"vmm_user"`
+	VMStartTimeout          uint32   `toml:"vm_start_timeout"`
+	VirtiofsdStartTimeout   uint32   `toml:"virtiofsd_start_timeout"`
+	EnableAnnotations       []string `toml:"enable_annotations"`
 }
 
 type proxy struct {
@@ -135,8 +178,33 @@ type runtime struct {
 	DisableNewNetNs     bool     `toml:"disable_new_netns"`
 	DisableGuestSeccomp bool     `toml:"disable_guest_seccomp"`
 	SandboxCgroupOnly   bool     `toml:"sandbox_cgroup_only"`
+	ExecLimit           uint32   `toml:"exec_limit"`
+	SuppressedEvents    []string `toml:"suppressed_events"`
+	SampledEvents       []string `toml:"sampled_events"`
+	EventSampleRate     uint32   `toml:"event_sample_rate"`
+	EmptyDirBlockSize   uint32   `toml:"emptydir_block_size"`
+	RootfsScratchSize   uint32   `toml:"rootfs_scratch_size"`
+	ScratchIntegrity    string   `toml:"scratch_integrity"`
+	LUKSKeyProvider     string   `toml:"luks_key_provider"`
+	LUKSKeyDir          string   `toml:"luks_key_dir"`
+	SandboxTmpQuota     uint32   `toml:"sandbox_tmp_quota"`
+	ResizePtyDebounce   uint32   `toml:"resize_pty_debounce"`
+	SandboxDrainTimeout uint32   `toml:"sandbox_drain_timeout"`
+	OverheadMetrics     bool     `toml:"sandbox_overhead_metrics"`
+	DebugIntrospection  bool     `toml:"enable_debug_introspection"`
+	Metrics             bool     `toml:"enable_metrics"`
+	AuditLog            bool     `toml:"enable_audit_log"`
+	AuditLogDir         string   `toml:"audit_log_dir"`
+	AuditLogMaxSize     uint32   `toml:"audit_log_max_size"`
 	Experimental        []string `toml:"experimental"`
 	InterNetworkModel   string   `toml:"internetworking_model"`
+	MacAddressPolicy    string   `toml:"mac_address_policy"`
+	PodBandwidth        bool     `toml:"enable_pod_bandwidth"`
+	XDPForwarder        string   `toml:"xdp_forwarder"`
+	TapFdSocketsDir     string   `toml:"tap_fd_sockets_dir"`
+	SRIOVInterfaces     []string `toml:"sriov_interfaces"`
+	ConfigRootPath      string   `toml:"config_root_path"`
+	RunRootPath         string   `toml:"run_root_path"`
 }
 
 type shim struct {
@@ -146,17 +214,40 @@ type shim struct {
 }
 
 type agent struct {
-	Debug         bool     `toml:"enable_debug"`
-	Tracing       bool     `toml:"enable_tracing"`
-	TraceMode     string   `toml:"trace_mode"`
-	TraceType     string   `toml:"trace_type"`
-	KernelModules []string `toml:"kernel_modules"`
+	Debug                  bool     `toml:"enable_debug"`
+	Tracing                bool     `toml:"enable_tracing"`
+	TraceMode              string   `toml:"trace_mode"`
+	TraceType              string   `toml:"trace_type"`
+	KernelModules          []string `toml:"kernel_modules"`
+	KernelModulesAllowlist []string `toml:"kernel_modules_allowlist"`
+	DialTimeout            uint32   `toml:"dial_timeout"`
+	PolicyFile             string   `toml:"policy_file"`
 }
 
 type netmon struct {
-	Path   string `toml:"path"`
-	Debug  bool   `toml:"enable_debug"`
-	Enable bool   `toml:"enable_netmon"`
+	Path      string `toml:"path"`
+	Debug     bool   `toml:"enable_debug"`
+	Enable    bool   `toml:"enable_netmon"`
+	InProcess bool   `toml:"in_process"`
+}
+
+type autoscale struct {
+	Interval         uint32 `toml:"interval"`
+	Cooldown         uint32 `toml:"cooldown"`
+	MemoryStep       uint32 `toml:"memory_step"`
+	MemHighWatermark uint32 `toml:"memory_high_watermark"`
+	CPUHighWatermark uint32 `toml:"cpu_high_watermark"`
+	CPULowWatermark  uint32 `toml:"cpu_low_watermark"`
+	SamplesToTrip    uint32 `toml:"samples_to_trip"`
+}
+
+type sizing struct {
+	Policy          string `toml:"policy"`
+	OverheadCPU     uint32 `toml:"overhead_cpu"`
+	OverheadMemory  uint32 `toml:"overhead_memory"`
+	BurstPercent    uint32 `toml:"burst_percent"`
+	MemoryStep      uint32 `toml:"memory_step"`
+	VCPUsHysteresis uint32 `toml:"vcpus_hysteresis"`
 }
 
 func (h hypervisor) path() (string, error) {
@@ -232,6 +323,14 @@ func (h hypervisor) firmware() (string, error) {
 	return ResolvePath(p)
 }
 
+func (h hypervisor) sevCertChain() (string, error) {
+	if h.SEVCertChain == "" {
+		return "", nil
+	}
+
+	return ResolvePath(h.SEVCertChain)
+}
+
 func (h hypervisor) machineAccelerators() string {
 	var machineAccelerators string
 	accelerators := strings.Split(h.MachineAccelerators, ",")
@@ -341,8 +440,22 @@ func (h hypervisor) defaultBridges() uint32 {
 	return h.DefaultBridges
 }
 
+func (h hypervisor) maxBridges() uint32 {
+	defaultBridges := h.defaultBridges()
+
+	if h.MaxBridges < defaultBridges {
+		return defaultBridges
+	}
+
+	if h.MaxBridges > maxHotplugPCIBridges {
+		return maxHotplugPCIBridges
+	}
+
+	return h.MaxBridges
+}
+
 func (h hypervisor) blockDeviceDriver() (string, error) {
-	supportedBlockDrivers := []string{config.VirtioSCSI, config.VirtioBlock, config.VirtioMmio, config.Nvdimm, config.VirtioBlockCCW}
+	supportedBlockDrivers := []string{config.VirtioSCSI, config.VirtioBlock, config.VirtioMmio, config.Nvdimm, config.VirtioBlockCCW, config.VirtioPmem}
 
 	if h.BlockDeviceDriver == "" {
 		return defaultBlockDeviceDriver, nil
@@ -373,6 +486,69 @@ func (h hypervisor) sharedFS() (string, error) {
 	return "", fmt.Errorf("Invalid hypervisor shared file system %v specified (supported file systems: %v)", h.SharedFS, supportedSharedFS)
 }
 
+func (h hypervisor) hugePageSize() (uint32, error) {
+	if h.HugePageSize == "" {
+		return 0, nil
+	}
+
+	return vc.ParseHugePageSize(h.HugePageSize)
+}
+
+func (h hypervisor) ksmMode() (vc.KSMMode, error) {
+	return vc.ParseKSMMode(h.KSMMode)
+}
+
+func (h hypervisor) enableAnnotations() ([]string, error) {
+	for _, option := range h.EnableAnnotations {
+		if !oci.IsHypervisorAnnotationOption(option) {
+			return nil, fmt.Errorf("enable_annotations: option %q cannot be overridden by the sandbox annotations", option)
+		}
+	}
+
+	return h.EnableAnnotations, nil
+}
+
+func (h hypervisor) vmmRlimits() ([]vc.VMMRlimit, error) {
+	var limits []vc.VMMRlimit
+
+	for _, l := range []struct {
+		name  string
+		value string
+	}{
+		{"nofile", h.RlimitNofile},
+		{"memlock", h.RlimitMemlock},
+		{"core", h.RlimitCore},
+	} {
+		if l.value == "" {
+			continue
+		}
+
+		limit, err := vc.ParseVMMRlimit(l.name, l.value)
+		if err != nil {
+			return nil, err
+		}
+
+		limits = append(limits, limit)
+	}
+
+	return limits, nil
+}
+
+func (h hypervisor) vmmBindMounts() ([]vc.VMMBindMount, error) {
+	var mounts []vc.VMMBindMount
+
+	for _, value := range h.VMMBindMounts {
+		mount, err := vc.ParseVMMBindMount(value)
+		if err != nil {
+			return nil, err
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	return mounts, nil
+}
+
 func (h hypervisor) msize9p() uint32 {
 	if h.Msize9p == 0 {
 		return defaultMsize9p
@@ -459,6 +635,24 @@ func (a agent) kernelModules() []string {
 	return a.KernelModules
 }
 
+// policy returns the agent policy document of the policy file, if any.
+func (a agent) policy() (string, error) {
+	if a.PolicyFile == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(a.PolicyFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the agent policy: %v", err)
+	}
+
+	if _, err := vc.ParseAgentPolicy(string(data)); err != nil {
+		return "", fmt.Errorf("%s: %v", a.PolicyFile, err)
+	}
+
+	return string(data), nil
+}
+
 func (n netmon) enable() bool {
 	return n.Enable
 }
@@ -475,7 +669,64 @@ func (n netmon) debug() bool {
 	return n.Debug
 }
 
+func (n netmon) inProcess() bool {
+	return n.InProcess
+}
+
+func newAutoscaleConfig(a autoscale) (vc.AutoscaleConfig, error) {
+	if a.CPULowWatermark != 0 && a.CPULowWatermark >= a.CPUHighWatermark {
+		return vc.AutoscaleConfig{}, fmt.Errorf("autoscale cpu_low_watermark (%d) must be lower than cpu_high_watermark (%d)",
+			a.CPULowWatermark, a.CPUHighWatermark)
+	}
+
+	if a.MemHighWatermark > 100 || a.CPUHighWatermark > 100 {
+		return vc.AutoscaleConfig{}, errors.New("autoscale watermarks are percentages and cannot exceed 100")
+	}
+
+	return vc.AutoscaleConfig{
+		Interval:         time.Duration(a.Interval) * time.Second,
+		Cooldown:         time.Duration(a.Cooldown) * time.Second,
+		MemStepMB:        a.MemoryStep,
+		MemHighWatermark: a.MemHighWatermark,
+		CPUHighWatermark: a.CPUHighWatermark,
+		CPULowWatermark:  a.CPULowWatermark,
+		SamplesToTrip:    a.SamplesToTrip,
+	}, nil
+}
+
+func newSizingConfig(s sizing) (vc.SizingConfig, error) {
+	switch s.Policy {
+	case "", vc.StaticSizingPolicy, vc.OverheadSizingPolicy:
+	default:
+		return vc.SizingConfig{}, fmt.Errorf("unknown sizing policy %q, expecting %q or %q",
+			s.Policy, vc.StaticSizingPolicy, vc.OverheadSizingPolicy)
+	}
+
+	return vc.SizingConfig{
+		Policy:            s.Policy,
+		OverheadMilliCPUs: s.OverheadCPU,
+		OverheadMemoryMB:  s.OverheadMemory,
+		BurstPercent:      s.BurstPercent,
+		MemoryStepMB:      s.MemoryStep,
+		VCPUsHysteresis:   s.VCPUsHysteresis,
+	}, nil
+}
+
+// checkBootTimeouts returns an error if the boot phase timeouts, which only
+// QEMU supports, are set.
+func (h hypervisor) checkBootTimeouts() error {
+	if h.VMStartTimeout > 0 || h.VirtiofsdStartTimeout > 0 {
+		return errors.New("vm_start_timeout and virtiofsd_start_timeout are only supported by qemu")
+	}
+
+	return nil
+}
+
 func newFirecrackerHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
+	if err := h.checkBootTimeouts(); err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
 	hypervisor, err := h.path()
 	if err != nil {
 		return vc.HypervisorConfig{}, err
@@ -508,13 +759,15 @@ func newFirecrackerHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		return vc.HypervisorConfig{}, err
 	}
 
-	if !utils.SupportsVsocks() {
-		return vc.HypervisorConfig{}, errors.New("No vsock support, firecracker cannot be used")
+	enableAnnotations, err := h.enableAnnotations()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
 	}
 
 	return vc.HypervisorConfig{
 		HypervisorPath:        hypervisor,
 		JailerPath:            jailer,
+		JailerChrootBase:      h.JailerChrootBase,
 		KernelPath:            kernel,
 		InitrdPath:            initrd,
 		ImagePath:             image,
@@ -535,6 +788,9 @@ func newFirecrackerHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		EnableIOThreads:       h.EnableIOThreads,
 		UseVSock:              true,
 		GuestHookPath:         h.guestHookPath(),
+		LogScrubParams:        h.LogScrubParams,
+		VMMUser:               h.VMMUser,
+		EnableAnnotations:     enableAnnotations,
 	}, nil
 }
 
@@ -578,6 +834,11 @@ func newQemuHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		return vc.HypervisorConfig{}, err
 	}
 
+	sevCertChain, err := h.sevCertChain()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
 	sharedFS, err := h.sharedFS()
 	if err != nil {
 		return vc.HypervisorConfig{}, err
@@ -588,6 +849,31 @@ func newQemuHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 			errors.New("cannot enable virtio-fs without daemon path in configuration file")
 	}
 
+	hugePageSize, err := h.hugePageSize()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
+	ksmMode, err := h.ksmMode()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
+	vmmRlimits, err := h.vmmRlimits()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
+	vmmBindMounts, err := h.vmmBindMounts()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
+	enableAnnotations, err := h.enableAnnotations()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
 	useVSock := false
 	if h.useVSock() {
 		if utils.SupportsVsocks() {
@@ -614,14 +900,19 @@ func newQemuHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		MemOffset:               h.defaultMemOffset(),
 		EntropySource:           h.GetEntropySource(),
 		DefaultBridges:          h.defaultBridges(),
+		MaxBridges:              h.maxBridges(),
 		DisableBlockDeviceUse:   h.DisableBlockDeviceUse,
 		SharedFS:                sharedFS,
 		VirtioFSDaemon:          h.VirtioFSDaemon,
 		VirtioFSCacheSize:       h.VirtioFSCacheSize,
 		VirtioFSCache:           h.VirtioFSCache,
 		VirtioFSExtraArgs:       h.VirtioFSExtraArgs,
+		VirtioFSVolumePaths:     h.VirtioFSVolumePaths,
 		MemPrealloc:             h.MemPrealloc,
+		MemPreallocAsync:        h.MemPreallocAsync,
 		HugePages:               h.HugePages,
+		HugePageSizeKB:          hugePageSize,
+		HugePagesPath:           h.HugePagesPath,
 		FileBackedMemRootDir:    h.FileBackedMemRootDir,
 		Mlock:                   !h.Swap,
 		Debug:                   h.Debug,
@@ -635,11 +926,39 @@ func newQemuHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		UseVSock:                useVSock,
 		HotplugVFIOOnRootBus:    h.HotplugVFIOOnRootBus,
 		DisableVhostNet:         h.DisableVhostNet,
+		NetworkMaxQueues:        h.NetworkMaxQueues,
 		GuestHookPath:           h.guestHookPath(),
+		OOMScoreAdj:             h.OOMScoreAdj,
+		PinVCPUs:                h.PinVCPUs,
+		VCPUCPUSet:              h.VCPUCPUSet,
+		KSMMode:                 ksmMode,
+		AgentHealthCheck:        h.AgentHealthCheck,
+		LogMaxSizeMiB:           h.LogMaxSize,
+		LogReplayMaxSizeKiB:     h.LogReplayMaxSize,
+		LogScrubParams:          h.LogScrubParams,
+		HostMemoryCap:           h.HostMemoryCap,
+		HostMemoryCapOverheadMB: h.HostMemoryCapOverhead,
+		ConfidentialGuest:       vc.ConfidentialGuestType(h.ConfidentialGuest),
+		SEVCertChainPath:        sevCertChain,
+		SEVGuestPolicy:          h.SEVGuestPolicy,
+		SeccompSandbox:          h.SeccompSandbox,
+		DropCapabilities:        h.DropCapabilities,
+		VMMRlimits:              vmmRlimits,
+		VMMEnv:                  h.VMMEnv,
+		VMMBindMounts:           vmmBindMounts,
+		VMMSlice:                h.VMMSlice,
+		VMMUser:                 h.VMMUser,
+		VMStartTimeout:          h.VMStartTimeout,
+		VirtiofsdStartTimeout:   h.VirtiofsdStartTimeout,
+		EnableAnnotations:       enableAnnotations,
 	}, nil
 }
 
 func newAcrnHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
+	if err := h.checkBootTimeouts(); err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
 	hypervisor, err := h.path()
 	if err != nil {
 		return vc.HypervisorConfig{}, err
@@ -677,6 +996,11 @@ func newAcrnHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		return vc.HypervisorConfig{}, err
 	}
 
+	enableAnnotations, err := h.enableAnnotations()
+	if err != nil {
+		return vc.HypervisorConfig{}, err
+	}
+
 	return vc.HypervisorConfig{
 		HypervisorPath:       hypervisor,
 		KernelPath:           kernel,
@@ -697,6 +1021,35 @@ func newAcrnHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
 		BlockDeviceDriver:    blockDriver,
 		DisableVhostNet:      h.DisableVhostNet,
 		GuestHookPath:        h.guestHookPath(),
+		EnableAnnotations:    enableAnnotations,
+	}, nil
+}
+
+// newMockHypervisorConfig returns the configuration of the mock hypervisor,
+// which fakes the VM lifecycle without KVM: the guest assets do not need to
+// exist.
+func newMockHypervisorConfig(h hypervisor) (vc.HypervisorConfig, error) {
+	kernel := h.Kernel
+	if kernel == "" {
+		kernel = defaultKernelPath
+	}
+
+	image := h.Image
+	if image == "" && h.Initrd == "" {
+		image = defaultImagePath
+	}
+
+	return vc.HypervisorConfig{
+		KernelPath:      kernel,
+		ImagePath:       image,
+		InitrdPath:      h.Initrd,
+		KernelParams:    vc.DeserializeParams(strings.Fields(h.kernelParams())),
+		NumVCPUs:        h.defaultVCPUs(),
+		DefaultMaxVCPUs: h.defaultMaxVCPUs(),
+		MemorySize:      h.defaultMemSz(),
+		MemSlots:        h.defaultMemSlots(),
+		DefaultBridges:  h.defaultBridges(),
+		Debug:           h.Debug,
 	}, nil
 }
 
@@ -743,6 +1096,9 @@ func updateRuntimeConfigHypervisor(configPath string, tomlConf tomlConfig, confi
 		case acrnHypervisorTableType:
 			config.HypervisorType = vc.AcrnHypervisor
 			hConfig, err = newAcrnHypervisorConfig(hypervisor)
+		case mockHypervisorTableType:
+			config.HypervisorType = vc.MockHypervisor
+			hConfig, err = newMockHypervisorConfig(hypervisor)
 		}
 
 		if err != nil {
@@ -787,6 +1143,22 @@ func updateRuntimeConfigProxy(configPath string, tomlConf tomlConfig, config *oc
 }
 
 func updateRuntimeConfigAgent(configPath string, tomlConf tomlConfig, config *oci.RuntimeConfig, builtIn bool) error {
+	// The no-op agent goes with the mock hypervisor, there is no guest
+	// to talk to even when the agent is built in.
+	if noop, ok := tomlConf.Agent[noopAgentTableType]; ok {
+		if len(tomlConf.Agent) > 1 {
+			return fmt.Errorf("%v: the %s agent cannot be configured along with another agent", configPath, noopAgentTableType)
+		}
+
+		if !reflect.DeepEqual(noop, agent{}) {
+			return fmt.Errorf("%v: the %s agent takes no option", configPath, noopAgentTableType)
+		}
+
+		config.AgentType = vc.NoopAgentType
+		config.AgentConfig = nil
+		return nil
+	}
+
 	if builtIn {
 		var agentConfig vc.KataAgentConfig
 
@@ -796,10 +1168,13 @@ func updateRuntimeConfigAgent(configPath string, tomlConf tomlConfig, config *oc
 
 		config.AgentType = vc.KataContainersAgent
 		config.AgentConfig = vc.KataAgentConfig{
-			LongLiveConn:  true,
-			UseVSock:      config.HypervisorConfig.UseVSock,
-			Debug:         agentConfig.Debug,
-			KernelModules: agentConfig.KernelModules,
+			LongLiveConn:           true,
+			UseVSock:               config.HypervisorConfig.UseVSock,
+			Debug:                  agentConfig.Debug,
+			KernelModules:          agentConfig.KernelModules,
+			KernelModulesAllowlist: agentConfig.KernelModulesAllowlist,
+			DialTimeout:            agentConfig.DialTimeout,
+			Policy:                 agentConfig.Policy,
 		}
 
 		return nil
@@ -808,14 +1183,22 @@ func updateRuntimeConfigAgent(configPath string, tomlConf tomlConfig, config *oc
 	for k, agent := range tomlConf.Agent {
 		switch k {
 		case kataAgentTableType:
+			policy, err := agent.policy()
+			if err != nil {
+				return fmt.Errorf("%v: %v", configPath, err)
+			}
+
 			config.AgentType = vc.KataContainersAgent
 			config.AgentConfig = vc.KataAgentConfig{
-				UseVSock:      config.HypervisorConfig.UseVSock,
-				Debug:         agent.debug(),
-				Trace:         agent.trace(),
-				TraceMode:     agent.traceMode(),
-				TraceType:     agent.traceType(),
-				KernelModules: agent.kernelModules(),
+				UseVSock:               config.HypervisorConfig.UseVSock,
+				Debug:                  agent.debug(),
+				Trace:                  agent.trace(),
+				TraceMode:              agent.traceMode(),
+				TraceType:              agent.traceType(),
+				KernelModules:          agent.kernelModules(),
+				KernelModulesAllowlist: agent.KernelModulesAllowlist,
+				DialTimeout:            agent.DialTimeout,
+				Policy:                 policy,
 			}
 		default:
 			return fmt.Errorf("%s agent type is not supported", k)
@@ -926,11 +1309,24 @@ func updateRuntimeConfig(configPath string, tomlConf tomlConfig, config *oci.Run
 	config.FactoryConfig = fConfig
 
 	config.NetmonConfig = vc.NetmonConfig{
-		Path:   tomlConf.Netmon.path(),
-		Debug:  tomlConf.Netmon.debug(),
-		Enable: tomlConf.Netmon.enable(),
+		Path:      tomlConf.Netmon.path(),
+		Debug:     tomlConf.Netmon.debug(),
+		Enable:    tomlConf.Netmon.enable(),
+		InProcess: tomlConf.Netmon.inProcess(),
 	}
 
+	aConfig, err := newAutoscaleConfig(tomlConf.Autoscale)
+	if err != nil {
+		return fmt.Errorf("%v: %v", configPath, err)
+	}
+	config.AutoscaleConfig = aConfig
+
+	sConfig, err := newSizingConfig(tomlConf.Sizing)
+	if err != nil {
+		return fmt.Errorf("%v: %v", configPath, err)
+	}
+	config.SizingConfig = sConfig
+
 	err = SetKernelParams(config)
 	if err != nil {
 		return err
@@ -1003,15 +1399,22 @@ func initConfig() (config oci.RuntimeConfig, err error) {
 // All paths are resolved fully meaning if this function does not return an
 // error, all paths are valid at the time of the call.
 func LoadConfiguration(configPath string, ignoreLogging, builtIn bool) (resolvedConfigPath string, config oci.RuntimeConfig, err error) {
+	resolvedConfigPath, _, config, err = LoadConfigurationData(configPath, ignoreLogging, builtIn)
+	return resolvedConfigPath, config, err
+}
+
+// LoadConfigurationData is LoadConfiguration, also returning the content
+// of the configuration file it parsed.
+func LoadConfigurationData(configPath string, ignoreLogging, builtIn bool) (resolvedConfigPath string, configData []byte, config oci.RuntimeConfig, err error) {
 
 	config, err = initConfig()
 	if err != nil {
-		return "", oci.RuntimeConfig{}, err
+		return "", nil, oci.RuntimeConfig{}, err
 	}
 
-	tomlConf, resolved, err := decodeConfig(configPath)
+	tomlConf, resolved, configData, err := decodeConfig(configPath)
 	if err != nil {
-		return "", oci.RuntimeConfig{}, err
+		return "", nil, oci.RuntimeConfig{}, err
 	}
 
 	config.Debug = tomlConf.Runtime.Debug
@@ -1027,14 +1430,28 @@ func LoadConfiguration(configPath string, ignoreLogging, builtIn bool) (resolved
 	if tomlConf.Runtime.InterNetworkModel != "" {
 		err = config.InterNetworkModel.SetModel(tomlConf.Runtime.InterNetworkModel)
 		if err != nil {
-			return "", config, err
+			return "", nil, config, err
+		}
+	}
+
+	if tomlConf.Runtime.MacAddressPolicy != "" {
+		err = config.MacAddressPolicy.SetPolicy(tomlConf.Runtime.MacAddressPolicy)
+		if err != nil {
+			return "", nil, config, err
+		}
+	}
+
+	if tomlConf.Runtime.ConfigRootPath != "" || tomlConf.Runtime.RunRootPath != "" {
+		err = vc.SetStorageRootPaths(tomlConf.Runtime.ConfigRootPath, tomlConf.Runtime.RunRootPath)
+		if err != nil {
+			return "", nil, config, err
 		}
 	}
 
 	if !ignoreLogging {
 		err := handleSystemLog("", "")
 		if err != nil {
-			return "", config, err
+			return "", nil, config, err
 		}
 
 		kataUtilsLogger.WithFields(
@@ -1045,7 +1462,7 @@ func LoadConfiguration(configPath string, ignoreLogging, builtIn bool) (resolved
 	}
 
 	if err := updateRuntimeConfig(resolved, tomlConf, &config, builtIn); err != nil {
-		return "", config, err
+		return "", nil, config, err
 	}
 
 	config.DisableGuestSeccomp = tomlConf.Runtime.DisableGuestSeccomp
@@ -1058,23 +1475,49 @@ func LoadConfiguration(configPath string, ignoreLogging, builtIn bool) (resolved
 	}
 
 	config.SandboxCgroupOnly = tomlConf.Runtime.SandboxCgroupOnly
+	config.ExecLimit = tomlConf.Runtime.ExecLimit
+	config.SuppressedEvents = tomlConf.Runtime.SuppressedEvents
+	config.SampledEvents = tomlConf.Runtime.SampledEvents
+	config.EventSampleRate = tomlConf.Runtime.EventSampleRate
+	config.EmptyDirBlockSize = tomlConf.Runtime.EmptyDirBlockSize
+	config.RootfsScratchSize = tomlConf.Runtime.RootfsScratchSize
+	config.ScratchIntegrity = tomlConf.Runtime.ScratchIntegrity
+	config.LUKSKeyProvider = tomlConf.Runtime.LUKSKeyProvider
+	config.LUKSKeyDir = tomlConf.Runtime.LUKSKeyDir
+	config.XDPForwarderPath = tomlConf.Runtime.XDPForwarder
+	config.TapFdSocketsDir = tomlConf.Runtime.TapFdSocketsDir
+	config.SandboxTmpQuota = tomlConf.Runtime.SandboxTmpQuota
+	config.ResizePtyDebounce = tomlConf.Runtime.ResizePtyDebounce
+	config.SandboxDrainTimeout = tomlConf.Runtime.SandboxDrainTimeout
+	config.SandboxOverheadMetrics = tomlConf.Runtime.OverheadMetrics
+	config.EnableDebugIntrospection = tomlConf.Runtime.DebugIntrospection
+	config.EnableMetrics = tomlConf.Runtime.Metrics
+	config.EnableAuditLog = tomlConf.Runtime.AuditLog
+	config.AuditLogDir = tomlConf.Runtime.AuditLogDir
+	config.AuditLogMaxSize = tomlConf.Runtime.AuditLogMaxSize
 	config.DisableNewNetNs = tomlConf.Runtime.DisableNewNetNs
+	config.EnablePodBandwidth = tomlConf.Runtime.PodBandwidth
+
+	if config.SRIOVInterfaces, err = vc.ParseSRIOVInterfaces(tomlConf.Runtime.SRIOVInterfaces); err != nil {
+		return "", nil, config, err
+	}
+
 	for _, f := range tomlConf.Runtime.Experimental {
 		feature := exp.Get(f)
 		if feature == nil {
-			return "", config, fmt.Errorf("Unsupported experimental feature %q", f)
+			return "", nil, config, fmt.Errorf("Unsupported experimental feature %q", f)
 		}
 		config.Experimental = append(config.Experimental, *feature)
 	}
 
 	if err := checkConfig(config); err != nil {
-		return "", config, err
+		return "", nil, config, err
 	}
 
-	return resolved, config, nil
+	return resolved, configData, config, nil
 }
 
-func decodeConfig(configPath string) (tomlConfig, string, error) {
+func decodeConfig(configPath string) (tomlConfig, string, []byte, error) {
 	var (
 		resolved string
 		tomlConf tomlConfig
@@ -1088,20 +1531,20 @@ func decodeConfig(configPath string) (tomlConfig, string, error) {
 	}
 
 	if err != nil {
-		return tomlConf, "", fmt.Errorf("Cannot find usable config file (%v)", err)
+		return tomlConf, "", nil, fmt.Errorf("Cannot find usable config file (%v)", err)
 	}
 
 	configData, err := ioutil.ReadFile(resolved)
 	if err != nil {
-		return tomlConf, resolved, err
+		return tomlConf, resolved, nil, err
 	}
 
 	_, err = toml.Decode(string(configData), &tomlConf)
 	if err != nil {
-		return tomlConf, resolved, err
+		return tomlConf, resolved, nil, err
 	}
 
-	return tomlConf, resolved, nil
+	return tomlConf, resolved, configData, nil
 }
 
 // checkConfig checks the validity of the specified config.
@@ -1118,6 +1561,71 @@ func checkConfig(config oci.RuntimeConfig) error {
 		return err
 	}
 
+	if err := checkScratchIntegrity(config.ScratchIntegrity); err != nil {
+		return err
+	}
+
+	if err := checkVMMSliceConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkAgentDialTimeout(config); err != nil {
+		return err
+	}
+
+	if err := checkMockHypervisorConfig(config); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkMockHypervisorConfig checks the mock hypervisor and the no-op agent
+// go together: the no-op agent would fake the containers of a real VM, and
+// the other agents would wait for a guest the mock hypervisor never boots.
+func checkMockHypervisorConfig(config oci.RuntimeConfig) error {
+	mock := config.HypervisorType == vc.MockHypervisor
+	noop := config.AgentType == vc.NoopAgentType
+
+	if mock && !noop {
+		return fmt.Errorf("The %s hypervisor requires the %s agent, not %s", mockHypervisorTableType, noopAgentTableType, config.AgentType)
+	}
+
+	if noop && !mock {
+		return fmt.Errorf("The %s agent requires the %s hypervisor, not %s", noopAgentTableType, mockHypervisorTableType, config.HypervisorType)
+	}
+
+	return nil
+}
+
+// checkVMMSliceConfig checks the hypervisor is not moved out of the sandbox
+// cgroup the runtime is confined to.
+func checkVMMSliceConfig(config oci.RuntimeConfig) error {
+	if config.SandboxCgroupOnly && config.HypervisorConfig.VMMSlice != "" {
+		return errors.New("config vmm_slice conflicts with sandbox_cgroup_only")
+	}
+
+	return nil
+}
+
+// checkAgentDialTimeout checks the agent dial timeout, only timed
+// separately from the VM boot with qemu, is not set for other hypervisors.
+func checkAgentDialTimeout(config oci.RuntimeConfig) error {
+	agentConfig, ok := config.AgentConfig.(vc.KataAgentConfig)
+	if ok && agentConfig.DialTimeout > 0 && config.HypervisorType != vc.QemuHypervisor {
+		return fmt.Errorf("agent dial_timeout is not supported by the %s hypervisor", config.HypervisorType)
+	}
+
+	return nil
+}
+
+// checkScratchIntegrity checks the scratch_integrity config is one of the
+// integrity algorithms of the block devices.
+func checkScratchIntegrity(integrity string) error {
+	if err := config.ValidBlockIntegrity(integrity); err != nil {
+		return fmt.Errorf("Invalid scratch_integrity: %v", err)
+	}
+
 	return nil
 }
 
@@ -1147,6 +1655,10 @@ func checkFactoryConfig(config oci.RuntimeConfig) error {
 		if config.HypervisorConfig.InitrdPath == "" {
 			return errors.New("Factory option enable_template requires an initrd image")
 		}
+
+		if config.HypervisorConfig.ConfidentialGuest != vc.NoConfidentialGuest {
+			return errors.New("Factory option enable_template conflicts with confidential_guest")
+		}
 	}
 
 	if config.FactoryConfig.VMCacheNumber > 0 {
@@ -1238,6 +1750,40 @@ func GetDefaultConfigFilePaths() []string {
 	}
 }
 
+// GetNamespaceConfigFilePaths returns a list of paths that will be
+// considered as configuration files for the given containerd namespace in
+// priority order. They live below a "namespaces/<namespace>" directory next
+// to each of the default configuration files.
+func GetNamespaceConfigFilePaths(namespace string) []string {
+	var paths []string
+
+	for _, file := range GetDefaultConfigFilePaths() {
+		dir, base := filepath.Split(file)
+		paths = append(paths, filepath.Join(dir, "namespaces", namespace, base))
+	}
+
+	return paths
+}
+
+// GetNamespaceConfigFile returns the resolved path of the first
+// configuration file found for the given containerd namespace, or an empty
+// string if the namespace has no specific configuration.
+func GetNamespaceConfigFile(namespace string) string {
+	// Namespaces are validated by containerd, be defensive anyway as the
+	// namespace ends up in a path.
+	if namespace == "" || namespace == "." || namespace == ".." || strings.Contains(namespace, "/") {
+		return ""
+	}
+
+	for _, file := range GetNamespaceConfigFilePaths(namespace) {
+		if resolved, err := ResolvePath(file); err == nil {
+			return resolved
+		}
+	}
+
+	return ""
+}
+
 // getDefaultConfigFile looks in multiple default locations for a
 // configuration file and returns the resolved path for the first file
 // found, or an error if no config files can be found.
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
@@ -155,6 +156,7 @@ func createAllRuntimeConfigFiles(dir, hypervisor string) (config testRuntimeConf
 		DisableBlockDeviceUse: disableBlockDevice,
 		BlockDeviceDriver:     defaultBlockDeviceDriver,
 		DefaultBridges:        defaultBridgesCount,
+		MaxBridges:            defaultBridgesCount,
 		Mlock:                 !defaultEnableSwap,
 		EnableIOThreads:       enableIOThreads,
 		HotplugVFIOOnRootBus:  hotplugVFIOOnRootBus,
@@ -349,6 +351,30 @@ func TestConfigLoadConfigurationFailSymLinkLoop(t *testing.T) {
 		})
 }
 
+func TestDecodeConfigData(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir(testDir, "decode-config-")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	configPath := filepath.Join(tmpdir, "configuration.toml")
+	content := []byte("[runtime]\nenable_debug = true\n")
+	assert.NoError(ioutil.WriteFile(configPath, content, testFileMode))
+
+	// the content parsed is returned, whatever the file becomes
+	tomlConf, resolved, configData, err := decodeConfig(configPath)
+	assert.NoError(err)
+	assert.Equal(configPath, resolved)
+	assert.Equal(content, configData)
+	assert.True(tomlConf.Runtime.Debug)
+
+	assert.NoError(ioutil.WriteFile(configPath, []byte("[runtime\n"), testFileMode))
+	_, _, configData, err = decodeConfig(configPath)
+	assert.Error(err)
+	assert.Nil(configData)
+}
+
 func TestConfigLoadConfigurationFailMissingHypervisor(t *testing.T) {
 	tmpdir, err := ioutil.TempDir(testDir, "runtime-config-")
 	assert.NoError(t, err)
@@ -620,6 +646,7 @@ func TestMinimalRuntimeConfig(t *testing.T) {
 		MemorySize:            defaultMemSize,
 		DisableBlockDeviceUse: defaultDisableBlockDeviceUse,
 		DefaultBridges:        defaultBridgesCount,
+		MaxBridges:            defaultBridgesCount,
 		Mlock:                 !defaultEnableSwap,
 		BlockDeviceDriver:     defaultBlockDeviceDriver,
 		Msize9p:               defaultMsize9p,
@@ -773,6 +800,8 @@ func TestNewQemuHypervisorConfig(t *testing.T) {
 	disableBlock := true
 	enableIOThreads := true
 	hotplugVFIOOnRootBus := true
+	oomScoreAdj := -500
+	hostMemoryCapOverhead := uint32(512)
 	orgVHostVSockDevicePath := utils.VHostVSockDevicePath
 	defer func() {
 		utils.VHostVSockDevicePath = orgVHostVSockDevicePath
@@ -788,6 +817,10 @@ func TestNewQemuHypervisorConfig(t *testing.T) {
 		EnableIOThreads:       enableIOThreads,
 		HotplugVFIOOnRootBus:  hotplugVFIOOnRootBus,
 		UseVSock:              true,
+		OOMScoreAdj:           oomScoreAdj,
+		KSMMode:               "aggressive",
+		HostMemoryCap:         true,
+		HostMemoryCapOverhead: hostMemoryCapOverhead,
 	}
 
 	files := []string{hypervisorPath, kernelPath, imagePath}
@@ -844,6 +877,19 @@ func TestNewQemuHypervisorConfig(t *testing.T) {
 	if config.HotplugVFIOOnRootBus != hotplugVFIOOnRootBus {
 		t.Errorf("Expected value for HotplugVFIOOnRootBus %v, got %v", hotplugVFIOOnRootBus, config.HotplugVFIOOnRootBus)
 	}
+
+	if config.OOMScoreAdj != oomScoreAdj {
+		t.Errorf("Expected value for OOMScoreAdj %v, got %v", oomScoreAdj, config.OOMScoreAdj)
+	}
+
+	if config.KSMMode != vc.KSMAggressive {
+		t.Errorf("Expected value for KSMMode %v, got %v", vc.KSMAggressive, config.KSMMode)
+	}
+
+	if !config.HostMemoryCap || config.HostMemoryCapOverheadMB != hostMemoryCapOverhead {
+		t.Errorf("Expected host memory cap with %v MiB overhead, got %v with %v MiB overhead",
+			hostMemoryCapOverhead, config.HostMemoryCap, config.HostMemoryCapOverheadMB)
+	}
 }
 
 func TestNewQemuHypervisorConfigImageAndInitrd(t *testing.T) {
@@ -918,6 +964,61 @@ func TestNewShimConfig(t *testing.T) {
 	}
 }
 
+func TestHypervisorVMMRlimits(t *testing.T) {
+	assert := assert.New(t)
+
+	h := hypervisor{}
+	limits, err := h.vmmRlimits()
+	assert.NoError(err)
+	assert.Empty(limits)
+
+	h.RlimitMemlock = "unlimited"
+	h.RlimitCore = "0"
+	limits, err = h.vmmRlimits()
+	assert.NoError(err)
+	assert.Len(limits, 2)
+
+	h.RlimitNofile = "lots"
+	_, err = h.vmmRlimits()
+	assert.Error(err)
+}
+
+func TestHypervisorEnableAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	h := hypervisor{}
+	options, err := h.enableAnnotations()
+	assert.NoError(err)
+	assert.Empty(options)
+
+	h.EnableAnnotations = []string{"default_memory", "virtio_fs_cache"}
+	options, err = h.enableAnnotations()
+	assert.NoError(err)
+	assert.Equal([]string{"default_memory", "virtio_fs_cache"}, options)
+
+	h.EnableAnnotations = []string{"path"}
+	_, err = h.enableAnnotations()
+	assert.Error(err)
+}
+
+func TestHypervisorVMMBindMounts(t *testing.T) {
+	assert := assert.New(t)
+
+	h := hypervisor{}
+	mounts, err := h.vmmBindMounts()
+	assert.NoError(err)
+	assert.Empty(mounts)
+
+	h.VMMBindMounts = []string{"/var/run/spdk:/var/run/vhost"}
+	mounts, err = h.vmmBindMounts()
+	assert.NoError(err)
+	assert.Equal([]vc.VMMBindMount{{Source: "/var/run/spdk", Destination: "/var/run/vhost"}}, mounts)
+
+	h.VMMBindMounts = []string{"/var/run/spdk"}
+	_, err = h.vmmBindMounts()
+	assert.Error(err)
+}
+
 func TestHypervisorDefaults(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1294,6 +1395,52 @@ func TestGetDefaultConfigFile(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestGetNamespaceConfigFile(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir(testDir, "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	savedConf := defaultRuntimeConfiguration
+	savedSysConf := defaultSysConfRuntimeConfiguration
+
+	defaultRuntimeConfiguration = filepath.Join(tmpdir, "conf", "configuration.toml")
+	defaultSysConfRuntimeConfiguration = filepath.Join(tmpdir, "sysconf", "configuration.toml")
+
+	defer func() {
+		defaultRuntimeConfiguration = savedConf
+		defaultSysConfRuntimeConfiguration = savedSysConf
+	}()
+
+	confFile := filepath.Join(tmpdir, "conf", "namespaces", "test", "configuration.toml")
+	sysConfFile := filepath.Join(tmpdir, "sysconf", "namespaces", "test", "configuration.toml")
+
+	assert.Equal([]string{sysConfFile, confFile}, GetNamespaceConfigFilePaths("test"))
+
+	// no namespace specific configuration
+	assert.Empty(GetNamespaceConfigFile("test"))
+
+	for _, file := range []string{confFile, sysConfFile} {
+		err = os.MkdirAll(filepath.Dir(file), testDirMode)
+		assert.NoError(err)
+		err = createEmptyFile(file)
+		assert.NoError(err)
+	}
+
+	// the sysconf namespace configuration has priority
+	assert.Equal(sysConfFile, GetNamespaceConfigFile("test"))
+
+	os.Remove(sysConfFile)
+	assert.Equal(confFile, GetNamespaceConfigFile("test"))
+
+	assert.Empty(GetNamespaceConfigFile("prod"))
+
+	for _, namespace := range []string{"", ".", "..", "../test"} {
+		assert.Empty(GetNamespaceConfigFile(namespace), "namespace %q", namespace)
+	}
+}
+
 func TestDefaultBridges(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1311,6 +1458,19 @@ func TestDefaultBridges(t *testing.T) {
 	assert.Equal(maxPCIBridges, bridges)
 }
 
+func TestMaxBridges(t *testing.T) {
+	assert := assert.New(t)
+
+	h := hypervisor{DefaultBridges: 2}
+	assert.Equal(uint32(2), h.maxBridges())
+
+	h.MaxBridges = 4
+	assert.Equal(uint32(4), h.maxBridges())
+
+	h.MaxBridges = maxHotplugPCIBridges + 1
+	assert.Equal(maxHotplugPCIBridges, h.maxBridges())
+}
+
 func TestDefaultFirmware(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1408,6 +1568,84 @@ func TestUpdateRuntimeConfiguration(t *testing.T) {
 	assert.Equal(config.AgentConfig, vc.KataAgentConfig{})
 }
 
+func TestUpdateRuntimeConfigurationKernelModules(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{}
+
+	tomlConf := tomlConfig{
+		Agent: map[string]agent{
+			kataAgentTableType: {
+				KernelModules:          []string{"ip_vs", "nbd nbds_max=32"},
+				KernelModulesAllowlist: []string{"ip_vs", "nbd"},
+			},
+		},
+	}
+
+	err := updateRuntimeConfig("", tomlConf, &config, false)
+	assert.NoError(err)
+
+	assert.Equal(vc.KataAgentConfig{
+		KernelModules:          []string{"ip_vs", "nbd nbds_max=32"},
+		KernelModulesAllowlist: []string{"ip_vs", "nbd"},
+	}, config.AgentConfig)
+
+	// The allowlist is kept for the built-in agent
+	err = updateRuntimeConfig("", tomlConf, &config, true)
+	assert.NoError(err)
+
+	agentConfig, ok := config.AgentConfig.(vc.KataAgentConfig)
+	assert.True(ok)
+	assert.Equal([]string{"ip_vs", "nbd"}, agentConfig.KernelModulesAllowlist)
+
+	// The kernel_modules are loaded without allowlist.
+	tomlConf.Agent[kataAgentTableType] = agent{
+		KernelModules: []string{"ip_vs", "nbd nbds_max=32"},
+	}
+	err = updateRuntimeConfig("", tomlConf, &config, false)
+	assert.NoError(err)
+
+	assert.Equal(vc.KataAgentConfig{
+		KernelModules: []string{"ip_vs", "nbd nbds_max=32"},
+	}, config.AgentConfig)
+}
+
+func TestUpdateRuntimeConfigurationAgentPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir(testDir, "agent-policy")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	policy := `{"default": "deny", "rules": [{"request": "exec", "action": "allow", "args": ["/usr/bin/ps", "-ef"]}]}`
+	policyFile := filepath.Join(dir, "policy.json")
+	assert.NoError(ioutil.WriteFile(policyFile, []byte(policy), 0600))
+
+	config := oci.RuntimeConfig{}
+	tomlConf := tomlConfig{
+		Agent: map[string]agent{
+			kataAgentTableType: {
+				PolicyFile: policyFile,
+			},
+		},
+	}
+
+	assert.NoError(updateRuntimeConfig("", tomlConf, &config, false))
+	assert.Equal(vc.KataAgentConfig{Policy: policy}, config.AgentConfig)
+
+	// The policy is kept for the built-in agent
+	assert.NoError(updateRuntimeConfig("", tomlConf, &config, true))
+	agentConfig, ok := config.AgentConfig.(vc.KataAgentConfig)
+	assert.True(ok)
+	assert.Equal(policy, agentConfig.Policy)
+
+	assert.NoError(ioutil.WriteFile(policyFile, []byte(`{"default": "maybe"}`), 0600))
+	assert.Error(updateRuntimeConfig("", tomlConf, &config, false))
+
+	tomlConf.Agent[kataAgentTableType] = agent{PolicyFile: filepath.Join(dir, "missing")}
+	assert.Error(updateRuntimeConfig("", tomlConf, &config, false))
+}
+
 func TestUpdateRuntimeConfigurationVMConfig(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1436,6 +1674,65 @@ func TestUpdateRuntimeConfigurationVMConfig(t *testing.T) {
 	assert.Equal(expectedVMConfig, config.HypervisorConfig.MemorySize)
 }
 
+func TestUpdateRuntimeConfigurationMockHypervisor(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{}
+
+	tomlConf := tomlConfig{
+		Hypervisor: map[string]hypervisor{
+			mockHypervisorTableType: {
+				MemorySize: 512,
+				Initrd:     "/does/not/exist",
+			},
+		},
+		Agent: map[string]agent{
+			noopAgentTableType: {},
+		},
+	}
+
+	// The no-op agent is used even when the agent is built in.
+	err := updateRuntimeConfig("", tomlConf, &config, true)
+	assert.NoError(err)
+
+	assert.Equal(vc.MockHypervisor, config.HypervisorType)
+	assert.Equal(defaultKernelPath, config.HypervisorConfig.KernelPath)
+	assert.Empty(config.HypervisorConfig.ImagePath)
+	assert.Equal("/does/not/exist", config.HypervisorConfig.InitrdPath)
+	assert.Equal(uint32(512), config.HypervisorConfig.MemorySize)
+
+	assert.Equal(vc.NoopAgentType, config.AgentType)
+	assert.Nil(config.AgentConfig)
+	assert.NoError(checkMockHypervisorConfig(config))
+
+	// The no-op agent takes no option, and is the only agent.
+	tomlConf.Agent[noopAgentTableType] = agent{Debug: true}
+	assert.Error(updateRuntimeConfig("", tomlConf, &config, true))
+
+	tomlConf.Agent[noopAgentTableType] = agent{}
+	tomlConf.Agent[kataAgentTableType] = agent{}
+	assert.Error(updateRuntimeConfig("", tomlConf, &config, false))
+}
+
+func TestCheckMockHypervisorConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+		AgentType:      vc.KataContainersAgent,
+	}
+	assert.NoError(checkMockHypervisorConfig(config))
+
+	config.AgentType = vc.NoopAgentType
+	assert.Error(checkMockHypervisorConfig(config))
+
+	config.HypervisorType = vc.MockHypervisor
+	assert.NoError(checkMockHypervisorConfig(config))
+
+	config.AgentType = vc.KataContainersAgent
+	assert.Error(checkMockHypervisorConfig(config))
+}
+
 func TestUpdateRuntimeConfigurationFactoryConfig(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1454,6 +1751,71 @@ func TestUpdateRuntimeConfigurationFactoryConfig(t *testing.T) {
 	assert.Equal(expectedFactoryConfig, config.FactoryConfig)
 }
 
+func TestUpdateRuntimeConfigurationAutoscaleConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{}
+	expectedAutoscaleConfig := vc.AutoscaleConfig{
+		Interval:         10 * time.Second,
+		MemStepMB:        512,
+		CPUHighWatermark: 90,
+		CPULowWatermark:  20,
+	}
+
+	tomlConf := tomlConfig{Autoscale: autoscale{
+		Interval:         10,
+		MemoryStep:       512,
+		CPUHighWatermark: 90,
+		CPULowWatermark:  20,
+	}}
+
+	err := updateRuntimeConfig("", tomlConf, &config, false)
+	assert.NoError(err)
+	assert.Equal(expectedAutoscaleConfig, config.AutoscaleConfig)
+
+	// low watermark above high watermark
+	tomlConf.Autoscale.CPULowWatermark = 95
+	err = updateRuntimeConfig("", tomlConf, &config, false)
+	assert.Error(err)
+
+	// watermarks are percentages
+	tomlConf.Autoscale.CPULowWatermark = 0
+	tomlConf.Autoscale.MemHighWatermark = 150
+	err = updateRuntimeConfig("", tomlConf, &config, false)
+	assert.Error(err)
+}
+
+func TestUpdateRuntimeConfigurationSizingConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{}
+	expectedSizingConfig := vc.SizingConfig{
+		Policy:            vc.OverheadSizingPolicy,
+		OverheadMilliCPUs: 250,
+		OverheadMemoryMB:  128,
+		BurstPercent:      10,
+		MemoryStepMB:      256,
+		VCPUsHysteresis:   1,
+	}
+
+	tomlConf := tomlConfig{Sizing: sizing{
+		Policy:          "overhead",
+		OverheadCPU:     250,
+		OverheadMemory:  128,
+		BurstPercent:    10,
+		MemoryStep:      256,
+		VCPUsHysteresis: 1,
+	}}
+
+	err := updateRuntimeConfig("", tomlConf, &config, false)
+	assert.NoError(err)
+	assert.Equal(expectedSizingConfig, config.SizingConfig)
+
+	tomlConf.Sizing.Policy = "dynamic"
+	err = updateRuntimeConfig("", tomlConf, &config, false)
+	assert.Error(err)
+}
+
 func TestUpdateRuntimeConfigurationInvalidKernelParams(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1605,6 +1967,51 @@ func TestCheckNetNsConfig(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestCheckScratchIntegrity(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkScratchIntegrity(""))
+	assert.NoError(checkScratchIntegrity("hmac-sha256"))
+	assert.Error(checkScratchIntegrity("md5"))
+}
+
+func TestCheckVMMSliceConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{}
+	assert.NoError(checkVMMSliceConfig(config))
+
+	config.HypervisorConfig.VMMSlice = "kata-vmm.slice"
+	assert.NoError(checkVMMSliceConfig(config))
+
+	config.SandboxCgroupOnly = true
+	assert.Error(checkVMMSliceConfig(config))
+}
+
+func TestCheckAgentDialTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	config := oci.RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+		AgentConfig:    vc.KataAgentConfig{DialTimeout: 30},
+	}
+	assert.NoError(checkAgentDialTimeout(config))
+
+	config.HypervisorType = vc.FirecrackerHypervisor
+	assert.Error(checkAgentDialTimeout(config))
+
+	config.AgentConfig = vc.KataAgentConfig{}
+	assert.NoError(checkAgentDialTimeout(config))
+}
+
+func TestHypervisorCheckBootTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(hypervisor{}.checkBootTimeouts())
+	assert.Error(hypervisor{VMStartTimeout: 30}.checkBootTimeouts())
+	assert.Error(hypervisor{VirtiofsdStartTimeout: 30}.checkBootTimeouts())
+}
+
 func TestCheckFactoryConfig(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1613,22 +2020,27 @@ func TestCheckFactoryConfig(t *testing.T) {
 		expectError    bool
 		imagePath      string
 		initrdPath     string
+		confidential   vc.ConfidentialGuestType
 	}
 
 	data := []testData{
-		{false, false, "", ""},
-		{false, false, "image", ""},
-		{false, false, "", "initrd"},
+		{false, false, "", "", vc.NoConfidentialGuest},
+		{false, false, "image", "", vc.NoConfidentialGuest},
+		{false, false, "", "initrd", vc.NoConfidentialGuest},
+		{false, false, "", "initrd", vc.SEVGuest},
 
-		{true, false, "", "initrd"},
-		{true, true, "image", ""},
+		{true, false, "", "initrd", vc.NoConfidentialGuest},
+		{true, true, "image", "", vc.NoConfidentialGuest},
+		{true, true, "", "initrd", vc.SEVGuest},
+		{true, true, "", "initrd", vc.TDXGuest},
 	}
 
 	for i, d := range data {
 		config := oci.RuntimeConfig{
 			HypervisorConfig: vc.HypervisorConfig{
-				ImagePath:  d.imagePath,
-				InitrdPath: d.initrdPath,
+				ImagePath:         d.imagePath,
+				InitrdPath:        d.initrdPath,
+				ConfidentialGuest: d.confidential,
 			},
 
 			FactoryConfig: oci.FactoryConfig{
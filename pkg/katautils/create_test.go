@@ -24,6 +24,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 )
@@ -467,3 +468,85 @@ func TestCreateContainer(t *testing.T) {
 		os.RemoveAll(path)
 	}
 }
+
+// TestCreateSandboxMockHypervisor runs a sandbox end to end, from the
+// configuration file to its deletion, with the mock hypervisor and the
+// no-op agent, which need no KVM.
+func TestCreateSandboxMockHypervisor(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(ktu.TestDisabledNeedRoot)
+	}
+
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "mock-hypervisor")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	// The guest assets are only checked to exist and not to be empty.
+	kernelPath := filepath.Join(tmpdir, "kernel")
+	imagePath := filepath.Join(tmpdir, "image")
+	for _, file := range []string{kernelPath, imagePath} {
+		assert.NoError(createFile(file, "asset"))
+	}
+
+	configPath := filepath.Join(tmpdir, "configuration.toml")
+	assert.NoError(createConfig(configPath, `
+[hypervisor.mock]
+kernel = "`+kernelPath+`"
+image = "`+imagePath+`"
+default_memory = 512
+
+[agent.noop]
+
+[runtime]
+internetworking_model = "none"
+disable_new_netns = true
+`))
+
+	_, runtimeConfig, err := LoadConfiguration(configPath, true, true)
+	assert.NoError(err)
+	assert.Equal(vc.MockHypervisor, runtimeConfig.HypervisorType)
+	assert.Equal(vc.NoopAgentType, runtimeConfig.AgentType)
+
+	bundlePath := filepath.Join(tmpdir, "bundle")
+	rootfs := filepath.Join(bundlePath, "rootfs")
+	assert.NoError(os.MkdirAll(rootfs, testDirMode))
+
+	spec := specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: rootfs},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Cwd:  "/",
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	ctx := context.Background()
+	vci := &vc.VCImpl{}
+	sandboxID := "mock-hypervisor-sandbox"
+
+	sandbox, _, err := CreateSandbox(ctx, vci, spec, runtimeConfig, vc.RootFs{Mounted: true}, sandboxID, bundlePath, "", true, false, true)
+	assert.NoError(err)
+	if err != nil {
+		return
+	}
+	assert.Equal(sandboxID, sandbox.ID())
+
+	_, err = vci.StartSandbox(ctx, sandboxID)
+	assert.NoError(err)
+
+	status, err := vci.StatusSandbox(ctx, sandboxID)
+	assert.NoError(err)
+	assert.Equal(types.StateRunning, status.State.State)
+	assert.Len(status.ContainersStatus, 1)
+
+	_, err = vci.StopSandbox(ctx, sandboxID, false)
+	assert.NoError(err)
+
+	_, err = vci.DeleteSandbox(ctx, sandboxID)
+	assert.NoError(err)
+}
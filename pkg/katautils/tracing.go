@@ -9,6 +9,7 @@ import (
 	"context"
 	"io"
 
+	vctracing "github.com/kata-containers/runtime/virtcontainers/pkg/tracing"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go/config"
 )
@@ -48,7 +49,11 @@ func CreateTracer(name string) (opentracing.Tracer, error) {
 
 	logger := traceLogger{}
 
-	tracer, closer, err := cfg.NewTracer(config.Logger(logger))
+	// Propagate the trace context in the W3C format of OpenTelemetry, for
+	// the spans to join the traces of containerd and of the agent.
+	options := append([]config.Option{config.Logger(logger)}, vctracing.TracerOptions()...)
+
+	tracer, closer, err := cfg.NewTracer(options...)
 	if err != nil {
 		return nil, err
 	}
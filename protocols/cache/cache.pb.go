@@ -12,6 +12,8 @@
 		GrpcVM
 		GrpcStatus
 		GrpcVMStatus
+		GrpcWarmRequest
+		GrpcEvictRequest
 */
 package cache
 
@@ -154,6 +156,7 @@ type GrpcVMStatus struct {
 	Pid    int64  `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
 	Cpu    uint32 `protobuf:"varint,2,opt,name=cpu,proto3" json:"cpu,omitempty"`
 	Memory uint32 `protobuf:"varint,3,opt,name=memory,proto3" json:"memory,omitempty"`
+	Id     string `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
 }
 
 func (m *GrpcVMStatus) Reset()                    { *m = GrpcVMStatus{} }
@@ -182,11 +185,52 @@ func (m *GrpcVMStatus) GetMemory() uint32 {
 	return 0
 }
 
+func (m *GrpcVMStatus) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type GrpcWarmRequest struct {
+	Count uint32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (m *GrpcWarmRequest) Reset()                    { *m = GrpcWarmRequest{} }
+func (m *GrpcWarmRequest) String() string            { return proto.CompactTextString(m) }
+func (*GrpcWarmRequest) ProtoMessage()               {}
+func (*GrpcWarmRequest) Descriptor() ([]byte, []int) { return fileDescriptorCache, []int{4} }
+
+func (m *GrpcWarmRequest) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+type GrpcEvictRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (m *GrpcEvictRequest) Reset()                    { *m = GrpcEvictRequest{} }
+func (m *GrpcEvictRequest) String() string            { return proto.CompactTextString(m) }
+func (*GrpcEvictRequest) ProtoMessage()               {}
+func (*GrpcEvictRequest) Descriptor() ([]byte, []int) { return fileDescriptorCache, []int{5} }
+
+func (m *GrpcEvictRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*GrpcVMConfig)(nil), "cache.GrpcVMConfig")
 	proto.RegisterType((*GrpcVM)(nil), "cache.GrpcVM")
 	proto.RegisterType((*GrpcStatus)(nil), "cache.GrpcStatus")
 	proto.RegisterType((*GrpcVMStatus)(nil), "cache.GrpcVMStatus")
+	proto.RegisterType((*GrpcWarmRequest)(nil), "cache.GrpcWarmRequest")
+	proto.RegisterType((*GrpcEvictRequest)(nil), "cache.GrpcEvictRequest")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -204,6 +248,8 @@ type CacheServiceClient interface {
 	GetBaseVM(ctx context.Context, in *google_protobuf.Empty, opts ...grpc.CallOption) (*GrpcVM, error)
 	Status(ctx context.Context, in *google_protobuf.Empty, opts ...grpc.CallOption) (*GrpcStatus, error)
 	Quit(ctx context.Context, in *google_protobuf.Empty, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	Warm(ctx context.Context, in *GrpcWarmRequest, opts ...grpc.CallOption) (*GrpcStatus, error)
+	Evict(ctx context.Context, in *GrpcEvictRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
 }
 
 type cacheServiceClient struct {
@@ -250,6 +296,24 @@ func (c *cacheServiceClient) Quit(ctx context.Context, in *google_protobuf.Empty
 	return out, nil
 }
 
+func (c *cacheServiceClient) Warm(ctx context.Context, in *GrpcWarmRequest, opts ...grpc.CallOption) (*GrpcStatus, error) {
+	out := new(GrpcStatus)
+	err := grpc.Invoke(ctx, "/cache.CacheService/Warm", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cacheServiceClient) Evict(ctx context.Context, in *GrpcEvictRequest, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
+	out := new(google_protobuf.Empty)
+	err := grpc.Invoke(ctx, "/cache.CacheService/Evict", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CacheService service
 
 type CacheServiceServer interface {
@@ -257,6 +321,8 @@ type CacheServiceServer interface {
 	GetBaseVM(context.Context, *google_protobuf.Empty) (*GrpcVM, error)
 	Status(context.Context, *google_protobuf.Empty) (*GrpcStatus, error)
 	Quit(context.Context, *google_protobuf.Empty) (*google_protobuf.Empty, error)
+	Warm(context.Context, *GrpcWarmRequest) (*GrpcStatus, error)
+	Evict(context.Context, *GrpcEvictRequest) (*google_protobuf.Empty, error)
 }
 
 func RegisterCacheServiceServer(s *grpc.Server, srv CacheServiceServer) {
@@ -335,6 +401,42 @@ func _CacheService_Quit_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CacheService_Warm_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrpcWarmRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).Warm(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cache.CacheService/Warm",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).Warm(ctx, req.(*GrpcWarmRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CacheService_Evict_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrpcEvictRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CacheServiceServer).Evict(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cache.CacheService/Evict",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CacheServiceServer).Evict(ctx, req.(*GrpcEvictRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CacheService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "cache.CacheService",
 	HandlerType: (*CacheServiceServer)(nil),
@@ -355,6 +457,14 @@ var _CacheService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Quit",
 			Handler:    _CacheService_Quit_Handler,
 		},
+		{
+			MethodName: "Warm",
+			Handler:    _CacheService_Warm_Handler,
+		},
+		{
+			MethodName: "Evict",
+			Handler:    _CacheService_Evict_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "cache.proto",
@@ -511,6 +621,59 @@ func (m *GrpcVMStatus) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintCache(dAtA, i, uint64(m.Memory))
 	}
+	if len(m.Id) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintCache(dAtA, i, uint64(len(m.Id)))
+		i += copy(dAtA[i:], m.Id)
+	}
+	return i, nil
+}
+
+func (m *GrpcWarmRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *GrpcWarmRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Count != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintCache(dAtA, i, uint64(m.Count))
+	}
+	return i, nil
+}
+
+func (m *GrpcEvictRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *GrpcEvictRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Id) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintCache(dAtA, i, uint64(len(m.Id)))
+		i += copy(dAtA[i:], m.Id)
+	}
 	return i, nil
 }
 
@@ -594,6 +757,29 @@ func (m *GrpcVMStatus) Size() (n int) {
 	if m.Memory != 0 {
 		n += 1 + sovCache(uint64(m.Memory))
 	}
+	l = len(m.Id)
+	if l > 0 {
+		n += 1 + l + sovCache(uint64(l))
+	}
+	return n
+}
+
+func (m *GrpcWarmRequest) Size() (n int) {
+	var l int
+	_ = l
+	if m.Count != 0 {
+		n += 1 + sovCache(uint64(m.Count))
+	}
+	return n
+}
+
+func (m *GrpcEvictRequest) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Id)
+	if l > 0 {
+		n += 1 + l + sovCache(uint64(l))
+	}
 	return n
 }
 
@@ -1123,6 +1309,183 @@ func (m *GrpcVMStatus) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCache
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCache
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Id = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCache(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthCache
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *GrpcWarmRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCache
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: GrpcWarmRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: GrpcWarmRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Count", wireType)
+			}
+			m.Count = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCache
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Count |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCache(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthCache
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *GrpcEvictRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCache
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: GrpcEvictRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: GrpcEvictRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCache
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCache
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Id = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipCache(dAtA[iNdEx:])
@@ -1252,29 +1615,33 @@ var (
 func init() { proto.RegisterFile("cache.proto", fileDescriptorCache) }
 
 var fileDescriptorCache = []byte{
-	// 374 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x51, 0xcd, 0x0a, 0xda, 0x40,
-	0x10, 0x66, 0x13, 0x4d, 0x75, 0x8c, 0xa5, 0xdd, 0x82, 0x2c, 0x16, 0x42, 0xc8, 0x29, 0xa7, 0x08,
-	0x4a, 0x7b, 0xaf, 0x5a, 0x84, 0x52, 0x69, 0x1b, 0xa9, 0xf7, 0x18, 0xd7, 0x18, 0x30, 0xee, 0x92,
-	0x6c, 0xa4, 0x79, 0xb1, 0x3e, 0x43, 0x8f, 0x7d, 0x84, 0xe2, 0xa1, 0xcf, 0x51, 0x76, 0xb3, 0x86,
-	0x08, 0xcd, 0x6d, 0x66, 0xbe, 0x9f, 0xfd, 0x76, 0x06, 0x46, 0x71, 0x14, 0x9f, 0x69, 0xc0, 0x73,
-	0x26, 0x18, 0xee, 0xab, 0x66, 0xfa, 0x36, 0x61, 0x2c, 0xb9, 0xd0, 0x99, 0x1a, 0x1e, 0xca, 0xd3,
-	0x8c, 0x66, 0x5c, 0x54, 0x35, 0xc7, 0x5b, 0x83, 0xbd, 0xc9, 0x79, 0xbc, 0xdf, 0xae, 0xd8, 0xf5,
-	0x94, 0x26, 0x18, 0x43, 0x6f, 0x1d, 0x89, 0x88, 0x20, 0x17, 0xf9, 0x76, 0xa8, 0x6a, 0xec, 0xc2,
-	0xe8, 0x43, 0x42, 0xaf, 0xa2, 0xa6, 0x10, 0x43, 0x41, 0xed, 0x91, 0xf7, 0x13, 0x81, 0x55, 0xdb,
-	0xe0, 0x97, 0x60, 0xa4, 0x47, 0x25, 0x1f, 0x86, 0x46, 0x7a, 0xc4, 0x0e, 0xc0, 0xb9, 0xe2, 0x34,
-	0xbf, 0xa5, 0x05, 0xcb, 0xb5, 0xb6, 0x35, 0xc1, 0x53, 0x18, 0xf0, 0x9c, 0xfd, 0xa8, 0xbe, 0xa6,
-	0x47, 0x62, 0xba, 0xc8, 0x37, 0xc3, 0xa6, 0x6f, 0xb0, 0xef, 0xe1, 0x67, 0xd2, 0x53, 0x8e, 0x4d,
-	0x8f, 0x5f, 0x81, 0x19, 0xf3, 0x92, 0xf4, 0x5d, 0xe4, 0x8f, 0x43, 0x59, 0xe2, 0x09, 0x58, 0x19,
-	0xcd, 0x58, 0x5e, 0x11, 0x4b, 0x0d, 0x75, 0x27, 0x5d, 0x62, 0x5e, 0xae, 0xe9, 0x45, 0x44, 0xe4,
-	0x85, 0x42, 0x9a, 0xde, 0xfb, 0x02, 0x20, 0x73, 0xef, 0x44, 0x24, 0xca, 0x42, 0x7a, 0x72, 0x1d,
-	0xde, 0x0c, 0x65, 0x89, 0x67, 0x30, 0xb8, 0x65, 0x85, 0x42, 0x89, 0xe1, 0x9a, 0xfe, 0x68, 0xfe,
-	0x26, 0xa8, 0x57, 0x5c, 0x7f, 0xb7, 0x16, 0x86, 0x0d, 0xc9, 0xfb, 0xf4, 0xd8, 0x67, 0xa7, 0xa5,
-	0x0e, 0x6e, 0xfc, 0x2f, 0xb8, 0xd9, 0x0e, 0x3e, 0xff, 0x8b, 0xc0, 0x5e, 0xc9, 0xc7, 0x76, 0x72,
-	0x59, 0x31, 0xc5, 0xef, 0xc0, 0xd2, 0x67, 0x9a, 0x04, 0xf5, 0x51, 0x83, 0xc7, 0x51, 0x83, 0x8f,
-	0xf2, 0xa8, 0xd3, 0xe7, 0x74, 0x9a, 0x3c, 0x87, 0xe1, 0x86, 0x8a, 0x65, 0x54, 0xd0, 0xfd, 0xb6,
-	0x53, 0x39, 0x7e, 0x52, 0xe2, 0x05, 0x58, 0xfa, 0x07, 0x5d, 0x82, 0xd7, 0x2d, 0x81, 0xa6, 0xbe,
-	0x87, 0xde, 0xb7, 0x32, 0x15, 0x9d, 0x92, 0x8e, 0xf9, 0xd2, 0xfe, 0x75, 0x77, 0xd0, 0xef, 0xbb,
-	0x83, 0xfe, 0xdc, 0x1d, 0x74, 0xb0, 0x14, 0xba, 0xf8, 0x17, 0x00, 0x00, 0xff, 0xff, 0x40, 0x5e,
-	0x19, 0x6e, 0xcc, 0x02, 0x00, 0x00,
+	// 448 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x52, 0xcd, 0x6e, 0xd3, 0x40,
+	0x10, 0x96, 0x7f, 0x62, 0xda, 0x89, 0x03, 0x65, 0x40, 0xc1, 0x0a, 0x52, 0x64, 0xf9, 0x42, 0x4e,
+	0x8e, 0x48, 0x05, 0xe2, 0x4a, 0x9b, 0xaa, 0x17, 0x2a, 0x60, 0x2b, 0x8a, 0xc4, 0xcd, 0x75, 0xb6,
+	0xa9, 0xa5, 0x3a, 0xbb, 0xd8, 0xeb, 0x88, 0xbc, 0x18, 0xcf, 0xc0, 0x11, 0x89, 0x17, 0x40, 0x79,
+	0x12, 0xb4, 0x3f, 0xb1, 0x36, 0x28, 0xbe, 0xed, 0xcc, 0xf7, 0xb3, 0xb3, 0xdf, 0x0e, 0xf4, 0xf3,
+	0x2c, 0xbf, 0xa7, 0x29, 0xaf, 0x98, 0x60, 0xd8, 0x53, 0xc5, 0xe8, 0xe5, 0x92, 0xb1, 0xe5, 0x03,
+	0x9d, 0xaa, 0xe6, 0x6d, 0x73, 0x37, 0xa5, 0x25, 0x17, 0x1b, 0xcd, 0x49, 0xe6, 0x10, 0x5e, 0x56,
+	0x3c, 0xbf, 0xb9, 0x3a, 0x67, 0xab, 0xbb, 0x62, 0x89, 0x08, 0xfe, 0x3c, 0x13, 0x59, 0xe4, 0xc4,
+	0xce, 0x24, 0x24, 0xea, 0x8c, 0x31, 0xf4, 0xdf, 0x2f, 0xe9, 0x4a, 0x68, 0x4a, 0xe4, 0x2a, 0xc8,
+	0x6e, 0x25, 0x3f, 0x1d, 0x08, 0xb4, 0x0d, 0x3e, 0x06, 0xb7, 0x58, 0x28, 0xf9, 0x31, 0x71, 0x8b,
+	0x05, 0x8e, 0x01, 0xee, 0x37, 0x9c, 0x56, 0xeb, 0xa2, 0x66, 0x95, 0xd1, 0x5a, 0x1d, 0x1c, 0xc1,
+	0x11, 0xaf, 0xd8, 0x8f, 0xcd, 0xa7, 0x62, 0x11, 0x79, 0xb1, 0x33, 0xf1, 0x48, 0x5b, 0xb7, 0xd8,
+	0x17, 0xf2, 0x21, 0xf2, 0x95, 0x63, 0x5b, 0xe3, 0x09, 0x78, 0x39, 0x6f, 0xa2, 0x5e, 0xec, 0x4c,
+	0x06, 0x44, 0x1e, 0x71, 0x08, 0x41, 0x49, 0x4b, 0x56, 0x6d, 0xa2, 0x40, 0x35, 0x4d, 0x25, 0x5d,
+	0x72, 0xde, 0xcc, 0xe9, 0x83, 0xc8, 0xa2, 0x47, 0x0a, 0x69, 0xeb, 0xe4, 0x23, 0x80, 0x9c, 0xfb,
+	0x5a, 0x64, 0xa2, 0xa9, 0xa5, 0x27, 0x37, 0xc3, 0x7b, 0x44, 0x1e, 0x71, 0x0a, 0x47, 0xeb, 0xb2,
+	0x56, 0x68, 0xe4, 0xc6, 0xde, 0xa4, 0x3f, 0x7b, 0x96, 0xea, 0x88, 0xf5, 0x73, 0xb5, 0x90, 0xb4,
+	0xa4, 0xe4, 0x1b, 0x84, 0x36, 0x72, 0xc0, 0xd2, 0x0c, 0xee, 0x1e, 0x1a, 0xdc, 0xdb, 0x1b, 0x5c,
+	0x47, 0xe9, 0xef, 0xa2, 0x4c, 0x5e, 0xc1, 0x13, 0xe9, 0xfd, 0x35, 0xab, 0x4a, 0x42, 0xbf, 0x37,
+	0xb4, 0x16, 0xf8, 0x1c, 0x7a, 0x39, 0x6b, 0x56, 0x42, 0x5d, 0x30, 0x20, 0xba, 0x48, 0x12, 0x38,
+	0x91, 0xc4, 0x8b, 0x75, 0x91, 0x8b, 0x1d, 0xf3, 0xbf, 0x7f, 0x99, 0xfd, 0x71, 0x21, 0x3c, 0x97,
+	0x2f, 0xb9, 0x96, 0x3f, 0x91, 0x53, 0x7c, 0x03, 0x81, 0xd9, 0x81, 0x61, 0xaa, 0x37, 0x26, 0xdd,
+	0x6d, 0x4c, 0x7a, 0x21, 0x37, 0x66, 0xb4, 0xff, 0x74, 0x43, 0x9e, 0xc1, 0xf1, 0x25, 0x15, 0x67,
+	0x59, 0x4d, 0x6f, 0xae, 0x3a, 0x95, 0x83, 0x3d, 0x25, 0x9e, 0x42, 0x60, 0xe2, 0xe9, 0x12, 0x3c,
+	0xb5, 0x04, 0x86, 0xfa, 0x16, 0xfc, 0xcf, 0x4d, 0x21, 0x3a, 0x25, 0x1d, 0x7d, 0x7c, 0x0d, 0xbe,
+	0x4c, 0x0c, 0x87, 0x96, 0xa5, 0x15, 0xe1, 0xa1, 0xab, 0xde, 0x41, 0x4f, 0x65, 0x87, 0x2f, 0x2c,
+	0xcc, 0x4e, 0xb3, 0xeb, 0xb2, 0xb3, 0xf0, 0xd7, 0x76, 0xec, 0xfc, 0xde, 0x8e, 0x9d, 0xbf, 0xdb,
+	0xb1, 0x73, 0x1b, 0x28, 0xf4, 0xf4, 0xdf, 0x00, 0x58, 0x63, 0x87, 0x51, 0x96, 0x03, 0x00, 0x00,
 }
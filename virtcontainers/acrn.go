@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +20,7 @@ import (
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/kata-containers/runtime/virtcontainers/utils"
@@ -91,6 +91,10 @@ func (a *acrn) hypervisorConfig() HypervisorConfig {
 	return a.config
 }
 
+func (a *acrn) generateSocket(id string, useVsock bool) (interface{}, error) {
+	return generateVMSocket(id, useVsock)
+}
+
 // get the acrn binary path
 func (a *acrn) acrnPath() (string, error) {
 	p, err := a.config.HypervisorAssetPath()
@@ -370,14 +374,14 @@ func (a *acrn) startSandbox(timeoutSecs int) error {
 		a.Logger().WithField("default-kernel-parameters", formatted).Debug()
 	}
 
-	vmPath := filepath.Join(store.RunVMStoragePath, a.id)
+	vmPath := sandboxTmpDir(a.id)
 	err := os.MkdirAll(vmPath, store.DirMode)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err != nil {
-			if err := os.RemoveAll(vmPath); err != nil {
+			if err := removeVMDir(vmPath); err != nil {
 				a.Logger().WithError(err).Error("Failed to clean up vm directory")
 			}
 		}
@@ -475,6 +479,10 @@ func (a *acrn) updateBlockDevice(drive *config.BlockDrive) error {
 			drive.ID, drive.Index)
 	}
 
+	if drive.Format != "" && drive.Format != config.BlockFormatRaw {
+		return fmt.Errorf("acrn only supports raw images, not %s", drive.Format)
+	}
+
 	slot := AcrnBlkdDevSlot[drive.Index]
 
 	//Explicitly set PCIAddr to NULL, so that VirtPath can be used
@@ -517,6 +525,10 @@ func (a *acrn) hotplugRemoveDevice(devInfo interface{}, devType deviceType) (int
 	return nil, nil
 }
 
+func (a *acrn) setBlockDeviceDriver(driver string) {
+	a.config.BlockDeviceDriver = driver
+}
+
 func (a *acrn) pauseSandbox() error {
 	span, _ := a.trace("pauseSandbox")
 	defer span.Finish()
@@ -574,7 +586,7 @@ func (a *acrn) getSandboxConsole(id string) (string, error) {
 	span, _ := a.trace("getSandboxConsole")
 	defer span.Finish()
 
-	return utils.BuildSocketPath(store.RunVMStoragePath, id, acrnConsoleSocket)
+	return utils.BuildSocketPath(sandboxTmpDir(id), acrnConsoleSocket)
 }
 
 func (a *acrn) saveSandbox() error {
@@ -602,6 +614,18 @@ func (a *acrn) getThreadIDs() (vcpuThreadIDs, error) {
 	return vcpuThreadIDs{}, nil
 }
 
+func (a *acrn) metrics() (HypervisorMetrics, error) {
+	span, _ := a.trace("metrics")
+	defer span.Finish()
+
+	// vCPU threads are not supported, only the process is accounted.
+	return processMetrics(a.info.PID, vcpuThreadIDs{})
+}
+
+func (a *acrn) hotplugCapacity() (hotplugCapacity, error) {
+	return hotplugCapacity{}, errors.New("acrn does not support reserving hotplug capacity")
+}
+
 func (a *acrn) resizeMemory(reqMemMB uint32, memoryBlockSizeMB uint32, probe bool) (uint32, memoryDevice, error) {
 	return 0, memoryDevice{}, nil
 }
@@ -650,10 +674,18 @@ func (a *acrn) load(s persistapi.HypervisorState) {
 	a.state.UUID = s.UUID
 }
 
-func (a *acrn) check() error {
+func (a *acrn) check(agentCheck func() error) error {
 	if err := syscall.Kill(a.info.PID, syscall.Signal(0)); err != nil {
-		return errors.Wrapf(err, "failed to ping acrn process")
+		return errors.Wrapf(ErrVMMDead, "failed to ping acrn process: %v", err)
 	}
 
-	return nil
+	return checkAgent(agentCheck)
+}
+
+func (a *acrn) migrateSandbox(config MigrationConfig) error {
+	return errors.Wrap(vcTypes.ErrNotSupported, "acrn live migration")
+}
+
+func (a *acrn) receiveMigration(config MigrationConfig) error {
+	return errors.Wrap(vcTypes.ErrNotSupported, "acrn live migration")
 }
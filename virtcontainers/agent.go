@@ -53,6 +53,27 @@ const (
 	SocketTypeUNIX = "unix"
 )
 
+// AgentHealth describes the state of the connection of the runtime to the
+// agent.
+type AgentHealth string
+
+const (
+	// AgentHealthUnknown is the health of an agent the runtime did not
+	// talk to yet.
+	AgentHealthUnknown AgentHealth = ""
+
+	// AgentHealthy is the health of an agent answering the runtime.
+	AgentHealthy AgentHealth = "healthy"
+
+	// AgentReconnecting is the health of an agent the runtime lost the
+	// connection to, and is connecting to again.
+	AgentReconnecting AgentHealth = "reconnecting"
+
+	// AgentUnreachable is the health of an agent the runtime could not
+	// connect to again, or whose guest is dead.
+	AgentUnreachable AgentHealth = "unreachable"
+)
+
 // Set sets an agent type based on the input string.
 func (agentType *AgentType) Set(value string) error {
 	switch value {
@@ -159,6 +180,10 @@ type agent interface {
 	// createContainer will tell the agent to create a container related to a Sandbox.
 	createContainer(sandbox *Sandbox, c *Container) (*Process, error)
 
+	// shareContainerFiles shares again with the guest the files of a
+	// container it runs, on the host its sandbox has been migrated to.
+	shareContainerFiles(sandbox *Sandbox, c *Container) error
+
 	// startContainer will tell the agent to start a container related to a Sandbox.
 	startContainer(sandbox *Sandbox, c *Container) error
 
@@ -254,6 +279,9 @@ type agent interface {
 	// markDead tell agent that the guest is dead
 	markDead()
 
+	// health returns the state of the connection to the agent
+	health() AgentHealth
+
 	// cleanup removes all on disk information generated by the agent
 	cleanup(s *Sandbox)
 
@@ -0,0 +1,241 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/kata-containers/agent/protocols/grpc"
+	"github.com/sirupsen/logrus"
+)
+
+// AgentPolicyAction is the decision taken on the agent requests matching a
+// policy rule.
+type AgentPolicyAction string
+
+const (
+	// AgentPolicyAllow forwards the request to the agent.
+	AgentPolicyAllow AgentPolicyAction = "allow"
+
+	// AgentPolicyDeny fails the request without forwarding it.
+	AgentPolicyDeny AgentPolicyAction = "deny"
+)
+
+// The agent requests a policy can filter.
+const (
+	// agentPolicyExec matches the processes executed in the containers,
+	// by the path of their binary and their full argv.
+	agentPolicyExec = "exec"
+
+	// agentPolicyCopyFile matches the files copied to the guest, by
+	// their guest path.
+	agentPolicyCopyFile = "copy_file"
+
+	// agentPolicyMount matches the storages and devices mounted in the
+	// containers, by their mount point in the guest or the container.
+	agentPolicyMount = "mount"
+)
+
+// ErrAgentRequestDenied is the error of the agent requests denied by the
+// sandbox policy.
+var ErrAgentRequestDenied = errors.New("agent request denied by the sandbox policy")
+
+// AgentPolicyRule is a rule of an agent policy.
+type AgentPolicyRule struct {
+	// Request is the kind of request the rule applies to: "exec",
+	// "copy_file" or "mount".
+	Request string `json:"request"`
+
+	// Action is the decision taken on the matching requests.
+	Action AgentPolicyAction `json:"action"`
+
+	// Paths restricts the rule to the requests on the paths matching
+	// one of these patterns, in filepath.Match syntax. The rule matches
+	// all the requests of its kind when empty.
+	Paths []string `json:"paths,omitempty"`
+
+	// Args restricts an exec rule to the processes whose full argv
+	// matches these patterns one by one, in filepath.Match syntax, the
+	// argv having exactly as many arguments as there are patterns. The
+	// binary is matched by the first pattern, and by Paths if any.
+	Args []string `json:"args,omitempty"`
+}
+
+// AgentPolicy is a per-sandbox policy document of the runtime
+// configuration, deciding which agent requests the runtime forwards to the
+// guest. The first rule matching a request decides, the Default action
+// applying when none does. The requests the rules cannot filter are always
+// forwarded.
+//
+// The binary of a process is the one its argv names, which the guest may
+// resolve to another file, e.g. through PATH or a link: an allow list,
+// denying by default and allowing full argvs, is the only policy a
+// container cannot sidestep.
+type AgentPolicy struct {
+	// Default is the decision taken on the requests no rule matches,
+	// AgentPolicyAllow if empty.
+	Default AgentPolicyAction `json:"default,omitempty"`
+
+	Rules []AgentPolicyRule `json:"rules"`
+}
+
+func validAgentPolicyAction(action AgentPolicyAction) bool {
+	return action == AgentPolicyAllow || action == AgentPolicyDeny
+}
+
+// ParseAgentPolicy parses and validates a JSON agent policy document.
+func ParseAgentPolicy(document string) (*AgentPolicy, error) {
+	var p AgentPolicy
+
+	if err := json.Unmarshal([]byte(document), &p); err != nil {
+		return nil, fmt.Errorf("Invalid agent policy: %v", err)
+	}
+
+	if p.Default == "" {
+		p.Default = AgentPolicyAllow
+	}
+
+	if !validAgentPolicyAction(p.Default) {
+		return nil, fmt.Errorf("Invalid agent policy default action %q", p.Default)
+	}
+
+	for i, r := range p.Rules {
+		switch r.Request {
+		case agentPolicyExec, agentPolicyCopyFile, agentPolicyMount:
+		default:
+			return nil, fmt.Errorf("Invalid agent policy rule %d: unknown request %q", i, r.Request)
+		}
+
+		if !validAgentPolicyAction(r.Action) {
+			return nil, fmt.Errorf("Invalid agent policy rule %d: unknown action %q", i, r.Action)
+		}
+
+		for _, pattern := range r.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid agent policy rule %d: bad path pattern %q", i, pattern)
+			}
+		}
+
+		if len(r.Args) > 0 && r.Request != agentPolicyExec {
+			return nil, fmt.Errorf("Invalid agent policy rule %d: args only apply to %q requests", i, agentPolicyExec)
+		}
+
+		for _, pattern := range r.Args {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid agent policy rule %d: bad argument pattern %q", i, pattern)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+// agentPolicySubject is what a policy matches an agent request on: the
+// path it acts on, and the argv of the processes it executes.
+type agentPolicySubject struct {
+	path string
+	args []string
+}
+
+// matches returns true if the rule applies to the request kind on subject.
+func (r AgentPolicyRule) matches(kind string, subject agentPolicySubject) bool {
+	if r.Request != kind {
+		return false
+	}
+
+	if len(r.Args) > 0 {
+		if len(r.Args) != len(subject.args) {
+			return false
+		}
+
+		for i, pattern := range r.Args {
+			if ok, _ := filepath.Match(pattern, subject.args[i]); !ok {
+				return false
+			}
+		}
+	}
+
+	if len(r.Paths) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.Paths {
+		if ok, _ := filepath.Match(pattern, subject.path); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decide returns the action taken on the request kind on subject.
+func (p *AgentPolicy) decide(kind string, subject agentPolicySubject) AgentPolicyAction {
+	for _, r := range p.Rules {
+		if r.matches(kind, subject) {
+			return r.Action
+		}
+	}
+
+	return p.Default
+}
+
+// agentPolicySubjects returns the kind of an agent request and the subjects
+// it acts on, or an empty kind for the requests a policy cannot filter. The
+// binary paths are cleaned, not to sidestep the rules with "//" or "..".
+func agentPolicySubjects(request interface{}) (string, []agentPolicySubject) {
+	switch req := request.(type) {
+	case *grpc.ExecProcessRequest:
+		var subject agentPolicySubject
+		if req.Process != nil && len(req.Process.Args) > 0 {
+			subject.args = req.Process.Args
+			subject.path = filepath.Clean(req.Process.Args[0])
+		}
+		return agentPolicyExec, []agentPolicySubject{subject}
+	case *grpc.CopyFileRequest:
+		return agentPolicyCopyFile, []agentPolicySubject{{path: req.Path}}
+	case *grpc.CreateContainerRequest:
+		var subjects []agentPolicySubject
+		for _, s := range req.Storages {
+			subjects = append(subjects, agentPolicySubject{path: s.MountPoint})
+		}
+		for _, d := range req.Devices {
+			subjects = append(subjects, agentPolicySubject{path: d.ContainerPath})
+		}
+		return agentPolicyMount, subjects
+	}
+
+	return "", nil
+}
+
+// evaluate returns ErrAgentRequestDenied if the policy denies request. A
+// nil policy allows all the requests.
+func (p *AgentPolicy) evaluate(request interface{}) error {
+	if p == nil {
+		return nil
+	}
+
+	kind, subjects := agentPolicySubjects(request)
+	if kind == "" {
+		return nil
+	}
+
+	for _, subject := range subjects {
+		if p.decide(kind, subject) == AgentPolicyDeny {
+			virtLog.WithFields(logrus.Fields{
+				"subsystem": "agent_policy",
+				"request":   kind,
+				"path":      subject.path,
+			}).Warn("Agent request denied")
+
+			return fmt.Errorf("%s %q: %v", kind, subject.path, ErrAgentRequestDenied)
+		}
+	}
+
+	return nil
+}
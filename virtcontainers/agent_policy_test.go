@@ -0,0 +1,97 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kata-containers/agent/protocols/grpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAgentPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParseAgentPolicy(`{"rules": []}`)
+	assert.NoError(err)
+	assert.Equal(AgentPolicyAllow, p.Default)
+
+	for _, document := range []string{
+		``,
+		`{"default": "maybe"}`,
+		`{"rules": [{"request": "reboot", "action": "deny"}]}`,
+		`{"rules": [{"request": "exec", "action": "log"}]}`,
+		`{"rules": [{"request": "exec", "action": "deny", "paths": ["["]}]}`,
+		`{"rules": [{"request": "exec", "action": "deny", "args": ["["]}]}`,
+		`{"rules": [{"request": "copy_file", "action": "deny", "args": ["/etc/*"]}]}`,
+	} {
+		_, err := ParseAgentPolicy(document)
+		assert.Error(err, "policy %s", document)
+	}
+}
+
+func TestAgentPolicyEvaluate(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilPolicy *AgentPolicy
+	assert.NoError(nilPolicy.evaluate(&grpc.ExecProcessRequest{}))
+
+	p, err := ParseAgentPolicy(`{
+		"rules": [
+			{"request": "exec", "action": "allow", "paths": ["/usr/bin/*"]},
+			{"request": "exec", "action": "deny"},
+			{"request": "copy_file", "action": "deny", "paths": ["/etc/*"]},
+			{"request": "mount", "action": "deny", "paths": ["/dev/*"]}
+		]
+	}`)
+	assert.NoError(err)
+
+	exec := func(args ...string) interface{} {
+		return &grpc.ExecProcessRequest{Process: &grpc.Process{Args: args}}
+	}
+
+	assert.NoError(p.evaluate(exec("/usr/bin/ls", "-l")))
+	assert.Error(p.evaluate(exec("/bin/sh", "-c", "id")))
+	assert.Error(p.evaluate(exec()))
+
+	assert.NoError(p.evaluate(&grpc.CopyFileRequest{Path: "/run/kata-containers/shared/containers/hosts"}))
+	err = p.evaluate(&grpc.CopyFileRequest{Path: "/etc/shadow"})
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), ErrAgentRequestDenied.Error()))
+
+	assert.NoError(p.evaluate(&grpc.CreateContainerRequest{
+		Storages: []*grpc.Storage{{MountPoint: "/run/kata-containers/shared/containers/foo"}},
+	}))
+	assert.Error(p.evaluate(&grpc.CreateContainerRequest{
+		Storages: []*grpc.Storage{{MountPoint: "/run/kata-containers/shared/containers/foo"}},
+		Devices:  []*grpc.Device{{ContainerPath: "/dev/sda"}},
+	}))
+
+	// The full argv is matched, and the binary path is cleaned.
+	p, err = ParseAgentPolicy(`{
+		"default": "deny",
+		"rules": [
+			{"request": "exec", "action": "deny", "paths": ["/bin/sh"]},
+			{"request": "exec", "action": "allow", "args": ["/usr/bin/ps", "-*"]},
+			{"request": "exec", "action": "allow", "args": ["/*/*/*", "--version"]}
+		]
+	}`)
+	assert.NoError(err)
+
+	assert.NoError(p.evaluate(exec("/usr/bin/ps", "-ef")))
+	assert.NoError(p.evaluate(exec("/usr/bin/ls", "--version")))
+	assert.Error(p.evaluate(exec("/usr/bin/ps")))
+	assert.Error(p.evaluate(exec("/usr/bin/ps", "-ef", "x")))
+	assert.Error(p.evaluate(exec("/usr/bin/ps", "ef")))
+	assert.Error(p.evaluate(exec("/bin//sh", "--version")))
+	assert.Error(p.evaluate(exec("/usr/../bin/sh", "--version")))
+
+	// Requests which cannot be filtered are always allowed.
+	p.Default = AgentPolicyDeny
+	assert.NoError(p.evaluate(&grpc.CheckRequest{}))
+	assert.Error(p.evaluate(&grpc.CopyFileRequest{Path: "/tmp/foo"}))
+}
@@ -13,6 +13,7 @@ import (
 
 	deviceApi "github.com/kata-containers/runtime/virtcontainers/device/api"
 	deviceConfig "github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/persist/fs"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
@@ -51,6 +52,26 @@ func SetLogger(ctx context.Context, logger *logrus.Entry) {
 	store.SetLogger(virtLog)
 }
 
+// SetStorageRootPaths relocates the configuration and runtime directories of
+// the sandboxes under configRoot and runRoot. An empty root keeps the
+// default location. The sandboxes found at the previous locations are
+// migrated to the new ones.
+func SetStorageRootPaths(configRoot, runRoot string) error {
+	if err := store.SetRootPaths(configRoot, runRoot); err != nil {
+		return err
+	}
+
+	if runRoot != "" {
+		fs.SetRunStoragePath(store.RunStoragePath)
+
+		if err := relocateMacAddressRegistry(runRoot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CreateSandbox is the virtcontainers sandbox creation entry point.
 // CreateSandbox creates a sandbox and its containers. It does not start them.
 func CreateSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factory) (VCSandbox, error) {
@@ -274,6 +295,77 @@ func StopSandbox(ctx context.Context, sandboxID string, force bool) (VCSandbox,
 	return s, nil
 }
 
+// PrepareMigration is the virtcontainers sandbox live migration preparation
+// entry point. PrepareMigration returns the sandbox configuration the target
+// host has to receive the sandbox from, through ReceiveSandbox, before the
+// sandbox is migrated with MigrateSandbox.
+func PrepareMigration(ctx context.Context, sandboxID string) (*SandboxConfig, error) {
+	span, ctx := trace(ctx, "PrepareMigration")
+	defer span.Finish()
+
+	if sandboxID == "" {
+		return nil, vcTypes.ErrNeedSandboxID
+	}
+
+	lockFile, err := rLockSandbox(ctx, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockSandbox(ctx, sandboxID, lockFile)
+
+	s, err := fetchSandbox(ctx, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseStatelessSandbox()
+
+	return s.PrepareMigration()
+}
+
+// MigrateSandbox is the virtcontainers sandbox live migration entry point.
+// MigrateSandbox migrates the VM of a running sandbox to the host receiving
+// it through ReceiveSandbox, and stops the sandbox on this host.
+func MigrateSandbox(ctx context.Context, sandboxID string, config MigrationConfig) error {
+	span, ctx := trace(ctx, "MigrateSandbox")
+	defer span.Finish()
+
+	if sandboxID == "" {
+		return vcTypes.ErrNeedSandboxID
+	}
+
+	lockFile, err := rwLockSandbox(ctx, sandboxID)
+	if err != nil {
+		return err
+	}
+	defer unlockSandbox(ctx, sandboxID, lockFile)
+
+	s, err := fetchSandbox(ctx, sandboxID)
+	if err != nil {
+		return err
+	}
+	defer s.releaseStatelessSandbox()
+
+	return s.Migrate(config)
+}
+
+// ReceiveSandbox is the virtcontainers sandbox live migration receiving entry
+// point. ReceiveSandbox creates the sandbox of sandboxConfig, as returned by
+// PrepareMigration on the source host, from the VM migrated through
+// config.URI.
+// The containers of the sandbox are running once it returns.
+func ReceiveSandbox(ctx context.Context, sandboxConfig SandboxConfig, config MigrationConfig) (VCSandbox, error) {
+	span, ctx := trace(ctx, "ReceiveSandbox")
+	defer span.Finish()
+
+	s, err := receiveSandbox(ctx, sandboxConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseStatelessSandbox()
+
+	return s, nil
+}
+
 // RunSandbox is the virtcontainers sandbox running entry point.
 // RunSandbox creates a sandbox and its containers and then it starts them.
 func RunSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factory) (VCSandbox, error) {
@@ -375,6 +467,7 @@ func StatusSandbox(ctx context.Context, sandboxID string) (SandboxStatus, error)
 		Hypervisor:       s.config.HypervisorType,
 		HypervisorConfig: s.config.HypervisorConfig,
 		Agent:            s.config.AgentType,
+		AgentHealth:      s.agent.health(),
 		ContainersStatus: contStatusList,
 		Annotations:      s.config.Annotations,
 	}
@@ -961,6 +1054,11 @@ func CleanupContainer(ctx context.Context, sandboxID, containerID string, force
 
 	s, err := fetchSandbox(ctx, sandboxID)
 	if err != nil {
+		// The temporary files of a sandbox which cannot be loaded
+		// anymore are removed along with its sandbox container.
+		if force && containerID == sandboxID {
+			sandboxTmp{id: sandboxID}.cleanup(virtLog.WithField("sandbox", sandboxID))
+		}
 		return err
 	}
 
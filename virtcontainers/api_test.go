@@ -578,6 +578,7 @@ func TestStatusSandboxSuccessfulStateReady(t *testing.T) {
 		Hypervisor:       MockHypervisor,
 		HypervisorConfig: hypervisorConfig,
 		Agent:            NoopAgentType,
+		AgentHealth:      AgentHealthy,
 		Annotations:      sandboxAnnotations,
 		ContainersStatus: []ContainerStatus{
 			{
@@ -602,9 +603,11 @@ func TestStatusSandboxSuccessfulStateReady(t *testing.T) {
 	status, err := StatusSandbox(ctx, p.ID())
 	assert.NoError(err)
 
-	// Copy the start time as we can't pretend we know what that
-	// value will be.
+	// Copy the start time and the provenance as we can't pretend we
+	// know what these values will be.
 	expectedStatus.ContainersStatus[0].StartTime = status.ContainersStatus[0].StartTime
+	assert.NotNil(status.State.Provenance)
+	expectedStatus.State.Provenance = status.State.Provenance
 
 	assert.Equal(status, expectedStatus)
 }
@@ -637,6 +640,7 @@ func TestStatusSandboxSuccessfulStateRunning(t *testing.T) {
 		Hypervisor:       MockHypervisor,
 		HypervisorConfig: hypervisorConfig,
 		Agent:            NoopAgentType,
+		AgentHealth:      AgentHealthy,
 		Annotations:      sandboxAnnotations,
 		ContainersStatus: []ContainerStatus{
 			{
@@ -665,9 +669,11 @@ func TestStatusSandboxSuccessfulStateRunning(t *testing.T) {
 	status, err := StatusSandbox(ctx, p.ID())
 	assert.NoError(err)
 
-	// Copy the start time as we can't pretend we know what that
-	// value will be.
+	// Copy the start time and the provenance as we can't pretend we
+	// know what these values will be.
 	expectedStatus.ContainersStatus[0].StartTime = status.ContainersStatus[0].StartTime
+	assert.NotNil(status.State.Provenance)
+	expectedStatus.State.Provenance = status.State.Provenance
 
 	assert.Exactly(status, expectedStatus)
 }
@@ -0,0 +1,278 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAuditLogDir is the directory of the audit logs of the
+	// sandboxes. It is persistent, the logs being kept once the sandboxes
+	// are deleted for the postmortems of their failures.
+	defaultAuditLogDir = "/var/log/kata-containers/audit"
+
+	// defaultAuditLogMaxSizeMiB is the size above which the audit log of
+	// a sandbox is rotated, only the previous log being kept.
+	defaultAuditLogMaxSizeMiB = 16
+)
+
+const (
+	auditQMP     = "qmp"
+	auditHotplug = "hotplug"
+	auditAgent   = "agent"
+)
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time      time.Time   `json:"time"`
+	Kind      string      `json:"kind"`
+	Command   string      `json:"command"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	Duration  float64     `json:"duration_seconds"`
+	Result    string      `json:"result"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// auditLog records in JSON lines the QMP commands, the hotplug operations
+// and the agent RPCs of a sandbox, with their redacted arguments and their
+// result, for the postmortems of their failures. Its methods are no-ops on
+// a nil auditLog, the audit log being optional.
+type auditLog struct {
+	sync.Mutex
+	dir     string
+	path    string
+	maxSize int64
+	size    int64
+	file    *os.File
+}
+
+// newAuditLog returns the audit log of the sandbox sandboxID in dir, rotated
+// once larger than maxSizeMiB.
+func newAuditLog(dir, sandboxID string, maxSizeMiB uint32) *auditLog {
+	if dir == "" {
+		dir = defaultAuditLogDir
+	}
+
+	if maxSizeMiB == 0 {
+		maxSizeMiB = defaultAuditLogMaxSizeMiB
+	}
+
+	return &auditLog{
+		dir:     dir,
+		path:    filepath.Join(dir, sandboxID+".log"),
+		maxSize: int64(maxSizeMiB) << 20,
+	}
+}
+
+type auditLogKey struct{}
+
+// withAuditLog returns a copy of ctx carrying the audit log, for the
+// hypervisor and the agent of the sandbox to record their commands.
+func withAuditLog(ctx context.Context, a *auditLog) context.Context {
+	return context.WithValue(ctx, auditLogKey{}, a)
+}
+
+// auditLogFromContext returns the audit log of ctx, nil if the sandbox has
+// none.
+func auditLogFromContext(ctx context.Context) *auditLog {
+	if ctx == nil {
+		return nil
+	}
+
+	a, _ := ctx.Value(auditLogKey{}).(*auditLog)
+
+	return a
+}
+
+// record appends the entry of the command started at start to the log.
+func (a *auditLog) record(kind, command string, arguments interface{}, start time.Time, err error) {
+	if a == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:      start.UTC(),
+		Kind:      kind,
+		Command:   command,
+		Arguments: arguments,
+		Duration:  time.Since(start).Seconds(),
+		Result:    "ok",
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Error = err.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		virtLog.WithError(err).WithField("command", command).Warn("failed to encode the audit log entry")
+		return
+	}
+
+	data = append(data, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.file != nil && a.size+int64(len(data)) > a.maxSize {
+		a.rotate()
+	}
+
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			virtLog.WithError(err).WithField("path", a.path).Warn("failed to open the audit log")
+			return
+		}
+	}
+
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	if err != nil {
+		virtLog.WithError(err).WithField("path", a.path).Warn("failed to write the audit log")
+	}
+}
+
+func (a *auditLog) open() error {
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.file = f
+	a.size = fi.Size()
+
+	return nil
+}
+
+// rotate moves the log to path.1, replacing the previous one, for the
+// entries to be written to a new log.
+func (a *auditLog) rotate() {
+	a.file.Close()
+	a.file = nil
+
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		virtLog.WithError(err).WithField("path", a.path).Warn("failed to rotate the audit log")
+	}
+}
+
+// hotplug records a hotplug operation of the hypervisor.
+func (a *auditLog) hotplug(op operation, devType deviceType, devInfo interface{}, start time.Time, err error) {
+	arguments := map[string]string{
+		"device": devType.String(),
+	}
+	if id := hotplugDeviceID(devInfo); id != "" {
+		arguments["id"] = id
+	}
+
+	a.record(auditHotplug, op.String(), arguments, start, err)
+}
+
+// close closes the audit log, which is opened again if entries are
+// recorded later.
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// qmpCommandAudit records the QMP commands in the audit log of the sandbox
+// from the messages logged by the QMP client, which sends a command once
+// the previous one is answered.
+type qmpCommandAudit struct {
+	sync.Mutex
+	command   string
+	arguments json.RawMessage
+	start     time.Time
+
+	// audit is the audit log of the sandbox, where the commands are
+	// recorded with their arguments scrubbed.
+	audit    *auditLog
+	scrubber logScrubber
+}
+
+// sent records the start of the command logged in msg.
+func (t *qmpCommandAudit) sent(msg string) {
+	var cmd struct {
+		Execute   string          `json:"execute"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(msg), &cmd); err != nil || cmd.Execute == "" {
+		cmd.Execute = "unknown"
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.command = cmd.Execute
+	t.arguments = cmd.Arguments
+	t.start = time.Now()
+}
+
+// received records the pending command in the audit log when the message
+// logged in msg is its response, and not an event.
+func (t *qmpCommandAudit) received(msg string) {
+	if !strings.HasPrefix(msg, `{"error"`) && !strings.HasPrefix(msg, `{"return"`) {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.command == "" {
+		return
+	}
+
+	var arguments interface{}
+	if len(t.arguments) != 0 {
+		arguments = t.scrubber.scrubArguments(t.arguments)
+	}
+	t.audit.record(auditQMP, t.command, arguments, t.start, qmpResponseError(msg))
+
+	t.command = ""
+	t.arguments = nil
+}
+
+// qmpResponseError returns the error of the QMP response msg, nil if it
+// succeeded.
+func qmpResponseError(msg string) error {
+	var resp struct {
+		Error *struct {
+			Class string `json:"class"`
+			Desc  string `json:"desc"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(msg), &resp); err != nil || resp.Error == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%s: %s", resp.Error.Class, resp.Error.Desc)
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kata-containers/agent/protocols/grpc"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	golangGrpc "google.golang.org/grpc"
+)
+
+func readAuditLog(t *testing.T, dir string) []map[string]interface{} {
+	data, err := ioutil.ReadFile(filepath.Join(dir, testSandboxID+".log"))
+	assert.NoError(t, err)
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestAuditLogRecord(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	a := newAuditLog(dir, testSandboxID, 0)
+	ctx := withAuditLog(context.Background(), a)
+	assert.Equal(a, auditLogFromContext(ctx))
+	assert.Nil(auditLogFromContext(context.Background()))
+	assert.Nil(auditLogFromContext(nil))
+
+	start := time.Now()
+	a.hotplug(addDevice, blockDev, &config.BlockDrive{ID: "drive-1"}, start, nil)
+	a.hotplug(removeDevice, vfioDev, nil, start, errors.New("device busy"))
+
+	// The log is opened again in append mode once closed.
+	a.close()
+	a.record(auditAgent, "grpc.CheckRequest", nil, start, nil)
+	a.close()
+
+	info, err := os.Stat(filepath.Join(dir, testSandboxID+".log"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	entries := readAuditLog(t, dir)
+	assert.Len(entries, 3)
+
+	assert.Equal(auditHotplug, entries[0]["kind"])
+	assert.Equal("add", entries[0]["command"])
+	assert.Equal(map[string]interface{}{"device": "block", "id": "drive-1"}, entries[0]["arguments"])
+	assert.Equal("ok", entries[0]["result"])
+	assert.NotContains(entries[0], "error")
+
+	assert.Equal("remove", entries[1]["command"])
+	assert.Equal(map[string]interface{}{"device": "VFIO"}, entries[1]["arguments"])
+	assert.Equal("error", entries[1]["result"])
+	assert.Equal("device busy", entries[1]["error"])
+
+	assert.Equal(auditAgent, entries[2]["kind"])
+	assert.NotContains(entries[2], "arguments")
+
+	// The audit log is optional.
+	var disabled *auditLog
+	disabled.record(auditAgent, "grpc.CheckRequest", nil, start, nil)
+	disabled.hotplug(addDevice, blockDev, nil, start, nil)
+	disabled.close()
+}
+
+func TestQMPLoggerAuditsCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	a := newAuditLog(dir, testSandboxID, 0)
+	defer a.close()
+
+	l := newQMPLogger(nil, a, newLogScrubber([]string{"secret", "token"}))
+
+	l.Infof("%s", `{"execute":"object-add","arguments":{"id":"sec0","props":"secret=xyz"}}`)
+	l.Infof("%s", `{"return": {}}`)
+	l.Infof("%s", `{"execute":"object-add","arguments":{"qom-type":"secret","id":"sec1","props":{"data":"xyz","token":"abc"}}}`)
+	l.Infof("%s", `{"event": "DEVICE_DELETED", "data": {}}`)
+	l.Infof("%s", `{"return": {}}`)
+	l.Infof("%s", `{"execute":"query-status"}`)
+	l.Infof("%s", `{"error": {"class": "GenericError", "desc": "failed"}}`)
+
+	entries := readAuditLog(t, dir)
+	assert.Len(entries, 3)
+
+	assert.Equal(auditQMP, entries[0]["kind"])
+	assert.Equal("object-add", entries[0]["command"])
+	assert.Equal(map[string]interface{}{"id": "sec0", "props": "secret=<redacted>"}, entries[0]["arguments"])
+	assert.Equal("ok", entries[0]["result"])
+
+	// the secret arguments are hidden from the log
+	assert.Equal(map[string]interface{}{
+		"qom-type": "secret",
+		"id":       "sec1",
+		"props":    map[string]interface{}{"data": scrubbedValue, "token": scrubbedValue},
+	}, entries[1]["arguments"])
+
+	assert.Equal("query-status", entries[2]["command"])
+	assert.NotContains(entries[2], "arguments")
+	assert.Equal("error", entries[2]["result"])
+	assert.Equal("GenericError: failed", entries[2]["error"])
+}
+
+func TestAuditRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte("data")
+
+	write := &grpc.WriteStreamRequest{ContainerId: "c", Data: data}
+	assert.Equal(&grpc.WriteStreamRequest{ContainerId: "c"}, auditRequest(write))
+	assert.Equal(data, write.Data)
+
+	copyFile := &grpc.CopyFileRequest{Path: "/etc/hosts", Data: data}
+	assert.Equal(&grpc.CopyFileRequest{Path: "/etc/hosts"}, auditRequest(copyFile))
+
+	reseed := &grpc.ReseedRandomDevRequest{Data: data}
+	assert.Equal(&grpc.ReseedRandomDevRequest{}, auditRequest(reseed))
+
+	check := &grpc.CheckRequest{}
+	assert.Equal(check, auditRequest(check))
+
+	// the environment and the annotations are not recorded
+	create := &grpc.CreateContainerRequest{
+		ContainerId: "c",
+		OCI: &grpc.Spec{
+			Process:     &grpc.Process{Env: []string{"PASSWORD=secret"}, Args: []string{"sh"}},
+			Annotations: map[string]string{"creds": "secret"},
+		},
+	}
+	audited := auditRequest(create).(*grpc.CreateContainerRequest)
+	assert.Empty(audited.OCI.Process.Env)
+	assert.Empty(audited.OCI.Annotations)
+	assert.Equal([]string{"sh"}, audited.OCI.Process.Args)
+	assert.NotEmpty(create.OCI.Process.Env)
+	assert.NotEmpty(create.OCI.Annotations)
+
+	exec := &grpc.ExecProcessRequest{ContainerId: "c", Process: &grpc.Process{Env: []string{"PASSWORD=secret"}}}
+	assert.Empty(auditRequest(exec).(*grpc.ExecProcessRequest).Process.Env)
+	assert.NotEmpty(exec.Process.Env)
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the log is created in its persistent directory
+	logDir := filepath.Join(dir, "audit")
+	a := newAuditLog(logDir, testSandboxID, 1)
+	defer a.close()
+	assert.Equal(int64(1<<20), a.maxSize)
+
+	start := time.Now()
+	a.record(auditAgent, "grpc.CheckRequest", nil, start, nil)
+	a.maxSize = a.size + 1
+
+	a.record(auditAgent, "grpc.CheckRequest", nil, start, nil)
+	a.record(auditAgent, "grpc.CheckRequest", nil, start, nil)
+
+	assert.Len(readAuditLog(t, logDir), 1)
+	_, err = os.Stat(filepath.Join(logDir, testSandboxID+".log.1"))
+	assert.NoError(err)
+
+	b := newAuditLog("", testSandboxID, 0)
+	assert.Equal(filepath.Join(defaultAuditLogDir, testSandboxID+".log"), b.path)
+	assert.Equal(int64(defaultAuditLogMaxSizeMiB<<20), b.maxSize)
+}
+
+func TestKataAgentAuditsRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	a := newAuditLog(dir, testSandboxID, 0)
+	defer a.close()
+
+	k := &kataAgent{ctx: withAuditLog(context.Background(), a)}
+	handler := func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
+		return nil, errors.New("agent unreachable")
+	}
+
+	_, err = k.handleReq(opentracing.NoopTracer{}.StartSpan("sendReq"), handler, grpcCopyFileRequest,
+		&grpc.CopyFileRequest{Path: "/etc/hosts", Data: []byte("data")})
+	assert.Error(err)
+
+	entries := readAuditLog(t, dir)
+	assert.Len(entries, 1)
+	assert.Equal(auditAgent, entries[0]["kind"])
+	assert.Equal(grpcCopyFileRequest, entries[0]["command"])
+	assert.Equal("/etc/hosts", entries[0]["arguments"].(map[string]interface{})["path"])
+	assert.NotContains(entries[0]["arguments"], "data")
+	assert.Equal("agent unreachable", entries[0]["error"])
+}
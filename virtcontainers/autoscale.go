@@ -0,0 +1,525 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAutoscaleInterval      = 5 * time.Second
+	defaultAutoscaleCooldown      = 30 * time.Second
+	defaultAutoscaleMemHigh       = 10
+	defaultAutoscaleCPUHigh       = 20
+	defaultAutoscaleCPULow        = 2
+	defaultAutoscaleMemStepMB     = 256
+	defaultAutoscaleSamplesToTrip = 3
+
+	// maxGuestPressureSize bounds the output of guestPressureCmd read.
+	maxGuestPressureSize = 4096
+)
+
+// guestPressureCmd prints the pressure stall information of the guest, the
+// memory one and then the CPU one. /proc/pressure is not namespaced, it
+// reads the same from all the containers.
+var guestPressureCmd = types.Cmd{
+	Args: []string{"cat", "/proc/pressure/memory", "/proc/pressure/cpu"},
+	Envs: []types.EnvVar{
+		{Var: "PATH", Value: "/usr/sbin:/usr/bin:/sbin:/bin"},
+	},
+	User:            "65534",
+	PrimaryGroup:    "65534",
+	WorkDir:         "/",
+	NoNewPrivileges: true,
+}
+
+// AutoscaleFeature is the experimental feature enabling the vertical
+// autoscale controller.
+var AutoscaleFeature = exp.Feature{
+	Name:        "autoscale",
+	Description: "Resize sandbox vCPUs and memory within the pod limits based on the pressure stall information of the guest.",
+	ExpRelease:  "2.0",
+}
+
+func init() {
+	if err := exp.Register(AutoscaleFeature); err != nil {
+		virtLog.WithError(err).Error("failed to register autoscale experimental feature")
+	}
+}
+
+// AutoscaleConfig is the structure providing specific configuration
+// for the vertical autoscale controller. An autoscaled sandbox starts with
+// the default vCPUs and memory of the hypervisor, and the controller grows
+// it up to the size the pod limits give it.
+type AutoscaleConfig struct {
+	// Interval is the period between two pressure samples.
+	Interval time.Duration
+
+	// Cooldown is the minimum time between two resize operations.
+	Cooldown time.Duration
+
+	// MemStepMB is the amount of memory hot added on each scale up.
+	MemStepMB uint32
+
+	// MemHighWatermark is the memory pressure, the percentage of time
+	// some tasks of the VM were stalled on memory, triggering a scale up.
+	// Memory cannot be hot removed, so it is never scaled down.
+	MemHighWatermark uint32
+
+	// CPUHighWatermark and CPULowWatermark are CPU pressures, the
+	// percentages of time some tasks of the VM were waiting for a CPU,
+	// triggering respectively a scale up and a scale down.
+	CPUHighWatermark uint32
+	CPULowWatermark  uint32
+
+	// SamplesToTrip is the number of consecutive samples that must be
+	// above (or below) a watermark before acting, providing hysteresis.
+	SamplesToTrip uint32
+}
+
+func (c *AutoscaleConfig) setDefaults() {
+	if c.Interval == 0 {
+		c.Interval = defaultAutoscaleInterval
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = defaultAutoscaleCooldown
+	}
+	if c.MemStepMB == 0 {
+		c.MemStepMB = defaultAutoscaleMemStepMB
+	}
+	if c.MemHighWatermark == 0 || c.MemHighWatermark > 100 {
+		c.MemHighWatermark = defaultAutoscaleMemHigh
+	}
+	if c.CPUHighWatermark == 0 || c.CPUHighWatermark > 100 {
+		c.CPUHighWatermark = defaultAutoscaleCPUHigh
+	}
+	if c.CPULowWatermark == 0 || c.CPULowWatermark >= c.CPUHighWatermark {
+		c.CPULowWatermark = defaultAutoscaleCPULow
+	}
+	if c.SamplesToTrip == 0 {
+		c.SamplesToTrip = defaultAutoscaleSamplesToTrip
+	}
+}
+
+// autoscaleSample is a pressure snapshot of the VM, the percentages of
+// time its tasks were stalled on memory and waiting for a CPU over the last
+// 10 seconds.
+type autoscaleSample struct {
+	memPressure uint32
+	cpuPressure uint32
+}
+
+// parsePressure parses the output of guestPressureCmd, keeping the "some"
+// averages over 10 seconds, such as 1.53 for the "some avg10=1.53
+// avg60=0.87 avg300=0.22 total=21564877" line of /proc/pressure/cpu,
+// rounded.
+func parsePressure(out []byte) (autoscaleSample, error) {
+	var pressures []uint32
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "some" || !strings.HasPrefix(fields[1], "avg10=") {
+			continue
+		}
+
+		avg, err := strconv.ParseFloat(strings.TrimPrefix(fields[1], "avg10="), 64)
+		if err != nil {
+			return autoscaleSample{}, fmt.Errorf("Invalid guest pressure: %v", err)
+		}
+
+		pressures = append(pressures, uint32(avg+0.5))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return autoscaleSample{}, err
+	}
+
+	if len(pressures) != 2 {
+		return autoscaleSample{}, fmt.Errorf("Invalid guest pressure %q", out)
+	}
+
+	return autoscaleSample{memPressure: pressures[0], cpuPressure: pressures[1]}, nil
+}
+
+// readGuestPressure runs guestPressureCmd in the container through the
+// agent, and parses its output.
+func readGuestPressure(s *Sandbox, c *Container) (autoscaleSample, error) {
+	process, err := s.agent.exec(s, *c, guestPressureCmd)
+	if err != nil {
+		return autoscaleSample{}, err
+	}
+
+	// The agent fails the read once the process closed its output.
+	var out []byte
+	buf := make([]byte, 512)
+	for len(out) < maxGuestPressureSize {
+		n, err := s.agent.readProcessStdout(c, process.Token, buf)
+		out = append(out, buf[:n]...)
+		if err != nil || n == 0 {
+			break
+		}
+	}
+
+	exitCode, err := s.agent.waitProcess(c, process.Token)
+	if err != nil {
+		return autoscaleSample{}, err
+	}
+
+	if exitCode != 0 {
+		return autoscaleSample{}, fmt.Errorf("%v exited with %d", guestPressureCmd.Args, exitCode)
+	}
+
+	return parsePressure(out)
+}
+
+type autoscaler struct {
+	sync.Mutex
+
+	sandbox *Sandbox
+	config  AutoscaleConfig
+
+	// extra resources added on top of the default ones of the hypervisor.
+	extraVCPUs uint32
+	extraMemMB uint32
+
+	// pending resources of the resize in progress, only committed to
+	// the extra resources once the sandbox is resized.
+	pendingVCPUs int32
+	pendingMemMB uint32
+
+	memHighCount uint32
+	cpuHighCount uint32
+	cpuLowCount  uint32
+	lastResize   time.Time
+
+	// container is the ID of the container the pressure was last read
+	// from.
+	container string
+
+	wg      sync.WaitGroup
+	running bool
+	stopCh  chan struct{}
+}
+
+func newAutoscaler(s *Sandbox, config AutoscaleConfig) *autoscaler {
+	config.setDefaults()
+
+	return &autoscaler{
+		sandbox: s,
+		config:  config,
+	}
+}
+
+func (a *autoscaler) Logger() *logrus.Entry {
+	return a.sandbox.Logger().WithField("subsystem", "autoscale")
+}
+
+func (a *autoscaler) start() {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.running {
+		return
+	}
+
+	stopCh := make(chan struct{})
+	a.running = true
+	a.stopCh = stopCh
+	a.wg.Add(1)
+
+	go func() {
+		defer a.wg.Done()
+
+		tick := time.NewTicker(a.config.Interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-tick.C:
+				a.run(stopCh)
+			}
+		}
+	}()
+}
+
+func (a *autoscaler) stop() {
+	// wait outside of the lock for the controller loop to exit.
+	defer a.wg.Wait()
+
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.running {
+		return
+	}
+
+	close(a.stopCh)
+	a.running = false
+}
+
+// extra returns the vCPUs and memory (MiB) added by the controller on top
+// of the default ones of the hypervisor, including the resize in progress.
+func (a *autoscaler) extra() (uint32, uint32) {
+	a.Lock()
+	defer a.Unlock()
+
+	return uint32(int32(a.extraVCPUs) + a.pendingVCPUs), a.extraMemMB + a.pendingMemMB
+}
+
+// run samples the pressure of the VM and resizes the sandbox if needed. It
+// holds the operation lock of the sandbox, unless stopCh is closed while it
+// waits for it.
+func (a *autoscaler) run(stopCh <-chan struct{}) {
+	s := a.sandbox
+
+	lock := s.operationLock()
+	if !lockUnlessClosed(lock, stopCh) {
+		return
+	}
+	defer lock.Unlock()
+
+	if s.state.State != types.StateRunning {
+		return
+	}
+
+	sample, err := a.sample()
+	if err != nil {
+		a.Logger().WithError(err).Debug("failed to sample the guest pressure")
+		return
+	}
+
+	a.Lock()
+	cpuDelta, memDelta := a.evaluate(sample, time.Now(), s.autoscaleRoom())
+	a.Unlock()
+
+	if cpuDelta == 0 && memDelta == 0 {
+		return
+	}
+
+	a.Logger().WithFields(logrus.Fields{
+		"memory-pressure": sample.memPressure,
+		"cpu-pressure":    sample.cpuPressure,
+		"vcpus-delta":     cpuDelta,
+		"memory-delta":    memDelta,
+	}).Info("resizing sandbox")
+
+	a.resize(cpuDelta, memDelta, s.updateResources)
+}
+
+// resize resizes the sandbox with update, the extra resources including
+// the deltas, which are only committed if update succeeds.
+func (a *autoscaler) resize(cpuDelta int32, memDelta uint32, update func() error) {
+	a.Lock()
+	a.pendingVCPUs, a.pendingMemMB = cpuDelta, memDelta
+	a.Unlock()
+
+	err := update()
+
+	a.Lock()
+	if err == nil {
+		a.extraVCPUs = uint32(int32(a.extraVCPUs) + cpuDelta)
+		a.extraMemMB += memDelta
+	}
+	a.pendingVCPUs, a.pendingMemMB = 0, 0
+	a.Unlock()
+
+	if err != nil {
+		a.Logger().WithError(err).Warn("failed to resize sandbox")
+	}
+}
+
+// sample reads the pressure of the guest, from the container it was last
+// read from or else the first running container it can be read from: the
+// command reading it must be available in the container.
+func (a *autoscaler) sample() (autoscaleSample, error) {
+	s := a.sandbox
+
+	ids := []string{a.container}
+	for _, c := range s.config.Containers {
+		if c.ID != a.container {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	err := fmt.Errorf("No running container")
+	for _, id := range ids {
+		c, ok := s.containers[id]
+		if !ok || c.state.State != types.StateRunning {
+			continue
+		}
+
+		var sample autoscaleSample
+		if sample, err = readGuestPressure(s, c); err == nil {
+			a.container = id
+			return sample, nil
+		}
+	}
+
+	return autoscaleSample{}, err
+}
+
+// evaluate updates the hysteresis counters with a new sample and returns
+// the vCPUs and memory (MiB) changes to apply, the extra resources being
+// capped to room. The extra resources are left untouched until the resize
+// succeeds. It must be called with the autoscaler lock held.
+func (a *autoscaler) evaluate(sample autoscaleSample, now time.Time, room autoscaleExtra) (cpuDelta int32, memDelta uint32) {
+	// The pod limits may have been lowered since the last resize: the
+	// sandbox is sized within them anyway.
+	if a.extraVCPUs > room.vCPUs {
+		a.extraVCPUs = room.vCPUs
+	}
+	if a.extraMemMB > room.memMB {
+		a.extraMemMB = room.memMB
+	}
+
+	if sample.memPressure >= a.config.MemHighWatermark {
+		a.memHighCount++
+	} else {
+		a.memHighCount = 0
+	}
+
+	switch {
+	case sample.cpuPressure >= a.config.CPUHighWatermark:
+		a.cpuHighCount++
+		a.cpuLowCount = 0
+	case sample.cpuPressure <= a.config.CPULowWatermark:
+		a.cpuLowCount++
+		a.cpuHighCount = 0
+	default:
+		a.cpuHighCount = 0
+		a.cpuLowCount = 0
+	}
+
+	// rate limit resize operations
+	if !a.lastResize.IsZero() && now.Sub(a.lastResize) < a.config.Cooldown {
+		return 0, 0
+	}
+
+	if a.memHighCount >= a.config.SamplesToTrip && a.extraMemMB < room.memMB {
+		step := a.config.MemStepMB
+		if a.extraMemMB+step > room.memMB {
+			step = room.memMB - a.extraMemMB
+		}
+		memDelta = step
+		a.memHighCount = 0
+	}
+
+	// Memory cannot be hot removed, only vCPUs are scaled down.
+	if a.cpuHighCount >= a.config.SamplesToTrip && a.extraVCPUs < room.vCPUs {
+		cpuDelta = 1
+		a.cpuHighCount = 0
+	} else if a.cpuLowCount >= a.config.SamplesToTrip && a.extraVCPUs > 0 {
+		cpuDelta = -1
+		a.cpuLowCount = 0
+	}
+
+	if cpuDelta != 0 || memDelta != 0 {
+		a.lastResize = now
+	}
+
+	return cpuDelta, memDelta
+}
+
+// autoscaleExtra is the number of vCPUs and the memory, in MiB, the
+// controller can add to the sandbox.
+type autoscaleExtra struct {
+	vCPUs uint32
+	memMB uint32
+}
+
+// autoscaleBase returns the size of the sandbox before the controller adds
+// resources, the default vCPUs and memory of the hypervisor.
+func (s *Sandbox) autoscaleBase() sandboxSize {
+	hConfig := s.hypervisor.hypervisorConfig()
+
+	return sandboxSize{
+		vCPUs:  hConfig.NumVCPUs,
+		memory: int64(hConfig.MemorySize) << utils.MibToBytesShift,
+	}
+}
+
+// autoscaleRoom returns the resources the controller can add to the
+// sandbox, up to the size the sizing policy gives the pod limits.
+func (s *Sandbox) autoscaleRoom() autoscaleExtra {
+	base, target := s.autoscaleBase(), s.targetSize()
+
+	var room autoscaleExtra
+	if target.vCPUs > base.vCPUs {
+		room.vCPUs = target.vCPUs - base.vCPUs
+	}
+	if target.memory > base.memory {
+		room.memMB = uint32((target.memory - base.memory) >> utils.MibToBytesShift)
+	}
+
+	return room
+}
+
+// size returns the size of the sandbox, the target of the sizing policy or,
+// when the autoscale controller runs, the default one of the hypervisor
+// plus the resources the controller added, within that target.
+func (s *Sandbox) size() sandboxSize {
+	target := s.targetSize()
+	if s.autoscaler == nil {
+		return target
+	}
+
+	size := s.autoscaleBase()
+	extraVCPUs, extraMemMB := s.autoscaler.extra()
+	size.vCPUs += extraVCPUs
+	size.memory += int64(extraMemMB) << utils.MibToBytesShift
+
+	if size.vCPUs > target.vCPUs {
+		size.vCPUs = target.vCPUs
+	}
+	if size.memory > target.memory {
+		size.memory = target.memory
+	}
+
+	return size
+}
+
+func (s *Sandbox) autoscaleEnabled() bool {
+	for _, f := range s.config.Experimental {
+		if f == AutoscaleFeature && exp.Get(AutoscaleFeature.Name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sandbox) startAutoscaler() {
+	if !s.autoscaleEnabled() {
+		return
+	}
+
+	s.Lock()
+	if s.autoscaler == nil {
+		s.autoscaler = newAutoscaler(s, s.config.AutoscaleConfig)
+	}
+	s.Unlock()
+
+	s.autoscaler.start()
+}
+
+func (s *Sandbox) stopAutoscaler() {
+	if s.autoscaler != nil {
+		s.autoscaler.stop()
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// pressureAgent runs the commands of the containers with an output.
+type pressureAgent struct {
+	noopAgent
+	output   map[string]string
+	exitCode int32
+	read     bool
+}
+
+func (a *pressureAgent) exec(sandbox *Sandbox, c Container, cmd types.Cmd) (*Process, error) {
+	if _, ok := a.output[c.id]; !ok {
+		return nil, errors.New("no such file or directory")
+	}
+
+	a.read = false
+	return &Process{Token: c.id}, nil
+}
+
+func (a *pressureAgent) readProcessStdout(c *Container, processID string, data []byte) (int, error) {
+	if a.read {
+		return 0, errors.New("EOF")
+	}
+
+	a.read = true
+	return copy(data, a.output[processID]), nil
+}
+
+func (a *pressureAgent) waitProcess(c *Container, processID string) (int32, error) {
+	return a.exitCode, nil
+}
+
+func TestAutoscaleConfigDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newAutoscaler(&Sandbox{}, AutoscaleConfig{
+		CPUHighWatermark: 50,
+		CPULowWatermark:  60,
+	})
+
+	assert.Equal(defaultAutoscaleInterval, a.config.Interval)
+	assert.Equal(defaultAutoscaleCooldown, a.config.Cooldown)
+	assert.Equal(uint32(defaultAutoscaleMemStepMB), a.config.MemStepMB)
+	assert.Equal(uint32(defaultAutoscaleMemHigh), a.config.MemHighWatermark)
+	assert.Equal(uint32(50), a.config.CPUHighWatermark)
+	// low watermark above the high one is reset
+	assert.Equal(uint32(defaultAutoscaleCPULow), a.config.CPULowWatermark)
+	assert.Equal(uint32(defaultAutoscaleSamplesToTrip), a.config.SamplesToTrip)
+}
+
+func TestAutoscaleEvaluateMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newAutoscaler(&Sandbox{}, AutoscaleConfig{
+		MemStepMB:     256,
+		SamplesToTrip: 2,
+		Cooldown:      time.Minute,
+	})
+
+	now := time.Now()
+	room := autoscaleExtra{memMB: 384}
+	high := autoscaleSample{memPressure: 30, cpuPressure: 10}
+	resized := func() error { return nil }
+
+	// hysteresis: one sample above the watermark is not enough
+	cpu, mem := a.evaluate(high, now, room)
+	assert.Equal(int32(0), cpu)
+	assert.Equal(uint32(0), mem)
+
+	cpu, mem = a.evaluate(high, now, room)
+	assert.Equal(int32(0), cpu)
+	assert.Equal(uint32(256), mem)
+	a.resize(cpu, mem, resized)
+
+	// cooldown
+	a.evaluate(high, now.Add(time.Second), room)
+	_, mem = a.evaluate(high, now.Add(2*time.Second), room)
+	assert.Equal(uint32(0), mem)
+
+	// capped to the pod limits
+	cpu, mem = a.evaluate(high, now.Add(2*time.Minute), room)
+	assert.Equal(uint32(128), mem)
+	a.resize(cpu, mem, resized)
+
+	vcpus, memMB := a.extra()
+	assert.Equal(uint32(0), vcpus)
+	assert.Equal(uint32(384), memMB)
+
+	a.evaluate(high, now.Add(4*time.Minute), room)
+	_, mem = a.evaluate(high, now.Add(4*time.Minute), room)
+	assert.Equal(uint32(0), mem)
+
+	// lowered pod limits
+	a.evaluate(high, now.Add(6*time.Minute), autoscaleExtra{memMB: 128})
+	_, memMB = a.extra()
+	assert.Equal(uint32(128), memMB)
+}
+
+func TestAutoscaleEvaluateCPU(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newAutoscaler(&Sandbox{}, AutoscaleConfig{
+		SamplesToTrip: 1,
+		Cooldown:      time.Second,
+	})
+
+	now := time.Now()
+	room := autoscaleExtra{vCPUs: 1}
+	resized := func() error { return nil }
+
+	cpu, mem := a.evaluate(autoscaleSample{cpuPressure: 50}, now, room)
+	assert.Equal(int32(1), cpu)
+	a.resize(cpu, mem, resized)
+
+	// already at the pod limits
+	cpu, _ = a.evaluate(autoscaleSample{cpuPressure: 50}, now.Add(time.Minute), room)
+	assert.Equal(int32(0), cpu)
+
+	// between watermarks
+	cpu, _ = a.evaluate(autoscaleSample{cpuPressure: 10}, now.Add(2*time.Minute), room)
+	assert.Equal(int32(0), cpu)
+
+	cpu, mem = a.evaluate(autoscaleSample{cpuPressure: 1}, now.Add(3*time.Minute), room)
+	assert.Equal(int32(-1), cpu)
+	a.resize(cpu, mem, resized)
+
+	// never below the default vCPUs
+	cpu, _ = a.evaluate(autoscaleSample{cpuPressure: 1}, now.Add(4*time.Minute), room)
+	assert.Equal(int32(0), cpu)
+}
+
+func TestAutoscaleResize(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newAutoscaler(&Sandbox{}, AutoscaleConfig{})
+
+	// the sandbox is resized with the pending resources
+	a.resize(1, 256, func() error {
+		vcpus, memMB := a.extra()
+		assert.Equal(uint32(1), vcpus)
+		assert.Equal(uint32(256), memMB)
+		return errors.New("resize failed")
+	})
+
+	// which are dropped if the resize fails
+	vcpus, memMB := a.extra()
+	assert.Equal(uint32(0), vcpus)
+	assert.Equal(uint32(0), memMB)
+
+	a.resize(1, 256, func() error { return nil })
+	vcpus, memMB = a.extra()
+	assert.Equal(uint32(1), vcpus)
+	assert.Equal(uint32(256), memMB)
+
+	a.resize(-1, 0, func() error { return nil })
+	vcpus, memMB = a.extra()
+	assert.Equal(uint32(0), vcpus)
+	assert.Equal(uint32(256), memMB)
+}
+
+func TestParsePressure(t *testing.T) {
+	assert := assert.New(t)
+
+	sample, err := parsePressure([]byte("some avg10=12.60 avg60=3.10 avg300=0.80 total=1234\n" +
+		"full avg10=4.00 avg60=1.00 avg300=0.20 total=567\n" +
+		"some avg10=0.40 avg60=0.10 avg300=0.00 total=89\n"))
+	assert.NoError(err)
+	assert.Equal(autoscaleSample{memPressure: 13, cpuPressure: 0}, sample)
+
+	_, err = parsePressure([]byte("some avg10=12.60\nfull avg10=1.00\n"))
+	assert.Error(err)
+
+	_, err = parsePressure([]byte("some avg10=x\nsome avg10=1.00\n"))
+	assert.Error(err)
+}
+
+func TestAutoscaleSample(t *testing.T) {
+	assert := assert.New(t)
+
+	agent := &pressureAgent{output: map[string]string{
+		"bar": "some avg10=12.60 avg60=3.10 avg300=0.80 total=1234\n" +
+			"full avg10=4.00 avg60=1.00 avg300=0.20 total=567\n" +
+			"some avg10=25.00 avg60=10.00 avg300=2.00 total=890\n",
+	}}
+	s := &Sandbox{
+		agent: agent,
+		config: &SandboxConfig{
+			Containers: []ContainerConfig{{ID: "foo"}, {ID: "bar"}, {ID: "baz"}},
+		},
+		containers: map[string]*Container{
+			"foo": {id: "foo", state: types.ContainerState{State: types.StateRunning}},
+			"bar": {id: "bar", state: types.ContainerState{State: types.StateStopped}},
+		},
+	}
+	a := newAutoscaler(s, AutoscaleConfig{})
+
+	// no running container the pressure can be read from
+	_, err := a.sample()
+	assert.Error(err)
+
+	s.containers["bar"].state.State = types.StateRunning
+	sample, err := a.sample()
+	assert.NoError(err)
+	assert.Equal(autoscaleSample{memPressure: 13, cpuPressure: 25}, sample)
+	assert.Equal("bar", a.container)
+
+	agent.exitCode = 1
+	_, err = a.sample()
+	assert.Error(err)
+}
+
+func TestAutoscaleSize(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(1024 << 20)
+	quota := int64(200000)
+	period := uint64(100000)
+
+	s := &Sandbox{
+		hypervisor: &mockHypervisor{
+			config: HypervisorConfig{
+				NumVCPUs:   1,
+				MemorySize: 512,
+			},
+		},
+		config: &SandboxConfig{
+			Containers: []ContainerConfig{{
+				Resources: specs.LinuxResources{
+					Memory: &specs.LinuxMemory{Limit: &limit},
+					CPU:    &specs.LinuxCPU{Quota: &quota, Period: &period},
+				},
+			}},
+		},
+	}
+
+	// sized with the pod limits without the controller
+	assert.Equal(sandboxSize{vCPUs: 3, memory: 1536 << 20}, s.size())
+
+	// and within them with the controller
+	s.autoscaler = newAutoscaler(s, AutoscaleConfig{})
+	assert.Equal(autoscaleExtra{vCPUs: 2, memMB: 1024}, s.autoscaleRoom())
+	assert.Equal(sandboxSize{vCPUs: 1, memory: 512 << 20}, s.size())
+
+	s.autoscaler.resize(1, 256, func() error { return nil })
+	assert.Equal(sandboxSize{vCPUs: 2, memory: 768 << 20}, s.size())
+
+	s.autoscaler.resize(2, 1024, func() error { return nil })
+	assert.Equal(sandboxSize{vCPUs: 3, memory: 1536 << 20}, s.size())
+}
+
+func TestAutoscaleEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{config: &SandboxConfig{}}
+	assert.False(s.autoscaleEnabled())
+
+	s.config.Experimental = []exp.Feature{AutoscaleFeature}
+	assert.True(s.autoscaleEnabled())
+
+	// not enabled, so nothing to stop
+	s.config.Experimental = nil
+	s.startAutoscaler()
+	assert.Nil(s.autoscaler)
+	s.stopAutoscaler()
+}
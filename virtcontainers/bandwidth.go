@@ -0,0 +1,141 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// BandwidthConfig gives the rates the traffic of the sandbox network
+// interfaces is limited to, as requested by the kubernetes.io/ingress-bandwidth
+// and kubernetes.io/egress-bandwidth pod annotations.
+type BandwidthConfig struct {
+	// IngressRate is the rate, in bits per second, of the traffic into
+	// the sandbox. 0 means no limit.
+	IngressRate uint64
+
+	// EgressRate is the rate, in bits per second, of the traffic out of
+	// the sandbox. 0 means no limit.
+	EgressRate uint64
+}
+
+const (
+	// tbfLatency is the longest time a packet waits in the token bucket
+	// filter before being dropped, the one of the CNI bandwidth plugin.
+	tbfLatency = 25 * time.Millisecond
+
+	// tbfMinBurst is the smallest burst, in bytes, the token bucket filter
+	// allows, so that the slow links still pass a few full-size packets.
+	tbfMinBurst = 64 * 1024
+)
+
+// newTBF returns the token bucket filter limiting the traffic sent on the
+// network interface at index to rate bits per second.
+// Equivalent to: `tc qdisc replace dev $link root handle 1: tbf rate $rate burst $burst latency 25ms`
+func newTBF(index int, rate uint64) *netlink.Tbf {
+	rateBytes := rate / 8
+
+	// Allow bursts of 10ms of traffic.
+	burst := rateBytes / 100
+	if burst < tbfMinBurst {
+		burst = tbfMinBurst
+	}
+
+	limit := rateBytes/uint64(time.Second/tbfLatency) + burst
+	if limit > math.MaxUint32 {
+		limit = math.MaxUint32
+	}
+
+	return &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateBytes,
+		Limit:  uint32(limit),
+		Buffer: uint32(netlink.Xmittime(rateBytes, uint32(burst))),
+	}
+}
+
+// setRootTBF limits the traffic sent on link to rate bits per second, or
+// removes the limit when rate is 0.
+func setRootTBF(link netlink.Link, rate uint64) error {
+	attrs := link.Attrs()
+
+	if rate != 0 {
+		if err := netlink.QdiscReplace(newTBF(attrs.Index, rate)); err != nil {
+			return fmt.Errorf("Could not limit the traffic of %s to %d bit/s: %v", attrs.Name, rate, err)
+		}
+		return nil
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+
+	for _, qdisc := range qdiscs {
+		if _, ok := qdisc.(*netlink.Tbf); !ok || qdisc.Attrs().Parent != netlink.HANDLE_ROOT {
+			continue
+		}
+		if err := netlink.QdiscDel(qdisc); err != nil {
+			return fmt.Errorf("Could not remove the traffic limit of %s: %v", attrs.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// setupBandwidth limits the traffic of the endpoint on the host, with token
+// bucket filters on the egress of both the TAP device, which is the traffic
+// into the sandbox, and of the network interface it is connected to, which is
+// the traffic out of the sandbox. It must run in the sandbox network
+// namespace, once the endpoint is attached.
+// Nothing is limited unless the runtime is configured to, as the CNI
+// bandwidth plugin, when chained, already limits the traffic of the pod on
+// the host side of its veth pair. The endpoints not connected through a TAP
+// device, such as the macvtap ones, are not limited.
+func setupBandwidth(endpoint Endpoint, config NetworkConfig) error {
+	if !config.EnableBandwidth || !interworkingEndpoint(endpoint) {
+		return nil
+	}
+
+	bw := config.Bandwidth
+
+	netPair := endpoint.NetworkPair()
+	switch netPair.NetInterworkingModel {
+	case NetXConnectBridgedModel, NetXConnectTCFilterModel:
+	default:
+		return nil
+	}
+
+	netHandle, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer netHandle.Delete()
+
+	tapLink, err := getLinkByName(netHandle, netPair.TAPIface.Name, &netlink.Tuntap{})
+	if err != nil {
+		return err
+	}
+
+	link, err := getLinkForEndpoint(endpoint, netHandle)
+	if err != nil {
+		return err
+	}
+
+	if err := setRootTBF(tapLink, bw.IngressRate); err != nil {
+		return err
+	}
+
+	return setRootTBF(link, bw.EgressRate)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestNewTBF(t *testing.T) {
+	assert := assert.New(t)
+
+	// 1Mbit/s, with the minimum burst
+	tbf := newTBF(3, 1000000)
+	assert.Equal(3, tbf.LinkIndex)
+	assert.Equal(netlink.MakeHandle(1, 0), tbf.Handle)
+	assert.Equal(uint32(netlink.HANDLE_ROOT), tbf.Parent)
+	assert.Equal(uint64(125000), tbf.Rate)
+	assert.Equal(uint32(125000/40+tbfMinBurst), tbf.Limit)
+	assert.NotZero(tbf.Buffer)
+
+	// 10Gbit/s, with a 10ms burst
+	tbf = newTBF(3, 10000000000)
+	assert.Equal(uint64(1250000000), tbf.Rate)
+	assert.Equal(uint32(1250000000/40+1250000000/100), tbf.Limit)
+}
+
+func TestSetupBandwidth(t *testing.T) {
+	assert := assert.New(t)
+
+	bw := NetworkConfig{
+		EnableBandwidth: true,
+		Bandwidth:       BandwidthConfig{IngressRate: 1000000, EgressRate: 1000000},
+	}
+
+	// nothing to shape on the host for the other endpoints
+	assert.NoError(setupBandwidth(&PhysicalEndpoint{}, bw))
+	assert.NoError(setupBandwidth(&TapEndpoint{}, bw))
+
+	// nor for the interworking models without TAP device
+	endpoint := &VethEndpoint{
+		NetPair: NetworkInterfacePair{NetInterworkingModel: NetXConnectMacVtapModel},
+	}
+	assert.NoError(setupBandwidth(endpoint, bw))
+
+	// nor when the runtime does not limit the bandwidth, the TAP device
+	// missing otherwise
+	endpoint.NetPair.NetInterworkingModel = NetXConnectTCFilterModel
+	assert.Error(setupBandwidth(endpoint, bw))
+	bw.EnableBandwidth = false
+	assert.NoError(setupBandwidth(endpoint, bw))
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+)
+
+// The phases of the sandbox boot, each having its own timeout.
+const (
+	bootPhaseVirtiofsd  = "virtiofsd"
+	bootPhaseHypervisor = "hypervisor"
+	bootPhaseAgent      = "agent"
+)
+
+// bootPhaseError is returned when a phase of the sandbox boot fails, with
+// the time it took.
+type bootPhaseError struct {
+	phase   string
+	elapsed time.Duration
+	err     error
+}
+
+func (e *bootPhaseError) Error() string {
+	return fmt.Sprintf("%s boot phase failed after %s: %v", e.phase, e.elapsed, e.err)
+}
+
+// Cause returns the error the phase failed with.
+func (e *bootPhaseError) Cause() error {
+	return e.err
+}
+
+// runBootPhase runs the boot phase fn, reporting its duration in span and
+// in the error it returns.
+func runBootPhase(span opentracing.Span, logger *logrus.Entry, phase string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	span.SetTag(phase+"-duration", elapsed.String())
+	logger.WithFields(logrus.Fields{
+		"boot-phase": phase,
+		"duration":   elapsed,
+	}).Info("Boot phase done")
+
+	if err != nil {
+		return &bootPhaseError{phase: phase, elapsed: elapsed, err: err}
+	}
+
+	return nil
+}
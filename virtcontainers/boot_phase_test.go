@@ -0,0 +1,43 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBootPhase(t *testing.T) {
+	assert := assert.New(t)
+
+	span := opentracing.NoopTracer{}.StartSpan("test")
+
+	assert.NoError(runBootPhase(span, virtLog, bootPhaseAgent, func() error {
+		return nil
+	}))
+
+	phaseErr := errors.New("agent not ready")
+	err := runBootPhase(span, virtLog, bootPhaseAgent, func() error {
+		return phaseErr
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "agent boot phase failed after")
+	assert.Contains(err.Error(), phaseErr.Error())
+	assert.Equal(phaseErr, errors.Cause(err))
+}
+
+func TestHypervisorConfigStartTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &HypervisorConfig{}
+	assert.Equal(vmStartTimeout, conf.startTimeout())
+
+	conf.VMStartTimeout = 30
+	assert.Equal(30, conf.startTimeout())
+}
@@ -124,6 +124,7 @@ func (endpoint *BridgedMacvlanEndpoint) save() persistapi.NetworkEndpoint {
 		Type: string(endpoint.Type()),
 		BridgedMacvlan: &persistapi.BridgedMacvlanEndpoint{
 			NetPair: *netpair,
+			PCIAddr: endpoint.PCIAddr,
 		},
 	}
 }
@@ -134,5 +135,6 @@ func (endpoint *BridgedMacvlanEndpoint) load(s persistapi.NetworkEndpoint) {
 	if s.BridgedMacvlan != nil {
 		netpair := loadNetIfPair(&s.BridgedMacvlan.NetPair)
 		endpoint.NetPair = *netpair
+		endpoint.PCIAddr = s.BridgedMacvlan.PCIAddr
 	}
 }
@@ -33,8 +33,8 @@ const cgroupKataPath = "/kata/"
 // from grabbing the stats data.
 const cgroupKataPrefix = "kata"
 
-var cgroupsLoadFunc = cgroups.Load
-var cgroupsNewFunc = cgroups.New
+var cgroupsLoadFunc = loadCgroup
+var cgroupsNewFunc = newCgroup
 
 // V1Constraints returns the cgroups that are compatible with th VC architecture
 // and hypervisor, constraints can be applied to these cgroups.
@@ -0,0 +1,537 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// cgroup2SuperMagic is the filesystem type of the unified hierarchy.
+const cgroup2SuperMagic = 0x63677270
+
+// unifiedControllers are the controllers the runtime enables for the
+// cgroups it creates in the unified hierarchy.
+var unifiedControllers = []string{"cpu", "cpuset", "memory", "pids"}
+
+// cgroupV2MountPoint is the mount point of the unified hierarchy.
+var cgroupV2MountPoint = "/sys/fs/cgroup"
+
+// isCgroupV2 returns true if the host mounts the unified hierarchy only.
+// The hosts mounting a hybrid hierarchy keep using the v1 controllers.
+var isCgroupV2 = func() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupV2MountPoint, &st); err != nil {
+		return false
+	}
+
+	return st.Type == cgroup2SuperMagic
+}
+
+// newCgroup creates the cgroup at path, in the hierarchy of the host.
+func newCgroup(hierarchy cgroups.Hierarchy, path cgroups.Path, resources *specs.LinuxResources, opts ...cgroups.InitOpts) (cgroups.Cgroup, error) {
+	if isCgroupV2() {
+		return newUnifiedCgroup(path, resources)
+	}
+
+	return cgroups.New(hierarchy, path, resources, opts...)
+}
+
+// loadCgroup loads the cgroup at path, in the hierarchy of the host.
+func loadCgroup(hierarchy cgroups.Hierarchy, path cgroups.Path, opts ...cgroups.InitOpts) (cgroups.Cgroup, error) {
+	if isCgroupV2() {
+		return loadUnifiedCgroup(path)
+	}
+
+	return cgroups.Load(hierarchy, path, opts...)
+}
+
+// unifiedCgroup is a cgroup of the unified hierarchy, implementing the
+// cgroups.Cgroup interface the runtime uses for the v1 ones. The
+// hierarchy has no per controller paths, the subsystems are ignored.
+type unifiedCgroup struct {
+	// path is relative to the mount point of the hierarchy.
+	path string
+}
+
+func newUnifiedCgroup(path cgroups.Path, resources *specs.LinuxResources) (cgroups.Cgroup, error) {
+	p, err := path("")
+	if err != nil {
+		return nil, err
+	}
+
+	if resources != nil {
+		if err := checkUnifiedResources(resources); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &unifiedCgroup{path: filepath.Join("/", p)}
+
+	if err := enableUnifiedControllers(filepath.Dir(c.path)); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(c.dir(), 0755); err != nil {
+		return nil, err
+	}
+
+	if resources != nil {
+		if err := c.Update(resources); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func loadUnifiedCgroup(path cgroups.Path) (cgroups.Cgroup, error) {
+	p, err := path("")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &unifiedCgroup{path: filepath.Join("/", p)}
+	if _, err := os.Stat(c.dir()); os.IsNotExist(err) {
+		return nil, cgroups.ErrCgroupDeleted
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// enableUnifiedControllers creates the cgroup path and its missing
+// ancestors, and enables the available controllers of the runtime for
+// their children. A controller which cannot be enabled, e.g. as the
+// cgroup has processes, fails the creation rather than leaving the
+// children unconstrained.
+func enableUnifiedControllers(path string) error {
+	var dirs []string
+	for p := filepath.Join("/", path); ; p = filepath.Dir(p) {
+		dirs = append([]string{filepath.Join(cgroupV2MountPoint, p)}, dirs...)
+		if p == "/" {
+			break
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		available := make(map[string]bool)
+		for _, controller := range strings.Fields(string(data)) {
+			available[controller] = true
+		}
+
+		for _, controller := range unifiedControllers {
+			if !available[controller] {
+				continue
+			}
+
+			if err := writeCgroupFile(dir, "cgroup.subtree_control", "+"+controller); err != nil {
+				return fmt.Errorf("Could not enable the %s controller of cgroup %s: %v", controller, dir, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *unifiedCgroup) dir() string {
+	return filepath.Join(cgroupV2MountPoint, c.path)
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+func (c *unifiedCgroup) readFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir(), file))
+	return strings.TrimSpace(string(data)), err
+}
+
+// New creates the child cgroup name.
+func (c *unifiedCgroup) New(name string, resources *specs.LinuxResources) (cgroups.Cgroup, error) {
+	return newUnifiedCgroup(cgroups.StaticPath(filepath.Join(c.path, name)), resources)
+}
+
+// Add moves the process to the cgroup.
+func (c *unifiedCgroup) Add(process cgroups.Process) error {
+	if process.Pid <= 0 {
+		return cgroups.ErrInvalidPid
+	}
+
+	return writeCgroupFile(c.dir(), "cgroup.procs", strconv.Itoa(process.Pid))
+}
+
+// AddTask moves the thread to the cgroup, which must be threaded.
+func (c *unifiedCgroup) AddTask(process cgroups.Process) error {
+	if process.Pid <= 0 {
+		return cgroups.ErrInvalidPid
+	}
+
+	return writeCgroupFile(c.dir(), "cgroup.threads", strconv.Itoa(process.Pid))
+}
+
+// Delete removes the cgroup, which must not have processes.
+func (c *unifiedCgroup) Delete() error {
+	if err := unix.Rmdir(c.dir()); err != nil && err != unix.ENOENT {
+		return err
+	}
+
+	return nil
+}
+
+// MoveTo moves the processes of the cgroup to destination.
+func (c *unifiedCgroup) MoveTo(destination cgroups.Cgroup) error {
+	processes, err := c.Processes("", false)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range processes {
+		// The processes which exited meanwhile are not moved.
+		err := destination.Add(p)
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == unix.ESRCH {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *unifiedCgroup) readIDs(file string) ([]int, error) {
+	data, err := c.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, field := range strings.Fields(data) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Processes returns the processes of the cgroup, and of its descendants
+// when recursive.
+func (c *unifiedCgroup) Processes(_ cgroups.Name, recursive bool) ([]cgroups.Process, error) {
+	var processes []cgroups.Process
+
+	err := c.walk(recursive, func(child *unifiedCgroup) error {
+		pids, err := child.readIDs("cgroup.procs")
+		if err != nil {
+			return err
+		}
+
+		for _, pid := range pids {
+			processes = append(processes, cgroups.Process{Pid: pid, Path: child.dir()})
+		}
+		return nil
+	})
+
+	return processes, err
+}
+
+// Tasks returns the threads of the cgroup, and of its descendants when
+// recursive.
+func (c *unifiedCgroup) Tasks(_ cgroups.Name, recursive bool) ([]cgroups.Task, error) {
+	var tasks []cgroups.Task
+
+	err := c.walk(recursive, func(child *unifiedCgroup) error {
+		tids, err := child.readIDs("cgroup.threads")
+		if err != nil {
+			return err
+		}
+
+		for _, tid := range tids {
+			tasks = append(tasks, cgroups.Task{Pid: tid, Path: child.dir()})
+		}
+		return nil
+	})
+
+	return tasks, err
+}
+
+func (c *unifiedCgroup) walk(recursive bool, fn func(*unifiedCgroup) error) error {
+	if !recursive {
+		return fn(c)
+	}
+
+	return filepath.Walk(c.dir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(cgroupV2MountPoint, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(&unifiedCgroup{path: filepath.Join("/", rel)})
+	})
+}
+
+// Freeze freezes the processes of the cgroup.
+func (c *unifiedCgroup) Freeze() error {
+	return writeCgroupFile(c.dir(), "cgroup.freeze", "1")
+}
+
+// Thaw thaws the processes of the cgroup.
+func (c *unifiedCgroup) Thaw() error {
+	return writeCgroupFile(c.dir(), "cgroup.freeze", "0")
+}
+
+// OOMEventFD is not supported, the unified hierarchy reports the OOM
+// events in memory.events.
+func (c *unifiedCgroup) OOMEventFD() (uintptr, error) {
+	return 0, cgroups.ErrMemoryNotSupported
+}
+
+// State returns the freezer state of the cgroup.
+func (c *unifiedCgroup) State() cgroups.State {
+	frozen, err := c.readFile("cgroup.freeze")
+	switch {
+	case os.IsNotExist(err):
+		if _, err := os.Stat(c.dir()); os.IsNotExist(err) {
+			return cgroups.Deleted
+		}
+		return cgroups.Thawed
+	case err != nil:
+		return cgroups.Unknown
+	case frozen == "1":
+		return cgroups.Frozen
+	default:
+		return cgroups.Thawed
+	}
+}
+
+// Subsystems returns no subsystem, the hierarchy has none.
+func (c *unifiedCgroup) Subsystems() []cgroups.Subsystem {
+	return nil
+}
+
+// cpuWeight converts the v1 CPU shares to the v2 CPU weight.
+func cpuWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// checkUnifiedResources refuses the resources Update does not apply,
+// rather than silently leaving the cgroup unconstrained by them.
+func checkUnifiedResources(resources *specs.LinuxResources) error {
+	var unsupported []string
+
+	if len(resources.Devices) > 0 {
+		unsupported = append(unsupported, "devices")
+	}
+	if resources.BlockIO != nil {
+		unsupported = append(unsupported, "blockIO")
+	}
+	if len(resources.HugepageLimits) > 0 {
+		unsupported = append(unsupported, "hugepageLimits")
+	}
+	if resources.Network != nil {
+		unsupported = append(unsupported, "network")
+	}
+	if len(resources.Rdma) > 0 {
+		unsupported = append(unsupported, "rdma")
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("The %s resources are not supported in the unified hierarchy", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+// Update applies the CPU, memory and pids resources to the cgroup, the
+// other ones are refused.
+func (c *unifiedCgroup) Update(resources *specs.LinuxResources) error {
+	if err := checkUnifiedResources(resources); err != nil {
+		return err
+	}
+
+	var settings [][2]string
+
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			settings = append(settings, [2]string{"cpu.weight", strconv.FormatUint(cpuWeight(*cpu.Shares), 10)})
+		}
+
+		if cpu.Period != nil && *cpu.Period > 0 {
+			quota := "max"
+			if cpu.Quota != nil && *cpu.Quota > 0 {
+				quota = strconv.FormatInt(*cpu.Quota, 10)
+			}
+			settings = append(settings, [2]string{"cpu.max", fmt.Sprintf("%s %d", quota, *cpu.Period)})
+		}
+
+		if cpu.Cpus != "" {
+			settings = append(settings, [2]string{"cpuset.cpus", cpu.Cpus})
+		}
+
+		if cpu.Mems != "" {
+			settings = append(settings, [2]string{"cpuset.mems", cpu.Mems})
+		}
+	}
+
+	if memory := resources.Memory; memory != nil {
+		if memory.Limit != nil && *memory.Limit > 0 {
+			settings = append(settings, [2]string{"memory.max", strconv.FormatInt(*memory.Limit, 10)})
+		}
+
+		if memory.Reservation != nil && *memory.Reservation > 0 {
+			settings = append(settings, [2]string{"memory.low", strconv.FormatInt(*memory.Reservation, 10)})
+		}
+	}
+
+	if pids := resources.Pids; pids != nil && pids.Limit > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.FormatInt(pids.Limit, 10)})
+	}
+
+	for _, s := range settings {
+		if err := writeCgroupFile(c.dir(), s[0], s[1]); err != nil {
+			return fmt.Errorf("Could not set %s of cgroup %s: %v", s[0], c.path, err)
+		}
+	}
+
+	return nil
+}
+
+// readKeyedFile parses the "key value" lines of file.
+func (c *unifiedCgroup) readKeyedFile(file string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(c.dir(), file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readUint parses the single value of file, "max" being returned as 0.
+func (c *unifiedCgroup) readUint(file string) (uint64, error) {
+	data, err := c.readFile(file)
+	if err != nil || data == "max" {
+		return 0, err
+	}
+
+	return strconv.ParseUint(data, 10, 64)
+}
+
+// Stat returns the CPU, memory and pids statistics of the cgroup, in the
+// format of the v1 ones. The statistics of the controllers not enabled
+// are left empty.
+func (c *unifiedCgroup) Stat(handlers ...cgroups.ErrorHandler) (*cgroups.Metrics, error) {
+	metrics := &cgroups.Metrics{}
+
+	handle := func(err error) error {
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		for _, h := range handlers {
+			if err = h(err); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	cpuStat, err := c.readKeyedFile("cpu.stat")
+	if err := handle(err); err != nil {
+		return nil, err
+	}
+	if cpuStat != nil {
+		metrics.CPU = &cgroups.CPUStat{
+			Usage: &cgroups.CPUUsage{
+				Total:  cpuStat["usage_usec"] * 1000,
+				User:   cpuStat["user_usec"] * 1000,
+				Kernel: cpuStat["system_usec"] * 1000,
+			},
+			Throttling: &cgroups.Throttle{
+				Periods:          cpuStat["nr_periods"],
+				ThrottledPeriods: cpuStat["nr_throttled"],
+				ThrottledTime:    cpuStat["throttled_usec"] * 1000,
+			},
+		}
+	}
+
+	usage, err := c.readUint("memory.current")
+	if err := handle(err); err != nil {
+		return nil, err
+	}
+	if err == nil {
+		limit, _ := c.readUint("memory.max")
+		memStat, _ := c.readKeyedFile("memory.stat")
+		metrics.Memory = &cgroups.MemoryStat{
+			Cache: memStat["file"],
+			RSS:   memStat["anon"],
+			Usage: &cgroups.MemoryEntry{
+				Usage: usage,
+				Limit: limit,
+			},
+		}
+	}
+
+	current, err := c.readUint("pids.current")
+	if err := handle(err); err != nil {
+		return nil, err
+	}
+	if err == nil {
+		limit, _ := c.readUint("pids.max")
+		metrics.Pids = &cgroups.PidsStat{
+			Current: current,
+			Limit:   limit,
+		}
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cgroups"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// withUnifiedHierarchy fakes a unified hierarchy host, mounted in a
+// temporary directory.
+func withUnifiedHierarchy(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cgroup2")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644))
+
+	savedMountPoint, savedIsCgroupV2 := cgroupV2MountPoint, isCgroupV2
+	cgroupV2MountPoint = dir
+	isCgroupV2 = func() bool { return true }
+
+	return dir, func() {
+		cgroupV2MountPoint, isCgroupV2 = savedMountPoint, savedIsCgroupV2
+		os.RemoveAll(dir)
+	}
+}
+
+func readCgroupFile(t *testing.T, path ...string) string {
+	data, err := ioutil.ReadFile(filepath.Join(path...))
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCPUWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint64(1), cpuWeight(0))
+	assert.Equal(uint64(1), cpuWeight(2))
+	assert.Equal(uint64(39), cpuWeight(1024))
+	assert.Equal(uint64(10000), cpuWeight(262144))
+	assert.Equal(uint64(10000), cpuWeight(1<<30))
+}
+
+func TestUnifiedCgroupCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	root, cleanup := withUnifiedHierarchy(t)
+	defer cleanup()
+
+	quota := int64(50000)
+	period := uint64(100000)
+	shares := uint64(1024)
+	limit := int64(1 << 30)
+	resources := &specs.LinuxResources{
+		CPU: &specs.LinuxCPU{
+			Quota:  &quota,
+			Period: &period,
+			Shares: &shares,
+			Cpus:   "0-1",
+		},
+		Memory: &specs.LinuxMemory{
+			Limit: &limit,
+		},
+		Pids: &specs.LinuxPids{
+			Limit: 100,
+		},
+	}
+
+	cgroup, err := newCgroup(cgroups.V1, cgroups.StaticPath("/kubepods/pod1/kata_foo"), resources)
+	assert.NoError(err)
+	assert.IsType(&unifiedCgroup{}, cgroup)
+
+	// The controllers are enabled for the children of the root, the fake
+	// subtree_control file keeps the last one.
+	assert.Equal("+pids", readCgroupFile(t, root, "cgroup.subtree_control"))
+
+	dir := filepath.Join(root, "kubepods", "pod1", "kata_foo")
+	assert.Equal("50000 100000", readCgroupFile(t, dir, "cpu.max"))
+	assert.Equal("39", readCgroupFile(t, dir, "cpu.weight"))
+	assert.Equal("0-1", readCgroupFile(t, dir, "cpuset.cpus"))
+	assert.Equal("1073741824", readCgroupFile(t, dir, "memory.max"))
+	assert.Equal("100", readCgroupFile(t, dir, "pids.max"))
+
+	assert.NoError(cgroup.Add(cgroups.Process{Pid: 42}))
+	assert.Equal("42", readCgroupFile(t, dir, "cgroup.procs"))
+	assert.Equal(cgroups.ErrInvalidPid, cgroup.Add(cgroups.Process{}))
+
+	processes, err := cgroup.Processes("", false)
+	assert.NoError(err)
+	assert.Equal([]cgroups.Process{{Pid: 42, Path: dir}}, processes)
+
+	assert.Equal(cgroups.Thawed, cgroup.State())
+	assert.NoError(cgroup.Freeze())
+	assert.Equal(cgroups.Frozen, cgroup.State())
+	assert.NoError(cgroup.Thaw())
+	assert.Equal(cgroups.Thawed, cgroup.State())
+
+	loaded, err := loadCgroup(cgroups.V1, cgroups.StaticPath("/kubepods/pod1/kata_foo"))
+	assert.NoError(err)
+	assert.Equal(cgroup, loaded)
+
+	_, err = loadCgroup(cgroups.V1, cgroups.StaticPath("/kubepods/pod1/kata_bar"))
+	assert.Equal(cgroups.ErrCgroupDeleted, err)
+
+	empty, err := cgroup.New("empty", nil)
+	assert.NoError(err)
+	assert.NoError(empty.Delete())
+	assert.Equal(cgroups.Deleted, empty.State())
+	assert.NoError(empty.Delete())
+}
+
+func TestUnifiedCgroupStat(t *testing.T) {
+	assert := assert.New(t)
+
+	root, cleanup := withUnifiedHierarchy(t)
+	defer cleanup()
+
+	dir := filepath.Join(root, "kata_foo")
+	assert.NoError(os.MkdirAll(dir, 0755))
+
+	for file, content := range map[string]string{
+		"cpu.stat":       "usage_usec 300\nuser_usec 200\nsystem_usec 100\nnr_periods 10\nnr_throttled 2\nthrottled_usec 5\n",
+		"memory.current": "4096\n",
+		"memory.max":     "max\n",
+		"memory.stat":    "anon 1024\nfile 2048\n",
+		"pids.current":   "3\n",
+		"pids.max":       "100\n",
+	} {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+	}
+
+	cgroup, err := loadCgroup(cgroups.V1, cgroups.StaticPath("kata_foo"))
+	assert.NoError(err)
+
+	metrics, err := cgroup.Stat()
+	assert.NoError(err)
+	assert.Equal(&cgroups.CPUUsage{Total: 300000, User: 200000, Kernel: 100000}, metrics.CPU.Usage)
+	assert.Equal(&cgroups.Throttle{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 5000}, metrics.CPU.Throttling)
+	assert.Equal(&cgroups.MemoryEntry{Usage: 4096}, metrics.Memory.Usage)
+	assert.Equal(uint64(1024), metrics.Memory.RSS)
+	assert.Equal(uint64(2048), metrics.Memory.Cache)
+	assert.Equal(&cgroups.PidsStat{Current: 3, Limit: 100}, metrics.Pids)
+
+	// The controllers not enabled are not reported.
+	for _, file := range []string{"memory.current", "pids.current"} {
+		assert.NoError(os.Remove(filepath.Join(dir, file)))
+	}
+	metrics, err = cgroup.Stat()
+	assert.NoError(err)
+	assert.NotNil(metrics.CPU)
+	assert.Nil(metrics.Memory)
+	assert.Nil(metrics.Pids)
+}
+
+func TestUnifiedCgroupFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	root, cleanup := withUnifiedHierarchy(t)
+	defer cleanup()
+
+	pids := &specs.LinuxResources{Pids: &specs.LinuxPids{Limit: 100}}
+
+	// The controllers must be enabled in the ancestors.
+	subtreeControl := filepath.Join(root, "cgroup.subtree_control")
+	assert.NoError(os.Mkdir(subtreeControl, 0755))
+	_, err := newCgroup(cgroups.V1, cgroups.StaticPath("/kata_foo"), pids)
+	assert.Error(err)
+	_, err = os.Stat(filepath.Join(root, "kata_foo"))
+	assert.True(os.IsNotExist(err))
+	assert.NoError(os.Remove(subtreeControl))
+
+	// The resources not applied are refused.
+	classID := uint32(1)
+	network := &specs.LinuxResources{
+		Network: &specs.LinuxNetwork{ClassID: &classID},
+	}
+	devices := &specs.LinuxResources{
+		Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}},
+	}
+	for _, resources := range []*specs.LinuxResources{network, devices} {
+		_, err = newCgroup(cgroups.V1, cgroups.StaticPath("/kata_foo"), resources)
+		assert.Error(err)
+	}
+	_, err = os.Stat(filepath.Join(root, "kata_foo"))
+	assert.True(os.IsNotExist(err))
+
+	cgroup, err := newCgroup(cgroups.V1, cgroups.StaticPath("/kata_foo"), pids)
+	assert.NoError(err)
+	assert.Error(cgroup.Update(network))
+	assert.Error(cgroup.Update(devices))
+}
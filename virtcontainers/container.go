@@ -250,6 +250,14 @@ type ContainerConfig struct {
 	// Resources container resources
 	Resources specs.LinuxResources
 
+	// HotplugReservation is the ID of the hotplug reservation made for
+	// the devices of the container, claimed when it is created.
+	HotplugReservation string
+
+	// RootfsEncryption describes the key of the LUKS encrypted block
+	// device of the rootfs, opened by the agent.
+	RootfsEncryption RootfsEncryption
+
 	// Raw OCI specification, it won't be saved to disk.
 	Spec *specs.Spec `json:"_"`
 }
@@ -348,10 +356,16 @@ func (c *Container) ID() string {
 
 // Logger returns a logrus logger appropriate for logging Container messages
 func (c *Container) Logger() *logrus.Entry {
-	return virtLog.WithFields(logrus.Fields{
+	logger := virtLog.WithFields(logrus.Fields{
 		"subsystem": "container",
 		"sandbox":   c.sandboxID,
 	})
+
+	if c.sandbox != nil {
+		logger = logger.WithFields(c.sandbox.labels().fields())
+	}
+
+	return logger
 }
 
 func (c *Container) trace(name string) (opentracing.Span, context.Context) {
@@ -527,6 +541,24 @@ func (c *Container) shareFiles(m Mount, idx int, hostSharedDir, guestSharedDir s
 	return guestDest, false, nil
 }
 
+// shareMount shares the bind mount m with the guest, and returns its guest
+// path or whether it has to be ignored.
+func (c *Container) shareMount(m Mount, idx int, hostSharedDir, guestSharedDir string) (string, bool, error) {
+	// The small Kubernetes volumes are mirrored into the guest and kept
+	// in sync by the agent, so that their updates trigger inotify events.
+	if c.isWatched(m) {
+		return c.shareWatchedMount(m, idx, hostSharedDir, guestSharedDir)
+	}
+
+	// Mounts from a virtio-fs volume are already shared with the
+	// guest through the volume device.
+	if guestDest, ok := virtioFSVolumeGuestPath(c.sandbox.config.HypervisorConfig.VirtioFSVolumes, m.Source); ok {
+		return guestDest, false, nil
+	}
+
+	return c.shareFiles(m, idx, hostSharedDir, guestSharedDir)
+}
+
 // mountSharedDirMounts handles bind-mounts by bindmounting to the host shared
 // directory which is mounted through 9pfs in the VM.
 // It also updates the container mount list with the HostPath info, and store
@@ -578,7 +610,7 @@ func (c *Container) mountSharedDirMounts(hostSharedDir, guestSharedDir string) (
 			continue
 		}
 
-		guestDest, ignore, err := c.shareFiles(m, idx, hostSharedDir, guestSharedDir)
+		guestDest, ignore, err := c.shareMount(m, idx, hostSharedDir, guestSharedDir)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -589,21 +621,29 @@ func (c *Container) mountSharedDirMounts(hostSharedDir, guestSharedDir string) (
 			continue
 		}
 
-		// Check if mount is readonly, let the agent handle the readonly mount
-		// within the VM.
-		readonly := false
-		for _, flag := range m.Options {
-			if flag == "ro" {
-				readonly = true
+		// The container sees the files with the IDs of the host
+		// through the shared directory. Only the files the runtime owns
+		// are remapped, the others are seen owned by the overflow IDs.
+		if spec := c.GetOCISpec(); hasUserNamespace(spec) {
+			if isRuntimeScratchDir(m.Source, c.sandboxID) {
+				// Set first to restore a partial remapping.
+				c.mounts[idx].OwnershipRemapped = true
+				if err := remapOwnership(m.Source, spec); err != nil {
+					return nil, nil, err
+				}
+			} else {
+				c.Logger().WithField("source", m.Source).Warn("Ownership of the mount not remapped to the user namespace of the container")
 			}
 		}
 
+		// Check if mount is readonly, let the agent handle the readonly mount
+		// within the VM.
 		sharedDirMount := Mount{
 			Source:      guestDest,
 			Destination: m.Destination,
 			Type:        m.Type,
 			Options:     m.Options,
-			ReadOnly:    readonly,
+			ReadOnly:    isReadOnlyMount(m.Options),
 		}
 
 		sharedDirMounts = append(sharedDirMounts, sharedDirMount)
@@ -636,6 +676,14 @@ func (c *Container) unmountHostMounts() error {
 				return err
 			}
 
+			if m.OwnershipRemapped {
+				if spec := c.GetOCISpec(); hasUserNamespace(spec) {
+					if err := restoreOwnership(m.Source, spec); err != nil {
+						c.Logger().WithError(err).WithField("source", m.Source).Warn("Could not restore the ownership of the mount")
+					}
+				}
+			}
+
 			if m.Type == "bind" {
 				s, err := os.Stat(m.HostPath)
 				if err != nil {
@@ -682,6 +730,16 @@ func filterDevices(c *Container, devices []ContainerDevice) (ret []ContainerDevi
 func (c *Container) createBlockDevices() error {
 	// iterate all mounts and create block device if it's block based.
 	for i, m := range c.mounts {
+		// Disk-backed emptyDir volumes are allocated in the guest as
+		// sparse block devices, when their size is configured.
+		if m.Type == KataLocalDevType && len(m.BlockDeviceID) == 0 &&
+			c.sandbox.config.EmptyDirBlockSize > 0 && c.checkBlockDeviceSupport() {
+			if err := c.createEmptyDirDevice(&c.mounts[i]); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if len(m.BlockDeviceID) > 0 || m.Type != "bind" {
 			// Non-empty m.BlockDeviceID indicates there's already one device
 			// associated with the mount,so no need to create a new device for it
@@ -689,27 +747,127 @@ func (c *Container) createBlockDevices() error {
 			continue
 		}
 
-		var stat unix.Stat_t
-		if err := unix.Stat(m.Source, &stat); err != nil {
-			return fmt.Errorf("stat %q failed: %v", m.Source, err)
+		if err := c.createBlockDevice(&c.mounts[i]); err != nil {
+			return err
 		}
+	}
 
-		// Check if mount is a block device file. If it is, the block device will be attached to the host
-		// instead of passing this as a shared mount.
-		if c.checkBlockDeviceSupport() && stat.Mode&unix.S_IFBLK == unix.S_IFBLK {
-			b, err := c.sandbox.devManager.NewDevice(config.DeviceInfo{
-				HostPath:      m.Source,
-				ContainerPath: m.Destination,
-				DevType:       "b",
-				Major:         int64(unix.Major(stat.Rdev)),
-				Minor:         int64(unix.Minor(stat.Rdev)),
-			})
-			if err != nil {
-				return fmt.Errorf("device manager failed to create new device for %q: %v", m.Source, err)
+	return nil
+}
+
+// createEmptyDirDevice backs the disk-backed emptyDir volume m with a
+// sparse block device, instead of a directory local to the guest. The
+// containers of the pod share the same device.
+func (c *Container) createEmptyDirDevice(m *Mount) error {
+	image, err := createEmptyDirImage(m.Source, c.sandbox.config.EmptyDirBlockSize)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.sandbox.devManager.NewDevice(config.DeviceInfo{
+		HostPath:      image,
+		ContainerPath: m.Destination,
+		DevType:       "b",
+		Format:        config.BlockFormatRaw,
+	})
+	if err != nil {
+		return fmt.Errorf("device manager failed to create new device for %q: %v", image, err)
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"volume": m.Source,
+		"image":  image,
+	}).Info("emptyDir volume backed by a block device")
+
+	m.Type = "bind"
+	m.BlockDeviceID = b.DeviceID()
+	m.BlockFstype = emptyDirFstype
+
+	// The agent mounts the device instead of creating a local directory.
+	if spec := c.GetOCISpec(); spec != nil {
+		for i := range spec.Mounts {
+			if spec.Mounts[i].Destination == m.Destination && spec.Mounts[i].Type == KataLocalDevType {
+				spec.Mounts[i].Type = "bind"
 			}
+		}
+	}
+
+	return nil
+}
+
+// createBlockDevice creates the block device backing the bind mount m, if
+// it is block based, and sets its ID in m.
+func (c *Container) createBlockDevice(m *Mount) error {
+	devicePath := m.Source
+
+	// Direct-assigned volumes are passed as the block device they are
+	// mounted from.
+	if isDirectVolume(m.Options) {
+		if !c.checkBlockDeviceSupport() {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "direct-assigned volume %q without block device support", m.Source)
+		}
+
+		var err error
+		devicePath, err = directVolumeDevice(m.Source)
+		if err != nil {
+			return err
+		}
+
+		m.BlockFstype = directVolumeFstype(m.Options)
+
+		c.Logger().WithFields(logrus.Fields{
+			"volume":      m.Source,
+			"device-path": devicePath,
+			"fs-type":     m.BlockFstype,
+		}).Info("Direct-assigned volume detected")
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return fmt.Errorf("stat %q failed: %v", devicePath, err)
+	}
 
-			c.mounts[i].BlockDeviceID = b.DeviceID()
+	if isDirectVolume(m.Options) && stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return fmt.Errorf("direct-assigned volume %q is not backed by a block device", m.Source)
+	}
+
+	// Image files are passed as block devices when their format is given
+	format := blockImageFormat(m.Options)
+	if format != "" {
+		if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+			return fmt.Errorf("block device image %q must be a regular file", m.Source)
 		}
+		if !c.checkBlockDeviceSupport() {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "block device image %q without block device support", m.Source)
+		}
+	}
+
+	isBlock := c.checkBlockDeviceSupport() && (stat.Mode&unix.S_IFBLK == unix.S_IFBLK || format != "")
+
+	// Encrypted volumes must be passed through raw
+	if keyName, _, _ := luksMountOptions(m.Options); keyName != "" && !isBlock {
+		return fmt.Errorf("LUKS encrypted volume %q must be a block device file", m.Source)
+	}
+
+	// Check if mount is a block device file. If it is, the block device will be attached to the host
+	// instead of passing this as a shared mount.
+	if isBlock {
+		b, err := c.sandbox.devManager.NewDevice(config.DeviceInfo{
+			HostPath:      devicePath,
+			ContainerPath: m.Destination,
+			DevType:       "b",
+			Major:         int64(unix.Major(stat.Rdev)),
+			Minor:         int64(unix.Minor(stat.Rdev)),
+			IOLimits:      c.blockIOLimits(int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev))),
+			// Write protect the device, not only the mount within the VM
+			ReadOnly: isReadOnlyMount(m.Options),
+			Format:   format,
+		})
+		if err != nil {
+			return fmt.Errorf("device manager failed to create new device for %q: %v", m.Source, err)
+		}
+
+		m.BlockDeviceID = b.DeviceID()
 	}
 
 	return nil
@@ -882,7 +1040,23 @@ func (c *Container) create() (err error) {
 		}
 	}()
 
-	if c.checkBlockDeviceSupport() {
+	if c.rootfsEncrypted() {
+		if !c.checkBlockDeviceSupport() {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "the encrypted rootfs %q without block device support", c.rootFs.Source)
+		}
+
+		if err = c.hotplugEncryptedRootfs(); err != nil {
+			return
+		}
+	} else if IsLayeredRootfs(c.rootFs.Type) && !c.rootFs.Mounted {
+		if !c.checkBlockDeviceSupport() {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "the %s rootfs %q without block device support", c.rootFs.Type, c.rootFs.Source)
+		}
+
+		if err = c.hotplugLayeredRootfs(); err != nil {
+			return
+		}
+	} else if c.checkBlockDeviceSupport() {
 		if err = c.hotplugDrive(); err != nil {
 			return
 		}
@@ -969,14 +1143,17 @@ func (c *Container) checkSandboxRunning(cmd string) error {
 func (c *Container) getSystemMountInfo() {
 	// check if /dev needs to be bind mounted from host /dev
 	c.systemMountsInfo.BindMountDev = false
+	c.systemMountsInfo.DevShmSize = 0
 
 	for _, m := range c.mounts {
 		if m.Source == "/dev" && m.Destination == "/dev" && m.Type == "bind" {
 			c.systemMountsInfo.BindMountDev = true
 		}
-	}
 
-	// TODO Deduce /dev/shm size. See https://github.com/clearcontainers/runtime/issues/138
+		if m.Destination == "/dev/shm" {
+			c.systemMountsInfo.DevShmSize = devShmSize(m)
+		}
+	}
 }
 
 func (c *Container) start() error {
@@ -1129,6 +1306,10 @@ func (c *Container) enter(cmd types.Cmd) (*Process, error) {
 			"impossible to enter")
 	}
 
+	if err := checkProcessUser(c.GetOCISpec(), cmd); err != nil {
+		return nil, err
+	}
+
 	process, err := c.sandbox.agent.exec(c.sandbox, *c, cmd)
 	if err != nil {
 		return nil, err
@@ -1341,6 +1522,37 @@ func (c *Container) hotplugDrive() error {
 	return c.setStateFstype(fsType)
 }
 
+// blockIOLimits returns the rate limits of the host block device
+// major:minor, as the throttling devices of the block I/O resources of the
+// container set them. They are applied by the hypervisor, the guest seeing
+// other devices.
+func (c *Container) blockIOLimits(major, minor int64) config.BlockIOLimits {
+	var limits config.BlockIOLimits
+
+	blockIO := c.config.Resources.BlockIO
+	if blockIO == nil {
+		return limits
+	}
+
+	for _, t := range []struct {
+		devices []specs.LinuxThrottleDevice
+		limit   *uint64
+	}{
+		{blockIO.ThrottleReadBpsDevice, &limits.ReadBps},
+		{blockIO.ThrottleWriteBpsDevice, &limits.WriteBps},
+		{blockIO.ThrottleReadIOPSDevice, &limits.ReadIops},
+		{blockIO.ThrottleWriteIOPSDevice, &limits.WriteIops},
+	} {
+		for _, d := range t.devices {
+			if d.Major == major && d.Minor == minor {
+				*t.limit = d.Rate
+			}
+		}
+	}
+
+	return limits
+}
+
 func (c *Container) plugDevice(devicePath string) error {
 	var stat unix.Stat_t
 	if err := unix.Stat(devicePath, &stat); err != nil {
@@ -1354,6 +1566,7 @@ func (c *Container) plugDevice(devicePath string) error {
 			DevType:       "b",
 			Major:         int64(unix.Major(stat.Rdev)),
 			Minor:         int64(unix.Minor(stat.Rdev)),
+			IOLimits:      c.blockIOLimits(int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev))),
 		})
 		if err != nil {
 			return fmt.Errorf("device manager failed to create rootfs device for %q: %v", devicePath, err)
@@ -1384,24 +1597,22 @@ func (c *Container) removeDrive() (err error) {
 	if c.isDriveUsed() {
 		c.Logger().Info("unplugging block device")
 
-		devID := c.state.BlockDeviceID
-		err := c.sandbox.devManager.DetachDevice(devID, c.sandbox)
-		if err != nil && err != manager.ErrDeviceNotAttached {
-			return err
-		}
-
-		if err = c.sandbox.devManager.RemoveDevice(devID); err != nil {
-			c.Logger().WithFields(logrus.Fields{
-				"container": c.id,
-				"device-id": devID,
-			}).WithError(err).Error("remove device failed")
+		for _, devID := range []string{c.state.BlockDeviceID, c.state.ScratchDeviceID} {
+			if devID == "" {
+				continue
+			}
 
-			// ignore the device not exist error
-			if err != manager.ErrDeviceNotExist {
+			if err := c.removeRootfsDevice(devID); err != nil {
 				return err
 			}
 		}
 
+		if c.state.ScratchDeviceID != "" || IsLayeredRootfs(c.state.Fstype) {
+			if err := os.Remove(rootfsScratchImage(c.sandboxID, c.id)); err != nil && !os.IsNotExist(err) {
+				c.Logger().WithError(err).Warn("Could not remove the rootfs scratch image")
+			}
+		}
+
 		if !c.sandbox.supportNewStore() {
 			if err := c.sandbox.storeSandboxDevices(); err != nil {
 				return err
@@ -1412,14 +1623,59 @@ func (c *Container) removeDrive() (err error) {
 	return nil
 }
 
-func (c *Container) attachDevices() error {
-	// there's no need to do rollback when error happens,
-	// because if attachDevices fails, container creation will fail too,
-	// and rollbackFailingContainerCreation could do all the rollbacks
+func (c *Container) removeRootfsDevice(devID string) error {
+	err := c.sandbox.devManager.DetachDevice(devID, c.sandbox)
+	if err != nil && err != manager.ErrDeviceNotAttached {
+		return err
+	}
+
+	if err = c.sandbox.devManager.RemoveDevice(devID); err != nil {
+		c.Logger().WithFields(logrus.Fields{
+			"container": c.id,
+			"device-id": devID,
+		}).WithError(err).Error("remove device failed")
+
+		// ignore the device not exist error
+		if err != manager.ErrDeviceNotExist {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Container) attachDevices() (err error) {
+	job := c.sandbox.hotplugJobs.start("attach-devices", c.id, len(c.devices))
+	defer func() {
+		job.finish(err)
+	}()
+
+	// The devices attached are detached when an error happens, or when
+	// the job is cancelled, before the job is finished, for it not to be
+	// reported as finished while half applied. Container creation fails
+	// too, and rollbackFailingContainerCreation removes the devices.
+	var attached []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(attached) - 1; i >= 0; i-- {
+			if detachErr := c.sandbox.devManager.DetachDevice(attached[i], c.sandbox); detachErr != nil && detachErr != manager.ErrDeviceNotAttached {
+				c.Logger().WithError(detachErr).WithField("device-id", attached[i]).Error("rollback failed DetachDevice()")
+			}
+		}
+	}()
+
 	for _, dev := range c.devices {
+		if err := job.step(); err != nil {
+			return err
+		}
+
 		if err := c.sandbox.devManager.AttachDevice(dev.ID, c.sandbox); err != nil {
 			return err
 		}
+		attached = append(attached, dev.ID)
+		job.stepDone()
 	}
 
 	if !c.sandbox.supportNewStore() {
@@ -23,6 +23,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/persist"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -71,6 +72,16 @@ func TestContainerSystemMountsInfo(t *testing.T) {
 	c.mounts[0].Type = "tmpfs"
 	c.getSystemMountInfo()
 	assert.False(t, c.systemMountsInfo.BindMountDev)
+	assert.Equal(t, uint(0), c.systemMountsInfo.DevShmSize)
+
+	c.mounts = append(c.mounts, Mount{
+		Source:      "shm",
+		Destination: "/dev/shm",
+		Type:        "tmpfs",
+		Options:     []string{"nosuid", "size=64m"},
+	})
+	c.getSystemMountInfo()
+	assert.Equal(t, uint(64<<20), c.systemMountsInfo.DevShmSize)
 }
 
 func TestContainerSandbox(t *testing.T) {
@@ -570,3 +581,86 @@ func TestProcessIOStream(t *testing.T) {
 	_, _, _, err = c.ioStream(processID)
 	assert.Error(err)
 }
+
+func TestContainerBlockIOLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Container{config: &ContainerConfig{}}
+	assert.False(c.blockIOLimits(8, 0).IsSet())
+
+	device := func(major, minor int64, rate uint64) []specs.LinuxThrottleDevice {
+		d := specs.LinuxThrottleDevice{Rate: rate}
+		d.Major = major
+		d.Minor = minor
+		return []specs.LinuxThrottleDevice{d}
+	}
+
+	c.config.Resources.BlockIO = &specs.LinuxBlockIO{
+		ThrottleReadBpsDevice:   device(8, 0, 10485760),
+		ThrottleWriteBpsDevice:  device(8, 0, 5242880),
+		ThrottleReadIOPSDevice:  device(8, 16, 1000),
+		ThrottleWriteIOPSDevice: device(8, 0, 500),
+	}
+
+	assert.Equal(config.BlockIOLimits{
+		ReadBps:   10485760,
+		WriteBps:  5242880,
+		WriteIops: 500,
+	}, c.blockIOLimits(8, 0))
+	assert.Equal(config.BlockIOLimits{ReadIops: 1000}, c.blockIOLimits(8, 16))
+	assert.False(c.blockIOLimits(8, 32).IsSet())
+}
+
+func TestContainerCreateEmptyDirDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	savedMkfsCmd := mkfsCmd
+	mkfsCmd = "true"
+	defer func() {
+		mkfsCmd = savedMkfsCmd
+	}()
+
+	dir, err := ioutil.TempDir("", "emptydir")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		config: &SandboxConfig{
+			EmptyDirBlockSize: 16,
+		},
+		devManager: manager.NewDeviceManager(manager.VirtioBlock, nil),
+	}
+
+	spec := &specs.Spec{
+		Mounts: []specs.Mount{{Source: dir, Destination: "/cache", Type: KataLocalDevType}},
+	}
+
+	newContainer := func() *Container {
+		return &Container{
+			ctx:     sandbox.ctx,
+			sandbox: sandbox,
+			config:  &ContainerConfig{Spec: spec},
+			mounts:  []Mount{{Source: dir, Destination: "/cache", Type: KataLocalDevType}},
+		}
+	}
+
+	c := newContainer()
+	assert.NoError(c.createEmptyDirDevice(&c.mounts[0]))
+
+	m := c.mounts[0]
+	assert.Equal("bind", m.Type)
+	assert.Equal(emptyDirFstype, m.BlockFstype)
+	assert.NotEmpty(m.BlockDeviceID)
+	assert.Equal("bind", spec.Mounts[0].Type)
+
+	device, ok := sandbox.devManager.GetDeviceByID(m.BlockDeviceID).(*drivers.BlockDevice)
+	assert.True(ok)
+	assert.Equal(filepath.Join(dir, emptyDirImage), device.DeviceInfo.HostPath)
+	assert.Equal(config.BlockFormatRaw, device.DeviceInfo.Format)
+
+	// The containers of the pod share the device.
+	other := newContainer()
+	assert.NoError(other.createEmptyDirDevice(&other.mounts[0]))
+	assert.Equal(m.BlockDeviceID, other.mounts[0].BlockDeviceID)
+}
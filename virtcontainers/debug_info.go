@@ -0,0 +1,155 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+)
+
+// qmpHistorySize is the number of QMP commands recorded for debugging.
+const qmpHistorySize = 64
+
+// agentCheckTimeout is the time the agent is given to answer the debug
+// information check.
+var agentCheckTimeout = 2 * time.Second
+
+// QMPCommand is a QMP command sent to the hypervisor.
+type QMPCommand struct {
+	Time    time.Time
+	Command string
+}
+
+// qmpHistory records the last QMP commands sent to the hypervisor.
+type qmpHistory struct {
+	sync.Mutex
+	commands []QMPCommand
+	next     int
+}
+
+func (h *qmpHistory) record(command string) {
+	h.Lock()
+	defer h.Unlock()
+
+	c := QMPCommand{Time: time.Now(), Command: command}
+	if len(h.commands) < qmpHistorySize {
+		h.commands = append(h.commands, c)
+		return
+	}
+
+	h.commands[h.next] = c
+	h.next = (h.next + 1) % qmpHistorySize
+}
+
+// list returns the commands recorded, from the oldest.
+func (h *qmpHistory) list() []QMPCommand {
+	h.Lock()
+	defer h.Unlock()
+
+	return append(append([]QMPCommand(nil), h.commands[h.next:]...), h.commands[:h.next]...)
+}
+
+// isQMPCommand returns true if the message logged by the QMP client is a
+// command sent to the hypervisor.
+func isQMPCommand(msg string) bool {
+	return strings.HasPrefix(msg, `{"execute"`)
+}
+
+// AgentDebugInfo is the state of the connection to the agent.
+type AgentDebugInfo struct {
+	URL        string
+	Responsive bool
+	Error      string `json:",omitempty"`
+}
+
+// SandboxDebugInfo is the state of a sandbox, for debugging the sandboxes
+// which are stuck.
+type SandboxDebugInfo struct {
+	ID               string
+	State            types.StateString
+	HypervisorType   HypervisorType
+	HypervisorConfig HypervisorConfig
+	Devices          []persistapi.DeviceState
+	Agent            AgentDebugInfo
+
+	// QMPCommands are the last QMP commands sent to QEMU, from the oldest.
+	QMPCommands []QMPCommand `json:",omitempty"`
+}
+
+// qmpCommandLister is implemented by the hypervisors recording their QMP
+// commands.
+type qmpCommandLister interface {
+	qmpCommands() []QMPCommand
+}
+
+// checkAgent checks that the agent answers within agentCheckTimeout. The
+// check is abandoned, not cancelled, when it does not.
+func (s *Sandbox) checkAgent() AgentDebugInfo {
+	var info AgentDebugInfo
+
+	url, err := s.agent.getAgentURL()
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.URL = url
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.agent.check()
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(agentCheckTimeout):
+		err = ErrAgentUnresponsive
+	}
+
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	info.Responsive = true
+
+	return info
+}
+
+// DebugInfo returns the state of the sandbox for debugging. It does not
+// wait for the operations in progress, which a stuck sandbox never
+// completes, and bounds the check of the agent.
+func (s *Sandbox) DebugInfo() SandboxDebugInfo {
+	info := SandboxDebugInfo{
+		ID:               s.id,
+		State:            s.state.State,
+		HypervisorType:   s.config.HypervisorType,
+		HypervisorConfig: s.config.HypervisorConfig,
+	}
+
+	if s.devManager != nil {
+		for _, d := range s.devManager.GetAllDevices() {
+			info.Devices = append(info.Devices, d.Save())
+		}
+		sort.Slice(info.Devices, func(i, j int) bool {
+			return info.Devices[i].ID < info.Devices[j].ID
+		})
+	}
+
+	if s.state.State == types.StateRunning || s.state.State == types.StatePaused {
+		info.Agent = s.checkAgent()
+	}
+
+	if l, ok := s.hypervisor.(qmpCommandLister); ok {
+		info.QMPCommands = l.qmpCommands()
+	}
+
+	return info
+}
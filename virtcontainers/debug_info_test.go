@@ -0,0 +1,91 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckAgent never answers the checks.
+type stuckAgent struct {
+	noopAgent
+	release chan struct{}
+}
+
+func (a *stuckAgent) check() error {
+	<-a.release
+	return nil
+}
+
+func TestQMPHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	var h qmpHistory
+	assert.Empty(h.list())
+
+	for i := 0; i < qmpHistorySize+2; i++ {
+		h.record(fmt.Sprintf("command %d", i))
+	}
+
+	commands := h.list()
+	assert.Len(commands, qmpHistorySize)
+	assert.Equal("command 2", commands[0].Command)
+	assert.Equal(fmt.Sprintf("command %d", qmpHistorySize+1), commands[qmpHistorySize-1].Command)
+
+	assert.True(isQMPCommand(`{"execute":"query-status"}`))
+	assert.False(isQMPCommand(`{"return": {}}`))
+}
+
+func TestSandboxDebugInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	savedTimeout := agentCheckTimeout
+	agentCheckTimeout = 10 * time.Millisecond
+	defer func() {
+		agentCheckTimeout = savedTimeout
+	}()
+
+	s := &Sandbox{
+		id:         testSandboxID,
+		agent:      &noopAgent{},
+		hypervisor: &mockHypervisor{},
+		config: &SandboxConfig{
+			HypervisorType: MockHypervisor,
+		},
+	}
+
+	// the agent is not checked before the sandbox is started
+	info := s.DebugInfo()
+	assert.Equal(testSandboxID, info.ID)
+	assert.Equal(MockHypervisor, info.HypervisorType)
+	assert.False(info.Agent.Responsive)
+	assert.Empty(info.Agent.Error)
+	assert.Nil(info.QMPCommands)
+
+	s.state.State = types.StateRunning
+	info = s.DebugInfo()
+	assert.True(info.Agent.Responsive)
+
+	// a stuck agent does not block the debug info
+	agent := &stuckAgent{release: make(chan struct{})}
+	defer close(agent.release)
+	s.agent = agent
+
+	info = s.DebugInfo()
+	assert.False(info.Agent.Responsive)
+	assert.Equal(ErrAgentUnresponsive.Error(), info.Agent.Error)
+
+	q := &qemu{}
+	q.qmpHistory.record(`{"execute":"query-status"}`)
+	s.hypervisor = q
+	info = s.DebugInfo()
+	assert.Len(info.QMPCommands, 1)
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/go-ini/ini"
 )
@@ -56,8 +57,46 @@ const (
 
 	// Nvdimm means use nvdimm for hotplugging drives
 	Nvdimm = "nvdimm"
+
+	// VirtioPmem means use virtio-pmem for hotplugging drives
+	VirtioPmem = "virtio-pmem"
+)
+
+const (
+	// BlockFormatRaw is the format of raw block device images
+	BlockFormatRaw = "raw"
+
+	// BlockFormatQcow2 is the format of qcow2 block device images
+	BlockFormatQcow2 = "qcow2"
 )
 
+const (
+	// BlockIntegrityCRC32C protects a block device from corruption with
+	// crc32c checksums.
+	BlockIntegrityCRC32C = "crc32c"
+
+	// BlockIntegritySHA256 protects a block device from corruption with
+	// sha256 hashes.
+	BlockIntegritySHA256 = "sha256"
+
+	// BlockIntegrityHMACSHA256 makes the tampering of a block device
+	// evident with sha256 HMACs, keyed with a key generated inside the
+	// guest and never leaving it.
+	BlockIntegrityHMACSHA256 = "hmac-sha256"
+)
+
+// ValidBlockIntegrity checks integrity is one of the integrity algorithms
+// of the block devices, an empty one meaning no integrity protection.
+func ValidBlockIntegrity(integrity string) error {
+	switch integrity {
+	case "", BlockIntegrityCRC32C, BlockIntegritySHA256, BlockIntegrityHMACSHA256:
+		return nil
+	}
+
+	return fmt.Errorf("Invalid block device integrity algorithm %q, expecting %q, %q or %q",
+		integrity, BlockIntegrityCRC32C, BlockIntegritySHA256, BlockIntegrityHMACSHA256)
+}
+
 const (
 	// Virtio9P means use virtio-9p for the shared file system
 	Virtio9P = "virtio-9p"
@@ -109,6 +148,44 @@ type DeviceInfo struct {
 	// DriverOptions is specific options for each device driver
 	// for example, for BlockDevice, we can set DriverOptions["blockDriver"]="virtio-blk"
 	DriverOptions map[string]string
+
+	// IOLimits are the rate limits applied to a block device once it
+	// has been hotplugged.
+	IOLimits BlockIOLimits
+
+	// ReadOnly makes the hypervisor write protect a block device
+	ReadOnly bool
+
+	// Format is the format of a block device image, BlockFormatRaw or
+	// BlockFormatQcow2, never detected. Block devices with a format are
+	// image files HostPath points to, rather than device nodes.
+	Format string
+
+	// Integrity is the algorithm of the dm-integrity target the agent
+	// sets up on a block device, formatting it. No integrity protection
+	// when empty.
+	Integrity string
+}
+
+// BlockIOLimits represents the rate limits of a block drive.
+// A zero value means no limit.
+type BlockIOLimits struct {
+	// ReadBps is the maximum number of bytes read per second
+	ReadBps uint64
+
+	// WriteBps is the maximum number of bytes written per second
+	WriteBps uint64
+
+	// ReadIops is the maximum number of read operations per second
+	ReadIops uint64
+
+	// WriteIops is the maximum number of write operations per second
+	WriteIops uint64
+}
+
+// IsSet returns true if at least one of the limits is set.
+func (l BlockIOLimits) IsSet() bool {
+	return l.ReadBps != 0 || l.WriteBps != 0 || l.ReadIops != 0 || l.WriteIops != 0
 }
 
 // BlockDrive represents a block storage drive which may be used in case the storage
@@ -147,6 +224,34 @@ type BlockDrive struct {
 
 	// ShareRW enables multiple qemu instances to share the File
 	ShareRW bool
+
+	// Serial is the serial number of a virtio-blk drive, letting the guest
+	// find the drive whatever the order it has been probed in.
+	Serial string
+
+	// IOLimits are the rate limits applied to the drive
+	IOLimits BlockIOLimits
+
+	// ReadOnly prevents the guest from writing to the drive
+	ReadOnly bool
+
+	// Integrity is the algorithm of the dm-integrity target the agent
+	// sets up on the drive
+	Integrity string
+}
+
+// virtioBlkSerialMaxLen is the maximum length of a virtio-blk serial number.
+const virtioBlkSerialMaxLen = 20
+
+// BlockDriveSerial returns the serial number of the virtio-blk drive
+// identified by id.
+func BlockDriveSerial(id string) string {
+	serial := strings.TrimPrefix(id, "drive-")
+	if len(serial) > virtioBlkSerialMaxLen {
+		serial = serial[:virtioBlkSerialMaxLen]
+	}
+
+	return serial
 }
 
 // VFIODeviceType indicates VFIO device type
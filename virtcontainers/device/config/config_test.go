@@ -0,0 +1,40 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockDriveSerial(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("0123456789abcdef", BlockDriveSerial("drive-0123456789abcdef"))
+	assert.Equal("0123456789abcdef0123", BlockDriveSerial("drive-0123456789abcdef0123456"))
+	assert.Equal("foo", BlockDriveSerial("foo"))
+}
+
+func TestBlockIOLimitsIsSet(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(BlockIOLimits{}.IsSet())
+	assert.True(BlockIOLimits{ReadBps: 1}.IsSet())
+	assert.True(BlockIOLimits{WriteBps: 1}.IsSet())
+	assert.True(BlockIOLimits{ReadIops: 1}.IsSet())
+	assert.True(BlockIOLimits{WriteIops: 1}.IsSet())
+}
+
+func TestValidBlockIntegrity(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, integrity := range []string{"", BlockIntegrityCRC32C, BlockIntegritySHA256, BlockIntegrityHMACSHA256} {
+		assert.NoError(ValidBlockIntegrity(integrity))
+	}
+
+	assert.Error(ValidBlockIntegrity("md5"))
+}
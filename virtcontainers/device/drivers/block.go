@@ -12,6 +12,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/device/api"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/utils"
 )
 
@@ -51,7 +52,7 @@ func (device *BlockDevice) Attach(devReceiver api.DeviceReceiver) (err error) {
 
 	defer func() {
 		if err != nil {
-			devReceiver.DecrementSandboxBlockIndex()
+			devReceiver.UnsetSandboxBlockIndex(index)
 			device.bumpAttachCount(false)
 		}
 	}()
@@ -60,11 +61,26 @@ func (device *BlockDevice) Attach(devReceiver api.DeviceReceiver) (err error) {
 		return err
 	}
 
+	format := config.BlockFormatRaw
+	if device.DeviceInfo.Format != "" {
+		format = device.DeviceInfo.Format
+		if err = checkBlockImage(device.DeviceInfo.HostPath, format); err != nil {
+			return err
+		}
+	}
+
+	if err = config.ValidBlockIntegrity(device.DeviceInfo.Integrity); err != nil {
+		return err
+	}
+
 	drive := &config.BlockDrive{
-		File:   device.DeviceInfo.HostPath,
-		Format: "raw",
-		ID:     utils.MakeNameID("drive", device.DeviceInfo.ID, maxDevIDSize),
-		Index:  index,
+		File:      device.DeviceInfo.HostPath,
+		Format:    format,
+		ID:        utils.MakeNameID("drive", device.DeviceInfo.ID, maxDevIDSize),
+		Index:     index,
+		IOLimits:  device.DeviceInfo.IOLimits,
+		ReadOnly:  device.DeviceInfo.ReadOnly,
+		Integrity: device.DeviceInfo.Integrity,
 	}
 
 	customOptions := device.DeviceInfo.DriverOptions
@@ -78,14 +94,17 @@ func (device *BlockDevice) Attach(devReceiver api.DeviceReceiver) (err error) {
 		}
 
 		drive.SCSIAddr = scsiAddr
-	} else if customOptions["block-driver"] != "nvdimm" {
+	} else if customOptions["block-driver"] != "nvdimm" &&
+		customOptions["block-driver"] != "virtio-pmem" {
 		var globalIdx int
 
 		switch customOptions["block-driver"] {
 		case "virtio-blk":
 			globalIdx = index
+			drive.Serial = config.BlockDriveSerial(drive.ID)
 		case "virtio-blk-ccw":
 			globalIdx = index
+			drive.Serial = config.BlockDriveSerial(drive.ID)
 		case "virtio-mmio":
 			//With firecracker the rootfs for the VM itself
 			//sits at /dev/vda and consumes the first index.
@@ -133,9 +152,26 @@ func (device *BlockDevice) Detach(devReceiver api.DeviceReceiver) error {
 	deviceLogger().WithField("device", device.DeviceInfo.HostPath).Info("Unplugging block device")
 
 	if err = devReceiver.HotplugRemoveDevice(device, config.DeviceBlock); err != nil {
-		deviceLogger().WithError(err).Error("Failed to unplug block device")
-		return err
+		if vcTypes.HotplugErrorKind(err) != vcTypes.ErrNotSupported {
+			deviceLogger().WithError(err).Error("Failed to unplug block device")
+			return err
+		}
+
+		// The device, e.g. virtio-pmem, stays in the VM until it is
+		// stopped, and so does its index.
+		deviceLogger().WithError(err).Warn("Block device left plugged")
+		err = nil
+		return nil
 	}
+
+	// The index, and the SCSI address it maps to, can be used by
+	// another device now.
+	if device.BlockDrive != nil {
+		if err := devReceiver.UnsetSandboxBlockIndex(device.BlockDrive.Index); err != nil {
+			deviceLogger().WithError(err).WithField("index", device.BlockDrive.Index).Warn("Failed to release block index")
+		}
+	}
+
 	return nil
 }
 
@@ -157,16 +193,19 @@ func (device *BlockDevice) Save() persistapi.DeviceState {
 	drive := device.BlockDrive
 	if drive != nil {
 		ds.BlockDrive = &persistapi.BlockDrive{
-			File:     drive.File,
-			Format:   drive.Format,
-			ID:       drive.ID,
-			Index:    drive.Index,
-			MmioAddr: drive.MmioAddr,
-			PCIAddr:  drive.PCIAddr,
-			SCSIAddr: drive.SCSIAddr,
-			NvdimmID: drive.NvdimmID,
-			VirtPath: drive.VirtPath,
-			DevNo:    drive.DevNo,
+			File:      drive.File,
+			Format:    drive.Format,
+			ID:        drive.ID,
+			Index:     drive.Index,
+			MmioAddr:  drive.MmioAddr,
+			PCIAddr:   drive.PCIAddr,
+			SCSIAddr:  drive.SCSIAddr,
+			NvdimmID:  drive.NvdimmID,
+			VirtPath:  drive.VirtPath,
+			DevNo:     drive.DevNo,
+			Serial:    drive.Serial,
+			ReadOnly:  drive.ReadOnly,
+			Integrity: drive.Integrity,
 		}
 	}
 	return ds
@@ -182,17 +221,22 @@ func (device *BlockDevice) Load(ds persistapi.DeviceState) {
 		return
 	}
 	device.BlockDrive = &config.BlockDrive{
-		File:     bd.File,
-		Format:   bd.Format,
-		ID:       bd.ID,
-		Index:    bd.Index,
-		MmioAddr: bd.MmioAddr,
-		PCIAddr:  bd.PCIAddr,
-		SCSIAddr: bd.SCSIAddr,
-		NvdimmID: bd.NvdimmID,
-		VirtPath: bd.VirtPath,
-		DevNo:    bd.DevNo,
+		File:      bd.File,
+		Format:    bd.Format,
+		ID:        bd.ID,
+		Index:     bd.Index,
+		MmioAddr:  bd.MmioAddr,
+		PCIAddr:   bd.PCIAddr,
+		SCSIAddr:  bd.SCSIAddr,
+		NvdimmID:  bd.NvdimmID,
+		VirtPath:  bd.VirtPath,
+		DevNo:     bd.DevNo,
+		Serial:    bd.Serial,
+		ReadOnly:  bd.ReadOnly,
+		Integrity: bd.Integrity,
 	}
+	device.DeviceInfo.ReadOnly = bd.ReadOnly
+	device.DeviceInfo.Integrity = bd.Integrity
 }
 
 // It should implement GetAttachCount() and DeviceID() as api.Device implementation
@@ -0,0 +1,42 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package drivers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/api"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type unplugErrorReceiver struct {
+	api.MockDeviceReceiver
+	err error
+}
+
+func (r *unplugErrorReceiver) HotplugRemoveDevice(api.Device, config.DeviceType) error {
+	return r.err
+}
+
+func TestBlockDeviceDetachFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	device := NewBlockDevice(&config.DeviceInfo{ID: "foo"})
+	device.AttachCount = 1
+
+	// the device is still attached after a failed unplug
+	receiver := &unplugErrorReceiver{err: &vcTypes.HotplugError{Kind: vcTypes.ErrHotplugFailed, Err: errors.New("failed")}}
+	assert.Error(device.Detach(receiver))
+	assert.Equal(uint(1), device.GetAttachCount())
+
+	// but not after an unsupported one, the device stays in the VM
+	receiver.err = &vcTypes.HotplugError{Kind: vcTypes.ErrNotSupported, Err: vcTypes.ErrNotSupported}
+	assert.NoError(device.Detach(receiver))
+	assert.Equal(uint(0), device.GetAttachCount())
+}
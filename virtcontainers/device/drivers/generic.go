@@ -132,6 +132,10 @@ func (device *GenericDevice) Save() persistapi.DeviceState {
 		dss.Major = info.Major
 		dss.Minor = info.Minor
 		dss.DriverOptions = info.DriverOptions
+		if info.Format != "" {
+			dss.HostPath = info.HostPath
+			dss.Format = info.Format
+		}
 	}
 	return dss
 }
@@ -147,5 +151,7 @@ func (device *GenericDevice) Load(ds persistapi.DeviceState) {
 		Major:         ds.Major,
 		Minor:         ds.Minor,
 		DriverOptions: ds.DriverOptions,
+		HostPath:      ds.HostPath,
+		Format:        ds.Format,
 	}
 }
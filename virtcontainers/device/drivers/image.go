@@ -0,0 +1,221 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package drivers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+)
+
+const (
+	qcow2Magic = "QFI\xfb"
+
+	// qcow2HeaderSize is the size of the version 2 qcow2 header, after
+	// which the header extensions start.
+	qcow2HeaderSize = 72
+
+	// qcow2HeaderLengthOffset is the offset of the header length field
+	// of the version 3 qcow2 header.
+	qcow2HeaderLengthOffset = 100
+
+	// qcow2BackingFormatExt is the type of the header extension giving
+	// the format of the backing file.
+	qcow2BackingFormatExt = 0xe2792aca
+
+	// qcow2MaxExtensionsSize bounds the size of the header extensions.
+	qcow2MaxExtensionsSize = 64 * 1024
+
+	// qcow2MaxBackingFileSize is the maximum length of a backing file
+	// name QEMU accepts.
+	qcow2MaxBackingFileSize = 1023
+
+	// maxBackingChainLength bounds the number of images of a backing
+	// file chain.
+	maxBackingChainLength = 16
+)
+
+// checkBlockImage makes sure the block device image path can be given to
+// the hypervisor in format, which must be explicit: a guest writable image
+// could otherwise pick its own format. The backing files of qcow2 images
+// must be relative to the directory of the image, with an explicit format
+// as well, and must not leave that directory.
+func checkBlockImage(path, format string) error {
+	switch format {
+	case config.BlockFormatRaw:
+		return nil
+	case config.BlockFormatQcow2:
+		return checkBackingChain(path)
+	default:
+		return fmt.Errorf("Unsupported block device image format %q, expecting %s or %s",
+			format, config.BlockFormatRaw, config.BlockFormatQcow2)
+	}
+}
+
+// isQcow2Image checks if path starts with the qcow2 magic.
+func isQcow2Image(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return string(magic) == qcow2Magic, nil
+}
+
+// qcow2BackingFile returns the name and the format of the backing file of
+// the qcow2 image path, or empty strings if the image has no backing file.
+func qcow2BackingFile(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, qcow2HeaderLengthOffset+4)
+	if _, err := io.ReadFull(f, header[:qcow2HeaderSize]); err != nil {
+		return "", "", fmt.Errorf("Invalid qcow2 image %s: %v", path, err)
+	}
+
+	if string(header[:len(qcow2Magic)]) != qcow2Magic {
+		return "", "", fmt.Errorf("Invalid qcow2 image %s: bad magic", path)
+	}
+
+	offset := binary.BigEndian.Uint64(header[8:16])
+	size := binary.BigEndian.Uint32(header[16:20])
+	if offset == 0 || size == 0 {
+		return "", "", nil
+	}
+
+	if size > qcow2MaxBackingFileSize {
+		return "", "", fmt.Errorf("Invalid qcow2 image %s: backing file name too long", path)
+	}
+
+	name := make([]byte, size)
+	if _, err := f.ReadAt(name, int64(offset)); err != nil {
+		return "", "", fmt.Errorf("Invalid qcow2 image %s: %v", path, err)
+	}
+
+	extOffset := uint32(qcow2HeaderSize)
+	if version := binary.BigEndian.Uint32(header[4:8]); version >= 3 {
+		if _, err := f.ReadAt(header[qcow2HeaderSize:], qcow2HeaderSize); err != nil {
+			return "", "", fmt.Errorf("Invalid qcow2 image %s: %v", path, err)
+		}
+		extOffset = binary.BigEndian.Uint32(header[qcow2HeaderLengthOffset:])
+	}
+
+	format, err := qcow2BackingFormat(f, int64(extOffset))
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid qcow2 image %s: %v", path, err)
+	}
+
+	return string(name), format, nil
+}
+
+// qcow2BackingFormat returns the backing file format header extension of
+// the qcow2 image f, starting at offset.
+func qcow2BackingFormat(f *os.File, offset int64) (string, error) {
+	r := io.NewSectionReader(f, offset, qcow2MaxExtensionsSize)
+
+	for {
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return "", err
+		}
+
+		extType := binary.BigEndian.Uint32(ext[:4])
+		extSize := binary.BigEndian.Uint32(ext[4:])
+		if extType == 0 {
+			return "", nil
+		}
+
+		data := make([]byte, (extSize+7)&^7)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+
+		if extType == qcow2BackingFormatExt {
+			return string(data[:extSize]), nil
+		}
+	}
+}
+
+// checkBackingChain makes sure all the backing files of the qcow2 image path
+// are available within its directory with an explicit format, and that the
+// chain is neither circular nor too long.
+func checkBackingChain(path string) error {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(realPath)
+	seen := map[string]bool{}
+
+	for i := 0; ; i++ {
+		if i == maxBackingChainLength {
+			return fmt.Errorf("Backing file chain of %s is too long", path)
+		}
+
+		if seen[realPath] {
+			return fmt.Errorf("Circular backing file chain with %s", realPath)
+		}
+		seen[realPath] = true
+
+		isQcow2, err := isQcow2Image(realPath)
+		if err != nil {
+			return err
+		}
+		if !isQcow2 {
+			return fmt.Errorf("%s is not a qcow2 image", realPath)
+		}
+
+		backing, format, err := qcow2BackingFile(realPath)
+		if err != nil {
+			return err
+		}
+
+		if backing == "" {
+			return nil
+		}
+
+		// QEMU resolves relative backing files from the directory of
+		// the image, and parses a colon as a protocol prefix, e.g. nbd:
+		// or json:.
+		if filepath.IsAbs(backing) || strings.Contains(backing, ":") {
+			return fmt.Errorf("Unsupported backing file %q for qcow2 image %s, it must be relative", backing, realPath)
+		}
+
+		// QEMU would probe the format of the backing file otherwise.
+		if format != config.BlockFormatRaw && format != config.BlockFormatQcow2 {
+			return fmt.Errorf("Backing file %q of qcow2 image %s has no explicit raw or qcow2 format", backing, realPath)
+		}
+
+		if realPath, err = filepath.EvalSymlinks(filepath.Join(filepath.Dir(realPath), backing)); err != nil {
+			return err
+		}
+
+		if filepath.Dir(realPath) != dir {
+			return fmt.Errorf("Backing file %s of qcow2 image %s is out of %s", realPath, path, dir)
+		}
+
+		if format == config.BlockFormatRaw {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package drivers
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeQcow2Header writes the header of a version 2 qcow2 image, with its
+// backing file and the backing format header extension, if any.
+func writeQcow2Header(path, backing, backingFormat string) error {
+	header := make([]byte, qcow2HeaderSize)
+	copy(header, qcow2Magic)
+	binary.BigEndian.PutUint32(header[4:8], 2)
+
+	if backingFormat != "" {
+		ext := make([]byte, 8+(len(backingFormat)+7)&^7)
+		binary.BigEndian.PutUint32(ext[:4], qcow2BackingFormatExt)
+		binary.BigEndian.PutUint32(ext[4:8], uint32(len(backingFormat)))
+		copy(ext[8:], backingFormat)
+		header = append(header, ext...)
+	}
+	header = append(header, make([]byte, 8)...)
+
+	if backing != "" {
+		binary.BigEndian.PutUint64(header[8:16], uint64(len(header)))
+		binary.BigEndian.PutUint32(header[16:20], uint32(len(backing)))
+		header = append(header, backing...)
+	}
+
+	return ioutil.WriteFile(path, header, 0600)
+}
+
+func TestIsQcow2Image(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "image")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, err = isQcow2Image(filepath.Join(dir, "missing"))
+	assert.Error(err)
+
+	raw := filepath.Join(dir, "raw")
+	assert.NoError(ioutil.WriteFile(raw, []byte("QF"), 0600))
+	isQcow2, err := isQcow2Image(raw)
+	assert.NoError(err)
+	assert.False(isQcow2)
+
+	qcow2 := filepath.Join(dir, "qcow2")
+	assert.NoError(writeQcow2Header(qcow2, "", ""))
+	isQcow2, err = isQcow2Image(qcow2)
+	assert.NoError(err)
+	assert.True(isQcow2)
+}
+
+func TestQcow2BackingFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "image")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "image")
+
+	assert.NoError(writeQcow2Header(image, "", ""))
+	backing, format, err := qcow2BackingFile(image)
+	assert.NoError(err)
+	assert.Empty(backing)
+	assert.Empty(format)
+
+	assert.NoError(writeQcow2Header(image, "base.qcow2", config.BlockFormatQcow2))
+	backing, format, err = qcow2BackingFile(image)
+	assert.NoError(err)
+	assert.Equal("base.qcow2", backing)
+	assert.Equal(config.BlockFormatQcow2, format)
+
+	assert.NoError(writeQcow2Header(image, "base.raw", ""))
+	backing, format, err = qcow2BackingFile(image)
+	assert.NoError(err)
+	assert.Equal("base.raw", backing)
+	assert.Empty(format)
+
+	assert.NoError(ioutil.WriteFile(image, []byte("raw image"), 0600))
+	_, _, err = qcow2BackingFile(image)
+	assert.Error(err)
+}
+
+func TestCheckBlockImage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "image")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base")
+	top := filepath.Join(dir, "top")
+
+	assert.NoError(ioutil.WriteFile(base, make([]byte, 512), 0600))
+	assert.NoError(writeQcow2Header(top, "base", config.BlockFormatRaw))
+
+	assert.NoError(checkBlockImage(top, config.BlockFormatQcow2))
+	assert.NoError(checkBlockImage(base, config.BlockFormatRaw))
+
+	// raw images are never probed, whatever their content
+	assert.NoError(checkBlockImage(top, config.BlockFormatRaw))
+
+	// the format must be explicit
+	for _, f := range []string{"", "auto", "vmdk"} {
+		assert.Error(checkBlockImage(top, f), "format %q", f)
+	}
+
+	// a raw image is not a qcow2 image
+	assert.Error(checkBlockImage(base, config.BlockFormatQcow2))
+
+	// the backing files must be relative, within the directory of the
+	// image, with an explicit format
+	outside, err := ioutil.TempDir("", "image")
+	assert.NoError(err)
+	defer os.RemoveAll(outside)
+	assert.NoError(ioutil.WriteFile(filepath.Join(outside, "base"), make([]byte, 512), 0600))
+	assert.NoError(os.Symlink(filepath.Join(outside, "base"), filepath.Join(dir, "link")))
+
+	for _, b := range []string{
+		base,
+		"../" + filepath.Base(outside) + "/base",
+		"link",
+		"nbd://localhost/base",
+		"json:{\"driver\": \"raw\"}",
+	} {
+		assert.NoError(writeQcow2Header(top, b, config.BlockFormatRaw))
+		assert.Error(checkBlockImage(top, config.BlockFormatQcow2), "backing file %q", b)
+	}
+
+	assert.NoError(writeQcow2Header(top, "base", ""))
+	assert.Error(checkBlockImage(top, config.BlockFormatQcow2))
+
+	// missing backing file
+	assert.NoError(writeQcow2Header(top, "base", config.BlockFormatQcow2))
+	assert.NoError(os.Remove(base))
+	assert.Error(checkBlockImage(top, config.BlockFormatQcow2))
+
+	// circular backing file chain
+	assert.NoError(writeQcow2Header(base, "top", config.BlockFormatQcow2))
+	assert.Error(checkBlockImage(top, config.BlockFormatQcow2))
+
+	// qcow2 backing file
+	assert.NoError(writeQcow2Header(base, "", ""))
+	assert.NoError(checkBlockImage(top, config.BlockFormatQcow2))
+}
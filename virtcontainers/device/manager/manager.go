@@ -31,6 +31,8 @@ const (
 	VirtioSCSI string = "virtio-scsi"
 	// Nvdimm indicates block driver is nvdimm based
 	Nvdimm string = "nvdimm"
+	// VirtioPmem indicates block driver is virtio-pmem based
+	VirtioPmem string = "virtio-pmem"
 )
 
 var (
@@ -41,6 +43,9 @@ var (
 	ErrDeviceNotExist = errors.New("device with specified ID hasn't been created")
 	// ErrDeviceNotAttached represents the device isn't attached
 	ErrDeviceNotAttached = errors.New("device isn't attached")
+	// ErrReadOnlyDevice represents a read-write access to a block device
+	// which has already been created read-only
+	ErrReadOnlyDevice = errors.New("device has been created read-only")
 	// ErrRemoveAttachedDevice represents the device isn't detached
 	// so not allow to remove from list
 	ErrRemoveAttachedDevice = errors.New("can't remove attached device")
@@ -68,6 +73,8 @@ func NewDeviceManager(blockDriver string, devices []api.Device) api.DeviceManage
 		dm.blockDriver = VirtioBlock
 	} else if blockDriver == Nvdimm {
 		dm.blockDriver = Nvdimm
+	} else if blockDriver == VirtioPmem {
+		dm.blockDriver = VirtioPmem
 	} else if blockDriver == VirtioBlockCCW {
 		dm.blockDriver = VirtioBlockCCW
 	} else {
@@ -82,6 +89,9 @@ func NewDeviceManager(blockDriver string, devices []api.Device) api.DeviceManage
 
 func (dm *deviceManager) findDeviceByMajorMinor(major, minor int64) api.Device {
 	for _, dev := range dm.devices {
+		if isBlockImage(dev) {
+			continue
+		}
 		dma, dmi := dev.GetMajorMinor()
 		if dma == major && dmi == minor {
 			return dev
@@ -90,13 +100,31 @@ func (dm *deviceManager) findDeviceByMajorMinor(major, minor int64) api.Device {
 	return nil
 }
 
+// findBlockImage returns the block device of the image file path
+func (dm *deviceManager) findBlockImage(path string) api.Device {
+	for _, dev := range dm.devices {
+		if isBlockImage(dev) && dev.(*drivers.BlockDevice).DeviceInfo.HostPath == path {
+			return dev
+		}
+	}
+	return nil
+}
+
 // createDevice creates one device based on DeviceInfo
 func (dm *deviceManager) createDevice(devInfo config.DeviceInfo) (dev api.Device, err error) {
-	path, err := config.GetHostPathFunc(devInfo)
-	if err != nil {
-		return nil, err
+	var existingDev api.Device
+	path := devInfo.HostPath
+
+	// Block device images are files on the host, without major/minor numbers
+	if devInfo.Format != "" {
+		existingDev = dm.findBlockImage(path)
+	} else {
+		if path, err = config.GetHostPathFunc(devInfo); err != nil {
+			return nil, err
+		}
+		devInfo.HostPath = path
+		existingDev = dm.findDeviceByMajorMinor(devInfo.Major, devInfo.Minor)
 	}
-	devInfo.HostPath = path
 
 	defer func() {
 		if err == nil {
@@ -104,7 +132,11 @@ func (dm *deviceManager) createDevice(devInfo config.DeviceInfo) (dev api.Device
 		}
 	}()
 
-	if existingDev := dm.findDeviceByMajorMinor(devInfo.Major, devInfo.Minor); existingDev != nil {
+	if existingDev != nil {
+		// The guest can't be given write access to a write protected device
+		if b, ok := existingDev.(*drivers.BlockDevice); ok && b.DeviceInfo.ReadOnly && !devInfo.ReadOnly {
+			return nil, ErrReadOnlyDevice
+		}
 		return existingDev, nil
 	}
 
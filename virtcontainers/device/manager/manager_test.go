@@ -200,6 +200,98 @@ func TestAttachBlockDevice(t *testing.T) {
 
 	err = device.Detach(devReceiver)
 	assert.Nil(t, err)
+
+	// rate limits are passed to the drive
+	dm.devices = make(map[string]api.Device)
+	deviceInfo.IOLimits = config.BlockIOLimits{ReadBps: 1024, WriteIops: 100}
+	device, err = dm.NewDevice(deviceInfo)
+	assert.Nil(t, err)
+	err = device.Attach(devReceiver)
+	assert.Nil(t, err)
+	assert.Equal(t, deviceInfo.IOLimits, device.(*drivers.BlockDevice).BlockDrive.IOLimits)
+
+	err = device.Detach(devReceiver)
+	assert.Nil(t, err)
+}
+
+func TestNewReadOnlyBlockDevice(t *testing.T) {
+	dm := &deviceManager{
+		blockDriver: VirtioBlock,
+		devices:     make(map[string]api.Device),
+	}
+	path := "/dev/hda"
+	deviceInfo := config.DeviceInfo{
+		HostPath:      path,
+		ContainerPath: path,
+		DevType:       "b",
+		ReadOnly:      true,
+	}
+
+	devReceiver := &api.MockDeviceReceiver{}
+	device, err := dm.NewDevice(deviceInfo)
+	assert.Nil(t, err)
+
+	err = device.Attach(devReceiver)
+	assert.Nil(t, err)
+	assert.True(t, device.(*drivers.BlockDevice).BlockDrive.ReadOnly)
+
+	// a write protected device can be shared read-only only
+	ro, err := dm.NewDevice(deviceInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, device.DeviceID(), ro.DeviceID())
+
+	deviceInfo.ReadOnly = false
+	_, err = dm.NewDevice(deviceInfo)
+	assert.Equal(t, ErrReadOnlyDevice, err)
+
+	// the read-only flag survives a save/load cycle
+	loaded := &drivers.BlockDevice{}
+	loaded.Load(device.Save())
+	assert.True(t, loaded.DeviceInfo.ReadOnly)
+	assert.True(t, loaded.BlockDrive.ReadOnly)
+}
+
+func TestNewBlockImageDevice(t *testing.T) {
+	dm := &deviceManager{
+		blockDriver: VirtioBlock,
+		devices:     make(map[string]api.Device),
+	}
+
+	// a block device without major/minor numbers
+	path := "/dev/hda"
+	blockDev, err := dm.NewDevice(config.DeviceInfo{
+		HostPath:      path,
+		ContainerPath: path,
+		DevType:       "b",
+	})
+	assert.Nil(t, err)
+
+	imageInfo := config.DeviceInfo{
+		HostPath:      "/images/image.qcow2",
+		ContainerPath: "/data",
+		DevType:       "b",
+		Format:        config.BlockFormatQcow2,
+	}
+	image, err := dm.NewDevice(imageInfo)
+	assert.Nil(t, err)
+	assert.NotEqual(t, blockDev.DeviceID(), image.DeviceID())
+	assert.Equal(t, "/images/image.qcow2", image.(*drivers.BlockDevice).DeviceInfo.HostPath)
+
+	// images are shared by path
+	same, err := dm.NewDevice(imageInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, image.DeviceID(), same.DeviceID())
+
+	imageInfo.HostPath = "/images/other.qcow2"
+	other, err := dm.NewDevice(imageInfo)
+	assert.Nil(t, err)
+	assert.NotEqual(t, image.DeviceID(), other.DeviceID())
+
+	// images are identified after a save/load cycle
+	loaded := &drivers.BlockDevice{}
+	loaded.Load(image.Save())
+	assert.Equal(t, "/images/image.qcow2", loaded.DeviceInfo.HostPath)
+	assert.Equal(t, config.BlockFormatQcow2, loaded.DeviceInfo.Format)
 }
 
 func TestAttachDetachDevice(t *testing.T) {
@@ -10,7 +10,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/kata-containers/runtime/virtcontainers/device/api"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
 )
 
 const (
@@ -31,6 +33,12 @@ func isVFIO(hostPath string) bool {
 	return false
 }
 
+// isBlockImage checks if the device is backed by an image file.
+func isBlockImage(dev api.Device) bool {
+	b, ok := dev.(*drivers.BlockDevice)
+	return ok && b.DeviceInfo != nil && b.DeviceInfo.Format != ""
+}
+
 // isBlock checks if the device is a block device.
 func isBlock(devInfo config.DeviceInfo) bool {
 	return devInfo.DevType == "b"
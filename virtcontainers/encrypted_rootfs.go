@@ -0,0 +1,135 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// luksKeyProviderTimeout bounds the time the key provider is given to
+// return the key of an encrypted rootfs.
+var luksKeyProviderTimeout = 30 * time.Second
+
+// RootfsEncryption describes the key of a LUKS encrypted block device
+// holding the container rootfs. The device is hotplugged to the guest
+// without being opened on the host, and the agent opens it with the key,
+// so that the plaintext data never reaches the host.
+type RootfsEncryption struct {
+	// KeyName is the name of the key in the LUKS key directory of the
+	// sandbox.
+	KeyName string
+
+	// KeyID identifies the key the key provider of the sandbox returns,
+	// when KeyName is not given.
+	KeyID string
+
+	// Fstype is the type of the filesystem of the opened device,
+	// defaultLUKSFstype when empty.
+	Fstype string
+}
+
+// Enabled returns true if the rootfs is encrypted.
+func (e RootfsEncryption) Enabled() bool {
+	return e.KeyName != "" || e.KeyID != ""
+}
+
+// rootfsEncrypted returns true if the rootfs of the container is a LUKS
+// encrypted block device opened in the guest.
+func (c *Container) rootfsEncrypted() bool {
+	return c.config != nil && c.config.RootfsEncryption.Enabled()
+}
+
+// hotplugEncryptedRootfs hotplugs the encrypted block device or image file
+// of the rootfs, which the agent opens and mounts.
+func (c *Container) hotplugEncryptedRootfs() error {
+	if c.rootFs.Mounted {
+		return fmt.Errorf("encrypted rootfs %q can not be mounted on the host", c.rootFs.Target)
+	}
+
+	path, err := filepath.EvalSymlinks(c.rootFs.Source)
+	if err != nil {
+		return err
+	}
+
+	fstype := c.config.RootfsEncryption.Fstype
+	if fstype == "" {
+		fstype = defaultLUKSFstype
+	}
+
+	// there is no "rootfs" dir on block device backed rootfs
+	c.rootfsSuffix = ""
+
+	// The state is set first for removeDrive to roll back.
+	c.state.Fstype = fstype
+
+	if c.state.BlockDeviceID, err = c.newRootfsDevice(path, false, ""); err != nil {
+		return err
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"device-path": path,
+		"fs-type":     fstype,
+	}).Info("Encrypted rootfs opened in the guest")
+
+	if !c.sandbox.supportNewStore() {
+		if err := c.sandbox.storeSandboxDevices(); err != nil {
+			return err
+		}
+	}
+
+	return c.setStateFstype(fstype)
+}
+
+// rootfsLUKSDriverOptions returns the storage driver options asking the
+// agent to open the encrypted rootfs, with the key read from the key
+// directory or returned by the key provider of the sandbox. The key ID is
+// written to the standard input of the provider, not to show in its
+// arguments.
+func (c *Container) rootfsLUKSDriverOptions() ([]string, error) {
+	if err := checkLUKSSupport(c.sandbox); err != nil {
+		return nil, err
+	}
+
+	e := c.config.RootfsEncryption
+	if e.KeyName != "" {
+		return luksDriverOptions(c.sandbox, e.KeyName)
+	}
+
+	provider := c.sandbox.config.LUKSKeyProvider
+	if provider == "" {
+		return nil, fmt.Errorf("No key provider configured for the rootfs key %q", e.KeyID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), luksKeyProviderTimeout)
+	defer cancel()
+
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, provider)
+	cmd.Stdin = strings.NewReader(e.KeyID + "\n")
+	cmd.Stderr = &stderr
+
+	key, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Key provider %s failed to return the rootfs key %q: %v: %s", provider, e.KeyID, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Key provider %s returned an empty rootfs key %q", provider, e.KeyID)
+	}
+
+	if len(key) > maxLUKSKeySize {
+		return nil, fmt.Errorf("Key provider %s returned a rootfs key %q larger than %d bytes", provider, e.KeyID, maxLUKSKeySize)
+	}
+
+	return luksKeyDriverOptions(key), nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/kata-containers/agent/protocols/grpc"
+	"github.com/kata-containers/runtime/virtcontainers/device/api"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
+	"github.com/kata-containers/runtime/virtcontainers/device/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootfsLUKSDriverOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "luks")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "key"), []byte("secret"), 0600))
+
+	provider := filepath.Join(dir, "provider")
+	assert.NoError(ioutil.WriteFile(provider, []byte("#!/bin/sh\nread id && [ \"$id\" = rootfs ] && [ $# = 0 ] && printf secret\n"), 0700))
+
+	c := &Container{
+		sandbox: &Sandbox{config: &SandboxConfig{LUKSKeyDir: dir}},
+		config:  &ContainerConfig{},
+	}
+	assert.False(c.rootfsEncrypted())
+
+	expected := []string{kataLUKSDriverOption, luksKeyDriverOption + "c2VjcmV0"}
+
+	c.config.RootfsEncryption.KeyName = "key"
+	assert.True(c.rootfsEncrypted())
+
+	// the agent cannot open the rootfs
+	_, err = c.rootfsLUKSDriverOptions()
+	assert.Error(err)
+
+	c.sandbox.state.GuestLUKS = true
+	options, err := c.rootfsLUKSDriverOptions()
+	assert.NoError(err)
+	assert.Equal(expected, options)
+
+	// No key provider
+	c.config.RootfsEncryption = RootfsEncryption{KeyID: "rootfs"}
+	_, err = c.rootfsLUKSDriverOptions()
+	assert.Error(err)
+
+	c.sandbox.config.LUKSKeyProvider = provider
+	options, err = c.rootfsLUKSDriverOptions()
+	assert.NoError(err)
+	assert.Equal(expected, options)
+
+	// Unknown key
+	c.config.RootfsEncryption.KeyID = "other"
+	_, err = c.rootfsLUKSDriverOptions()
+	assert.Error(err)
+}
+
+func TestBuildEncryptedRootfs(t *testing.T) {
+	assert := assert.New(t)
+	k := kataAgent{}
+
+	dir, err := ioutil.TempDir("", "luks")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "key"), []byte("secret"), 0600))
+
+	ctrDevices := []api.Device{
+		&drivers.BlockDevice{
+			GenericDevice: &drivers.GenericDevice{ID: "rootfs"},
+			BlockDrive:    &config.BlockDrive{PCIAddr: "02/01"},
+		},
+	}
+
+	sandbox := &Sandbox{
+		devManager: manager.NewDeviceManager(manager.VirtioBlock, ctrDevices),
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				BlockDeviceDriver: config.VirtioBlock,
+			},
+			LUKSKeyDir: dir,
+		},
+	}
+	sandbox.state.GuestLUKS = true
+
+	c := &Container{
+		id:      testContainerID,
+		sandbox: sandbox,
+		config: &ContainerConfig{
+			RootfsEncryption: RootfsEncryption{KeyName: "key"},
+		},
+	}
+	c.state.BlockDeviceID = "rootfs"
+	c.state.Fstype = "ext4"
+
+	parent := filepath.Join(kataGuestSharedDir, c.id)
+
+	rootfs, err := k.buildContainerRootfs(sandbox, c, parent)
+	assert.NoError(err)
+	assert.Equal(&pb.Storage{
+		Driver:        kataBlkDevType,
+		Source:        "02/01",
+		Fstype:        "ext4",
+		DriverOptions: []string{kataLUKSDriverOption, luksKeyDriverOption + "c2VjcmV0"},
+		MountPoint:    parent,
+	}, rootfs)
+
+	c.config.RootfsEncryption.KeyName = "missing"
+	_, err = k.buildContainerRootfs(sandbox, c, parent)
+	assert.Error(err)
+}
@@ -55,6 +55,14 @@ const (
 
 	// IPVlanEndpointType is ipvlan network interface.
 	IPVlanEndpointType EndpointType = "ipvlan"
+
+	// XDPEndpointType is a veth network interface forwarded through an
+	// AF_XDP socket.
+	XDPEndpointType EndpointType = "xdp"
+
+	// TapFdEndpointType is an existing TAP device whose file descriptors
+	// are passed by a device plugin.
+	TapFdEndpointType EndpointType = "tap-fd"
 )
 
 // Set sets an endpoint type based on the input string.
@@ -81,6 +89,12 @@ func (endpointType *EndpointType) Set(value string) error {
 	case "ipvlan":
 		*endpointType = IPVlanEndpointType
 		return nil
+	case "xdp":
+		*endpointType = XDPEndpointType
+		return nil
+	case "tap-fd":
+		*endpointType = TapFdEndpointType
+		return nil
 	default:
 		return fmt.Errorf("Unknown endpoint type %s", value)
 	}
@@ -103,6 +117,10 @@ func (endpointType *EndpointType) String() string {
 		return string(TapEndpointType)
 	case IPVlanEndpointType:
 		return string(IPVlanEndpointType)
+	case XDPEndpointType:
+		return string(XDPEndpointType)
+	case TapFdEndpointType:
+		return string(TapFdEndpointType)
 	default:
 		return ""
 	}
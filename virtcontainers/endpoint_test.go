@@ -121,3 +121,23 @@ func TestSaveLoadIfPair(t *testing.T) {
 	// They are equal now.
 	assert.True(t, reflect.DeepEqual(netPair, loadedIfPair))
 }
+
+func TestSaveLoadEndpointPCIAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	veth := &VethEndpoint{PCIAddr: "02/01"}
+	loadedVeth := &VethEndpoint{}
+	loadedVeth.load(veth.save())
+	assert.Equal("02/01", loadedVeth.PciAddr())
+
+	tap := &TapEndpoint{PCIAddr: "02/02"}
+	loadedTap := &TapEndpoint{}
+	loadedTap.load(tap.save())
+	assert.Equal("02/02", loadedTap.PciAddr())
+
+	tapFd := &TapFdEndpoint{PCIAddr: "02/03", SocketPath: "/run/tap-plugin.sock"}
+	loadedTapFd := &TapFdEndpoint{}
+	loadedTapFd.load(tapFd.save())
+	assert.Equal("02/03", loadedTapFd.PciAddr())
+	assert.Equal("/run/tap-plugin.sock", loadedTapFd.SocketPath)
+}
@@ -25,6 +25,13 @@ type Factory interface {
 	// GetBaseVM returns a paused VM created by the base factory.
 	GetBaseVM(ctx context.Context, config VMConfig) (*VM, error)
 
+	// WarmVMs sets the number of paused VMs the factory keeps ready.
+	WarmVMs(ctx context.Context, count uint) error
+
+	// EvictVM replaces the paused VM id of the factory, or all of them
+	// when id is empty.
+	EvictVM(ctx context.Context, id string) error
+
 	// CloseFactory closes and cleans up the factory.
 	CloseFactory(ctx context.Context)
 }
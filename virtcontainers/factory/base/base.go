@@ -27,6 +27,13 @@ type FactoryBase interface {
 	// GetBaseVM returns a paused VM created by the base factory.
 	GetBaseVM(ctx context.Context, config vc.VMConfig) (*vc.VM, error)
 
+	// WarmVMs sets the number of paused VMs the base factory keeps ready.
+	WarmVMs(ctx context.Context, count uint) error
+
+	// EvictVM replaces the paused VM id of the base factory, or all of
+	// them when id is empty.
+	EvictVM(ctx context.Context, id string) error
+
 	// CloseFactory closes the base factory.
 	CloseFactory(ctx context.Context)
 }
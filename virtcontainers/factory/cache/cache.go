@@ -19,12 +19,22 @@ import (
 type cache struct {
 	base base.FactoryBase
 
+	// ctx is the context the VMs are created with. The context of a
+	// warm request ends with the request.
+	ctx context.Context
+
 	cacheCh   chan *vc.VM
-	closed    chan<- int
 	wg        sync.WaitGroup
 	closeOnce sync.Once
 
-	vmm     map[*vc.VM]interface{}
+	// workers holds the stop channel of each goroutine keeping a VM
+	// ready.
+	workers     []chan struct{}
+	workersLock sync.Mutex
+	closed      bool
+
+	// vmm maps the VMs ready to be handed out to their evict channel.
+	vmm     map[*vc.VM]chan struct{}
 	vmmLock sync.RWMutex
 }
 
@@ -34,50 +44,56 @@ func New(ctx context.Context, count uint, b base.FactoryBase) base.FactoryBase {
 		return b
 	}
 
-	cacheCh := make(chan *vc.VM)
-	closed := make(chan int, count)
 	c := cache{
 		base:    b,
-		cacheCh: cacheCh,
-		closed:  closed,
-		vmm:     make(map[*vc.VM]interface{}),
-	}
-	for i := 0; i < int(count); i++ {
-		c.wg.Add(1)
-		go func() {
-			for {
-				vm, err := b.GetBaseVM(ctx, c.Config())
-				if err != nil {
-					c.wg.Done()
-					c.CloseFactory(ctx)
-					return
-				}
-				c.addToVmm(vm)
-
-				select {
-				case cacheCh <- vm:
-					// Because vm will not be relased or changed
-					// by cacheServer.GetBaseVM or removeFromVmm.
-					// So removeFromVmm can be called after vm send to cacheCh.
-					c.removeFromVmm(vm)
-				case <-closed:
-					c.removeFromVmm(vm)
-					vm.Stop()
-					vm.Disconnect()
-					c.wg.Done()
-					return
-				}
-			}
-		}()
+		ctx:     ctx,
+		cacheCh: make(chan *vc.VM),
+		vmm:     make(map[*vc.VM]chan struct{}),
 	}
+	c.WarmVMs(ctx, count)
+
 	return &c
 }
 
-func (c *cache) addToVmm(vm *vc.VM) {
+// worker keeps a VM ready until it is handed out, evicted or the worker is
+// stopped, and starts over with a new one.
+func (c *cache) worker(stop <-chan struct{}) {
+	for {
+		vm, err := c.base.GetBaseVM(c.ctx, c.Config())
+		if err != nil {
+			c.wg.Done()
+			c.CloseFactory(c.ctx)
+			return
+		}
+
+		evict := make(chan struct{})
+		c.addToVmm(vm, evict)
+
+		select {
+		case c.cacheCh <- vm:
+			// Because vm will not be relased or changed
+			// by cacheServer.GetBaseVM or removeFromVmm.
+			// So removeFromVmm can be called after vm send to cacheCh.
+			c.removeFromVmm(vm)
+		case <-evict:
+			c.removeFromVmm(vm)
+			vm.Stop()
+			vm.Disconnect()
+		case <-stop:
+			c.removeFromVmm(vm)
+			vm.Stop()
+			vm.Disconnect()
+			c.wg.Done()
+			return
+		}
+	}
+}
+
+func (c *cache) addToVmm(vm *vc.VM, evict chan struct{}) {
 	c.vmmLock.Lock()
 	defer c.vmmLock.Unlock()
 
-	c.vmm[vm] = nil
+	c.vmm[vm] = evict
 }
 
 func (c *cache) removeFromVmm(vm *vc.VM) {
@@ -108,19 +124,89 @@ func (c *cache) GetVMStatus() []*pb.GrpcVMStatus {
 
 // GetBaseVM returns a base VM from cache factory's base factory.
 func (c *cache) GetBaseVM(ctx context.Context, config vc.VMConfig) (*vc.VM, error) {
-	vm, ok := <-c.cacheCh
-	if ok {
-		return vm, nil
+	c.workersLock.Lock()
+	closed, warm := c.closed, len(c.workers) > 0
+	c.workersLock.Unlock()
+
+	if closed {
+		return nil, fmt.Errorf("cache factory is closed")
+	}
+	if !warm {
+		return nil, fmt.Errorf("cache factory keeps no VM ready")
+	}
+
+	select {
+	case vm, ok := <-c.cacheCh:
+		if ok {
+			return vm, nil
+		}
+		return nil, fmt.Errorf("cache factory is closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WarmVMs starts or stops the goroutines keeping the VMs ready, so that
+// count VMs are ready.
+func (c *cache) WarmVMs(ctx context.Context, count uint) error {
+	c.workersLock.Lock()
+	defer c.workersLock.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("cache factory is closed")
+	}
+
+	for uint(len(c.workers)) < count {
+		stop := make(chan struct{})
+		c.workers = append(c.workers, stop)
+		c.wg.Add(1)
+		go c.worker(stop)
+	}
+
+	for uint(len(c.workers)) > count {
+		last := len(c.workers) - 1
+		close(c.workers[last])
+		c.workers = c.workers[:last]
 	}
-	return nil, fmt.Errorf("cache factory is closed")
+
+	return nil
+}
+
+// EvictVM stops the ready VM id, or all of them when id is empty. New VMs
+// replace them.
+func (c *cache) EvictVM(ctx context.Context, id string) error {
+	c.vmmLock.Lock()
+	defer c.vmmLock.Unlock()
+
+	found := false
+	for vm, evict := range c.vmm {
+		if id != "" && vm.ID() != id {
+			continue
+		}
+
+		close(evict)
+		delete(c.vmm, vm)
+		found = true
+	}
+
+	if id != "" && !found {
+		return fmt.Errorf("VM %s is not cached", id)
+	}
+
+	return nil
 }
 
 // CloseFactory closes the cache factory.
 func (c *cache) CloseFactory(ctx context.Context) {
 	c.closeOnce.Do(func() {
-		for len(c.closed) < cap(c.closed) { // send sufficient closed signal
-			c.closed <- 0
+		c.workersLock.Lock()
+		c.closed = true
+		for _, stop := range c.workers {
+			close(stop)
 		}
+		c.workers = nil
+		c.workersLock.Unlock()
+
 		c.wg.Wait()
 		close(c.cacheCh)
 		c.base.CloseFactory(ctx)
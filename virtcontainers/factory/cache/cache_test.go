@@ -9,6 +9,7 @@ import (
 	"context"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -49,3 +50,64 @@ func TestTemplateFactory(t *testing.T) {
 	// CloseFactory
 	f.CloseFactory(ctx)
 }
+
+func TestCacheWarmEvict(t *testing.T) {
+	assert := assert.New(t)
+
+	testDir, _ := ioutil.TempDir("", "vmfactory-tmp-")
+	hyperConfig := vc.HypervisorConfig{
+		KernelPath: testDir,
+		ImagePath:  testDir,
+	}
+	vmConfig := vc.VMConfig{
+		HypervisorType:   vc.MockHypervisor,
+		AgentType:        vc.NoopAgentType,
+		ProxyType:        vc.NoopProxyType,
+		HypervisorConfig: hyperConfig,
+	}
+
+	ctx := context.Background()
+
+	f := New(ctx, 1, direct.New(ctx, vmConfig))
+
+	// ready waits for count VMs to be kept ready.
+	ready := func(count int) {
+		for i := 0; i < 500 && len(f.GetVMStatus()) != count; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Len(f.GetVMStatus(), count)
+	}
+
+	// WarmVMs
+	assert.Nil(f.WarmVMs(ctx, 3))
+	ready(3)
+
+	assert.Nil(f.WarmVMs(ctx, 2))
+	ready(2)
+
+	// EvictVM
+	assert.Error(f.EvictVM(ctx, "unknown"))
+
+	id := f.GetVMStatus()[0].Id
+	assert.NotEmpty(id)
+	assert.Nil(f.EvictVM(ctx, id))
+	ready(2)
+	for _, status := range f.GetVMStatus() {
+		assert.NotEqual(id, status.Id)
+	}
+
+	assert.Nil(f.EvictVM(ctx, ""))
+	ready(2)
+
+	// No VM kept ready
+	assert.Nil(f.WarmVMs(ctx, 0))
+	ready(0)
+	_, err := f.GetBaseVM(ctx, vmConfig)
+	assert.Error(err)
+
+	// CloseFactory
+	f.CloseFactory(ctx)
+	assert.Error(f.WarmVMs(ctx, 1))
+	_, err = f.GetBaseVM(ctx, vmConfig)
+	assert.Error(err)
+}
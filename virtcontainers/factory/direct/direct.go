@@ -52,3 +52,13 @@ func (d *direct) CloseFactory(ctx context.Context) {
 func (d *direct) GetVMStatus() []*pb.GrpcVMStatus {
 	panic("ERROR: package direct does not support GetVMStatus")
 }
+
+// WarmVMs is not supported
+func (d *direct) WarmVMs(ctx context.Context, count uint) error {
+	panic("ERROR: package direct does not support WarmVMs")
+}
+
+// EvictVM is not supported
+func (d *direct) EvictVM(ctx context.Context, id string) error {
+	panic("ERROR: package direct does not support EvictVM")
+}
@@ -247,6 +247,16 @@ func (f *factory) GetBaseVM(ctx context.Context, config vc.VMConfig) (*vc.VM, er
 	return f.base.GetBaseVM(ctx, config)
 }
 
+// WarmVMs sets the number of paused VMs the base factory keeps ready.
+func (f *factory) WarmVMs(ctx context.Context, count uint) error {
+	return f.base.WarmVMs(ctx, count)
+}
+
+// EvictVM replaces the paused VM id of the base factory.
+func (f *factory) EvictVM(ctx context.Context, id string) error {
+	return f.base.EvictVM(ctx, id)
+}
+
 // CloseFactory closes the factory.
 func (f *factory) CloseFactory(ctx context.Context) {
 	f.base.CloseFactory(ctx)
@@ -66,3 +66,13 @@ func (g *grpccache) CloseFactory(ctx context.Context) {
 func (g *grpccache) GetVMStatus() []*pb.GrpcVMStatus {
 	panic("ERROR: package grpccache does not support GetVMStatus")
 }
+
+// WarmVMs is not supported
+func (g *grpccache) WarmVMs(ctx context.Context, count uint) error {
+	panic("ERROR: package grpccache does not support WarmVMs")
+}
+
+// EvictVM is not supported
+func (g *grpccache) EvictVM(ctx context.Context, id string) error {
+	panic("ERROR: package grpccache does not support EvictVM")
+}
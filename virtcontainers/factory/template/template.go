@@ -85,6 +85,16 @@ func (t *template) GetVMStatus() []*pb.GrpcVMStatus {
 	panic("ERROR: package template does not support GetVMStatus")
 }
 
+// WarmVMs is not supported
+func (t *template) WarmVMs(ctx context.Context, count uint) error {
+	panic("ERROR: package template does not support WarmVMs")
+}
+
+// EvictVM is not supported
+func (t *template) EvictVM(ctx context.Context, id string) error {
+	panic("ERROR: package template does not support EvictVM")
+}
+
 func (t *template) close() {
 	syscall.Unmount(t.statePath, 0)
 	os.RemoveAll(t.statePath)
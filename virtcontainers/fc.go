@@ -31,6 +31,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/kata-containers/runtime/virtcontainers/utils"
@@ -52,14 +53,22 @@ const (
 	//Having predefined names helps with cleanup
 	fcKernel             = "vmlinux"
 	fcRootfs             = "rootfs"
+	fcHybridVSock        = "kata.hvsock"
 	fcStopSandboxTimeout = 15
 	// This indicates the number of block devices that can be attached to the
 	// firecracker guest VM.
 	// We attach a pool of placeholder drives before the guest has started, and then
 	// patch the replace placeholder drives with drives with actual contents.
 	fcDiskPoolSize = 8
+	// fcGuestCID is the vsock context ID of the guest, which the hybrid
+	// vsock does not use on the host.
+	fcGuestCID = 3
 )
 
+// fcChrootBaseDir is the default base directory of the jailer chroots.
+// store.ConfigStoragePath cannot be used as the jailer needs exec perms.
+var fcChrootBaseDir = filepath.Join("/var/lib/", store.StoragePathSuffix)
+
 var fcKernelParams = append(commonVirtioblkKernelRootParams, []Param{
 	// The boot source is the first partition of the first block device added
 	{"pci", "off"},
@@ -92,6 +101,10 @@ func (s vmmState) String() string {
 // want to store on disk
 type FirecrackerInfo struct {
 	PID int
+
+	// DriveGrants maps the drive IDs to the host files the VMM user was
+	// granted access to.
+	DriveGrants map[string]string
 }
 
 type firecrackerState struct {
@@ -117,6 +130,9 @@ type firecracker struct {
 	uid           string //UID and GID to be used for the VMM
 	gid           string
 
+	// vmmUser is the unprivileged user firecracker runs as, if any.
+	vmmUser *vmmUser
+
 	info FirecrackerInfo
 
 	firecrackerd *exec.Cmd           //Tracks the firecracker process itself
@@ -218,8 +234,13 @@ func (fc *firecracker) createSandbox(ctx context.Context, id string, networkNS N
 	// Also jailer based on the id implicitly sets up cgroups under
 	// <cgroups_base>/<exec_file_name>/<id>/
 	hypervisorName := filepath.Base(hypervisorConfig.HypervisorPath)
-	//store.ConfigStoragePath cannot be used as we need exec perms
-	fc.chrootBaseDir = filepath.Join("/var/lib/", store.StoragePathSuffix)
+	fc.chrootBaseDir = fcChrootBaseDir
+	if hypervisorConfig.JailerChrootBase != "" {
+		if !filepath.IsAbs(hypervisorConfig.JailerChrootBase) {
+			return fmt.Errorf("The jailer chroot base %q is not an absolute path", hypervisorConfig.JailerChrootBase)
+		}
+		fc.chrootBaseDir = filepath.Clean(hypervisorConfig.JailerChrootBase)
+	}
 
 	fc.vmPath = filepath.Join(fc.chrootBaseDir, hypervisorName, fc.id)
 	fc.jailerRoot = filepath.Join(fc.vmPath, "root") // auto created by jailer
@@ -228,10 +249,25 @@ func (fc *firecracker) createSandbox(ctx context.Context, id string, networkNS N
 	// So we need to repopulate this at startSandbox where it is valid
 	fc.netNSPath = networkNS.NetNsPath
 
-	// Till we create lower privileged kata user run as root
+	// Without a lower privileged user run as root
 	// https://github.com/kata-containers/runtime/issues/1869
 	fc.uid = "0"
 	fc.gid = "0"
+	if hypervisorConfig.VMMUser != "" {
+		// The jailer drops the privileges of firecracker within its
+		// chroot.
+		if hypervisorConfig.JailerPath == "" {
+			return fmt.Errorf("The firecracker user %q requires the jailer", hypervisorConfig.VMMUser)
+		}
+
+		u, err := lookupVMMUser(hypervisorConfig.VMMUser)
+		if err != nil {
+			return err
+		}
+		fc.vmmUser = u
+		fc.uid = strconv.FormatUint(uint64(u.uid), 10)
+		fc.gid = strconv.FormatUint(uint64(u.gid), 10)
+	}
 
 	// No need to return an error from there since there might be nothing
 	// to fetch if this is the first time the hypervisor is created.
@@ -348,30 +384,19 @@ func (fc *firecracker) fcInit(timeout int) error {
 	var args []string
 	var cmd *exec.Cmd
 
-	//https://github.com/firecracker-microvm/firecracker/blob/master/docs/jailer.md#jailer-usage
-	//--seccomp-level specifies whether seccomp filters should be installed and how restrictive they should be. Possible values are:
-	//0 : disabled.
-	//1 : basic filtering. This prohibits syscalls not whitelisted by Firecracker.
-	//2 (default): advanced filtering. This adds further checks on some of the parameters of the allowed syscalls.
 	if fc.jailed {
-		args = []string{
-			"--id", fc.id,
-			"--node", "0", //FIXME: Comprehend NUMA topology or explicit ignore
-			"--seccomp-level", "2",
-			"--exec-file", fc.config.HypervisorPath,
-			"--uid", "0", //https://github.com/kata-containers/runtime/issues/1869
-			"--gid", "0",
-			"--chroot-base-dir", fc.chrootBaseDir,
-			"--daemonize",
-		}
+		// The jailer joins the network namespace of the sandbox
+		// itself, it must exist.
 		if fc.netNSPath != "" {
-			args = append(args, "--netns", fc.netNSPath)
+			if _, err = os.Stat(fc.netNSPath); err != nil {
+				return fmt.Errorf("Could not find the network namespace %s of the jailer: %v", fc.netNSPath, err)
+			}
 		}
+		args = fc.jailerArgs()
 		cmd = exec.Command(fc.config.JailerPath, args...)
 	} else {
 		args = []string{"--api-sock", fc.socketPath}
 		cmd = exec.Command(fc.config.HypervisorPath, args...)
-
 	}
 
 	fc.Logger().WithField("hypervisor args", args).Debug()
@@ -399,6 +424,32 @@ func (fc *firecracker) fcInit(timeout int) error {
 	return nil
 }
 
+// jailerArgs returns the arguments of the jailer, which sets up the chroot
+// of the VM under the chroot base, joins the network namespace of the
+// sandbox and drops the privileges of firecracker to the VMM user.
+func (fc *firecracker) jailerArgs() []string {
+	//https://github.com/firecracker-microvm/firecracker/blob/master/docs/jailer.md#jailer-usage
+	//--seccomp-level specifies whether seccomp filters should be installed and how restrictive they should be. Possible values are:
+	//0 : disabled.
+	//1 : basic filtering. This prohibits syscalls not whitelisted by Firecracker.
+	//2 (default): advanced filtering. This adds further checks on some of the parameters of the allowed syscalls.
+	args := []string{
+		"--id", fc.id,
+		"--node", "0", //FIXME: Comprehend NUMA topology or explicit ignore
+		"--seccomp-level", "2",
+		"--exec-file", fc.config.HypervisorPath,
+		"--uid", fc.uid,
+		"--gid", fc.gid,
+		"--chroot-base-dir", fc.chrootBaseDir,
+		"--daemonize",
+	}
+	if fc.netNSPath != "" {
+		args = append(args, "--netns", fc.netNSPath)
+	}
+
+	return args
+}
+
 func (fc *firecracker) fcEnd() (err error) {
 	span, _ := fc.trace("fcEnd")
 	defer span.Finish()
@@ -414,6 +465,9 @@ func (fc *firecracker) fcEnd() (err error) {
 	}()
 
 	pid := fc.info.PID
+	if pid <= 0 {
+		return nil
+	}
 
 	// Check if VM process is running, in case it is not, let's
 	// return from here.
@@ -482,7 +536,7 @@ func (fc *firecracker) fcSetBootSource(path, params string) error {
 	span, _ := fc.trace("fcSetBootSource")
 	defer span.Finish()
 	fc.Logger().WithFields(logrus.Fields{"kernel-path": path,
-		"kernel-params": params}).Debug("fcSetBootSource")
+		"kernel-params": newLogScrubber(fc.config.LogScrubParams).scrub(params)}).Debug("fcSetBootSource")
 
 	kernelPath, err := fc.fcJailResource(path, fcKernel)
 	if err != nil {
@@ -660,6 +714,10 @@ func (fc *firecracker) createDiskPool() error {
 			return err
 		}
 
+		if err := fc.grantDriveAccess(driveID, u.Path); err != nil {
+			return err
+		}
+
 		jailedDrive, err := fc.fcJailResource(u.Path, driveID)
 		if err != nil {
 			fc.Logger().WithField("createDiskPool failed", err).Error()
@@ -682,12 +740,81 @@ func (fc *firecracker) createDiskPool() error {
 	return nil
 }
 
-func (fc *firecracker) umountResource(jailedPath string) {
+// grantDriveAccess lets the VMM user read and write path, the host file of
+// the drive driveID. The grant replaces the previous one of the drive, and
+// is recorded to be revoked when the drive is removed or the jail is
+// cleaned up.
+func (fc *firecracker) grantDriveAccess(driveID, path string) error {
+	if fc.vmmUser == nil {
+		return nil
+	}
+
+	if err := fc.vmmUser.grantAccess(path); err != nil {
+		return err
+	}
+
+	prev, ok := fc.info.DriveGrants[driveID]
+	if fc.info.DriveGrants == nil {
+		fc.info.DriveGrants = make(map[string]string)
+	}
+	fc.info.DriveGrants[driveID] = path
+
+	if ok && prev != path {
+		fc.revokePathAccess(prev)
+	}
+
+	return nil
+}
+
+// revokeDriveAccess revokes the grant of the host file of the drive
+// driveID, if any.
+func (fc *firecracker) revokeDriveAccess(driveID string) {
+	path, ok := fc.info.DriveGrants[driveID]
+	if !ok {
+		return
+	}
+
+	delete(fc.info.DriveGrants, driveID)
+	fc.revokePathAccess(path)
+}
+
+// revokePathAccess removes the ACL entry of the VMM user from path, unless
+// another drive still uses it.
+func (fc *firecracker) revokePathAccess(path string) {
+	for _, p := range fc.info.DriveGrants {
+		if p == path {
+			return
+		}
+	}
+
+	if fc.vmmUser == nil {
+		fc.Logger().WithField("path", path).Warn("Could not revoke the access of the firecracker user, it is not configured anymore")
+		return
+	}
+
+	if err := fc.vmmUser.revokeAccess(path); err != nil {
+		fc.Logger().WithError(err).WithField("path", path).Warn("Could not revoke the access of the firecracker user")
+	}
+}
+
+// revokeDriveGrants revokes the grants of all the drives.
+func (fc *firecracker) revokeDriveGrants() {
+	for driveID := range fc.info.DriveGrants {
+		fc.revokeDriveAccess(driveID)
+	}
+}
+
+// umountResource unmounts a resource of the jail. It returns false if the
+// resource is still mounted.
+func (fc *firecracker) umountResource(jailedPath string) bool {
 	hostPath := filepath.Join(fc.jailerRoot, jailedPath)
 	err := syscall.Unmount(hostPath, syscall.MNT_DETACH)
-	if err != nil {
-		fc.Logger().WithField("umountResource failed", err).Info()
+	if err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		fc.Logger().WithField("umountResource failed", err).Error()
+		return false
 	}
+
+	return true
 }
 
 // cleanup all jail artifacts
@@ -695,18 +822,31 @@ func (fc *firecracker) cleanupJail() {
 	span, _ := fc.trace("cleanupJail")
 	defer span.Finish()
 
-	fc.umountResource(fcKernel)
-	fc.umountResource(fcRootfs)
+	fc.revokeDriveGrants()
+
+	if fc.vmPath == "" {
+		return
+	}
+
+	umounted := fc.umountResource(fcKernel)
+	umounted = fc.umountResource(fcRootfs) && umounted
 
 	for i := 0; i < fcDiskPoolSize; i++ {
-		fc.umountResource(fcDriveIndexToID(i))
+		umounted = fc.umountResource(fcDriveIndexToID(i)) && umounted
 	}
 
 	//Run through the list second time as may have bindmounted
 	//to the same location twice. In the future this needs to
 	//be tracked so that we do not do this blindly
 	for i := 0; i < fcDiskPoolSize; i++ {
-		fc.umountResource(fcDriveIndexToID(i))
+		umounted = fc.umountResource(fcDriveIndexToID(i)) && umounted
+	}
+
+	// Removing the jail with resources still mounted would remove
+	// them on the host.
+	if !umounted {
+		fc.Logger().WithField("vm-path", fc.vmPath).Error("Resources still mounted, not cleaning the jail")
+		return
 	}
 
 	fc.Logger().WithField("cleaningJail", fc.vmPath).Info()
@@ -715,12 +855,18 @@ func (fc *firecracker) cleanupJail() {
 	}
 }
 
-// stopSandbox will stop the Sandbox's VM.
+// stopSandbox will stop the Sandbox's VM, and clean its jail up.
 func (fc *firecracker) stopSandbox() (err error) {
 	span, _ := fc.trace("stopSandbox")
 	defer span.Finish()
 
-	return fc.fcEnd()
+	if err = fc.fcEnd(); err != nil {
+		return err
+	}
+
+	fc.cleanupJail()
+
+	return nil
 }
 
 func (fc *firecracker) pauseSandbox() error {
@@ -735,34 +881,39 @@ func (fc *firecracker) resumeSandbox() error {
 	return nil
 }
 
-func (fc *firecracker) fcAddVsock(vs kataVSOCK) error {
+func (fc *firecracker) fcAddVsock(hvs kataHybridVSOCK) error {
 	span, _ := fc.trace("fcAddVsock")
 	defer span.Finish()
 
+	// The unix socket is created by Firecracker, in its jail when jailed.
+	udsPath := hvs.udsPath
+	if fc.jailed {
+		udsPath = filepath.Join("/", fcHybridVSock)
+	}
+
 	vsockParams := ops.NewPutGuestVsockByIDParams()
 	vsockID := "root"
-	ctxID := int64(vs.contextID)
+	// The guest CID is required, but only matters to the guest.
+	ctxID := int64(fcGuestCID)
 	vsock := &models.Vsock{
 		GuestCid: &ctxID,
-		ID:       &vsockID,
+		UdsPath:  &udsPath,
+		VsockID:  &vsockID,
 	}
 	vsockParams.SetID(vsockID)
 	vsockParams.SetBody(vsock)
 	_, _, err := fc.client().Operations.PutGuestVsockByID(vsockParams)
-	if err != nil {
-		return err
-	}
-	//Still racy. There is no way to send an fd to the firecracker
-	//REST API. We could release this just before we start the instance
-	//but even that will not eliminate the race
-	vs.vhostFd.Close()
-	return nil
+	return err
 }
 
 func (fc *firecracker) fcAddNetDevice(endpoint Endpoint) error {
 	span, _ := fc.trace("fcAddNetDevice")
 	defer span.Finish()
 
+	if endpoint.NetworkPair() == nil {
+		return fmt.Errorf("Firecracker does not support %s endpoints", endpoint.Type())
+	}
+
 	cfg := ops.NewPutGuestNetworkInterfaceByIDParams()
 	ifaceID := endpoint.Name()
 	ifaceCfg := &models.NetworkInterface{
@@ -787,6 +938,10 @@ func (fc *firecracker) fcAddBlockDrive(drive config.BlockDrive) error {
 	isReadOnly := false
 	isRootDevice := false
 
+	if err := fc.grantDriveAccess(driveID, drive.File); err != nil {
+		return err
+	}
+
 	jailedDrive, err := fc.fcJailResource(drive.File, driveID)
 	if err != nil {
 		fc.Logger().WithField("fcAddBlockDrive failed", err).Error()
@@ -805,7 +960,7 @@ func (fc *firecracker) fcAddBlockDrive(drive config.BlockDrive) error {
 }
 
 // Firecracker supports replacing the host drive used once the VM has booted up
-func (fc *firecracker) fcUpdateBlockDrive(drive config.BlockDrive) error {
+func (fc *firecracker) fcUpdateBlockDrive(drive config.BlockDrive) (err error) {
 	span, _ := fc.trace("fcUpdateBlockDrive")
 	defer span.Finish()
 
@@ -815,6 +970,15 @@ func (fc *firecracker) fcUpdateBlockDrive(drive config.BlockDrive) error {
 	driveParams := ops.NewPatchGuestDriveByIDParams()
 	driveParams.SetDriveID(driveID)
 
+	if err := fc.grantDriveAccess(driveID, drive.File); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fc.revokeDriveAccess(driveID)
+		}
+	}()
+
 	jailedDrive, err := fc.fcJailResource(drive.File, driveID)
 	if err != nil {
 		fc.Logger().WithField("fcUpdateBlockDrive failed", err).Error()
@@ -874,8 +1038,8 @@ func (fc *firecracker) addDevice(devInfo interface{}, devType deviceType) error
 	case config.BlockDrive:
 		fc.Logger().WithField("device-type-blockdrive", devInfo).Info("Adding device")
 		return fc.fcAddBlockDrive(v)
-	case kataVSOCK:
-		fc.Logger().WithField("device-type-vsock", devInfo).Info("Adding device")
+	case kataHybridVSOCK:
+		fc.Logger().WithField("device-type-hybrid-vsock", devInfo).Info("Adding device")
 		return fc.fcAddVsock(v)
 	default:
 		fc.Logger().WithField("unknown-device-type", devInfo).Error("Adding device")
@@ -891,8 +1055,12 @@ func (fc *firecracker) hotplugAddDevice(devInfo interface{}, devType deviceType)
 
 	switch devType {
 	case blockDev:
+		drive := devInfo.(*config.BlockDrive)
+		if drive.Format != "" && drive.Format != config.BlockFormatRaw {
+			return nil, fmt.Errorf("firecracker only supports raw images, not %s", drive.Format)
+		}
 		//The drive placeholder has to exist prior to Update
-		return nil, fc.fcUpdateBlockDrive(*devInfo.(*config.BlockDrive))
+		return nil, fc.fcUpdateBlockDrive(*drive)
 	default:
 		fc.Logger().WithFields(logrus.Fields{"devInfo": devInfo,
 			"deviceType": devType}).Warn("hotplugAddDevice: unsupported device")
@@ -901,11 +1069,21 @@ func (fc *firecracker) hotplugAddDevice(devInfo interface{}, devType deviceType)
 	}
 }
 
-// hotplugRemoveDevice supported in Firecracker VMM, but no-op
+// hotplugRemoveDevice supported in Firecracker VMM, but no-op. The drive
+// stays in the pool, only the access of the VMM user to its host file is
+// revoked.
 func (fc *firecracker) hotplugRemoveDevice(devInfo interface{}, devType deviceType) (interface{}, error) {
+	if drive, ok := devInfo.(*config.BlockDrive); ok && devType == blockDev {
+		fc.revokeDriveAccess(fcDriveIndexToID(drive.Index))
+	}
+
 	return nil, nil
 }
 
+func (fc *firecracker) setBlockDeviceDriver(driver string) {
+	fc.config.BlockDeviceDriver = driver
+}
+
 // getSandboxConsole builds the path of the console where we can read
 // logs coming from the sandbox.
 //
@@ -934,6 +1112,19 @@ func (fc *firecracker) hypervisorConfig() HypervisorConfig {
 	return fc.config
 }
 
+// generateSocket returns the hybrid vsock of the agent, Firecracker
+// forwards the connections to the unix socket in its jail to the guest.
+func (fc *firecracker) generateSocket(id string, useVsock bool) (interface{}, error) {
+	if !useVsock {
+		return nil, fmt.Errorf("Firecracker only reaches the agent through vsock, use_vsock must be enabled")
+	}
+
+	return kataHybridVSOCK{
+		udsPath: filepath.Join(fc.jailerRoot, fcHybridVSock),
+		port:    vSockPort,
+	}, nil
+}
+
 func (fc *firecracker) resizeMemory(reqMemMB uint32, memoryBlockSizeMB uint32, probe bool) (uint32, memoryDevice, error) {
 	return 0, memoryDevice{}, nil
 }
@@ -980,6 +1171,19 @@ func (fc *firecracker) getThreadIDs() (vcpuThreadIDs, error) {
 	return vcpuInfo, nil
 }
 
+func (fc *firecracker) metrics() (HypervisorMetrics, error) {
+	tids, err := fc.getThreadIDs()
+	if err != nil {
+		return HypervisorMetrics{}, err
+	}
+
+	return processMetrics(fc.info.PID, tids)
+}
+
+func (fc *firecracker) hotplugCapacity() (hotplugCapacity, error) {
+	return hotplugCapacity{}, errors.New("firecracker does not support reserving hotplug capacity")
+}
+
 func (fc *firecracker) cleanup() error {
 	fc.cleanupJail()
 	return nil
@@ -1000,17 +1204,27 @@ func (fc *firecracker) toGrpc() ([]byte, error) {
 func (fc *firecracker) save() (s persistapi.HypervisorState) {
 	s.Pid = fc.info.PID
 	s.Type = string(FirecrackerHypervisor)
+	s.DriveGrants = fc.info.DriveGrants
 	return
 }
 
 func (fc *firecracker) load(s persistapi.HypervisorState) {
 	fc.info.PID = s.Pid
+	fc.info.DriveGrants = s.DriveGrants
 }
 
-func (fc *firecracker) check() error {
+func (fc *firecracker) check(agentCheck func() error) error {
 	if err := syscall.Kill(fc.info.PID, syscall.Signal(0)); err != nil {
-		return errors.Wrapf(err, "failed to ping fc process")
+		return errors.Wrapf(ErrVMMDead, "failed to ping fc process: %v", err)
 	}
 
-	return nil
+	return checkAgent(agentCheck)
+}
+
+func (fc *firecracker) migrateSandbox(config MigrationConfig) error {
+	return errors.Wrap(vcTypes.ErrNotSupported, "firecracker live migration")
+}
+
+func (fc *firecracker) receiveMigration(config MigrationConfig) error {
+	return errors.Wrap(vcTypes.ErrNotSupported, "firecracker live migration")
 }
@@ -0,0 +1,97 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFCCreateSandboxJailer(t *testing.T) {
+	assert := assert.New(t)
+
+	savedLookup := lookupVMMUserFn
+	defer func() {
+		lookupVMMUserFn = savedLookup
+	}()
+
+	lookupVMMUserFn = func(name string) (*user.User, error) {
+		return &user.User{Username: name, Uid: "1001", Gid: "1002"}, nil
+	}
+
+	config := HypervisorConfig{
+		HypervisorPath: "/usr/bin/firecracker",
+		JailerPath:     "/usr/bin/jailer",
+	}
+	networkNS := NetworkNamespace{NetNsPath: "/var/run/netns/cni-1234"}
+
+	fc := &firecracker{}
+	assert.NoError(fc.createSandbox(context.Background(), "sandbox", networkNS, &config, nil))
+	assert.Equal(fcChrootBaseDir, fc.chrootBaseDir)
+	assert.Equal("/var/lib/vc/firecracker/sandbox/root", fc.jailerRoot)
+	assert.Equal("0", fc.uid)
+	assert.Nil(fc.vmmUser)
+
+	config.JailerChrootBase = "/srv/jailer/"
+	config.VMMUser = "kata-vmm"
+	fc = &firecracker{}
+	assert.NoError(fc.createSandbox(context.Background(), "sandbox", networkNS, &config, nil))
+	assert.Equal("/srv/jailer/firecracker/sandbox", fc.vmPath)
+	assert.Equal("/srv/jailer/firecracker/sandbox/root/api.socket", fc.socketPath)
+
+	assert.Equal([]string{
+		"--id", "sandbox",
+		"--node", "0",
+		"--seccomp-level", "2",
+		"--exec-file", "/usr/bin/firecracker",
+		"--uid", "1001",
+		"--gid", "1002",
+		"--chroot-base-dir", "/srv/jailer",
+		"--daemonize",
+		"--netns", "/var/run/netns/cni-1234",
+	}, fc.jailerArgs())
+
+	config.JailerChrootBase = "jailer"
+	fc = &firecracker{}
+	assert.Error(fc.createSandbox(context.Background(), "sandbox", networkNS, &config, nil))
+
+	// The jailer drops the privileges of firecracker.
+	config.JailerChrootBase = ""
+	config.JailerPath = ""
+	fc = &firecracker{}
+	assert.Error(fc.createSandbox(context.Background(), "sandbox", networkNS, &config, nil))
+}
+
+func TestFCStopSandboxCleansJail(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fc-jail")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fc := &firecracker{
+		ctx:        context.Background(),
+		vmPath:     filepath.Join(dir, "firecracker", "sandbox"),
+		jailerRoot: filepath.Join(dir, "firecracker", "sandbox", "root"),
+	}
+	assert.NoError(os.MkdirAll(fc.jailerRoot, 0750))
+	assert.NoError(ioutil.WriteFile(filepath.Join(fc.jailerRoot, fcSocket), nil, 0600))
+
+	// the VM is not running anymore
+	assert.NoError(fc.stopSandbox())
+
+	_, err = os.Stat(fc.vmPath)
+	assert.True(os.IsNotExist(err))
+
+	// cleaning the jail up again is harmless
+	assert.NoError(fc.cleanup())
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/pkg/errors"
+)
+
+func (op operation) String() string {
+	if op == removeDevice {
+		return "remove"
+	}
+
+	return "add"
+}
+
+var deviceTypeNames = map[deviceType]string{
+	imgDev:        "image",
+	fsDev:         "filesystem",
+	netDev:        "network",
+	blockDev:      "block",
+	serialPortDev: "serial port",
+	vSockPCIDev:   "vsock",
+	vfioDev:       "VFIO",
+	vhostuserDev:  "vhost-user",
+	cpuDev:        "CPU",
+	memoryDev:     "memory",
+}
+
+func (t deviceType) String() string {
+	if name, ok := deviceTypeNames[t]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// hotplugDeviceID returns the ID of a device hotplugged, if it has one.
+func hotplugDeviceID(devInfo interface{}) string {
+	switch d := devInfo.(type) {
+	case *config.BlockDrive:
+		return d.ID
+	case *config.VFIODev:
+		return d.ID
+	case Endpoint:
+		return d.Name()
+	}
+
+	return ""
+}
+
+// hotplugErrorKind classifies the cause of a hotplug failure. Only the
+// timeouts of the hypervisor and the errors known to be transient are
+// ErrHotplugFailed, the other unknown failures are not classified.
+func hotplugErrorKind(cause error) error {
+	switch cause {
+	case vcTypes.ErrResourceExhausted, vcTypes.ErrNotSupported, vcTypes.ErrHotplugFailed:
+		return cause
+	case context.DeadlineExceeded, syscall.EAGAIN, syscall.EBUSY, syscall.EINTR:
+		return vcTypes.ErrHotplugFailed
+	}
+
+	return nil
+}
+
+// newHotplugError returns the HotplugError of the device, classified by the
+// cause of err.
+func newHotplugError(op operation, devType deviceType, devInfo interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*vcTypes.HotplugError); ok {
+		return err
+	}
+
+	kind := hotplugErrorKind(errors.Cause(err))
+
+	return &vcTypes.HotplugError{
+		Op:     op.String(),
+		Device: devType.String(),
+		ID:     hotplugDeviceID(devInfo),
+		Kind:   kind,
+		Err:    err,
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHotplugError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(newHotplugError(addDevice, memoryDev, nil, nil))
+
+	drive := &config.BlockDrive{ID: "drive-1"}
+	cause := errors.New("device_del failed")
+	err := newHotplugError(removeDevice, blockDev, drive, cause)
+	herr, ok := err.(*vcTypes.HotplugError)
+	assert.True(ok)
+	assert.Equal("remove", herr.Op)
+	assert.Equal("block", herr.Device)
+	assert.Equal("drive-1", herr.ID)
+	// the unknown failures are not classified, nor hidden
+	assert.Nil(vcTypes.HotplugErrorKind(err))
+	assert.Equal(cause, errors.Cause(err))
+	assert.Equal("failed to remove block device drive-1: device_del failed", err.Error())
+
+	err = newHotplugError(addDevice, blockDev, drive, errors.Wrap(context.DeadlineExceeded, "device_add"))
+	assert.Equal(vcTypes.ErrHotplugFailed, vcTypes.HotplugErrorKind(errors.Wrap(err, "attaching devices")))
+	assert.Equal(context.DeadlineExceeded, errors.Cause(err))
+
+	err = newHotplugError(addDevice, blockDev, drive, syscall.EBUSY)
+	assert.Equal(vcTypes.ErrHotplugFailed, vcTypes.HotplugErrorKind(err))
+
+	err = newHotplugError(addDevice, memoryDev, &memoryDevice{}, errors.Wrap(vcTypes.ErrResourceExhausted, "Unable to hotplug 1024 MiB memory"))
+	assert.Equal(vcTypes.ErrResourceExhausted, vcTypes.HotplugErrorKind(err))
+	assert.Equal(vcTypes.ErrResourceExhausted, errors.Cause(err))
+	assert.Equal("failed to add memory device: Unable to hotplug 1024 MiB memory: resource exhausted", err.Error())
+
+	// the errors already classified are kept
+	assert.Equal(err, newHotplugError(addDevice, cpuDev, nil, err))
+
+	err = newHotplugError(addDevice, cpuDev, uint32(1), errors.Wrap(vcTypes.ErrNotSupported, "guest vCPU hotplug"))
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(errors.Wrap(err, "resizing vCPUs")))
+}
+
+func TestQemuHotplugErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		ctx: context.Background(),
+	}
+
+	_, err := q.hotplugAddDevice(nil, serialPortDev)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+	assert.Contains(err.Error(), "serial port")
+}
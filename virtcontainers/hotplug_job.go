@@ -0,0 +1,199 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HotplugJobState is the state of a hotplug job.
+type HotplugJobState string
+
+const (
+	// HotplugJobRunning is the state of the jobs in progress.
+	HotplugJobRunning HotplugJobState = "running"
+
+	// HotplugJobCompleted is the state of the jobs which applied all
+	// their steps.
+	HotplugJobCompleted HotplugJobState = "completed"
+
+	// HotplugJobFailed is the state of the jobs which failed.
+	HotplugJobFailed HotplugJobState = "failed"
+
+	// HotplugJobCancelled is the state of the jobs which have been
+	// cancelled before applying all their steps.
+	HotplugJobCancelled HotplugJobState = "cancelled"
+)
+
+// maxFinishedHotplugJobs is the number of finished jobs kept for querying.
+const maxFinishedHotplugJobs = 16
+
+// ErrHotplugJobCancelled is returned by the operations whose hotplug job
+// has been cancelled.
+var ErrHotplugJobCancelled = errors.New("hotplug job cancelled")
+
+// HotplugJob describes the progress of a sequence of hotplug operations,
+// such as attaching the devices of a container or resizing the VM. The
+// operations stay synchronous: the call which started the job returns once
+// it is finished, the job letting the management layers follow and cancel
+// it meanwhile.
+type HotplugJob struct {
+	ID       string          `json:"id"`
+	Kind     string          `json:"kind"`
+	Target   string          `json:"target,omitempty"`
+	State    HotplugJobState `json:"state"`
+	Steps    int             `json:"steps"`
+	Done     int             `json:"done"`
+	Error    string          `json:"error,omitempty"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+}
+
+// hotplugJob is a hotplug job in progress. Its steps are applied
+// synchronously by the operation which started it, and cancellation takes
+// effect between two steps. The operation rolls back the steps applied
+// before finishing the job.
+type hotplugJob struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	registry *hotplugJobRegistry
+	info     HotplugJob
+}
+
+// hotplugJobRegistry records the hotplug jobs of a sandbox. Its zero value
+// is ready to use.
+type hotplugJobRegistry struct {
+	sync.Mutex
+
+	next     int
+	running  map[string]*hotplugJob
+	finished []HotplugJob
+}
+
+// start registers a new job of steps steps.
+func (r *hotplugJobRegistry) start(kind, target string, steps int) *hotplugJob {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.running == nil {
+		r.running = make(map[string]*hotplugJob)
+	}
+
+	r.next++
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &hotplugJob{
+		ctx:      ctx,
+		cancel:   cancel,
+		registry: r,
+		info: HotplugJob{
+			ID:      fmt.Sprintf("%s-%d", kind, r.next),
+			Kind:    kind,
+			Target:  target,
+			State:   HotplugJobRunning,
+			Steps:   steps,
+			Started: time.Now(),
+		},
+	}
+
+	r.running[job.info.ID] = job
+
+	return job
+}
+
+// list returns the running jobs and the last finished ones.
+func (r *hotplugJobRegistry) list() []HotplugJob {
+	r.Lock()
+	defer r.Unlock()
+
+	jobs := make([]HotplugJob, 0, len(r.finished)+len(r.running))
+	jobs = append(jobs, r.finished...)
+	for _, job := range r.running {
+		jobs = append(jobs, job.info)
+	}
+
+	return jobs
+}
+
+// cancel cancels the running job id.
+func (r *hotplugJobRegistry) cancel(id string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	job, ok := r.running[id]
+	if !ok {
+		return fmt.Errorf("No running hotplug job %s", id)
+	}
+
+	job.cancel()
+
+	return nil
+}
+
+// step returns ErrHotplugJobCancelled if the job has been cancelled, so that
+// the next step is not applied.
+func (j *hotplugJob) step() error {
+	select {
+	case <-j.ctx.Done():
+		return ErrHotplugJobCancelled
+	default:
+		return nil
+	}
+}
+
+// stepDone records the progress of the job.
+func (j *hotplugJob) stepDone() {
+	j.registry.Lock()
+	defer j.registry.Unlock()
+
+	j.info.Done++
+}
+
+// finish records the end of the job, err being the error of the operation.
+func (j *hotplugJob) finish(err error) {
+	r := j.registry
+
+	r.Lock()
+	defer r.Unlock()
+
+	switch {
+	case err == nil:
+		j.info.State = HotplugJobCompleted
+	case err == ErrHotplugJobCancelled:
+		j.info.State = HotplugJobCancelled
+	default:
+		j.info.State = HotplugJobFailed
+		j.info.Error = err.Error()
+	}
+	j.info.Finished = time.Now()
+	j.cancel()
+
+	delete(r.running, j.info.ID)
+
+	r.finished = append(r.finished, j.info)
+	if len(r.finished) > maxFinishedHotplugJobs {
+		r.finished = r.finished[len(r.finished)-maxFinishedHotplugJobs:]
+	}
+}
+
+// HotplugJobs returns the running hotplug jobs of the sandbox, and the last
+// finished ones.
+func (s *Sandbox) HotplugJobs() []HotplugJob {
+	return s.hotplugJobs.list()
+}
+
+// CancelHotplugJob cancels a running hotplug job of the sandbox, without
+// waiting for it. The operation which started the job stops before its next
+// step, rolls back the steps already applied, and fails with
+// ErrHotplugJobCancelled once the job is reported cancelled.
+func (s *Sandbox) CancelHotplugJob(id string) error {
+	return s.hotplugJobs.cancel(id)
+}
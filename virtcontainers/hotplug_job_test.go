@@ -0,0 +1,83 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotplugJobProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+	assert.Empty(s.HotplugJobs())
+
+	job := s.hotplugJobs.start("attach-devices", "ctr", 2)
+	assert.NoError(job.step())
+	job.stepDone()
+
+	jobs := s.HotplugJobs()
+	assert.Len(jobs, 1)
+	assert.Equal("attach-devices-1", jobs[0].ID)
+	assert.Equal("ctr", jobs[0].Target)
+	assert.Equal(HotplugJobRunning, jobs[0].State)
+	assert.Equal(2, jobs[0].Steps)
+	assert.Equal(1, jobs[0].Done)
+
+	job.stepDone()
+	job.finish(nil)
+
+	jobs = s.HotplugJobs()
+	assert.Len(jobs, 1)
+	assert.Equal(HotplugJobCompleted, jobs[0].State)
+	assert.Equal(2, jobs[0].Done)
+	assert.False(jobs[0].Finished.IsZero())
+
+	// finished jobs cannot be cancelled
+	assert.Error(s.CancelHotplugJob(jobs[0].ID))
+
+	job = s.hotplugJobs.start("update-resources", "sb", 2)
+	job.finish(errors.New("hotplug failure"))
+	jobs = s.HotplugJobs()
+	assert.Equal(HotplugJobFailed, jobs[1].State)
+	assert.Equal("hotplug failure", jobs[1].Error)
+}
+
+func TestHotplugJobCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+	job := s.hotplugJobs.start("attach-devices", "ctr", 3)
+
+	assert.Error(s.CancelHotplugJob("foo"))
+	assert.NoError(s.CancelHotplugJob(job.info.ID))
+
+	err := job.step()
+	assert.Equal(ErrHotplugJobCancelled, err)
+	job.finish(err)
+
+	jobs := s.HotplugJobs()
+	assert.Len(jobs, 1)
+	assert.Equal(HotplugJobCancelled, jobs[0].State)
+	assert.Empty(jobs[0].Error)
+}
+
+func TestHotplugJobFinishedLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+	for i := 0; i < maxFinishedHotplugJobs+4; i++ {
+		s.hotplugJobs.start("attach-devices", "ctr", 0).finish(nil)
+	}
+
+	jobs := s.HotplugJobs()
+	assert.Len(jobs, maxFinishedHotplugJobs)
+	assert.Equal(fmt.Sprintf("attach-devices-%d", maxFinishedHotplugJobs+4), jobs[len(jobs)-1].ID)
+}
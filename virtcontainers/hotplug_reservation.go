@@ -0,0 +1,277 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultHotplugReservationTTL is how long a hotplug reservation is
+	// held when its owner does not ask for a duration.
+	DefaultHotplugReservationTTL = 5 * time.Minute
+
+	// MaxHotplugReservationTTL is the longest a hotplug reservation can be
+	// held, so that the capacity reserved by a crashed owner is reclaimed.
+	MaxHotplugReservationTTL = time.Hour
+)
+
+// ErrHotplugCapacity is returned when the hotplug capacity of a sandbox is
+// exhausted or reserved for pending hotplugs.
+var ErrHotplugCapacity = errors.New("not enough hotplug capacity")
+
+// hotplugCapacity is the capacity of a VM for hotplugged devices and
+// memory.
+type hotplugCapacity struct {
+	// bridgeSlots is the number of free slots on the bridges.
+	bridgeSlots uint32
+
+	// memorySlots is the number of free memory slots, each memory hot
+	// add consuming one of them.
+	memorySlots uint32
+
+	// memoryMB is the current memory of the VM, the memory slots are
+	// only consumed to grow it.
+	memoryMB uint32
+}
+
+// HotplugReservation holds part of the hotplug capacity of a sandbox for
+// a device attach in preparation, e.g. by a device plugin, so that the
+// hotplugs of the runtime do not consume it first. The container whose
+// devices use the reservation references it by annotation.
+//
+// The ID is a random token, only known by the owner the reservation is
+// bound to, which alone can list and release it.
+type HotplugReservation struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner,omitempty"`
+	BridgeSlots uint32    `json:"bridge_slots"`
+	MemorySlots uint32    `json:"memory_slots"`
+	Expires     time.Time `json:"expires"`
+}
+
+// hotplugReservationRegistry records the hotplug reservations of a
+// sandbox. Its zero value is ready to use.
+type hotplugReservationRegistry struct {
+	sync.Mutex
+
+	reservations map[string]HotplugReservation
+}
+
+// prune drops the expired reservations. It must be called with the
+// registry locked.
+func (r *hotplugReservationRegistry) prune(now time.Time) {
+	for id, res := range r.reservations {
+		if !now.Before(res.Expires) {
+			delete(r.reservations, id)
+		}
+	}
+}
+
+// reserved returns the bridge slots and the memory slots held by the
+// reservations.
+func (r *hotplugReservationRegistry) reserved() (bridgeSlots, memorySlots uint32) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(time.Now())
+
+	for _, res := range r.reservations {
+		bridgeSlots += res.BridgeSlots
+		memorySlots += res.MemorySlots
+	}
+
+	return bridgeSlots, memorySlots
+}
+
+// add records res if capacity leaves enough room for it along with the
+// other reservations, and returns it with its ID.
+func (r *hotplugReservationRegistry) add(res HotplugReservation, capacity hotplugCapacity) (HotplugReservation, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(time.Now())
+
+	var bridgeSlots, memorySlots uint32
+	for _, other := range r.reservations {
+		bridgeSlots += other.BridgeSlots
+		memorySlots += other.MemorySlots
+	}
+
+	if bridgeSlots+res.BridgeSlots > capacity.bridgeSlots {
+		return HotplugReservation{}, errors.Wrapf(ErrHotplugCapacity, "%d bridge slots requested, %d free and %d reserved",
+			res.BridgeSlots, capacity.bridgeSlots, bridgeSlots)
+	}
+
+	if memorySlots+res.MemorySlots > capacity.memorySlots {
+		return HotplugReservation{}, errors.Wrapf(ErrHotplugCapacity, "%d memory slots requested, %d free and %d reserved",
+			res.MemorySlots, capacity.memorySlots, memorySlots)
+	}
+
+	if r.reservations == nil {
+		r.reservations = make(map[string]HotplugReservation)
+	}
+
+	token, err := utils.GenerateRandomBytes(16)
+	if err != nil {
+		return HotplugReservation{}, err
+	}
+
+	res.ID = "reservation-" + hex.EncodeToString(token)
+	r.reservations[res.ID] = res
+
+	return res, nil
+}
+
+// release drops the reservation id of owner. The reservations of the
+// other owners are not told apart from the missing ones.
+func (r *hotplugReservationRegistry) release(id, owner string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(time.Now())
+
+	if res, ok := r.reservations[id]; !ok || res.Owner != owner {
+		return fmt.Errorf("No hotplug reservation %s", id)
+	}
+
+	delete(r.reservations, id)
+
+	return nil
+}
+
+// claim drops the reservation id, whatever its owner, as the token is
+// the proof of the reservation.
+func (r *hotplugReservationRegistry) claim(id string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(time.Now())
+
+	if _, ok := r.reservations[id]; !ok {
+		return fmt.Errorf("No hotplug reservation %s", id)
+	}
+
+	delete(r.reservations, id)
+
+	return nil
+}
+
+// list returns the reservations of owner which have not expired.
+func (r *hotplugReservationRegistry) list(owner string) []HotplugReservation {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(time.Now())
+
+	reservations := []HotplugReservation{}
+	for _, res := range r.reservations {
+		if res.Owner == owner {
+			reservations = append(reservations, res)
+		}
+	}
+
+	return reservations
+}
+
+// ReserveHotplug reserves bridgeSlots bridge slots and memorySlots memory
+// slots of the sandbox for ttl, on behalf of owner, the identity of the
+// caller as authenticated by the runtime. It fails with
+// ErrHotplugCapacity if the capacity left by the other reservations is not
+// enough.
+func (s *Sandbox) ReserveHotplug(owner string, bridgeSlots, memorySlots uint32, ttl time.Duration) (HotplugReservation, error) {
+	if owner == "" {
+		return HotplugReservation{}, fmt.Errorf("Hotplug reservation without owner")
+	}
+
+	if bridgeSlots == 0 && memorySlots == 0 {
+		return HotplugReservation{}, fmt.Errorf("Empty hotplug reservation")
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultHotplugReservationTTL
+	}
+	if ttl > MaxHotplugReservationTTL {
+		return HotplugReservation{}, fmt.Errorf("Hotplug reservation of %v longer than %v", ttl, MaxHotplugReservationTTL)
+	}
+
+	capacity, err := s.hypervisor.hotplugCapacity()
+	if err != nil {
+		return HotplugReservation{}, err
+	}
+
+	res, err := s.hotplugReservations.add(HotplugReservation{
+		Owner:       owner,
+		BridgeSlots: bridgeSlots,
+		MemorySlots: memorySlots,
+		Expires:     time.Now().Add(ttl),
+	}, capacity)
+	if err != nil {
+		return res, err
+	}
+
+	s.Logger().WithField("reservation", res).Info("Hotplug capacity reserved")
+
+	return res, nil
+}
+
+// ReleaseHotplugReservation releases the reservation id of owner before
+// it expires.
+func (s *Sandbox) ReleaseHotplugReservation(id, owner string) error {
+	return s.hotplugReservations.release(id, owner)
+}
+
+// HotplugReservations returns the hotplug reservations of owner in the
+// sandbox.
+func (s *Sandbox) HotplugReservations(owner string) []HotplugReservation {
+	return s.hotplugReservations.list(owner)
+}
+
+// claimHotplugReservation releases the reservation id made for the
+// devices of a container, before they are attached.
+func (s *Sandbox) claimHotplugReservation(id string) {
+	if id == "" {
+		return
+	}
+
+	if err := s.hotplugReservations.claim(id); err != nil {
+		s.Logger().WithError(err).Warn("Attaching the devices without their hotplug reservation")
+		return
+	}
+
+	s.Logger().WithField("reservation", id).Info("Hotplug reservation claimed")
+}
+
+// checkHotplugCapacity fails with ErrHotplugCapacity if hotplugging
+// bridgeSlots devices, and growing the memory to memoryMB, would consume
+// the capacity held by the reservations.
+func (s *Sandbox) checkHotplugCapacity(bridgeSlots uint32, memoryMB uint32) error {
+	reservedBridgeSlots, reservedMemorySlots := s.hotplugReservations.reserved()
+	if reservedBridgeSlots == 0 && reservedMemorySlots == 0 {
+		return nil
+	}
+
+	capacity, err := s.hypervisor.hotplugCapacity()
+	if err != nil {
+		return err
+	}
+
+	if reservedBridgeSlots > 0 && bridgeSlots > 0 && capacity.bridgeSlots < reservedBridgeSlots+bridgeSlots {
+		return errors.Wrapf(ErrHotplugCapacity, "%d of the %d free bridge slots are reserved", reservedBridgeSlots, capacity.bridgeSlots)
+	}
+
+	if reservedMemorySlots > 0 && memoryMB > capacity.memoryMB && capacity.memorySlots <= reservedMemorySlots {
+		return errors.Wrapf(ErrHotplugCapacity, "%d of the %d free memory slots are reserved", reservedMemorySlots, capacity.memorySlots)
+	}
+
+	return nil
+}
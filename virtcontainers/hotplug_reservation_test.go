@@ -0,0 +1,98 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReserveHotplug(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &mockHypervisor{
+		capacity: hotplugCapacity{bridgeSlots: 3, memorySlots: 1},
+	}
+	s := &Sandbox{hypervisor: h}
+	assert.Empty(s.HotplugReservations("plugin"))
+
+	_, err := s.ReserveHotplug("", 1, 0, 0)
+	assert.Error(err)
+
+	_, err = s.ReserveHotplug("plugin", 0, 0, 0)
+	assert.Error(err)
+
+	_, err = s.ReserveHotplug("plugin", 1, 0, 2*MaxHotplugReservationTTL)
+	assert.Error(err)
+
+	res, err := s.ReserveHotplug("plugin", 2, 1, 0)
+	assert.NoError(err)
+	assert.Regexp("^reservation-[0-9a-f]{32}$", res.ID)
+	assert.Equal("plugin", res.Owner)
+	assert.True(res.Expires.After(time.Now().Add(DefaultHotplugReservationTTL - time.Minute)))
+
+	// the capacity left by the first reservation is not enough
+	_, err = s.ReserveHotplug("plugin", 2, 0, 0)
+	assert.Equal(ErrHotplugCapacity, errors.Cause(err))
+	_, err = s.ReserveHotplug("plugin", 0, 1, 0)
+	assert.Equal(ErrHotplugCapacity, errors.Cause(err))
+
+	other, err := s.ReserveHotplug("other", 1, 0, time.Minute)
+	assert.NoError(err)
+	assert.NotEqual(res.ID, other.ID)
+	assert.Len(s.HotplugReservations("plugin"), 1)
+	assert.Equal([]HotplugReservation{other}, s.HotplugReservations("other"))
+
+	// only the owner releases its reservations
+	assert.Error(s.ReleaseHotplugReservation(res.ID, "other"))
+	assert.NoError(s.ReleaseHotplugReservation(res.ID, "plugin"))
+	assert.Error(s.ReleaseHotplugReservation(res.ID, "plugin"))
+	assert.Empty(s.HotplugReservations("plugin"))
+	assert.Len(s.HotplugReservations("other"), 1)
+
+	// expired reservations release their capacity
+	for id, res := range s.hotplugReservations.reservations {
+		res.Expires = time.Now()
+		s.hotplugReservations.reservations[id] = res
+	}
+	assert.Empty(s.HotplugReservations("other"))
+
+	_, err = s.ReserveHotplug("plugin", 3, 1, 0)
+	assert.NoError(err)
+}
+
+func TestCheckHotplugCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	h := &mockHypervisor{
+		capacity: hotplugCapacity{bridgeSlots: 2, memorySlots: 1, memoryMB: 2048},
+	}
+	s := &Sandbox{hypervisor: h}
+
+	// nothing is checked without reservations
+	assert.NoError(s.checkHotplugCapacity(10, 8192))
+
+	res, err := s.ReserveHotplug("plugin", 1, 1, 0)
+	assert.NoError(err)
+
+	assert.NoError(s.checkHotplugCapacity(1, 0))
+	assert.Equal(ErrHotplugCapacity, errors.Cause(s.checkHotplugCapacity(2, 0)))
+
+	// the memory slots are only consumed to grow the memory
+	assert.NoError(s.checkHotplugCapacity(0, 2048))
+	assert.Equal(ErrHotplugCapacity, errors.Cause(s.checkHotplugCapacity(0, 4096)))
+
+	// the container the reservation was made for gets its capacity
+	s.claimHotplugReservation(res.ID)
+	assert.Empty(s.HotplugReservations("plugin"))
+	assert.NoError(s.checkHotplugCapacity(2, 4096))
+
+	// claiming an expired reservation is harmless
+	s.claimHotplugReservation(res.ID)
+}
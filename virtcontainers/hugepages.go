@@ -0,0 +1,183 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultHugePagesPath is the hugetlbfs mount used when neither a mount nor
+// a page size are configured.
+const defaultHugePagesPath = "/dev/hugepages"
+
+var (
+	procMounts  = "/proc/mounts"
+	procMeminfo = "/proc/meminfo"
+)
+
+// ParseHugePageSize parses a huge page size, e.g. "2M" or "1G", and returns
+// it in KiB.
+func ParseHugePageSize(size string) (uint32, error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		return 0, fmt.Errorf("Empty huge page size")
+	}
+
+	var shift uint
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		shift = 0
+	case "M":
+		shift = 10
+	case "G":
+		shift = 20
+	default:
+		return 0, fmt.Errorf("Invalid huge page size %q, expecting a K, M or G suffix", size)
+	}
+
+	n, err := strconv.ParseUint(size[:len(size)-1], 10, 32)
+	if err != nil || n == 0 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("Invalid huge page size %q, expecting a power of two", size)
+	}
+
+	kb := n << shift
+	if kb > uint64(^uint32(0)) {
+		return 0, fmt.Errorf("Invalid huge page size %q, too large", size)
+	}
+
+	return uint32(kb), nil
+}
+
+// hugetlbfsMount is a hugetlbfs mount point and the size of its pages.
+type hugetlbfsMount struct {
+	path       string
+	pageSizeKB uint32
+}
+
+// defaultHugePageSizeKB returns the default huge page size of the host.
+func defaultHugePageSizeKB() (uint32, error) {
+	f, err := os.Open(procMeminfo)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "Hugepagesize:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid default huge page size %q: %v", fields[1], err)
+			}
+			return uint32(kb), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("Could not find the default huge page size")
+}
+
+// hugetlbfsMounts returns the hugetlbfs mount points of the host.
+func hugetlbfsMounts() ([]hugetlbfsMount, error) {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []hugetlbfsMount
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "hugetlbfs" {
+			continue
+		}
+
+		mount := hugetlbfsMount{path: fields[1]}
+
+		for _, opt := range strings.Split(fields[3], ",") {
+			if strings.HasPrefix(opt, "pagesize=") {
+				kb, err := ParseHugePageSize(strings.TrimPrefix(opt, "pagesize="))
+				if err != nil {
+					return nil, err
+				}
+				mount.pageSizeKB = kb
+			}
+		}
+
+		if mount.pageSizeKB == 0 {
+			kb, err := defaultHugePageSizeKB()
+			if err != nil {
+				return nil, err
+			}
+			mount.pageSizeKB = kb
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// resolveHugePages returns the hugetlbfs mount backing the guest memory and
+// the size of its pages in KiB. path is the configured mount, if any, and
+// pageSizeKB the configured page size, if any. When only the page size is
+// configured, the first mount providing pages of that size is picked.
+func resolveHugePages(path string, pageSizeKB uint32) (string, uint32, error) {
+	mounts, err := hugetlbfsMounts()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if path == "" && pageSizeKB == 0 {
+		path = defaultHugePagesPath
+	}
+
+	for _, m := range mounts {
+		if path != "" && filepath.Clean(path) != filepath.Clean(m.path) {
+			continue
+		}
+
+		if pageSizeKB != 0 && m.pageSizeKB != pageSizeKB {
+			if path != "" {
+				return "", 0, fmt.Errorf("Huge pages mount %s provides %d KiB pages, not %d KiB ones", path, m.pageSizeKB, pageSizeKB)
+			}
+			continue
+		}
+
+		return m.path, m.pageSizeKB, nil
+	}
+
+	if path != "" {
+		return "", 0, fmt.Errorf("%s is not a hugetlbfs mount", path)
+	}
+
+	return "", 0, fmt.Errorf("No hugetlbfs mount provides %d KiB pages", pageSizeKB)
+}
+
+// hugePageAlignMB returns the alignment in MiB of the memory backed by
+// pageSizeKB pages.
+func hugePageAlignMB(pageSizeKB uint32) uint32 {
+	if pageSizeKB < 1024 {
+		return 1
+	}
+
+	return pageSizeKB / 1024
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHugePageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	for size, kb := range map[string]uint32{
+		"2M":     2048,
+		"2m":     2048,
+		" 1G ":   1024 * 1024,
+		"512K":   512,
+		"16384k": 16384,
+	} {
+		v, err := ParseHugePageSize(size)
+		assert.NoError(err, "size %q", size)
+		assert.Equal(kb, v, "size %q", size)
+	}
+
+	for _, size := range []string{"", "2", "3M", "0M", "-2M", "M", "2T", "8192G"} {
+		_, err := ParseHugePageSize(size)
+		assert.Error(err, "size %q", size)
+	}
+}
+
+func setupHugePagesTest(t *testing.T, mounts, meminfo string) func() {
+	dir, err := ioutil.TempDir("", "hugepages")
+	assert.NoError(t, err)
+
+	savedMounts, savedMeminfo := procMounts, procMeminfo
+	procMounts = filepath.Join(dir, "mounts")
+	procMeminfo = filepath.Join(dir, "meminfo")
+
+	assert.NoError(t, ioutil.WriteFile(procMounts, []byte(mounts), 0644))
+	assert.NoError(t, ioutil.WriteFile(procMeminfo, []byte(meminfo), 0644))
+
+	return func() {
+		procMounts, procMeminfo = savedMounts, savedMeminfo
+		os.RemoveAll(dir)
+	}
+}
+
+func TestResolveHugePages(t *testing.T) {
+	assert := assert.New(t)
+
+	cleanup := setupHugePagesTest(t,
+		"proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n"+
+			"hugetlbfs /dev/hugepages hugetlbfs rw,relatime 0 0\n"+
+			"hugetlbfs /dev/hugepages-1G hugetlbfs rw,relatime,pagesize=1024M 0 0\n"+
+			"hugetlbfs /run/kata/pool hugetlbfs rw,relatime,pagesize=2M 0 0\n",
+		"MemTotal:       16318304 kB\n"+
+			"Hugepagesize:       2048 kB\n")
+	defer cleanup()
+
+	type testData struct {
+		path       string
+		pageSizeKB uint32
+		expected   string
+		expectedKB uint32
+		expectErr  bool
+	}
+
+	for i, d := range []testData{
+		{"", 0, "/dev/hugepages", 2048, false},
+		{"", 2048, "/dev/hugepages", 2048, false},
+		{"", 1024 * 1024, "/dev/hugepages-1G", 1024 * 1024, false},
+		{"/run/kata/pool/", 0, "/run/kata/pool", 2048, false},
+		{"/run/kata/pool", 2048, "/run/kata/pool", 2048, false},
+		{"/run/kata/pool", 1024 * 1024, "", 0, true},
+		{"/proc", 0, "", 0, true},
+		{"", 16 * 1024 * 1024, "", 0, true},
+	} {
+		path, kb, err := resolveHugePages(d.path, d.pageSizeKB)
+		if d.expectErr {
+			assert.Error(err, "test %d", i)
+			continue
+		}
+
+		assert.NoError(err, "test %d", i)
+		assert.Equal(d.expected, path, "test %d", i)
+		assert.Equal(d.expectedKB, kb, "test %d", i)
+	}
+}
+
+func TestHugePageAlignMB(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint32(1), hugePageAlignMB(64))
+	assert.Equal(uint32(2), hugePageAlignMB(2048))
+	assert.Equal(uint32(1024), hugePageAlignMB(1024*1024))
+
+	q := &qemu{}
+	assert.Equal(uint32(128), q.memoryAlignMB(128))
+
+	q.hugePageSizeKB = 2048
+	assert.Equal(uint32(128), q.memoryAlignMB(128))
+	assert.Equal(uint32(2), q.memoryAlignMB(0))
+
+	q.hugePageSizeKB = 1024 * 1024
+	assert.Equal(uint32(1024), q.memoryAlignMB(128))
+}
+
+func TestQemuCreateSandboxHugePages(t *testing.T) {
+	assert := assert.New(t)
+
+	cleanup := setupHugePagesTest(t,
+		"hugetlbfs /dev/hugepages hugetlbfs rw,relatime 0 0\n"+
+			"hugetlbfs /run/kata/pool hugetlbfs rw,relatime,pagesize=2M 0 0\n",
+		"Hugepagesize:       2048 kB\n")
+	defer cleanup()
+
+	qemuConfig := newQemuConfig()
+	qemuConfig.HugePages = true
+	qemuConfig.HugePagesPath = "/run/kata/pool"
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		id:  "testSandbox",
+		config: &SandboxConfig{
+			HypervisorConfig: qemuConfig,
+		},
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	sandbox.store = vcStore
+
+	testQemuPath := filepath.Join(testDir, testHypervisor)
+	_, err = os.Create(testQemuPath)
+	assert.NoError(err)
+
+	parentDir := store.SandboxConfigurationRootPath(sandbox.id)
+	assert.NoError(os.MkdirAll(parentDir, store.DirMode))
+	defer os.RemoveAll(parentDir)
+
+	// The guest memory is backed by the files of the selected mount,
+	// rather than by /dev/hugepages.
+	q := &qemu{}
+	err = q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, sandbox.store)
+	assert.NoError(err)
+	assert.False(q.qemuConfig.Knobs.HugePages)
+	assert.True(q.qemuConfig.Knobs.FileBackedMem)
+	assert.Equal("/run/kata/pool", q.qemuConfig.Memory.Path)
+	assert.Equal(uint32(2048), q.hugePageSizeKB)
+}
@@ -10,14 +10,19 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+	"github.com/pkg/errors"
 )
 
 // HypervisorType describes an hypervisor type.
@@ -39,6 +44,21 @@ const (
 	MockHypervisor HypervisorType = "mock"
 )
 
+// ConfidentialGuestType describes the technology protecting the memory of
+// a confidential guest from the host.
+type ConfidentialGuestType string
+
+const (
+	// NoConfidentialGuest runs a regular, unprotected guest.
+	NoConfidentialGuest ConfidentialGuestType = ""
+
+	// SEVGuest runs an AMD SEV or SEV-ES guest.
+	SEVGuest ConfidentialGuestType = "sev"
+
+	// TDXGuest runs an Intel TDX guest.
+	TDXGuest ConfidentialGuestType = "tdx"
+)
+
 const (
 	procMemInfo = "/proc/meminfo"
 	procCPUInfo = "/proc/cpuinfo"
@@ -54,9 +74,24 @@ const (
 	defaultBlockDriver = config.VirtioSCSI
 )
 
+const (
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+
+	// The hypervisor memory overhead is estimated to a fixed part plus
+	// a ratio of the guest memory, used by page tables and device
+	// emulation.
+	defaultHostMemoryCapOverheadMiB   = 256
+	defaultHostMemoryCapOverheadRatio = 64
+)
+
 // In some architectures the maximum number of vCPUs depends on the number of physical cores.
 var defaultMaxQemuVCPUs = MaxQemuVCPUs()
 
+// virtio-fs tags are at most 36 bytes long. They also end up in socket and
+// guest mount paths.
+var virtioFSTagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,36}$`)
+
 // agnostic list of kernel root parameters for NVDIMM
 var commonNvdimmKernelRootParams = []Param{ //nolint: unused, deadcode, varcheck
 	{"root", "/dev/pmem0p1"},
@@ -93,6 +128,10 @@ const (
 	// vSockPCIDev is the vhost vsock PCI device type.
 	vSockPCIDev
 
+	// hybridVSockDev is the hybrid vsock device type, whose connections
+	// are forwarded by the hypervisor from a unix socket.
+	hybridVSockDev
+
 	// VFIODevice is VFIO device type
 	vfioDev
 
@@ -186,6 +225,10 @@ type HypervisorConfig struct {
 	// Bridges can be used to hot plug devices
 	DefaultBridges uint32
 
+	// MaxBridges specifies the number of bridges the VM can grow up to,
+	// by hotplugging new bridges when the existing ones are full.
+	MaxBridges uint32
+
 	// Msize9p is used as the msize for 9p shares
 	Msize9p uint32
 
@@ -229,6 +272,11 @@ type HypervisorConfig struct {
 	// JailerPath is the jailer executable host path.
 	JailerPath string
 
+	// JailerChrootBase is the base directory of the chroots the jailer
+	// runs the hypervisor in, /var/lib/vc when empty. It must not be
+	// mounted noexec.
+	JailerChrootBase string
+
 	// BlockDeviceDriver specifies the driver to be used for block device
 	// either VirtioSCSI or VirtioBlock with the default driver being defaultBlockDriver
 	BlockDeviceDriver string
@@ -263,6 +311,15 @@ type HypervisorConfig struct {
 	// VirtioFSExtraArgs passes options to virtiofsd daemon
 	VirtioFSExtraArgs []string
 
+	// VirtioFSVolumes are host directories shared with the guest through
+	// their own virtio-fs device and daemon, rather than through the
+	// sandbox shared directory.
+	VirtioFSVolumes []types.Volume
+
+	// VirtioFSVolumePaths are the host directories the virtio-fs volumes
+	// can be taken from. The volumes are denied if empty.
+	VirtioFSVolumePaths []string
+
 	// customAssets is a map of assets.
 	// Each value in that map takes precedence over the configured assets.
 	// For example, if there is a value for the "kernel" key in this map,
@@ -294,9 +351,23 @@ type HypervisorConfig struct {
 	// MemPrealloc specifies if the memory should be pre-allocated
 	MemPrealloc bool
 
+	// MemPreallocAsync pre-allocates the memory in the background once
+	// the VM has booted, rather than before booting it. It only applies
+	// to file backed memory without huge pages.
+	MemPreallocAsync bool
+
 	// HugePages specifies if the memory should be pre-allocated from huge pages
 	HugePages bool
 
+	// HugePageSizeKB is the size of the huge pages backing the guest
+	// memory, in KiB. Zero means the size of the HugePagesPath pages.
+	HugePageSizeKB uint32
+
+	// HugePagesPath is the hugetlbfs mount backing the guest memory. When
+	// empty, the first mount providing HugePageSizeKB pages is used, or
+	// /dev/hugepages if HugePageSizeKB is zero too.
+	HugePagesPath string
+
 	// File based memory backend root directory
 	FileBackedMemRootDir string
 
@@ -325,15 +396,133 @@ type HypervisorConfig struct {
 	// BootFromTemplate used to indicate if the VM should be created from a template VM
 	BootFromTemplate bool
 
+	// IncomingMigration starts the VM waiting for the live migration of
+	// the VM of another host instead of booting it.
+	IncomingMigration bool
+
 	// DisableVhostNet is used to indicate if host supports vhost_net
 	DisableVhostNet bool
 
+	// NetworkMaxQueues is the maximum number of queue pairs of the
+	// multiqueue network interfaces, which then get one queue pair per
+	// vCPU the VM can grow to. 0 gives them one queue pair per boot vCPU.
+	NetworkMaxQueues uint32
+
 	// GuestHookPath is the path within the VM that will be used for 'drop-in' hooks
 	GuestHookPath string
 
 	// VMid is the id of the VM that create the hypervisor if the VM is created by the factory.
 	// VMid is "" if the hypervisor is not created by the factory.
 	VMid string
+
+	// OOMScoreAdj is the oom_score_adj applied to the hypervisor processes.
+	// Zero keeps the value inherited from the runtime.
+	OOMScoreAdj int
+
+	// PinVCPUs pins each vCPU thread to a single host CPU, taken in turn
+	// from VCPUCPUSet, or from the sandbox cpuset cgroup when empty.
+	PinVCPUs bool
+
+	// VCPUCPUSet is the list of host CPUs the vCPU threads are pinned to
+	// when PinVCPUs is set, in cpuset.cpus format, e.g. "2-5,8".
+	VCPUCPUSet string
+
+	// LogMaxSizeMiB is the size of the hypervisor log file above which
+	// it is rotated. Zero means the default size.
+	LogMaxSizeMiB uint32
+
+	// LogReplayMaxSizeKiB caps how much of the hypervisor log is replayed
+	// into the runtime log when the VM stops. Zero means the default size.
+	LogReplayMaxSizeKiB uint32
+
+	// LogScrubParams are the names of the kernel parameters whose values
+	// are hidden from the logs, such as agent tokens.
+	LogScrubParams []string
+
+	// AgentHealthCheck makes the hypervisor health check round-trip a
+	// request to the agent as well.
+	AgentHealthCheck bool
+
+	// HostMemoryCap enables a host memory cgroup limit on the hypervisor
+	// processes, sized to the guest memory plus HostMemoryCapOverheadMB.
+	HostMemoryCap bool
+
+	// HostMemoryCapOverheadMB is the memory allowed to the hypervisor
+	// processes on top of the guest memory. It is computed from the guest
+	// memory size when zero.
+	HostMemoryCapOverheadMB uint32
+
+	// KSMMode is how the host kernel same-page merging is driven while
+	// the sandbox runs. The host settings are restored once no sandbox
+	// asks for KSM anymore.
+	KSMMode KSMMode
+
+	// ConfidentialGuest selects the technology protecting the guest
+	// memory from the host, if any. Memory hotplug and VM templating are
+	// not available for such guests.
+	ConfidentialGuest ConfidentialGuestType
+
+	// SEVCertChainPath is the path to the guest owner's Diffie-Hellman
+	// certificate, used to establish the SEV launch session. It is
+	// optional and only used for SEV guests.
+	SEVCertChainPath string
+
+	// SEVGuestPolicy is the SEV guest policy passed to the firmware at
+	// launch time. Setting bit 2 (0x4) launches a SEV-ES guest.
+	SEVGuestPolicy uint32
+
+	// SGXEPCSize is the size in bytes of the Intel SGX enclave page cache
+	// section exposed to the guest, so that enclaves can be run inside
+	// the sandbox. Zero disables SGX.
+	SGXEPCSize int64
+
+	// SeccompSandbox is the value of the QEMU -sandbox option, e.g.
+	// "on,obsolete=deny,spawn=deny,resourcecontrol=deny", enabling the
+	// QEMU seccomp filter. It is ignored if QEMU is built without seccomp
+	// support.
+	SeccompSandbox string
+
+	// DropCapabilities launches the hypervisor without the capabilities it
+	// does not need, which are removed from its bounding and ambient sets.
+	DropCapabilities bool
+
+	// VMMRlimits are the resource limits of the hypervisor and virtiofsd
+	// processes. The limits of the runtime are inherited otherwise.
+	VMMRlimits []VMMRlimit
+
+	// VMMEnv are the environment variables, of the form NAME=VALUE, the
+	// hypervisor is launched with in addition to those of the runtime,
+	// e.g. to configure its plugins or the vhost-user backends.
+	VMMEnv []string
+
+	// VMMBindMounts are the bind mounts of the private mount namespace
+	// the hypervisor is launched in, e.g. to expose vhost-user sockets at
+	// the paths it expects. It shares the mount namespace of the runtime
+	// when empty.
+	VMMBindMounts []VMMBindMount
+
+	// VMMSlice is the systemd slice the hypervisor processes are placed
+	// in, through a transient scope, once launched. They stay in the
+	// sandbox cgroup when empty.
+	VMMSlice string
+
+	// VMMUser is the unprivileged user the hypervisor process runs as,
+	// once it set the VM up. It runs as root when empty, and virtiofsd
+	// always does.
+	VMMUser string
+
+	// VMStartTimeout is the time in seconds the hypervisor is given to
+	// start and be ready, 10 seconds when zero.
+	VMStartTimeout uint32
+
+	// VirtiofsdStartTimeout is the time in seconds the virtiofsd daemons
+	// are given to be ready. When zero, they take from the hypervisor
+	// start timeout.
+	VirtiofsdStartTimeout uint32
+
+	// EnableAnnotations lists the configuration options, by their
+	// configuration file names, the sandbox annotations may override.
+	EnableAnnotations []string
 }
 
 // vcpu mapping from vcpu number to thread number
@@ -341,11 +530,109 @@ type vcpuThreadIDs struct {
 	vcpus map[int]int
 }
 
+// HypervisorMetrics are the host resources used by the hypervisor of a
+// sandbox.
+type HypervisorMetrics struct {
+	// RSS is the resident memory of the hypervisor process, in bytes.
+	RSS uint64
+
+	// CPUTime is the CPU time used by the hypervisor process, including
+	// its vCPU threads.
+	CPUTime time.Duration
+
+	// VCPUTime is the CPU time used by each vCPU thread, indexed by vCPU.
+	VCPUTime map[int]time.Duration
+
+	// VirtiofsdRSS and VirtiofsdCPUTime are the resources used by the
+	// virtiofsd daemon, if any.
+	VirtiofsdRSS     uint64
+	VirtiofsdCPUTime time.Duration
+
+	// HotpluggedMemoryMB is the memory hot added to the guest, as
+	// reported by the hypervisor.
+	HotpluggedMemoryMB uint64
+
+	// HostTmpUsage is the disk space used by the temporary files of the
+	// sandbox on the host, in bytes.
+	HostTmpUsage uint64
+
+	// HostScratchUsage is the disk space used by the scratch disks of the
+	// containers on the host, in bytes.
+	HostScratchUsage uint64
+}
+
+// OverheadCPUTime returns the CPU time spent by the hypervisor and its
+// helpers outside of the guest vCPUs.
+func (m HypervisorMetrics) OverheadCPUTime() time.Duration {
+	overhead := m.CPUTime + m.VirtiofsdCPUTime
+	for _, t := range m.VCPUTime {
+		overhead -= t
+	}
+
+	if overhead < 0 {
+		return 0
+	}
+	return overhead
+}
+
+// procResources returns the resident memory, in bytes, and the CPU time
+// used by a process or a thread.
+func procResources(pid int) (uint64, time.Duration, error) {
+	proc, err := utils.NewProc(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stat, err := proc.NewStat()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to read pid %d stat", pid)
+	}
+
+	return uint64(stat.ResidentMemory()), time.Duration(stat.CPUTime() * float64(time.Second)), nil
+}
+
+// processMetrics collects the resources used by an hypervisor process
+// and its vCPU threads.
+func processMetrics(pid int, tids vcpuThreadIDs) (HypervisorMetrics, error) {
+	var m HypervisorMetrics
+	var err error
+
+	m.RSS, m.CPUTime, err = procResources(pid)
+	if err != nil {
+		return m, err
+	}
+
+	m.VCPUTime = make(map[int]time.Duration, len(tids.vcpus))
+	for vcpu, tid := range tids.vcpus {
+		_, cpuTime, err := procResources(tid)
+		if err != nil {
+			return m, err
+		}
+		m.VCPUTime[vcpu] = cpuTime
+	}
+
+	return m, nil
+}
+
+// startTimeout returns the time in seconds the hypervisor is given to
+// start.
+func (conf *HypervisorConfig) startTimeout() int {
+	if conf.VMStartTimeout > 0 {
+		return int(conf.VMStartTimeout)
+	}
+
+	return vmStartTimeout
+}
+
 func (conf *HypervisorConfig) checkTemplateConfig() error {
 	if conf.BootToBeTemplate && conf.BootFromTemplate {
 		return fmt.Errorf("Cannot set both 'to be' and 'from' vm tempate")
 	}
 
+	if conf.IncomingMigration && (conf.BootToBeTemplate || conf.BootFromTemplate) {
+		return fmt.Errorf("Cannot receive a live migration with a vm template")
+	}
+
 	if conf.BootToBeTemplate || conf.BootFromTemplate {
 		if conf.MemoryPath == "" {
 			return fmt.Errorf("Missing MemoryPath for vm template")
@@ -396,6 +683,111 @@ func (conf *HypervisorConfig) valid() error {
 		conf.Msize9p = defaultMsize9p
 	}
 
+	if conf.OOMScoreAdj < minOOMScoreAdj || conf.OOMScoreAdj > maxOOMScoreAdj {
+		return fmt.Errorf("Invalid hypervisor oom_score_adj %d, expecting a value between %d and %d",
+			conf.OOMScoreAdj, minOOMScoreAdj, maxOOMScoreAdj)
+	}
+
+	if conf.HugePageSizeKB&(conf.HugePageSizeKB-1) != 0 {
+		return fmt.Errorf("Invalid huge page size %d KiB, expecting a power of two", conf.HugePageSizeKB)
+	}
+
+	if conf.SGXEPCSize < 0 {
+		return fmt.Errorf("Invalid SGX EPC size %d", conf.SGXEPCSize)
+	}
+
+	if conf.VCPUCPUSet != "" {
+		if _, err := utils.ParseCPUSet(conf.VCPUCPUSet); err != nil {
+			return err
+		}
+	}
+
+	if err := conf.KSMMode.valid(); err != nil {
+		return err
+	}
+
+	if err := conf.checkConfidentialGuestConfig(); err != nil {
+		return err
+	}
+
+	if err := conf.checkVirtioFSVolumes(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (conf *HypervisorConfig) checkVirtioFSVolumes() error {
+	if len(conf.VirtioFSVolumes) == 0 {
+		return nil
+	}
+
+	if conf.SharedFS != config.VirtioFS {
+		return fmt.Errorf("virtio-fs volumes require the %s shared file system", config.VirtioFS)
+	}
+
+	// The sandbox shared directory uses its own tag.
+	tags := map[string]bool{mountGuest9pTag: true}
+	for _, v := range conf.VirtioFSVolumes {
+		if !virtioFSTagRegexp.MatchString(v.MountTag) {
+			return fmt.Errorf("Invalid virtio-fs volume tag %q", v.MountTag)
+		}
+
+		if tags[v.MountTag] {
+			return fmt.Errorf("Duplicated virtio-fs volume tag %q", v.MountTag)
+		}
+		tags[v.MountTag] = true
+
+		if !filepath.IsAbs(v.HostPath) {
+			return fmt.Errorf("virtio-fs volume %s host path %q is not absolute", v.MountTag, v.HostPath)
+		}
+
+		if !conf.virtioFSVolumePathAllowed(v.HostPath) {
+			return fmt.Errorf("virtio-fs volume %s host path %q is not under the allowed virtio-fs volume paths", v.MountTag, v.HostPath)
+		}
+	}
+
+	return nil
+}
+
+// virtioFSVolumePathAllowed returns true if path is one of the allowed
+// virtio-fs volume paths or lies under one of them. The path is compared
+// as is, its symbolic links are expected to be resolved already.
+func (conf *HypervisorConfig) virtioFSVolumePathAllowed(path string) bool {
+	path = filepath.Clean(path)
+	for _, p := range conf.VirtioFSVolumePaths {
+		p = filepath.Clean(p)
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (conf *HypervisorConfig) checkConfidentialGuestConfig() error {
+	switch conf.ConfidentialGuest {
+	case NoConfidentialGuest:
+		return nil
+	case SEVGuest, TDXGuest:
+	default:
+		return fmt.Errorf("Unknown confidential guest type %q", conf.ConfidentialGuest)
+	}
+
+	if conf.BootToBeTemplate || conf.BootFromTemplate {
+		return fmt.Errorf("VM templating is not supported for %s confidential guests", conf.ConfidentialGuest)
+	}
+
+	// The guest cannot read an image exposed as an unprotected NVDIMM.
+	if conf.InitrdPath == "" {
+		return fmt.Errorf("%s confidential guests cannot boot from an NVDIMM image, an initrd is required", conf.ConfidentialGuest)
+	}
+
+	// OVMF for SEV, TDVF for TDX.
+	if conf.FirmwarePath == "" {
+		return fmt.Errorf("%s confidential guests require a firmware path", conf.ConfidentialGuest)
+	}
+
 	return nil
 }
 
@@ -604,9 +996,19 @@ func getHostMemorySizeKb(memInfoPath string) (uint64, error) {
 	return 0, fmt.Errorf("unable get MemTotal from %s", memInfoPath)
 }
 
+// HostMemoryMB returns the memory of the host in MiB.
+func HostMemoryMB() (uint64, error) {
+	memKb, err := getHostMemorySizeKb(procMemInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	return memKb / 1024, nil
+}
+
 // RunningOnVMM checks if the system is running inside a VM.
 func RunningOnVMM(cpuInfoPath string) (bool, error) {
-	if runtime.GOARCH == "arm64" || runtime.GOARCH == "ppc64le" || runtime.GOARCH == "s390x" {
+	if runtime.GOARCH == "arm64" || runtime.GOARCH == "ppc64le" || runtime.GOARCH == "riscv64" || runtime.GOARCH == "s390x" {
 		virtLog.Info("Unable to know if the system is running inside a VM")
 		return false, nil
 	}
@@ -671,20 +1073,39 @@ type hypervisor interface {
 	addDevice(devInfo interface{}, devType deviceType) error
 	hotplugAddDevice(devInfo interface{}, devType deviceType) (interface{}, error)
 	hotplugRemoveDevice(devInfo interface{}, devType deviceType) (interface{}, error)
+	// setBlockDeviceDriver changes the driver the next block devices are
+	// hotplugged with.
+	setBlockDeviceDriver(driver string)
 	resizeMemory(memMB uint32, memoryBlockSizeMB uint32, probe bool) (uint32, memoryDevice, error)
 	resizeVCPUs(vcpus uint32) (uint32, uint32, error)
 	getSandboxConsole(sandboxID string) (string, error)
 	disconnect()
 	capabilities() types.Capabilities
 	hypervisorConfig() HypervisorConfig
+	// generateSocket returns the socket the agent of the VM id is reached
+	// through, a vsock one when useVsock is true.
+	generateSocket(id string, useVsock bool) (interface{}, error)
 	getThreadIDs() (vcpuThreadIDs, error)
+	metrics() (HypervisorMetrics, error)
+	// hotplugCapacity returns the devices and memory that can still be
+	// hotplugged in the VM.
+	hotplugCapacity() (hotplugCapacity, error)
 	cleanup() error
 	// getPids returns a slice of hypervisor related process ids.
 	// The hypervisor pid must be put at index 0.
 	getPids() []int
 	fromGrpc(ctx context.Context, hypervisorConfig *HypervisorConfig, store *store.VCStore, j []byte) error
 	toGrpc() ([]byte, error)
-	check() error
+	// check returns an error if the hypervisor is not healthy. When
+	// agentCheck is not nil, it is also called to make sure the agent
+	// answers once the guest is known to be running.
+	check(agentCheck func() error) error
+	// migrateSandbox live migrates the VM to the target of config. The
+	// VM is left paused on this host once the migration is completed.
+	migrateSandbox(config MigrationConfig) error
+	// receiveMigration receives the live migration of config in the VM
+	// started with IncomingMigration, which runs once it is completed.
+	receiveMigration(config MigrationConfig) error
 
 	save() persistapi.HypervisorState
 	load(persistapi.HypervisorState)
@@ -0,0 +1,296 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultHypervisorLogMaxSizeMiB is the size above which the
+	// hypervisor log file is rotated.
+	defaultHypervisorLogMaxSizeMiB = 4
+
+	// defaultHypervisorLogReplayKiB is how much of the end of the
+	// hypervisor log is replayed into the runtime log.
+	defaultHypervisorLogReplayKiB = 64
+
+	// scrubbedValue replaces the values hidden from the logs.
+	scrubbedValue = "<redacted>"
+)
+
+// logMaxSize returns the size above which the hypervisor log is rotated.
+func (conf *HypervisorConfig) logMaxSize() int64 {
+	size := conf.LogMaxSizeMiB
+	if size == 0 {
+		size = defaultHypervisorLogMaxSizeMiB
+	}
+
+	return int64(size) << 20
+}
+
+// logReplaySize returns how much of the hypervisor log is replayed into the
+// runtime log.
+func (conf *HypervisorConfig) logReplaySize() int64 {
+	size := conf.LogReplayMaxSizeKiB
+	if size == 0 {
+		size = defaultHypervisorLogReplayKiB
+	}
+
+	return int64(size) << 10
+}
+
+// logScrubber hides the values of sensitive kernel parameters from the logs.
+type logScrubber struct {
+	re     *regexp.Regexp
+	params map[string]bool
+}
+
+func newLogScrubber(params []string) logScrubber {
+	var names []string
+	scrubbed := make(map[string]bool)
+	for _, p := range params {
+		if p != "" {
+			names = append(names, regexp.QuoteMeta(p))
+			scrubbed[p] = true
+		}
+	}
+
+	if len(names) == 0 {
+		return logScrubber{}
+	}
+
+	return logScrubber{
+		re:     regexp.MustCompile(`(^|[\s,"'\[])(` + strings.Join(names, "|") + `)=[^\s,"'\]]*`),
+		params: scrubbed,
+	}
+}
+
+// qmpSecretArguments are the QMP arguments always hidden from the logs: the
+// passwords, and the data of the secret objects.
+var qmpSecretArguments = map[string]bool{
+	"password": true,
+	"data":     true,
+	"secret":   true,
+}
+
+// scrubArguments returns the JSON arguments of a QMP command, with the
+// values of the secret arguments and of the scrubbed parameters hidden, as
+// well as the scrubbed parameters of the strings.
+func (s logScrubber) scrubArguments(arguments json.RawMessage) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(arguments, &v); err != nil {
+		return scrubbedValue
+	}
+
+	return s.scrubValue(v)
+}
+
+func (s logScrubber) scrubValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if qmpSecretArguments[key] || s.params[key] {
+				v[key] = scrubbedValue
+			} else {
+				v[key] = s.scrubValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = s.scrubValue(value)
+		}
+	case string:
+		return s.scrub(v)
+	}
+
+	return v
+}
+
+func (s logScrubber) scrub(line string) string {
+	if s.re == nil {
+		return line
+	}
+
+	return s.re.ReplaceAllString(line, "${1}${2}="+scrubbedValue)
+}
+
+// hypervisorLog copies the log the hypervisor writes to a named pipe into
+// the log file, which is rotated to path.1 once larger than maxSize. The
+// hypervisor never holds the log file open, so it is renamed and a new one
+// opened, rather than truncated under the hypervisor writing past its end.
+type hypervisorLog struct {
+	path    string
+	maxSize int64
+	size    int64
+	file    *os.File
+	pipe    *os.File
+	done    chan struct{}
+}
+
+// logPipePath returns the named pipe the hypervisor writes the log file path
+// to.
+func logPipePath(path string) string {
+	return path + ".pipe"
+}
+
+// startHypervisorLog creates the named pipe of the log file path, unless it
+// exists, as it does for a hypervisor started by a previous runtime, and
+// copies what is written to it into the log file. The pipe is opened for
+// writing too, not to read an end of file while no hypervisor holds it open.
+func startHypervisorLog(path string, maxSize int64) (*hypervisorLog, error) {
+	pipePath := logPipePath(path)
+	if err := unix.Mkfifo(pipePath, 0600); err != nil && err != unix.EEXIST {
+		return nil, err
+	}
+
+	pipe, err := os.OpenFile(pipePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &hypervisorLog{
+		path:    path,
+		maxSize: maxSize,
+		pipe:    pipe,
+		done:    make(chan struct{}),
+	}
+
+	if err := l.open(); err != nil {
+		pipe.Close()
+		return nil, err
+	}
+
+	go l.copy()
+
+	return l, nil
+}
+
+func (l *hypervisorLog) copy() {
+	defer close(l.done)
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := l.pipe.Read(buf)
+		if n > 0 {
+			l.write(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (l *hypervisorLog) write(data []byte) {
+	if l.file != nil && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		l.rotate()
+	}
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			virtLog.WithError(err).WithField("path", l.path).Warn("failed to open the hypervisor log")
+			return
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		virtLog.WithError(err).WithField("path", l.path).Warn("failed to write the hypervisor log")
+	}
+}
+
+func (l *hypervisorLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	l.size = fi.Size()
+
+	return nil
+}
+
+// rotate moves the log to path.1, replacing the previous one, for the
+// hypervisor log to be written to a new file.
+func (l *hypervisorLog) rotate() {
+	l.file.Close()
+	l.file = nil
+
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		virtLog.WithError(err).WithField("path", l.path).Warn("failed to rotate the hypervisor log")
+	}
+}
+
+// close stops the copy and closes the log file. It is a no-op on a nil log.
+func (l *hypervisorLog) close() {
+	if l == nil {
+		return
+	}
+
+	l.pipe.Close()
+	<-l.done
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// replayLog calls logLine for each scrubbed line of the last maxSize bytes
+// of the log file path.
+func replayLog(path string, maxSize int64, scrubber logScrubber, logLine func(string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	skipped := fi.Size() - maxSize
+	if skipped > 0 {
+		if _, err := f.Seek(skipped, io.SeekStart); err != nil {
+			return err
+		}
+		logLine(fmt.Sprintf("[%d bytes of log skipped]", skipped))
+	}
+
+	scanner := bufio.NewScanner(f)
+	for first := true; scanner.Scan(); first = false {
+		// the first line is likely partial after seeking
+		if first && skipped > 0 {
+			continue
+		}
+
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		logLine(scrubber.scrub(line))
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHypervisorConfigLogSizes(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := HypervisorConfig{}
+	assert.Equal(int64(defaultHypervisorLogMaxSizeMiB)<<20, conf.logMaxSize())
+	assert.Equal(int64(defaultHypervisorLogReplayKiB)<<10, conf.logReplaySize())
+
+	conf.LogMaxSizeMiB = 1
+	conf.LogReplayMaxSizeKiB = 2
+	assert.Equal(int64(1<<20), conf.logMaxSize())
+	assert.Equal(int64(2<<10), conf.logReplaySize())
+}
+
+func TestLogScrubber(t *testing.T) {
+	assert := assert.New(t)
+
+	line := "launching qemu with: [-append tsc=reliable agent.token=s3cr3t agent.log=debug]"
+
+	assert.Equal(line, newLogScrubber(nil).scrub(line))
+	assert.Equal(line, newLogScrubber([]string{""}).scrub(line))
+
+	s := newLogScrubber([]string{"agent.token", "agent.log"})
+	assert.Equal("launching qemu with: [-append tsc=reliable agent.token=<redacted> agent.log=<redacted>]", s.scrub(line))
+
+	// only whole parameter names are scrubbed
+	assert.Equal("xagent.token=foo", s.scrub("xagent.token=foo"))
+	assert.Equal("agent.token=<redacted>", s.scrub("agent.token=foo"))
+}
+
+func TestHypervisorLog(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hypervisor-log")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "qemu.log")
+
+	l, err := startHypervisorLog(path, 8)
+	assert.NoError(err)
+
+	fi, err := os.Stat(logPipePath(path))
+	assert.NoError(err)
+	assert.True(fi.Mode()&os.ModeNamedPipe != 0)
+
+	// the log is copied asynchronously
+	readLog := func(path string, expected string) {
+		var data []byte
+		for i := 0; i < 100; i++ {
+			if data, _ = ioutil.ReadFile(path); string(data) == expected {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(expected, string(data), path)
+	}
+
+	pipe, err := os.OpenFile(logPipePath(path), os.O_WRONLY, 0)
+	assert.NoError(err)
+
+	_, err = pipe.Write([]byte("1234\n"))
+	assert.NoError(err)
+	readLog(path, "1234\n")
+
+	// the writer going away does not stop the copy
+	assert.NoError(pipe.Close())
+	pipe, err = os.OpenFile(logPipePath(path), os.O_WRONLY, 0)
+	assert.NoError(err)
+	defer pipe.Close()
+
+	_, err = pipe.Write([]byte("5678\n"))
+	assert.NoError(err)
+	readLog(path, "5678\n")
+	readLog(path+".1", "1234\n")
+
+	l.close()
+
+	// the copy goes on with the existing pipe and log file
+	l, err = startHypervisorLog(path, 8)
+	assert.NoError(err)
+	defer l.close()
+
+	_, err = pipe.Write([]byte("9\n"))
+	assert.NoError(err)
+	readLog(path, "5678\n9\n")
+}
+
+func TestReplayLog(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hypervisor-log")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "qemu.log")
+
+	var lines []string
+	logLine := func(line string) {
+		lines = append(lines, line)
+	}
+
+	assert.Error(replayLog(path, 1024, logScrubber{}, logLine))
+
+	content := "first agent.token=foo\nsecond\n\nthird\n"
+	assert.NoError(ioutil.WriteFile(path, []byte(content), 0640))
+
+	assert.NoError(replayLog(path, 1024, newLogScrubber([]string{"agent.token"}), logLine))
+	assert.Equal([]string{"first agent.token=<redacted>", "second", "third"}, lines)
+
+	// only the end of the log is replayed
+	lines = nil
+	assert.NoError(replayLog(path, 10, logScrubber{}, logLine))
+	assert.Len(lines, 2)
+	assert.True(strings.HasPrefix(lines[0], "["))
+	assert.Equal("third", lines[1])
+}
@@ -10,8 +10,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -435,3 +439,167 @@ func genericTestRunningOnVMM(t *testing.T, data []testNestedVMMData) {
 		assert.Equal(running, d.expected)
 	}
 }
+
+func TestHypervisorMetricsOverheadCPUTime(t *testing.T) {
+	assert := assert.New(t)
+
+	m := HypervisorMetrics{
+		CPUTime:          5 * time.Second,
+		VCPUTime:         map[int]time.Duration{0: time.Second, 1: 2 * time.Second},
+		VirtiofsdCPUTime: time.Second,
+	}
+	assert.Equal(3*time.Second, m.OverheadCPUTime())
+
+	m.CPUTime = 0
+	m.VirtiofsdCPUTime = 0
+	assert.Equal(time.Duration(0), m.OverheadCPUTime())
+}
+
+func TestProcessMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	pid := os.Getpid()
+	m, err := processMetrics(pid, vcpuThreadIDs{map[int]int{0: pid}})
+	assert.NoError(err)
+	assert.NotZero(m.RSS)
+	assert.Len(m.VCPUTime, 1)
+
+	_, err = processMetrics(-1, vcpuThreadIDs{})
+	assert.Error(err)
+}
+
+func TestHypervisorConfigOOMScoreAdj(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:     fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:      fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath: fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		OOMScoreAdj:    -1000,
+	}
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.OOMScoreAdj = 1001
+	assert.Error(hypervisorConfig.valid())
+}
+
+func TestHypervisorConfigKSMMode(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:     fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:      fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath: fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		KSMMode:        KSMAggressive,
+	}
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.KSMMode = "always"
+	assert.Error(hypervisorConfig.valid())
+}
+
+func TestHypervisorConfigHugePageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:     fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:      fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath: fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		HugePages:      true,
+		HugePageSizeKB: 1024 * 1024,
+	}
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.HugePageSizeKB = 3072
+	assert.Error(hypervisorConfig.valid())
+}
+
+func TestHypervisorConfigSGXEPCSize(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:     fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:      fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath: fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		SGXEPCSize:     64 * 1024 * 1024,
+	}
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.SGXEPCSize = -1
+	assert.Error(hypervisorConfig.valid())
+}
+
+func TestHypervisorConfigConfidentialGuest(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:        fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:         fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath:    fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		ConfidentialGuest: "foo",
+	}
+	assert.Error(hypervisorConfig.valid())
+
+	hypervisorConfig.ConfidentialGuest = SEVGuest
+
+	// An image is exposed through an unencrypted NVDIMM
+	assert.Error(hypervisorConfig.valid())
+
+	hypervisorConfig.ImagePath = ""
+	hypervisorConfig.InitrdPath = fmt.Sprintf("%s/%s", testDir, testInitrd)
+	assert.Error(hypervisorConfig.valid())
+
+	hypervisorConfig.FirmwarePath = "/usr/share/ovmf/OVMF.fd"
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.ConfidentialGuest = TDXGuest
+	assert.NoError(hypervisorConfig.valid())
+
+	hypervisorConfig.BootToBeTemplate = true
+	hypervisorConfig.MemoryPath = "/dev/shm/foo"
+	assert.Error(hypervisorConfig.valid())
+}
+
+func TestHypervisorConfigVirtioFSVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	hypervisorConfig := &HypervisorConfig{
+		KernelPath:     fmt.Sprintf("%s/%s", testDir, testKernel),
+		ImagePath:      fmt.Sprintf("%s/%s", testDir, testImage),
+		HypervisorPath: fmt.Sprintf("%s/%s", testDir, testHypervisor),
+		VirtioFSVolumes: []types.Volume{
+			{MountTag: "data", HostPath: "/srv/data"},
+		},
+	}
+
+	// virtio-fs volumes require virtio-fs
+	assert.Error(hypervisorConfig.valid())
+
+	// and an allowed host path.
+	hypervisorConfig.SharedFS = config.VirtioFS
+	assert.Error(hypervisorConfig.valid())
+
+	for _, paths := range [][]string{{"/srv/data"}, {"/srv/"}, {"/srv"}, {"/"}, {"/opt", "/srv"}} {
+		hypervisorConfig.VirtioFSVolumePaths = paths
+		assert.NoError(hypervisorConfig.valid(), "paths %v", paths)
+	}
+
+	for _, paths := range [][]string{{"/srv/data/sub"}, {"/srv/dat"}, {"/opt"}} {
+		hypervisorConfig.VirtioFSVolumePaths = paths
+		assert.Error(hypervisorConfig.valid(), "paths %v", paths)
+	}
+
+	hypervisorConfig.VirtioFSVolumePaths = []string{"/srv"}
+
+	for _, volumes := range [][]types.Volume{
+		{{MountTag: "", HostPath: "/srv/data"}},
+		{{MountTag: "da/ta", HostPath: "/srv/data"}},
+		{{MountTag: strings.Repeat("a", 37), HostPath: "/srv/data"}},
+		{{MountTag: mountGuest9pTag, HostPath: "/srv/data"}},
+		{{MountTag: "data", HostPath: "srv/data"}},
+		{{MountTag: "data", HostPath: "/srv/data"}, {MountTag: "data", HostPath: "/srv/models"}},
+	} {
+		hypervisorConfig.VirtioFSVolumes = volumes
+		assert.Error(hypervisorConfig.valid(), "volumes %+v", volumes)
+	}
+}
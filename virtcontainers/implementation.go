@@ -61,6 +61,21 @@ func (impl *VCImpl) RunSandbox(ctx context.Context, sandboxConfig SandboxConfig)
 	return RunSandbox(ctx, sandboxConfig, impl.factory)
 }
 
+// PrepareMigration implements the VC function of the same name.
+func (impl *VCImpl) PrepareMigration(ctx context.Context, sandboxID string) (*SandboxConfig, error) {
+	return PrepareMigration(ctx, sandboxID)
+}
+
+// MigrateSandbox implements the VC function of the same name.
+func (impl *VCImpl) MigrateSandbox(ctx context.Context, sandboxID string, config MigrationConfig) error {
+	return MigrateSandbox(ctx, sandboxID, config)
+}
+
+// ReceiveSandbox implements the VC function of the same name.
+func (impl *VCImpl) ReceiveSandbox(ctx context.Context, sandboxConfig SandboxConfig, config MigrationConfig) (VCSandbox, error) {
+	return ReceiveSandbox(ctx, sandboxConfig, config)
+}
+
 // ListSandbox implements the VC function of the same name.
 func (impl *VCImpl) ListSandbox(ctx context.Context) ([]SandboxStatus, error) {
 	return ListSandbox(ctx)
@@ -8,7 +8,9 @@ package virtcontainers
 import (
 	"context"
 	"io"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/api"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
@@ -33,6 +35,9 @@ type VC interface {
 	StartSandbox(ctx context.Context, sandboxID string) (VCSandbox, error)
 	StatusSandbox(ctx context.Context, sandboxID string) (SandboxStatus, error)
 	StopSandbox(ctx context.Context, sandboxID string, force bool) (VCSandbox, error)
+	PrepareMigration(ctx context.Context, sandboxID string) (*SandboxConfig, error)
+	MigrateSandbox(ctx context.Context, sandboxID string, config MigrationConfig) error
+	ReceiveSandbox(ctx context.Context, sandboxConfig SandboxConfig, config MigrationConfig) (VCSandbox, error)
 
 	CreateContainer(ctx context.Context, sandboxID string, containerConfig ContainerConfig) (VCSandbox, VCContainer, error)
 	DeleteContainer(ctx context.Context, sandboxID, containerID string) (VCContainer, error)
@@ -84,6 +89,10 @@ type VCSandbox interface {
 	KillContainer(containerID string, signal syscall.Signal, all bool) error
 	StatusContainer(containerID string) (ContainerStatus, error)
 	StatsContainer(containerID string) (ContainerStats, error)
+	NetworkStats() ([]*NetworkStats, error)
+	Metrics() (HypervisorMetrics, error)
+	AllocateVSockPort(service string) (uint32, error)
+	ReleaseVSockPort(service string) error
 	PauseContainer(containerID string) error
 	ResumeContainer(containerID string) error
 	EnterContainer(containerID string, cmd types.Cmd) (VCContainer, *Process, error)
@@ -101,6 +110,20 @@ type VCSandbox interface {
 	ListInterfaces() ([]*vcTypes.Interface, error)
 	UpdateRoutes(routes []*vcTypes.Route) ([]*vcTypes.Route, error)
 	ListRoutes() ([]*vcTypes.Route, error)
+	UpdateBandwidth(bw BandwidthConfig) error
+
+	HotplugJobs() []HotplugJob
+	CancelHotplugJob(id string) error
+
+	ReserveHotplug(owner string, bridgeSlots, memorySlots uint32, ttl time.Duration) (HotplugReservation, error)
+	ReleaseHotplugReservation(id, owner string) error
+	HotplugReservations(owner string) []HotplugReservation
+
+	Provenance() *types.Provenance
+
+	SetOperationLock(lock sync.Locker)
+
+	DebugInfo() SandboxDebugInfo
 }
 
 // VCContainer is the Container interface
@@ -127,6 +127,7 @@ func (endpoint *IPVlanEndpoint) save() persistapi.NetworkEndpoint {
 		Type: string(endpoint.Type()),
 		IPVlan: &persistapi.IPVlanEndpoint{
 			NetPair: *netpair,
+			PCIAddr: endpoint.PCIAddr,
 		},
 	}
 }
@@ -137,5 +138,6 @@ func (endpoint *IPVlanEndpoint) load(s persistapi.NetworkEndpoint) {
 	if s.IPVlan != nil {
 		netpair := loadNetIfPair(&s.IPVlan.NetPair)
 		endpoint.NetPair = *netpair
+		endpoint.PCIAddr = s.IPVlan.PCIAddr
 	}
 }
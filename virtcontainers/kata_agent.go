@@ -6,9 +6,11 @@
 package virtcontainers
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -26,7 +28,9 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/hvsock"
 	ns "github.com/kata-containers/runtime/virtcontainers/pkg/nsenter"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/tracing"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/uuid"
 	"github.com/kata-containers/runtime/virtcontainers/store"
@@ -40,6 +44,7 @@ import (
 	"golang.org/x/sys/unix"
 	golangGrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	grpcStatus "google.golang.org/grpc/status"
 )
 
@@ -55,6 +60,16 @@ const (
 var (
 	checkRequestTimeout   = 30 * time.Second
 	defaultRequestTimeout = 60 * time.Second
+
+	// The connection to the agent is attempted again after a delay
+	// doubling from agentReconnectBackoff up to agentReconnectMaxBackoff,
+	// at most agentReconnectAttempts times, each attempt being given
+	// agentReconnectDialTimeout.
+	agentReconnectAttempts    = 6
+	agentReconnectBackoff     = 50 * time.Millisecond
+	agentReconnectMaxBackoff  = 2 * time.Second
+	agentReconnectDialTimeout = 2 * time.Second
+
 	defaultKataSocketName = "kata.sock"
 	defaultKataChannel    = "agent.channel.0"
 	defaultKataDeviceID   = "channel0"
@@ -63,22 +78,33 @@ var (
 	errorMissingOCISpec   = errors.New("Missing OCI specification")
 	kataHostSharedDir     = "/run/kata-containers/shared/sandboxes/"
 	kataGuestSharedDir    = "/run/kata-containers/shared/containers/"
+	kataGuestVolumesDir   = "/run/kata-containers/shared/volumes/"
+	kataGuestWatchableDir = "/run/kata-containers/shared/watchable/"
 	mountGuest9pTag       = "kataShared"
 	kataGuestSandboxDir   = "/run/kata-containers/sandbox/"
 	type9pFs              = "9p"
 	typeVirtioFS          = "virtio_fs"
 	typeVirtioFSNoCache   = "none"
 	vsockSocketScheme     = "vsock"
+	hybridVSockScheme     = hvsock.Scheme
 	// port numbers below 1024 are called privileged ports. Only a process with
 	// CAP_NET_BIND_SERVICE capability may bind to these port numbers.
-	vSockPort                   = 1024
+	vSockPort                   = types.VSockAgentPort
 	kata9pDevType               = "9p"
 	kataMmioBlkDevType          = "mmioblk"
 	kataBlkDevType              = "blk"
+	kataBlkSerialDevType        = "blk-serial"
+	kataLUKSDriverOption        = "luks"
+	luksKeyDriverOption         = "luks.key="
+	kataIntegrityDriverOption   = "integrity"
+	integrityAlgDriverOption    = "integrity.alg="
+	integrityDirsDriverOption   = "integrity.dirs="
 	kataBlkCCWDevType           = "blk-ccw"
 	kataSCSIDevType             = "scsi"
 	kataNvdimmDevType           = "nvdimm"
+	kataVirtioPmemDevType       = "virtio-pmem"
 	kataVirtioFSDevType         = "virtio-fs"
+	kataWatchableBindDevType    = "watchable-bind"
 	sharedDir9pOptions          = []string{"trans=virtio,version=9p2000.L,cache=mmap", "nodev"}
 	sharedDirVirtioFSOptions    = []string{"default_permissions,allow_other,rootmode=040000,user_id=0,group_id=0,tag=" + mountGuest9pTag, "nodev"}
 	sharedDirVirtioFSDaxOptions = "dax"
@@ -133,6 +159,22 @@ const (
 	grpcStopTracingRequest       = "grpc.StopTracingRequest"
 )
 
+// idempotentRequests are the requests sent again to the agent when the
+// connection to it is lost while they are in flight: sending them twice
+// does not change their result.
+var idempotentRequests = map[string]bool{
+	grpcCheckRequest:            true,
+	grpcUpdateRoutesRequest:     true,
+	grpcUpdateInterfaceRequest:  true,
+	grpcListInterfacesRequest:   true,
+	grpcListRoutesRequest:       true,
+	grpcListProcessesRequest:    true,
+	grpcTtyWinResizeRequest:     true,
+	grpcStatsContainerRequest:   true,
+	grpcGuestDetailsRequest:     true,
+	grpcSetGuestDateTimeRequest: true,
+}
+
 // KataAgentConfig is a structure storing information needed
 // to reach the Kata Containers agent.
 type KataAgentConfig struct {
@@ -143,6 +185,22 @@ type KataAgentConfig struct {
 	TraceMode     string
 	TraceType     string
 	KernelModules []string
+
+	// KernelModulesAllowlist lists the names of the kernel modules the
+	// KernelModules sandbox annotation may ask the guest to load. No
+	// module may be loaded through the annotation when it is empty, the
+	// modules of the configuration are always loaded.
+	KernelModulesAllowlist []string
+
+	// Policy is the JSON agent policy document filtering the requests
+	// forwarded to the agent, see AgentPolicy. It is only taken from the
+	// runtime configuration.
+	Policy string
+
+	// DialTimeout is the time in seconds the agent is given to accept
+	// the connection of the runtime once the VM started. The agent
+	// client default applies when zero.
+	DialTimeout uint32
 }
 
 type kataVSOCK struct {
@@ -155,6 +213,18 @@ func (s *kataVSOCK) String() string {
 	return fmt.Sprintf("%s://%d:%d", vsockSocketScheme, s.contextID, s.port)
 }
 
+// kataHybridVSOCK is a vsock port of the guest whose connections are
+// forwarded by the hypervisor from the unix socket udsPath, as Firecracker
+// and cloud-hypervisor do.
+type kataHybridVSOCK struct {
+	udsPath string
+	port    uint32
+}
+
+func (s *kataHybridVSOCK) String() string {
+	return hvsock.URL(s.udsPath, s.port)
+}
+
 // KataAgentState is the structure describing the data stored from this
 // agent implementation.
 type KataAgentState struct {
@@ -162,13 +232,26 @@ type KataAgentState struct {
 	URL      string
 }
 
+// agentClient is a gRPC client connected to the agent, through the agent
+// client for the vsock and unix URLs or the hybrid vsock one.
+type agentClient interface {
+	grpc.AgentServiceClient
+	grpc.HealthClient
+	Close() error
+}
+
 type kataAgent struct {
 	shim  shim
 	proxy proxy
 
-	// lock protects the client pointer
+	// lock protects the client pointer, the number of requests using it
+	// and the health of the connection
 	sync.Mutex
-	client *kataclient.AgentClient
+	client   agentClient
+	inflight int
+	conn     AgentHealth
+	// reconnected is closed once the pending reconnection, if any, ends.
+	reconnected chan struct{}
 
 	reqHandlers    map[string]reqFunc
 	state          KataAgentState
@@ -177,6 +260,8 @@ type kataAgent struct {
 	dynamicTracing bool
 	dead           bool
 	kmodules       []string
+	policy         *AgentPolicy
+	dialTimeout    time.Duration
 
 	vmSocket interface{}
 	ctx      context.Context
@@ -208,28 +293,55 @@ func (k *kataAgent) getSharePath(id string) string {
 	return filepath.Join(kataHostSharedDir, id)
 }
 
-func (k *kataAgent) generateVMSocket(id string, c KataAgentConfig) error {
-	if c.UseVSock {
+// generateVMSocket returns the socket of the agent of the VM id, for the
+// hypervisors reaching it through a vhost vsock device or an emulated
+// serial port.
+func generateVMSocket(id string, useVsock bool) (interface{}, error) {
+	if useVsock {
 		// We want to go through VSOCK. The VM VSOCK endpoint will be our gRPC.
-		k.Logger().Debug("agent: Using vsock VM socket endpoint")
 		// We dont know yet the context ID - set empty vsock configuration
-		k.vmSocket = kataVSOCK{}
-	} else {
-		k.Logger().Debug("agent: Using unix socket form VM socket endpoint")
-		// We need to generate a host UNIX socket path for the emulated serial port.
-		kataSock, err := utils.BuildSocketPath(k.getVMPath(id), defaultKataSocketName)
-		if err != nil {
-			return err
-		}
+		return kataVSOCK{}, nil
+	}
 
-		k.vmSocket = types.Socket{
-			DeviceID: defaultKataDeviceID,
-			ID:       defaultKataID,
-			HostPath: kataSock,
-			Name:     defaultKataChannel,
-		}
+	// We need to generate a host UNIX socket path for the emulated serial port.
+	kataSock, err := utils.BuildSocketPath(filepath.Join(store.RunVMStoragePath, id), defaultKataSocketName)
+	if err != nil {
+		return nil, err
 	}
 
+	return types.Socket{
+		DeviceID: defaultKataDeviceID,
+		ID:       defaultKataID,
+		HostPath: kataSock,
+		Name:     defaultKataChannel,
+	}, nil
+}
+
+func (k *kataAgent) generateVMSocket(id string, c KataAgentConfig) error {
+	return k.setupVMSocket(nil, id, c)
+}
+
+// setupVMSocket sets the socket the agent is reached through, which the
+// hypervisor h chooses. The vhost vsock or serial port one is used when h
+// is nil.
+func (k *kataAgent) setupVMSocket(h hypervisor, id string, c KataAgentConfig) error {
+	var (
+		vmSocket interface{}
+		err      error
+	)
+
+	if h != nil {
+		vmSocket, err = h.generateSocket(id, c.UseVSock)
+	} else {
+		vmSocket, err = generateVMSocket(id, c.UseVSock)
+	}
+	if err != nil {
+		return err
+	}
+
+	k.Logger().WithField("vm-socket", fmt.Sprintf("%T", vmSocket)).Debug("agent: Using VM socket endpoint")
+	k.vmSocket = vmSocket
+
 	return nil
 }
 
@@ -303,13 +415,30 @@ func (k *kataAgent) init(ctx context.Context, sandbox *Sandbox, config interface
 
 	switch c := config.(type) {
 	case KataAgentConfig:
-		if err := k.generateVMSocket(sandbox.id, c); err != nil {
+		if err := k.setupVMSocket(sandbox.hypervisor, sandbox.id, c); err != nil {
 			return false, err
 		}
 
+		// The kata-shim and the kata-proxy only dial unix and vsock URLs.
+		if _, ok := k.vmSocket.(kataHybridVSOCK); ok &&
+			(sandbox.config.ShimType == KataShimType || sandbox.config.ProxyType == KataProxyType) {
+			return false, fmt.Errorf("The hybrid vsock of %s is only supported by containerd-shim-kata-v2, not by the %s shim and the %s proxy",
+				sandbox.config.HypervisorType, sandbox.config.ShimType, sandbox.config.ProxyType)
+		}
+
 		disableVMShutdown = k.handleTraceSettings(c)
 		k.keepConn = c.LongLiveConn
+		k.dialTimeout = time.Duration(c.DialTimeout) * time.Second
+		if err := checkKernelModules(c.KernelModules); err != nil {
+			return false, err
+		}
 		k.kmodules = c.KernelModules
+
+		if c.Policy != "" {
+			if k.policy, err = ParseAgentPolicy(c.Policy); err != nil {
+				return false, err
+			}
+		}
 	default:
 		return false, vcTypes.ErrInvalidConfigType
 	}
@@ -342,6 +471,8 @@ func (k *kataAgent) agentURL() (string, error) {
 		return s.HostPath, nil
 	case kataVSOCK:
 		return s.String(), nil
+	case kataHybridVSOCK:
+		return s.String(), nil
 	default:
 		return "", fmt.Errorf("Invalid socket type")
 	}
@@ -360,10 +491,11 @@ func (k *kataAgent) internalConfigure(h hypervisor, id, sharePath string, builti
 	if config != nil {
 		switch c := config.(type) {
 		case KataAgentConfig:
-			if err := k.generateVMSocket(id, c); err != nil {
+			if err := k.setupVMSocket(h, id, c); err != nil {
 				return err
 			}
 			k.keepConn = c.LongLiveConn
+			k.dialTimeout = time.Duration(c.DialTimeout) * time.Second
 		default:
 			return vcTypes.ErrInvalidConfigType
 		}
@@ -393,11 +525,15 @@ func (k *kataAgent) configure(h hypervisor, id, sharePath string, builtin bool,
 		if err != nil {
 			return err
 		}
-		s.port = uint32(vSockPort)
+		s.port = vSockPort
 		if err = h.addDevice(s, vSockPCIDev); err != nil {
 			return err
 		}
 		k.vmSocket = s
+	case kataHybridVSOCK:
+		if err = h.addDevice(s, hybridVSockDev); err != nil {
+			return err
+		}
 	default:
 		return vcTypes.ErrInvalidConfigType
 	}
@@ -420,7 +556,18 @@ func (k *kataAgent) configure(h hypervisor, id, sharePath string, builtin bool,
 		return err
 	}
 
-	return h.addDevice(sharedVolume, fsDev)
+	if err = h.addDevice(sharedVolume, fsDev); err != nil {
+		return err
+	}
+
+	// virtio-fs volumes are shared through their own device.
+	for _, v := range h.hypervisorConfig().VirtioFSVolumes {
+		if err = h.addDevice(v, fsDev); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (k *kataAgent) configureFromGrpc(id string, builtin bool, config interface{}) error {
@@ -431,7 +578,47 @@ func (k *kataAgent) createSandbox(sandbox *Sandbox) error {
 	span, _ := k.trace("createSandbox")
 	defer span.Finish()
 
-	return k.configure(sandbox.hypervisor, sandbox.id, k.getSharePath(sandbox.id), k.proxyBuiltIn, nil)
+	if err := k.configure(sandbox.hypervisor, sandbox.id, k.getSharePath(sandbox.id), k.proxyBuiltIn, nil); err != nil {
+		return err
+	}
+
+	return k.registerVSockPorts(sandbox)
+}
+
+// registerVSockPorts records the vsock ports used by the agent services in
+// the sandbox vsock port registry.
+func (k *kataAgent) registerVSockPorts(sandbox *Sandbox) error {
+	var port uint32
+	switch s := k.vmSocket.(type) {
+	case kataVSOCK:
+		port = s.port
+	case kataHybridVSOCK:
+		port = s.port
+	default:
+		return nil
+	}
+
+	sandbox.Lock()
+	defer sandbox.Unlock()
+
+	if err := sandbox.state.VSockPorts.Register(types.VSockAgentService, port); err != nil {
+		return err
+	}
+
+	for _, p := range sandbox.config.HypervisorConfig.KernelParams {
+		if p.Key != "agent.debug_console_vport" {
+			continue
+		}
+
+		port, err := strconv.ParseUint(p.Value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid agent.debug_console_vport %q: %v", p.Value, err)
+		}
+
+		return sandbox.state.VSockPorts.Register(types.VSockDebugConsoleService, uint32(port))
+	}
+
+	return nil
 }
 
 func cmdToKataProcess(cmd types.Cmd) (process *grpc.Process, err error) {
@@ -708,6 +895,9 @@ func (k *kataAgent) reuseAgent(agent agent) error {
 		return fmt.Errorf("Bug: get a wrong type of agent")
 	}
 
+	k.Lock()
+	defer k.Unlock()
+
 	k.installReqFunc(a.client)
 	k.client = a.client
 	return nil
@@ -793,7 +983,8 @@ func (k *kataAgent) startSandbox(sandbox *Sandbox) error {
 	}
 
 	// check grpc server is serving
-	if err = k.check(); err != nil {
+	if err = runBootPhase(span, k.Logger(), bootPhaseAgent, k.check); err != nil {
+		err = k.diagnoseBootFailure(sandbox, err)
 		return err
 	}
 
@@ -840,6 +1031,87 @@ func (k *kataAgent) startSandbox(sandbox *Sandbox) error {
 	return nil
 }
 
+var kernelModuleRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// kernelModuleName returns the name of a kernel module as the guest kernel
+// knows it, dashes and underscores being interchangeable.
+func kernelModuleName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// diagnoseBootFailure returns why the agent of the sandbox is not serving,
+// if the hypervisor tells. The console output is the one the console
+// watcher read, when the console is watched, rather than a second reader
+// racing it on the console socket.
+func (k *kataAgent) diagnoseBootFailure(sandbox *Sandbox, err error) error {
+	d, ok := sandbox.hypervisor.(bootDiagnoser)
+	if !ok {
+		return err
+	}
+
+	return d.diagnoseBootFailure(err, func() (string, error) {
+		if w, ok := k.proxy.(consoleWatcher); ok {
+			if output, watched := w.consoleOutput(); watched {
+				return output, nil
+			}
+		}
+
+		console, err := sandbox.hypervisor.getSandboxConsole(sandbox.id)
+		if err != nil {
+			return "", err
+		}
+
+		return probeConsole(console)
+	})
+}
+
+// checkKernelModules returns an error if a kernel module of kmodules is not
+// a valid modprobe(8) module and parameters list.
+func checkKernelModules(kmodules []string) error {
+	for _, m := range kmodules {
+		l := strings.Fields(m)
+		if len(l) == 0 {
+			continue
+		}
+
+		if !kernelModuleRegexp.MatchString(l[0]) {
+			return fmt.Errorf("Invalid kernel module name %q", l[0])
+		}
+
+		// The parameters must not be mistaken for modprobe options.
+		for _, param := range l[1:] {
+			if strings.HasPrefix(param, "-") {
+				return fmt.Errorf("Invalid kernel module %s parameter %q", l[0], param)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckKernelModulesAllowed returns an error if a kernel module of kmodules
+// is not valid or is not listed by allowlist. No module is allowed when
+// allowlist is empty.
+func CheckKernelModulesAllowed(kmodules, allowlist []string) error {
+	if err := checkKernelModules(kmodules); err != nil {
+		return err
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range allowlist {
+		allowed[kernelModuleName(strings.TrimSpace(name))] = true
+	}
+
+	for _, m := range kmodules {
+		l := strings.Fields(m)
+		if len(l) > 0 && !allowed[kernelModuleName(l[0])] {
+			return fmt.Errorf("Kernel module %s is not allowed", l[0])
+		}
+	}
+
+	return nil
+}
+
 func setupKernelModules(kmodules []string) []*grpc.KernelModule {
 	modules := []*grpc.KernelModule{}
 
@@ -890,6 +1162,16 @@ func setupStorages(sandbox *Sandbox) []*grpc.Storage {
 			}
 
 			storages = append(storages, sharedVolume)
+
+			for _, v := range sandbox.config.HypervisorConfig.VirtioFSVolumes {
+				storages = append(storages, &grpc.Storage{
+					Driver:     kataVirtioFSDevType,
+					Source:     "none",
+					MountPoint: filepath.Join(kataGuestVolumesDir, v.MountTag),
+					Fstype:     typeVirtioFS,
+					Options:    virtioFSVolumeOptions(v.MountTag, sandbox.config.HypervisorConfig.VirtioFSCache != typeVirtioFSNoCache),
+				})
+			}
 		} else {
 			sharedDir9pOptions = append(sharedDir9pOptions, fmt.Sprintf("msize=%d", sandbox.config.HypervisorConfig.Msize9p))
 
@@ -923,6 +1205,281 @@ func setupStorages(sandbox *Sandbox) []*grpc.Storage {
 	return storages
 }
 
+// agentSupportsBlkSerial returns true if the agent can find virtio-blk
+// devices and storages by their serial number.
+func agentSupportsBlkSerial(details *grpc.AgentDetails) bool {
+	var device, storage bool
+
+	for _, h := range details.DeviceHandlers {
+		if h == kataBlkSerialDevType {
+			device = true
+		}
+	}
+
+	for _, h := range details.StorageHandlers {
+		if h == kataBlkSerialDevType {
+			storage = true
+		}
+	}
+
+	return device && storage
+}
+
+// useBlkSerial returns true if the agent should find drive by its serial
+// number rather than by its address.
+func useBlkSerial(sandbox *Sandbox, drive *config.BlockDrive) bool {
+	switch sandbox.config.HypervisorConfig.BlockDeviceDriver {
+	case config.VirtioBlock, config.VirtioBlockCCW:
+		return sandbox.state.GuestBlkSerial && drive.Serial != ""
+	}
+
+	return false
+}
+
+// agentSupportsLUKS returns true if the agent can open LUKS encrypted
+// storages.
+func agentSupportsLUKS(details *grpc.AgentDetails) bool {
+	for _, h := range details.StorageHandlers {
+		if h == kataLUKSDriverOption {
+			return true
+		}
+	}
+
+	return false
+}
+
+// agentSupportsIntegrity returns true if the agent can set up dm-integrity
+// targets on the storages.
+func agentSupportsIntegrity(details *grpc.AgentDetails) bool {
+	for _, h := range details.StorageHandlers {
+		if h == kataIntegrityDriverOption {
+			return true
+		}
+	}
+
+	return false
+}
+
+// agentSupportsVirtioPmem returns true if the agent can find virtio-pmem
+// devices and storages.
+func agentSupportsVirtioPmem(details *grpc.AgentDetails) bool {
+	var device, storage bool
+
+	for _, h := range details.DeviceHandlers {
+		if h == kataVirtioPmemDevType {
+			device = true
+		}
+	}
+
+	for _, h := range details.StorageHandlers {
+		if h == kataVirtioPmemDevType {
+			storage = true
+		}
+	}
+
+	return device && storage
+}
+
+// agentSupportsWatchableBind returns true if the agent can mirror the
+// watchable mounts from the shared directory into the guest.
+func agentSupportsWatchableBind(details *grpc.AgentDetails) bool {
+	for _, h := range details.StorageHandlers {
+		if h == kataWatchableBindDevType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkLUKSSupport returns an error if the agent of the sandbox cannot open
+// LUKS encrypted storages, which it would otherwise mount as they are.
+func checkLUKSSupport(sandbox *Sandbox) error {
+	if !sandbox.state.GuestLUKS {
+		return fmt.Errorf("The agent cannot open LUKS encrypted storages")
+	}
+
+	return nil
+}
+
+// checkIntegritySupport returns an error if the agent of the sandbox cannot
+// set up dm-integrity targets, which it would otherwise ignore.
+func checkIntegritySupport(sandbox *Sandbox) error {
+	if !sandbox.state.GuestIntegrity {
+		return fmt.Errorf("The agent cannot set up dm-integrity targets")
+	}
+
+	return nil
+}
+
+// maxLUKSKeySize bounds the size of the LUKS keys sent to the agent.
+const maxLUKSKeySize = 8192
+
+// readLUKSKey returns the LUKS key name from the key directory configured
+// by the operator, the only host directory the keys are read from.
+func readLUKSKey(keyDir, name string) ([]byte, error) {
+	if keyDir == "" {
+		return nil, fmt.Errorf("No LUKS key directory configured for the key %q", name)
+	}
+
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return nil, fmt.Errorf("Invalid LUKS key name %q", name)
+	}
+
+	path := filepath.Join(keyDir, name)
+
+	// Neither follow symbolic links out of the directory nor block on
+	// FIFOs.
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open the LUKS key %q: %v", name, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("LUKS key %q is not a regular file", name)
+	}
+
+	key, err := ioutil.ReadAll(io.LimitReader(f, maxLUKSKeySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Empty LUKS key %q", name)
+	}
+
+	if len(key) > maxLUKSKeySize {
+		return nil, fmt.Errorf("LUKS key %q is larger than %d bytes", name, maxLUKSKeySize)
+	}
+
+	return key, nil
+}
+
+// luksDriverOptions returns the storage driver options asking the agent to
+// open a LUKS encrypted volume with the key keyName from the key directory
+// of the sandbox.
+func luksDriverOptions(sandbox *Sandbox, keyName string) ([]string, error) {
+	if err := checkLUKSSupport(sandbox); err != nil {
+		return nil, err
+	}
+
+	key, err := readLUKSKey(sandbox.config.LUKSKeyDir, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	return luksKeyDriverOptions(key), nil
+}
+
+// luksKeyDriverOptions returns the storage driver options asking the agent
+// to open a LUKS encrypted device with key.
+func luksKeyDriverOptions(key []byte) []string {
+	return []string{kataLUKSDriverOption, luksKeyDriverOption + base64.StdEncoding.EncodeToString(key)}
+}
+
+// redactRequest returns a copy of request where the LUKS keys have been
+// replaced, so that they don't leak to the logs and traces.
+func redactRequest(request interface{}) interface{} {
+	req, ok := request.(*grpc.CreateContainerRequest)
+	if !ok {
+		return request
+	}
+
+	var redacted *grpc.CreateContainerRequest
+	for i, s := range req.Storages {
+		for j, opt := range s.DriverOptions {
+			if !strings.HasPrefix(opt, luksKeyDriverOption) {
+				continue
+			}
+
+			if redacted == nil {
+				redacted = proto.Clone(req).(*grpc.CreateContainerRequest)
+			}
+			redacted.Storages[i].DriverOptions[j] = luksKeyDriverOption + "<redacted>"
+		}
+	}
+
+	if redacted == nil {
+		return request
+	}
+
+	return redacted
+}
+
+// auditRequest returns the request of the agent to record in the audit log,
+// redacted and without the data of the streams, of the files and of the
+// entropy it carries. The environment of the processes and the annotations
+// of the containers, which can hold credentials, are not recorded either.
+func auditRequest(request interface{}) interface{} {
+	switch req := redactRequest(request).(type) {
+	case *grpc.CreateContainerRequest:
+		if req.OCI == nil {
+			return req
+		}
+		audited := proto.Clone(req).(*grpc.CreateContainerRequest)
+		audited.OCI.Annotations = nil
+		if audited.OCI.Process != nil {
+			audited.OCI.Process.Env = nil
+		}
+		return audited
+	case *grpc.ExecProcessRequest:
+		if req.Process == nil {
+			return req
+		}
+		audited := *req
+		process := *req.Process
+		process.Env = nil
+		audited.Process = &process
+		return &audited
+	case *grpc.WriteStreamRequest:
+		audited := *req
+		audited.Data = nil
+		return &audited
+	case *grpc.CopyFileRequest:
+		audited := *req
+		audited.Data = nil
+		return &audited
+	case *grpc.ReseedRandomDevRequest:
+		audited := *req
+		audited.Data = nil
+		return &audited
+	default:
+		return req
+	}
+}
+
+// virtioFSVolumeOptions returns the guest mount options of the virtio-fs
+// volume tagged tag.
+func virtioFSVolumeOptions(tag string, dax bool) []string {
+	options := []string{"default_permissions,allow_other,rootmode=040000,user_id=0,group_id=0,tag=" + tag, "nodev"}
+	if dax {
+		options = append(options, sharedDirVirtioFSDaxOptions)
+	}
+
+	return options
+}
+
+// virtioFSVolumeGuestPath returns the guest path of hostPath if it belongs to
+// one of the virtio-fs volumes.
+func virtioFSVolumeGuestPath(volumes []types.Volume, hostPath string) (string, bool) {
+	for _, v := range volumes {
+		rel, err := filepath.Rel(v.HostPath, hostPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		return filepath.Join(kataGuestVolumesDir, v.MountTag, rel), true
+	}
+
+	return "", false
+}
+
 func (k *kataAgent) stopSandbox(sandbox *Sandbox) error {
 	span, _ := k.trace("stopSandbox")
 	defer span.Finish()
@@ -1020,6 +1577,8 @@ func (k *kataAgent) replaceOCIMountsForStorages(spec *specs.Spec, volumeStorages
 
 			k.Logger().Debugf("Replacing OCI mount source (%s) with %s", m.Source, path)
 			ociMounts[index].Source = path
+			// The encryption and the image format are handled by the storage
+			ociMounts[index].Options = guestMountOptions(m.Options)
 			volumeStorages[i].MountPoint = path
 
 			break
@@ -1089,19 +1648,26 @@ func constraintGRPCSpec(grpcSpec *grpc.Spec, systemdCgroup bool, passSeccomp boo
 	grpcSpec.Linux.Namespaces = tmpNamespaces
 }
 
-func (k *kataAgent) handleShm(grpcSpec *grpc.Spec, sandbox *Sandbox) {
+// handleShm sets up the /dev/shm of the container, either the shm of the
+// sandbox or, for the private tmpfs ones, a tmpfs of devShmSize allocated
+// in the guest.
+func (k *kataAgent) handleShm(grpcSpec *grpc.Spec, sandbox *Sandbox, devShmSize uint) {
 	for idx, mnt := range grpcSpec.Mounts {
 		if mnt.Destination != "/dev/shm" {
 			continue
 		}
 
-		if sandbox.shmSize > 0 {
+		if sandbox.shmSize > 0 && mnt.Type != "tmpfs" {
 			grpcSpec.Mounts[idx].Type = "bind"
 			grpcSpec.Mounts[idx].Options = []string{"rbind"}
 			grpcSpec.Mounts[idx].Source = filepath.Join(kataGuestSandboxDir, shmDir)
 			k.Logger().WithField("shm-size", sandbox.shmSize).Info("Using sandbox shm")
 		} else {
-			sizeOption := fmt.Sprintf("size=%d", DefaultShmSize)
+			size := uint(DefaultShmSize)
+			if devShmSize > 0 {
+				size = devShmSize
+			}
+			sizeOption := fmt.Sprintf("size=%d", size)
 			grpcSpec.Mounts[idx].Type = "tmpfs"
 			grpcSpec.Mounts[idx].Source = "shm"
 			grpcSpec.Mounts[idx].Options = []string{"noexec", "nosuid", "nodev", "mode=1777", sizeOption}
@@ -1137,18 +1703,26 @@ func (k *kataAgent) appendDevices(deviceList []*grpc.Device, c *Container) []*gr
 			kataDevice.Type = kataMmioBlkDevType
 			kataDevice.Id = d.VirtPath
 			kataDevice.VmPath = d.VirtPath
-		case config.VirtioBlockCCW:
-			kataDevice.Type = kataBlkCCWDevType
-			kataDevice.Id = d.DevNo
-		case config.VirtioBlock:
-			kataDevice.Type = kataBlkDevType
-			kataDevice.Id = d.PCIAddr
+		case config.VirtioBlockCCW, config.VirtioBlock:
+			if useBlkSerial(c.sandbox, d) {
+				kataDevice.Type = kataBlkSerialDevType
+				kataDevice.Id = d.Serial
+			} else if c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlockCCW {
+				kataDevice.Type = kataBlkCCWDevType
+				kataDevice.Id = d.DevNo
+			} else {
+				kataDevice.Type = kataBlkDevType
+				kataDevice.Id = d.PCIAddr
+			}
 		case config.VirtioSCSI:
 			kataDevice.Type = kataSCSIDevType
 			kataDevice.Id = d.SCSIAddr
 		case config.Nvdimm:
 			kataDevice.Type = kataNvdimmDevType
 			kataDevice.VmPath = fmt.Sprintf("/dev/pmem%s", d.NvdimmID)
+		case config.VirtioPmem:
+			kataDevice.Type = kataVirtioPmemDevType
+			kataDevice.Id = d.PCIAddr
 		}
 
 		deviceList = append(deviceList, kataDevice)
@@ -1173,6 +1747,112 @@ func (k *kataAgent) rollbackFailingContainerCreation(c *Container) {
 	}
 }
 
+// blockDeviceStorage returns the storage of the block device deviceID,
+// without its mount point and file system.
+func (k *kataAgent) blockDeviceStorage(sandbox *Sandbox, deviceID string) (*grpc.Storage, error) {
+	storage := &grpc.Storage{}
+
+	device := sandbox.devManager.GetDeviceByID(deviceID)
+	if device == nil {
+		k.Logger().WithField("device", deviceID).Error("failed to find device by id")
+		return nil, fmt.Errorf("failed to find device by id %q", deviceID)
+	}
+
+	blockDrive, ok := device.GetDeviceInfo().(*config.BlockDrive)
+	if !ok || blockDrive == nil {
+		k.Logger().Error("malformed block drive")
+		return nil, fmt.Errorf("malformed block drive")
+	}
+	switch {
+	case useBlkSerial(sandbox, blockDrive):
+		storage.Driver = kataBlkSerialDevType
+		storage.Source = blockDrive.Serial
+	case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioMmio:
+		storage.Driver = kataMmioBlkDevType
+		storage.Source = blockDrive.VirtPath
+	case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlockCCW:
+		storage.Driver = kataBlkCCWDevType
+		storage.Source = blockDrive.DevNo
+	case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlock:
+		storage.Driver = kataBlkDevType
+		if blockDrive.PCIAddr == "" {
+			storage.Source = blockDrive.VirtPath
+		} else {
+			storage.Source = blockDrive.PCIAddr
+		}
+	case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioSCSI:
+		storage.Driver = kataSCSIDevType
+		storage.Source = blockDrive.SCSIAddr
+	case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioPmem:
+		storage.Driver = kataVirtioPmemDevType
+		storage.Source = blockDrive.PCIAddr
+	default:
+		return nil, fmt.Errorf("Unknown block device driver: %s", sandbox.config.HypervisorConfig.BlockDeviceDriver)
+	}
+
+	// Let the agent format the drive with a dm-integrity target and
+	// the filesystem of the storage on top of it.
+	if blockDrive.Integrity != "" {
+		storage.DriverOptions = []string{kataIntegrityDriverOption, integrityAlgDriverOption + blockDrive.Integrity}
+	}
+
+	return storage, nil
+}
+
+// scratchStorage returns the storage of the scratch disk of the container,
+// mounted under rootPathParent. The agent creates dirs in the filesystem it
+// formats on the scratch disks protected by dm-integrity.
+func (k *kataAgent) scratchStorage(sandbox *Sandbox, c *Container, rootPathParent string, dirs ...string) (*grpc.Storage, error) {
+	scratch, err := k.blockDeviceStorage(sandbox, c.state.ScratchDeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch.MountPoint = filepath.Join(rootPathParent, layeredRootfsScratchDir)
+	scratch.Fstype = rootfsScratchFstype
+
+	if len(scratch.DriverOptions) > 0 && len(dirs) > 0 {
+		scratch.DriverOptions = append(scratch.DriverOptions, integrityDirsDriverOption+strings.Join(dirs, ","))
+	}
+
+	return scratch, nil
+}
+
+// buildLayeredRootfs returns the storages of a rootfs layered on a read-only
+// image: the image and the scratch disk, mounted under rootPathParent, and
+// the overlay stacking them on the rootfs path. The overlay is mounted as
+// is by the ephemeral storage handler of the agent, which creates its mount
+// point, once the storages it depends on are mounted.
+func (k *kataAgent) buildLayeredRootfs(sandbox *Sandbox, c *Container, rootPathParent string) ([]*grpc.Storage, error) {
+	lower, err := k.blockDeviceStorage(sandbox, c.state.BlockDeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	lower.MountPoint = filepath.Join(rootPathParent, layeredRootfsLowerDir)
+	lower.Fstype = c.state.Fstype
+	lower.Options = []string{"ro"}
+
+	scratch, err := k.scratchStorage(sandbox, c, rootPathParent, layeredRootfsUpperDir, layeredRootfsWorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay := &grpc.Storage{
+		Driver: KataEphemeralDevType,
+		Source: "overlay",
+		Fstype: "overlay",
+		Options: []string{
+			"lowerdir=" + lower.MountPoint,
+			"upperdir=" + filepath.Join(scratch.MountPoint, layeredRootfsUpperDir),
+			"workdir=" + filepath.Join(scratch.MountPoint, layeredRootfsWorkDir),
+		},
+		MountPoint: filepath.Join(rootPathParent, c.rootfsSuffix),
+	}
+
+	return []*grpc.Storage{lower, scratch, overlay}, nil
+}
+
 func (k *kataAgent) buildContainerRootfs(sandbox *Sandbox, c *Container, rootPathParent string) (*grpc.Storage, error) {
 	if c.state.Fstype != "" && c.state.BlockDeviceID != "" {
 		// The rootfs storage volume represents the container rootfs
@@ -1180,41 +1860,10 @@ func (k *kataAgent) buildContainerRootfs(sandbox *Sandbox, c *Container, rootPat
 		// It can be a block based device (when using block based container
 		// overlay on the host) mount or a 9pfs one (for all other overlay
 		// implementations).
-		rootfs := &grpc.Storage{}
-
 		// This is a block based device rootfs.
-		device := sandbox.devManager.GetDeviceByID(c.state.BlockDeviceID)
-		if device == nil {
-			k.Logger().WithField("device", c.state.BlockDeviceID).Error("failed to find device by id")
-			return nil, fmt.Errorf("failed to find device by id %q", c.state.BlockDeviceID)
-		}
-
-		blockDrive, ok := device.GetDeviceInfo().(*config.BlockDrive)
-		if !ok || blockDrive == nil {
-			k.Logger().Error("malformed block drive")
-			return nil, fmt.Errorf("malformed block drive")
-		}
-		switch {
-		case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioMmio:
-			rootfs.Driver = kataMmioBlkDevType
-			rootfs.Source = blockDrive.VirtPath
-		case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlockCCW:
-			rootfs.Driver = kataBlkCCWDevType
-			rootfs.Source = blockDrive.DevNo
-		case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlock:
-			rootfs.Driver = kataBlkDevType
-			if blockDrive.PCIAddr == "" {
-				rootfs.Source = blockDrive.VirtPath
-			} else {
-				rootfs.Source = blockDrive.PCIAddr
-			}
-
-		case sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioSCSI:
-
-			rootfs.Driver = kataSCSIDevType
-			rootfs.Source = blockDrive.SCSIAddr
-		default:
-			return nil, fmt.Errorf("Unknown block device driver: %s", sandbox.config.HypervisorConfig.BlockDeviceDriver)
+		rootfs, err := k.blockDeviceStorage(sandbox, c.state.BlockDeviceID)
+		if err != nil {
+			return nil, err
 		}
 
 		rootfs.MountPoint = rootPathParent
@@ -1224,6 +1873,23 @@ func (k *kataAgent) buildContainerRootfs(sandbox *Sandbox, c *Container, rootPat
 			rootfs.Options = []string{"nouuid"}
 		}
 
+		// Let the agent open the encrypted rootfs, which can not be
+		// mapped straight from the device.
+		if c.rootfsEncrypted() {
+			if rootfs.DriverOptions, err = c.rootfsLUKSDriverOptions(); err != nil {
+				k.Logger().WithField("container", c.id).WithError(err).Error("failed to get the rootfs LUKS key")
+				return nil, err
+			}
+
+			return rootfs, nil
+		}
+
+		// Map the rootfs straight from the device, bypassing the guest
+		// page cache.
+		if rootfs.Driver == kataVirtioPmemDevType && (c.state.Fstype == "ext4" || c.state.Fstype == "xfs") {
+			rootfs.Options = append(rootfs.Options, "dax")
+		}
+
 		return rootfs, nil
 	}
 
@@ -1242,6 +1908,33 @@ func (k *kataAgent) buildContainerRootfs(sandbox *Sandbox, c *Container, rootPat
 	return nil, nil
 }
 
+// shareContainerFiles bind mounts the rootfs and the mounts of the container
+// in the shared directory of the sandbox, which is empty on the host the
+// sandbox has been migrated to. The mounts are bound at the host paths they
+// had on the source host, so that the guest finds them at the same paths.
+func (k *kataAgent) shareContainerFiles(sandbox *Sandbox, c *Container) error {
+	rootPathParent := filepath.Join(kataGuestSharedDir, c.id)
+	if _, err := k.buildContainerRootfs(sandbox, c, rootPathParent); err != nil {
+		return err
+	}
+
+	for _, m := range c.mounts {
+		if m.HostPath == "" || len(m.BlockDeviceID) > 0 {
+			continue
+		}
+
+		if err := bindMount(k.ctx, m.Source, m.HostPath, false); err != nil {
+			return err
+		}
+	}
+
+	if !sandbox.supportNewStore() {
+		return c.storeMounts()
+	}
+
+	return nil
+}
+
 func (k *kataAgent) hasAgentDebugConsole(sandbox *Sandbox) bool {
 	for _, p := range sandbox.config.HypervisorConfig.KernelParams {
 		if p.Key == "agent.debug_console" {
@@ -1272,7 +1965,13 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		}
 	}()
 
-	if rootfs, err = k.buildContainerRootfs(sandbox, c, rootPathParent); err != nil {
+	if c.state.ScratchDeviceID != "" {
+		layers, err := k.buildLayeredRootfs(sandbox, c, rootPathParent)
+		if err != nil {
+			return nil, err
+		}
+		ctrStorages = append(ctrStorages, layers...)
+	} else if rootfs, err = k.buildContainerRootfs(sandbox, c, rootPathParent); err != nil {
 		return nil, err
 	} else if rootfs != nil {
 		// Add rootfs to the list of container storage.
@@ -1287,6 +1986,10 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		return nil, errorMissingOCISpec
 	}
 
+	if err = checkUserNamespace(ociSpec); err != nil {
+		return nil, err
+	}
+
 	// Handle container mounts
 	newMounts, ignoredMounts, err := c.mountSharedDirMounts(kataHostSharedDir, kataGuestSharedDir)
 	if err != nil {
@@ -1299,6 +2002,9 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 	localStorages := k.handleLocalStorage(ociSpec.Mounts, sandbox.id, c.rootfsSuffix)
 	ctrStorages = append(ctrStorages, localStorages...)
 
+	watchableStorages := k.handleWatchableMounts(c)
+	ctrStorages = append(ctrStorages, watchableStorages...)
+
 	// We replace all OCI mount sources that match our container mount
 	// with the right source path (The guest one).
 	if err = k.replaceOCIMountSource(ociSpec, newMounts); err != nil {
@@ -1335,6 +2041,11 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 	// We need to give the OCI spec our absolute rootfs path in the guest.
 	grpcSpec.Root.Path = rootPath
 
+	if hasUserNamespace(ociSpec) {
+		grpcSpec.Linux.UIDMappings = idMappingsToGRPC(ociSpec.Linux.UIDMappings)
+		grpcSpec.Linux.GIDMappings = idMappingsToGRPC(ociSpec.Linux.GIDMappings)
+	}
+
 	sharedPidNs := k.handlePidNamespace(grpcSpec, sandbox)
 
 	passSeccomp := !sandbox.config.DisableGuestSeccomp && sandbox.seccompSupported
@@ -1343,7 +2054,7 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 	// irrelevant information to the agent.
 	constraintGRPCSpec(grpcSpec, sandbox.config.SystemdCgroup, passSeccomp)
 
-	k.handleShm(grpcSpec, sandbox)
+	k.handleShm(grpcSpec, sandbox, c.systemMountsInfo.DevShmSize)
 
 	req := &grpc.CreateContainerRequest{
 		ContainerId:  c.id,
@@ -1355,6 +2066,9 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 	}
 
 	if _, err = k.sendReq(req); err != nil {
+		if hasUserNamespace(ociSpec) {
+			return nil, fmt.Errorf("Could not create the container in its user namespace, the guest kernel may not support user namespaces: %v", err)
+		}
 		return nil, err
 	}
 
@@ -1437,6 +2151,28 @@ func (k *kataAgent) handleLocalStorage(mounts []specs.Mount, sandboxID string, r
 	return localStorages
 }
 
+// handleWatchableMounts lets the agent mirror the watchable mounts of the
+// container from the shared directory into a guest tmpfs. It keeps their
+// layout, so that the updates kubelet makes by swapping the ..data symbolic
+// link are atomic in the guest, and trigger inotify events there.
+func (k *kataAgent) handleWatchableMounts(c *Container) []*grpc.Storage {
+	var watchableStorages []*grpc.Storage
+	for _, m := range c.mounts {
+		if m.WatchableSource == "" {
+			continue
+		}
+
+		watchableStorages = append(watchableStorages, &grpc.Storage{
+			Driver:     kataWatchableBindDevType,
+			Source:     m.WatchableSource,
+			Fstype:     "bind",
+			Options:    []string{"bind"},
+			MountPoint: watchableGuestPath(m.WatchableSource),
+		})
+	}
+	return watchableStorages
+}
+
 // handleBlockVolumes handles volumes that are block devices files
 // by passing the block devices as Storage to the agent.
 func (k *kataAgent) handleBlockVolumes(c *Container) ([]*grpc.Storage, error) {
@@ -1461,43 +2197,86 @@ func (k *kataAgent) handleBlockVolumes(c *Container) ([]*grpc.Storage, error) {
 			}
 		}
 
-		vol := &grpc.Storage{}
-
-		device := c.sandbox.devManager.GetDeviceByID(id)
-		if device == nil {
-			k.Logger().WithField("device", id).Error("failed to find device by id")
-			return nil, fmt.Errorf("Failed to find device by id (id=%s)", id)
-		}
-		blockDrive, ok := device.GetDeviceInfo().(*config.BlockDrive)
-		if !ok || blockDrive == nil {
-			k.Logger().Error("malformed block drive")
-			continue
+		vol, err := k.blockVolumeStorage(c, m)
+		if err != nil {
+			return nil, err
 		}
-		switch {
-		case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlockCCW:
-			vol.Driver = kataBlkCCWDevType
-			vol.Source = blockDrive.DevNo
-		case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlock:
-			vol.Driver = kataBlkDevType
-			vol.Source = blockDrive.PCIAddr
-		case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioMmio:
-			vol.Driver = kataMmioBlkDevType
-			vol.Source = blockDrive.VirtPath
-		case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioSCSI:
-			vol.Driver = kataSCSIDevType
-			vol.Source = blockDrive.SCSIAddr
-		default:
-			return nil, fmt.Errorf("Unknown block device driver: %s", c.sandbox.config.HypervisorConfig.BlockDeviceDriver)
+
+		if vol != nil {
+			volumeStorages = append(volumeStorages, vol)
 		}
+	}
+
+	return volumeStorages, nil
+}
+
+// blockVolumeStorage returns the storage of the block volume m, nil if its
+// device is not a block drive.
+func (k *kataAgent) blockVolumeStorage(c *Container, m Mount) (*grpc.Storage, error) {
+	id := m.BlockDeviceID
+	vol := &grpc.Storage{}
 
-		vol.MountPoint = m.Destination
+	device := c.sandbox.devManager.GetDeviceByID(id)
+	if device == nil {
+		k.Logger().WithField("device", id).Error("failed to find device by id")
+		return nil, fmt.Errorf("Failed to find device by id (id=%s)", id)
+	}
+	blockDrive, ok := device.GetDeviceInfo().(*config.BlockDrive)
+	if !ok || blockDrive == nil {
+		k.Logger().Error("malformed block drive")
+		return nil, nil
+	}
+	switch {
+	case useBlkSerial(c.sandbox, blockDrive):
+		vol.Driver = kataBlkSerialDevType
+		vol.Source = blockDrive.Serial
+	case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlockCCW:
+		vol.Driver = kataBlkCCWDevType
+		vol.Source = blockDrive.DevNo
+	case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlock:
+		vol.Driver = kataBlkDevType
+		vol.Source = blockDrive.PCIAddr
+	case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioMmio:
+		vol.Driver = kataMmioBlkDevType
+		vol.Source = blockDrive.VirtPath
+	case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioSCSI:
+		vol.Driver = kataSCSIDevType
+		vol.Source = blockDrive.SCSIAddr
+	case c.sandbox.config.HypervisorConfig.BlockDeviceDriver == config.VirtioPmem:
+		vol.Driver = kataVirtioPmemDevType
+		vol.Source = blockDrive.PCIAddr
+	default:
+		return nil, fmt.Errorf("Unknown block device driver: %s", c.sandbox.config.HypervisorConfig.BlockDeviceDriver)
+	}
+
+	vol.MountPoint = m.Destination
+
+	keyName, fstype, options := luksMountOptions(m.Options)
+	switch {
+	case keyName == "" && m.BlockFstype != "":
+		// Let the agent mount the filesystem of the direct-assigned
+		// volume, as it was on the host.
+		vol.Fstype = m.BlockFstype
+		vol.Options = directVolumeMountOptions(m.Options)
+	case keyName == "":
 		vol.Fstype = "bind"
 		vol.Options = []string{"bind"}
-
-		volumeStorages = append(volumeStorages, vol)
+	default:
+		// Let the agent open the encrypted volume and mount
+		// its filesystem.
+		driverOptions, err := luksDriverOptions(c.sandbox, keyName)
+		if err != nil {
+			k.Logger().WithField("device", id).WithError(err).Error("failed to get the LUKS key")
+			return nil, err
+		}
+		vol.DriverOptions = driverOptions
+		vol.Fstype = fstype
+		if isReadOnlyMount(options) {
+			vol.Options = []string{"ro"}
+		}
 	}
 
-	return volumeStorages, nil
+	return vol, nil
 }
 
 // handlePidNamespace checks if Pid namespace for a container needs to be shared with its sandbox
@@ -1698,10 +2477,20 @@ func (k *kataAgent) statsContainer(sandbox *Sandbox, c Container) (*ContainerSta
 }
 
 func (k *kataAgent) connect() error {
+	k.Lock()
+	defer k.Unlock()
+
+	return k.connectLocked()
+}
+
+// connectLocked connects to the agent, if not connected yet. It is called
+// with the lock held.
+func (k *kataAgent) connectLocked() error {
+	k.waitReconnection()
+
 	if k.dead {
 		return errors.New("Dead agent")
 	}
-	// lockless quick pass
 	if k.client != nil {
 		return nil
 	}
@@ -1709,33 +2498,210 @@ func (k *kataAgent) connect() error {
 	span, _ := k.trace("connect")
 	defer span.Finish()
 
-	// This is for the first connection only, to prevent race
-	k.Lock()
-	defer k.Unlock()
-	if k.client != nil {
-		return nil
+	client, err := k.dial(k.dialTimeout)
+	if err != nil {
+		k.dead = true
+		k.conn = AgentUnreachable
+		return err
 	}
 
+	k.installReqFunc(client)
+	k.client = client
+	k.conn = AgentHealthy
+
+	return nil
+}
+
+// dial creates a new client connected to the agent, waiting at most
+// timeout for the agent, or the agent client default when zero. It only
+// reads the agent state, hence does not require the lock.
+func (k *kataAgent) dial(timeout time.Duration) (agentClient, error) {
 	if k.state.ProxyPid > 0 {
 		// check that proxy is running before talk with it avoiding long timeouts
 		if err := syscall.Kill(k.state.ProxyPid, syscall.Signal(0)); err != nil {
-			return errors.New("Proxy is not running")
+			return nil, errors.New("Proxy is not running")
 		}
 	}
 
+	ctx := k.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	k.Logger().WithField("url", k.state.URL).WithField("proxy", k.state.ProxyPid).Info("New client")
-	client, err := kataclient.NewAgentClient(k.ctx, k.state.URL, k.proxyBuiltIn)
-	if err != nil {
-		k.dead = true
-		return err
+
+	// The hybrid vsock is reached directly, never through a proxy.
+	if strings.HasPrefix(k.state.URL, hybridVSockScheme+"://") {
+		client, err := hvsock.NewAgentClient(ctx, k.state.URL)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
 	}
 
-	k.installReqFunc(client)
-	k.client = client
+	// The agent client bounds each dial to its own default timeout: the
+	// longer ones are honoured by dialing again until they expire.
+	for {
+		client, err := kataclient.NewAgentClient(ctx, k.state.URL, k.proxyBuiltIn)
+		if err == nil {
+			return client, nil
+		}
+
+		if timeout == 0 || err != context.DeadlineExceeded || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// hold connects to the agent, if needed, and keeps the connection open
+// until release is called, so that concurrent requests share it.
+func (k *kataAgent) hold() error {
+	k.Lock()
+	defer k.Unlock()
+
+	if err := k.connectLocked(); err != nil {
+		return err
+	}
+	k.inflight++
 
 	return nil
 }
 
+// reqHandler returns the client connected to the agent and its handler of
+// the msgName requests.
+func (k *kataAgent) reqHandler(msgName string) (agentClient, reqFunc) {
+	k.Lock()
+	defer k.Unlock()
+
+	return k.client, k.reqHandlers[msgName]
+}
+
+// release closes the connection held by hold once no request uses it
+// anymore, unless it is kept open.
+func (k *kataAgent) release() {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.inflight > 0 {
+		k.inflight--
+	}
+
+	if k.inflight == 0 && !k.keepConn {
+		if err := k.closeClient(); err != nil {
+			k.Logger().WithError(err).Warn("Could not close the agent connection")
+		}
+	}
+}
+
+// reconnect replaces the lost client by a new one connected to the agent,
+// unless another request did it already. The connection is attempted again
+// with an exponential backoff, at most agentReconnectAttempts times. The
+// lock is released between the attempts so that the other requests wait
+// for the reconnection without blocking the callers of health.
+func (k *kataAgent) reconnect(lost agentClient) error {
+	k.Lock()
+
+	if k.reconnected != nil {
+		k.waitReconnection()
+		defer k.Unlock()
+
+		if k.dead {
+			return errors.New("Dead agent")
+		}
+		if k.client == nil {
+			return errors.New("Could not reconnect to the agent")
+		}
+		return nil
+	}
+
+	if k.dead {
+		k.Unlock()
+		return errors.New("Dead agent")
+	}
+
+	if k.client != nil && k.client != lost {
+		k.Unlock()
+		return nil
+	}
+
+	if err := k.closeClient(); err != nil {
+		k.Logger().WithError(err).Debug("Could not close the lost agent connection")
+	}
+	k.conn = AgentReconnecting
+	reconnected := make(chan struct{})
+	k.reconnected = reconnected
+	k.Unlock()
+
+	err := k.redial()
+
+	k.Lock()
+	k.reconnected = nil
+	close(reconnected)
+	k.Unlock()
+
+	return err
+}
+
+// redial connects to the agent again for reconnect, sleeping between the
+// attempts without the lock held.
+func (k *kataAgent) redial() error {
+	backoff := agentReconnectBackoff
+	for attempt := 1; ; attempt++ {
+		client, err := k.dial(agentReconnectDialTimeout)
+
+		k.Lock()
+		if k.dead {
+			k.Unlock()
+			if client != nil {
+				client.Close()
+			}
+			return errors.New("Dead agent")
+		}
+
+		if err == nil {
+			k.installReqFunc(client)
+			k.client = client
+			k.conn = AgentHealthy
+			k.Unlock()
+			k.Logger().WithField("attempts", attempt).Info("Reconnected to the agent")
+			return nil
+		}
+
+		if attempt >= agentReconnectAttempts {
+			k.conn = AgentUnreachable
+			k.Unlock()
+			return fmt.Errorf("Could not reconnect to the agent after %d attempts: %v", attempt, err)
+		}
+		k.Unlock()
+
+		k.Logger().WithError(err).WithField("attempt", attempt).Warn("Could not reconnect to the agent")
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > agentReconnectMaxBackoff {
+			backoff = agentReconnectMaxBackoff
+		}
+	}
+}
+
+// waitReconnection waits for the pending reconnection, if any, to end. It
+// is called with the lock held, which is released while waiting.
+func (k *kataAgent) waitReconnection() {
+	for k.reconnected != nil {
+		reconnected := k.reconnected
+		k.Unlock()
+		<-reconnected
+		k.Lock()
+	}
+}
+
+// agentConnectionLost returns whether a request failed because the
+// connection to the agent was lost.
+func agentConnectionLost(err error) bool {
+	return grpcStatus.Code(err) == codes.Unavailable
+}
+
 func (k *kataAgent) disconnect() error {
 	span, _ := k.trace("disconnect")
 	defer span.Finish()
@@ -1743,6 +2709,12 @@ func (k *kataAgent) disconnect() error {
 	k.Lock()
 	defer k.Unlock()
 
+	return k.closeClient()
+}
+
+// closeClient closes the client connected to the agent. It is called with
+// the lock held.
+func (k *kataAgent) closeClient() error {
 	if k.client == nil {
 		return nil
 	}
@@ -1757,6 +2729,18 @@ func (k *kataAgent) disconnect() error {
 	return nil
 }
 
+// health returns the state of the connection to the agent.
+func (k *kataAgent) health() AgentHealth {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.dead {
+		return AgentUnreachable
+	}
+
+	return k.conn
+}
+
 // check grpc server is serving
 func (k *kataAgent) check() error {
 	span, _ := k.trace("check")
@@ -1817,94 +2801,94 @@ func (k *kataAgent) reseedRNG(data []byte) error {
 
 type reqFunc func(context.Context, interface{}, ...golangGrpc.CallOption) (interface{}, error)
 
-func (k *kataAgent) installReqFunc(c *kataclient.AgentClient) {
+func (k *kataAgent) installReqFunc(c agentClient) {
 	k.reqHandlers = make(map[string]reqFunc)
 	k.reqHandlers[grpcCheckRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.Check(ctx, req.(*grpc.CheckRequest), opts...)
+		return c.Check(ctx, req.(*grpc.CheckRequest), opts...)
 	}
 	k.reqHandlers[grpcExecProcessRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ExecProcess(ctx, req.(*grpc.ExecProcessRequest), opts...)
+		return c.ExecProcess(ctx, req.(*grpc.ExecProcessRequest), opts...)
 	}
 	k.reqHandlers[grpcCreateSandboxRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.CreateSandbox(ctx, req.(*grpc.CreateSandboxRequest), opts...)
+		return c.CreateSandbox(ctx, req.(*grpc.CreateSandboxRequest), opts...)
 	}
 	k.reqHandlers[grpcDestroySandboxRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.DestroySandbox(ctx, req.(*grpc.DestroySandboxRequest), opts...)
+		return c.DestroySandbox(ctx, req.(*grpc.DestroySandboxRequest), opts...)
 	}
 	k.reqHandlers[grpcCreateContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.CreateContainer(ctx, req.(*grpc.CreateContainerRequest), opts...)
+		return c.CreateContainer(ctx, req.(*grpc.CreateContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcStartContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.StartContainer(ctx, req.(*grpc.StartContainerRequest), opts...)
+		return c.StartContainer(ctx, req.(*grpc.StartContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcRemoveContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.RemoveContainer(ctx, req.(*grpc.RemoveContainerRequest), opts...)
+		return c.RemoveContainer(ctx, req.(*grpc.RemoveContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcSignalProcessRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.SignalProcess(ctx, req.(*grpc.SignalProcessRequest), opts...)
+		return c.SignalProcess(ctx, req.(*grpc.SignalProcessRequest), opts...)
 	}
 	k.reqHandlers[grpcUpdateRoutesRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.UpdateRoutes(ctx, req.(*grpc.UpdateRoutesRequest), opts...)
+		return c.UpdateRoutes(ctx, req.(*grpc.UpdateRoutesRequest), opts...)
 	}
 	k.reqHandlers[grpcUpdateInterfaceRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.UpdateInterface(ctx, req.(*grpc.UpdateInterfaceRequest), opts...)
+		return c.UpdateInterface(ctx, req.(*grpc.UpdateInterfaceRequest), opts...)
 	}
 	k.reqHandlers[grpcListInterfacesRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ListInterfaces(ctx, req.(*grpc.ListInterfacesRequest), opts...)
+		return c.ListInterfaces(ctx, req.(*grpc.ListInterfacesRequest), opts...)
 	}
 	k.reqHandlers[grpcListRoutesRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ListRoutes(ctx, req.(*grpc.ListRoutesRequest), opts...)
+		return c.ListRoutes(ctx, req.(*grpc.ListRoutesRequest), opts...)
 	}
 	k.reqHandlers[grpcOnlineCPUMemRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.OnlineCPUMem(ctx, req.(*grpc.OnlineCPUMemRequest), opts...)
+		return c.OnlineCPUMem(ctx, req.(*grpc.OnlineCPUMemRequest), opts...)
 	}
 	k.reqHandlers[grpcListProcessesRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ListProcesses(ctx, req.(*grpc.ListProcessesRequest), opts...)
+		return c.ListProcesses(ctx, req.(*grpc.ListProcessesRequest), opts...)
 	}
 	k.reqHandlers[grpcUpdateContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.UpdateContainer(ctx, req.(*grpc.UpdateContainerRequest), opts...)
+		return c.UpdateContainer(ctx, req.(*grpc.UpdateContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcWaitProcessRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.WaitProcess(ctx, req.(*grpc.WaitProcessRequest), opts...)
+		return c.WaitProcess(ctx, req.(*grpc.WaitProcessRequest), opts...)
 	}
 	k.reqHandlers[grpcTtyWinResizeRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.TtyWinResize(ctx, req.(*grpc.TtyWinResizeRequest), opts...)
+		return c.TtyWinResize(ctx, req.(*grpc.TtyWinResizeRequest), opts...)
 	}
 	k.reqHandlers[grpcWriteStreamRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.WriteStdin(ctx, req.(*grpc.WriteStreamRequest), opts...)
+		return c.WriteStdin(ctx, req.(*grpc.WriteStreamRequest), opts...)
 	}
 	k.reqHandlers[grpcCloseStdinRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.CloseStdin(ctx, req.(*grpc.CloseStdinRequest), opts...)
+		return c.CloseStdin(ctx, req.(*grpc.CloseStdinRequest), opts...)
 	}
 	k.reqHandlers[grpcStatsContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.StatsContainer(ctx, req.(*grpc.StatsContainerRequest), opts...)
+		return c.StatsContainer(ctx, req.(*grpc.StatsContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcPauseContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.PauseContainer(ctx, req.(*grpc.PauseContainerRequest), opts...)
+		return c.PauseContainer(ctx, req.(*grpc.PauseContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcResumeContainerRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ResumeContainer(ctx, req.(*grpc.ResumeContainerRequest), opts...)
+		return c.ResumeContainer(ctx, req.(*grpc.ResumeContainerRequest), opts...)
 	}
 	k.reqHandlers[grpcReseedRandomDevRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.ReseedRandomDev(ctx, req.(*grpc.ReseedRandomDevRequest), opts...)
+		return c.ReseedRandomDev(ctx, req.(*grpc.ReseedRandomDevRequest), opts...)
 	}
 	k.reqHandlers[grpcGuestDetailsRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.GetGuestDetails(ctx, req.(*grpc.GuestDetailsRequest), opts...)
+		return c.GetGuestDetails(ctx, req.(*grpc.GuestDetailsRequest), opts...)
 	}
 	k.reqHandlers[grpcMemHotplugByProbeRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.MemHotplugByProbe(ctx, req.(*grpc.MemHotplugByProbeRequest), opts...)
+		return c.MemHotplugByProbe(ctx, req.(*grpc.MemHotplugByProbeRequest), opts...)
 	}
 	k.reqHandlers[grpcCopyFileRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.CopyFile(ctx, req.(*grpc.CopyFileRequest), opts...)
+		return c.CopyFile(ctx, req.(*grpc.CopyFileRequest), opts...)
 	}
 	k.reqHandlers[grpcSetGuestDateTimeRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.SetGuestDateTime(ctx, req.(*grpc.SetGuestDateTimeRequest), opts...)
+		return c.SetGuestDateTime(ctx, req.(*grpc.SetGuestDateTimeRequest), opts...)
 	}
 	k.reqHandlers[grpcStartTracingRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.StartTracing(ctx, req.(*grpc.StartTracingRequest), opts...)
+		return c.StartTracing(ctx, req.(*grpc.StartTracingRequest), opts...)
 	}
 	k.reqHandlers[grpcStopTracingRequest] = func(ctx context.Context, req interface{}, opts ...golangGrpc.CallOption) (interface{}, error) {
-		return k.client.StopTracing(ctx, req.(*grpc.StopTracingRequest), opts...)
+		return c.StopTracing(ctx, req.(*grpc.StopTracingRequest), opts...)
 	}
 }
 
@@ -1924,53 +2908,99 @@ func (k *kataAgent) getReqContext(reqName string) (ctx context.Context, cancel c
 
 func (k *kataAgent) sendReq(request interface{}) (interface{}, error) {
 	span, _ := k.trace("sendReq")
-	span.SetTag("request", request)
+	span.SetTag("request", redactRequest(request))
 	defer span.Finish()
 
-	if err := k.connect(); err != nil {
+	if err := k.policy.evaluate(request); err != nil {
 		return nil, err
 	}
-	if !k.keepConn {
-		defer k.disconnect()
+
+	if err := k.hold(); err != nil {
+		return nil, err
 	}
+	defer k.release()
 
 	msgName := proto.MessageName(request.(proto.Message))
-	handler := k.reqHandlers[msgName]
+	client, handler := k.reqHandler(msgName)
 	if msgName == "" || handler == nil {
 		return nil, errors.New("Invalid request type")
 	}
-	message := request.(proto.Message)
+
+	message := redactRequest(request).(proto.Message)
+	k.Logger().WithField("name", msgName).WithField("req", message.String()).Debug("sending request")
+
+	resp, err := k.handleReq(span, handler, msgName, request)
+	if err == nil || !agentConnectionLost(err) {
+		return resp, err
+	}
+
+	k.Logger().WithError(err).WithField("name", msgName).Warn("Lost the connection to the agent")
+
+	if rerr := k.reconnect(client); rerr != nil {
+		k.Logger().WithError(rerr).Error("Agent unreachable")
+		return nil, err
+	}
+
+	// The request may have been handled by the agent already.
+	if !idempotentRequests[msgName] {
+		return nil, err
+	}
+
+	if _, handler = k.reqHandler(msgName); handler == nil {
+		return nil, err
+	}
+
+	return k.handleReq(span, handler, msgName, request)
+}
+
+// handleReq sends the request to the agent, with the trace context of the
+// span in its gRPC metadata for the agent to continue the trace.
+func (k *kataAgent) handleReq(span opentracing.Span, handler reqFunc, msgName string, request interface{}) (interface{}, error) {
 	ctx, cancel := k.getReqContext(msgName)
 	if cancel != nil {
 		defer cancel()
 	}
-	k.Logger().WithField("name", msgName).WithField("req", message.String()).Debug("sending request")
 
-	return handler(ctx, request)
+	if traceParent, ok := tracing.Inject(span); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, tracing.TraceParentKey, traceParent)
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, request)
+	agentRPCDurations.WithLabelValues(msgName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		agentRPCFailures.WithLabelValues(msgName).Inc()
+	}
+
+	if audit := auditLogFromContext(k.ctx); audit != nil {
+		audit.record(auditAgent, msgName, auditRequest(request), start, err)
+	}
+
+	return resp, err
 }
 
 // readStdout and readStderr are special that we cannot differentiate them with the request types...
 func (k *kataAgent) readProcessStdout(c *Container, processID string, data []byte) (int, error) {
-	if err := k.connect(); err != nil {
+	if err := k.hold(); err != nil {
 		return 0, err
 	}
-	if !k.keepConn {
-		defer k.disconnect()
-	}
+	defer k.release()
+
+	client, _ := k.reqHandler("")
 
-	return k.readProcessStream(c.id, processID, data, k.client.ReadStdout)
+	return k.readProcessStream(c.id, processID, data, client.ReadStdout)
 }
 
 // readStdout and readStderr are special that we cannot differentiate them with the request types...
 func (k *kataAgent) readProcessStderr(c *Container, processID string, data []byte) (int, error) {
-	if err := k.connect(); err != nil {
+	if err := k.hold(); err != nil {
 		return 0, err
 	}
-	if !k.keepConn {
-		defer k.disconnect()
-	}
+	defer k.release()
+
+	client, _ := k.reqHandler("")
 
-	return k.readProcessStream(c.id, processID, data, k.client.ReadStderr)
+	return k.readProcessStream(c.id, processID, data, client.ReadStderr)
 }
 
 type readFn func(context.Context, *grpc.ReadStreamRequest, ...golangGrpc.CallOption) (*grpc.ReadStreamResponse, error)
@@ -2202,7 +3232,9 @@ func (k *kataAgent) copyFile(src, dst string) error {
 
 func (k *kataAgent) markDead() {
 	k.Logger().Infof("mark agent dead")
+	k.Lock()
 	k.dead = true
+	k.Unlock()
 	k.disconnect()
 }
 
@@ -17,11 +17,15 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	gpb "github.com/gogo/protobuf/types"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	aTypes "github.com/kata-containers/agent/pkg/types"
 	pb "github.com/kata-containers/agent/protocols/grpc"
@@ -30,6 +34,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
 	"github.com/kata-containers/runtime/virtcontainers/device/manager"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/mock"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/tracing"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
@@ -453,6 +458,266 @@ func TestAppendDevices(t *testing.T) {
 		updatedDevList, expected)
 }
 
+func TestAppendBlkSerialDevices(t *testing.T) {
+	assert := assert.New(t)
+	k := kataAgent{}
+
+	id := "test-append-blk-serial"
+	ctrDevices := []api.Device{
+		&drivers.BlockDevice{
+			GenericDevice: &drivers.GenericDevice{
+				ID: id,
+			},
+			BlockDrive: &config.BlockDrive{
+				PCIAddr: testPCIAddr,
+				DevNo:   "0.0.0001",
+				Serial:  "0123456789abcdef",
+			},
+		},
+	}
+
+	sandbox := &Sandbox{
+		devManager: manager.NewDeviceManager("virtio-blk", ctrDevices),
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				BlockDeviceDriver: config.VirtioBlock,
+			},
+		},
+	}
+
+	c := &Container{
+		sandbox: sandbox,
+		devices: []ContainerDevice{
+			{
+				ID:            id,
+				ContainerPath: testBlockDeviceCtrPath,
+			},
+		},
+	}
+
+	// The agent does not support finding devices by serial
+	devList := k.appendDevices([]*pb.Device{}, c)
+	assert.Equal([]*pb.Device{
+		{
+			Type:          kataBlkDevType,
+			ContainerPath: testBlockDeviceCtrPath,
+			Id:            testPCIAddr,
+		},
+	}, devList)
+
+	sandbox.state.GuestBlkSerial = true
+	devList = k.appendDevices([]*pb.Device{}, c)
+	assert.Equal([]*pb.Device{
+		{
+			Type:          kataBlkSerialDevType,
+			ContainerPath: testBlockDeviceCtrPath,
+			Id:            "0123456789abcdef",
+		},
+	}, devList)
+
+	sandbox.config.HypervisorConfig.BlockDeviceDriver = config.VirtioBlockCCW
+	devList = k.appendDevices([]*pb.Device{}, c)
+	assert.Equal(kataBlkSerialDevType, devList[0].Type)
+
+	sandbox.state.GuestBlkSerial = false
+	devList = k.appendDevices([]*pb.Device{}, c)
+	assert.Equal(kataBlkCCWDevType, devList[0].Type)
+	assert.Equal("0.0.0001", devList[0].Id)
+
+	// Serials are only used with virtio-blk
+	sandbox.state.GuestBlkSerial = true
+	sandbox.config.HypervisorConfig.BlockDeviceDriver = config.VirtioSCSI
+	assert.False(useBlkSerial(sandbox, &config.BlockDrive{Serial: "foo"}))
+}
+
+func TestLUKSDriverOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "luks")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "key"), []byte("secret"), 0600))
+
+	sandbox := &Sandbox{config: &SandboxConfig{LUKSKeyDir: dir}}
+
+	// the agent cannot open the volume
+	_, err = luksDriverOptions(sandbox, "key")
+	assert.Error(err)
+
+	sandbox.state.GuestLUKS = true
+	options, err := luksDriverOptions(sandbox, "key")
+	assert.NoError(err)
+	assert.Equal([]string{kataLUKSDriverOption, luksKeyDriverOption + "c2VjcmV0"}, options)
+
+	_, err = luksDriverOptions(sandbox, "missing")
+	assert.Error(err)
+}
+
+func TestReadLUKSKey(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "luks")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	keyDir := filepath.Join(dir, "keys")
+	assert.NoError(os.Mkdir(keyDir, 0700))
+	assert.NoError(ioutil.WriteFile(filepath.Join(keyDir, "key"), []byte("secret"), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(keyDir, "empty"), nil, 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(keyDir, "large"), make([]byte, maxLUKSKeySize+1), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "outside"), []byte("secret"), 0600))
+	assert.NoError(os.Symlink(filepath.Join(dir, "outside"), filepath.Join(keyDir, "link")))
+	assert.NoError(syscall.Mkfifo(filepath.Join(keyDir, "fifo"), 0600))
+
+	key, err := readLUKSKey(keyDir, "key")
+	assert.NoError(err)
+	assert.Equal([]byte("secret"), key)
+
+	// no key directory configured
+	_, err = readLUKSKey("", "key")
+	assert.Error(err)
+
+	for _, name := range []string{"", ".", "..", "../outside", "/etc/passwd", "link", "fifo", "empty", "large", "missing"} {
+		_, err = readLUKSKey(keyDir, name)
+		assert.Error(err, name)
+	}
+}
+
+func TestAgentSupportsLUKS(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(agentSupportsLUKS(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType}}))
+	assert.True(agentSupportsLUKS(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType, kataLUKSDriverOption}}))
+}
+
+func TestAgentSupportsIntegrity(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(agentSupportsIntegrity(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType}}))
+	assert.True(agentSupportsIntegrity(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType, kataIntegrityDriverOption}}))
+}
+
+func TestAgentSupportsVirtioPmem(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(agentSupportsVirtioPmem(&pb.AgentDetails{}))
+	assert.False(agentSupportsVirtioPmem(&pb.AgentDetails{DeviceHandlers: []string{kataVirtioPmemDevType}}))
+	assert.False(agentSupportsVirtioPmem(&pb.AgentDetails{StorageHandlers: []string{kataVirtioPmemDevType}}))
+	assert.True(agentSupportsVirtioPmem(&pb.AgentDetails{
+		DeviceHandlers:  []string{kataBlkDevType, kataVirtioPmemDevType},
+		StorageHandlers: []string{kataBlkDevType, kataVirtioPmemDevType},
+	}))
+}
+
+func TestAgentSupportsWatchableBind(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(agentSupportsWatchableBind(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType}}))
+	assert.True(agentSupportsWatchableBind(&pb.AgentDetails{StorageHandlers: []string{kataBlkDevType, kataWatchableBindDevType}}))
+}
+
+func TestRedactRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	other := &pb.StartContainerRequest{ContainerId: "foo"}
+	assert.Equal(other, redactRequest(other))
+
+	req := &pb.CreateContainerRequest{
+		Storages: []*pb.Storage{
+			{Driver: kataBlkDevType, Fstype: "bind"},
+			{Driver: kataBlkDevType, DriverOptions: []string{kataLUKSDriverOption, luksKeyDriverOption + "c2VjcmV0"}},
+		},
+	}
+	redacted := redactRequest(req).(*pb.CreateContainerRequest)
+	assert.Equal([]string{kataLUKSDriverOption, luksKeyDriverOption + "<redacted>"}, redacted.Storages[1].DriverOptions)
+	assert.NotContains(redacted.String(), "c2VjcmV0")
+
+	// the request sent to the agent is left untouched
+	assert.Equal(luksKeyDriverOption+"c2VjcmV0", req.Storages[1].DriverOptions[1])
+
+	// requests without keys are not copied
+	req.Storages = req.Storages[:1]
+	assert.True(req == redactRequest(req))
+}
+
+func TestReplaceOCIMountsForLUKSStorages(t *testing.T) {
+	assert := assert.New(t)
+	k := kataAgent{}
+
+	spec := &specs.Spec{
+		Mounts: []specs.Mount{
+			{
+				Source:      "/dev/sdb",
+				Destination: "/data",
+				Type:        "bind",
+				Options:     []string{"rbind", luksKeyNameOption + "data", "ro"},
+			},
+		},
+	}
+	storages := []*pb.Storage{{MountPoint: "/data"}}
+
+	assert.NoError(k.replaceOCIMountsForStorages(spec, storages))
+	assert.Equal([]string{"rbind", "ro"}, spec.Mounts[0].Options)
+	assert.Equal(storages[0].MountPoint, spec.Mounts[0].Source)
+}
+
+func TestAgentSupportsBlkSerial(t *testing.T) {
+	assert := assert.New(t)
+
+	details := &pb.AgentDetails{}
+	assert.False(agentSupportsBlkSerial(details))
+
+	details.DeviceHandlers = []string{kataBlkDevType, kataBlkSerialDevType}
+	assert.False(agentSupportsBlkSerial(details))
+
+	details.StorageHandlers = []string{kataBlkSerialDevType}
+	assert.True(agentSupportsBlkSerial(details))
+}
+
+func TestAppendVirtioPmemDevices(t *testing.T) {
+	k := kataAgent{}
+
+	id := "test-append-pmem"
+	ctrDevices := []api.Device{
+		&drivers.BlockDevice{
+			GenericDevice: &drivers.GenericDevice{
+				ID: id,
+			},
+			BlockDrive: &config.BlockDrive{
+				PCIAddr: testPCIAddr,
+			},
+		},
+	}
+
+	sandboxConfig := &SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			BlockDeviceDriver: config.VirtioPmem,
+		},
+	}
+
+	c := &Container{
+		sandbox: &Sandbox{
+			devManager: manager.NewDeviceManager(config.VirtioPmem, ctrDevices),
+			config:     sandboxConfig,
+		},
+	}
+	c.devices = append(c.devices, ContainerDevice{
+		ID:            id,
+		ContainerPath: testBlockDeviceCtrPath,
+	})
+
+	expected := []*pb.Device{
+		{
+			Type:          kataVirtioPmemDevType,
+			ContainerPath: testBlockDeviceCtrPath,
+			Id:            testPCIAddr,
+		},
+	}
+	updatedDevList := k.appendDevices([]*pb.Device{}, c)
+	assert.Equal(t, expected, updatedDevList)
+}
+
 func TestConstraintGRPCSpec(t *testing.T) {
 	assert := assert.New(t)
 	expectedCgroupPath := "/foo/bar"
@@ -525,7 +790,7 @@ func TestHandleShm(t *testing.T) {
 		},
 	}
 
-	k.handleShm(g, sandbox)
+	k.handleShm(g, sandbox, 0)
 
 	assert.Len(g.Mounts, 1)
 	assert.NotEmpty(g.Mounts[0].Destination)
@@ -535,7 +800,7 @@ func TestHandleShm(t *testing.T) {
 	assert.Equal(g.Mounts[0].Options, []string{"rbind"})
 
 	sandbox.shmSize = 0
-	k.handleShm(g, sandbox)
+	k.handleShm(g, sandbox, 0)
 
 	assert.Len(g.Mounts, 1)
 	assert.NotEmpty(g.Mounts[0].Destination)
@@ -545,6 +810,15 @@ func TestHandleShm(t *testing.T) {
 
 	sizeOption := fmt.Sprintf("size=%d", DefaultShmSize)
 	assert.Equal(g.Mounts[0].Options, []string{"noexec", "nosuid", "nodev", "mode=1777", sizeOption})
+
+	// Private tmpfs get the size assigned on the host.
+	sandbox.shmSize = 8192
+	g.Mounts[0] = pb.Mount{Destination: "/dev/shm", Type: "tmpfs"}
+	k.handleShm(g, sandbox, 1<<30)
+
+	assert.Equal(g.Mounts[0].Type, "tmpfs")
+	assert.Equal(g.Mounts[0].Source, "shm")
+	assert.Equal(g.Mounts[0].Options, []string{"noexec", "nosuid", "nodev", "mode=1777", "size=1073741824"})
 }
 
 func testIsPidNamespacePresent(grpcSpec *pb.Spec) bool {
@@ -648,6 +922,87 @@ func TestAgentPathAPI(t *testing.T) {
 	assert.True(ok)
 }
 
+func TestKataAgentRegisterVSockPorts(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		config: &SandboxConfig{},
+	}
+
+	// Nothing to register without vsock
+	k := &kataAgent{vmSocket: types.Socket{}}
+	assert.NoError(k.registerVSockPorts(sandbox))
+	assert.Empty(sandbox.state.VSockPorts)
+
+	k.vmSocket = kataVSOCK{port: vSockPort}
+	sandbox.config.HypervisorConfig.KernelParams = []Param{
+		{Key: "agent.debug_console_vport", Value: "1026"},
+	}
+	assert.NoError(k.registerVSockPorts(sandbox))
+	assert.Equal(types.VSockPorts{
+		types.VSockAgentService:        types.VSockAgentPort,
+		types.VSockDebugConsoleService: types.VSockDebugConsolePort,
+	}, sandbox.state.VSockPorts)
+
+	// The debug console cannot take the agent port
+	sandbox.state.VSockPorts = nil
+	sandbox.config.HypervisorConfig.KernelParams = []Param{
+		{Key: "agent.debug_console_vport", Value: "1024"},
+	}
+	assert.Error(k.registerVSockPorts(sandbox))
+
+	sandbox.config.HypervisorConfig.KernelParams = []Param{
+		{Key: "agent.debug_console_vport", Value: "foo"},
+	}
+	assert.Error(k.registerVSockPorts(sandbox))
+
+	// The hybrid vsock registers the same ports
+	sandbox.state.VSockPorts = nil
+	sandbox.config.HypervisorConfig.KernelParams = nil
+	k.vmSocket = kataHybridVSOCK{udsPath: "/run/kata.hvsock", port: vSockPort}
+	assert.NoError(k.registerVSockPorts(sandbox))
+	assert.Equal(types.VSockPorts{
+		types.VSockAgentService: types.VSockAgentPort,
+	}, sandbox.state.VSockPorts)
+}
+
+func TestKataAgentHybridVSock(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hvsock")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fc := &firecracker{jailerRoot: dir}
+	k := &kataAgent{}
+	assert.Error(k.setupVMSocket(fc, "sandbox", KataAgentConfig{}))
+	assert.NoError(k.setupVMSocket(fc, "sandbox", KataAgentConfig{UseVSock: true}))
+
+	udsPath := filepath.Join(dir, fcHybridVSock)
+	url, err := k.agentURL()
+	assert.NoError(err)
+	assert.Equal(fmt.Sprintf("hvsock://%s:%d", udsPath, vSockPort), url)
+
+	// The v1 shim and proxy cannot dial the hybrid vsock.
+	sandbox := &Sandbox{
+		ctx:        context.Background(),
+		id:         testSandboxID,
+		hypervisor: fc,
+		config: &SandboxConfig{
+			HypervisorType: FirecrackerHypervisor,
+			ShimType:       KataShimType,
+			ProxyType:      NoProxyType,
+		},
+	}
+	_, err = k.init(context.Background(), sandbox, KataAgentConfig{UseVSock: true})
+	assert.Error(err)
+
+	sandbox.config.ShimType = KataBuiltInShimType
+	sandbox.config.ProxyType = KataProxyType
+	_, err = k.init(context.Background(), sandbox, KataAgentConfig{UseVSock: true})
+	assert.Error(err)
+}
+
 func TestAgentConfigure(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1130,3 +1485,334 @@ func TestKataAgentSetDefaultTraceConfigOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestKataAgentSetupStoragesVirtioFSVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		ctx:        context.Background(),
+		hypervisor: &qemu{ctx: context.Background(), arch: &qemuArchBase{}},
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				SharedFS:      config.VirtioFS,
+				VirtioFSCache: typeVirtioFSNoCache,
+				VirtioFSVolumes: []types.Volume{
+					{MountTag: "data", HostPath: "/srv/data"},
+				},
+			},
+		},
+	}
+
+	storages := setupStorages(sandbox)
+	assert.Len(storages, 2)
+	assert.Equal(kataGuestSharedDir, storages[0].MountPoint)
+
+	volume := storages[1]
+	assert.Equal(kataVirtioFSDevType, volume.Driver)
+	assert.Equal(typeVirtioFS, volume.Fstype)
+	assert.Equal(filepath.Join(kataGuestVolumesDir, "data"), volume.MountPoint)
+	assert.Equal(virtioFSVolumeOptions("data", false), volume.Options)
+	assert.Contains(volume.Options[0], "tag=data")
+	assert.NotContains(volume.Options, sharedDirVirtioFSDaxOptions)
+
+	assert.Contains(virtioFSVolumeOptions("data", true), sharedDirVirtioFSDaxOptions)
+}
+
+func TestVirtioFSVolumeGuestPath(t *testing.T) {
+	assert := assert.New(t)
+
+	volumes := []types.Volume{
+		{MountTag: "data", HostPath: "/srv/data"},
+		{MountTag: "models", HostPath: "/srv/models"},
+	}
+
+	path, ok := virtioFSVolumeGuestPath(volumes, "/srv/data")
+	assert.True(ok)
+	assert.Equal(filepath.Join(kataGuestVolumesDir, "data"), path)
+
+	path, ok = virtioFSVolumeGuestPath(volumes, "/srv/models/foo/bar")
+	assert.True(ok)
+	assert.Equal(filepath.Join(kataGuestVolumesDir, "models", "foo/bar"), path)
+
+	for _, hostPath := range []string{"/srv", "/srv/database", "/srv/data/../models2", "relative/path"} {
+		_, ok = virtioFSVolumeGuestPath(volumes, hostPath)
+		assert.False(ok, "host path %s", hostPath)
+	}
+
+	_, ok = virtioFSVolumeGuestPath(nil, "/srv/data")
+	assert.False(ok)
+}
+
+func TestKataAgentDialTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	sockDir, err := testGenerateKataProxySockDir()
+	assert.NoError(err)
+	defer os.RemoveAll(sockDir)
+
+	// Nothing listens on the socket, the connection times out.
+	k := &kataAgent{
+		ctx: context.Background(),
+		state: KataAgentState{
+			URL: fmt.Sprintf(testKataProxyURLTempl, sockDir),
+		},
+		dialTimeout: 100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	assert.Error(k.connect())
+	assert.True(time.Since(start) < 5*time.Second)
+}
+
+func TestCheckKernelModules(t *testing.T) {
+	assert := assert.New(t)
+
+	modules := []string{"ip_vs", " nbd nbds_max=32 max_part=8", ""}
+
+	assert.NoError(checkKernelModules(nil))
+	assert.NoError(checkKernelModules(modules))
+	assert.NoError(CheckKernelModulesAllowed(modules, []string{"ip-vs", "nbd"}))
+	assert.Error(CheckKernelModulesAllowed(modules, []string{"nbd"}))
+
+	// An empty allowlist denies all the modules.
+	assert.NoError(CheckKernelModulesAllowed(nil, nil))
+	assert.Error(CheckKernelModulesAllowed(modules, nil))
+	assert.Error(CheckKernelModulesAllowed(modules, []string{}))
+
+	for _, module := range []string{"../nbd", "-r nbd", "nbd -r", "nbd --dry-run"} {
+		assert.Error(checkKernelModules([]string{module}), "module %q", module)
+		assert.Error(CheckKernelModulesAllowed([]string{module}, []string{"nbd"}), "module %q", module)
+	}
+
+	// The agent refuses to start a sandbox loading an invalid module.
+	k := &kataAgent{}
+	_, err := k.init(context.Background(), &Sandbox{ctx: context.Background(), id: testSandboxID}, KataAgentConfig{
+		KernelModules: []string{"nbd --dry-run"},
+	})
+	assert.Error(err)
+
+	assert.Equal([]*pb.KernelModule{
+		{Name: "ip_vs"},
+		{Name: "nbd", Parameters: []string{"nbds_max=32", "max_part=8"}},
+	}, setupKernelModules(modules))
+}
+
+func TestKataAgentReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	savedAttempts, savedBackoff, savedDialTimeout := agentReconnectAttempts, agentReconnectBackoff, agentReconnectDialTimeout
+	agentReconnectAttempts = 4
+	agentReconnectBackoff = 50 * time.Millisecond
+	agentReconnectDialTimeout = 100 * time.Millisecond
+	defer func() {
+		agentReconnectAttempts, agentReconnectBackoff, agentReconnectDialTimeout = savedAttempts, savedBackoff, savedDialTimeout
+	}()
+
+	sockDir, err := testGenerateKataProxySockDir()
+	assert.NoError(err)
+	defer os.RemoveAll(sockDir)
+
+	sockPath := filepath.Join(sockDir, "kata-proxy-test.sock")
+
+	serve := func() *grpc.Server {
+		l, err := net.Listen("unix", sockPath)
+		assert.NoError(err)
+
+		server := grpc.NewServer()
+		gRPCRegister(server, &gRPCProxy{})
+		go server.Serve(l)
+
+		return server
+	}
+
+	// restart stops the agent and starts it again after a while.
+	restart := func(server *grpc.Server) <-chan *grpc.Server {
+		server.Stop()
+
+		ch := make(chan *grpc.Server, 1)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			ch <- serve()
+		}()
+
+		return ch
+	}
+
+	k := &kataAgent{
+		ctx: context.Background(),
+		state: KataAgentState{
+			URL: "unix://" + sockPath,
+		},
+		keepConn: true,
+	}
+	assert.Equal(AgentHealthUnknown, k.health())
+
+	server := serve()
+
+	_, err = k.sendReq(&pb.CheckRequest{})
+	assert.NoError(err)
+	assert.Equal(AgentHealthy, k.health())
+
+	// The idempotent requests are sent again once reconnected.
+	ch := restart(server)
+	_, err = k.sendReq(&pb.CheckRequest{})
+	assert.NoError(err)
+	assert.Equal(AgentHealthy, k.health())
+	server = <-ch
+
+	// The other requests may have been handled already.
+	ch = restart(server)
+	_, err = k.sendReq(&pb.StartContainerRequest{})
+	assert.Error(err)
+	assert.Equal(AgentHealthy, k.health())
+	server = <-ch
+
+	_, err = k.sendReq(&pb.StartContainerRequest{})
+	assert.NoError(err)
+
+	server.Stop()
+	_, err = k.sendReq(&pb.CheckRequest{})
+	assert.Error(err)
+	assert.Equal(AgentUnreachable, k.health())
+}
+
+func TestKataAgentReconnectUnlocked(t *testing.T) {
+	assert := assert.New(t)
+
+	savedAttempts, savedBackoff, savedDialTimeout := agentReconnectAttempts, agentReconnectBackoff, agentReconnectDialTimeout
+	agentReconnectAttempts = 10
+	agentReconnectBackoff = 100 * time.Millisecond
+	agentReconnectDialTimeout = 50 * time.Millisecond
+	defer func() {
+		agentReconnectAttempts, agentReconnectBackoff, agentReconnectDialTimeout = savedAttempts, savedBackoff, savedDialTimeout
+	}()
+
+	sockDir, err := testGenerateKataProxySockDir()
+	assert.NoError(err)
+	defer os.RemoveAll(sockDir)
+
+	sockPath := filepath.Join(sockDir, "kata-proxy-test.sock")
+
+	serve := func() *grpc.Server {
+		l, err := net.Listen("unix", sockPath)
+		assert.NoError(err)
+
+		server := grpc.NewServer()
+		gRPCRegister(server, &gRPCProxy{})
+		go server.Serve(l)
+
+		return server
+	}
+
+	k := &kataAgent{
+		ctx: context.Background(),
+		state: KataAgentState{
+			URL: "unix://" + sockPath,
+		},
+		keepConn: true,
+	}
+
+	server := serve()
+	_, err = k.sendReq(&pb.CheckRequest{})
+	assert.NoError(err)
+	server.Stop()
+
+	send := func() <-chan error {
+		ch := make(chan error, 1)
+		go func() {
+			_, err := k.sendReq(&pb.CheckRequest{})
+			ch <- err
+		}()
+		return ch
+	}
+
+	first := send()
+
+	// The health is reported while the agent is being reconnected.
+	timeout := time.After(5 * time.Second)
+	for k.health() != AgentReconnecting {
+		select {
+		case <-timeout:
+			t.Fatal("The agent is not being reconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The other requests wait for the reconnection.
+	second := send()
+
+	server = serve()
+	defer server.Stop()
+
+	assert.NoError(<-first)
+	assert.NoError(<-second)
+	assert.Equal(AgentHealthy, k.health())
+}
+
+func TestKataAgentConcurrentRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := mock.ProxyGRPCMock{
+		GRPCImplementer: &gRPCProxy{},
+		GRPCRegister:    gRPCRegister,
+	}
+
+	sockDir, err := testGenerateKataProxySockDir()
+	assert.NoError(err)
+	defer os.RemoveAll(sockDir)
+
+	testKataProxyURL := fmt.Sprintf(testKataProxyURLTempl, sockDir)
+	assert.NoError(proxy.Start(testKataProxyURL))
+	defer proxy.Stop()
+
+	k := &kataAgent{
+		ctx: context.Background(),
+		state: KataAgentState{
+			URL: testKataProxyURL,
+		},
+	}
+
+	// The requests share the connection, closed once they are all done.
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := k.sendReq(&pb.CheckRequest{})
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(<-errs)
+	}
+
+	assert.Nil(k.client)
+	assert.Equal(0, k.inflight)
+}
+
+func TestKataAgentHandleReqTraceContext(t *testing.T) {
+	assert := assert.New(t)
+
+	k := &kataAgent{}
+
+	var md metadata.MD
+	handler := func(ctx context.Context, req interface{}, opts ...grpc.CallOption) (interface{}, error) {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	}
+
+	// The spans of the NOP tracer are not propagated.
+	_, err := k.handleReq(opentracing.NoopTracer{}.StartSpan("sendReq"), handler, grpcCheckRequest, &pb.CheckRequest{})
+	assert.NoError(err)
+	assert.Empty(md[tracing.TraceParentKey])
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Injector(tracing.TraceContext, tracing.Propagator{}))
+	defer closer.Close()
+
+	span := tracer.StartSpan("sendReq")
+	defer span.Finish()
+
+	_, err = k.handleReq(span, handler, grpcCheckRequest, &pb.CheckRequest{})
+	assert.NoError(err)
+	assert.Equal([]string{tracing.FormatTraceParent(span.Context().(jaeger.SpanContext))}, md[tracing.TraceParentKey])
+}
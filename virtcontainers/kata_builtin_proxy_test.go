@@ -6,7 +6,13 @@
 package virtcontainers
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -45,3 +51,57 @@ func TestKataBuiltinProxy(t *testing.T) {
 
 	assert.False(p.consoleWatched())
 }
+
+func TestKataBuiltinProxyConsoleOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "builtin-proxy")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	console := filepath.Join(dir, "console.sock")
+	l, err := net.Listen("unix", console)
+	assert.NoError(err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("[    0.000000] Linux version 5.4.15\n"))
+	}()
+
+	p := kataBuiltInProxy{}
+
+	_, watched := p.consoleOutput()
+	assert.False(watched)
+
+	assert.NoError(p.watchConsole(consoleProtoUnix, console, logrus.WithField("proxy", "test")))
+	defer p.stop(0)
+
+	// the console is read asynchronously
+	var output string
+	for i := 0; i < 100 && output == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		output, watched = p.consoleOutput()
+	}
+	assert.True(watched)
+	assert.Equal("[    0.000000] Linux version 5.4.15\n", output)
+}
+
+func TestConsoleTail(t *testing.T) {
+	assert := assert.New(t)
+
+	var c consoleTail
+	c.writeLine("first")
+	assert.Equal("first\n", c.String())
+
+	c.writeLine(strings.Repeat("x", bootConsoleProbeMaxSize))
+	assert.Len(c.String(), bootConsoleProbeMaxSize)
+	assert.False(strings.HasPrefix(c.String(), "first"))
+
+	c.reset()
+	assert.Empty(c.String())
+}
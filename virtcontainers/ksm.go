@@ -0,0 +1,390 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+)
+
+// KSMMode describes how the host kernel same-page merging is driven while
+// a sandbox runs.
+type KSMMode string
+
+const (
+	// KSMOff leaves the host KSM settings untouched.
+	KSMOff KSMMode = "off"
+
+	// KSMAuto runs KSM with moderate scanning while the sandbox runs.
+	KSMAuto KSMMode = "auto"
+
+	// KSMAggressive scans aggressively while the sandbox boots, and
+	// trails off to the auto settings over ksmBootWindow.
+	KSMAggressive KSMMode = "aggressive"
+)
+
+const (
+	// ksmBootWindow is the time an aggressive sandbox takes to trail off
+	// to the auto settings after it started.
+	ksmBootWindow = 2 * time.Minute
+
+	// ksmTrailSteps is the number of steps the trail off is applied in
+	// by the runtime process the sandbox was started from.
+	ksmTrailSteps = 4
+
+	ksmLockFile     = "lock"
+	ksmSavedFile    = "saved.json"
+	ksmSandboxesDir = "sandboxes"
+)
+
+var (
+	ksmSysfsPath = "/sys/kernel/mm/ksm"
+
+	ksmAutoSettings       = ksmSettings{Run: 1, PagesToScan: 100, SleepMillisecs: 200}
+	ksmAggressiveSettings = ksmSettings{Run: 1, PagesToScan: 1000, SleepMillisecs: 20}
+)
+
+// ksmSettings are the host KSM tunables the runtime drives.
+type ksmSettings struct {
+	Run            uint64 `json:"run"`
+	PagesToScan    uint64 `json:"pages_to_scan"`
+	SleepMillisecs uint64 `json:"sleep_millisecs"`
+}
+
+// ksmRegistration is the KSM reference a running sandbox holds.
+type ksmRegistration struct {
+	Mode    KSMMode   `json:"mode"`
+	Started time.Time `json:"started"`
+
+	// Pid is the pid of the hypervisor of the sandbox, the reference
+	// being stale once it exited.
+	Pid int `json:"pid"`
+}
+
+// stale returns true if the hypervisor of the sandbox holding the reference
+// exited, e.g. it was left behind by a runtime which crashed.
+func (r ksmRegistration) stale() bool {
+	return r.Pid <= 0 || syscall.Kill(r.Pid, syscall.Signal(0)) == syscall.ESRCH
+}
+
+// ParseKSMMode parses a KSM mode, an empty one leaving KSM untouched.
+func ParseKSMMode(mode string) (KSMMode, error) {
+	m := KSMMode(strings.TrimSpace(mode))
+	if err := m.valid(); err != nil {
+		return "", err
+	}
+
+	return m, nil
+}
+
+func (mode KSMMode) valid() error {
+	switch mode {
+	case "", KSMOff, KSMAuto, KSMAggressive:
+		return nil
+	}
+
+	return fmt.Errorf("Invalid KSM mode %q, expecting %q, %q or %q", mode, KSMOff, KSMAuto, KSMAggressive)
+}
+
+// ksmStateDir returns the directory holding the KSM references of the
+// sandboxes and the host settings to restore once they are all released.
+// It is shared by all the runtime processes of the host.
+func ksmStateDir() string {
+	return filepath.Join(filepath.Dir(store.RunStoragePath), "ksm")
+}
+
+func readKSMSettings() (ksmSettings, error) {
+	var settings ksmSettings
+
+	for name, value := range map[string]*uint64{
+		"run":             &settings.Run,
+		"pages_to_scan":   &settings.PagesToScan,
+		"sleep_millisecs": &settings.SleepMillisecs,
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(ksmSysfsPath, name))
+		if err != nil {
+			return ksmSettings{}, err
+		}
+
+		if *value, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return ksmSettings{}, fmt.Errorf("Invalid KSM %s %q: %v", name, data, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// writeKSMSettings applies settings to the host, the scanning rate before
+// starting or stopping KSM.
+func writeKSMSettings(settings ksmSettings) error {
+	for _, f := range []struct {
+		name  string
+		value uint64
+	}{
+		{"pages_to_scan", settings.PagesToScan},
+		{"sleep_millisecs", settings.SleepMillisecs},
+		{"run", settings.Run},
+	} {
+		path := filepath.Join(ksmSysfsPath, f.name)
+		if err := ioutil.WriteFile(path, []byte(strconv.FormatUint(f.value, 10)), 0644); err != nil {
+			return fmt.Errorf("Could not set KSM %s: %v", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// trailKSMSettings returns the settings of an aggressive sandbox started
+// for elapsed: the aggressive settings at first, moving linearly to the
+// auto settings until the boot window ends.
+func trailKSMSettings(elapsed time.Duration) ksmSettings {
+	if elapsed >= ksmBootWindow {
+		return ksmAutoSettings
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	trail := func(from, to uint64) uint64 {
+		return uint64(int64(from) + (int64(to)-int64(from))*int64(elapsed)/int64(ksmBootWindow))
+	}
+
+	return ksmSettings{
+		Run:            1,
+		PagesToScan:    trail(ksmAggressiveSettings.PagesToScan, ksmAutoSettings.PagesToScan),
+		SleepMillisecs: trail(ksmAggressiveSettings.SleepMillisecs, ksmAutoSettings.SleepMillisecs),
+	}
+}
+
+// effectiveKSMSettings returns the settings satisfying all the sandboxes
+// holding a KSM reference: the most aggressive scanning any of them asks
+// for.
+func effectiveKSMSettings(registrations []ksmRegistration, now time.Time) ksmSettings {
+	settings := ksmAutoSettings
+
+	for _, r := range registrations {
+		if r.Mode != KSMAggressive {
+			continue
+		}
+
+		s := trailKSMSettings(now.Sub(r.Started))
+		if s.PagesToScan > settings.PagesToScan {
+			settings.PagesToScan = s.PagesToScan
+		}
+		if s.SleepMillisecs < settings.SleepMillisecs {
+			settings.SleepMillisecs = s.SleepMillisecs
+		}
+	}
+
+	return settings
+}
+
+// withKSMLock runs fn holding the host wide KSM lock.
+func withKSMLock(fn func(dir string) error) error {
+	dir := ksmStateDir()
+	if err := os.MkdirAll(dir, store.DirMode); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, ksmLockFile), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return fn(dir)
+}
+
+// ksmRegistrations returns the KSM references held by the sandboxes. The
+// stale and invalid references are pruned.
+func ksmRegistrations(dir string) ([]ksmRegistration, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, ksmSandboxesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var registrations []ksmRegistration
+	for _, e := range entries {
+		path := filepath.Join(dir, ksmSandboxesDir, e.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var r ksmRegistration
+		if err := json.Unmarshal(data, &r); err != nil || r.stale() {
+			virtLog.WithField("sandbox", e.Name()).Warn("Pruning a stale KSM reference")
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		registrations = append(registrations, r)
+	}
+
+	return registrations, nil
+}
+
+// updateKSMSettings applies the settings of the sandboxes holding a KSM
+// reference, or restores the host settings saved when the first reference
+// was taken once there is none left.
+func updateKSMSettings(dir string) error {
+	registrations, err := ksmRegistrations(dir)
+	if err != nil {
+		return err
+	}
+
+	savedPath := filepath.Join(dir, ksmSavedFile)
+
+	if len(registrations) == 0 {
+		data, err := ioutil.ReadFile(savedPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		var saved ksmSettings
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("Invalid saved KSM settings: %v", err)
+		}
+
+		if err := writeKSMSettings(saved); err != nil {
+			return err
+		}
+
+		return os.Remove(savedPath)
+	}
+
+	if _, err := os.Stat(savedPath); os.IsNotExist(err) {
+		saved, err := readKSMSettings()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(saved)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(savedPath, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	return writeKSMSettings(effectiveKSMSettings(registrations, time.Now()))
+}
+
+// takeKSMReference takes the KSM reference of the sandbox, if its KSM
+// mode asks for one, and applies the resulting host settings.
+func (s *Sandbox) takeKSMReference() error {
+	mode := s.config.HypervisorConfig.KSMMode
+	if mode == "" || mode == KSMOff {
+		return nil
+	}
+
+	if _, err := os.Stat(ksmSysfsPath); err != nil {
+		return fmt.Errorf("KSM is not supported by the host kernel: %v", err)
+	}
+
+	pids := s.hypervisor.getPids()
+	if len(pids) == 0 || pids[0] <= 0 {
+		return fmt.Errorf("Could not take the KSM reference of the sandbox: unknown hypervisor pid")
+	}
+
+	data, err := json.Marshal(ksmRegistration{
+		Mode:    mode,
+		Started: time.Now(),
+		Pid:     pids[0],
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := withKSMLock(func(dir string) error {
+		if err := os.MkdirAll(filepath.Join(dir, ksmSandboxesDir), store.DirMode); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, ksmSandboxesDir, s.id), data, 0600); err != nil {
+			return err
+		}
+
+		return updateKSMSettings(dir)
+	}); err != nil {
+		return fmt.Errorf("Could not take the KSM reference of the sandbox: %v", err)
+	}
+
+	s.Logger().WithField("ksm-mode", mode).Info("KSM reference taken")
+
+	if mode == KSMAggressive {
+		go s.trailKSM()
+	}
+
+	return nil
+}
+
+// trailKSM lowers the host KSM scanning as the sandbox boot window ends.
+// When the runtime process exits earlier, the next sandbox taking or
+// releasing a KSM reference lowers it.
+func (s *Sandbox) trailKSM() {
+	for i := 0; i < ksmTrailSteps; i++ {
+		time.Sleep(ksmBootWindow / ksmTrailSteps)
+
+		if err := withKSMLock(func(dir string) error {
+			if _, err := os.Stat(filepath.Join(dir, ksmSandboxesDir, s.id)); err != nil {
+				return err
+			}
+
+			return updateKSMSettings(dir)
+		}); err != nil {
+			if !os.IsNotExist(err) {
+				s.Logger().WithError(err).Warn("Could not lower the KSM scanning")
+			}
+			return
+		}
+	}
+}
+
+// releaseKSMReference releases the KSM reference of the sandbox, if any,
+// and applies the settings of the sandboxes left.
+func (s *Sandbox) releaseKSMReference() error {
+	if _, err := os.Stat(filepath.Join(ksmStateDir(), ksmSandboxesDir, s.id)); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := withKSMLock(func(dir string) error {
+		if err := os.Remove(filepath.Join(dir, ksmSandboxesDir, s.id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return updateKSMSettings(dir)
+	}); err != nil {
+		return fmt.Errorf("Could not release the KSM reference of the sandbox: %v", err)
+	}
+
+	s.Logger().Info("KSM reference released")
+
+	return nil
+}
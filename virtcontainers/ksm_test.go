@@ -0,0 +1,160 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKSMMode(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]KSMMode{
+		"":           "",
+		"off":        KSMOff,
+		" auto ":     KSMAuto,
+		"aggressive": KSMAggressive,
+	} {
+		mode, err := ParseKSMMode(value)
+		assert.NoError(err, "mode %q", value)
+		assert.Equal(expected, mode)
+	}
+
+	_, err := ParseKSMMode("always")
+	assert.Error(err)
+}
+
+func TestEffectiveKSMSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+
+	assert.Equal(ksmAggressiveSettings, trailKSMSettings(0))
+	assert.Equal(ksmAutoSettings, trailKSMSettings(ksmBootWindow))
+
+	half := trailKSMSettings(ksmBootWindow / 2)
+	assert.Equal(uint64(550), half.PagesToScan)
+	assert.Equal(uint64(110), half.SleepMillisecs)
+
+	assert.Equal(ksmAutoSettings, effectiveKSMSettings([]ksmRegistration{
+		{Mode: KSMAuto, Started: now},
+		{Mode: KSMAggressive, Started: now.Add(-2 * ksmBootWindow)},
+	}, now))
+
+	assert.Equal(half, effectiveKSMSettings([]ksmRegistration{
+		{Mode: KSMAuto, Started: now},
+		{Mode: KSMAggressive, Started: now.Add(-2 * ksmBootWindow)},
+		{Mode: KSMAggressive, Started: now.Add(-ksmBootWindow / 2)},
+	}, now))
+}
+
+func TestSandboxKSMReference(t *testing.T) {
+	assert := assert.New(t)
+
+	sysfs, err := ioutil.TempDir("", "ksm")
+	assert.NoError(err)
+	defer os.RemoveAll(sysfs)
+	defer os.RemoveAll(ksmStateDir())
+
+	savedPath := ksmSysfsPath
+	ksmSysfsPath = sysfs
+	defer func() {
+		ksmSysfsPath = savedPath
+	}()
+
+	host := ksmSettings{Run: 0, PagesToScan: 50, SleepMillisecs: 500}
+	assert.NoError(writeKSMSettings(host))
+
+	newKSMSandbox := func(id string, mode KSMMode) *Sandbox {
+		return &Sandbox{
+			ctx:        context.Background(),
+			id:         id,
+			hypervisor: &mockHypervisor{mockPid: os.Getpid()},
+			config: &SandboxConfig{
+				HypervisorConfig: HypervisorConfig{KSMMode: mode},
+			},
+		}
+	}
+
+	// No reference taken
+	off := newKSMSandbox("off", KSMOff)
+	assert.NoError(off.takeKSMReference())
+	assert.NoError(off.releaseKSMReference())
+
+	settings, err := readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(host, settings)
+
+	auto := newKSMSandbox("auto", KSMAuto)
+	assert.NoError(auto.takeKSMReference())
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(ksmAutoSettings, settings)
+
+	aggressive := newKSMSandbox("aggressive", KSMAggressive)
+	assert.NoError(aggressive.takeKSMReference())
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.True(settings.PagesToScan > ksmAutoSettings.PagesToScan)
+	assert.True(settings.SleepMillisecs < ksmAutoSettings.SleepMillisecs)
+
+	assert.NoError(aggressive.releaseKSMReference())
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(ksmAutoSettings, settings)
+
+	// The last reference restores the host settings
+	assert.NoError(auto.releaseKSMReference())
+	assert.NoError(auto.releaseKSMReference())
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(host, settings)
+
+	_, err = os.Stat(filepath.Join(ksmStateDir(), ksmSavedFile))
+	assert.True(os.IsNotExist(err))
+
+	// The references of the exited hypervisors are pruned
+	cmd := exec.Command("true")
+	assert.NoError(cmd.Run())
+
+	stale := newKSMSandbox("stale", KSMAggressive)
+	stale.hypervisor = &mockHypervisor{mockPid: cmd.Process.Pid}
+	assert.NoError(stale.takeKSMReference())
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(host, settings)
+
+	_, err = os.Stat(filepath.Join(ksmStateDir(), ksmSandboxesDir, "stale"))
+	assert.True(os.IsNotExist(err))
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(ksmStateDir(), ksmSandboxesDir, "invalid"), []byte("{"), 0600))
+	assert.NoError(auto.takeKSMReference())
+	assert.NoError(auto.releaseKSMReference())
+
+	_, err = os.Stat(filepath.Join(ksmStateDir(), ksmSandboxesDir, "invalid"))
+	assert.True(os.IsNotExist(err))
+
+	settings, err = readKSMSettings()
+	assert.NoError(err)
+	assert.Equal(host, settings)
+
+	// KSM not supported by the host
+	ksmSysfsPath = filepath.Join(sysfs, "missing")
+	assert.Error(auto.takeKSMReference())
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// sandboxLabelsFile is the file the labels of a sandbox are written to, in
+// the directory of the sockets of its VM.
+const sandboxLabelsFile = "labels.json"
+
+// maxLinkAliasLen is the maximum length of the alias of a network
+// interface, IFALIASZ minus the terminating null byte.
+const maxLinkAliasLen = 255
+
+// SandboxLabels identify the workload a sandbox runs, usually a Kubernetes
+// pod. They are attached to the host resources the runtime creates for the
+// sandbox, so that the node tooling can attribute them without looking the
+// sandbox up.
+type SandboxLabels struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	UID       string `json:"uid,omitempty"`
+}
+
+func (l SandboxLabels) empty() bool {
+	return l == SandboxLabels{}
+}
+
+// fields returns the labels set as log fields.
+func (l SandboxLabels) fields() logrus.Fields {
+	fields := logrus.Fields{}
+
+	for key, value := range map[string]string{
+		"pod-namespace": l.Namespace,
+		"pod-name":      l.Name,
+		"pod-uid":       l.UID,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	return fields
+}
+
+// alias returns the labels as the alias of a network interface.
+func (l SandboxLabels) alias() string {
+	alias := fmt.Sprintf("kata:%s/%s/%s", l.Namespace, l.Name, l.UID)
+	if len(alias) > maxLinkAliasLen {
+		alias = alias[:maxLinkAliasLen]
+	}
+
+	return alias
+}
+
+// labels returns the labels of the sandbox.
+func (s *Sandbox) labels() SandboxLabels {
+	if s.config == nil {
+		return SandboxLabels{}
+	}
+
+	return s.config.Labels
+}
+
+// recordLabels attaches the labels of the sandbox to its host resources:
+// they are written next to the sockets of the VM and set as the alias of
+// the TAP devices. A resource which cannot be labelled is logged, rather
+// than failing the sandbox.
+func (s *Sandbox) recordLabels() {
+	labels := s.labels()
+	if labels.empty() {
+		return
+	}
+
+	if err := writeSandboxLabels(s.tmp().dir(), labels); err != nil {
+		s.Logger().WithError(err).Warn("Could not write the sandbox labels")
+	}
+
+	if err := setTapAliases(s.networkNS.NetNsPath, s.networkNS.Endpoints, labels.alias()); err != nil {
+		s.Logger().WithError(err).Warn("Could not label the TAP devices")
+	}
+}
+
+func writeSandboxLabels(dir string, labels SandboxLabels) error {
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, store.DirMode); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, sandboxLabelsFile), data, 0644)
+}
+
+// setTapAliases sets alias as the alias of the TAP devices of endpoints, in
+// the network namespace networkNSPath.
+func setTapAliases(networkNSPath string, endpoints []Endpoint, alias string) error {
+	if networkNSPath == "" || len(endpoints) == 0 {
+		return nil
+	}
+
+	netnsHandle, err := netns.GetFromPath(networkNSPath)
+	if err != nil {
+		return err
+	}
+	defer netnsHandle.Close()
+
+	netlinkHandle, err := netlink.NewHandleAt(netnsHandle)
+	if err != nil {
+		return err
+	}
+	defer netlinkHandle.Delete()
+
+	for _, endpoint := range endpoints {
+		pair := endpoint.NetworkPair()
+		if pair == nil || pair.TAPIface.Name == "" {
+			continue
+		}
+
+		link, err := netlinkHandle.LinkByName(pair.TAPIface.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := netlinkHandle.LinkSetAlias(link, alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
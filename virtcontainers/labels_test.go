@@ -0,0 +1,59 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := SandboxLabels{Namespace: "default", Name: "nginx"}
+	assert.False(labels.empty())
+	assert.True(SandboxLabels{}.empty())
+
+	assert.Equal(logrus.Fields{"pod-namespace": "default", "pod-name": "nginx"}, labels.fields())
+	assert.Equal("kata:default/nginx/", labels.alias())
+
+	labels.UID = strings.Repeat("x", 300)
+	assert.Len(labels.alias(), maxLinkAliasLen)
+
+	s := &Sandbox{}
+	assert.True(s.labels().empty())
+	s.config = &SandboxConfig{Labels: labels}
+	assert.Equal(labels, s.labels())
+}
+
+func TestWriteSandboxLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "labels")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	labels := SandboxLabels{Namespace: "default", Name: "nginx", UID: "1234"}
+	vmDir := filepath.Join(dir, "sandbox")
+	assert.NoError(writeSandboxLabels(vmDir, labels))
+
+	data, err := ioutil.ReadFile(filepath.Join(vmDir, sandboxLabelsFile))
+	assert.NoError(err)
+
+	var written SandboxLabels
+	assert.NoError(json.Unmarshal(data, &written))
+	assert.Equal(labels, written)
+
+	// Without a network namespace, there are no TAP devices to label.
+	assert.NoError(setTapAliases("", []Endpoint{&TapEndpoint{}}, labels.alias()))
+}
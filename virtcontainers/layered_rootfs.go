@@ -0,0 +1,217 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// The file systems of the read-only images a container rootfs can be
+// layered on. The image is passed to the guest as a read-only block device
+// and the agent stacks a writable scratch disk on it with an overlay, so
+// that the rootfs is neither mounted on the host nor shared with the guest.
+var layeredRootfsFstypes = map[string]bool{
+	"erofs":    true,
+	"squashfs": true,
+}
+
+// IsLayeredRootfs returns true if a rootfs of the file system fstype is
+// layered on a read-only image in the guest.
+func IsLayeredRootfs(fstype string) bool {
+	return layeredRootfsFstypes[fstype]
+}
+
+const (
+	// defaultRootfsScratchSizeMB is the size of the sparse scratch disks
+	// when the sandbox does not configure it.
+	defaultRootfsScratchSizeMB = 10240
+
+	rootfsScratchFstype = "ext4"
+
+	// The directories of the guest, under the directory of the container,
+	// the image and the scratch disk are mounted on.
+	layeredRootfsLowerDir   = "lower"
+	layeredRootfsScratchDir = "scratch"
+
+	// The directories of the scratch disk holding the writable layer of
+	// the overlay and its work directory.
+	layeredRootfsUpperDir = "upper"
+	layeredRootfsWorkDir  = "work"
+)
+
+// rootfsScratchDir is the directory of the scratch disk images, on a disk
+// of the host rather than the tmpfs of the runtime state.
+var rootfsScratchDir = filepath.Join(store.DefaultConfigRootPath, "scratch")
+
+func rootfsScratchImage(sandboxID, containerID string) string {
+	return filepath.Join(rootfsScratchDir, sandboxID, containerID+".img")
+}
+
+// createScratchImage creates the sparse image file of sizeMB megabytes of
+// the scratch disk, formatted with the upper and work directories of the
+// overlay when format is set. mkfs.ext4 populates the file system from a
+// directory, staged in tmp.
+func createScratchImage(path string, sizeMB uint32, format bool, tmp sandboxTmp) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	err = f.Truncate(int64(sizeMB) << 20)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if !format {
+		return nil
+	}
+
+	root, err := tmp.tempDir("scratch")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{layeredRootfsUpperDir, layeredRootfsWorkDir} {
+		if err = os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	if out, err := exec.Command(mkfsCmd, "-q", "-F", "-d", root, path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to format scratch image %q: %v: %s", path, err, out)
+	}
+
+	return nil
+}
+
+// newRootfsDevice creates and attaches the block device of the image or
+// block device path, the agent setting up a dm-integrity target of the
+// integrity algorithm on it if any.
+func (c *Container) newRootfsDevice(path string, readOnly bool, integrity string) (string, error) {
+	info := config.DeviceInfo{
+		HostPath:      path,
+		ContainerPath: filepath.Join(kataGuestSharedDir, c.id),
+		DevType:       "b",
+		ReadOnly:      readOnly,
+		Integrity:     integrity,
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return "", fmt.Errorf("stat %q failed: %v", path, err)
+	}
+
+	if stat.Mode&unix.S_IFMT == unix.S_IFBLK {
+		info.Major = int64(unix.Major(stat.Rdev))
+		info.Minor = int64(unix.Minor(stat.Rdev))
+		info.IOLimits = c.blockIOLimits(info.Major, info.Minor)
+	} else {
+		info.Format = config.BlockFormatRaw
+	}
+
+	b, err := c.sandbox.devManager.NewDevice(info)
+	if err != nil {
+		return "", fmt.Errorf("device manager failed to create rootfs device for %q: %v", path, err)
+	}
+
+	if err := c.sandbox.devManager.AttachDevice(b.DeviceID(), c.sandbox); err != nil {
+		return b.DeviceID(), err
+	}
+
+	return b.DeviceID(), nil
+}
+
+// newScratchDevice creates and attaches a new scratch disk of the
+// container, and returns the path of its image. The agent formats the
+// scratch disks protected by dm-integrity, the integrity metadata being
+// interleaved with the data.
+func (c *Container) newScratchDevice() (string, error) {
+	sizeMB := c.sandbox.config.RootfsScratchSize
+	if sizeMB == 0 {
+		sizeMB = defaultRootfsScratchSizeMB
+	}
+
+	integrity := c.sandbox.config.ScratchIntegrity
+	if integrity != "" {
+		if err := checkIntegritySupport(c.sandbox); err != nil {
+			return "", err
+		}
+	}
+
+	scratch := rootfsScratchImage(c.sandboxID, c.id)
+	if err := createScratchImage(scratch, sizeMB, integrity == "", c.sandbox.tmp()); err != nil {
+		return "", err
+	}
+
+	var err error
+	c.state.ScratchDeviceID, err = c.newRootfsDevice(scratch, false, integrity)
+
+	return scratch, err
+}
+
+// hotplugLayeredRootfs hotplugs the read-only image of the rootfs and a
+// new scratch disk, which the agent stacks with an overlay.
+func (c *Container) hotplugLayeredRootfs() error {
+	image, err := filepath.EvalSymlinks(c.rootFs.Source)
+	if err != nil {
+		return err
+	}
+
+	// The state is set first for removeDrive to roll back.
+	c.state.Fstype = c.rootFs.Type
+
+	if c.state.BlockDeviceID, err = c.newRootfsDevice(image, true, ""); err != nil {
+		return err
+	}
+
+	scratch, err := c.newScratchDevice()
+	if err != nil {
+		return err
+	}
+
+	c.Logger().WithFields(logrus.Fields{
+		"image":   image,
+		"fs-type": c.rootFs.Type,
+		"scratch": scratch,
+	}).Info("Rootfs layered on a read-only image")
+
+	if !c.sandbox.supportNewStore() {
+		if err := c.sandbox.storeSandboxDevices(); err != nil {
+			return err
+		}
+	}
+
+	return c.setStateFstype(c.rootFs.Type)
+}
+
+// cleanupRootfsScratch removes the directory of the scratch images of the
+// sandbox, left empty once its containers are deleted.
+func (s *Sandbox) cleanupRootfsScratch() {
+	dir := filepath.Join(rootfsScratchDir, s.id)
+	if err := os.RemoveAll(dir); err != nil {
+		s.Logger().WithError(err).WithField("dir", dir).Warn("failed to remove the rootfs scratch directory")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/kata-containers/agent/protocols/grpc"
+	"github.com/kata-containers/runtime/virtcontainers/device/api"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
+	"github.com/kata-containers/runtime/virtcontainers/device/manager"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLayeredRootfs(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsLayeredRootfs("erofs"))
+	assert.True(IsLayeredRootfs("squashfs"))
+	assert.False(IsLayeredRootfs("ext4"))
+	assert.False(IsLayeredRootfs(""))
+}
+
+func TestCreateScratchImage(t *testing.T) {
+	assert := assert.New(t)
+
+	savedMkfsCmd := mkfsCmd
+	defer func() {
+		mkfsCmd = savedMkfsCmd
+	}()
+
+	dir, err := ioutil.TempDir("", "scratch")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedRunVMStoragePath := store.RunVMStoragePath
+	store.RunVMStoragePath = filepath.Join(dir, "vm")
+	defer func() {
+		store.RunVMStoragePath = savedRunVMStoragePath
+	}()
+
+	path := filepath.Join(dir, testSandboxID, "ctr.img")
+	tmp := sandboxTmp{id: testSandboxID}
+
+	mkfsCmd = "false"
+	assert.Error(createScratchImage(path, 16, true, tmp))
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+
+	mkfsCmd = "true"
+	assert.NoError(createScratchImage(path, 16, true, tmp))
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(int64(16<<20), info.Size())
+
+	// The file system is staged in the temporary files of the sandbox.
+	staged, err := ioutil.ReadDir(tmp.dir())
+	assert.NoError(err)
+	assert.Empty(staged)
+
+	// Left to the agent to format
+	mkfsCmd = "false"
+	assert.NoError(createScratchImage(path, 32, false, tmp))
+	info, err = os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(int64(32<<20), info.Size())
+}
+
+func TestNewScratchDeviceIntegrity(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Container{
+		id:        "container",
+		sandboxID: "sandbox",
+		sandbox: &Sandbox{
+			config: &SandboxConfig{
+				ScratchIntegrity: config.BlockIntegritySHA256,
+			},
+		},
+	}
+
+	// The scratch disks are not left unprotected by an agent unable to
+	// set up the dm-integrity targets.
+	_, err := c.newScratchDevice()
+	assert.Error(err)
+}
+
+func TestBuildLayeredRootfs(t *testing.T) {
+	assert := assert.New(t)
+	k := kataAgent{}
+
+	ctrDevices := []api.Device{
+		&drivers.BlockDevice{
+			GenericDevice: &drivers.GenericDevice{ID: "image"},
+			BlockDrive:    &config.BlockDrive{PCIAddr: "02/01"},
+		},
+		&drivers.BlockDevice{
+			GenericDevice: &drivers.GenericDevice{ID: "scratch"},
+			BlockDrive:    &config.BlockDrive{PCIAddr: "02/02"},
+		},
+	}
+
+	sandbox := &Sandbox{
+		devManager: manager.NewDeviceManager(manager.VirtioBlock, ctrDevices),
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				BlockDeviceDriver: config.VirtioBlock,
+			},
+		},
+	}
+
+	c := &Container{
+		id:           testContainerID,
+		sandbox:      sandbox,
+		rootfsSuffix: "rootfs",
+	}
+	c.state.BlockDeviceID = "image"
+	c.state.ScratchDeviceID = "scratch"
+	c.state.Fstype = "erofs"
+
+	parent := filepath.Join(kataGuestSharedDir, c.id)
+
+	storages, err := k.buildLayeredRootfs(sandbox, c, parent)
+	assert.NoError(err)
+	assert.Equal([]*pb.Storage{
+		{
+			Driver:     kataBlkDevType,
+			Source:     "02/01",
+			Fstype:     "erofs",
+			Options:    []string{"ro"},
+			MountPoint: filepath.Join(parent, "lower"),
+		},
+		{
+			Driver:     kataBlkDevType,
+			Source:     "02/02",
+			Fstype:     "ext4",
+			MountPoint: filepath.Join(parent, "scratch"),
+		},
+		{
+			Driver: KataEphemeralDevType,
+			Source: "overlay",
+			Fstype: "overlay",
+			Options: []string{
+				"lowerdir=" + filepath.Join(parent, "lower"),
+				"upperdir=" + filepath.Join(parent, "scratch", "upper"),
+				"workdir=" + filepath.Join(parent, "scratch", "work"),
+			},
+			MountPoint: filepath.Join(parent, "rootfs"),
+		},
+	}, storages)
+
+	// The agent formats the scratch disk protected by dm-integrity
+	ctrDevices[1].(*drivers.BlockDevice).BlockDrive.Integrity = config.BlockIntegrityHMACSHA256
+
+	storages, err = k.buildLayeredRootfs(sandbox, c, parent)
+	assert.NoError(err)
+	assert.Equal([]string{
+		kataIntegrityDriverOption,
+		integrityAlgDriverOption + config.BlockIntegrityHMACSHA256,
+		integrityDirsDriverOption + "upper,work",
+	}, storages[1].DriverOptions)
+
+	c.state.ScratchDeviceID = "missing"
+	_, err = k.buildLayeredRootfs(sandbox, c, parent)
+	assert.Error(err)
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+)
+
+// maxMacAddressAttempts bounds the number of MAC addresses derived from a
+// sandbox ID and an interface name before giving up on collisions.
+const maxMacAddressAttempts = 16
+
+// macAddressRegistryPath is the node level directory recording the MAC
+// addresses allocated to the sandboxes, one file per address.
+var macAddressRegistryPath = filepath.Join(store.DefaultRunRootPath, macAddressRegistryDir)
+
+const (
+	macAddressRegistryDir = "macs"
+	macAddressLockFile    = "lock"
+)
+
+// macAddressEntry is the content of a MAC address registry file.
+type macAddressEntry struct {
+	SandboxID string `json:"sandboxID"`
+	Interface string `json:"interface"`
+}
+
+func macAddressEntryPath(mac net.HardwareAddr) string {
+	return filepath.Join(macAddressRegistryPath, mac.String())
+}
+
+// sandboxMacAddress derives a locally administered unicast MAC address from
+// the sandbox ID and the interface name. attempt gives other addresses for
+// the same interface when the previous ones collide.
+func sandboxMacAddress(sandboxID, ifName string, attempt int) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(sandboxID + "/" + ifName + "/" + strconv.Itoa(attempt)))
+
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = (mac[0] | 2) & 0xfe
+
+	return mac
+}
+
+// ipMacAddress derives a MAC address from the first IPv4 address of addrs,
+// the same way docker does.
+func ipMacAddress(addrs []netlink.Addr) (net.HardwareAddr, error) {
+	for _, addr := range addrs {
+		if addr.IPNet == nil {
+			continue
+		}
+
+		if ip := addr.IP.To4(); ip != nil {
+			return net.HardwareAddr{0x02, 0x42, ip[0], ip[1], ip[2], ip[3]}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No IPv4 address to derive a MAC address from")
+}
+
+// withMacAddressLock runs fn holding the node wide MAC address registry
+// lock, the registry being shared by the runtimes of all the sandboxes.
+func withMacAddressLock(fn func() error) error {
+	if err := os.MkdirAll(macAddressRegistryPath, store.DirMode); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(macAddressRegistryPath, macAddressLockFile), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// stale returns true if the sandbox the MAC address is allocated to does not
+// exist anymore, e.g. it was left behind by a runtime which crashed.
+func (e macAddressEntry) stale() bool {
+	if e.SandboxID == "" {
+		return true
+	}
+
+	_, err := os.Stat(store.SandboxRuntimeRootPath(e.SandboxID))
+	return os.IsNotExist(err)
+}
+
+// macAddressEntries returns the allocated MAC addresses, by address. It is
+// called with the registry lock held, the stale and invalid entries are
+// pruned.
+func macAddressEntries() (map[string]macAddressEntry, error) {
+	entries := make(map[string]macAddressEntry)
+
+	files, err := ioutil.ReadDir(macAddressRegistryPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	for _, file := range files {
+		mac, err := net.ParseMAC(file.Name())
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(macAddressRegistryPath, file.Name())
+
+		entry, err := readMacAddressEntry(path)
+		if err != nil || entry.stale() {
+			networkLogger().WithField("mac-address", mac.String()).Warn("Pruning a stale MAC address registry entry")
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		entries[mac.String()] = entry
+	}
+
+	return entries, nil
+}
+
+// claimMacAddress records mac as allocated to entry. It is called with the
+// registry lock held, and returns false if mac is already allocated to
+// another sandbox interface.
+func claimMacAddress(entries map[string]macAddressEntry, mac net.HardwareAddr, entry macAddressEntry) (bool, error) {
+	if owner, ok := entries[mac.String()]; ok {
+		return owner == entry, nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	if err := ioutil.WriteFile(macAddressEntryPath(mac), data, defaultFilePerms); err != nil {
+		os.Remove(macAddressEntryPath(mac))
+		return false, err
+	}
+
+	entries[mac.String()] = entry
+
+	return true, nil
+}
+
+func readMacAddressEntry(path string) (macAddressEntry, error) {
+	var entry macAddressEntry
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("Invalid MAC address registry entry %s: %v", path, err)
+	}
+
+	return entry, nil
+}
+
+// relocateMacAddressRegistry moves the MAC address registry under runRoot.
+// The registry must stay shared with the sandboxes created before, hence it
+// is migrated along with them.
+func relocateMacAddressRegistry(runRoot string) error {
+	oldPath := macAddressRegistryPath
+	macAddressRegistryPath = filepath.Join(runRoot, macAddressRegistryDir)
+
+	if filepath.Clean(oldPath) == filepath.Clean(macAddressRegistryPath) {
+		return nil
+	}
+
+	return store.MigrateDir(oldPath, macAddressRegistryPath)
+}
+
+// releaseMacAddresses releases all the MAC addresses allocated to the
+// sandbox.
+func releaseMacAddresses(sandboxID string) error {
+	if _, err := os.Stat(macAddressRegistryPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	return withMacAddressLock(func() error {
+		entries, err := macAddressEntries()
+		if err != nil {
+			return err
+		}
+
+		for mac, owner := range entries {
+			if owner.SandboxID != sandboxID {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(macAddressRegistryPath, mac)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// allocateMacAddress returns the MAC address of the network interface
+// according to policy, and records it in the node registry.
+func allocateMacAddress(sandboxID string, netInfo NetworkInfo, policy MacAddressPolicy) (mac net.HardwareAddr, err error) {
+	entry := macAddressEntry{
+		SandboxID: sandboxID,
+		Interface: netInfo.Iface.Name,
+	}
+
+	err = withMacAddressLock(func() error {
+		entries, err := macAddressEntries()
+		if err != nil {
+			return err
+		}
+
+		mac, err = pickMacAddress(entries, entry, netInfo, policy)
+		return err
+	})
+
+	return mac, err
+}
+
+// pickMacAddress allocates the MAC address of entry according to policy. It
+// is called with the registry lock held.
+func pickMacAddress(entries map[string]macAddressEntry, entry macAddressEntry, netInfo NetworkInfo, policy MacAddressPolicy) (net.HardwareAddr, error) {
+	switch policy {
+	case MacAddressPolicySandbox:
+		// The re-created endpoints get the same address.
+		for mac, owner := range entries {
+			if owner == entry {
+				return net.ParseMAC(mac)
+			}
+		}
+
+		for attempt := 0; attempt < maxMacAddressAttempts; attempt++ {
+			mac := sandboxMacAddress(entry.SandboxID, entry.Interface, attempt)
+
+			claimed, err := claimMacAddress(entries, mac, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			if claimed {
+				return mac, nil
+			}
+
+			networkLogger().WithField("mac-address", mac.String()).Warn("MAC address collision, trying another one")
+		}
+
+		return nil, fmt.Errorf("Could not allocate a MAC address for interface %s", entry.Interface)
+	case MacAddressPolicyIP:
+		mac, err := ipMacAddress(netInfo.Addrs)
+		if err != nil {
+			return nil, err
+		}
+
+		claimed, err := claimMacAddress(entries, mac, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if !claimed {
+			return nil, fmt.Errorf("MAC address %s of interface %s is already allocated", mac, entry.Interface)
+		}
+
+		return mac, nil
+	}
+
+	return nil, fmt.Errorf("Unknown MAC address policy %s", policy)
+}
+
+// applyMacAddressPolicy sets the MAC addresses of the network interfaces
+// found in the network namespace according to policy, before the endpoints
+// are created from them. Only the interfaces whose MAC address is handed to
+// the VM can be changed.
+func applyMacAddressPolicy(networkNSPath, sandboxID string, policy MacAddressPolicy) error {
+	if policy != MacAddressPolicySandbox && policy != MacAddressPolicyIP {
+		return nil
+	}
+
+	netnsHandle, err := netns.GetFromPath(networkNSPath)
+	if err != nil {
+		return err
+	}
+	defer netnsHandle.Close()
+
+	netlinkHandle, err := netlink.NewHandleAt(netnsHandle)
+	if err != nil {
+		return err
+	}
+	defer netlinkHandle.Delete()
+
+	linkList, err := netlinkHandle.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, link := range linkList {
+		if link.Type() != "veth" && link.Type() != "macvlan" {
+			continue
+		}
+
+		netInfo, err := networkInfoFromLink(netlinkHandle, link)
+		if err != nil {
+			return err
+		}
+
+		// Skip the interfaces ignored by createEndpointsFromScan().
+		if len(netInfo.Addrs) == 0 || (netInfo.Iface.Flags&net.FlagLoopback) != 0 {
+			continue
+		}
+
+		mac, err := allocateMacAddress(sandboxID, netInfo, policy)
+		if err != nil {
+			return err
+		}
+
+		if netInfo.Iface.HardwareAddr.String() == mac.String() {
+			continue
+		}
+
+		networkLogger().WithFields(logrus.Fields{
+			"interface":   netInfo.Iface.Name,
+			"mac-address": mac.String(),
+		}).Info("Setting MAC address")
+
+		if err := netlinkHandle.LinkSetHardwareAddr(link, mac); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestSandboxMacAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	mac := sandboxMacAddress("sandbox", "eth0", 0)
+	assert.Len(mac, 6)
+	assert.Equal(mac, sandboxMacAddress("sandbox", "eth0", 0))
+
+	// locally administered unicast address
+	assert.Equal(byte(2), mac[0]&3)
+
+	assert.NotEqual(mac, sandboxMacAddress("sandbox", "eth0", 1))
+	assert.NotEqual(mac, sandboxMacAddress("sandbox", "eth1", 0))
+	assert.NotEqual(mac, sandboxMacAddress("other", "eth0", 0))
+}
+
+func TestIPMacAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ipMacAddress(nil)
+	assert.Error(err)
+
+	ipv6 := netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}}
+	_, err = ipMacAddress([]netlink.Addr{ipv6})
+	assert.Error(err)
+
+	ipv4 := netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)}}
+	mac, err := ipMacAddress([]netlink.Addr{ipv6, ipv4})
+	assert.NoError(err)
+	assert.Equal("02:42:ac:11:00:02", mac.String())
+}
+
+// testClaimMacAddress records mac as allocated to entry, as allocateMacAddress
+// does.
+func testClaimMacAddress(mac net.HardwareAddr, entry macAddressEntry) (claimed bool, err error) {
+	err = withMacAddressLock(func() error {
+		entries, err := macAddressEntries()
+		if err != nil {
+			return err
+		}
+
+		claimed, err = claimMacAddress(entries, mac, entry)
+		return err
+	})
+
+	return claimed, err
+}
+
+func TestAllocateMacAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	defer os.RemoveAll(macAddressRegistryPath)
+
+	// the entries of the sandboxes which do not exist are stale
+	for _, id := range []string{"sandbox1", "sandbox2", "sandbox3"} {
+		assert.NoError(os.MkdirAll(store.SandboxRuntimeRootPath(id), store.DirMode))
+		defer os.RemoveAll(store.SandboxRuntimeRootPath(id))
+	}
+
+	netInfo := NetworkInfo{
+		Iface: NetlinkIface{
+			LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+		},
+		Addrs: []netlink.Addr{
+			{IPNet: &net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)}},
+		},
+	}
+
+	mac, err := allocateMacAddress("sandbox1", netInfo, MacAddressPolicySandbox)
+	assert.NoError(err)
+	assert.Equal(sandboxMacAddress("sandbox1", "eth0", 0), mac)
+
+	// the same interface gets the same address again
+	again, err := allocateMacAddress("sandbox1", netInfo, MacAddressPolicySandbox)
+	assert.NoError(err)
+	assert.Equal(mac, again)
+
+	// another sandbox colliding with the first address gets another one
+	claimed, err := testClaimMacAddress(sandboxMacAddress("sandbox2", "eth0", 0), macAddressEntry{"sandbox3", "eth0"})
+	assert.NoError(err)
+	assert.True(claimed)
+
+	mac2, err := allocateMacAddress("sandbox2", netInfo, MacAddressPolicySandbox)
+	assert.NoError(err)
+	assert.Equal(sandboxMacAddress("sandbox2", "eth0", 1), mac2)
+
+	// derived from the IP address, collisions are errors
+	ipMac, err := allocateMacAddress("sandbox1", netInfo, MacAddressPolicyIP)
+	assert.NoError(err)
+	assert.Equal("02:42:ac:11:00:02", ipMac.String())
+
+	_, err = allocateMacAddress("sandbox2", netInfo, MacAddressPolicyIP)
+	assert.Error(err)
+
+	_, err = allocateMacAddress("sandbox1", netInfo, "random")
+	assert.Error(err)
+
+	// released addresses can be allocated by other sandboxes
+	assert.NoError(releaseMacAddresses("sandbox1"))
+
+	_, err = os.Stat(macAddressEntryPath(mac))
+	assert.True(os.IsNotExist(err))
+
+	_, err = os.Stat(macAddressEntryPath(mac2))
+	assert.NoError(err)
+
+	ipMac, err = allocateMacAddress("sandbox2", netInfo, MacAddressPolicyIP)
+	assert.NoError(err)
+	assert.Equal("02:42:ac:11:00:02", ipMac.String())
+}
+
+func TestMacAddressStaleEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	defer os.RemoveAll(macAddressRegistryPath)
+
+	assert.NoError(os.MkdirAll(store.SandboxRuntimeRootPath("sandbox1"), store.DirMode))
+	defer os.RemoveAll(store.SandboxRuntimeRootPath("sandbox1"))
+
+	netInfo := NetworkInfo{
+		Iface: NetlinkIface{
+			LinkAttrs: netlink.LinkAttrs{Name: "eth0"},
+		},
+	}
+
+	// an address left behind by a sandbox which does not exist anymore
+	mac := sandboxMacAddress("sandbox1", "eth0", 0)
+	claimed, err := testClaimMacAddress(mac, macAddressEntry{"gone", "eth0"})
+	assert.NoError(err)
+	assert.True(claimed)
+
+	// and an entry left half written
+	invalid := sandboxMacAddress("sandbox1", "eth0", 1)
+	assert.NoError(ioutil.WriteFile(macAddressEntryPath(invalid), nil, 0640))
+
+	allocated, err := allocateMacAddress("sandbox1", netInfo, MacAddressPolicySandbox)
+	assert.NoError(err)
+	assert.Equal(mac, allocated)
+
+	_, err = os.Stat(macAddressEntryPath(invalid))
+	assert.True(os.IsNotExist(err))
+
+	entry, err := readMacAddressEntry(macAddressEntryPath(mac))
+	assert.NoError(err)
+	assert.Equal(macAddressEntry{"sandbox1", "eth0"}, entry)
+
+	// the lock file is not an entry
+	assert.NoError(releaseMacAddresses("sandbox1"))
+	_, err = os.Stat(filepath.Join(macAddressRegistryPath, macAddressLockFile))
+	assert.NoError(err)
+	_, err = os.Stat(macAddressEntryPath(mac))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestRelocateMacAddressRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	savedPath := macAddressRegistryPath
+	defer func() {
+		macAddressRegistryPath = savedPath
+	}()
+
+	dir, err := ioutil.TempDir("", "macs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// no registry to share yet
+	macAddressRegistryPath = filepath.Join(dir, "old", macAddressRegistryDir)
+	assert.NoError(relocateMacAddressRegistry(filepath.Join(dir, "new")))
+	assert.Equal(filepath.Join(dir, "new", macAddressRegistryDir), macAddressRegistryPath)
+	_, err = os.Lstat(macAddressRegistryPath)
+	assert.True(os.IsNotExist(err))
+
+	oldPath := filepath.Join(dir, "old", macAddressRegistryDir)
+	assert.NoError(os.MkdirAll(oldPath, 0750))
+	assert.NoError(ioutil.WriteFile(filepath.Join(oldPath, "02:00:00:00:00:01"), []byte("{}"), 0640))
+	macAddressRegistryPath = oldPath
+	assert.NoError(relocateMacAddressRegistry(filepath.Join(dir, "new")))
+
+	// the registry is moved, the previous path links to it
+	_, err = os.Stat(filepath.Join(macAddressRegistryPath, "02:00:00:00:00:01"))
+	assert.NoError(err)
+	target, err := os.Readlink(oldPath)
+	assert.NoError(err)
+	assert.Equal(macAddressRegistryPath, target)
+}
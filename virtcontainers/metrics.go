@@ -0,0 +1,75 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hotplugDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "kata_hypervisor_hotplug_duration_seconds",
+		Help: "Duration of the device hotplug operations.",
+	}, []string{"op", "device"})
+	hotplugFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kata_hypervisor_hotplug_failures_total",
+		Help: "Number of the device hotplug operations which failed.",
+	}, []string{"op", "device"})
+
+	qmpCommandDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "kata_qmp_command_duration_seconds",
+		Help: "Duration of the QMP commands sent to QEMU.",
+	}, []string{"command"})
+	qmpCommandFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kata_qmp_command_failures_total",
+		Help: "Number of the QMP commands QEMU returned an error for.",
+	}, []string{"command"})
+
+	agentRPCDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "kata_agent_rpc_duration_seconds",
+		Help: "Duration of the RPCs sent to the agent.",
+	}, []string{"request"})
+	agentRPCFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kata_agent_rpc_failures_total",
+		Help: "Number of the RPCs sent to the agent which failed.",
+	}, []string{"request"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		hotplugDurations,
+		hotplugFailures,
+		qmpCommandDurations,
+		qmpCommandFailures,
+		agentRPCDurations,
+		agentRPCFailures,
+	)
+}
+
+// observeHotplug records the duration and the failure of a hotplug
+// operation started at start.
+func observeHotplug(op operation, devType deviceType, start time.Time, err error) {
+	hotplugDurations.WithLabelValues(op.String(), devType.String()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		hotplugFailures.WithLabelValues(op.String(), devType.String()).Inc()
+	}
+}
+
+// qmpExecute runs execute, which sends the QMP command to QEMU, and records
+// its duration and its failure.
+func qmpExecute(command string, execute func() error) error {
+	start := time.Now()
+	err := execute()
+
+	qmpCommandDurations.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		qmpCommandFailures.WithLabelValues(command).Inc()
+	}
+
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMetrics(t *testing.T) string {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		_, err := expfmt.MetricFamilyToText(&buf, f)
+		assert.NoError(t, err)
+	}
+
+	return buf.String()
+}
+
+func TestObserveHotplug(t *testing.T) {
+	assert := assert.New(t)
+
+	observeHotplug(addDevice, vfioDev, time.Now(), nil)
+	observeHotplug(addDevice, vfioDev, time.Now(), errors.New("no slot"))
+
+	out := writeMetrics(t)
+	assert.Contains(out, `kata_hypervisor_hotplug_duration_seconds_count{device="VFIO",op="add"} 2`)
+	assert.Contains(out, `kata_hypervisor_hotplug_failures_total{device="VFIO",op="add"} 1`)
+}
+
+func TestQMPExecute(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(qmpExecute("test-qmp-command", func() error { return nil }))
+	assert.Error(qmpExecute("test-qmp-command", func() error { return errors.New("failed") }))
+
+	out := writeMetrics(t)
+	assert.Contains(out, `kata_qmp_command_duration_seconds_count{command="test-qmp-command"} 2`)
+	assert.Contains(out, `kata_qmp_command_failures_total{command="test-qmp-command"} 1`)
+}
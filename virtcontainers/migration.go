@@ -0,0 +1,432 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+)
+
+// defaultMigrationTimeout is the time given to a live migration to complete
+// when the migration configuration does not set any.
+const defaultMigrationTimeout = 5 * time.Minute
+
+// MigrationFeature is the experimental feature enabling the live migration
+// of sandboxes between hosts.
+var MigrationFeature = exp.Feature{
+	Name:        "migration",
+	Description: "Live migrate sandboxes between hosts sharing the containers storage.",
+	ExpRelease:  "2.0",
+}
+
+func init() {
+	if err := exp.Register(MigrationFeature); err != nil {
+		virtLog.WithError(err).Error("failed to register migration experimental feature")
+	}
+}
+
+// MigrationConfig is the configuration of a sandbox live migration.
+// Both the source and the target of the migration use the same one.
+type MigrationConfig struct {
+	// URI is the migration stream URI, "tcp:<host>:<port>" or
+	// "unix:<path>". The target listens on it, the source connects to it.
+	URI string
+
+	// TLSDir is the directory of the x509 credentials the migration
+	// stream is authenticated and encrypted with: ca-cert.pem, plus
+	// server-cert.pem and server-key.pem on the target host, or
+	// client-cert.pem and client-key.pem on the source host. Both ends
+	// verify the certificate of their peer. It is required by the tcp
+	// URIs, the unix ones being reachable from the local host only.
+	TLSDir string
+
+	// SandboxConfigDigest is the digest of the sandbox configuration
+	// given to the target host, as returned by SandboxConfigDigest. The
+	// source refuses to migrate a sandbox whose configuration changed
+	// since it has been prepared.
+	SandboxConfigDigest string
+
+	// MultifdChannels is the number of parallel channels the memory is
+	// streamed through. Zero streams it through a single channel.
+	MultifdChannels uint32
+
+	// Compress compresses the migration stream.
+	Compress bool
+
+	// Timeout is the time given to the migration to complete.
+	Timeout time.Duration
+}
+
+func (config MigrationConfig) valid() error {
+	switch {
+	case strings.HasPrefix(config.URI, "tcp:"):
+		if strings.Count(config.URI, ":") < 2 {
+			return fmt.Errorf("Invalid migration URI %q, expecting tcp:<host>:<port>", config.URI)
+		}
+		if config.TLSDir == "" {
+			return fmt.Errorf("Migration URI %q requires the TLS credentials directory", config.URI)
+		}
+	case strings.HasPrefix(config.URI, "unix:"):
+		if config.URI == "unix:" {
+			return fmt.Errorf("Invalid migration URI %q, expecting unix:<path>", config.URI)
+		}
+	default:
+		return fmt.Errorf("Invalid migration URI %q, only tcp and unix URIs are supported", config.URI)
+	}
+
+	return nil
+}
+
+func (config MigrationConfig) timeout() time.Duration {
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+
+	return defaultMigrationTimeout
+}
+
+// SandboxConfigDigest returns the digest of the sandbox configuration data,
+// as written from the configuration PrepareMigration returns.
+func SandboxConfigDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationEnabled returns true if the migration experimental feature is
+// enabled for the sandbox of sandboxConfig.
+func migrationEnabled(sandboxConfig *SandboxConfig) bool {
+	for _, f := range sandboxConfig.Experimental {
+		if f == MigrationFeature && exp.Get(MigrationFeature.Name) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkMigratableConfig returns an error if the migration experimental
+// feature is not enabled for the sandbox of config, or if its VM relies on
+// host processes or kernel state the migration does not hand over: the
+// virtio-fs daemon and the vhost-vsock connections of the agent stay on the
+// source host.
+func checkMigratableConfig(sandboxConfig *SandboxConfig) error {
+	if !migrationEnabled(sandboxConfig) {
+		return fmt.Errorf("Live migration requires the %q experimental feature", MigrationFeature.Name)
+	}
+
+	if sandboxConfig.HypervisorConfig.SharedFS == config.VirtioFS {
+		return errors.Wrap(vcTypes.ErrNotSupported, "live migration of the virtio-fs shared file system")
+	}
+
+	if sandboxConfig.HypervisorConfig.UseVSock {
+		return errors.Wrap(vcTypes.ErrNotSupported, "live migration of the vsock agent connection")
+	}
+
+	return nil
+}
+
+// checkMigratable returns an error if the containers of the sandbox are not
+// running or rely on block devices, which are not migrated.
+func (s *Sandbox) checkMigratable() error {
+	if s.state.State != types.StateRunning {
+		return fmt.Errorf("Sandbox not running, impossible to migrate it")
+	}
+
+	if err := checkMigratableConfig(s.config); err != nil {
+		return err
+	}
+
+	for _, c := range s.containers {
+		if c.state.State != types.StateRunning {
+			return fmt.Errorf("Container %s not running, impossible to migrate the sandbox", c.id)
+		}
+
+		if c.state.BlockDeviceID != "" {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "live migration of the block based rootfs of container %s", c.id)
+		}
+
+		for _, m := range c.mounts {
+			if len(m.BlockDeviceID) > 0 {
+				return errors.Wrapf(vcTypes.ErrNotSupported, "live migration of the block device mount %q", m.Destination)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migratedConfig returns the configuration the sandbox has to be received
+// from on the target host: the containers mounts record the host paths they
+// are shared with the guest through.
+func (s *Sandbox) migratedConfig() *SandboxConfig {
+	config := *s.config
+	config.Containers = make([]ContainerConfig, len(s.config.Containers))
+
+	for i, contConfig := range s.config.Containers {
+		if c, ok := s.containers[contConfig.ID]; ok {
+			contConfig.Mounts = append([]Mount{}, c.mounts...)
+		}
+		config.Containers[i] = contConfig
+	}
+
+	return &config
+}
+
+// PrepareMigration returns the configuration the target host has to receive
+// the sandbox from, which has to be given to it before the migration starts.
+func (s *Sandbox) PrepareMigration() (*SandboxConfig, error) {
+	if err := s.checkMigratable(); err != nil {
+		return nil, err
+	}
+
+	return s.migratedConfig(), nil
+}
+
+// Migrate live migrates the VM of the sandbox to the target host listening on
+// config.URI, and stops the sandbox on this host once the migration has
+// completed. The target must have been given the sandbox configuration
+// PrepareMigration returned, whose digest is config.SandboxConfigDigest.
+//
+// The storage of the containers has to be available at the same paths on
+// the target host, and the sandbox must not have hotplugged resources. The
+// container processes IO and shims stay on this host.
+func (s *Sandbox) Migrate(config MigrationConfig) error {
+	span, _ := s.trace("migrate")
+	defer span.Finish()
+
+	if err := config.valid(); err != nil {
+		return err
+	}
+
+	migratedConfig, err := s.PrepareMigration()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(migratedConfig)
+	if err != nil {
+		return err
+	}
+
+	if SandboxConfigDigest(data) != config.SandboxConfigDigest {
+		return fmt.Errorf("Sandbox %s configuration changed since the migration has been prepared", s.id)
+	}
+
+	s.Logger().WithField("uri", config.URI).Info("Migrating sandbox")
+
+	if err := s.hypervisor.migrateSandbox(config); err != nil {
+		return err
+	}
+
+	// The sandbox is running on the target host now, only the host
+	// resources are left to release here.
+	s.agent.markDead()
+	s.setShutdownReason(types.ShutdownReasonMigrated, config.URI)
+
+	if err := s.Stop(true); err != nil {
+		return err
+	}
+
+	s.Logger().Info("Sandbox migrated")
+
+	return nil
+}
+
+// receiveSandbox creates the sandbox of sandboxConfig, as returned by
+// Sandbox.PrepareMigration on the source host, and receives its VM migrated
+// through config.URI.
+func receiveSandbox(ctx context.Context, sandboxConfig SandboxConfig, config MigrationConfig) (_ *Sandbox, err error) {
+	span, ctx := trace(ctx, "receiveSandbox")
+	defer span.Finish()
+
+	if err := config.valid(); err != nil {
+		return nil, err
+	}
+
+	if err := checkMigratableConfig(&sandboxConfig); err != nil {
+		return nil, err
+	}
+
+	sandboxConfig.Containers = append([]ContainerConfig{}, sandboxConfig.Containers...)
+	for i, contConfig := range sandboxConfig.Containers {
+		if contConfig.Spec != nil {
+			continue
+		}
+
+		spec, err := compatoci.GetContainerSpec(contConfig.Annotations)
+		if err != nil {
+			return nil, err
+		}
+		sandboxConfig.Containers[i].Spec = &spec
+	}
+
+	// The VM is started waiting for the migration stream.
+	sandboxConfig.HypervisorConfig.IncomingMigration = true
+
+	s, err := createSandbox(ctx, sandboxConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.state.State != types.StateReady || len(s.containers) > 0 {
+		return nil, fmt.Errorf("Sandbox %s already exists", s.id)
+	}
+
+	if s.config.SandboxCgroupOnly {
+		if err := s.setupSandboxCgroup(); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			s.Delete()
+		}
+	}()
+
+	if err = s.createNetwork(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			s.removeNetwork()
+		}
+	}()
+
+	if err = s.receiveVM(config); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			s.stopVM()
+		}
+	}()
+
+	s.postCreatedNetwork()
+
+	if err = s.getAndStoreGuestDetails(); err != nil {
+		return nil, err
+	}
+
+	if err = s.receiveContainers(); err != nil {
+		return nil, err
+	}
+
+	// A restart of the VM boots it normally.
+	s.config.HypervisorConfig.IncomingMigration = false
+
+	if err = s.setSandboxState(types.StateRunning); err != nil {
+		return nil, err
+	}
+
+	if err = s.storeSandbox(); err != nil {
+		return nil, err
+	}
+
+	s.startAutoscaler()
+	s.startNetworkWatcher()
+
+	s.Logger().Info("Sandbox received")
+
+	return s, nil
+}
+
+// receiveVM starts the VM of the sandbox and waits for its state to be
+// migrated in. The agent resumes serving the sandbox it was running on the
+// source host.
+func (s *Sandbox) receiveVM(config MigrationConfig) (err error) {
+	span, _ := s.trace("receiveVM")
+	defer span.Finish()
+
+	s.Logger().Info("Starting VM to receive the migration")
+
+	if err := s.network.Run(s.networkNS.NetNsPath, func() error {
+		return s.hypervisor.startSandbox(s.config.HypervisorConfig.startTimeout())
+	}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			s.hypervisor.stopSandbox()
+		}
+	}()
+
+	if err = s.hypervisor.receiveMigration(config); err != nil {
+		return err
+	}
+
+	if err = s.setHypervisorOOMScoreAdj(); err != nil {
+		return err
+	}
+
+	if err = s.pinHypervisorVCPUs(); err != nil {
+		return err
+	}
+
+	if err = s.recordProvenance(); err != nil {
+		return err
+	}
+
+	s.recordLabels()
+
+	if err = s.takeKSMReference(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			s.releaseKSMReference()
+		}
+	}()
+
+	s.Logger().Info("VM migrated")
+
+	if err = s.agent.startProxy(s); err != nil {
+		return err
+	}
+
+	return s.agent.check()
+}
+
+// receiveContainers adds the containers of the sandbox, already running in
+// the migrated VM, and shares their files with the guest again.
+func (s *Sandbox) receiveContainers() error {
+	for _, contConfig := range s.config.Containers {
+		c, err := newContainer(s, contConfig)
+		if err != nil {
+			return err
+		}
+
+		if err := s.agent.shareContainerFiles(s, c); err != nil {
+			return err
+		}
+
+		if err := s.addContainer(c); err != nil {
+			return err
+		}
+
+		if err := c.setContainerState(types.StateRunning); err != nil {
+			return err
+		}
+	}
+
+	return s.cgroupsUpdate()
+}
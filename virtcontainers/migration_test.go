@@ -0,0 +1,190 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationConfigValid(t *testing.T) {
+	assert := assert.New(t)
+
+	for uri, valid := range map[string]bool{
+		"":                    false,
+		"tcp:":                false,
+		"tcp:192.168.0.2":     false,
+		"tcp:192.168.0.2:444": true,
+		"unix:":               false,
+		"unix:/run/migration": true,
+		"exec:cat":            false,
+	} {
+		err := MigrationConfig{URI: uri, TLSDir: "/etc/pki/qemu"}.valid()
+		if valid {
+			assert.NoError(err, uri)
+		} else {
+			assert.Error(err, uri)
+		}
+	}
+
+	// The tcp streams have to be authenticated.
+	assert.Error(MigrationConfig{URI: "tcp:192.168.0.2:444"}.valid())
+	assert.NoError(MigrationConfig{URI: "unix:/run/migration"}.valid())
+}
+
+func TestMigrationConfigTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(defaultMigrationTimeout, MigrationConfig{}.timeout())
+	assert.Equal(time.Minute, MigrationConfig{Timeout: time.Minute}.timeout())
+}
+
+func TestSandboxCheckMigratable(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Container{
+		id:    "foo",
+		state: types.ContainerState{State: types.StateRunning},
+	}
+	s := &Sandbox{
+		config:     &SandboxConfig{},
+		state:      types.SandboxState{State: types.StatePaused},
+		containers: map[string]*Container{c.id: c},
+	}
+
+	assert.Error(s.checkMigratable())
+
+	s.state.State = types.StateRunning
+	assert.Error(s.checkMigratable())
+
+	s.config.Experimental = []exp.Feature{MigrationFeature}
+	assert.NoError(s.checkMigratable())
+
+	s.config.HypervisorConfig.SharedFS = config.VirtioFS
+	err := s.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+
+	s.config.HypervisorConfig.SharedFS = config.Virtio9P
+	s.config.HypervisorConfig.UseVSock = true
+	err = s.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+
+	s.config.HypervisorConfig.UseVSock = false
+
+	c.mounts = []Mount{{Destination: "/data", BlockDeviceID: "foo"}}
+	err = s.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+
+	c.mounts = nil
+	c.state.BlockDeviceID = "bar"
+	err = s.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+
+	c.state = types.ContainerState{State: types.StateStopped}
+	assert.Error(s.checkMigratable())
+}
+
+func TestSandboxMigratedConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	mounts := []Mount{{Source: "/data", Destination: "/data", Type: "bind", HostPath: "/run/kata-containers/shared/sandboxes/foo/data"}}
+	s := &Sandbox{
+		config: &SandboxConfig{
+			ID:         "foo",
+			Containers: []ContainerConfig{{ID: "foo", Mounts: []Mount{{Source: "/data", Destination: "/data", Type: "bind"}}}},
+		},
+		containers: map[string]*Container{"foo": {id: "foo", mounts: mounts}},
+	}
+
+	config := s.migratedConfig()
+	assert.Equal("foo", config.ID)
+	assert.Equal(mounts, config.Containers[0].Mounts)
+	assert.Empty(s.config.Containers[0].Mounts[0].HostPath)
+}
+
+func TestMigrateSandboxNoopAgent(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(testDisabledAsNonRoot)
+	}
+	defer cleanUp()
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	config := MigrationConfig{URI: "unix:/run/migration"}
+
+	err := MigrateSandbox(ctx, "", config)
+	assert.Error(err)
+
+	_, err = PrepareMigration(ctx, "")
+	assert.Error(err)
+
+	sandboxConfig := newTestSandboxConfigNoop()
+	sandboxConfig.Experimental = []exp.Feature{MigrationFeature}
+
+	p, _, err := createAndStartSandbox(ctx, sandboxConfig)
+	assert.NoError(err)
+	assert.NotNil(p)
+
+	migratedConfig, err := PrepareMigration(ctx, p.ID())
+	assert.NoError(err)
+	assert.Equal(p.ID(), migratedConfig.ID)
+	assert.Len(migratedConfig.Containers, 1)
+
+	data, err := json.Marshal(migratedConfig)
+	assert.NoError(err)
+
+	err = MigrateSandbox(ctx, p.ID(), MigrationConfig{URI: "exec:cat", SandboxConfigDigest: SandboxConfigDigest(data)})
+	assert.Error(err)
+
+	// The target host has not been given this configuration.
+	err = MigrateSandbox(ctx, p.ID(), config)
+	assert.Error(err)
+
+	config.SandboxConfigDigest = SandboxConfigDigest(data)
+	err = MigrateSandbox(ctx, p.ID(), config)
+	assert.NoError(err)
+
+	status, err := StatusSandbox(ctx, p.ID())
+	assert.NoError(err)
+	assert.Equal(types.StateStopped, status.State.State)
+	assert.Equal(types.ShutdownReasonMigrated, status.State.ShutdownReason)
+
+	_, err = DeleteSandbox(ctx, p.ID())
+	assert.NoError(err)
+
+	// Receive it back.
+	r, err := ReceiveSandbox(ctx, *migratedConfig, config)
+	assert.NoError(err)
+	assert.NotNil(r)
+
+	status, err = StatusSandbox(ctx, r.ID())
+	assert.NoError(err)
+	assert.Equal(types.StateRunning, status.State.State)
+	assert.Len(status.ContainersStatus, 1)
+	assert.Equal(types.StateRunning, status.ContainersStatus[0].State.State)
+
+	s, ok := r.(*Sandbox)
+	assert.True(ok)
+	assert.False(s.config.HypervisorConfig.IncomingMigration)
+
+	// The sandbox exists already.
+	_, err = ReceiveSandbox(ctx, *migratedConfig, config)
+	assert.Error(err)
+}
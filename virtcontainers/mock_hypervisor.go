@@ -15,8 +15,13 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/types"
 )
 
+// mockHypervisor fakes the VM lifecycle without running any VM, for the
+// tests and the dry runs of the runtime on hosts without KVM. It goes with
+// the no-op agent.
 type mockHypervisor struct {
-	mockPid int
+	mockPid  int
+	config   HypervisorConfig
+	capacity hotplugCapacity
 }
 
 func (m *mockHypervisor) capabilities() types.Capabilities {
@@ -24,7 +29,11 @@ func (m *mockHypervisor) capabilities() types.Capabilities {
 }
 
 func (m *mockHypervisor) hypervisorConfig() HypervisorConfig {
-	return HypervisorConfig{}
+	return m.config
+}
+
+func (m *mockHypervisor) generateSocket(id string, useVsock bool) (interface{}, error) {
+	return generateVMSocket(id, useVsock)
 }
 
 func (m *mockHypervisor) createSandbox(ctx context.Context, id string, networkNS NetworkNamespace, hypervisorConfig *HypervisorConfig, store *store.VCStore) error {
@@ -33,6 +42,8 @@ func (m *mockHypervisor) createSandbox(ctx context.Context, id string, networkNS
 		return err
 	}
 
+	m.config = *hypervisorConfig
+
 	return nil
 }
 
@@ -81,6 +92,9 @@ func (m *mockHypervisor) hotplugRemoveDevice(devInfo interface{}, devType device
 	return nil, nil
 }
 
+func (m *mockHypervisor) setBlockDeviceDriver(driver string) {
+}
+
 func (m *mockHypervisor) getSandboxConsole(sandboxID string) (string, error) {
 	return "", nil
 }
@@ -100,6 +114,14 @@ func (m *mockHypervisor) getThreadIDs() (vcpuThreadIDs, error) {
 	return vcpuThreadIDs{vcpus}, nil
 }
 
+func (m *mockHypervisor) metrics() (HypervisorMetrics, error) {
+	return HypervisorMetrics{}, nil
+}
+
+func (m *mockHypervisor) hotplugCapacity() (hotplugCapacity, error) {
+	return m.capacity, nil
+}
+
 func (m *mockHypervisor) cleanup() error {
 	return nil
 }
@@ -117,11 +139,23 @@ func (m *mockHypervisor) toGrpc() ([]byte, error) {
 }
 
 func (m *mockHypervisor) save() (s persistapi.HypervisorState) {
+	s.Pid = m.mockPid
+	s.Type = string(MockHypervisor)
 	return
 }
 
-func (m *mockHypervisor) load(s persistapi.HypervisorState) {}
+func (m *mockHypervisor) load(s persistapi.HypervisorState) {
+	m.mockPid = s.Pid
+}
+
+func (m *mockHypervisor) check(agentCheck func() error) error {
+	return checkAgent(agentCheck)
+}
+
+func (m *mockHypervisor) migrateSandbox(config MigrationConfig) error {
+	return nil
+}
 
-func (m *mockHypervisor) check() error {
+func (m *mockHypervisor) receiveMigration(config MigrationConfig) error {
 	return nil
 }
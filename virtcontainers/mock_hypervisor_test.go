@@ -14,7 +14,7 @@ import (
 )
 
 func TestMockHypervisorCreateSandbox(t *testing.T) {
-	var m *mockHypervisor
+	m := &mockHypervisor{}
 	assert := assert.New(t)
 
 	sandbox := &Sandbox{
@@ -42,6 +42,7 @@ func TestMockHypervisorCreateSandbox(t *testing.T) {
 
 	err = m.createSandbox(ctx, sandbox.config.ID, NetworkNamespace{}, &sandbox.config.HypervisorConfig, nil)
 	assert.NoError(err)
+	assert.Equal(sandbox.config.HypervisorConfig, m.hypervisorConfig())
 }
 
 func TestMockHypervisorStartSandbox(t *testing.T) {
@@ -86,5 +87,19 @@ func TestMockHypervisorDisconnect(t *testing.T) {
 func TestMockHypervisorCheck(t *testing.T) {
 	var m *mockHypervisor
 
-	assert.NoError(t, m.check())
+	assert.NoError(t, m.check(nil))
+	assert.Error(t, m.check(func() error { return fmt.Errorf("timeout") }))
+}
+
+func TestMockHypervisorSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockHypervisor{mockPid: 1234}
+	s := m.save()
+	assert.Equal(1234, s.Pid)
+	assert.Equal(string(MockHypervisor), s.Type)
+
+	m = &mockHypervisor{}
+	m.load(s)
+	assert.Equal([]int{1234}, m.getPids())
 }
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/pkg/errors"
 )
 
@@ -58,7 +59,10 @@ func (m *monitor) newWatcher() (chan error, error) {
 					return
 				case <-tick.C:
 					m.watchHypervisor()
-					m.watchAgent()
+					// otherwise the hypervisor check pings the agent
+					if !m.sandbox.config.HypervisorConfig.AgentHealthCheck {
+						m.watchAgent()
+					}
 				}
 			}
 		}()
@@ -130,13 +134,23 @@ func (m *monitor) stop() {
 func (m *monitor) watchAgent() {
 	err := m.sandbox.agent.check()
 	if err != nil {
-		// TODO: define and export error types
-		m.notify(errors.Wrapf(err, "failed to ping agent"))
+		m.sandbox.setShutdownReason(types.ShutdownReasonAgentLost, err.Error())
+		m.notify(errors.Wrapf(ErrAgentUnresponsive, "failed to ping agent: %v", err))
 	}
 }
 
 func (m *monitor) watchHypervisor() error {
-	if err := m.sandbox.hypervisor.check(); err != nil {
+	var agentCheck func() error
+	if m.sandbox.config.HypervisorConfig.AgentHealthCheck {
+		agentCheck = m.sandbox.agent.check
+	}
+
+	if err := m.sandbox.hypervisor.check(agentCheck); err != nil {
+		reason := m.sandbox.hypervisorShutdownReason(err)
+		m.sandbox.setShutdownReason(reason, err.Error())
+		if reason == types.ShutdownReasonHypervisorOOMKilled {
+			err = errors.Wrap(ErrVMMOOMKilled, err.Error())
+		}
 		m.notify(errors.Wrapf(err, "failed to ping hypervisor process"))
 		return err
 	}
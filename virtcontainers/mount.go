@@ -11,19 +11,57 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"github.com/docker/go-units"
 	merr "github.com/hashicorp/go-multierror"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // DefaultShmSize is the default shm size to be used in case host
 // IPC is used.
 const DefaultShmSize = 65536 * 1024
 
+// devShmSize returns the size of the /dev/shm mount m assigned on the
+// host: the size option of a tmpfs, or the size of the filesystem bind
+// mounted. It returns 0 when the size can't be told.
+func devShmSize(m Mount) uint {
+	switch m.Type {
+	case "tmpfs":
+		for _, opt := range m.Options {
+			if !strings.HasPrefix(opt, "size=") {
+				continue
+			}
+
+			// Sizes relative to the host memory, as "50%", are not
+			// meaningful in the VM.
+			size, err := units.RAMInBytes(strings.TrimPrefix(opt, "size="))
+			if err != nil || size <= 0 {
+				return 0
+			}
+			return uint(size)
+		}
+	case "bind":
+		if m.Source == "/dev/shm" {
+			return 0
+		}
+
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m.Source, &st); err != nil {
+			return 0
+		}
+		return uint(uint64(st.Bsize) * st.Blocks)
+	}
+
+	return 0
+}
+
 var rootfsDir = "rootfs"
 
 var systemMountPrefixes = []string{"/proc", "/sys"}
@@ -326,6 +364,282 @@ type Mount struct {
 	// VM in case this mount is a block device file or a directory
 	// backed by a block device.
 	BlockDeviceID string
+
+	// BlockFstype is the filesystem type of the block device of a
+	// direct-assigned directory volume, mounted by the agent.
+	BlockFstype string
+
+	// OwnershipRemapped is true if the ownership of the files of the
+	// source was remapped to the user namespace of the container, to be
+	// restored when the mount is torn down.
+	OwnershipRemapped bool
+
+	// WatchableSource is the guest path of the shared directory the agent
+	// mirrors the watchable mount from, into a guest tmpfs where its
+	// updates trigger inotify events.
+	WatchableSource string
+}
+
+const (
+	// luksKeyNameOption is the mount option giving the name of the key of
+	// a LUKS encrypted block volume, in the key directory configured by
+	// the operator. The volume is opened and mounted by the agent, it
+	// never appears decrypted on the host.
+	luksKeyNameOption = "luks.keyname="
+
+	// luksFstypeOption is the mount option giving the type of the
+	// filesystem of a LUKS encrypted block volume.
+	luksFstypeOption = "luks.fstype="
+
+	defaultLUKSFstype = "ext4"
+
+	// blockFormatOption is the mount option passing an image file as a
+	// block device, giving its format: raw or qcow2.
+	blockFormatOption = "block.format="
+
+	// directVolumeOption is the mount option assigning the block device
+	// source of a volume directly to the VM. The device is hotplugged and
+	// its filesystem mounted by the agent, instead of sharing a directory
+	// through 9p or virtio-fs. The device must not be mounted on the host,
+	// as two kernels mounting the same filesystem corrupt it.
+	directVolumeOption = "io.katacontainers.volume=block"
+
+	// directVolumeFstypeOption is the mount option giving the type of the
+	// filesystem of a direct-assigned volume.
+	directVolumeFstypeOption = "io.katacontainers.volume.fstype="
+
+	defaultDirectVolumeFstype = "ext4"
+)
+
+// runtimeMountOptions are the mount options handled by the runtime, which
+// must not be passed to the agent.
+var runtimeMountOptions = []string{luksKeyNameOption, luksFstypeOption, blockFormatOption, directVolumeOption, directVolumeFstypeOption}
+
+var (
+	procMountInfoFile   = "/proc/self/mountinfo"
+	sysBlockDevTemplate = "/sys/dev/block/%d:%d"
+)
+
+// bindMountOptions are the mount options only valid for a bind mount, not
+// for the filesystem of a direct-assigned volume.
+var bindMountOptions = []string{"bind", "rbind", "private", "rprivate", "shared", "rshared", "slave", "rslave", "unbindable", "runbindable"}
+
+// isDirectVolume returns true if the mount options assign the block device
+// of the volume directly to the VM.
+func isDirectVolume(options []string) bool {
+	for _, opt := range options {
+		if opt == directVolumeOption {
+			return true
+		}
+	}
+
+	return false
+}
+
+// directVolumeMountOptions returns the options of the filesystem of a
+// direct-assigned volume, from the options of its bind mount.
+func directVolumeMountOptions(options []string) []string {
+	var fsOptions []string
+
+	for _, opt := range guestMountOptions(options) {
+		isBind := false
+		for _, bindOpt := range bindMountOptions {
+			if opt == bindOpt {
+				isBind = true
+				break
+			}
+		}
+
+		if !isBind {
+			fsOptions = append(fsOptions, opt)
+		}
+	}
+
+	return fsOptions
+}
+
+// directVolumeFstype returns the type of the filesystem of a
+// direct-assigned volume.
+func directVolumeFstype(options []string) string {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, directVolumeFstypeOption) {
+			return strings.TrimPrefix(opt, directVolumeFstypeOption)
+		}
+	}
+
+	return defaultDirectVolumeFstype
+}
+
+// directVolumeDevice returns the block device file of a direct-assigned
+// volume source. The device, its partitions and the disk it is a partition
+// of must not be mounted on the host, and the device must not be held by
+// the host either, e.g. by device-mapper or md, which an exclusive open
+// checks.
+func directVolumeDevice(source string) (string, error) {
+	if source == "" {
+		return "", fmt.Errorf("Direct-assigned volume source cannot be empty")
+	}
+
+	devicePath, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", err
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return "", err
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", fmt.Errorf("Direct-assigned volume %s is not a block device", source)
+	}
+
+	devices, err := relatedBlockDevices(int(unix.Major(stat.Rdev)), int(unix.Minor(stat.Rdev)))
+	if err != nil {
+		return "", err
+	}
+
+	mounted, err := mountedDevices()
+	if err != nil {
+		return "", err
+	}
+
+	for _, dev := range devices {
+		if mounted[dev] {
+			return "", fmt.Errorf("Direct-assigned volume %s is mounted on the host (device %s)", source, dev)
+		}
+	}
+
+	fd, err := unix.Open(devicePath, unix.O_RDONLY|unix.O_EXCL|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", fmt.Errorf("Direct-assigned volume %s is in use on the host: %v", source, err)
+	}
+	unix.Close(fd)
+
+	return devicePath, nil
+}
+
+// relatedBlockDevices returns the "major:minor" numbers of the block device,
+// of its partitions and of the disk it is a partition of.
+func relatedBlockDevices(major, minor int) ([]string, error) {
+	devices := []string{fmt.Sprintf("%d:%d", major, minor)}
+
+	sysPath, err := filepath.EvalSymlinks(fmt.Sprintf(sysBlockDevTemplate, major, minor))
+	if os.IsNotExist(err) {
+		return devices, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var devFiles []string
+	if _, err := os.Stat(filepath.Join(sysPath, "partition")); err == nil {
+		devFiles = append(devFiles, filepath.Join(filepath.Dir(sysPath), "dev"))
+	}
+
+	partitions, err := filepath.Glob(filepath.Join(sysPath, "*", "partition"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range partitions {
+		devFiles = append(devFiles, filepath.Join(filepath.Dir(p), "dev"))
+	}
+
+	for _, f := range devFiles {
+		dev, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, strings.TrimSpace(string(dev)))
+	}
+
+	return devices, nil
+}
+
+// mountedDevices returns the "major:minor" numbers of the devices mounted
+// in the mount namespace of the runtime.
+func mountedDevices() (map[string]bool, error) {
+	f, err := os.Open(procMountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounted := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Invalid mountinfo line %q", scanner.Text())
+		}
+		mounted[fields[2]] = true
+	}
+
+	return mounted, scanner.Err()
+}
+
+// blockImageFormat returns the format of the image file passed as a block
+// device, or an empty string if the mount is not an image file.
+func blockImageFormat(options []string) string {
+	for _, opt := range options {
+		if strings.HasPrefix(opt, blockFormatOption) {
+			return strings.TrimPrefix(opt, blockFormatOption)
+		}
+	}
+
+	return ""
+}
+
+// guestMountOptions returns the mount options without the ones handled by
+// the runtime.
+func guestMountOptions(options []string) []string {
+	var guestOptions []string
+
+	for _, opt := range options {
+		handled := false
+		for _, prefix := range runtimeMountOptions {
+			if strings.HasPrefix(opt, prefix) {
+				handled = true
+				break
+			}
+		}
+
+		if !handled {
+			guestOptions = append(guestOptions, opt)
+		}
+	}
+
+	return guestOptions
+}
+
+// luksMountOptions returns the key name and the filesystem type of a LUKS
+// encrypted volume, along with its mount options unrelated to the
+// encryption. keyName is empty if the volume is not encrypted.
+func luksMountOptions(options []string) (keyName, fstype string, others []string) {
+	fstype = defaultLUKSFstype
+
+	for _, opt := range options {
+		switch {
+		case strings.HasPrefix(opt, luksKeyNameOption):
+			keyName = strings.TrimPrefix(opt, luksKeyNameOption)
+		case strings.HasPrefix(opt, luksFstypeOption):
+			fstype = strings.TrimPrefix(opt, luksFstypeOption)
+		default:
+			others = append(others, opt)
+		}
+	}
+
+	return keyName, fstype, others
+}
+
+// isReadOnlyMount returns true if the mount options contain "ro".
+func isReadOnlyMount(options []string) bool {
+	for _, flag := range options {
+		if flag == "ro" {
+			return true
+		}
+	}
+
+	return false
 }
 
 func bindUnmountContainerRootfs(ctx context.Context, sharedDir, sandboxID, cID string) error {
@@ -412,6 +726,50 @@ func Isk8sHostEmptyDir(path string) bool {
 	return false
 }
 
+const (
+	// emptyDirImage is the sparse image file backing a disk-backed
+	// Kubernetes emptyDir volume in the guest. It is created in the
+	// emptyDir directory, so that kubelet accounts for its usage.
+	emptyDirImage = ".kata-emptydir.img"
+
+	emptyDirFstype = "ext4"
+)
+
+// mkfsCmd formats the ext4 images of the emptyDir volumes and of the
+// layered rootfs.
+var mkfsCmd = "mkfs.ext4"
+
+// createEmptyDirImage creates the sparse image file of sizeMB megabytes
+// backing the emptyDir volume dir, unless another container of the pod
+// created it already, and returns its path.
+func createEmptyDirImage(dir string, sizeMB uint32) (string, error) {
+	path := filepath.Join(dir, emptyDirImage)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	err = f.Truncate(int64(sizeMB) << 20)
+	f.Close()
+	if err == nil {
+		var out []byte
+		if out, err = exec.Command(mkfsCmd, "-q", "-F", path).CombinedOutput(); err != nil {
+			err = fmt.Errorf("failed to format emptyDir image %q: %v: %s", path, err, out)
+		}
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
 func isEmptyDir(path string) bool {
 	splitSourceSlice := strings.Split(path, "/")
 	if len(splitSourceSlice) > 1 {
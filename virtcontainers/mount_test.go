@@ -304,3 +304,221 @@ func TestBindUnmountContainerRootfsENOENTNotError(t *testing.T) {
 	err := bindUnmountContainerRootfs(context.Background(), testMnt, sID, cID)
 	assert.NoError(err)
 }
+
+func TestIsReadOnlyMount(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isReadOnlyMount(nil))
+	assert.False(isReadOnlyMount([]string{"rbind", "rw"}))
+	assert.True(isReadOnlyMount([]string{"rbind", "ro"}))
+}
+
+func TestLUKSMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	keyName, fstype, options := luksMountOptions([]string{"rbind", "ro"})
+	assert.Empty(keyName)
+	assert.Equal(defaultLUKSFstype, fstype)
+	assert.Equal([]string{"rbind", "ro"}, options)
+
+	keyName, fstype, options = luksMountOptions([]string{"rbind", luksKeyNameOption + "vol", luksFstypeOption + "xfs", "ro"})
+	assert.Equal("vol", keyName)
+	assert.Equal("xfs", fstype)
+	assert.Equal([]string{"rbind", "ro"}, options)
+}
+
+func TestBlockImageFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(blockImageFormat([]string{"rbind", "ro"}))
+	assert.Equal("qcow2", blockImageFormat([]string{"rbind", blockFormatOption + "qcow2"}))
+}
+
+func TestGuestMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(guestMountOptions(nil))
+	assert.Equal([]string{"rbind", "ro"}, guestMountOptions([]string{
+		"rbind",
+		luksKeyNameOption + "data",
+		luksFstypeOption + "xfs",
+		blockFormatOption + "qcow2",
+		"ro",
+	}))
+}
+
+func TestIsDirectVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isDirectVolume(nil))
+	assert.False(isDirectVolume([]string{"rbind", "ro"}))
+	assert.True(isDirectVolume([]string{"rbind", directVolumeOption, "ro"}))
+}
+
+func TestDirectVolumeMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(directVolumeMountOptions([]string{"rbind", "rprivate", directVolumeOption}))
+	assert.Equal([]string{"ro", "noatime"}, directVolumeMountOptions([]string{
+		"rbind",
+		directVolumeOption,
+		"ro",
+		"rslave",
+		"noatime",
+	}))
+}
+
+func TestDirectVolumeFstype(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(defaultDirectVolumeFstype, directVolumeFstype([]string{directVolumeOption}))
+	assert.Equal("xfs", directVolumeFstype([]string{directVolumeOption, directVolumeFstypeOption + "xfs"}))
+	assert.Equal([]string{"ro"}, guestMountOptions([]string{directVolumeOption, directVolumeFstypeOption + "xfs", "ro"}))
+}
+
+func TestDirectVolumeDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := directVolumeDevice("")
+	assert.Error(err)
+
+	// Host mounts are refused, only block devices can be assigned.
+	dir, err := ioutil.TempDir("", "direct-volume")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, err = directVolumeDevice(dir)
+	assert.Error(err)
+
+	_, err = directVolumeDevice("/")
+	assert.Error(err)
+
+	_, err = directVolumeDevice("/proc")
+	assert.Error(err)
+}
+
+func TestMountedDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	savedProcMountInfoFile := procMountInfoFile
+	defer func() {
+		procMountInfoFile = savedProcMountInfoFile
+	}()
+
+	f, err := ioutil.TempFile("", "mountinfo")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+	procMountInfoFile = f.Name()
+
+	_, err = f.WriteString(`22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid shared:12 - proc proc rw
+`)
+	assert.NoError(err)
+	f.Close()
+
+	mounted, err := mountedDevices()
+	assert.NoError(err)
+	assert.True(mounted["8:1"])
+	assert.True(mounted["0:21"])
+	assert.False(mounted["8:0"])
+
+	assert.NoError(ioutil.WriteFile(f.Name(), []byte("22 1\n"), 0644))
+	_, err = mountedDevices()
+	assert.Error(err)
+}
+
+func TestRelatedBlockDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	savedSysBlockDevTemplate := sysBlockDevTemplate
+	defer func() {
+		sysBlockDevTemplate = savedSysBlockDevTemplate
+	}()
+
+	dir, err := ioutil.TempDir("", "sys-block")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	sysBlockDevTemplate = filepath.Join(dir, "dev", "%d:%d")
+
+	disk := filepath.Join(dir, "devices", "sda")
+	for name, content := range map[string]string{
+		"dev":              "8:0\n",
+		"sda1/dev":         "8:1\n",
+		"sda1/partition":   "1\n",
+		"sda2/dev":         "8:2\n",
+		"sda2/partition":   "2\n",
+		"queue/rotational": "0\n",
+	} {
+		path := filepath.Join(disk, name)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "dev"), 0755))
+	assert.NoError(os.Symlink(disk, filepath.Join(dir, "dev", "8:0")))
+	assert.NoError(os.Symlink(filepath.Join(disk, "sda1"), filepath.Join(dir, "dev", "8:1")))
+
+	// The partitions of a disk, and the disk of a partition
+	devices, err := relatedBlockDevices(8, 0)
+	assert.NoError(err)
+	assert.Equal([]string{"8:0", "8:1", "8:2"}, devices)
+
+	devices, err = relatedBlockDevices(8, 1)
+	assert.NoError(err)
+	assert.Equal([]string{"8:1", "8:0"}, devices)
+
+	devices, err = relatedBlockDevices(7, 0)
+	assert.NoError(err)
+	assert.Equal([]string{"7:0"}, devices)
+}
+
+func TestCreateEmptyDirImage(t *testing.T) {
+	assert := assert.New(t)
+
+	savedMkfsCmd := mkfsCmd
+	defer func() {
+		mkfsCmd = savedMkfsCmd
+	}()
+
+	dir, err := ioutil.TempDir("", "emptydir")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// The image is removed when it can't be formatted.
+	mkfsCmd = "false"
+	_, err = createEmptyDirImage(dir, 16)
+	assert.Error(err)
+	_, err = os.Stat(filepath.Join(dir, emptyDirImage))
+	assert.True(os.IsNotExist(err))
+
+	mkfsCmd = "true"
+	path, err := createEmptyDirImage(dir, 16)
+	assert.NoError(err)
+	assert.Equal(filepath.Join(dir, emptyDirImage), path)
+
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(int64(16<<20), info.Size())
+
+	// It is reused by the other containers of the pod.
+	mkfsCmd = "false"
+	path2, err := createEmptyDirImage(dir, 16)
+	assert.NoError(err)
+	assert.Equal(path, path2)
+}
+
+func TestDevShmSize(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpfs := func(options ...string) Mount {
+		return Mount{Source: "shm", Destination: "/dev/shm", Type: "tmpfs", Options: options}
+	}
+
+	assert.Equal(uint(65536<<10), devShmSize(tmpfs("nosuid", "size=65536k")))
+	assert.Equal(uint(1<<30), devShmSize(tmpfs("size=1g")))
+	assert.Equal(uint(0), devShmSize(tmpfs("size=50%")))
+	assert.Equal(uint(0), devShmSize(tmpfs("nosuid")))
+
+	assert.Equal(uint(0), devShmSize(Mount{Source: "/dev/shm", Destination: "/dev/shm", Type: "bind"}))
+	assert.Equal(uint(0), devShmSize(Mount{Source: "/does/not/exist", Destination: "/dev/shm", Type: "bind"}))
+	assert.NotEqual(uint(0), devShmSize(Mount{Source: os.TempDir(), Destination: "/dev/shm", Type: "bind"}))
+}
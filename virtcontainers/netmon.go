@@ -19,6 +19,11 @@ type NetmonConfig struct {
 	Path   string
 	Debug  bool
 	Enable bool
+
+	// InProcess watches the network namespace from the process of the
+	// sandbox instead of starting the netmon binary, when the sandbox
+	// lives as long as the process, such as in the shim v2.
+	InProcess bool
 }
 
 // netmonParams is the structure providing specific parameters needed
@@ -56,6 +56,8 @@ const (
 	// NetXConnectTCFilterModel redirects traffic from the network interface
 	// provided by the network plugin to a tap interface.
 	// This works for ipvlan and macvlan as well.
+	// Unlike the bridged and macvtap models, the MAC and IP addresses of
+	// the network interface are left untouched.
 	NetXConnectTCFilterModel
 
 	// NetXConnectNoneModel can be used when the VM is in the host network namespace
@@ -109,6 +111,37 @@ func (n *NetInterworkingModel) SetModel(modelName string) error {
 	return fmt.Errorf("Unknown type %s", modelName)
 }
 
+// MacAddressPolicy determines how the MAC addresses of the network
+// interfaces of a sandbox are allocated.
+type MacAddressPolicy string
+
+const (
+	// MacAddressPolicyNone keeps the MAC addresses set by the network
+	// plugin.
+	MacAddressPolicyNone MacAddressPolicy = "none"
+
+	// MacAddressPolicySandbox derives the MAC addresses from the sandbox
+	// ID and the network interface names.
+	MacAddressPolicySandbox MacAddressPolicy = "sandbox"
+
+	// MacAddressPolicyIP derives the MAC addresses from the IPv4 addresses
+	// of the network interfaces.
+	MacAddressPolicyIP MacAddressPolicy = "ip"
+)
+
+// SetPolicy changes the policy string value
+func (p *MacAddressPolicy) SetPolicy(policyName string) error {
+	switch MacAddressPolicy(policyName) {
+	case "", MacAddressPolicyNone:
+		*p = MacAddressPolicyNone
+		return nil
+	case MacAddressPolicySandbox, MacAddressPolicyIP:
+		*p = MacAddressPolicy(policyName)
+		return nil
+	}
+	return fmt.Errorf("Unknown MAC address policy %s", policyName)
+}
+
 // DefaultNetInterworkingModel is a package level default
 // that determines how the VM should be connected to the
 // the container network interface
@@ -173,6 +206,29 @@ type NetworkConfig struct {
 	DisableNewNetNs   bool
 	NetmonConfig      NetmonConfig
 	InterworkingModel NetInterworkingModel
+
+	// MacAddressPolicy determines how the MAC addresses of the network
+	// interfaces are allocated.
+	MacAddressPolicy MacAddressPolicy
+
+	// XDP selects the network interfaces forwarded through AF_XDP sockets.
+	XDP XDPConfig
+
+	// TapFdSockets maps the names of the existing TAP devices to the unix
+	// sockets of the device plugins passing their file descriptors.
+	TapFdSockets map[string]string
+
+	// SRIOV maps the names of the SR-IOV virtual function network
+	// interfaces to the settings programmed on their physical function.
+	SRIOV map[string]SRIOVConfig
+
+	// EnableBandwidth tells the runtime to limit the traffic of the
+	// network interfaces on the host, to Bandwidth.
+	EnableBandwidth bool
+
+	// Bandwidth limits the traffic of the network interfaces on the host,
+	// when EnableBandwidth is set.
+	Bandwidth BandwidthConfig
 }
 
 func networkLogger() *logrus.Entry {
@@ -262,6 +318,14 @@ func generateEndpoints(typedEndpoints []TypedJSONEndpoint) ([]Endpoint, error) {
 			var endpoint IPVlanEndpoint
 			endpointInf = &endpoint
 
+		case XDPEndpointType:
+			var endpoint XDPEndpoint
+			endpointInf = &endpoint
+
+		case TapFdEndpointType:
+			var endpoint TapFdEndpoint
+			endpointInf = &endpoint
+
 		default:
 			networkLogger().WithField("endpoint-type", e.Type).Error("Ignoring unknown endpoint type")
 		}
@@ -418,15 +482,59 @@ func getLinkByName(netHandle *netlink.Handle, name string, expectedLink netlink.
 	return nil, fmt.Errorf("Incorrect link type %s, expecting %s", link.Type(), expectedLink.Type())
 }
 
+// maxNetworkQueues is the maximum number of queues of a TAP device.
+const maxNetworkQueues = 256
+
+// networkQueues returns the number of queue pairs of the network interfaces
+// connected to the VM through an interworking model, 0 when the hypervisor
+// does not support multiqueue network interfaces.
+func networkQueues(h hypervisor) int {
+	caps := h.capabilities()
+	if !caps.IsMultiQueueSupported() {
+		return 0
+	}
+
+	return int(networkQueueCount(h.hypervisorConfig()))
+}
+
+// networkQueueCount returns the number of queue pairs of the multiqueue
+// network interfaces: one per boot vCPU by default or, when the maximum
+// number of queue pairs is set, one per vCPU the VM can grow to, up to that
+// maximum, so that the vCPUs hotplugged later get their own queue pair.
+func networkQueueCount(config HypervisorConfig) uint32 {
+	queues := config.NumVCPUs
+	if config.NetworkMaxQueues != 0 {
+		if config.DefaultMaxVCPUs > queues {
+			queues = config.DefaultMaxVCPUs
+		}
+		if queues > config.NetworkMaxQueues {
+			queues = config.NetworkMaxQueues
+		}
+	}
+
+	if queues > maxNetworkQueues {
+		queues = maxNetworkQueues
+	}
+
+	return queues
+}
+
+// interworkingEndpoint tells if the endpoint is connected to the VM through
+// an interworking model, with networkQueues queue pairs.
+func interworkingEndpoint(endpoint Endpoint) bool {
+	switch endpoint.(type) {
+	case *VethEndpoint, *BridgedMacvlanEndpoint, *IPVlanEndpoint:
+		return true
+	default:
+		return false
+	}
+}
+
 // The endpoint type should dictate how the connection needs to happen.
 func xConnectVMNetwork(endpoint Endpoint, h hypervisor) error {
 	netPair := endpoint.NetworkPair()
 
-	queues := 0
-	caps := h.capabilities()
-	if caps.IsMultiQueueSupported() {
-		queues = int(h.hypervisorConfig().NumVCPUs)
-	}
+	queues := networkQueues(h)
 
 	disableVhostNet := h.hypervisorConfig().DisableVhostNet
 
@@ -611,8 +719,9 @@ func tapNetworkPair(endpoint Endpoint, queues int, disableVhostNet bool) error {
 		return fmt.Errorf("Could not enable TAP %s: %s", netPair.TAPIface.Name, err)
 	}
 
-	// Clear the IP addresses from the veth interface to prevent ARP conflict
-	netPair.VirtIface.Addrs, err = netlink.AddrList(link, netlink.FAMILY_V4)
+	// Clear the IP addresses from the veth interface to prevent ARP and NDP
+	// conflicts
+	netPair.VirtIface.Addrs, err = netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return fmt.Errorf("Unable to obtain veth IP addresses: %s", err)
 	}
@@ -716,8 +825,9 @@ func bridgeNetworkPair(endpoint Endpoint, queues int, disableVhostNet bool) erro
 			netPair.VirtIface.Name, netPair.Name, err)
 	}
 
-	// Clear the IP addresses from the veth interface to prevent ARP conflict
-	netPair.VirtIface.Addrs, err = netlink.AddrList(link, netlink.FAMILY_V4)
+	// Clear the IP addresses from the veth interface to prevent ARP and NDP
+	// conflicts
+	netPair.VirtIface.Addrs, err = netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return fmt.Errorf("Unable to obtain veth IP addresses: %s", err)
 	}
@@ -1133,22 +1243,18 @@ func generateInterfacesAndRoutes(networkNS NetworkNamespace) ([]*vcTypes.Interfa
 
 		var ipAddresses []*vcTypes.IPAddress
 		for _, addr := range endpoint.Properties().Addrs {
-			// Skip IPv6 because not supported
-			if addr.IP.To4() == nil {
-				// Skip IPv6 because not supported
-				networkLogger().WithFields(logrus.Fields{
-					"unsupported-address-type": "ipv6",
-					"address":                  addr,
-				}).Warn("unsupported address")
-				continue
-			}
 			// Skip localhost interface
 			if addr.IP.IsLoopback() {
 				continue
 			}
+			// Skip IPv6 link-local addresses, the guest kernel
+			// generates them from the interface MAC address.
+			if addr.IP.To4() == nil && addr.IP.IsLinkLocalUnicast() {
+				continue
+			}
 			netMask, _ := addr.Mask.Size()
 			ipAddress := vcTypes.IPAddress{
-				Family:  netlink.FAMILY_V4,
+				Family:  ipFamily(addr.IP),
 				Address: addr.IP.String(),
 				Mask:    fmt.Sprintf("%d", netMask),
 			}
@@ -1176,29 +1282,13 @@ func generateInterfacesAndRoutes(networkNS NetworkNamespace) ([]*vcTypes.Interfa
 
 			if route.Dst != nil {
 				r.Dest = route.Dst.String()
-
-				if route.Dst.IP.To4() == nil {
-					// Skip IPv6 because not supported
-					networkLogger().WithFields(logrus.Fields{
-						"unsupported-route-type": "ipv6",
-						"destination":            r.Dest,
-					}).Warn("unsupported route")
-					continue
-				}
 			}
 
+			// The IPv6 default route commonly goes through a
+			// link-local gateway, which is valid as the route is
+			// bound to the interface.
 			if route.Gw != nil {
-				gateway := route.Gw.String()
-
-				if route.Gw.To4() == nil {
-					// Skip IPv6 because is is not supported
-					networkLogger().WithFields(logrus.Fields{
-						"unsupported-route-type": "ipv6",
-						"gateway":                gateway,
-					}).Warn("unsupported route")
-					continue
-				}
-				r.Gateway = gateway
+				r.Gateway = route.Gw.String()
 			}
 
 			if route.Src != nil {
@@ -1214,6 +1304,14 @@ func generateInterfacesAndRoutes(networkNS NetworkNamespace) ([]*vcTypes.Interfa
 	return ifaces, routes, nil
 }
 
+// ipFamily returns the netlink family of an IP address.
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
 func createNetworkInterfacePair(idx int, ifName string, interworkingModel NetInterworkingModel) (NetworkInterfacePair, error) {
 	uniqueID := uuid.Generate().String()
 
@@ -1323,7 +1421,15 @@ func createEndpointsFromScan(networkNSPath string, config *NetworkConfig) ([]End
 		}
 
 		if err := doNetNS(networkNSPath, func(_ ns.NetNS) error {
+			if socketPath, ok := config.TapFdSockets[netInfo.Iface.Name]; ok {
+				endpoint, errCreate = createTapFdEndpoint(netInfo, socketPath)
+				return errCreate
+			}
+
 			endpoint, errCreate = createEndpoint(netInfo, idx, config.InterworkingModel)
+			if veth, ok := endpoint.(*VethEndpoint); ok && config.XDP.selects(netInfo.Iface.Name) {
+				endpoint = createXDPEndpoint(veth, config.XDP)
+			}
 			return errCreate
 		}); err != nil {
 			return []Endpoint{}, err
@@ -1427,6 +1533,14 @@ func (n *Network) Add(ctx context.Context, config *NetworkConfig, hypervisor hyp
 		return endpoints, err
 	}
 
+	// The physical functions of the SR-IOV virtual functions live in the
+	// host network namespace.
+	for _, endpoint := range endpoints {
+		if err := setupEndpointVF(endpoint, config.SRIOV); err != nil {
+			return []Endpoint{}, err
+		}
+	}
+
 	err = doNetNS(config.NetNSPath, func(_ ns.NetNS) error {
 		for _, endpoint := range endpoints {
 			networkLogger().WithField("endpoint-type", endpoint.Type()).WithField("hotplug", hotplug).Info("Attaching endpoint")
@@ -1439,6 +1553,10 @@ func (n *Network) Add(ctx context.Context, config *NetworkConfig, hypervisor hyp
 					return err
 				}
 			}
+
+			if err := setupBandwidth(endpoint, *config); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -0,0 +1,108 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// endpointStatsLink returns the host link carrying the traffic of endpoint,
+// and whether its counters are reversed from the guest point of view: what
+// the guest transmits is received by a tap device. Physical and vhost-user
+// endpoints have no such link.
+func endpointStatsLink(endpoint Endpoint) (name string, reversed bool, ok bool) {
+	switch ep := endpoint.(type) {
+	case *VethEndpoint, *BridgedMacvlanEndpoint, *IPVlanEndpoint:
+		return ep.NetworkPair().VirtIface.Name, false, true
+	case *MacvtapEndpoint:
+		return ep.EndpointProperties.Iface.Name, false, true
+	case *TapEndpoint:
+		return ep.TapInterface.TAPIface.Name, true, true
+	case *TapFdEndpoint:
+		return ep.TapInterface.TAPIface.Name, true, true
+	case *XDPEndpoint:
+		return ep.NetPair.VirtIface.Name, false, true
+	}
+
+	return "", false, false
+}
+
+// linkStatisticsFn returns the statistics of the link name.
+var linkStatisticsFn = func(handle *netlink.Handle, name string) (*netlink.LinkStatistics, error) {
+	link, err := handle.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return link.Attrs().Statistics, nil
+}
+
+func newNetworkStats(name string, s *netlink.LinkStatistics, reversed bool) *NetworkStats {
+	if reversed {
+		return &NetworkStats{
+			Name:      name,
+			RxBytes:   s.TxBytes,
+			RxPackets: s.TxPackets,
+			RxErrors:  s.TxErrors,
+			RxDropped: s.TxDropped,
+			TxBytes:   s.RxBytes,
+			TxPackets: s.RxPackets,
+			TxErrors:  s.RxErrors,
+			TxDropped: s.RxDropped,
+		}
+	}
+
+	return &NetworkStats{
+		Name:      name,
+		RxBytes:   s.RxBytes,
+		RxPackets: s.RxPackets,
+		RxErrors:  s.RxErrors,
+		RxDropped: s.RxDropped,
+		TxBytes:   s.TxBytes,
+		TxPackets: s.TxPackets,
+		TxErrors:  s.TxErrors,
+		TxDropped: s.TxDropped,
+	}
+}
+
+// NetworkStats returns the counters of the sandbox network interfaces, as
+// seen by the guest, read from their host links in the network namespace.
+// Unlike the statistics returned by the agent, they do not depend on the
+// guest being healthy.
+func (s *Sandbox) NetworkStats() ([]*NetworkStats, error) {
+	var stats []*NetworkStats
+
+	err := doNetNS(s.networkNS.NetNsPath, func(_ ns.NetNS) error {
+		handle, err := netlink.NewHandle()
+		if err != nil {
+			return err
+		}
+		defer handle.Delete()
+
+		for _, endpoint := range s.networkNS.Endpoints {
+			name, reversed, ok := endpointStatsLink(endpoint)
+			if !ok {
+				continue
+			}
+
+			linkStats, err := linkStatisticsFn(handle, name)
+			if err != nil {
+				return err
+			}
+
+			if linkStats == nil {
+				continue
+			}
+
+			stats = append(stats, newNetworkStats(endpoint.Name(), linkStats, reversed))
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
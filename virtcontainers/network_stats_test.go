@@ -0,0 +1,89 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEndpointStatsLink(t *testing.T) {
+	assert := assert.New(t)
+
+	netPair := NetworkInterfacePair{
+		TapInterface: TapInterface{TAPIface: NetworkInterface{Name: "tap0_kata"}},
+		VirtIface:    NetworkInterface{Name: "eth0"},
+	}
+
+	type testData struct {
+		endpoint Endpoint
+		name     string
+		reversed bool
+		ok       bool
+	}
+
+	for _, d := range []testData{
+		{&VethEndpoint{NetPair: netPair}, "eth0", false, true},
+		{&BridgedMacvlanEndpoint{NetPair: netPair}, "eth0", false, true},
+		{&IPVlanEndpoint{NetPair: netPair}, "eth0", false, true},
+		{&MacvtapEndpoint{EndpointProperties: NetworkInfo{Iface: NetlinkIface{LinkAttrs: netlink.LinkAttrs{Name: "macvtap0"}}}}, "macvtap0", false, true},
+		{&TapEndpoint{TapInterface: TapInterface{Name: "eth0", TAPIface: NetworkInterface{Name: "tap0"}}}, "tap0", true, true},
+		{&PhysicalEndpoint{IfaceName: "eth0"}, "", false, false},
+		{&VhostUserEndpoint{IfaceName: "eth0"}, "", false, false},
+	} {
+		name, reversed, ok := endpointStatsLink(d.endpoint)
+		assert.Equal(d.name, name, "%T", d.endpoint)
+		assert.Equal(d.reversed, reversed, "%T", d.endpoint)
+		assert.Equal(d.ok, ok, "%T", d.endpoint)
+	}
+}
+
+func TestSandboxNetworkStats(t *testing.T) {
+	assert := assert.New(t)
+
+	savedLinkStatisticsFn := linkStatisticsFn
+	defer func() {
+		linkStatisticsFn = savedLinkStatisticsFn
+	}()
+
+	links := map[string]*netlink.LinkStatistics{
+		"eth0": {RxBytes: 10, RxPackets: 1, TxBytes: 20, TxPackets: 2, TxDropped: 3},
+		"tap1": {RxBytes: 30, RxPackets: 3, RxErrors: 4, TxBytes: 40, TxPackets: 4},
+	}
+
+	linkStatisticsFn = func(handle *netlink.Handle, name string) (*netlink.LinkStatistics, error) {
+		if s, ok := links[name]; ok {
+			return s, nil
+		}
+		return nil, errors.New("no such link")
+	}
+
+	s := &Sandbox{
+		networkNS: NetworkNamespace{
+			Endpoints: []Endpoint{
+				&VethEndpoint{NetPair: NetworkInterfacePair{VirtIface: NetworkInterface{Name: "eth0"}}},
+				&TapEndpoint{TapInterface: TapInterface{Name: "eth1", TAPIface: NetworkInterface{Name: "tap1"}}},
+				&PhysicalEndpoint{IfaceName: "eth2"},
+			},
+		},
+	}
+
+	stats, err := s.NetworkStats()
+	assert.NoError(err)
+	assert.Equal([]*NetworkStats{
+		{Name: "eth0", RxBytes: 10, RxPackets: 1, TxBytes: 20, TxPackets: 2, TxDropped: 3},
+		{Name: "eth1", RxBytes: 40, RxPackets: 4, TxBytes: 30, TxPackets: 3, TxErrors: 4},
+	}, stats)
+
+	s.networkNS.Endpoints = append(s.networkNS.Endpoints,
+		&VethEndpoint{NetPair: NetworkInterfacePair{VirtIface: NetworkInterface{Name: "eth3"}}})
+
+	_, err = s.NetworkStats()
+	assert.Error(err)
+}
@@ -15,6 +15,7 @@ import (
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 func TestCreateDeleteNetNS(t *testing.T) {
@@ -102,6 +103,78 @@ func TestGenerateInterfacesAndRoutes(t *testing.T) {
 
 }
 
+func TestGenerateInterfacesAndRoutesDualStack(t *testing.T) {
+	assert := assert.New(t)
+
+	addrs := []netlink.Addr{
+		{IPNet: &net.IPNet{IP: net.IPv4(172, 17, 0, 2), Mask: net.CIDRMask(16, 32)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP("fe80::42:acff:fe11:2"), Mask: net.CIDRMask(64, 128)}},
+		{IPNet: &net.IPNet{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)}},
+	}
+
+	_, dst6, _ := net.ParseCIDR("fd00:1::/64")
+	_, kernel6, _ := net.ParseCIDR("fd00::/64")
+
+	routes := []netlink.Route{
+		{Gw: net.IPv4(172, 17, 0, 1)},
+		{Gw: net.ParseIP("fe80::1")},
+		{Dst: dst6, Gw: net.ParseIP("fd00::1"), Src: net.ParseIP("fd00::2")},
+		{Dst: kernel6, Protocol: unix.RTPROT_KERNEL},
+	}
+
+	ep0 := &PhysicalEndpoint{
+		IfaceName: "eth0",
+		HardAddr:  net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}.String(),
+		EndpointProperties: NetworkInfo{
+			Iface:  NetlinkIface{LinkAttrs: netlink.LinkAttrs{MTU: 1500}},
+			Addrs:  addrs,
+			Routes: routes,
+		},
+	}
+
+	nns := NetworkNamespace{NetNsPath: "foobar", NetNsCreated: true, Endpoints: []Endpoint{ep0}}
+
+	resInterfaces, resRoutes, err := generateInterfacesAndRoutes(nns)
+	assert.NoError(err)
+
+	expectedInterfaces := []*vcTypes.Interface{
+		{
+			Device: "eth0",
+			Name:   "eth0",
+			IPAddresses: []*vcTypes.IPAddress{
+				{Family: netlink.FAMILY_V4, Address: "172.17.0.2", Mask: "16"},
+				{Family: netlink.FAMILY_V6, Address: "fd00::2", Mask: "64"},
+			},
+			Mtu:    1500,
+			HwAddr: "02:42:ac:11:00:02",
+		},
+	}
+	assert.Equal(expectedInterfaces, resInterfaces)
+
+	expectedRoutes := []*vcTypes.Route{
+		{Gateway: "172.17.0.1", Device: "eth0"},
+		{Gateway: "fe80::1", Device: "eth0"},
+		{Dest: "fd00:1::/64", Gateway: "fd00::1", Device: "eth0", Source: "fd00::2"},
+	}
+	assert.Equal(expectedRoutes, resRoutes)
+}
+
+func TestNetworkQueueCount(t *testing.T) {
+	assert := assert.New(t)
+
+	// one queue pair per boot vCPU
+	assert.Equal(uint32(2), networkQueueCount(HypervisorConfig{NumVCPUs: 2, DefaultMaxVCPUs: 16}))
+
+	// one queue pair per vCPU the VM can grow to, up to the maximum
+	assert.Equal(uint32(16), networkQueueCount(HypervisorConfig{NumVCPUs: 2, DefaultMaxVCPUs: 16, NetworkMaxQueues: 32}))
+	assert.Equal(uint32(8), networkQueueCount(HypervisorConfig{NumVCPUs: 2, DefaultMaxVCPUs: 16, NetworkMaxQueues: 8}))
+	assert.Equal(uint32(1), networkQueueCount(HypervisorConfig{NumVCPUs: 2, DefaultMaxVCPUs: 16, NetworkMaxQueues: 1}))
+
+	// no more queues than a TAP device has
+	assert.Equal(uint32(maxNetworkQueues), networkQueueCount(HypervisorConfig{NumVCPUs: 2, DefaultMaxVCPUs: 512, NetworkMaxQueues: 1024}))
+}
+
 func TestNetInterworkingModelIsValid(t *testing.T) {
 	tests := []struct {
 		name string
@@ -149,6 +222,22 @@ func TestNetInterworkingModelSetModel(t *testing.T) {
 	}
 }
 
+func TestMacAddressPolicySetPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	var p MacAddressPolicy
+
+	assert.NoError(p.SetPolicy(""))
+	assert.Equal(MacAddressPolicyNone, p)
+
+	for _, policy := range []MacAddressPolicy{MacAddressPolicyNone, MacAddressPolicySandbox, MacAddressPolicyIP} {
+		assert.NoError(p.SetPolicy(string(policy)))
+		assert.Equal(policy, p)
+	}
+
+	assert.Error(p.SetPolicy("random"))
+}
+
 func TestGenerateRandomPrivateMacAdd(t *testing.T) {
 	assert := assert.New(t)
 
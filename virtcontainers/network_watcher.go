@@ -0,0 +1,424 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// kataLinkSuffix ends the names of the network interfaces created by Kata
+// Containers in the network namespace, such as tap0_kata.
+const kataLinkSuffix = "kata"
+
+// networkWatcherDelay is the time the network changes have to settle for
+// before the network of the sandbox is reconciled.
+var networkWatcherDelay = 100 * time.Millisecond
+
+// networkWatcher replaces the netmon binary in the long-lived runtime
+// processes, such as the shim v2. It subscribes to the link, address and
+// route changes of the sandbox network namespace, and once they settle
+// reconciles the network of the sandbox with the one of the namespace.
+type networkWatcher struct {
+	sync.Mutex
+
+	sandbox *Sandbox
+
+	wg      sync.WaitGroup
+	running bool
+	stopCh  chan struct{}
+}
+
+func (w *networkWatcher) Logger() *logrus.Entry {
+	return w.sandbox.Logger().WithField("subsystem", "network-watcher")
+}
+
+// watchedLinks returns the network interfaces of the network namespace,
+// by name. The loopback interface and the ones created by Kata Containers
+// are skipped.
+func watchedLinks(netnsPath string) (map[string]NetworkInfo, error) {
+	netnsHandle, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer netnsHandle.Close()
+
+	netlinkHandle, err := netlink.NewHandleAt(netnsHandle)
+	if err != nil {
+		return nil, err
+	}
+	defer netlinkHandle.Delete()
+
+	linkList, err := netlinkHandle.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[string]NetworkInfo)
+	for _, link := range linkList {
+		attrs := link.Attrs()
+		if strings.HasSuffix(attrs.Name, kataLinkSuffix) || attrs.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		netInfo, err := networkInfoFromLink(netlinkHandle, link)
+		if err != nil {
+			return nil, err
+		}
+		links[attrs.Name] = netInfo
+	}
+
+	return links, nil
+}
+
+// addableLink tells if the network interface, which no endpoint matches, is
+// to be hot plugged to the sandbox. As the netmon binary, only the configured
+// interfaces once UP and RUNNING are added.
+func addableLink(netInfo NetworkInfo) bool {
+	switch netInfo.Iface.Type {
+	case "veth", "macvlan", "ipvlan":
+	default:
+		return false
+	}
+
+	if len(netInfo.Addrs) == 0 {
+		return false
+	}
+
+	running := uint32(unix.IFF_UP | unix.IFF_RUNNING)
+	return netInfo.Iface.RawFlags&running == running
+}
+
+// refreshableEndpoint tells if the properties of the endpoint follow the
+// ones of its network interface. The other interworking models move the
+// addresses of the interface to the guest, leaving none on the host.
+func refreshableEndpoint(endpoint Endpoint) bool {
+	if !interworkingEndpoint(endpoint) {
+		return false
+	}
+
+	model := endpoint.NetworkPair().NetInterworkingModel
+	if model == NetXConnectDefaultModel {
+		model = DefaultNetInterworkingModel
+	}
+
+	return model == NetXConnectTCFilterModel
+}
+
+// interfaceFromNetInfo converts the network interface into the interface
+// the sandbox hot plugs.
+func interfaceFromNetInfo(netInfo NetworkInfo) *vcTypes.Interface {
+	var ipAddresses []*vcTypes.IPAddress
+	for _, addr := range netInfo.Addrs {
+		// The guest kernel generates the IPv6 link-local addresses.
+		if addr.IP.To4() == nil && addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		netMask, _ := addr.Mask.Size()
+		ipAddresses = append(ipAddresses, &vcTypes.IPAddress{
+			Family:  ipFamily(addr.IP),
+			Address: addr.IP.String(),
+			Mask:    fmt.Sprintf("%d", netMask),
+		})
+	}
+
+	return &vcTypes.Interface{
+		Device:      netInfo.Iface.Name,
+		Name:        netInfo.Iface.Name,
+		IPAddresses: ipAddresses,
+		Mtu:         uint64(netInfo.Iface.MTU),
+		HwAddr:      netInfo.Iface.HardwareAddr.String(),
+		LinkType:    netInfo.Iface.Type,
+	}
+}
+
+// sync hot unplugs the endpoints whose network interface was removed, hot
+// plugs the new network interfaces, and updates the interfaces and routes
+// of the guest that changed. It holds the operation lock of the sandbox,
+// unless stopCh is closed while it waits for it.
+func (w *networkWatcher) sync(stopCh <-chan struct{}) {
+	s := w.sandbox
+
+	lock := s.operationLock()
+	if !lockUnlessClosed(lock, stopCh) {
+		return
+	}
+	defer lock.Unlock()
+
+	links, err := watchedLinks(s.networkNS.NetNsPath)
+	if err != nil {
+		w.Logger().WithError(err).Warn("Could not scan the network namespace")
+		return
+	}
+
+	oldInterfaces, oldRoutes, err := generateInterfacesAndRoutes(s.networkNS)
+	if err != nil {
+		w.Logger().WithError(err).Warn("Could not generate the guest interfaces and routes")
+		return
+	}
+
+	refreshed := false
+	known := make(map[string]bool)
+	for _, endpoint := range append([]Endpoint(nil), s.networkNS.Endpoints...) {
+		known[endpoint.Name()] = true
+
+		netInfo, ok := links[endpoint.Name()]
+		if !ok {
+			if !interworkingEndpoint(endpoint) {
+				continue
+			}
+
+			w.Logger().WithField("interface", endpoint.Name()).Info("Network interface removed")
+			if _, err := s.RemoveInterface(&vcTypes.Interface{HwAddr: endpoint.HardwareAddr()}); err != nil {
+				w.Logger().WithError(err).WithField("interface", endpoint.Name()).Warn("Could not remove network interface")
+			}
+			continue
+		}
+
+		if refreshableEndpoint(endpoint) && len(netInfo.Addrs) != 0 {
+			endpoint.SetProperties(netInfo)
+		}
+	}
+
+	for name, netInfo := range links {
+		if known[name] || !addableLink(netInfo) {
+			continue
+		}
+		if _, ok := s.config.NetworkConfig.TapFdSockets[name]; ok {
+			continue
+		}
+
+		w.Logger().WithField("interface", name).Info("Network interface added")
+		if _, err := s.AddInterface(interfaceFromNetInfo(netInfo)); err != nil {
+			w.Logger().WithError(err).WithField("interface", name).Warn("Could not add network interface")
+			continue
+		}
+
+		// Keep the routes of the interface.
+		if endpoint := s.endpointByName(name); endpoint != nil {
+			endpoint.SetProperties(netInfo)
+		}
+		refreshed = true
+	}
+
+	newInterfaces, newRoutes, err := generateInterfacesAndRoutes(s.networkNS)
+	if err != nil {
+		w.Logger().WithError(err).Warn("Could not generate the guest interfaces and routes")
+		return
+	}
+
+	for _, ifc := range newInterfaces {
+		for _, old := range oldInterfaces {
+			if old.Name != ifc.Name || reflect.DeepEqual(old, ifc) {
+				continue
+			}
+
+			w.Logger().WithField("interface", ifc.Name).Info("Network interface updated")
+			refreshed = true
+			if _, err := s.agent.updateInterface(ifc); err != nil {
+				w.Logger().WithError(err).WithField("interface", ifc.Name).Warn("Could not update network interface")
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(oldRoutes, newRoutes) {
+		w.Logger().WithField("routes", newRoutes).Info("Routes updated")
+		refreshed = true
+		if _, err := s.UpdateRoutes(newRoutes); err != nil {
+			w.Logger().WithError(err).Warn("Could not update routes")
+		}
+	}
+
+	if refreshed {
+		if err := s.storeSandbox(); err != nil {
+			w.Logger().WithError(err).Warn("Could not store the sandbox network")
+		}
+	}
+}
+
+// subscribe subscribes to the link, address and route changes of the
+// network namespace, until stopCh is closed. It returns the channels the
+// changes are received on.
+func (w *networkWatcher) subscribe(netnsHandle netns.NsHandle) (chan netlink.LinkUpdate, chan netlink.AddrUpdate, chan netlink.RouteUpdate, error) {
+	errorCallback := func(err error) {
+		w.Logger().WithError(err).Warn("Network namespace subscription failed")
+	}
+
+	linkCh := make(chan netlink.LinkUpdate, 16)
+	if err := netlink.LinkSubscribeWithOptions(linkCh, w.stopCh, netlink.LinkSubscribeOptions{
+		Namespace:     &netnsHandle,
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	addrCh := make(chan netlink.AddrUpdate, 16)
+	if err := netlink.AddrSubscribeWithOptions(addrCh, w.stopCh, netlink.AddrSubscribeOptions{
+		Namespace:     &netnsHandle,
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	routeCh := make(chan netlink.RouteUpdate, 16)
+	if err := netlink.RouteSubscribeWithOptions(routeCh, w.stopCh, netlink.RouteSubscribeOptions{
+		Namespace:     &netnsHandle,
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return linkCh, addrCh, routeCh, nil
+}
+
+// drain unblocks the subscriptions until they close. The nil channels are
+// already closed.
+func (w *networkWatcher) drain(linkCh chan netlink.LinkUpdate, addrCh chan netlink.AddrUpdate, routeCh chan netlink.RouteUpdate) {
+	if linkCh != nil {
+		for range linkCh {
+		}
+	}
+	if addrCh != nil {
+		for range addrCh {
+		}
+	}
+	if routeCh != nil {
+		for range routeCh {
+		}
+	}
+}
+
+func (w *networkWatcher) start() {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.running {
+		return
+	}
+
+	netnsHandle, err := netns.GetFromPath(w.sandbox.networkNS.NetNsPath)
+	if err != nil {
+		w.Logger().WithError(err).Error("Could not open the network namespace")
+		return
+	}
+	defer netnsHandle.Close()
+
+	stopCh := make(chan struct{})
+	w.stopCh = stopCh
+
+	linkCh, addrCh, routeCh, err := w.subscribe(netnsHandle)
+	if err != nil {
+		close(w.stopCh)
+		w.Logger().WithError(err).Error("Could not subscribe to the network namespace changes")
+		return
+	}
+
+	w.running = true
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+
+		// Catch up with the changes since the network was scanned.
+		w.sync(stopCh)
+
+		// The changes come in bursts, such as a link followed by its
+		// addresses and routes, they are synced once they settle.
+		var settled <-chan time.Time
+
+		for {
+			select {
+			case <-stopCh:
+				w.drain(linkCh, addrCh, routeCh)
+				return
+			case _, ok := <-linkCh:
+				if !ok {
+					linkCh = nil
+				}
+				settled = time.After(networkWatcherDelay)
+			case _, ok := <-addrCh:
+				if !ok {
+					addrCh = nil
+				}
+				settled = time.After(networkWatcherDelay)
+			case _, ok := <-routeCh:
+				if !ok {
+					routeCh = nil
+				}
+				settled = time.After(networkWatcherDelay)
+			case <-settled:
+				settled = nil
+				w.sync(stopCh)
+			}
+		}
+	}()
+}
+
+func (w *networkWatcher) stop() {
+	// wait outside of the lock for the watcher loop to exit.
+	defer w.wg.Wait()
+
+	w.Lock()
+	defer w.Unlock()
+
+	if !w.running {
+		return
+	}
+
+	close(w.stopCh)
+	w.running = false
+}
+
+// networkWatcherEnabled tells if the network monitor runs in the process
+// of the sandbox rather than as the netmon binary, which is only possible
+// when the sandbox lives as long as the process, such as in the shim v2.
+func (s *Sandbox) networkWatcherEnabled() bool {
+	netmon := s.config.NetworkConfig.NetmonConfig
+
+	return netmon.Enable && netmon.InProcess && s.config.Stateful && s.networkNS.NetNsPath != ""
+}
+
+func (s *Sandbox) startNetworkWatcher() {
+	if !s.networkWatcherEnabled() {
+		return
+	}
+
+	s.Lock()
+	if s.networkWatcher == nil {
+		s.networkWatcher = &networkWatcher{sandbox: s}
+	}
+	s.Unlock()
+
+	s.networkWatcher.start()
+}
+
+// endpointByName returns the endpoint of the network interface name, or
+// nil if the sandbox has none.
+func (s *Sandbox) endpointByName(name string) Endpoint {
+	for _, endpoint := range s.networkNS.Endpoints {
+		if endpoint.Name() == name {
+			return endpoint
+		}
+	}
+
+	return nil
+}
+
+func (s *Sandbox) stopNetworkWatcher() {
+	if s.networkWatcher != nil {
+		s.networkWatcher.stop()
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddableLink(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, err := netlink.ParseAddr("10.0.0.2/24")
+	assert.NoError(err)
+
+	netInfo := NetworkInfo{
+		Iface: NetlinkIface{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:     "eth1",
+				RawFlags: unix.IFF_UP | unix.IFF_RUNNING,
+			},
+			Type: "veth",
+		},
+		Addrs: []netlink.Addr{*addr},
+	}
+	assert.True(addableLink(netInfo))
+
+	notRunning := netInfo
+	notRunning.Iface.RawFlags = unix.IFF_UP
+	assert.False(addableLink(notRunning))
+
+	unconfigured := netInfo
+	unconfigured.Addrs = nil
+	assert.False(addableLink(unconfigured))
+
+	tap := netInfo
+	tap.Iface.Type = "tuntap"
+	assert.False(addableLink(tap))
+}
+
+func TestRefreshableEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	for model, refreshable := range map[NetInterworkingModel]bool{
+		NetXConnectDefaultModel:  DefaultNetInterworkingModel == NetXConnectTCFilterModel,
+		NetXConnectTCFilterModel: true,
+		NetXConnectBridgedModel:  false,
+		NetXConnectMacVtapModel:  false,
+	} {
+		endpoint := &VethEndpoint{NetPair: NetworkInterfacePair{NetInterworkingModel: model}}
+		assert.Equal(refreshable, refreshableEndpoint(endpoint), "model %v", model)
+	}
+
+	assert.False(refreshableEndpoint(&PhysicalEndpoint{}))
+	assert.False(refreshableEndpoint(&TapFdEndpoint{}))
+}
+
+func TestInterfaceFromNetInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	hw, err := net.ParseMAC("02:00:ca:fe:00:01")
+	assert.NoError(err)
+
+	var addrs []netlink.Addr
+	for _, a := range []string{"10.0.0.2/24", "fd00::2/64", "fe80::1/64"} {
+		addr, err := netlink.ParseAddr(a)
+		assert.NoError(err)
+		addrs = append(addrs, *addr)
+	}
+
+	netInfo := NetworkInfo{
+		Iface: NetlinkIface{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:         "eth1",
+				MTU:          1450,
+				HardwareAddr: hw,
+			},
+			Type: "veth",
+		},
+		Addrs: addrs,
+	}
+
+	expected := &vcTypes.Interface{
+		Device: "eth1",
+		Name:   "eth1",
+		IPAddresses: []*vcTypes.IPAddress{
+			{Family: netlink.FAMILY_V4, Address: "10.0.0.2", Mask: "24"},
+			{Family: netlink.FAMILY_V6, Address: "fd00::2", Mask: "64"},
+		},
+		Mtu:      1450,
+		HwAddr:   "02:00:ca:fe:00:01",
+		LinkType: "veth",
+	}
+
+	assert.Equal(expected, interfaceFromNetInfo(netInfo))
+}
+
+func TestNetworkWatcherEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{
+		config: &SandboxConfig{
+			Stateful: true,
+			NetworkConfig: NetworkConfig{
+				NetmonConfig: NetmonConfig{
+					Enable:    true,
+					InProcess: true,
+				},
+			},
+		},
+		networkNS: NetworkNamespace{NetNsPath: "/var/run/netns/test"},
+	}
+	assert.True(s.networkWatcherEnabled())
+
+	// The runtime CLI keeps starting the netmon binary.
+	s.config.Stateful = false
+	assert.False(s.networkWatcherEnabled())
+
+	s.config.Stateful = true
+	s.config.NetworkConfig.NetmonConfig.InProcess = false
+	assert.False(s.networkWatcherEnabled())
+
+	s.config.NetworkConfig.NetmonConfig.InProcess = true
+	s.networkNS.NetNsPath = ""
+	assert.False(s.networkWatcherEnabled())
+
+	// Nothing to stop without a watcher.
+	s.stopNetworkWatcher()
+}
+
+func TestEndpointByName(t *testing.T) {
+	assert := assert.New(t)
+
+	eth0 := &VethEndpoint{NetPair: NetworkInterfacePair{VirtIface: NetworkInterface{Name: "eth0"}}}
+	eth1 := &VethEndpoint{NetPair: NetworkInterfacePair{VirtIface: NetworkInterface{Name: "eth1"}}}
+
+	s := &Sandbox{networkNS: NetworkNamespace{Endpoints: []Endpoint{eth0, eth1}}}
+	assert.Equal(eth0, s.endpointByName("eth0"))
+	assert.Equal(eth1, s.endpointByName("eth1"))
+	assert.Nil(s.endpointByName("eth2"))
+}
+
+func TestLockUnlessClosed(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+	lock := &sync.Mutex{}
+	s.SetOperationLock(lock)
+	assert.Equal(lock, s.operationLock())
+
+	stopCh := make(chan struct{})
+	assert.True(lockUnlessClosed(lock, stopCh))
+
+	// The runtime holding the lock stops the background task.
+	done := make(chan bool)
+	go func() {
+		done <- lockUnlessClosed(lock, stopCh)
+	}()
+	close(stopCh)
+	assert.False(<-done)
+
+	// The lock is left free once released by the runtime.
+	lock.Unlock()
+	assert.False(lockUnlessClosed(lock, stopCh))
+	lock.Lock()
+	lock.Unlock()
+
+	// The background tasks share a lock unless the runtime sets one.
+	s = &Sandbox{}
+	assert.Equal(s.operationLock(), s.operationLock())
+}
@@ -67,6 +67,11 @@ func (n *noopAgent) createContainer(sandbox *Sandbox, c *Container) (*Process, e
 	return &Process{}, nil
 }
 
+// shareContainerFiles is the Noop agent container files sharing implementation. It does nothing.
+func (n *noopAgent) shareContainerFiles(sandbox *Sandbox, c *Container) error {
+	return nil
+}
+
 // startContainer is the Noop agent Container starting implementation. It does nothing.
 func (n *noopAgent) startContainer(sandbox *Sandbox, c *Container) error {
 	return nil
@@ -232,6 +237,11 @@ func (n *noopAgent) copyFile(src, dst string) error {
 func (n *noopAgent) markDead() {
 }
 
+// health is the Noop agent health. It is always healthy.
+func (n *noopAgent) health() AgentHealth {
+	return AgentHealthy
+}
+
 func (n *noopAgent) cleanup(s *Sandbox) {
 }
 
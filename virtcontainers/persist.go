@@ -32,8 +32,18 @@ func (s *Sandbox) dumpState(ss *persistapi.SandboxState, cs map[string]persistap
 	ss.SandboxContainer = s.id
 	ss.GuestMemoryBlockSizeMB = s.state.GuestMemoryBlockSizeMB
 	ss.GuestMemoryHotplugProbe = s.state.GuestMemoryHotplugProbe
+	ss.GuestBlkSerial = s.state.GuestBlkSerial
+	ss.GuestLUKS = s.state.GuestLUKS
+	ss.GuestIntegrity = s.state.GuestIntegrity
+	ss.GuestVirtioPmem = s.state.GuestVirtioPmem
+	ss.GuestWatchableBind = s.state.GuestWatchableBind
 	ss.State = string(s.state.State)
+	ss.ShutdownReason = string(s.state.ShutdownReason)
+	ss.ShutdownMessage = s.state.ShutdownMessage
 	ss.CgroupPath = s.state.CgroupPath
+	ss.HypervisorMemoryCap = s.state.HypervisorMemoryCap
+	ss.VSockPorts = s.state.VSockPorts
+	ss.Provenance = provenanceToState(s.state.Provenance)
 
 	for id, cont := range s.containers {
 		state := persistapi.ContainerState{}
@@ -42,8 +52,9 @@ func (s *Sandbox) dumpState(ss *persistapi.SandboxState, cs map[string]persistap
 		}
 		state.State = string(cont.state.State)
 		state.Rootfs = persistapi.RootfsState{
-			BlockDeviceID: cont.state.BlockDeviceID,
-			FsType:        cont.state.Fstype,
+			BlockDeviceID:   cont.state.BlockDeviceID,
+			FsType:          cont.state.Fstype,
+			ScratchDeviceID: cont.state.ScratchDeviceID,
 		}
 		state.CgroupPath = cont.state.CgroupPath
 		cs[id] = state
@@ -61,6 +72,39 @@ func (s *Sandbox) dumpHypervisor(ss *persistapi.SandboxState) {
 	ss.HypervisorState = s.hypervisor.save()
 	// BlockIndex will be moved from sandbox state to hypervisor state later
 	ss.HypervisorState.BlockIndex = s.state.BlockIndex
+	ss.HypervisorState.FreeBlockIndexes = s.state.FreeBlockIndexes
+}
+
+func provenanceToState(p *types.Provenance) *persistapi.Provenance {
+	if p == nil {
+		return nil
+	}
+
+	ps := &persistapi.Provenance{
+		RuntimeVersion: p.RuntimeVersion,
+		RecordedAt:     p.RecordedAt,
+	}
+	for _, c := range p.Components {
+		ps.Components = append(ps.Components, persistapi.ProvenanceComponent(c))
+	}
+
+	return ps
+}
+
+func provenanceFromState(ps *persistapi.Provenance) *types.Provenance {
+	if ps == nil {
+		return nil
+	}
+
+	p := &types.Provenance{
+		RuntimeVersion: ps.RuntimeVersion,
+		RecordedAt:     ps.RecordedAt,
+	}
+	for _, c := range ps.Components {
+		p.Components = append(p.Components, types.ProvenanceComponent(c))
+	}
+
+	return p
 }
 
 func deviceToDeviceState(devices []api.Device) (dss []persistapi.DeviceState) {
@@ -140,6 +184,10 @@ func (s *Sandbox) dumpMounts(cs map[string]persistapi.ContainerState) {
 				HostPath:      m.HostPath,
 				ReadOnly:      m.ReadOnly,
 				BlockDeviceID: m.BlockDeviceID,
+				BlockFstype:   m.BlockFstype,
+
+				OwnershipRemapped: m.OwnershipRemapped,
+				WatchableSource:   m.WatchableSource,
 			})
 		}
 
@@ -197,17 +245,29 @@ func (s *Sandbox) loadState(ss persistapi.SandboxState) {
 	s.state.PersistVersion = ss.PersistVersion
 	s.state.GuestMemoryBlockSizeMB = ss.GuestMemoryBlockSizeMB
 	s.state.BlockIndex = ss.HypervisorState.BlockIndex
+	s.state.FreeBlockIndexes = ss.HypervisorState.FreeBlockIndexes
 	s.state.State = types.StateString(ss.State)
+	s.state.ShutdownReason = types.ShutdownReason(ss.ShutdownReason)
+	s.state.ShutdownMessage = ss.ShutdownMessage
 	s.state.CgroupPath = ss.CgroupPath
+	s.state.HypervisorMemoryCap = ss.HypervisorMemoryCap
+	s.state.VSockPorts = ss.VSockPorts
+	s.state.Provenance = provenanceFromState(ss.Provenance)
 	s.state.GuestMemoryHotplugProbe = ss.GuestMemoryHotplugProbe
+	s.state.GuestBlkSerial = ss.GuestBlkSerial
+	s.state.GuestLUKS = ss.GuestLUKS
+	s.state.GuestIntegrity = ss.GuestIntegrity
+	s.state.GuestVirtioPmem = ss.GuestVirtioPmem
+	s.state.GuestWatchableBind = ss.GuestWatchableBind
 }
 
 func (c *Container) loadContState(cs persistapi.ContainerState) {
 	c.state = types.ContainerState{
-		State:         types.StateString(cs.State),
-		BlockDeviceID: cs.Rootfs.BlockDeviceID,
-		Fstype:        cs.Rootfs.FsType,
-		CgroupPath:    cs.CgroupPath,
+		State:           types.StateString(cs.State),
+		BlockDeviceID:   cs.Rootfs.BlockDeviceID,
+		Fstype:          cs.Rootfs.FsType,
+		ScratchDeviceID: cs.Rootfs.ScratchDeviceID,
+		CgroupPath:      cs.CgroupPath,
 	}
 }
 
@@ -248,6 +308,10 @@ func (c *Container) loadContMounts(cs persistapi.ContainerState) {
 			HostPath:      m.HostPath,
 			ReadOnly:      m.ReadOnly,
 			BlockDeviceID: m.BlockDeviceID,
+			BlockFstype:   m.BlockFstype,
+
+			OwnershipRemapped: m.OwnershipRemapped,
+			WatchableSource:   m.WatchableSource,
 		})
 	}
 }
@@ -284,6 +348,10 @@ func (s *Sandbox) loadNetwork(netInfo persistapi.NetworkInfo) {
 			ep = &TapEndpoint{}
 		case IPVlanEndpointType:
 			ep = &IPVlanEndpoint{}
+		case XDPEndpointType:
+			ep = &XDPEndpoint{}
+		case TapFdEndpointType:
+			ep = &TapFdEndpoint{}
 		default:
 			s.Logger().WithField("endpoint-type", e.Type).Error("unknown endpoint type")
 			continue
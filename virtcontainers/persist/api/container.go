@@ -54,6 +54,18 @@ type Mount struct {
 	// VM in case this mount is a block device file or a directory
 	// backed by a block device.
 	BlockDeviceID string
+
+	// BlockFstype is the filesystem type of the block device of a
+	// direct-assigned directory volume.
+	BlockFstype string
+
+	// OwnershipRemapped is true if the ownership of the files of the
+	// source was remapped to the user namespace of the container.
+	OwnershipRemapped bool
+
+	// WatchableSource is the guest path of the shared directory the agent
+	// mirrors the watchable mount from.
+	WatchableSource string
 }
 
 // RootfsState saves state of container rootfs
@@ -64,6 +76,10 @@ type RootfsState struct {
 
 	// RootFStype is file system of the rootfs incase it is block device
 	FsType string
+
+	// ScratchDeviceID is the block device of the writable layer of a
+	// rootfs layered on a read-only image
+	ScratchDeviceID string
 }
 
 // Process gathers data related to a container process.
@@ -41,6 +41,16 @@ type BlockDrive struct {
 
 	// DevNo
 	DevNo string
+
+	// Serial is the serial number of a virtio-blk drive
+	Serial string
+
+	// ReadOnly prevents the guest from writing to the drive
+	ReadOnly bool
+
+	// Integrity is the algorithm of the dm-integrity target the agent
+	// sets up on the drive
+	Integrity string
 }
 
 // VFIODev represents a VFIO drive used for hotplugging
@@ -96,6 +106,10 @@ type DeviceState struct {
 	// for example, for BlockDevice, we can set DriverOptions["blockDriver"]="virtio-blk"
 	DriverOptions map[string]string
 
+	// HostPath and Format identify block device image files
+	HostPath string `json:",omitempty"`
+	Format   string `json:",omitempty"`
+
 	// ============ device driver specific data ===========
 	// BlockDrive is specific for block device driver
 	BlockDrive *BlockDrive `json:",omitempty"`
@@ -26,6 +26,19 @@ type CPUDevice struct {
 	ID string
 }
 
+// NetworkDevice represents a network device which was hot-added in a running VM
+type NetworkDevice struct {
+	// ID identifies the device on its bridge, it is the ID of the TAP
+	// interface of the device.
+	ID string
+	// DevID is the ID of the device in the hypervisor.
+	DevID string
+	// Netdev is the ID of the network backend of the device.
+	Netdev string
+	// MACAddress identifies the endpoint of the device.
+	MACAddress string
+}
+
 type HypervisorState struct {
 	Pid int
 	// Type of hypervisor, E.g. qemu/firecracker/acrn.
@@ -33,12 +46,34 @@ type HypervisorState struct {
 	BlockIndex int
 	UUID       string
 
+	// FreeBlockIndexes are the block indexes released by unplugged
+	// block devices
+	FreeBlockIndexes []int
+
 	// Belows are qemu specific
 	// Refs: virtcontainers/qemu.go:QemuState
 	Bridges []Bridge
 	// HotpluggedCPUs is the list of CPUs that were hot-added
-	HotpluggedVCPUs      []CPUDevice
+	HotpluggedVCPUs []CPUDevice
+	// HotpluggedNetDevices is the list of network devices that were hot-added
+	HotpluggedNetDevices []NetworkDevice
 	HotpluggedMemory     int
 	VirtiofsdPid         int
 	HotplugVFIOOnRootBus bool
+	// VolumeVirtiofsdPids maps the tag of each virtio-fs volume to the
+	// pid of its virtiofsd daemon
+	VolumeVirtiofsdPids map[string]int
+	// MemPreallocPending is true while the guest memory is pre-allocated
+	// in the background
+	MemPreallocPending bool
+	// VMMUserGrants are the host files of the hotplugged devices the
+	// unprivileged QEMU was granted access to, once per device
+	VMMUserGrants []string
+	// DriveGrants maps the ID of each firecracker drive to the host file
+	// the unprivileged firecracker was granted access to
+	DriveGrants map[string]string
+	// NvdimmCount is the number of nvdimm ids in use or free
+	NvdimmCount int
+	// NvdimmFreeIDs are the ids released by unplugged nvdimm devices
+	NvdimmFreeIDs []int
 }
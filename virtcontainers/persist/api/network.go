@@ -37,6 +37,17 @@ type PhysicalEndpoint struct {
 	BDF            string
 	Driver         string
 	VendorDeviceID string
+	VF             *SRIOVVF
+}
+
+// SRIOVVF is the SR-IOV virtual function behind a physical endpoint, and the
+// settings programmed on its physical function.
+type SRIOVVF struct {
+	PFName    string
+	Index     int
+	VLAN      int
+	Trust     bool
+	LinkState string
 }
 
 type MacvtapEndpoint struct {
@@ -47,18 +58,22 @@ type MacvtapEndpoint struct {
 
 type TapEndpoint struct {
 	TapInterface TapInterface
+	PCIAddr      string
 }
 
 type BridgedMacvlanEndpoint struct {
 	NetPair NetworkInterfacePair
+	PCIAddr string
 }
 
 type VethEndpoint struct {
 	NetPair NetworkInterfacePair
+	PCIAddr string
 }
 
 type IPVlanEndpoint struct {
 	NetPair NetworkInterfacePair
+	PCIAddr string
 }
 
 type VhostUserEndpoint struct {
@@ -68,6 +83,24 @@ type VhostUserEndpoint struct {
 	PCIAddr   string
 }
 
+type XDPEndpoint struct {
+	NetPair      NetworkInterfacePair
+	PCIAddr      string
+	SocketPath   string
+	Queue        uint32
+	ForwarderPid int
+	Fallback     bool
+
+	// ForwarderStartTime identifies the forwarder along with its pid.
+	ForwarderStartTime uint64
+}
+
+type TapFdEndpoint struct {
+	TapInterface TapInterface
+	PCIAddr      string
+	SocketPath   string
+}
+
 // NetworkEndpoint contains network interface information
 type NetworkEndpoint struct {
 	Type string
@@ -80,6 +113,8 @@ type NetworkEndpoint struct {
 	Macvtap        *MacvtapEndpoint        `json:",omitempty"`
 	Tap            *TapEndpoint            `json:",omitempty"`
 	IPVlan         *IPVlanEndpoint         `json:",omitempty"`
+	XDP            *XDPEndpoint            `json:",omitempty"`
+	TapFd          *TapFdEndpoint          `json:",omitempty"`
 }
 
 // NetworkInfo contains network information of sandbox
@@ -6,6 +6,8 @@
 
 package persistapi
 
+import "time"
+
 // ============= sandbox level resources =============
 
 // AgentState save agent state data
@@ -17,6 +19,21 @@ type AgentState struct {
 	URL string
 }
 
+// ProvenanceComponent identifies a component a sandbox runs on
+type ProvenanceComponent struct {
+	Name    string
+	Path    string
+	Version string
+	Digest  string
+}
+
+// Provenance records the components a sandbox has been started with
+type Provenance struct {
+	RuntimeVersion string
+	Components     []ProvenanceComponent
+	RecordedAt     time.Time
+}
+
 // SandboxState contains state information of sandbox
 // nolint: maligned
 type SandboxState struct {
@@ -26,12 +43,37 @@ type SandboxState struct {
 	// State is sandbox running status
 	State string
 
+	// ShutdownReason records why the sandbox has been stopped
+	ShutdownReason string
+
+	// ShutdownMessage details the shutdown reason
+	ShutdownMessage string
+
 	// GuestMemoryBlockSizeMB is the size of memory block of guestos
 	GuestMemoryBlockSizeMB uint32
 
 	// GuestMemoryHotplugProbe determines whether guest kernel supports memory hotplug probe interface
 	GuestMemoryHotplugProbe bool
 
+	// GuestBlkSerial determines whether the agent finds virtio-blk devices
+	// by their serial number
+	GuestBlkSerial bool
+
+	// GuestLUKS determines whether the agent opens LUKS encrypted storages
+	GuestLUKS bool
+
+	// GuestIntegrity determines whether the agent sets up dm-integrity
+	// targets on the storages
+	GuestIntegrity bool
+
+	// GuestVirtioPmem determines whether the agent finds virtio-pmem
+	// devices and storages
+	GuestVirtioPmem bool
+
+	// GuestWatchableBind determines whether the agent mirrors the
+	// watchable mounts into the guest
+	GuestWatchableBind bool
+
 	// SandboxContainer specifies which container is used to start the sandbox/vm
 	SandboxContainer string
 
@@ -40,6 +82,15 @@ type SandboxState struct {
 	// FIXME: sandbox can reuse "SandboxContainer"'s CgroupPath so we can remove this field.
 	CgroupPath string
 
+	// HypervisorMemoryCap is the host memory limit applied to the hypervisor processes
+	HypervisorMemoryCap int64
+
+	// VSockPorts maps the guest services reachable over vsock to their port
+	VSockPorts map[string]uint32
+
+	// Provenance records the components the sandbox has been started with
+	Provenance *Provenance
+
 	// Devices plugged to sandbox(hypervisor)
 	Devices []DeviceState
 
@@ -15,6 +15,7 @@ import (
 	"syscall"
 
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/sirupsen/logrus"
 )
 
@@ -213,7 +214,7 @@ func (fs *FS) Destroy() error {
 		return err
 	}
 
-	if err := os.RemoveAll(sandboxDir); err != nil {
+	if err := store.RemoveSandboxDir(sandboxDir); err != nil {
 		return err
 	}
 	return nil
@@ -254,6 +255,11 @@ func (fs *FS) unlock() error {
 	return nil
 }
 
+// SetRunStoragePath relocates the sandbox runtime directory to path.
+func SetRunStoragePath(path string) {
+	runStoragePath = path
+}
+
 // TestSetRunStoragePath set runStoragePath to path
 // this function is only used for testing purpose
 func TestSetRunStoragePath(path string) {
@@ -102,6 +102,7 @@ func TestSandboxRestore(t *testing.T) {
 	sandbox.state.State = types.StateString("running")
 	sandbox.state.GuestMemoryBlockSizeMB = uint32(1024)
 	sandbox.state.BlockIndex = 2
+	sandbox.state.FreeBlockIndexes = []int{0}
 	// flush data to disk
 	err = sandbox.Save()
 	assert.Nil(err)
@@ -115,4 +116,5 @@ func TestSandboxRestore(t *testing.T) {
 	assert.Equal(sandbox.state.State, types.StateString("running"))
 	assert.Equal(sandbox.state.GuestMemoryBlockSizeMB, uint32(1024))
 	assert.Equal(sandbox.state.BlockIndex, 2)
+	assert.Equal(sandbox.state.FreeBlockIndexes, []int{0})
 }
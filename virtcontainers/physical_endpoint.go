@@ -28,6 +28,9 @@ type PhysicalEndpoint struct {
 	Driver             string
 	VendorDeviceID     string
 	PCIAddr            string
+
+	// VF is set when the interface is an SR-IOV virtual function.
+	VF *SRIOVVF
 }
 
 // Properties returns the properties of the physical interface.
@@ -80,19 +83,24 @@ func (endpoint *PhysicalEndpoint) Attach(h hypervisor) error {
 	}
 
 	// TODO: use device manager as general device management entrance
+	return h.addDevice(endpoint.vfioDevice(), vfioDev)
+}
+
+// vfioDevice returns the VFIO device passed through for the endpoint.
+func (endpoint *PhysicalEndpoint) vfioDevice() config.VFIODev {
 	var vendorID, deviceID string
 	if splits := strings.Split(endpoint.VendorDeviceID, " "); len(splits) == 2 {
 		vendorID = splits[0]
 		deviceID = splits[1]
 	}
 
-	d := config.VFIODev{
+	return config.VFIODev{
+		ID:       "vfio-" + endpoint.IfaceName,
+		Type:     config.VFIODeviceNormalType,
 		BDF:      endpoint.BDF,
 		VendorID: vendorID,
 		DeviceID: deviceID,
 	}
-
-	return h.addDevice(d, vfioDev)
 }
 
 // Detach for physical endpoint unbinds the physical network interface from vfio-pci
@@ -104,17 +112,56 @@ func (endpoint *PhysicalEndpoint) Detach(netNsCreated bool, netNsPath string) er
 
 	// We do not need to enter the network namespace to bind back the
 	// physical interface to host driver.
-	return bindNICToHost(endpoint)
+	if err := bindNICToHost(endpoint); err != nil {
+		return err
+	}
+
+	if endpoint.VF != nil {
+		return endpoint.VF.restore()
+	}
+
+	return nil
 }
 
-// HotAttach for physical endpoint not supported yet
+// HotAttach for physical endpoint binds the SR-IOV virtual function to
+// vfio-pci and hotplugs it into the VM. Other physical network interfaces
+// do not support it.
 func (endpoint *PhysicalEndpoint) HotAttach(h hypervisor) error {
-	return fmt.Errorf("PhysicalEndpoint does not support Hot attach")
+	if endpoint.VF == nil {
+		return fmt.Errorf("PhysicalEndpoint does not support Hot attach")
+	}
+
+	if err := bindNICToVFIO(endpoint); err != nil {
+		return err
+	}
+
+	device := endpoint.vfioDevice()
+	if _, err := h.hotplugAddDevice(&device, vfioDev); err != nil {
+		networkLogger().WithError(err).Error("Error attach SR-IOV virtual function")
+		if bindErr := bindNICToHost(endpoint); bindErr != nil {
+			networkLogger().WithError(bindErr).Warn("Error binding back SR-IOV virtual function")
+		}
+		return err
+	}
+
+	return nil
 }
 
-// HotDetach for physical endpoint not supported yet
+// HotDetach for physical endpoint unplugs the SR-IOV virtual function from
+// the VM and gives it back to the host. Other physical network interfaces
+// do not support it.
 func (endpoint *PhysicalEndpoint) HotDetach(h hypervisor, netNsCreated bool, netNsPath string) error {
-	return fmt.Errorf("PhysicalEndpoint does not support Hot detach")
+	if endpoint.VF == nil {
+		return fmt.Errorf("PhysicalEndpoint does not support Hot detach")
+	}
+
+	device := endpoint.vfioDevice()
+	if _, err := h.hotplugRemoveDevice(&device, vfioDev); err != nil {
+		networkLogger().WithError(err).Error("Error detach SR-IOV virtual function")
+		return err
+	}
+
+	return endpoint.Detach(netNsCreated, netNsPath)
 }
 
 // isPhysicalIface checks if an interface is a physical device.
@@ -190,6 +237,11 @@ func createPhysicalEndpoint(netInfo NetworkInfo) (*PhysicalEndpoint, error) {
 	vendorDeviceID := fmt.Sprintf("%s %s", vendorID, deviceID)
 	vendorDeviceID = strings.TrimSpace(vendorDeviceID)
 
+	vf, err := sriovVF(bdf)
+	if err != nil {
+		return nil, err
+	}
+
 	physicalEndpoint := &PhysicalEndpoint{
 		IfaceName:      netInfo.Iface.Name,
 		HardAddr:       netInfo.Iface.HardwareAddr.String(),
@@ -197,6 +249,7 @@ func createPhysicalEndpoint(netInfo NetworkInfo) (*PhysicalEndpoint, error) {
 		EndpointType:   PhysicalEndpointType,
 		Driver:         driver,
 		BDF:            bdf,
+		VF:             vf,
 	}
 
 	return physicalEndpoint, nil
@@ -218,6 +271,7 @@ func (endpoint *PhysicalEndpoint) save() persistapi.NetworkEndpoint {
 			BDF:            endpoint.BDF,
 			Driver:         endpoint.Driver,
 			VendorDeviceID: endpoint.VendorDeviceID,
+			VF:             saveSRIOVVF(endpoint.VF),
 		},
 	}
 }
@@ -229,5 +283,6 @@ func (endpoint *PhysicalEndpoint) load(s persistapi.NetworkEndpoint) {
 		endpoint.BDF = s.Physical.BDF
 		endpoint.Driver = s.Physical.Driver
 		endpoint.VendorDeviceID = s.Physical.VendorDeviceID
+		endpoint.VF = loadSRIOVVF(s.Physical.VF)
 	}
 }
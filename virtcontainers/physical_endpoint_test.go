@@ -11,6 +11,7 @@ import (
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
@@ -42,6 +43,30 @@ func TestPhysicalEndpoint_HotDetach(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestPhysicalEndpointVFIODevice(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &PhysicalEndpoint{
+		IfaceName:      "eth1",
+		BDF:            "0000:3b:02.1",
+		VendorDeviceID: "0x8086 0x154c",
+		VF:             &SRIOVVF{PFName: "ens1f0", Index: 1},
+	}
+
+	assert.Equal(config.VFIODev{
+		ID:       "vfio-eth1",
+		Type:     config.VFIODeviceNormalType,
+		BDF:      "0000:3b:02.1",
+		VendorID: "0x8086",
+		DeviceID: "0x154c",
+	}, v.vfioDevice())
+
+	s := v.save()
+	var loaded PhysicalEndpoint
+	loaded.load(s)
+	assert.Equal(v.VF, loaded.VF)
+}
+
 func TestIsPhysicalIface(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,175 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+// Package agentctl sends individual RPCs to the agent of a running sandbox,
+// with JSON encoded requests and responses. It is meant to debug agent
+// level issues without going through the whole runtime.
+package agentctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	kataclient "github.com/kata-containers/agent/protocols/client"
+	"github.com/kata-containers/runtime/virtcontainers/persist"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/hvsock"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// agentState mirrors the agent state stored by the runtime.
+type agentState struct {
+	ProxyPid int
+	URL      string
+}
+
+// AgentURL returns the URL of the agent of the given sandbox, as persisted
+// by the runtime.
+func AgentURL(ctx context.Context, sandboxID string) (string, error) {
+	if sandboxID == "" {
+		return "", fmt.Errorf("Missing sandbox ID")
+	}
+
+	// Sandboxes created with the newstore experimental feature only
+	// persist their state through the persist driver.
+	if driver, err := persist.GetDriver("fs"); err == nil {
+		if ss, _, err := driver.FromDisk(sandboxID); err == nil && ss.AgentState.URL != "" {
+			return ss.AgentState.URL, nil
+		}
+	}
+
+	path, err := store.SandboxRuntimeItemPath(sandboxID, store.Agent)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("Could not find the agent state of sandbox %s: %v", sandboxID, err)
+	}
+
+	s, err := store.NewVCSandboxStore(ctx, sandboxID)
+	if err != nil {
+		return "", err
+	}
+
+	var state agentState
+	if err := s.Load(store.Agent, &state); err != nil {
+		return "", err
+	}
+
+	if state.URL == "" {
+		return "", fmt.Errorf("Sandbox %s has no agent URL, is it running?", sandboxID)
+	}
+
+	return state.URL, nil
+}
+
+// Conn is a connection to an agent.
+type Conn struct {
+	client io.Closer
+
+	// rpcs holds the agent RPCs as methods.
+	rpcs reflect.Value
+}
+
+// Dial connects to the agent listening on url. yamux must be set when the
+// runtime uses its built-in proxy.
+func Dial(ctx context.Context, url string, yamux bool) (*Conn, error) {
+	if strings.HasPrefix(url, hvsock.Scheme+"://") {
+		client, err := hvsock.NewAgentClient(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		conn := newConn(client)
+		conn.client = client
+
+		return conn, nil
+	}
+
+	client, err := kataclient.NewAgentClient(ctx, url, yamux)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConn(client)
+	conn.client = client
+
+	return conn, nil
+}
+
+func newConn(rpcs interface{}) *Conn {
+	return &Conn{
+		rpcs: reflect.ValueOf(rpcs),
+	}
+}
+
+// Close closes the connection to the agent.
+func (c *Conn) Close() error {
+	if c.client == nil {
+		return nil
+	}
+
+	return c.client.Close()
+}
+
+// isRPC returns true if t is the type of a gRPC client method, that is
+// func(context.Context, *Request, ...grpc.CallOption) (*Response, error).
+func isRPC(t reflect.Type) bool {
+	return t.NumIn() == 3 && t.IsVariadic() &&
+		t.In(0) == contextType &&
+		t.In(1).Kind() == reflect.Ptr && t.In(1).Elem().Kind() == reflect.Struct &&
+		t.NumOut() == 2 && t.Out(1) == errorType
+}
+
+// RPCs returns the sorted names of the RPCs the agent can be sent.
+func (c *Conn) RPCs() []string {
+	var names []string
+
+	t := c.rpcs.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		if isRPC(c.rpcs.Method(i).Type()) {
+			names = append(names, t.Method(i).Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Call sends the RPC name to the agent. input is the JSON encoded request,
+// an empty input sends an empty request. The response is returned JSON
+// encoded.
+func (c *Conn) Call(ctx context.Context, name string, input []byte) ([]byte, error) {
+	m := c.rpcs.MethodByName(name)
+	if !m.IsValid() || !isRPC(m.Type()) {
+		return nil, fmt.Errorf("Unknown agent RPC %q", name)
+	}
+
+	req := reflect.New(m.Type().In(1).Elem())
+	if len(input) > 0 {
+		if err := json.Unmarshal(input, req.Interface()); err != nil {
+			return nil, fmt.Errorf("Invalid %s request: %v", name, err)
+		}
+	}
+
+	out := m.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(out[0].Interface())
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package agentctl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/kata-containers/agent/protocols/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type fakeAgent struct {
+	lastReq *pb.GuestDetailsRequest
+}
+
+func (f *fakeAgent) GetGuestDetails(ctx context.Context, req *pb.GuestDetailsRequest, opts ...grpc.CallOption) (*pb.GuestDetailsResponse, error) {
+	f.lastReq = req
+	return &pb.GuestDetailsResponse{MemBlockSizeBytes: 128}, nil
+}
+
+func (f *fakeAgent) DestroySandbox(ctx context.Context, req *pb.DestroySandboxRequest, opts ...grpc.CallOption) (*pb.GuestDetailsResponse, error) {
+	return nil, errors.New("destroy failed")
+}
+
+// NotAnRPC does not have the signature of a gRPC client method.
+func (f *fakeAgent) NotAnRPC() error {
+	return nil
+}
+
+func TestConnRPCs(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := newConn(&fakeAgent{})
+	assert.Equal([]string{"DestroySandbox", "GetGuestDetails"}, conn.RPCs())
+	assert.NoError(conn.Close())
+}
+
+func TestConnCall(t *testing.T) {
+	assert := assert.New(t)
+
+	agent := &fakeAgent{}
+	conn := newConn(agent)
+	ctx := context.Background()
+
+	out, err := conn.Call(ctx, "GetGuestDetails", []byte(`{"mem_block_size":true}`))
+	assert.NoError(err)
+	assert.True(agent.lastReq.MemBlockSize)
+	assert.Contains(string(out), `"mem_block_size_bytes":128`)
+
+	// An empty input sends an empty request
+	_, err = conn.Call(ctx, "GetGuestDetails", nil)
+	assert.NoError(err)
+	assert.False(agent.lastReq.MemBlockSize)
+
+	_, err = conn.Call(ctx, "GetGuestDetails", []byte("{"))
+	assert.Error(err)
+
+	_, err = conn.Call(ctx, "DestroySandbox", nil)
+	assert.EqualError(err, "destroy failed")
+
+	_, err = conn.Call(ctx, "NotAnRPC", nil)
+	assert.Error(err)
+
+	_, err = conn.Call(ctx, "Foo", nil)
+	assert.Error(err)
+}
+
+func TestAgentURL(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := AgentURL(context.Background(), "")
+	assert.Error(err)
+
+	_, err = AgentURL(context.Background(), "agentctl-no-such-sandbox")
+	assert.Error(err)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package agentctl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/persist"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/hvsock"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/mdlayher/vsock"
+)
+
+// consoleSocket is the socket of the guest console of the hypervisors
+// emulating a serial port, in the VM directory.
+const consoleSocket = "console.sock"
+
+// ConsoleURL returns the URL of the debug console of the guest of the given
+// sandbox. The debug console listens on the vsock port registered for it
+// when the agent is given agent.debug_console_vport, and is the guest
+// console when the agent is given agent.debug_console.
+func ConsoleURL(ctx context.Context, sandboxID string) (string, error) {
+	agentURL, err := AgentURL(ctx, sandboxID)
+	if err != nil {
+		return "", err
+	}
+
+	return consoleURL(sandboxID, agentURL, vsockPorts(ctx, sandboxID))
+}
+
+// vsockPorts returns the vsock ports registered by the given sandbox, nil
+// if they cannot be read.
+func vsockPorts(ctx context.Context, sandboxID string) types.VSockPorts {
+	if driver, err := persist.GetDriver("fs"); err == nil {
+		if ss, _, err := driver.FromDisk(sandboxID); err == nil && ss.AgentState.URL != "" {
+			return ss.VSockPorts
+		}
+	}
+
+	s, err := store.NewVCSandboxStore(ctx, sandboxID)
+	if err != nil {
+		return nil
+	}
+
+	state, err := s.LoadState()
+	if err != nil {
+		return nil
+	}
+
+	return state.VSockPorts
+}
+
+func consoleURL(sandboxID, agentURL string, ports types.VSockPorts) (string, error) {
+	port, ok := ports[types.VSockDebugConsoleService]
+	if !ok {
+		path := filepath.Join(store.RunVMStoragePath, sandboxID, consoleSocket)
+		return "unix://" + path, nil
+	}
+
+	// The vsock and hybrid vsock URLs of the agent end with its port.
+	i := strings.LastIndex(agentURL, ":")
+	isVSock := strings.HasPrefix(agentURL, "vsock://") || strings.HasPrefix(agentURL, hvsock.Scheme+"://")
+	if !isVSock || i < 0 {
+		return "", fmt.Errorf("Invalid agent URL %q for the vsock debug console", agentURL)
+	}
+
+	return fmt.Sprintf("%s:%d", agentURL[:i], port), nil
+}
+
+// DialConsole connects to the debug console listening on consoleURL.
+func DialConsole(consoleURL string, timeout time.Duration) (net.Conn, error) {
+	u, err := url.Parse(consoleURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		return net.DialTimeout("unix", u.Path, timeout)
+	case "vsock":
+		cid, err := strconv.ParseUint(u.Hostname(), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vsock context ID in %q: %v", consoleURL, err)
+		}
+
+		port, err := strconv.ParseUint(u.Port(), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vsock port in %q: %v", consoleURL, err)
+		}
+
+		return vsock.Dial(uint32(cid), uint32(port))
+	case hvsock.Scheme:
+		udsPath, port, err := hvsock.Parse(consoleURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return hvsock.Dial(udsPath, port, timeout)
+	}
+
+	return nil, fmt.Errorf("Unsupported debug console URL %q", consoleURL)
+}
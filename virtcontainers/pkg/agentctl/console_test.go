@@ -0,0 +1,81 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package agentctl
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleURL(t *testing.T) {
+	assert := assert.New(t)
+
+	ports := types.VSockPorts{
+		types.VSockAgentService:        types.VSockAgentPort,
+		types.VSockDebugConsoleService: types.VSockDebugConsolePort,
+	}
+
+	url, err := consoleURL("sandbox", "vsock://3:1024", ports)
+	assert.NoError(err)
+	assert.Equal("vsock://3:1026", url)
+
+	url, err = consoleURL("sandbox", "hvsock:///run/vc/kata.hvsock:1024", ports)
+	assert.NoError(err)
+	assert.Equal("hvsock:///run/vc/kata.hvsock:1026", url)
+
+	// The vsock debug console needs the agent to be reached over vsock
+	_, err = consoleURL("sandbox", "/run/vc/vm/sandbox/kata.sock", ports)
+	assert.Error(err)
+
+	// The guest console otherwise
+	url, err = consoleURL("sandbox", "vsock://3:1024", types.VSockPorts{types.VSockAgentService: types.VSockAgentPort})
+	assert.NoError(err)
+	assert.Equal("unix://"+filepath.Join(store.RunVMStoragePath, "sandbox", consoleSocket), url)
+}
+
+func TestDialConsole(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "console")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, consoleSocket)
+	l, err := net.Listen("unix", path)
+	assert.NoError(err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("/ # "))
+		conn.Close()
+	}()
+
+	conn, err := DialConsole("unix://"+path, time.Second)
+	assert.NoError(err)
+	defer conn.Close()
+
+	prompt, err := ioutil.ReadAll(conn)
+	assert.NoError(err)
+	assert.Equal("/ # ", string(prompt))
+
+	_, err = DialConsole("vsock://foo:1026", time.Second)
+	assert.Error(err)
+
+	_, err = DialConsole("tcp://127.0.0.1:1026", time.Second)
+	assert.Error(err)
+}
@@ -53,6 +53,117 @@ const (
 	// ContainerTypeKey is the annotation key to fetch container type.
 	ContainerTypeKey = vcAnnotationsPrefix + "pkg.oci.container_type"
 
+	// VirtioFSVolumes is a sandbox annotation for sharing host directories
+	// with the guest through their own virtio-fs device, rather than through
+	// the sandbox shared directory. It is a semicolon separated list of
+	// tag=/host/path entries, e.g.:
+	//
+	//   com.github.containers.virtcontainers.VirtioFSVolumes: "data=/srv/data;models=/srv/models"
+	//
+	// The host paths must be under the virtio_fs_volume_paths of the
+	// hypervisor configuration.
+	//
+	VirtioFSVolumes = vcAnnotationsPrefix + "VirtioFSVolumes"
+
+	// VCPUCPUSet is a sandbox annotation pinning each vCPU thread to a
+	// host CPU taken in turn from the given list, in cpuset.cpus format,
+	// e.g.:
+	//
+	//   com.github.containers.virtcontainers.VCPUCPUSet: "2-5,8"
+	//
+	VCPUCPUSet = vcAnnotationsPrefix + "VCPUCPUSet"
+
+	// HugePageSize is a sandbox annotation backing the guest memory with
+	// huge pages of the given size, e.g. "2M" or "1G".
+	HugePageSize = vcAnnotationsPrefix + "HugePageSize"
+
+	// HugePagesPath is a sandbox annotation backing the guest memory with
+	// the huge pages of the given hugetlbfs mount, e.g. a mount dedicated
+	// to the sandbox.
+	HugePagesPath = vcAnnotationsPrefix + "HugePagesPath"
+
+	// SGXEPC is the sandbox annotation set by the Intel SGX device plugin
+	// admission webhook on the pods requesting the sgx.intel.com/epc
+	// resource. It is the size of the enclave page cache section exposed
+	// to the guest, e.g. "64Mi".
+	SGXEPC = "sgx.intel.com/epc"
+
+	// ConfigPathKey is the annotation key recording the path of the runtime
+	// configuration file the sandbox has been created with.
+	ConfigPathKey = vcAnnotationsPrefix + "pkg.oci.config_path"
+
+	// ConfigSourceKey is the annotation key recording why the runtime
+	// configuration file recorded by ConfigPathKey has been selected.
+	ConfigSourceKey = vcAnnotationsPrefix + "pkg.oci.config_source"
+
+	// ConfigSnapshotKey is the annotation key recording the copy of the
+	// runtime configuration file the sandbox has been created with, which
+	// the sandbox keeps when the file changes.
+	ConfigSnapshotKey = vcAnnotationsPrefix + "pkg.oci.config_snapshot"
+
+	// HotplugReservation is a container annotation giving the ID of the
+	// hotplug reservation made on the introspection socket of the shim
+	// for the devices of the container, e.g. by a device plugin.
+	HotplugReservation = vcAnnotationsPrefix + "HotplugReservation"
+
+	// RootfsLUKSKeyName is a container annotation giving the name of the
+	// key of the LUKS encrypted block device of the rootfs, opened by the
+	// agent inside the guest, in the LUKS key directory of the runtime.
+	RootfsLUKSKeyName = vcAnnotationsPrefix + "RootfsLUKSKeyName"
+
+	// RootfsLUKSKeyID is a container annotation giving the ID of the key
+	// of the LUKS encrypted rootfs, returned by the configured key
+	// provider.
+	RootfsLUKSKeyID = vcAnnotationsPrefix + "RootfsLUKSKeyID"
+
+	// RootfsLUKSFstype is a container annotation giving the type of the
+	// filesystem of the LUKS encrypted rootfs, ext4 by default.
+	RootfsLUKSFstype = vcAnnotationsPrefix + "RootfsLUKSFstype"
+
+	// XDPInterfaces is a sandbox annotation selecting the veth network
+	// interfaces forwarded to the guest through AF_XDP sockets, when the
+	// af_xdp experimental feature is enabled. It is a semicolon separated
+	// list of interface[=queue] entries, the queue defaulting to 0, e.g.:
+	//
+	//   com.github.containers.virtcontainers.XDPInterfaces: "eth0;eth1=2"
+	//
+	XDPInterfaces = vcAnnotationsPrefix + "XDPInterfaces"
+
+	// TapFdSockets is a sandbox annotation giving the unix sockets of the
+	// device plugins passing the file descriptors of existing TAP devices,
+	// which the runtime then does not create. It is a semicolon separated
+	// list of interface=socket entries, the sockets lying in the directory
+	// configured by the operator, e.g.:
+	//
+	//   com.github.containers.virtcontainers.TapFdSockets: "net1=/run/kata-containers/tap-fd/net1.sock"
+	//
+	TapFdSockets = vcAnnotationsPrefix + "TapFdSockets"
+
+	// DefaultMemory is a sandbox annotation overriding the memory of the
+	// VM, in MiB, up to the memory of the host. Like the other hypervisor
+	// configuration annotations, it must be allowed by the
+	// enable_annotations of the hypervisor configuration.
+	DefaultMemory = vcAnnotationsPrefix + "DefaultMemory"
+
+	// DefaultVCPUs is a sandbox annotation overriding the number of vCPUs
+	// of the VM, which cannot exceed its maximum number of vCPUs.
+	DefaultVCPUs = vcAnnotationsPrefix + "DefaultVCPUs"
+
+	// KernelParams is a sandbox annotation giving guest kernel parameters
+	// appended to the ones of the hypervisor configuration, e.g.:
+	//
+	//   com.github.containers.virtcontainers.KernelParams: "agent.log=debug systemd.unit=kata.target"
+	//
+	KernelParams = vcAnnotationsPrefix + "KernelParams"
+
+	// MachineType is a sandbox annotation overriding the machine type of
+	// the VM, e.g. "q35".
+	MachineType = vcAnnotationsPrefix + "MachineType"
+
+	// VirtioFSCache is a sandbox annotation overriding the virtio-fs cache
+	// mode: "none", "auto" or "always".
+	VirtioFSCache = vcAnnotationsPrefix + "VirtioFSCache"
+
 	// KernelModules is the annotation key for passing the list of kernel
 	// modules and their parameters that will be loaded in the guest kernel.
 	// Semicolon separated list of kernel modules and their parameters.
@@ -63,6 +174,9 @@ const (
 	//     com.github.containers.virtcontainers.KernelModules: "e1000e InterruptThrottleRate=3000,3000,3000 EEE=1; i915 enable_ppgtt=0"
 	//
 	// The first word is considered as the module name and the rest as its parameters.
+	// The modules must be listed by the kernel_modules_allowlist of the
+	// agent configuration. No module may be loaded through the annotation
+	// when the allowlist is empty.
 	//
 	KernelModules = vcAnnotationsPrefix + "KernelModules"
 )
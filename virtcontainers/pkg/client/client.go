@@ -0,0 +1,399 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"github.com/kata-containers/runtime/pkg/katautils"
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Version is the version of the client API.
+const Version = "v1"
+
+// Client manages Kata Containers sandboxes.
+type Client interface {
+	// CreateSandbox creates a sandbox and its containers, without
+	// starting them.
+	CreateSandbox(ctx context.Context, config SandboxConfig) (SandboxStatus, error)
+
+	// StartSandbox starts a created sandbox and its containers.
+	StartSandbox(ctx context.Context, sandboxID string) (SandboxStatus, error)
+
+	// StopSandbox stops a running sandbox. When force is true, guest
+	// failures are ignored.
+	StopSandbox(ctx context.Context, sandboxID string, force bool) (SandboxStatus, error)
+
+	// DeleteSandbox deletes a stopped sandbox.
+	DeleteSandbox(ctx context.Context, sandboxID string) error
+
+	// ListSandboxes returns the status of all the sandboxes on the host.
+	ListSandboxes(ctx context.Context) ([]SandboxStatus, error)
+
+	// SandboxStatus returns the status of a sandbox.
+	SandboxStatus(ctx context.Context, sandboxID string) (SandboxStatus, error)
+
+	// SandboxStats returns the statistics of all the containers of a sandbox.
+	SandboxStats(ctx context.Context, sandboxID string) (SandboxStats, error)
+
+	// CreateContainer creates a container in a running sandbox.
+	CreateContainer(ctx context.Context, sandboxID string, config ContainerConfig) (ContainerStatus, error)
+
+	// StartContainer starts a created container.
+	StartContainer(ctx context.Context, sandboxID, containerID string) error
+
+	// KillContainer sends a signal to a container. When all is true, the
+	// signal is sent to all the processes of the container.
+	KillContainer(ctx context.Context, sandboxID, containerID string, signal syscall.Signal, all bool) error
+
+	// DeleteContainer stops and deletes a container.
+	DeleteContainer(ctx context.Context, sandboxID, containerID string, force bool) error
+
+	// ContainerStatus returns the status of a container.
+	ContainerStatus(ctx context.Context, sandboxID, containerID string) (ContainerStatus, error)
+
+	// ContainerStats returns the statistics of a container.
+	ContainerStats(ctx context.Context, sandboxID, containerID string) (ContainerStats, error)
+
+	// UpdateContainer updates the resources of a container, resizing the
+	// sandbox if needed.
+	UpdateContainer(ctx context.Context, sandboxID, containerID string, resources specs.LinuxResources) error
+
+	// AddDevice hotplugs a device into a sandbox.
+	AddDevice(ctx context.Context, sandboxID string, info DeviceInfo) error
+
+	// AddInterface hotplugs a network interface into a sandbox.
+	AddInterface(ctx context.Context, sandboxID string, inf *Interface) (*Interface, error)
+
+	// RemoveInterface hot unplugs a network interface from a sandbox.
+	RemoveInterface(ctx context.Context, sandboxID string, inf *Interface) (*Interface, error)
+
+	// ListInterfaces lists the network interfaces of a sandbox.
+	ListInterfaces(ctx context.Context, sandboxID string) ([]*Interface, error)
+
+	// UpdateRoutes replaces the network routes of a sandbox.
+	UpdateRoutes(ctx context.Context, sandboxID string, routes []*Route) ([]*Route, error)
+
+	// ListRoutes lists the network routes of a sandbox.
+	ListRoutes(ctx context.Context, sandboxID string) ([]*Route, error)
+}
+
+type client struct {
+	vci           vc.VC
+	runtimeConfig oci.RuntimeConfig
+}
+
+// New returns a Client managing sandboxes with the virtcontainers
+// implementation, configured by the runtime configuration file at
+// configPath. The default configuration file is used when configPath is
+// empty.
+func New(configPath string) (Client, error) {
+	_, runtimeConfig, err := katautils.LoadConfiguration(configPath, true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		vci:           &vc.VCImpl{},
+		runtimeConfig: runtimeConfig,
+	}, nil
+}
+
+// NewWithVC returns a Client backed by the provided virtcontainers
+// implementation and the default runtime configuration. This is mostly
+// useful to inject a mock in tests.
+func NewWithVC(vci vc.VC) Client {
+	return &client{vci: vci}
+}
+
+// checkContext returns the context error when the context is done.
+func checkContext(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("nil context")
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
+
+func (c *client) CreateSandbox(ctx context.Context, config SandboxConfig) (SandboxStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return SandboxStatus{}, err
+	}
+
+	sandboxConfig, err := oci.SandboxConfig(config.Spec, c.runtimeConfig, config.Bundle, config.ID, "", true, false)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+
+	s, err := c.vci.CreateSandbox(ctx, sandboxConfig)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+
+	return newSandboxStatus(s.Status()), nil
+}
+
+func (c *client) StartSandbox(ctx context.Context, sandboxID string) (SandboxStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return SandboxStatus{}, err
+	}
+
+	s, err := c.vci.StartSandbox(ctx, sandboxID)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+
+	return newSandboxStatus(s.Status()), nil
+}
+
+func (c *client) StopSandbox(ctx context.Context, sandboxID string, force bool) (SandboxStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return SandboxStatus{}, err
+	}
+
+	s, err := c.vci.StopSandbox(ctx, sandboxID, force)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+
+	return newSandboxStatus(s.Status()), nil
+}
+
+func (c *client) DeleteSandbox(ctx context.Context, sandboxID string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	_, err := c.vci.DeleteSandbox(ctx, sandboxID)
+	return err
+}
+
+func (c *client) ListSandboxes(ctx context.Context) ([]SandboxStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	list, err := c.vci.ListSandbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var sandboxes []SandboxStatus
+	for _, s := range list {
+		sandboxes = append(sandboxes, newSandboxStatus(s))
+	}
+
+	return sandboxes, nil
+}
+
+func (c *client) SandboxStatus(ctx context.Context, sandboxID string) (SandboxStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return SandboxStatus{}, err
+	}
+
+	status, err := c.vci.StatusSandbox(ctx, sandboxID)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+
+	return newSandboxStatus(status), nil
+}
+
+func (c *client) SandboxStats(ctx context.Context, sandboxID string) (SandboxStats, error) {
+	status, err := c.SandboxStatus(ctx, sandboxID)
+	if err != nil {
+		return SandboxStats{}, err
+	}
+
+	stats := SandboxStats{
+		ContainerStats: make(map[string]ContainerStats, len(status.Containers)),
+	}
+
+	for _, cs := range status.Containers {
+		if err := checkContext(ctx); err != nil {
+			return SandboxStats{}, err
+		}
+
+		s, err := c.vci.StatsContainer(ctx, sandboxID, cs.ID)
+		if err != nil {
+			return SandboxStats{}, err
+		}
+
+		stats.ContainerStats[cs.ID] = newContainerStats(s)
+	}
+
+	return stats, nil
+}
+
+func (c *client) CreateContainer(ctx context.Context, sandboxID string, config ContainerConfig) (ContainerStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return ContainerStatus{}, err
+	}
+
+	if err := oci.CheckAnnotations(config.Spec, c.runtimeConfig.HypervisorConfig); err != nil {
+		return ContainerStatus{}, err
+	}
+
+	containerConfig, err := oci.ContainerConfig(config.Spec, config.Bundle, config.ID, "", true)
+	if err != nil {
+		return ContainerStatus{}, err
+	}
+
+	_, container, err := c.vci.CreateContainer(ctx, sandboxID, containerConfig)
+	if err != nil {
+		return ContainerStatus{}, err
+	}
+
+	return c.ContainerStatus(ctx, sandboxID, container.ID())
+}
+
+func (c *client) StartContainer(ctx context.Context, sandboxID, containerID string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	_, err := c.vci.StartContainer(ctx, sandboxID, containerID)
+	return err
+}
+
+func (c *client) KillContainer(ctx context.Context, sandboxID, containerID string, signal syscall.Signal, all bool) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	return c.vci.KillContainer(ctx, sandboxID, containerID, signal, all)
+}
+
+func (c *client) DeleteContainer(ctx context.Context, sandboxID, containerID string, force bool) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	if _, err := c.vci.StopContainer(ctx, sandboxID, containerID); err != nil && !force {
+		return err
+	}
+
+	_, err := c.vci.DeleteContainer(ctx, sandboxID, containerID)
+	return err
+}
+
+func (c *client) ContainerStatus(ctx context.Context, sandboxID, containerID string) (ContainerStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return ContainerStatus{}, err
+	}
+
+	status, err := c.vci.StatusContainer(ctx, sandboxID, containerID)
+	if err != nil {
+		return ContainerStatus{}, err
+	}
+
+	return newContainerStatus(status), nil
+}
+
+func (c *client) ContainerStats(ctx context.Context, sandboxID, containerID string) (ContainerStats, error) {
+	if err := checkContext(ctx); err != nil {
+		return ContainerStats{}, err
+	}
+
+	stats, err := c.vci.StatsContainer(ctx, sandboxID, containerID)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	return newContainerStats(stats), nil
+}
+
+func (c *client) UpdateContainer(ctx context.Context, sandboxID, containerID string, resources specs.LinuxResources) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	return c.vci.UpdateContainer(ctx, sandboxID, containerID, resources)
+}
+
+func (c *client) AddDevice(ctx context.Context, sandboxID string, info DeviceInfo) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	_, err := c.vci.AddDevice(ctx, sandboxID, info.vcDeviceInfo())
+	return err
+}
+
+func (c *client) AddInterface(ctx context.Context, sandboxID string, inf *Interface) (*Interface, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	added, err := c.vci.AddInterface(ctx, sandboxID, inf.vcInterface())
+	if err != nil {
+		return nil, err
+	}
+
+	return newInterface(added), nil
+}
+
+func (c *client) RemoveInterface(ctx context.Context, sandboxID string, inf *Interface) (*Interface, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	removed, err := c.vci.RemoveInterface(ctx, sandboxID, inf.vcInterface())
+	if err != nil {
+		return nil, err
+	}
+
+	return newInterface(removed), nil
+}
+
+func (c *client) ListInterfaces(ctx context.Context, sandboxID string) ([]*Interface, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	infs, err := c.vci.ListInterfaces(ctx, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInterfaces(infs), nil
+}
+
+func (c *client) UpdateRoutes(ctx context.Context, sandboxID string, routes []*Route) ([]*Route, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	updated, err := c.vci.UpdateRoutes(ctx, sandboxID, vcRoutes(routes))
+	if err != nil {
+		return nil, err
+	}
+
+	return newRoutes(updated), nil
+}
+
+func (c *client) ListRoutes(ctx context.Context, sandboxID string) ([]*Route, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	routes, err := c.vci.ListRoutes(ctx, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRoutes(routes), nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/vcmock"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSandboxID = "sandbox"
+
+func TestClientCancelledContext(t *testing.T) {
+	assert := assert.New(t)
+
+	called := false
+	m := &vcmock.VCMock{
+		StatusSandboxFunc: func(ctx context.Context, sandboxID string) (vc.SandboxStatus, error) {
+			called = true
+			return vc.SandboxStatus{}, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.SandboxStatus(ctx, testSandboxID)
+	assert.Equal(context.Canceled, err)
+	assert.False(called)
+
+	_, err = c.SandboxStatus(nil, testSandboxID)
+	assert.Error(err)
+	assert.False(called)
+}
+
+func TestClientSandboxStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &vcmock.VCMock{
+		StatusSandboxFunc: func(ctx context.Context, sandboxID string) (vc.SandboxStatus, error) {
+			return vc.SandboxStatus{
+				ID:    sandboxID,
+				State: types.SandboxState{State: types.StateRunning},
+				ContainersStatus: []vc.ContainerStatus{
+					{ID: "foo", State: types.ContainerState{State: types.StatePaused}, PID: 42},
+				},
+			}, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	status, err := c.SandboxStatus(context.Background(), testSandboxID)
+	assert.NoError(err)
+	assert.Equal(testSandboxID, status.ID)
+	assert.Equal(StateRunning, status.State)
+	assert.Equal([]ContainerStatus{{ID: "foo", State: StatePaused, PID: 42}}, status.Containers)
+}
+
+func TestClientSandboxStats(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &vcmock.VCMock{
+		StatusSandboxFunc: func(ctx context.Context, sandboxID string) (vc.SandboxStatus, error) {
+			return vc.SandboxStatus{
+				ID: sandboxID,
+				ContainersStatus: []vc.ContainerStatus{
+					{ID: "foo"},
+					{ID: "bar"},
+				},
+			}, nil
+		},
+		StatsContainerFunc: func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error) {
+			stats := vc.ContainerStats{
+				CgroupStats:  &vc.CgroupStats{},
+				NetworkStats: []*vc.NetworkStats{{Name: "eth0", RxBytes: 10}},
+			}
+			stats.CgroupStats.MemoryStats.Usage.Usage = 4096
+			return stats, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	stats, err := c.SandboxStats(context.Background(), testSandboxID)
+	assert.NoError(err)
+	assert.Len(stats.ContainerStats, 2)
+	assert.Contains(stats.ContainerStats, "foo")
+	assert.Contains(stats.ContainerStats, "bar")
+	assert.Equal(uint64(4096), stats.ContainerStats["foo"].Memory.Usage)
+	assert.Equal([]NetworkStats{{Name: "eth0", RxBytes: 10}}, stats.ContainerStats["foo"].Network)
+
+	m.StatsContainerFunc = func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error) {
+		return vc.ContainerStats{}, errors.New("stats failure")
+	}
+
+	_, err = c.SandboxStats(context.Background(), testSandboxID)
+	assert.Error(err)
+}
+
+func TestClientListSandboxes(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &vcmock.VCMock{}
+	c := NewWithVC(m)
+
+	// unset mock functions return an error
+	_, err := c.ListSandboxes(context.Background())
+	assert.Error(err)
+
+	m.ListSandboxFunc = func(ctx context.Context) ([]vc.SandboxStatus, error) {
+		return []vc.SandboxStatus{{ID: testSandboxID}}, nil
+	}
+
+	list, err := c.ListSandboxes(context.Background())
+	assert.NoError(err)
+	assert.Len(list, 1)
+}
+
+func TestClientDeleteContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	deleted := false
+	m := &vcmock.VCMock{
+		StopContainerFunc: func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error) {
+			return nil, errors.New("stop failure")
+		},
+		DeleteContainerFunc: func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error) {
+			deleted = true
+			return &vcmock.Container{}, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	err := c.DeleteContainer(context.Background(), testSandboxID, "foo", false)
+	assert.Error(err)
+	assert.False(deleted)
+
+	err = c.DeleteContainer(context.Background(), testSandboxID, "foo", true)
+	assert.NoError(err)
+	assert.True(deleted)
+}
+
+func TestClientRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	routes := []*Route{{Dest: "10.1.0.0/16", Gateway: "10.0.0.1", Device: "net1"}}
+
+	m := &vcmock.VCMock{
+		UpdateRoutesFunc: func(ctx context.Context, sandboxID string, r []*vcTypes.Route) ([]*vcTypes.Route, error) {
+			return r, nil
+		},
+		ListRoutesFunc: func(ctx context.Context, sandboxID string) ([]*vcTypes.Route, error) {
+			return []*vcTypes.Route{{Dest: "10.1.0.0/16", Gateway: "10.0.0.1", Device: "net1"}}, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	res, err := c.UpdateRoutes(context.Background(), testSandboxID, routes)
+	assert.NoError(err)
+	assert.Equal(routes, res)
+
+	res, err = c.ListRoutes(context.Background(), testSandboxID)
+	assert.NoError(err)
+	assert.Equal(routes, res)
+}
+
+func TestClientInterfaces(t *testing.T) {
+	assert := assert.New(t)
+
+	inf := &Interface{
+		Name:        "eth0",
+		IPAddresses: []IPAddress{{Family: 2, Address: "10.0.0.2", Mask: "24"}},
+		Mtu:         1500,
+		HwAddr:      "02:00:ca:fe:00:48",
+	}
+
+	m := &vcmock.VCMock{
+		AddInterfaceFunc: func(ctx context.Context, sandboxID string, i *vcTypes.Interface) (*vcTypes.Interface, error) {
+			added := *i
+			added.PciAddr = "01/02"
+			return &added, nil
+		},
+	}
+	c := NewWithVC(m)
+
+	res, err := c.AddInterface(context.Background(), testSandboxID, inf)
+	assert.NoError(err)
+	assert.Equal("01/02", res.PciAddr)
+	assert.Equal(inf.IPAddresses, res.IPAddresses)
+	assert.Empty(inf.PciAddr)
+}
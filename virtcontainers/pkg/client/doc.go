@@ -0,0 +1,31 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+/*
+Package client is the stable Go API to manage Kata Containers sandboxes from
+out-of-tree programs, such as node agents.
+
+The package wraps the virtcontainers API behind the Client interface. Every
+operation takes a context, which is checked before the operation is sent to
+the sandbox: a cancelled or expired context makes the call fail with the
+context error without touching the sandbox.
+
+Stability
+
+The client API is versioned through the Version constant. Within a major
+version:
+
+  - methods are never removed from Client and their signatures never change,
+  - new methods are only added to new interfaces embedding Client,
+  - the configuration and status types only get new fields.
+
+These types are defined by the package and converted from and to the
+virtcontainers ones at the boundary, so that programs do not depend on the
+virtcontainers package directly, its API can change between releases.
+Sandboxes and containers are described by their OCI bundle and spec, and
+configured by the runtime configuration file given to New, as for the
+runtime and the shim.
+*/
+package client
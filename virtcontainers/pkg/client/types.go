@@ -0,0 +1,390 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package client
+
+import (
+	"os"
+	"time"
+
+	vc "github.com/kata-containers/runtime/virtcontainers"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// State is the state of a sandbox or a container.
+type State string
+
+const (
+	// StateReady is the state of a created sandbox or container.
+	StateReady State = "ready"
+
+	// StateRunning is the state of a running sandbox or container.
+	StateRunning State = "running"
+
+	// StatePaused is the state of a paused sandbox or container.
+	StatePaused State = "paused"
+
+	// StateStopped is the state of a stopped sandbox or container.
+	StateStopped State = "stopped"
+)
+
+// SandboxConfig is the configuration of a sandbox, described by the OCI
+// bundle of its first container as when created by a container manager.
+type SandboxConfig struct {
+	// ID is the ID of the sandbox and of its first container.
+	ID string
+
+	// Bundle is the path of the OCI bundle of the first container.
+	Bundle string
+
+	// Spec is the OCI runtime spec of the first container.
+	Spec specs.Spec
+}
+
+// ContainerConfig is the configuration of a container, described by its
+// OCI bundle.
+type ContainerConfig struct {
+	// ID is the ID of the container.
+	ID string
+
+	// Bundle is the path of the OCI bundle of the container.
+	Bundle string
+
+	// Spec is the OCI runtime spec of the container.
+	Spec specs.Spec
+}
+
+// SandboxStatus is the status of a sandbox.
+type SandboxStatus struct {
+	ID         string
+	State      State
+	Hypervisor string
+	Agent      string
+	Containers []ContainerStatus
+
+	// Annotations are the annotations of the sandbox.
+	Annotations map[string]string
+}
+
+// ContainerStatus is the status of a container.
+type ContainerStatus struct {
+	ID        string
+	State     State
+	PID       int
+	StartTime time.Time
+	RootFs    string
+
+	// Annotations are the annotations of the container.
+	Annotations map[string]string
+}
+
+// CPUStats are the CPU usage statistics of a container, in nanoseconds.
+type CPUStats struct {
+	TotalUsage  uint64
+	KernelUsage uint64
+	UserUsage   uint64
+
+	// Periods, ThrottledPeriods and ThrottledTime describe the
+	// throttling of the container by its CPU quota.
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    uint64
+}
+
+// MemoryStats are the memory usage statistics of a container, in bytes.
+type MemoryStats struct {
+	Usage     uint64
+	MaxUsage  uint64
+	Limit     uint64
+	Cache     uint64
+	SwapUsage uint64
+}
+
+// PidsStats are the number of processes of a container and their limit.
+type PidsStats struct {
+	Current uint64
+	Limit   uint64
+}
+
+// NetworkStats are the statistics of a network interface.
+type NetworkStats struct {
+	Name      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// ContainerStats are the resources usage statistics of a container.
+type ContainerStats struct {
+	CPU     CPUStats
+	Memory  MemoryStats
+	Pids    PidsStats
+	Network []NetworkStats
+}
+
+// SandboxStats are the resources usage statistics of all the containers
+// of a sandbox, indexed by container ID.
+type SandboxStats struct {
+	ContainerStats map[string]ContainerStats
+}
+
+// DeviceInfo describes a device to hotplug into a sandbox.
+type DeviceInfo struct {
+	// ID is the ID of the device, generated when empty.
+	ID string
+
+	// HostPath is the path of the device on the host.
+	HostPath string
+
+	// ContainerPath is the path of the device in the container.
+	ContainerPath string
+
+	// DevType is the type of the device, "c", "b", "u" or "p".
+	DevType string
+
+	Major    int64
+	Minor    int64
+	FileMode os.FileMode
+	UID      uint32
+	GID      uint32
+
+	// ReadOnly attaches a block device read-only.
+	ReadOnly bool
+}
+
+// IPAddress is an IP address of a network interface.
+type IPAddress struct {
+	Family  int
+	Address string
+	Mask    string
+}
+
+// Interface describes a network interface of a sandbox.
+type Interface struct {
+	Device      string
+	Name        string
+	IPAddresses []IPAddress
+	Mtu         uint64
+	RawFlags    uint32
+	HwAddr      string
+
+	// PciAddr is the PCI address of the interface in the guest, as
+	// "bridgeAddr/deviceAddr". It is only set by the sandbox.
+	PciAddr string
+
+	// LinkType is the netlink type of the interface, e.g. "veth".
+	LinkType string
+}
+
+// Route describes a network route of a sandbox.
+type Route struct {
+	Dest    string
+	Gateway string
+	Device  string
+	Source  string
+	Scope   uint32
+}
+
+func newSandboxStatus(s vc.SandboxStatus) SandboxStatus {
+	status := SandboxStatus{
+		ID:          s.ID,
+		State:       State(s.State.State),
+		Hypervisor:  string(s.Hypervisor),
+		Agent:       string(s.Agent),
+		Annotations: s.Annotations,
+	}
+
+	for _, c := range s.ContainersStatus {
+		status.Containers = append(status.Containers, newContainerStatus(c))
+	}
+
+	return status
+}
+
+func newContainerStatus(c vc.ContainerStatus) ContainerStatus {
+	return ContainerStatus{
+		ID:          c.ID,
+		State:       State(c.State.State),
+		PID:         c.PID,
+		StartTime:   c.StartTime,
+		RootFs:      c.RootFs,
+		Annotations: c.Annotations,
+	}
+}
+
+func newContainerStats(s vc.ContainerStats) ContainerStats {
+	var stats ContainerStats
+
+	if cg := s.CgroupStats; cg != nil {
+		cpu := cg.CPUStats
+		stats.CPU = CPUStats{
+			TotalUsage:       cpu.CPUUsage.TotalUsage,
+			KernelUsage:      cpu.CPUUsage.UsageInKernelmode,
+			UserUsage:        cpu.CPUUsage.UsageInUsermode,
+			Periods:          cpu.ThrottlingData.Periods,
+			ThrottledPeriods: cpu.ThrottlingData.ThrottledPeriods,
+			ThrottledTime:    cpu.ThrottlingData.ThrottledTime,
+		}
+
+		memory := cg.MemoryStats
+		stats.Memory = MemoryStats{
+			Usage:     memory.Usage.Usage,
+			MaxUsage:  memory.Usage.MaxUsage,
+			Limit:     memory.Usage.Limit,
+			Cache:     memory.Cache,
+			SwapUsage: memory.SwapUsage.Usage,
+		}
+
+		stats.Pids = PidsStats{
+			Current: cg.PidsStats.Current,
+			Limit:   cg.PidsStats.Limit,
+		}
+	}
+
+	for _, n := range s.NetworkStats {
+		if n == nil {
+			continue
+		}
+
+		stats.Network = append(stats.Network, NetworkStats{
+			Name:      n.Name,
+			RxBytes:   n.RxBytes,
+			RxPackets: n.RxPackets,
+			RxErrors:  n.RxErrors,
+			RxDropped: n.RxDropped,
+			TxBytes:   n.TxBytes,
+			TxPackets: n.TxPackets,
+			TxErrors:  n.TxErrors,
+			TxDropped: n.TxDropped,
+		})
+	}
+
+	return stats
+}
+
+func (d DeviceInfo) vcDeviceInfo() config.DeviceInfo {
+	return config.DeviceInfo{
+		ID:            d.ID,
+		HostPath:      d.HostPath,
+		ContainerPath: d.ContainerPath,
+		DevType:       d.DevType,
+		Major:         d.Major,
+		Minor:         d.Minor,
+		FileMode:      d.FileMode,
+		UID:           d.UID,
+		GID:           d.GID,
+		ReadOnly:      d.ReadOnly,
+	}
+}
+
+func (i *Interface) vcInterface() *vcTypes.Interface {
+	if i == nil {
+		return nil
+	}
+
+	inf := &vcTypes.Interface{
+		Device:   i.Device,
+		Name:     i.Name,
+		Mtu:      i.Mtu,
+		RawFlags: i.RawFlags,
+		HwAddr:   i.HwAddr,
+		PciAddr:  i.PciAddr,
+		LinkType: i.LinkType,
+	}
+
+	for _, addr := range i.IPAddresses {
+		inf.IPAddresses = append(inf.IPAddresses, &vcTypes.IPAddress{
+			Family:  addr.Family,
+			Address: addr.Address,
+			Mask:    addr.Mask,
+		})
+	}
+
+	return inf
+}
+
+func newInterface(inf *vcTypes.Interface) *Interface {
+	if inf == nil {
+		return nil
+	}
+
+	i := &Interface{
+		Device:   inf.Device,
+		Name:     inf.Name,
+		Mtu:      inf.Mtu,
+		RawFlags: inf.RawFlags,
+		HwAddr:   inf.HwAddr,
+		PciAddr:  inf.PciAddr,
+		LinkType: inf.LinkType,
+	}
+
+	for _, addr := range inf.IPAddresses {
+		if addr == nil {
+			continue
+		}
+
+		i.IPAddresses = append(i.IPAddresses, IPAddress{
+			Family:  addr.Family,
+			Address: addr.Address,
+			Mask:    addr.Mask,
+		})
+	}
+
+	return i
+}
+
+func newInterfaces(infs []*vcTypes.Interface) []*Interface {
+	var list []*Interface
+	for _, inf := range infs {
+		list = append(list, newInterface(inf))
+	}
+
+	return list
+}
+
+func vcRoutes(routes []*Route) []*vcTypes.Route {
+	var list []*vcTypes.Route
+	for _, r := range routes {
+		if r == nil {
+			continue
+		}
+
+		list = append(list, &vcTypes.Route{
+			Dest:    r.Dest,
+			Gateway: r.Gateway,
+			Device:  r.Device,
+			Source:  r.Source,
+			Scope:   r.Scope,
+		})
+	}
+
+	return list
+}
+
+func newRoutes(routes []*vcTypes.Route) []*Route {
+	var list []*Route
+	for _, r := range routes {
+		if r == nil {
+			continue
+		}
+
+		list = append(list, &Route{
+			Dest:    r.Dest,
+			Gateway: r.Gateway,
+			Device:  r.Device,
+			Source:  r.Source,
+			Scope:   r.Scope,
+		})
+	}
+
+	return list
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/go-openapi/validate"
 )
 
-// Vsock vsock
+// Vsock Defines a vsock device, backed by a set of Unix Domain Sockets, on the host side. For host-initiated connections, Firecracker will be listening on the Unix socket identified by the path `uds_path`. Firecracker will create this socket, bind and listen on it. Host-initiated connections will be performed by connection to this socket and issuing a connection forwarding request to the desired guest-side vsock port (i.e. `CONNECT 52\n`, to connect to port 52).
 // swagger:model Vsock
 type Vsock struct {
 
@@ -22,9 +22,13 @@ type Vsock struct {
 	// Minimum: 3
 	GuestCid *int64 `json:"guest_cid"`
 
-	// id
+	// Path to UNIX domain socket, used to proxy vsock connections.
 	// Required: true
-	ID *string `json:"id"`
+	UdsPath *string `json:"uds_path"`
+
+	// vsock id
+	// Required: true
+	VsockID *string `json:"vsock_id"`
 }
 
 // Validate validates this vsock
@@ -35,7 +39,11 @@ func (m *Vsock) Validate(formats strfmt.Registry) error {
 		res = append(res, err)
 	}
 
-	if err := m.validateID(formats); err != nil {
+	if err := m.validateUdsPath(formats); err != nil {
+		res = append(res, err)
+	}
+
+	if err := m.validateVsockID(formats); err != nil {
 		res = append(res, err)
 	}
 
@@ -58,9 +66,18 @@ func (m *Vsock) validateGuestCid(formats strfmt.Registry) error {
 	return nil
 }
 
-func (m *Vsock) validateID(formats strfmt.Registry) error {
+func (m *Vsock) validateUdsPath(formats strfmt.Registry) error {
+
+	if err := validate.Required("uds_path", "body", m.UdsPath); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *Vsock) validateVsockID(formats strfmt.Registry) error {
 
-	if err := validate.Required("id", "body", m.ID); err != nil {
+	if err := validate.Required("vsock_id", "body", m.VsockID); err != nil {
 		return err
 	}
 
@@ -0,0 +1,176 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+// Package hvsock dials the vsock ports of a guest through the unix socket
+// its hypervisor forwards the connections from, as Firecracker does. Once
+// connected to the unix socket, the host asks for the guest port with
+// "CONNECT <port>\n", and the hypervisor answers "OK <host port>\n" once
+// the guest accepted the connection.
+package hvsock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
+	agentgrpc "github.com/kata-containers/agent/protocols/grpc"
+	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+)
+
+// Scheme is the scheme of the hybrid vsock URLs,
+// hvsock://<unix socket path>:<port>.
+const Scheme = "hvsock"
+
+// maxReplySize bounds the reply of the hypervisor to CONNECT.
+const maxReplySize = 64
+
+// defaultDialTimeout is the timeout of the connections to the agent, when
+// the context has no deadline.
+var defaultDialTimeout = 15 * time.Second
+
+// URL returns the hybrid vsock URL of the port of the guest reached
+// through the unix socket udsPath.
+func URL(udsPath string, port uint32) string {
+	return fmt.Sprintf("%s://%s:%d", Scheme, udsPath, port)
+}
+
+// Parse returns the unix socket and the port of the hybrid vsock URL s.
+func Parse(s string) (string, uint32, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", 0, err
+	}
+
+	i := strings.LastIndex(u.Path, ":")
+	if u.Scheme != Scheme || u.Host != "" || i <= 0 {
+		return "", 0, fmt.Errorf("Invalid hybrid vsock URL %q", s)
+	}
+
+	port, err := strconv.ParseUint(u.Path[i+1:], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid hybrid vsock port in %q: %v", s, err)
+	}
+
+	return u.Path[:i], uint32(port), nil
+}
+
+// Dial connects to the port of the guest through the unix socket udsPath.
+func Dial(udsPath string, port uint32, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", udsPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := connect(conn, port, timeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func connect(conn net.Conn, port uint32, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		return err
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(reply, "OK ") {
+		return fmt.Errorf("Unexpected hybrid vsock reply %q", reply)
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// readReply reads the reply line of the hypervisor one byte at a time, not
+// to consume the data the guest sends once connected.
+func readReply(conn net.Conn) (string, error) {
+	var reply []byte
+	b := make([]byte, 1)
+
+	for len(reply) < maxReplySize {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+
+		if b[0] == '\n' {
+			return string(reply), nil
+		}
+		reply = append(reply, b[0])
+	}
+
+	return "", fmt.Errorf("Hybrid vsock reply too long: %q", reply)
+}
+
+// AgentClient is a gRPC client of the agent listening on a hybrid vsock
+// port, the counterpart of the agent client for the vsock and unix URLs.
+type AgentClient struct {
+	agentgrpc.AgentServiceClient
+	agentgrpc.HealthClient
+	conn *grpc.ClientConn
+}
+
+// NewAgentClient connects to the agent listening on the hybrid vsock URL
+// agentURL.
+func NewAgentClient(ctx context.Context, agentURL string) (*AgentClient, error) {
+	udsPath, port, err := Parse(agentURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return Dial(udsPath, port, defaultDialTimeout)
+		}),
+	}
+
+	// If the context contains a trace span, trace all client comms
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		tracer := span.Tracer()
+
+		dialOpts = append(dialOpts,
+			grpc.WithUnaryInterceptor(otgrpc.OpenTracingClientInterceptor(tracer)),
+			grpc.WithStreamInterceptor(otgrpc.OpenTracingStreamClientInterceptor(tracer)))
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+	}
+
+	// The address is only used by the dialer.
+	conn, err := grpc.DialContext(ctx, "passthrough:///"+Scheme, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AgentClient{
+		AgentServiceClient: agentgrpc.NewAgentServiceClient(conn),
+		HealthClient:       agentgrpc.NewHealthClient(conn),
+		conn:               conn,
+	}, nil
+}
+
+// Close closes the connection to the agent.
+func (c *AgentClient) Close() error {
+	return c.conn.Close()
+}
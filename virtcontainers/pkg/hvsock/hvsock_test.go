@@ -0,0 +1,173 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package hvsock
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	agentgrpc "github.com/kata-containers/agent/protocols/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	udsPath, port, err := Parse(URL("/run/kata.hvsock", 1024))
+	assert.NoError(err)
+	assert.Equal("/run/kata.hvsock", udsPath)
+	assert.Equal(uint32(1024), port)
+
+	for _, s := range []string{
+		"vsock://3:1024",
+		"hvsock://host/run/kata.hvsock:1024",
+		"hvsock:///run/kata.hvsock",
+		"hvsock://:1024",
+		"hvsock:///run/kata.hvsock:port",
+		"hvsock:///run/kata.hvsock:4294967296",
+	} {
+		_, _, err := Parse(s)
+		assert.Error(err, s)
+	}
+}
+
+// fakeHypervisor forwards the connections of the unix socket l to the guest
+// port once acknowledged, the guest writing greeting.
+func fakeHypervisor(l net.Listener, port string, greeting string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			buf := make([]byte, len("CONNECT \n")+len(port))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+
+			if string(buf) != "CONNECT "+port+"\n" {
+				conn.Write([]byte("ERR unknown port\n"))
+				return
+			}
+			conn.Write([]byte("OK 1073741824\n" + greeting))
+		}()
+	}
+}
+
+func TestDial(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hvsock")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	udsPath := filepath.Join(dir, "kata.hvsock")
+	_, err = Dial(udsPath, 1024, time.Second)
+	assert.Error(err)
+
+	l, err := net.Listen("unix", udsPath)
+	assert.NoError(err)
+	defer l.Close()
+
+	go fakeHypervisor(l, "1024", "agent")
+
+	conn, err := Dial(udsPath, 1024, time.Second)
+	assert.NoError(err)
+	defer conn.Close()
+
+	// the reply of the hypervisor is not mixed with the guest data
+	buf := make([]byte, len("agent"))
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(err)
+	assert.Equal("agent", string(buf))
+
+	_, err = Dial(udsPath, 1025, time.Second)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "ERR unknown port"))
+}
+
+type healthServer struct{}
+
+func (healthServer) Check(context.Context, *agentgrpc.CheckRequest) (*agentgrpc.HealthCheckResponse, error) {
+	return &agentgrpc.HealthCheckResponse{Status: agentgrpc.HealthCheckResponse_SERVING}, nil
+}
+
+func (healthServer) Version(context.Context, *agentgrpc.CheckRequest) (*agentgrpc.VersionCheckResponse, error) {
+	return &agentgrpc.VersionCheckResponse{}, nil
+}
+
+func TestNewAgentClient(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hvsock")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the agent
+	agentPath := filepath.Join(dir, "agent.sock")
+	agentListener, err := net.Listen("unix", agentPath)
+	assert.NoError(err)
+
+	server := grpc.NewServer()
+	agentgrpc.RegisterHealthServer(server, healthServer{})
+	go server.Serve(agentListener)
+	defer server.Stop()
+
+	// the hypervisor forwarding the connections to the agent
+	udsPath := filepath.Join(dir, "kata.hvsock")
+	l, err := net.Listen("unix", udsPath)
+	assert.NoError(err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			buf := make([]byte, len("CONNECT 1024\n"))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				conn.Close()
+				continue
+			}
+			conn.Write([]byte("OK 1073741824\n"))
+
+			agent, err := net.Dial("unix", agentPath)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go io.Copy(agent, conn)
+			go io.Copy(conn, agent)
+		}
+	}()
+
+	_, err = NewAgentClient(context.Background(), "vsock://3:1024")
+	assert.Error(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := NewAgentClient(ctx, URL(udsPath, 1024))
+	assert.NoError(err)
+	defer c.Close()
+
+	resp, err := c.Check(ctx, &agentgrpc.CheckRequest{})
+	assert.NoError(err)
+	assert.Equal(agentgrpc.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -7,6 +7,7 @@ package oci
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 
 	criContainerdAnnotations "github.com/containerd/cri-containerd/pkg/annotations"
 	crioAnnotations "github.com/cri-o/cri-o/pkg/annotations"
+	"github.com/docker/go-units"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 
@@ -25,6 +27,7 @@ import (
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	dockershimAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations/dockershim"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 )
 
 type annotationContainerType struct {
@@ -73,6 +76,10 @@ const (
 
 const KernelModulesSeparator = ";"
 
+// VirtioFSVolumesSeparator separates the entries of the virtio-fs volumes
+// annotation.
+const VirtioFSVolumesSeparator = ";"
+
 // FactoryConfig is a structure to set the VM factory configuration.
 type FactoryConfig struct {
 	// Template enables VM templating support in VM factory.
@@ -119,11 +126,93 @@ type RuntimeConfig struct {
 	//Determines if create a netns for hypervisor process
 	DisableNewNetNs bool
 
+	//Determines how the MAC addresses of the network interfaces are allocated
+	MacAddressPolicy vc.MacAddressPolicy
+
+	//Determines if the runtime limits the pod bandwidth on the host
+	EnablePodBandwidth bool
+
+	//Path of the forwarder of the network interfaces using AF_XDP sockets
+	XDPForwarderPath string
+
+	//Directory of the unix sockets passing the file descriptors of
+	//existing TAP devices
+	TapFdSocketsDir string
+
+	//Settings programmed on the physical function of the SR-IOV virtual
+	//function network interfaces
+	SRIOVInterfaces map[string]vc.SRIOVConfig
+
 	//Determines kata processes are managed only in sandbox cgroup
 	SandboxCgroupOnly bool
 
+	//Maximum number of exec processes running concurrently in a sandbox
+	ExecLimit uint32
+
+	//Topics of the task events the shim does not publish
+	SuppressedEvents []string
+
+	//Topics of the task events the shim only publishes one in
+	//EventSampleRate of
+	SampledEvents   []string
+	EventSampleRate uint32
+
+	//Size in megabytes of the block devices backing the disk-backed
+	//emptyDir volumes in the guest
+	EmptyDirBlockSize uint32
+
+	//Size in megabytes of the scratch disks of the rootfs layered on
+	//read-only images
+	RootfsScratchSize uint32
+
+	//Integrity algorithm of the dm-integrity target of the scratch disks
+	ScratchIntegrity string
+
+	//Executable returning the keys of the encrypted rootfs
+	LUKSKeyProvider string
+
+	//Directory of the LUKS keys given by their name
+	LUKSKeyDir string
+
+	//Size in megabytes of the tmpfs holding the temporary files of a
+	//sandbox on the host
+	SandboxTmpQuota uint32
+
+	//Time in milliseconds the pty resizes of a process are coalesced over
+	ResizePtyDebounce uint32
+
+	//Time in seconds the containers of a sandbox being torn down are given
+	//to exit once signalled with SIGTERM, before they are killed
+	SandboxDrainTimeout uint32
+
+	//Determines the shim reports the whole host footprint of the VM,
+	//virtiofsd and the shim in separate metrics
+	SandboxOverheadMetrics bool
+
+	//Determines the introspection socket of the shim serves the debug
+	//state of the sandbox
+	EnableDebugIntrospection bool
+
+	//Determines the introspection socket of the shim serves its metrics
+	EnableMetrics bool
+
+	//Determines the QMP commands, the hotplug operations and the agent
+	//RPCs of the sandbox are recorded in its audit log
+	EnableAuditLog bool
+
+	//Directory of the audit logs and size in megabytes above which they
+	//are rotated
+	AuditLogDir     string
+	AuditLogMaxSize uint32
+
 	//Experimental features enabled
 	Experimental []exp.Feature
+
+	//Autoscale controller settings, used with the autoscale experimental feature
+	AutoscaleConfig vc.AutoscaleConfig
+
+	//Policy deciding the vCPUs and memory of the sandboxes
+	SizingConfig vc.SizingConfig
 }
 
 // AddKernelParam allows the addition of new kernel parameters to an existing
@@ -137,6 +226,8 @@ var ociLog = logrus.WithFields(logrus.Fields{
 	"subsystem": "oci",
 })
 
+var hostMemoryMBFn = vc.HostMemoryMB
+
 // SetLogger sets the logger for oci package.
 func SetLogger(ctx context.Context, logger *logrus.Entry) {
 	fields := ociLog.Data
@@ -264,16 +355,204 @@ func networkConfig(ocispec specs.Spec, config RuntimeConfig) (vc.NetworkConfig,
 	}
 	netConf.InterworkingModel = config.InterNetworkModel
 	netConf.DisableNewNetNs = config.DisableNewNetNs
+	netConf.MacAddressPolicy = config.MacAddressPolicy
 
 	netConf.NetmonConfig = vc.NetmonConfig{
-		Path:   config.NetmonConfig.Path,
-		Debug:  config.NetmonConfig.Debug,
-		Enable: config.NetmonConfig.Enable,
+		Path:      config.NetmonConfig.Path,
+		Debug:     config.NetmonConfig.Debug,
+		Enable:    config.NetmonConfig.Enable,
+		InProcess: config.NetmonConfig.InProcess,
+	}
+
+	xdpInterfaces, err := xdpInterfaces(ocispec)
+	if err != nil {
+		return vc.NetworkConfig{}, err
+	}
+	netConf.XDP = vc.XDPConfig{
+		ForwarderPath: config.XDPForwarderPath,
+		Interfaces:    xdpInterfaces,
+	}
+
+	netConf.SRIOV = config.SRIOVInterfaces
+
+	tapFdSockets, err := tapFdSockets(ocispec, config.TapFdSocketsDir)
+	if err != nil {
+		return vc.NetworkConfig{}, err
+	}
+	netConf.TapFdSockets = tapFdSockets
+
+	if config.EnablePodBandwidth {
+		bandwidth, err := sandboxBandwidth(ocispec)
+		if err != nil {
+			return vc.NetworkConfig{}, err
+		}
+		netConf.EnableBandwidth = true
+		netConf.Bandwidth = bandwidth
 	}
 
 	return netConf, nil
 }
 
+// xdpInterfaces returns the network interfaces forwarded through AF_XDP
+// sockets and their queue, as declared by the sandbox annotations.
+func xdpInterfaces(ocispec specs.Spec) (map[string]uint32, error) {
+	value, ok := ocispec.Annotations[vcAnnotations.XDPInterfaces]
+	if !ok {
+		return nil, nil
+	}
+
+	interfaces := make(map[string]uint32)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if fields[0] == "" {
+			return nil, fmt.Errorf("Invalid AF_XDP interface %q, expecting interface[=queue]", entry)
+		}
+
+		var queue uint64
+		if len(fields) == 2 {
+			var err error
+			if queue, err = strconv.ParseUint(fields[1], 10, 32); err != nil {
+				return nil, fmt.Errorf("Invalid AF_XDP interface %q, expecting interface[=queue]", entry)
+			}
+		}
+
+		interfaces[fields[0]] = uint32(queue)
+	}
+
+	return interfaces, nil
+}
+
+// tapFdSockets returns the unix sockets the file descriptors of the existing
+// TAP devices are passed over, as declared by the sandbox annotations. The
+// sockets, once their symbolic links are resolved, must lie in dir, the
+// directory the operator lets the device plugins create them in, so that a
+// pod cannot make the runtime connect to any host socket.
+func tapFdSockets(ocispec specs.Spec, dir string) (map[string]string, error) {
+	value, ok := ocispec.Annotations[vcAnnotations.TapFdSockets]
+	if !ok {
+		return nil, nil
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("TAP fd sockets not allowed, tap_fd_sockets_dir is not configured")
+	}
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TAP fd sockets directory: %v", err)
+	}
+
+	sockets := make(map[string]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || fields[0] == "" || !filepath.IsAbs(fields[1]) {
+			return nil, fmt.Errorf("Invalid TAP fd socket %q, expecting interface=/path/to/socket", entry)
+		}
+
+		path, err := filepath.EvalSymlinks(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TAP fd socket %q: %v", entry, err)
+		}
+
+		if filepath.Dir(path) != dir {
+			return nil, fmt.Errorf("TAP fd socket %q not in %s", entry, dir)
+		}
+
+		sockets[fields[0]] = path
+	}
+
+	return sockets, nil
+}
+
+// sandboxBandwidth returns the rates the traffic of the pod is limited to, as
+// declared by its Kubernetes bandwidth annotations. containerd passes them
+// as is when allowed, while CRI-O passes them in the annotations annotation.
+func sandboxBandwidth(ocispec specs.Spec) (vc.BandwidthConfig, error) {
+	annotations := ocispec.Annotations
+
+	if value, ok := ocispec.Annotations[crioAnnotations.Annotations]; ok {
+		var kubeAnnotations map[string]string
+		if err := json.Unmarshal([]byte(value), &kubeAnnotations); err != nil {
+			ociLog.WithError(err).Warn("Could not parse the CRI-O annotations")
+		} else {
+			annotations = kubeAnnotations
+		}
+	}
+
+	var bw vc.BandwidthConfig
+	for key, rate := range map[string]*uint64{
+		kubernetesIngressBandwidth: &bw.IngressRate,
+		kubernetesEgressBandwidth:  &bw.EgressRate,
+	} {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		r, err := parseBandwidth(value)
+		if err != nil {
+			return vc.BandwidthConfig{}, fmt.Errorf("Invalid %s annotation %q: %v", key, value, err)
+		}
+		*rate = r
+	}
+
+	return bw, nil
+}
+
+// bandwidthSuffixes are the suffixes of the Kubernetes resource quantities.
+var bandwidthSuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"Pi", 1 << 50},
+	{"k", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"P", 1e15},
+}
+
+// parseBandwidth parses a rate in bits per second, written as a Kubernetes
+// resource quantity such as "10M" or "512Ki". Like the kubelet, it rejects
+// the rates below 1k or above 1P.
+func parseBandwidth(value string) (uint64, error) {
+	number := strings.TrimSpace(value)
+	multiplier := 1.0
+	for _, s := range bandwidthSuffixes {
+		if strings.HasSuffix(number, s.suffix) {
+			number = strings.TrimSuffix(number, s.suffix)
+			multiplier = s.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	rate := n * multiplier
+	if rate < 1e3 || rate > 1e15 {
+		return 0, fmt.Errorf("rate must be between 1k and 1P")
+	}
+
+	return uint64(rate), nil
+}
+
 // GetContainerType determines which type of container matches the annotations
 // table provided.
 func GetContainerType(annotations map[string]string) (vc.ContainerType, error) {
@@ -308,6 +587,61 @@ func ContainerType(spec specs.Spec) (vc.ContainerType, error) {
 	return vc.PodSandbox, nil
 }
 
+// The CRI annotations of the pod metadata, which the vendored containerd
+// cri does not define.
+const (
+	criSandboxNamespace = "io.kubernetes.cri.sandbox-namespace"
+	criSandboxName      = "io.kubernetes.cri.sandbox-name"
+	criSandboxUID       = "io.kubernetes.cri.sandbox-uid"
+)
+
+// The Kubernetes labels of the pod metadata, which CRI-O passes in the
+// labels annotation.
+const (
+	kubernetesPodNamespace = "io.kubernetes.pod.namespace"
+	kubernetesPodName      = "io.kubernetes.pod.name"
+	kubernetesPodUID       = "io.kubernetes.pod.uid"
+)
+
+// The Kubernetes annotations of the pod bandwidth, in bits per second.
+const (
+	kubernetesIngressBandwidth = "kubernetes.io/ingress-bandwidth"
+	kubernetesEgressBandwidth  = "kubernetes.io/egress-bandwidth"
+)
+
+// sandboxLabels returns the labels identifying the pod the sandbox runs,
+// from the annotations of the CRI implementation.
+func sandboxLabels(ocispec specs.Spec) vc.SandboxLabels {
+	labels := vc.SandboxLabels{
+		Namespace: ocispec.Annotations[criSandboxNamespace],
+		Name:      ocispec.Annotations[criSandboxName],
+		UID:       ocispec.Annotations[criSandboxUID],
+	}
+
+	if value, ok := ocispec.Annotations[crioAnnotations.Labels]; ok {
+		var kubeLabels map[string]string
+		if err := json.Unmarshal([]byte(value), &kubeLabels); err != nil {
+			ociLog.WithError(err).Warn("Could not parse the CRI-O labels")
+			return labels
+		}
+
+		for _, l := range []struct {
+			key   string
+			value *string
+		}{
+			{kubernetesPodNamespace, &labels.Namespace},
+			{kubernetesPodName, &labels.Name},
+			{kubernetesPodUID, &labels.UID},
+		} {
+			if *l.value == "" {
+				*l.value = kubeLabels[l.key]
+			}
+		}
+	}
+
+	return labels
+}
+
 // SandboxID determines the sandbox ID related to an OCI configuration. This function
 // is expected to be called only when the container type is "PodContainer".
 func SandboxID(spec specs.Spec) (string, error) {
@@ -321,7 +655,307 @@ func SandboxID(spec specs.Spec) (string, error) {
 	return "", fmt.Errorf("Could not find sandbox ID")
 }
 
-func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) {
+// addVirtioFSVolumes adds the virtio-fs volumes declared by the sandbox
+// annotations to the hypervisor configuration.
+func addVirtioFSVolumes(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	value, ok := ocispec.Annotations[vcAnnotations.VirtioFSVolumes]
+	if !ok {
+		return nil
+	}
+
+	var volumes []types.Volume
+	for _, entry := range strings.Split(value, VirtioFSVolumesSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("Invalid virtio-fs volume %q, expecting tag=/host/path", entry)
+		}
+
+		// The volume is checked against the allowed paths once its
+		// symbolic links are resolved, not to escape them through a link.
+		hostPath, err := filepath.EvalSymlinks(fields[1])
+		if err != nil {
+			return fmt.Errorf("Invalid virtio-fs volume %q: %v", entry, err)
+		}
+
+		volumes = append(volumes, types.Volume{
+			MountTag: fields[0],
+			HostPath: hostPath,
+		})
+	}
+
+	config.HypervisorConfig.VirtioFSVolumes = volumes
+
+	return nil
+}
+
+// addVCPUPinning enables the vCPU pinning to the host CPUs declared by the
+// sandbox annotations.
+func addVCPUPinning(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	value, ok := ocispec.Annotations[vcAnnotations.VCPUCPUSet]
+	if !ok {
+		return nil
+	}
+
+	cpus, err := utils.ParseCPUSet(value)
+	if err != nil {
+		return err
+	}
+
+	if len(cpus) == 0 {
+		return fmt.Errorf("Invalid vCPU cpuset %q, expecting at least one CPU", value)
+	}
+
+	config.HypervisorConfig.PinVCPUs = true
+	config.HypervisorConfig.VCPUCPUSet = strings.TrimSpace(value)
+
+	return nil
+}
+
+// addHugePages backs the guest memory with the huge pages declared by the
+// sandbox annotations.
+func addHugePages(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	if value, ok := ocispec.Annotations[vcAnnotations.HugePageSize]; ok {
+		size, err := vc.ParseHugePageSize(value)
+		if err != nil {
+			return err
+		}
+
+		config.HypervisorConfig.HugePages = true
+		config.HypervisorConfig.HugePageSizeKB = size
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.HugePagesPath]; ok {
+		path := strings.TrimSpace(value)
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("Invalid huge pages path %q, expecting an absolute path", value)
+		}
+
+		config.HypervisorConfig.HugePages = true
+		config.HypervisorConfig.HugePagesPath = path
+	}
+
+	return nil
+}
+
+// addSGXEPC exposes the SGX enclave page cache section declared by the
+// sandbox annotations to the guest.
+func addSGXEPC(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	value, ok := ocispec.Annotations[vcAnnotations.SGXEPC]
+	if !ok {
+		return nil
+	}
+
+	size, err := units.RAMInBytes(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("Invalid SGX EPC size %q: %v", value, err)
+	}
+
+	if size <= 0 {
+		return fmt.Errorf("Invalid SGX EPC size %q, expecting a positive size", value)
+	}
+
+	config.HypervisorConfig.SGXEPCSize = size
+
+	return nil
+}
+
+// hypervisorAnnotation is a sandbox annotation overriding the hypervisor
+// configuration option of the same name in the configuration file.
+type hypervisorAnnotation struct {
+	option     string
+	annotation string
+	apply      func(config *vc.HypervisorConfig, value string) error
+}
+
+var hypervisorAnnotations = []hypervisorAnnotation{
+	{"default_memory", vcAnnotations.DefaultMemory, func(config *vc.HypervisorConfig, value string) error {
+		memory, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || memory == 0 {
+			return fmt.Errorf("expecting a memory size in MiB")
+		}
+		hostMemory, err := hostMemoryMBFn()
+		if err != nil {
+			return err
+		}
+		if memory > hostMemory {
+			return fmt.Errorf("the VM cannot have more memory than the host, %d MiB", hostMemory)
+		}
+		config.MemorySize = uint32(memory)
+		return nil
+	}},
+	{"default_vcpus", vcAnnotations.DefaultVCPUs, func(config *vc.HypervisorConfig, value string) error {
+		vcpus, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || vcpus == 0 {
+			return fmt.Errorf("expecting a number of vCPUs")
+		}
+		if config.DefaultMaxVCPUs != 0 && uint32(vcpus) > config.DefaultMaxVCPUs {
+			return fmt.Errorf("the VM cannot have more than %d vCPUs", config.DefaultMaxVCPUs)
+		}
+		config.NumVCPUs = uint32(vcpus)
+		return nil
+	}},
+	{"kernel_params", vcAnnotations.KernelParams, func(config *vc.HypervisorConfig, value string) error {
+		params := vc.DeserializeParams(strings.Fields(value))
+		if len(params) == 0 {
+			return fmt.Errorf("expecting kernel parameters")
+		}
+		for _, p := range params {
+			if p.Key == "" {
+				return fmt.Errorf("expecting key=value kernel parameters")
+			}
+		}
+		// The parameters of the runtime configuration are shared by the
+		// sandboxes created by the shim.
+		config.KernelParams = append(append([]vc.Param{}, config.KernelParams...), params...)
+		return nil
+	}},
+	{"machine_type", vcAnnotations.MachineType, func(config *vc.HypervisorConfig, value string) error {
+		if value == "" {
+			return fmt.Errorf("expecting a machine type")
+		}
+		config.HypervisorMachineType = value
+		return nil
+	}},
+	{"virtio_fs_cache", vcAnnotations.VirtioFSCache, func(config *vc.HypervisorConfig, value string) error {
+		switch value {
+		case "none", "auto", "always":
+		default:
+			return fmt.Errorf("expecting %q, %q or %q", "none", "auto", "always")
+		}
+		config.VirtioFSCache = value
+		return nil
+	}},
+}
+
+// featureAnnotations are the sandbox and container annotations which do not
+// override a hypervisor configuration option, and the option of
+// enable_annotations allowing them. The KernelModules annotation is not
+// gated, the modules it loads are checked against the allowlist of the
+// agent instead.
+var featureAnnotations = []struct {
+	option     string
+	annotation string
+}{
+	{"virtio_fs_volumes", vcAnnotations.VirtioFSVolumes},
+	{"vcpu_cpuset", vcAnnotations.VCPUCPUSet},
+	{"hugepage_size", vcAnnotations.HugePageSize},
+	{"hugepages_path", vcAnnotations.HugePagesPath},
+	{"sgx_epc", vcAnnotations.SGXEPC},
+	{"xdp_interfaces", vcAnnotations.XDPInterfaces},
+	{"tap_fd_sockets", vcAnnotations.TapFdSockets},
+	{"hotplug_reservation", vcAnnotations.HotplugReservation},
+	{"rootfs_luks", vcAnnotations.RootfsLUKSKeyName},
+	{"rootfs_luks", vcAnnotations.RootfsLUKSKeyID},
+	{"rootfs_luks", vcAnnotations.RootfsLUKSFstype},
+}
+
+// maxAnnotationSize bounds the values of the annotations enabled by
+// enable_annotations.
+const maxAnnotationSize = 4096
+
+// IsHypervisorAnnotationOption returns whether the option can be listed by
+// enable_annotations, to allow the sandbox annotations overriding it or the
+// annotations of the feature it names.
+func IsHypervisorAnnotationOption(option string) bool {
+	for _, a := range hypervisorAnnotations {
+		if a.option == option {
+			return true
+		}
+	}
+
+	for _, a := range featureAnnotations {
+		if a.option == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAnnotation returns an error if the annotation is not enabled by the
+// option of enable_annotations, or if its value is too large.
+func checkAnnotation(enabled []string, option, annotation, value string) error {
+	allowed := false
+	for _, o := range enabled {
+		if o == option {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return fmt.Errorf("Annotation %s is not allowed, %s is not listed by enable_annotations", annotation, option)
+	}
+
+	if len(value) > maxAnnotationSize {
+		return fmt.Errorf("Annotation %s is too large, %d bytes above %d", annotation, len(value), maxAnnotationSize)
+	}
+
+	return nil
+}
+
+// CheckAnnotations returns an error if the spec of a sandbox or a container
+// holds an annotation of a feature which is not enabled by the
+// enable_annotations of the hypervisor configuration.
+func CheckAnnotations(ocispec specs.Spec, config vc.HypervisorConfig) error {
+	for _, a := range featureAnnotations {
+		value, ok := ocispec.Annotations[a.annotation]
+		if !ok {
+			continue
+		}
+
+		if err := checkAnnotation(config.EnableAnnotations, a.option, a.annotation, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addHypervisorAnnotations overrides the hypervisor configuration with the
+// sandbox annotations enabled by the runtime configuration.
+func addHypervisorAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	for _, a := range hypervisorAnnotations {
+		value, ok := ocispec.Annotations[a.annotation]
+		if !ok {
+			continue
+		}
+
+		if err := checkAnnotation(config.HypervisorConfig.EnableAnnotations, a.option, a.annotation, value); err != nil {
+			return err
+		}
+
+		value = strings.TrimSpace(value)
+		if err := a.apply(&config.HypervisorConfig, value); err != nil {
+			return fmt.Errorf("Invalid %s annotation %q: %v", a.annotation, value, err)
+		}
+
+		ociLog.WithFields(logrus.Fields{
+			"annotation": a.annotation,
+			"option":     a.option,
+			"value":      value,
+		}).Info("Overriding the hypervisor configuration")
+	}
+
+	return nil
+}
+
+// addConfigAnnotations keeps track of the runtime configuration used to
+// create the sandbox.
+func addConfigAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) {
+	for _, a := range []string{vcAnnotations.ConfigPathKey, vcAnnotations.ConfigSourceKey} {
+		if value, ok := ocispec.Annotations[a]; ok {
+			config.Annotations[a] = value
+		}
+	}
+}
+
+func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) error {
 	assetAnnotations := []string{
 		vcAnnotations.KernelPath,
 		vcAnnotations.ImagePath,
@@ -343,15 +977,26 @@ func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) {
 	if value, ok := ocispec.Annotations[vcAnnotations.KernelModules]; ok {
 		if c, ok := config.AgentConfig.(vc.KataAgentConfig); ok {
 			modules := strings.Split(value, KernelModulesSeparator)
+			// The modules of the annotation, unlike the ones of the
+			// configuration, must be listed by the allowlist.
+			if err := vc.CheckKernelModulesAllowed(modules, c.KernelModulesAllowlist); err != nil {
+				return fmt.Errorf("Invalid %s annotation: %v", vcAnnotations.KernelModules, err)
+			}
 			c.KernelModules = modules
 			config.AgentConfig = c
 		}
 	}
+
+	return nil
 }
 
 // SandboxConfig converts an OCI compatible runtime configuration file
 // to a virtcontainers sandbox configuration structure.
 func SandboxConfig(ocispec specs.Spec, runtime RuntimeConfig, bundlePath, cid, console string, detach, systemdCgroup bool) (vc.SandboxConfig, error) {
+	if err := CheckAnnotations(ocispec, runtime.HypervisorConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
 	containerConfig, err := ContainerConfig(ocispec, bundlePath, cid, console, detach)
 	if err != nil {
 		return vc.SandboxConfig{}, err
@@ -400,13 +1045,58 @@ func SandboxConfig(ocispec specs.Spec, runtime RuntimeConfig, bundlePath, cid, c
 
 		DisableGuestSeccomp: runtime.DisableGuestSeccomp,
 
+		EmptyDirBlockSize: runtime.EmptyDirBlockSize,
+
+		RootfsScratchSize: runtime.RootfsScratchSize,
+
+		ScratchIntegrity: runtime.ScratchIntegrity,
+
+		LUKSKeyProvider: runtime.LUKSKeyProvider,
+		LUKSKeyDir:      runtime.LUKSKeyDir,
+
+		TmpQuota: runtime.SandboxTmpQuota,
+
+		AuditLog:           runtime.EnableAuditLog,
+		AuditLogDir:        runtime.AuditLogDir,
+		AuditLogMaxSizeMiB: runtime.AuditLogMaxSize,
+
+		Labels: sandboxLabels(ocispec),
+
 		// Q: Is this really necessary? @weizhang555
 		// Spec: &ocispec,
 
 		Experimental: runtime.Experimental,
+
+		AutoscaleConfig: runtime.AutoscaleConfig,
+
+		SizingConfig: runtime.SizingConfig,
+	}
+
+	if err := addAssetAnnotations(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
 	}
 
-	addAssetAnnotations(ocispec, &sandboxConfig)
+	addConfigAnnotations(ocispec, &sandboxConfig)
+
+	if err := addHypervisorAnnotations(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := addVirtioFSVolumes(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := addVCPUPinning(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := addHugePages(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := addSGXEPC(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
 
 	return sandboxConfig, nil
 }
@@ -455,10 +1145,16 @@ func ContainerConfig(ocispec specs.Spec, bundlePath, cid, console string, detach
 		Annotations: map[string]string{
 			vcAnnotations.BundlePathKey: bundlePath,
 		},
-		Mounts:      containerMounts(ocispec),
-		DeviceInfos: deviceInfos,
-		Resources:   *ocispec.Linux.Resources,
-		Spec:        &ocispec,
+		Mounts:             containerMounts(ocispec),
+		DeviceInfos:        deviceInfos,
+		Resources:          *ocispec.Linux.Resources,
+		HotplugReservation: ocispec.Annotations[vcAnnotations.HotplugReservation],
+		RootfsEncryption: vc.RootfsEncryption{
+			KeyName: ocispec.Annotations[vcAnnotations.RootfsLUKSKeyName],
+			KeyID:   ocispec.Annotations[vcAnnotations.RootfsLUKSKeyID],
+			Fstype:  ocispec.Annotations[vcAnnotations.RootfsLUKSFstype],
+		},
+		Spec: &ocispec,
 	}
 
 	cType, err := ContainerType(ocispec)
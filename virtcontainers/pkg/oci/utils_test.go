@@ -26,6 +26,7 @@ import (
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 )
 
 const (
@@ -666,7 +667,7 @@ func TestAddAssetAnnotations(t *testing.T) {
 		Annotations: expectedAnnotations,
 	}
 
-	addAssetAnnotations(ocispec, &config)
+	assert.NoError(addAssetAnnotations(ocispec, &config))
 	assert.Exactly(expectedAnnotations, config.Annotations)
 
 	expectedAgentConfig := vc.KataAgentConfig{
@@ -674,10 +675,462 @@ func TestAddAssetAnnotations(t *testing.T) {
 			"e1000e InterruptThrottleRate=3000,3000,3000 EEE=1",
 			"i915 enable_ppgtt=0",
 		},
+		KernelModulesAllowlist: []string{"e1000e", "i915"},
 	}
 
+	config.AgentConfig = vc.KataAgentConfig{
+		KernelModulesAllowlist: expectedAgentConfig.KernelModulesAllowlist,
+	}
 	ocispec.Annotations[vcAnnotations.KernelModules] = strings.Join(expectedAgentConfig.KernelModules, KernelModulesSeparator)
-	addAssetAnnotations(ocispec, &config)
+	assert.NoError(addAssetAnnotations(ocispec, &config))
 	assert.Exactly(expectedAgentConfig, config.AgentConfig)
 
 }
+
+func TestAddAssetAnnotationsKernelModulesAllowlist(t *testing.T) {
+	assert := assert.New(t)
+
+	// The modules of the configuration are kept with an empty allowlist.
+	agentConfig := vc.KataAgentConfig{
+		KernelModules: []string{"nbd nbds_max=32"},
+	}
+	config := vc.SandboxConfig{
+		Annotations: make(map[string]string),
+		AgentConfig: agentConfig,
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{},
+	}
+
+	assert.NoError(addAssetAnnotations(ocispec, &config))
+	assert.Exactly(agentConfig, config.AgentConfig)
+
+	// The annotation cannot load any module with an empty allowlist.
+	ocispec.Annotations[vcAnnotations.KernelModules] = "nbd nbds_max=32"
+	assert.Error(addAssetAnnotations(ocispec, &config))
+	assert.Exactly(agentConfig, config.AgentConfig)
+
+	// Nor a module not listed by the allowlist.
+	agentConfig.KernelModulesAllowlist = []string{"ip_vs"}
+	config.AgentConfig = agentConfig
+	assert.Error(addAssetAnnotations(ocispec, &config))
+	assert.Exactly(agentConfig, config.AgentConfig)
+}
+
+func TestAddConfigAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	expectedAnnotations := map[string]string{
+		vcAnnotations.ConfigPathKey:   "/etc/kata-containers/namespaces/test/configuration.toml",
+		vcAnnotations.ConfigSourceKey: "namespace",
+	}
+
+	config := vc.SandboxConfig{
+		Annotations: make(map[string]string),
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.ConfigPathKey:   expectedAnnotations[vcAnnotations.ConfigPathKey],
+			vcAnnotations.ConfigSourceKey: expectedAnnotations[vcAnnotations.ConfigSourceKey],
+			"foo":                         "bar",
+		},
+	}
+
+	addConfigAnnotations(ocispec, &config)
+	assert.Exactly(expectedAnnotations, config.Annotations)
+}
+
+func TestAddVirtioFSVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	config := vc.SandboxConfig{}
+	ocispec := specs.Spec{}
+
+	assert.NoError(addVirtioFSVolumes(ocispec, &config))
+	assert.Empty(config.HypervisorConfig.VirtioFSVolumes)
+
+	dir, err := ioutil.TempDir("", "virtiofs-volumes")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	data := filepath.Join(dir, "data")
+	models := filepath.Join(dir, "models")
+	assert.NoError(os.Mkdir(data, 0700))
+	assert.NoError(os.Symlink(data, models))
+
+	// The symbolic links are resolved.
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.VirtioFSVolumes: fmt.Sprintf("data=%s; models=%s/;", data, models),
+	}
+	assert.NoError(addVirtioFSVolumes(ocispec, &config))
+	assert.Equal([]types.Volume{
+		{MountTag: "data", HostPath: data},
+		{MountTag: "models", HostPath: data},
+	}, config.HypervisorConfig.VirtioFSVolumes)
+
+	for _, value := range []string{"data", "data=", "=" + data, "data=" + data + ";models", "data=" + filepath.Join(dir, "missing")} {
+		ocispec.Annotations[vcAnnotations.VirtioFSVolumes] = value
+		assert.Error(addVirtioFSVolumes(ocispec, &config), "annotation %q", value)
+	}
+}
+
+func TestAddVCPUPinning(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "vcpu-pinning")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPath := utils.PossibleCPUsPath
+	utils.PossibleCPUsPath = filepath.Join(dir, "possible")
+	defer func() {
+		utils.PossibleCPUsPath = savedPath
+	}()
+	assert.NoError(ioutil.WriteFile(utils.PossibleCPUsPath, []byte("0-15"), 0644))
+
+	config := vc.SandboxConfig{}
+	ocispec := specs.Spec{}
+
+	assert.NoError(addVCPUPinning(ocispec, &config))
+	assert.False(config.HypervisorConfig.PinVCPUs)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.VCPUCPUSet: " 2-5,8 ",
+	}
+	assert.NoError(addVCPUPinning(ocispec, &config))
+	assert.True(config.HypervisorConfig.PinVCPUs)
+	assert.Equal("2-5,8", config.HypervisorConfig.VCPUCPUSet)
+
+	for _, value := range []string{"", "a", "5-2", "8-16"} {
+		ocispec.Annotations[vcAnnotations.VCPUCPUSet] = value
+		assert.Error(addVCPUPinning(ocispec, &config), "annotation %q", value)
+	}
+}
+
+func TestAddHypervisorAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	savedHostMemoryMBFn := hostMemoryMBFn
+	hostMemoryMBFn = func() (uint64, error) {
+		return 8192, nil
+	}
+	defer func() {
+		hostMemoryMBFn = savedHostMemoryMBFn
+	}()
+
+	kernelParams := []vc.Param{{Key: "quiet"}}
+	config := vc.SandboxConfig{
+		HypervisorConfig: vc.HypervisorConfig{
+			MemorySize:      2048,
+			NumVCPUs:        1,
+			DefaultMaxVCPUs: 4,
+			KernelParams:    kernelParams,
+		},
+	}
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.DefaultMemory: "4096",
+			vcAnnotations.DefaultVCPUs:  " 2 ",
+			vcAnnotations.KernelParams:  "agent.log=debug",
+		},
+	}
+
+	// The annotations have to be enabled
+	assert.Error(addHypervisorAnnotations(ocispec, &config))
+
+	config.HypervisorConfig.EnableAnnotations = []string{"default_memory", "default_vcpus", "kernel_params"}
+	assert.NoError(addHypervisorAnnotations(ocispec, &config))
+	assert.Equal(uint32(4096), config.HypervisorConfig.MemorySize)
+	assert.Equal(uint32(2), config.HypervisorConfig.NumVCPUs)
+	assert.Equal([]vc.Param{{Key: "quiet"}, {Key: "agent.log", Value: "debug"}}, config.HypervisorConfig.KernelParams)
+	assert.Equal([]vc.Param{{Key: "quiet"}}, kernelParams)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.MachineType: "q35",
+	}
+	assert.Error(addHypervisorAnnotations(ocispec, &config))
+
+	for _, a := range []struct {
+		key   string
+		value string
+	}{
+		{vcAnnotations.DefaultMemory, "0"},
+		{vcAnnotations.DefaultMemory, "16384"},
+		{vcAnnotations.DefaultVCPUs, "8"},
+		{vcAnnotations.KernelParams, " "},
+		{vcAnnotations.KernelParams, strings.Repeat("a=b ", maxAnnotationSize)},
+		{vcAnnotations.MachineType, ""},
+		{vcAnnotations.VirtioFSCache, "never"},
+	} {
+		config.HypervisorConfig.EnableAnnotations = []string{"default_memory", "default_vcpus", "kernel_params", "machine_type", "virtio_fs_cache"}
+		ocispec.Annotations = map[string]string{a.key: a.value}
+		assert.Error(addHypervisorAnnotations(ocispec, &config), "annotation %s=%q", a.key, a.value)
+	}
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.MachineType:   "q35",
+		vcAnnotations.VirtioFSCache: "always",
+	}
+	assert.NoError(addHypervisorAnnotations(ocispec, &config))
+	assert.Equal("q35", config.HypervisorConfig.HypervisorMachineType)
+	assert.Equal("always", config.HypervisorConfig.VirtioFSCache)
+
+	assert.True(IsHypervisorAnnotationOption("kernel_params"))
+	assert.True(IsHypervisorAnnotationOption("rootfs_luks"))
+	assert.False(IsHypervisorAnnotationOption("path"))
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	config := vc.HypervisorConfig{}
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.BundlePathKey: "/run/bundle",
+		},
+	}
+
+	assert.NoError(CheckAnnotations(ocispec, config))
+
+	for _, a := range featureAnnotations {
+		ocispec.Annotations = map[string]string{a.annotation: "value"}
+
+		config.EnableAnnotations = nil
+		assert.Error(CheckAnnotations(ocispec, config), "annotation %s", a.annotation)
+
+		config.EnableAnnotations = []string{a.option}
+		assert.NoError(CheckAnnotations(ocispec, config), "annotation %s", a.annotation)
+
+		ocispec.Annotations[a.annotation] = strings.Repeat("a", maxAnnotationSize+1)
+		assert.Error(CheckAnnotations(ocispec, config), "annotation %s", a.annotation)
+	}
+
+	// The kernel modules annotation is not gated.
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.KernelModules: "e1000e",
+	}
+	config.EnableAnnotations = nil
+	assert.NoError(CheckAnnotations(ocispec, config))
+
+	// The sandbox is not created with a feature not enabled.
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.VirtioFSVolumes: "data=/srv/data",
+	}
+	_, err := SandboxConfig(ocispec, RuntimeConfig{}, "/run/bundle", "sandbox", "", false, false)
+	assert.Error(err)
+}
+
+func TestAddHugePages(t *testing.T) {
+	assert := assert.New(t)
+
+	config := vc.SandboxConfig{}
+	ocispec := specs.Spec{}
+
+	assert.NoError(addHugePages(ocispec, &config))
+	assert.False(config.HypervisorConfig.HugePages)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.HugePageSize:  "1G",
+		vcAnnotations.HugePagesPath: " /dev/hugepages-1G ",
+	}
+	assert.NoError(addHugePages(ocispec, &config))
+	assert.True(config.HypervisorConfig.HugePages)
+	assert.Equal(uint32(1024*1024), config.HypervisorConfig.HugePageSizeKB)
+	assert.Equal("/dev/hugepages-1G", config.HypervisorConfig.HugePagesPath)
+
+	ocispec.Annotations[vcAnnotations.HugePageSize] = "3M"
+	assert.Error(addHugePages(ocispec, &config))
+
+	ocispec.Annotations[vcAnnotations.HugePageSize] = "2M"
+	ocispec.Annotations[vcAnnotations.HugePagesPath] = "hugepages"
+	assert.Error(addHugePages(ocispec, &config))
+}
+
+func TestAddSGXEPC(t *testing.T) {
+	assert := assert.New(t)
+
+	config := vc.SandboxConfig{}
+	ocispec := specs.Spec{}
+
+	assert.NoError(addSGXEPC(ocispec, &config))
+	assert.Zero(config.HypervisorConfig.SGXEPCSize)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.SGXEPC: "64Mi",
+	}
+	assert.NoError(addSGXEPC(ocispec, &config))
+	assert.Equal(int64(64*1024*1024), config.HypervisorConfig.SGXEPCSize)
+
+	for _, value := range []string{"", "0", "-1Mi", "lots"} {
+		ocispec.Annotations[vcAnnotations.SGXEPC] = value
+		assert.Error(addSGXEPC(ocispec, &config), "annotation %q", value)
+	}
+}
+
+func TestXDPInterfaces(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{}
+	interfaces, err := xdpInterfaces(ocispec)
+	assert.NoError(err)
+	assert.Empty(interfaces)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.XDPInterfaces: "eth0; eth1=2;",
+	}
+	interfaces, err = xdpInterfaces(ocispec)
+	assert.NoError(err)
+	assert.Equal(map[string]uint32{"eth0": 0, "eth1": 2}, interfaces)
+
+	for _, value := range []string{"=1", "eth0=", "eth0=-1", "eth0=a"} {
+		ocispec.Annotations[vcAnnotations.XDPInterfaces] = value
+		_, err = xdpInterfaces(ocispec)
+		assert.Error(err, "annotation %q", value)
+	}
+}
+
+func TestTapFdSockets(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "tap-fd")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	tmpdir, err = filepath.EvalSymlinks(tmpdir)
+	assert.NoError(err)
+
+	dir := filepath.Join(tmpdir, "sockets")
+	other := filepath.Join(tmpdir, "other")
+	for _, path := range []string{
+		filepath.Join(dir, "net1.sock"),
+		filepath.Join(dir, "net2.sock"),
+		filepath.Join(other, "net1.sock"),
+	} {
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0700))
+		assert.NoError(ioutil.WriteFile(path, nil, 0600))
+	}
+	assert.NoError(os.Symlink(filepath.Join(other, "net1.sock"), filepath.Join(dir, "link.sock")))
+	assert.NoError(os.Mkdir(filepath.Join(dir, "sub"), 0700))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sub", "net1.sock"), nil, 0600))
+
+	ocispec := specs.Spec{}
+	sockets, err := tapFdSockets(ocispec, "")
+	assert.NoError(err)
+	assert.Empty(sockets)
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.TapFdSockets: "net1=" + dir + "/net1.sock; net2=" + dir + "/../sockets/net2.sock;",
+	}
+	sockets, err = tapFdSockets(ocispec, dir)
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"net1": filepath.Join(dir, "net1.sock"),
+		"net2": filepath.Join(dir, "net2.sock"),
+	}, sockets)
+
+	// the sockets are refused unless the operator configured their directory
+	_, err = tapFdSockets(ocispec, "")
+	assert.Error(err)
+
+	for _, value := range []string{
+		"net1",
+		"=" + dir + "/net1.sock",
+		"net1=",
+		"net1=net1.sock",
+		"net1=" + dir + "/net3.sock",
+		"net1=" + other + "/net1.sock",
+		"net1=" + dir + "/../other/net1.sock",
+		"net1=" + dir + "/link.sock",
+		"net1=" + dir + "/sub/net1.sock",
+	} {
+		ocispec.Annotations[vcAnnotations.TapFdSockets] = value
+		_, err = tapFdSockets(ocispec, dir)
+		assert.Error(err, "annotation %q", value)
+	}
+}
+
+func TestSandboxBandwidth(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{}
+	bw, err := sandboxBandwidth(ocispec)
+	assert.NoError(err)
+	assert.Equal(vc.BandwidthConfig{}, bw)
+
+	ocispec.Annotations = map[string]string{
+		kubernetesIngressBandwidth: "10M",
+		kubernetesEgressBandwidth:  "512Ki",
+	}
+	bw, err = sandboxBandwidth(ocispec)
+	assert.NoError(err)
+	assert.Equal(vc.BandwidthConfig{IngressRate: 10000000, EgressRate: 524288}, bw)
+
+	ocispec.Annotations = map[string]string{
+		annotations.Annotations: `{"kubernetes.io/egress-bandwidth":"1.5G"}`,
+	}
+	bw, err = sandboxBandwidth(ocispec)
+	assert.NoError(err)
+	assert.Equal(vc.BandwidthConfig{EgressRate: 1500000000}, bw)
+
+	for _, value := range []string{"fast", "10X", "999", "2P", "-1M"} {
+		ocispec.Annotations = map[string]string{kubernetesIngressBandwidth: value}
+		_, err = sandboxBandwidth(ocispec)
+		assert.Error(err, "annotation %q", value)
+	}
+
+	// The annotations are ignored unless the runtime limits the bandwidth.
+	ocispec.Linux = &specs.Linux{}
+	netConf, err := networkConfig(ocispec, RuntimeConfig{})
+	assert.NoError(err)
+	assert.False(netConf.EnableBandwidth)
+	assert.Equal(vc.BandwidthConfig{}, netConf.Bandwidth)
+
+	ocispec.Annotations = map[string]string{kubernetesIngressBandwidth: "10M"}
+	netConf, err = networkConfig(ocispec, RuntimeConfig{EnablePodBandwidth: true})
+	assert.NoError(err)
+	assert.True(netConf.EnableBandwidth)
+	assert.Equal(vc.BandwidthConfig{IngressRate: 10000000}, netConf.Bandwidth)
+}
+
+func TestSandboxLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{}
+	assert.Equal(vc.SandboxLabels{}, sandboxLabels(ocispec))
+
+	ocispec.Annotations = map[string]string{
+		criSandboxNamespace: "default",
+		criSandboxName:      "nginx",
+		criSandboxUID:       "1234",
+	}
+	assert.Equal(vc.SandboxLabels{Namespace: "default", Name: "nginx", UID: "1234"}, sandboxLabels(ocispec))
+
+	ocispec.Annotations = map[string]string{
+		annotations.Labels: `{"io.kubernetes.pod.namespace":"kube-system","io.kubernetes.pod.name":"dns","io.kubernetes.pod.uid":"5678","app":"dns"}`,
+	}
+	assert.Equal(vc.SandboxLabels{Namespace: "kube-system", Name: "dns", UID: "5678"}, sandboxLabels(ocispec))
+
+	ocispec.Annotations[annotations.Labels] = "not json"
+	assert.Equal(vc.SandboxLabels{}, sandboxLabels(ocispec))
+}
+
+func TestContainerConfigRootfsEncryption(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Process: &specs.Process{},
+		Root:    &specs.Root{Path: "rootfs"},
+		Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+		Annotations: map[string]string{
+			vcAnnotations.RootfsLUKSKeyID:  "rootfs",
+			vcAnnotations.RootfsLUKSFstype: "xfs",
+		},
+	}
+
+	config, err := ContainerConfig(ocispec, tempBundlePath, "ctr", "", false)
+	assert.NoError(err)
+	assert.Equal(vc.RootfsEncryption{KeyID: "rootfs", Fstype: "xfs"}, config.RootfsEncryption)
+	assert.True(config.RootfsEncryption.Enabled())
+}
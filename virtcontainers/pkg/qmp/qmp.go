@@ -0,0 +1,267 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+// Package qmp is a minimal QMP client executing the commands the govmm QMP
+// client does not provide. It is connected to a QMP monitor of its own,
+// QEMU serializing the commands of its monitors.
+package qmp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The QMP error classes.
+const (
+	ErrorClassGeneric         = "GenericError"
+	ErrorClassCommandNotFound = "CommandNotFound"
+	ErrorClassDeviceNotFound  = "DeviceNotFound"
+)
+
+// maxMessageSize bounds the QMP messages, the QMP schema being returned on
+// a single line.
+const maxMessageSize = 16 * 1024 * 1024
+
+// maxEvents bounds the events kept for WaitEvent, the oldest are dropped.
+const maxEvents = 64
+
+// Error is the error QEMU returned for a command.
+type Error struct {
+	Command string
+	Class   string
+	Desc    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("QMP %s failed: %s: %s", e.Command, e.Class, e.Desc)
+}
+
+// IsErrorClass returns whether the cause of err is an Error of the class.
+func IsErrorClass(err error, class string) bool {
+	qmpErr, ok := errors.Cause(err).(*Error)
+
+	return ok && qmpErr.Class == class
+}
+
+// Event is an event QEMU emitted.
+type Event struct {
+	Name string          `json:"event"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type message struct {
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// Client executes QMP commands on a QMP monitor. Its commands are
+// serialized.
+type Client struct {
+	sync.Mutex
+
+	w       io.Writer
+	scanner *bufio.Scanner
+	conn    net.Conn
+
+	// events are the events received while waiting for the responses.
+	events []Event
+}
+
+// New returns the client of the QMP monitor read from r and written to w,
+// once its capabilities are negotiated.
+func New(ctx context.Context, w io.Writer, r io.Reader) (*Client, error) {
+	return newClient(ctx, w, r, nil)
+}
+
+// Dial connects to the QMP monitor of the unix socket path.
+func Dial(ctx context.Context, path string) (*Client, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newClient(ctx, conn, conn, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newClient(ctx context.Context, w io.Writer, r io.Reader, conn net.Conn) (*Client, error) {
+	c := &Client{
+		w:       w,
+		scanner: bufio.NewScanner(r),
+		conn:    conn,
+	}
+	c.scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	// The greeting is skipped like the events.
+	if err := c.Execute(ctx, "qmp_capabilities", nil, nil); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the connection to the QMP monitor.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+// Execute executes the command with its arguments, decoding its return
+// value into result if it is not nil. The errors of QEMU are *Error. The
+// client must be closed once ctx interrupted a command.
+func (c *Client) Execute(ctx context.Context, command string, arguments interface{}, result interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+
+	defer c.watch(ctx)()
+
+	cmd := struct {
+		Execute   string      `json:"execute"`
+		Arguments interface{} `json:"arguments,omitempty"`
+	}{command, arguments}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.w.Write(append(data, '\n')); err != nil {
+		return c.contextError(ctx, err)
+	}
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return c.contextError(ctx, err)
+		}
+
+		if msg.Error != nil {
+			return &Error{
+				Command: command,
+				Class:   msg.Error.Class,
+				Desc:    msg.Error.Desc,
+			}
+		}
+
+		if msg.Return != nil {
+			if result == nil {
+				return nil
+			}
+
+			return json.Unmarshal(msg.Return, result)
+		}
+	}
+}
+
+// WaitEvent waits for the event name whose data match, if match is not
+// nil, and returns it.
+func (c *Client) WaitEvent(ctx context.Context, name string, match func(data json.RawMessage) bool) (Event, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	matches := func(e Event) bool {
+		return e.Name == name && (match == nil || match(e.Data))
+	}
+
+	for i, e := range c.events {
+		if matches(e) {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return e, nil
+		}
+	}
+
+	defer c.watch(ctx)()
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return Event{}, c.contextError(ctx, err)
+		}
+
+		if e := (Event{Name: msg.Event, Data: msg.Data}); msg.Event != "" && matches(e) {
+			c.events = c.events[:len(c.events)-1]
+			return e, nil
+		}
+	}
+}
+
+// read reads the next message, recording it if it is an event.
+func (c *Client) read() (*message, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var msg message
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		return nil, err
+	}
+
+	if msg.Event != "" {
+		if len(c.events) == maxEvents {
+			c.events = c.events[1:]
+		}
+		c.events = append(c.events, Event{Name: msg.Event, Data: msg.Data})
+	}
+
+	return &msg, nil
+}
+
+// watch interrupts the I/O on the connection once ctx is done, until the
+// function it returns is called.
+func (c *Client) watch(ctx context.Context) func() {
+	if c.conn == nil || ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// contextError returns the error of ctx if it interrupted the I/O which
+// failed with err.
+func (c *Client) contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package qmp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQEMU answers the QMP commands like QEMU does.
+func fakeQEMU(r io.Reader, w io.Writer) {
+	w.Write([]byte(`{"QMP": {"version": {"qemu": {"major": 4}}, "capabilities": []}}` + "\n"))
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var cmd struct {
+			Execute   string
+			Arguments map[string]interface{}
+		}
+		json.Unmarshal(scanner.Bytes(), &cmd)
+
+		switch cmd.Execute {
+		case "qmp_capabilities":
+			w.Write([]byte(`{"return": {}}` + "\n"))
+		case "query-machines":
+			w.Write([]byte(`{"timestamp": {"seconds": 1}, "event": "RESUME"}` + "\n"))
+			w.Write([]byte(`{"return": [{"name": "pc-q35-4.1", "alias": "q35"}]}` + "\n"))
+		case "device_del":
+			if cmd.Arguments["id"] != "dev0" {
+				w.Write([]byte(`{"error": {"class": "DeviceNotFound", "desc": "Device 'dev1' not found"}}` + "\n"))
+				continue
+			}
+			w.Write([]byte(`{"return": {}}` + "\n"))
+			w.Write([]byte(`{"event": "DEVICE_DELETED", "data": {"device": "dev0"}}` + "\n"))
+		case "stop":
+			// never answered
+		default:
+			w.Write([]byte(`{"error": {"class": "CommandNotFound", "desc": "unknown"}}` + "\n"))
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	inR, inW, err := os.Pipe()
+	assert.NoError(err)
+	outR, outW, err := os.Pipe()
+	assert.NoError(err)
+	defer inW.Close()
+	defer outR.Close()
+
+	go func() {
+		defer inR.Close()
+		defer outW.Close()
+		fakeQEMU(inR, outW)
+	}()
+
+	ctx := context.Background()
+
+	c, err := New(ctx, inW, outR)
+	assert.NoError(err)
+	assert.NoError(c.Close())
+
+	var machines []struct {
+		Name  string `json:"name"`
+		Alias string `json:"alias"`
+	}
+	assert.NoError(c.Execute(ctx, "query-machines", nil, &machines))
+	assert.Len(machines, 1)
+	assert.Equal("q35", machines[0].Alias)
+
+	err = c.Execute(ctx, "device_del", map[string]string{"id": "dev1"}, nil)
+	assert.True(IsErrorClass(errors.Wrap(err, "hot unplug"), ErrorClassDeviceNotFound))
+	assert.False(IsErrorClass(err, ErrorClassGeneric))
+	assert.Equal("QMP device_del failed: DeviceNotFound: Device 'dev1' not found", err.Error())
+
+	err = c.Execute(ctx, "query-pci", nil, nil)
+	assert.True(IsErrorClass(err, ErrorClassCommandNotFound))
+
+	// the event received with the response is kept
+	assert.NoError(c.Execute(ctx, "device_del", map[string]string{"id": "dev0"}, nil))
+	e, err := c.WaitEvent(ctx, "DEVICE_DELETED", func(data json.RawMessage) bool {
+		var d struct{ Device string }
+		return json.Unmarshal(data, &d) == nil && d.Device == "dev0"
+	})
+	assert.NoError(err)
+	assert.Equal("DEVICE_DELETED", e.Name)
+
+	// the events are only returned once
+	assert.Len(c.events, 1)
+	_, err = c.WaitEvent(ctx, "RESUME", nil)
+	assert.NoError(err)
+	assert.Empty(c.events)
+
+	assert.False(IsErrorClass(io.EOF, ErrorClassGeneric))
+}
+
+func TestDial(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "qmp")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "qmp.sock")
+	l, err := net.Listen("unix", path)
+	assert.NoError(err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fakeQEMU(conn, conn)
+	}()
+
+	ctx := context.Background()
+
+	_, err = Dial(ctx, filepath.Join(dir, "missing.sock"))
+	assert.Error(err)
+
+	c, err := Dial(ctx, path)
+	assert.NoError(err)
+	defer c.Close()
+
+	// the commands QEMU does not answer are interrupted
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	err = c.Execute(ctx, "stop", nil, nil)
+	assert.Equal(context.DeadlineExceeded, err)
+}
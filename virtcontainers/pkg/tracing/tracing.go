@@ -0,0 +1,193 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+// Package tracing propagates the trace context between containerd, the
+// runtime and the agent in the W3C Trace Context format used by
+// OpenTelemetry, so that the spans of the runtime join the traces of the
+// requests of containerd.
+//
+// The runtime spans are still recorded with the opentracing API and the
+// Jaeger tracer, OpenTelemetry is not vendored: the Propagator only makes
+// them interoperable with OpenTelemetry on the wire.
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/config"
+)
+
+// TraceParentKey is the key of the trace context in the ttrpc and gRPC
+// metadata and in the HTTP headers.
+const TraceParentKey = "traceparent"
+
+const (
+	traceParentVersion = "00"
+	flagSampled        = 0x01
+)
+
+type traceContextFormat struct{}
+
+// TraceContext is the opentracing format of the W3C Trace Context, whose
+// carrier is an opentracing.TextMapReader to extract from and an
+// opentracing.TextMapWriter to inject into.
+var TraceContext = traceContextFormat{}
+
+// Propagator injects and extracts the Jaeger span contexts in the W3C
+// Trace Context format.
+type Propagator struct{}
+
+// Inject implements jaeger.Injector.
+func (p Propagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	writer.Set(TraceParentKey, FormatTraceParent(sc))
+
+	return nil
+}
+
+// Extract implements jaeger.Extractor.
+func (p Propagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+
+	var traceParent string
+	err := reader.ForeachKey(func(key, value string) error {
+		if strings.ToLower(key) == TraceParentKey {
+			traceParent = value
+		}
+		return nil
+	})
+	if err != nil {
+		return jaeger.SpanContext{}, err
+	}
+
+	if traceParent == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	return ParseTraceParent(traceParent)
+}
+
+// TracerOptions returns the options of the Jaeger tracers propagating the
+// trace context in the W3C format.
+func TracerOptions() []config.Option {
+	return []config.Option{
+		// The trace ids of the W3C format are 128 bit long.
+		config.Gen128Bit(true),
+		config.Injector(TraceContext, Propagator{}),
+		config.Extractor(TraceContext, Propagator{}),
+	}
+}
+
+// FormatTraceParent returns the traceparent value of the span context.
+func FormatTraceParent(sc jaeger.SpanContext) string {
+	traceID := sc.TraceID()
+
+	flags := 0
+	if sc.IsSampled() {
+		flags = flagSampled
+	}
+
+	return fmt.Sprintf("%s-%016x%016x-%016x-%02x", traceParentVersion, traceID.High, traceID.Low, uint64(sc.SpanID()), flags)
+}
+
+// ParseTraceParent returns the span context of the traceparent value s.
+func ParseTraceParent(s string) (jaeger.SpanContext, error) {
+	invalid := func() (jaeger.SpanContext, error) {
+		return jaeger.SpanContext{}, fmt.Errorf("Invalid traceparent %q", s)
+	}
+
+	fields := strings.Split(strings.TrimSpace(s), "-")
+	if len(fields) < 4 {
+		return invalid()
+	}
+
+	version, err := decodeHex(fields[0], 1)
+	if err != nil || version[0] == 0xff {
+		return invalid()
+	}
+
+	// The later versions may append fields.
+	if version[0] == 0 && len(fields) != 4 {
+		return invalid()
+	}
+
+	traceID, err := decodeHex(fields[1], 16)
+	if err != nil {
+		return invalid()
+	}
+
+	spanID, err := decodeHex(fields[2], 8)
+	if err != nil {
+		return invalid()
+	}
+
+	flags, err := decodeHex(fields[3], 1)
+	if err != nil {
+		return invalid()
+	}
+
+	id := jaeger.TraceID{
+		High: beUint64(traceID[:8]),
+		Low:  beUint64(traceID[8:]),
+	}
+	if !id.IsValid() || beUint64(spanID) == 0 {
+		return invalid()
+	}
+
+	return jaeger.NewSpanContext(id, jaeger.SpanID(beUint64(spanID)), 0, flags[0]&flagSampled != 0, nil), nil
+}
+
+// decodeHex decodes the lower case hexadecimal field s of size bytes.
+func decodeHex(s string, size int) ([]byte, error) {
+	if len(s) != 2*size || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("Invalid field %q", s)
+	}
+
+	return hex.DecodeString(s)
+}
+
+func beUint64(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
+
+// Inject returns the trace context of the span in the W3C format, if the
+// span is recorded.
+func Inject(span opentracing.Span) (string, bool) {
+	carrier := opentracing.TextMapCarrier{}
+	if err := span.Tracer().Inject(span.Context(), TraceContext, carrier); err != nil {
+		return "", false
+	}
+
+	traceParent, ok := carrier[TraceParentKey]
+
+	return traceParent, ok
+}
+
+// Extract returns the span context of the trace context in the W3C format,
+// or nil if traceParent is not a valid one.
+func Extract(traceParent string) opentracing.SpanContext {
+	sc, err := opentracing.GlobalTracer().Extract(TraceContext, opentracing.TextMapCarrier{TraceParentKey: traceParent})
+	if err != nil {
+		return nil
+	}
+
+	return sc
+}
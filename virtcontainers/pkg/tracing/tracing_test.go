@@ -0,0 +1,85 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package tracing
+
+import (
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func newTestTracer() opentracing.Tracer {
+	tracer, _ := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Gen128Bit(true),
+		jaeger.TracerOptions.Injector(TraceContext, Propagator{}),
+		jaeger.TracerOptions.Extractor(TraceContext, Propagator{}))
+
+	return tracer
+}
+
+func TestParseTraceParent(t *testing.T) {
+	assert := assert.New(t)
+
+	traceParent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	sc, err := ParseTraceParent(traceParent)
+	assert.NoError(err)
+	assert.Equal(jaeger.TraceID{High: 0x0af7651916cd43dd, Low: 0x8448eb211c80319c}, sc.TraceID())
+	assert.Equal(jaeger.SpanID(0xb7ad6b7169203331), sc.SpanID())
+	assert.True(sc.IsSampled())
+	assert.Equal(traceParent, FormatTraceParent(sc))
+
+	sc, err = ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	assert.NoError(err)
+	assert.False(sc.IsSampled())
+
+	// The later versions may append fields.
+	_, err = ParseTraceParent("01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra")
+	assert.NoError(err)
+
+	for _, s := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b716920333z-01",
+	} {
+		_, err := ParseTraceParent(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	assert := assert.New(t)
+
+	// The spans of the NOP tracer are not propagated.
+	_, ok := Inject(opentracing.NoopTracer{}.StartSpan("noop"))
+	assert.False(ok)
+
+	tracer := newTestTracer()
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	span := tracer.StartSpan("parent")
+	defer span.Finish()
+
+	traceParent, ok := Inject(span)
+	assert.True(ok)
+	assert.Equal(FormatTraceParent(span.Context().(jaeger.SpanContext)), traceParent)
+
+	sc, ok := Extract(traceParent).(jaeger.SpanContext)
+	assert.True(ok)
+	assert.Equal(span.Context().(jaeger.SpanContext).TraceID(), sc.TraceID())
+	assert.Equal(span.Context().(jaeger.SpanContext).SpanID(), sc.SpanID())
+
+	assert.Nil(Extract("invalid"))
+}
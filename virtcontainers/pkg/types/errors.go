@@ -7,6 +7,7 @@ package types
 
 import (
 	"errors"
+	"fmt"
 )
 
 // common error objects used for argument checking
@@ -18,3 +19,72 @@ var (
 	ErrNoSuchContainer   = errors.New("Container does not exist")
 	ErrInvalidConfigType = errors.New("Invalid config type")
 )
+
+// error objects classifying the failures to hotplug a device, the cause of
+// the HotplugError returned
+var (
+	// ErrResourceExhausted is returned when hotplugging a device would
+	// exceed the resources of the sandbox.
+	ErrResourceExhausted = errors.New("resource exhausted")
+
+	// ErrNotSupported is returned when the hypervisor or the guest do not
+	// support hotplugging a device.
+	ErrNotSupported = errors.New("not supported")
+
+	// ErrHotplugFailed is the kind of the hotplugs which failed
+	// transiently. Unlike the others, the hotplug may succeed if retried.
+	ErrHotplugFailed = errors.New("hotplug failed")
+)
+
+// HotplugError describes the device a hotplug failed for. Its kind is
+// ErrResourceExhausted, ErrNotSupported or ErrHotplugFailed, or nil if the
+// failure is not classified.
+type HotplugError struct {
+	// Op is the hotplug operation, "add" or "remove".
+	Op string
+
+	// Device is the type of the device, e.g. "memory".
+	Device string
+
+	// ID is the ID of the device, if it has one.
+	ID string
+
+	// Kind is the class of the failure.
+	Kind error
+
+	// Err is the error which failed the hotplug.
+	Err error
+}
+
+func (e *HotplugError) Error() string {
+	device := e.Device + " device"
+	if e.ID != "" {
+		device += " " + e.ID
+	}
+
+	return fmt.Sprintf("failed to %s %s: %v", e.Op, device, e.Err)
+}
+
+// Cause returns the error which failed the hotplug, for
+// github.com/pkg/errors.Cause.
+func (e *HotplugError) Cause() error {
+	return e.Err
+}
+
+// HotplugErrorKind returns the kind of the HotplugError err is or wraps,
+// nil if there is none.
+func HotplugErrorKind(err error) error {
+	for err != nil {
+		if herr, ok := err.(*HotplugError); ok {
+			return herr.Kind
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil
+		}
+		err = cause.Cause()
+	}
+
+	return nil
+}
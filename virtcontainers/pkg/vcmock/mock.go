@@ -101,6 +101,33 @@ func (m *VCMock) RunSandbox(ctx context.Context, sandboxConfig vc.SandboxConfig)
 	return nil, fmt.Errorf("%s: %s (%+v): sandboxConfig: %v", mockErrorPrefix, getSelf(), m, sandboxConfig)
 }
 
+// PrepareMigration implements the VC function of the same name.
+func (m *VCMock) PrepareMigration(ctx context.Context, sandboxID string) (*vc.SandboxConfig, error) {
+	if m.PrepareMigrationFunc != nil {
+		return m.PrepareMigrationFunc(ctx, sandboxID)
+	}
+
+	return nil, fmt.Errorf("%s: %s (%+v): sandboxID: %v", mockErrorPrefix, getSelf(), m, sandboxID)
+}
+
+// MigrateSandbox implements the VC function of the same name.
+func (m *VCMock) MigrateSandbox(ctx context.Context, sandboxID string, config vc.MigrationConfig) error {
+	if m.MigrateSandboxFunc != nil {
+		return m.MigrateSandboxFunc(ctx, sandboxID, config)
+	}
+
+	return fmt.Errorf("%s: %s (%+v): sandboxID: %v", mockErrorPrefix, getSelf(), m, sandboxID)
+}
+
+// ReceiveSandbox implements the VC function of the same name.
+func (m *VCMock) ReceiveSandbox(ctx context.Context, sandboxConfig vc.SandboxConfig, config vc.MigrationConfig) (vc.VCSandbox, error) {
+	if m.ReceiveSandboxFunc != nil {
+		return m.ReceiveSandboxFunc(ctx, sandboxConfig, config)
+	}
+
+	return nil, fmt.Errorf("%s: %s (%+v): sandboxConfig: %v", mockErrorPrefix, getSelf(), m, sandboxConfig)
+}
+
 // ListSandbox implements the VC function of the same name.
 func (m *VCMock) ListSandbox(ctx context.Context) ([]vc.SandboxStatus, error) {
 	if m.ListSandboxFunc != nil {
@@ -251,6 +251,86 @@ func TestVCMockResumeSandbox(t *testing.T) {
 	assert.True(IsMockError(err))
 }
 
+func TestVCMockPrepareMigration(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.PrepareMigrationFunc)
+
+	ctx := context.Background()
+	_, err := m.PrepareMigration(ctx, testSandboxID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.PrepareMigrationFunc = func(ctx context.Context, sandboxID string) (*vc.SandboxConfig, error) {
+		return &vc.SandboxConfig{ID: sandboxID}, nil
+	}
+
+	sandboxConfig, err := m.PrepareMigration(ctx, testSandboxID)
+	assert.NoError(err)
+	assert.Equal(sandboxConfig, &vc.SandboxConfig{ID: testSandboxID})
+
+	// reset
+	m.PrepareMigrationFunc = nil
+
+	_, err = m.PrepareMigration(ctx, testSandboxID)
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
+func TestVCMockMigrateSandbox(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.MigrateSandboxFunc)
+
+	ctx := context.Background()
+	err := m.MigrateSandbox(ctx, testSandboxID, vc.MigrationConfig{})
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.MigrateSandboxFunc = func(ctx context.Context, sandboxID string, config vc.MigrationConfig) error {
+		return nil
+	}
+
+	err = m.MigrateSandbox(ctx, testSandboxID, vc.MigrationConfig{})
+	assert.NoError(err)
+
+	// reset
+	m.MigrateSandboxFunc = nil
+
+	err = m.MigrateSandbox(ctx, testSandboxID, vc.MigrationConfig{})
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
+func TestVCMockReceiveSandbox(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &VCMock{}
+	assert.Nil(m.ReceiveSandboxFunc)
+
+	ctx := context.Background()
+	_, err := m.ReceiveSandbox(ctx, vc.SandboxConfig{}, vc.MigrationConfig{})
+	assert.Error(err)
+	assert.True(IsMockError(err))
+
+	m.ReceiveSandboxFunc = func(ctx context.Context, sandboxConfig vc.SandboxConfig, config vc.MigrationConfig) (vc.VCSandbox, error) {
+		return &Sandbox{}, nil
+	}
+
+	sandbox, err := m.ReceiveSandbox(ctx, vc.SandboxConfig{}, vc.MigrationConfig{})
+	assert.NoError(err)
+	assert.Equal(sandbox, &Sandbox{})
+
+	// reset
+	m.ReceiveSandboxFunc = nil
+
+	_, err = m.ReceiveSandbox(ctx, vc.SandboxConfig{}, vc.MigrationConfig{})
+	assert.Error(err)
+	assert.True(IsMockError(err))
+}
+
 func TestVCMockRunSandbox(t *testing.T) {
 	assert := assert.New(t)
 
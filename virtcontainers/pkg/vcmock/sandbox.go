@@ -7,7 +7,9 @@ package vcmock
 
 import (
 	"io"
+	"sync"
 	"syscall"
+	"time"
 
 	vc "github.com/kata-containers/runtime/virtcontainers"
 	"github.com/kata-containers/runtime/virtcontainers/device/api"
@@ -128,6 +130,26 @@ func (s *Sandbox) StatsContainer(contID string) (vc.ContainerStats, error) {
 	return vc.ContainerStats{}, nil
 }
 
+// NetworkStats implements the VCSandbox function of the same name.
+func (s *Sandbox) NetworkStats() ([]*vc.NetworkStats, error) {
+	return nil, nil
+}
+
+// Metrics implements the VCSandbox function of the same name.
+func (s *Sandbox) Metrics() (vc.HypervisorMetrics, error) {
+	return vc.HypervisorMetrics{}, nil
+}
+
+// AllocateVSockPort implements the VCSandbox function of the same name.
+func (s *Sandbox) AllocateVSockPort(service string) (uint32, error) {
+	return 0, nil
+}
+
+// ReleaseVSockPort implements the VCSandbox function of the same name.
+func (s *Sandbox) ReleaseVSockPort(service string) error {
+	return nil
+}
+
 // PauseContainer implements the VCSandbox function of the same name.
 func (s *Sandbox) PauseContainer(contID string) error {
 	return nil
@@ -212,3 +234,47 @@ func (s *Sandbox) UpdateRoutes(routes []*vcTypes.Route) ([]*vcTypes.Route, error
 func (s *Sandbox) ListRoutes() ([]*vcTypes.Route, error) {
 	return nil, nil
 }
+
+// UpdateBandwidth implements the VCSandbox function of the same name.
+func (s *Sandbox) UpdateBandwidth(bw vc.BandwidthConfig) error {
+	return nil
+}
+
+// HotplugJobs implements the VCSandbox function of the same name.
+func (s *Sandbox) HotplugJobs() []vc.HotplugJob {
+	return nil
+}
+
+// CancelHotplugJob implements the VCSandbox function of the same name.
+func (s *Sandbox) CancelHotplugJob(id string) error {
+	return nil
+}
+
+// ReserveHotplug implements the VCSandbox function of the same name.
+func (s *Sandbox) ReserveHotplug(owner string, bridgeSlots, memorySlots uint32, ttl time.Duration) (vc.HotplugReservation, error) {
+	return vc.HotplugReservation{}, nil
+}
+
+// ReleaseHotplugReservation implements the VCSandbox function of the same name.
+func (s *Sandbox) ReleaseHotplugReservation(id, owner string) error {
+	return nil
+}
+
+// HotplugReservations implements the VCSandbox function of the same name.
+func (s *Sandbox) HotplugReservations(owner string) []vc.HotplugReservation {
+	return nil
+}
+
+// SetOperationLock implements the VCSandbox function of the same name.
+func (s *Sandbox) SetOperationLock(lock sync.Locker) {
+}
+
+// Provenance implements the VCSandbox function of the same name.
+func (s *Sandbox) Provenance() *types.Provenance {
+	return nil
+}
+
+// DebugInfo implements the VCSandbox function of the same name.
+func (s *Sandbox) DebugInfo() vc.SandboxDebugInfo {
+	return vc.SandboxDebugInfo{ID: s.MockID}
+}
@@ -44,17 +44,20 @@ type VCMock struct {
 	SetLoggerFunc  func(ctx context.Context, logger *logrus.Entry)
 	SetFactoryFunc func(ctx context.Context, factory vc.Factory)
 
-	CreateSandboxFunc  func(ctx context.Context, sandboxConfig vc.SandboxConfig) (vc.VCSandbox, error)
-	DeleteSandboxFunc  func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
-	ListSandboxFunc    func(ctx context.Context) ([]vc.SandboxStatus, error)
-	FetchSandboxFunc   func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
-	PauseSandboxFunc   func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
-	ResumeSandboxFunc  func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
-	RunSandboxFunc     func(ctx context.Context, sandboxConfig vc.SandboxConfig) (vc.VCSandbox, error)
-	StartSandboxFunc   func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
-	StatusSandboxFunc  func(ctx context.Context, sandboxID string) (vc.SandboxStatus, error)
-	StatsContainerFunc func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error)
-	StopSandboxFunc    func(ctx context.Context, sandboxID string, force bool) (vc.VCSandbox, error)
+	CreateSandboxFunc    func(ctx context.Context, sandboxConfig vc.SandboxConfig) (vc.VCSandbox, error)
+	DeleteSandboxFunc    func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
+	ListSandboxFunc      func(ctx context.Context) ([]vc.SandboxStatus, error)
+	FetchSandboxFunc     func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
+	PauseSandboxFunc     func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
+	ResumeSandboxFunc    func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
+	RunSandboxFunc       func(ctx context.Context, sandboxConfig vc.SandboxConfig) (vc.VCSandbox, error)
+	StartSandboxFunc     func(ctx context.Context, sandboxID string) (vc.VCSandbox, error)
+	StatusSandboxFunc    func(ctx context.Context, sandboxID string) (vc.SandboxStatus, error)
+	StatsContainerFunc   func(ctx context.Context, sandboxID, containerID string) (vc.ContainerStats, error)
+	StopSandboxFunc      func(ctx context.Context, sandboxID string, force bool) (vc.VCSandbox, error)
+	PrepareMigrationFunc func(ctx context.Context, sandboxID string) (*vc.SandboxConfig, error)
+	MigrateSandboxFunc   func(ctx context.Context, sandboxID string, config vc.MigrationConfig) error
+	ReceiveSandboxFunc   func(ctx context.Context, sandboxConfig vc.SandboxConfig, config vc.MigrationConfig) (vc.VCSandbox, error)
 
 	CreateContainerFunc      func(ctx context.Context, sandboxID string, containerConfig vc.ContainerConfig) (vc.VCSandbox, vc.VCContainer, error)
 	DeleteContainerFunc      func(ctx context.Context, sandboxID, containerID string) (vc.VCContainer, error)
@@ -0,0 +1,223 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+)
+
+// runtimeVersion is the version of the runtime recorded in the provenance
+// of the sandboxes.
+var runtimeVersion = "unknown"
+
+// SetRuntimeVersion sets the runtime version recorded in the provenance of
+// the sandboxes.
+func SetRuntimeVersion(version string) {
+	runtimeVersion = version
+}
+
+// provenanceFile identifies a version of a file: hashing the guest image at
+// each sandbox creation would be slow, the digests and versions are cached
+// until the file is modified.
+type provenanceFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+var (
+	provenanceLock     sync.Mutex
+	provenanceDigests  = make(map[provenanceFile]string)
+	provenanceVersions = make(map[provenanceFile]string)
+)
+
+func statProvenanceFile(path string) (provenanceFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return provenanceFile{}, err
+	}
+
+	return provenanceFile{
+		path:    path,
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+	}, nil
+}
+
+// fileDigest returns the sha256 digest of path.
+func fileDigest(path string) (string, error) {
+	key, err := statProvenanceFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	provenanceLock.Lock()
+	defer provenanceLock.Unlock()
+
+	if digest, ok := provenanceDigests[key]; ok {
+		return digest, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	provenanceDigests[key] = digest
+
+	return digest, nil
+}
+
+var componentVersionFunc = componentVersion
+
+// componentVersion returns the first line printed by the --version option
+// of the executable path.
+func componentVersion(path string) (string, error) {
+	key, err := statProvenanceFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	provenanceLock.Lock()
+	defer provenanceLock.Unlock()
+
+	if version, ok := provenanceVersions[key]; ok {
+		return version, nil
+	}
+
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("Could not get %s version: %v", path, err)
+	}
+
+	line, _ := bufio.NewReader(bytes.NewReader(output)).ReadString('\n')
+	version := strings.TrimSpace(line)
+	provenanceVersions[key] = version
+
+	return version, nil
+}
+
+// hypervisorBinary returns the path of the hypervisor executable. The
+// executable of the running hypervisor is preferred over the configured
+// one, which may be empty for the drivers picking a default binary.
+func (s *Sandbox) hypervisorBinary() (string, error) {
+	if pids := s.hypervisor.getPids(); len(pids) > 0 && pids[0] > 0 {
+		if path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pids[0])); err == nil {
+			return path, nil
+		}
+	}
+
+	return s.config.HypervisorConfig.HypervisorAssetPath()
+}
+
+// provenanceComponents returns the components of the sandbox to record,
+// with their path.
+func (s *Sandbox) provenanceComponents() ([]types.ProvenanceComponent, error) {
+	conf := &s.config.HypervisorConfig
+
+	hypervisor, err := s.hypervisorBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	components := []types.ProvenanceComponent{
+		{Name: types.ProvenanceHypervisor, Path: hypervisor},
+	}
+
+	for _, asset := range []struct {
+		name string
+		path func() (string, error)
+	}{
+		{types.ProvenanceKernel, conf.KernelAssetPath},
+		{types.ProvenanceImage, conf.ImageAssetPath},
+		{types.ProvenanceInitrd, conf.InitrdAssetPath},
+		{types.ProvenanceFirmware, conf.FirmwareAssetPath},
+	} {
+		path, err := asset.path()
+		if err != nil {
+			return nil, err
+		}
+
+		if path != "" {
+			components = append(components, types.ProvenanceComponent{Name: asset.name, Path: path})
+		}
+	}
+
+	if conf.SharedFS == config.VirtioFS && conf.VirtioFSDaemon != "" {
+		components = append(components, types.ProvenanceComponent{
+			Name: types.ProvenanceVirtioFSDaemon,
+			Path: conf.VirtioFSDaemon,
+		})
+	}
+
+	return components, nil
+}
+
+// recordProvenance records the versions and digests of the components the
+// sandbox has been started with. A component which cannot be identified is
+// recorded without version or digest, rather than failing the sandbox.
+func (s *Sandbox) recordProvenance() error {
+	components, err := s.provenanceComponents()
+	if err != nil {
+		return err
+	}
+
+	for i, c := range components {
+		logger := s.Logger().WithField("component", c.Name).WithField("path", c.Path)
+
+		if components[i].Digest, err = fileDigest(c.Path); err != nil {
+			logger.WithError(err).Warn("Could not compute the component digest")
+		}
+
+		switch c.Name {
+		case types.ProvenanceHypervisor, types.ProvenanceVirtioFSDaemon:
+			if components[i].Version, err = componentVersionFunc(c.Path); err != nil {
+				logger.WithError(err).Warn("Could not get the component version")
+			}
+		}
+	}
+
+	s.state.Provenance = &types.Provenance{
+		RuntimeVersion: runtimeVersion,
+		Components:     components,
+		RecordedAt:     time.Now().UTC(),
+	}
+
+	return s.storeState()
+}
+
+// Provenance returns the components the sandbox has been started with, or
+// nil if they have not been recorded.
+func (s *Sandbox) Provenance() *types.Provenance {
+	if s.state.Provenance == nil {
+		return nil
+	}
+
+	p := *s.state.Provenance
+	p.Components = append([]types.ProvenanceComponent(nil), p.Components...)
+
+	return &p
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/device/manager"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/kata-containers/runtime/virtcontainers/persist"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "provenance")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kernel")
+	assert.NoError(ioutil.WriteFile(path, []byte("foo"), 0644))
+
+	digest, err := fileDigest(path)
+	assert.NoError(err)
+	assert.Equal("sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", digest)
+
+	// A modified file is hashed again.
+	assert.NoError(ioutil.WriteFile(path, []byte("foobar"), 0644))
+	digest, err = fileDigest(path)
+	assert.NoError(err)
+	assert.Equal("sha256:c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", digest)
+
+	_, err = fileDigest(filepath.Join(dir, "missing"))
+	assert.Error(err)
+}
+
+func TestSandboxRecordProvenance(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "provenance")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	paths := make(map[string]string)
+	for _, name := range []string{"qemu", "kernel", "image", "virtiofsd"} {
+		paths[name] = filepath.Join(dir, name)
+		assert.NoError(ioutil.WriteFile(paths[name], []byte(name), 0755))
+	}
+
+	savedVersionFunc, savedRuntimeVersion := componentVersionFunc, runtimeVersion
+	defer func() {
+		componentVersionFunc, runtimeVersion = savedVersionFunc, savedRuntimeVersion
+	}()
+
+	componentVersionFunc = func(path string) (string, error) {
+		return filepath.Base(path) + " 1.0", nil
+	}
+	SetRuntimeVersion("1.9.0 (commit abc)")
+
+	sandbox := &Sandbox{
+		id:         "test-provenance",
+		devManager: manager.NewDeviceManager(manager.VirtioSCSI, nil),
+		hypervisor: &mockHypervisor{},
+		ctx:        context.Background(),
+		config: &SandboxConfig{
+			ID:           "test-provenance",
+			Experimental: []exp.Feature{persist.NewStoreFeature},
+			HypervisorConfig: HypervisorConfig{
+				HypervisorPath: paths["qemu"],
+				KernelPath:     paths["kernel"],
+				ImagePath:      paths["image"],
+				SharedFS:       config.VirtioFS,
+				VirtioFSDaemon: paths["virtiofsd"],
+			},
+		},
+	}
+
+	sandbox.newStore, err = persist.GetDriver("fs")
+	assert.NoError(err)
+
+	assert.Nil(sandbox.Provenance())
+	assert.NoError(sandbox.recordProvenance())
+
+	p := sandbox.Provenance()
+	assert.NotNil(p)
+	assert.Equal("1.9.0 (commit abc)", p.RuntimeVersion)
+	assert.Len(p.Components, 4)
+
+	qemu, ok := p.Component(types.ProvenanceHypervisor)
+	assert.True(ok)
+	assert.Equal(paths["qemu"], qemu.Path)
+	assert.Equal("qemu 1.0", qemu.Version)
+	assert.NotEmpty(qemu.Digest)
+
+	kernel, ok := p.Component(types.ProvenanceKernel)
+	assert.True(ok)
+	assert.Empty(kernel.Version)
+	assert.NotEmpty(kernel.Digest)
+
+	_, ok = p.Component(types.ProvenanceInitrd)
+	assert.False(ok)
+
+	virtiofsd, ok := p.Component(types.ProvenanceVirtioFSDaemon)
+	assert.True(ok)
+	assert.Equal("virtiofsd 1.0", virtiofsd.Version)
+
+	// The provenance is persisted with the sandbox state.
+	sandbox.state = types.SandboxState{}
+	assert.NoError(sandbox.Restore())
+	assert.Equal(p, sandbox.Provenance())
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"net"
 	"path/filepath"
+	"sync"
 
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/sirupsen/logrus"
@@ -21,6 +22,49 @@ var buildinProxyConsoleProto = consoleProtoUnix
 type proxyBuiltin struct {
 	sandboxID string
 	conn      net.Conn
+
+	// console is the end of the output of the watched console.
+	console consoleTail
+}
+
+// consoleTail keeps the last bootConsoleProbeMaxSize bytes of the console
+// output, written by the console watcher and read to diagnose the boot
+// failures.
+type consoleTail struct {
+	sync.Mutex
+	data []byte
+}
+
+func (c *consoleTail) writeLine(line string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.data = append(c.data, line...)
+	c.data = append(c.data, '\n')
+	if extra := len(c.data) - bootConsoleProbeMaxSize; extra > 0 {
+		c.data = append(c.data[:0], c.data[extra:]...)
+	}
+}
+
+func (c *consoleTail) String() string {
+	c.Lock()
+	defer c.Unlock()
+
+	return string(c.data)
+}
+
+func (c *consoleTail) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.data = nil
+}
+
+// consoleWatcher is implemented by the proxies watching the console.
+type consoleWatcher interface {
+	// consoleOutput returns the end of the output of the console, false
+	// if it is not watched.
+	consoleOutput() (string, bool)
 }
 
 // ProxyConfig is a structure storing information needed from any
@@ -193,10 +237,12 @@ func (p *proxyBuiltin) watchConsole(proto, console string, logger *logrus.Entry)
 	}
 
 	p.conn = conn
+	p.console.reset()
 
 	go func() {
 		scanner = bufio.NewScanner(conn)
 		for scanner.Scan() {
+			p.console.writeLine(scanner.Text())
 			logger.WithFields(logrus.Fields{
 				"sandbox":   p.sandboxID,
 				"vmconsole": scanner.Text(),
@@ -223,6 +269,14 @@ func (p *proxyBuiltin) consoleWatched() bool {
 	return p.conn != nil
 }
 
+func (p *proxyBuiltin) consoleOutput() (string, bool) {
+	if !p.consoleWatched() {
+		return "", false
+	}
+
+	return p.console.String(), true
+}
+
 // start is the proxy start implementation for builtin proxy.
 // It starts the console watcher for the guest.
 // It returns agentURL to let agent connect directly.
@@ -8,7 +8,6 @@ package virtcontainers
 import (
 	"bufio"
 	"context"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -16,6 +15,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -30,6 +30,8 @@ import (
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/qmp"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/uuid"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
@@ -51,6 +53,11 @@ type qmpChannel struct {
 	path    string
 	qmp     *govmmQemu.QMP
 	disconn chan struct{}
+
+	// ext is the client of the QMP commands govmm does not provide, on
+	// the QMP monitor extPath.
+	extPath string
+	ext     *qmp.Client
 }
 
 // CPUDevice represents a CPU device which was hot-added in a running VM
@@ -59,15 +66,45 @@ type CPUDevice struct {
 	ID string
 }
 
+// NetworkDevice represents a network device which was hot-added in a running VM
+type NetworkDevice struct {
+	// ID identifies the device on its bridge, it is the ID of the TAP
+	// interface of the device.
+	ID string
+	// DevID is the ID of the device in the hypervisor.
+	DevID string
+	// Netdev is the ID of the network backend of the device.
+	Netdev string
+	// MACAddress identifies the endpoint of the device.
+	MACAddress string
+}
+
 // QemuState keeps Qemu's state
 type QemuState struct {
 	Bridges []types.Bridge
 	// HotpluggedCPUs is the list of CPUs that were hot-added
-	HotpluggedVCPUs      []CPUDevice
+	HotpluggedVCPUs []CPUDevice
+	// HotpluggedNetDevices is the list of network devices that were
+	// hot-added, so that they can be removed by the next runtime instance
+	HotpluggedNetDevices []NetworkDevice
 	HotpluggedMemory     int
 	UUID                 string
 	HotplugVFIOOnRootBus bool
 	VirtiofsdPid         int
+	// VolumeVirtiofsdPids maps the tag of each virtio-fs volume to the
+	// pid of its virtiofsd daemon
+	VolumeVirtiofsdPids map[string]int
+	// MemPreallocPending is true while the guest memory is pre-allocated
+	// in the background
+	MemPreallocPending bool
+	// VMMUserGrants are the host files of the hotplugged devices the
+	// unprivileged QEMU was granted access to, once per device
+	VMMUserGrants []string
+	// NvdimmCount is the number of nvdimm ids in use or in NvdimmFreeIDs
+	NvdimmCount int
+	// NvdimmFreeIDs holds the ids below NvdimmCount released by unplugged
+	// nvdimm devices, in increasing order
+	NvdimmFreeIDs []int
 }
 
 // qemu is an Hypervisor interface implementation for the Linux qemu hypervisor.
@@ -86,13 +123,43 @@ type qemu struct {
 
 	arch qemuArch
 
+	// log copies the QEMU log to the VM directory, on debug.
+	log *hypervisorLog
+
 	// fds is a list of file descriptors inherited by QEMU process
 	// they'll be closed once QEMU process is running
 	fds []*os.File
 
 	ctx context.Context
 
-	nvdimmCount int
+	// compat adapts the driver to the QEMU version in use.
+	compat qemuCompat
+
+	// hugePageSizeKB is the size of the huge pages backing the guest
+	// memory, if any.
+	hugePageSizeKB uint32
+
+	// vmmUser is the unprivileged user QEMU drops its privileges to, if
+	// any.
+	vmmUser *vmmUser
+
+	// vmmMemoryDir is the directory of the memory backend files owned by
+	// vmmUser.
+	vmmMemoryDir string
+
+	// memPreallocDir is the directory of the memory backend file to
+	// pre-allocate in the background, if any.
+	memPreallocDir string
+
+	// memPrealloc pre-allocates the guest memory in the background.
+	memPrealloc *memPreallocator
+
+	// launchStderr is what QEMU wrote on its standard error before
+	// daemonizing.
+	launchStderr string
+
+	// qmpHistory records the last QMP commands sent to QEMU.
+	qmpHistory qmpHistory
 
 	stopped bool
 }
@@ -100,12 +167,23 @@ type qemu struct {
 const (
 	consoleSocket = "console.sock"
 	qmpSocket     = "qmp.sock"
+	qmpExtSocket  = "qmp-ext.sock"
 	vhostFSSocket = "vhost-fs.sock"
 
+	// vhostFSVolumeSocket is the socket of the virtio-fs device of a
+	// volume, formatted with the volume tag.
+	vhostFSVolumeSocket = "vhost-fs-%s.sock"
+
 	qmpCapErrMsg  = "Failed to negoatiate QMP capabilities"
 	qmpExecCatCmd = "exec:cat"
 
+	// qmpExtDialTimeout bounds the connection to the extra QMP monitor,
+	// whose socket exists as long as QEMU runs.
+	qmpExtDialTimeout = 5 * time.Second
+
 	scsiControllerID         = "scsi0"
+	scsiIOThreadID           = "iothread0"
+	imageID                  = "image0"
 	rngID                    = "rng0"
 	vsockKernelOption        = "agent.use_vsock"
 	fallbackFileBackedMemDir = "/dev/shm"
@@ -125,13 +203,24 @@ const (
 )
 
 type qmpLogger struct {
-	logger *logrus.Entry
+	logger   *logrus.Entry
+	scrubber logScrubber
+	history  *qmpHistory
+	audit    *qmpCommandAudit
 }
 
-func newQMPLogger() qmpLogger {
-	return qmpLogger{
-		logger: virtLog.WithField("subsystem", "qmp"),
+func newQMPLogger(history *qmpHistory, audit *auditLog, scrubber logScrubber) qmpLogger {
+	l := qmpLogger{
+		logger:   virtLog.WithField("subsystem", "qmp"),
+		scrubber: scrubber,
+		history:  history,
+	}
+
+	if audit != nil {
+		l.audit = &qmpCommandAudit{audit: audit, scrubber: scrubber}
 	}
+
+	return l
 }
 
 func (l qmpLogger) V(level int32) bool {
@@ -139,15 +228,39 @@ func (l qmpLogger) V(level int32) bool {
 }
 
 func (l qmpLogger) Infof(format string, v ...interface{}) {
-	l.logger.Infof(format, v...)
+	msg := l.scrubber.scrub(fmt.Sprintf(format, v...))
+	if l.history != nil && isQMPCommand(msg) {
+		l.history.record(msg)
+	}
+
+	if l.audit != nil {
+		if isQMPCommand(msg) {
+			l.audit.sent(msg)
+		} else {
+			l.audit.received(msg)
+		}
+	}
+
+	l.logger.Info(msg)
 }
 
 func (l qmpLogger) Warningf(format string, v ...interface{}) {
-	l.logger.Warnf(format, v...)
+	l.logger.Warn(l.scrubber.scrub(fmt.Sprintf(format, v...)))
 }
 
 func (l qmpLogger) Errorf(format string, v ...interface{}) {
-	l.logger.Errorf(format, v...)
+	l.logger.Error(l.scrubber.scrub(fmt.Sprintf(format, v...)))
+}
+
+// newQMPLogger returns the logger of the QMP connections to QEMU, which
+// records their commands in the history and the audit log of the sandbox.
+func (q *qemu) newQMPLogger() qmpLogger {
+	return newQMPLogger(&q.qmpHistory, auditLogFromContext(q.ctx), newLogScrubber(q.config.LogScrubParams))
+}
+
+// qmpCommands returns the last QMP commands sent to QEMU.
+func (q *qemu) qmpCommands() []QMPCommand {
+	return q.qmpHistory.list()
 }
 
 // Logger returns a logrus logger appropriate for logging qemu messages
@@ -192,6 +305,10 @@ func (q *qemu) hypervisorConfig() HypervisorConfig {
 	return q.config
 }
 
+func (q *qemu) generateSocket(id string, useVsock bool) (interface{}, error) {
+	return generateVMSocket(id, useVsock)
+}
+
 // get the QEMU binary path
 func (q *qemu) qemuPath() (string, error) {
 	p, err := q.config.HypervisorAssetPath()
@@ -241,6 +358,7 @@ func (q *qemu) setup(id string, hypervisorConfig *HypervisorConfig, vcStore *sto
 	q.store = vcStore
 	q.config = *hypervisorConfig
 	q.arch = newQemuArch(q.config)
+	q.arch.setQMPExt(q.qmpExecutor)
 
 	initrdPath, err := q.config.InitrdAssetPath()
 	if err != nil {
@@ -250,10 +368,10 @@ func (q *qemu) setup(id string, hypervisorConfig *HypervisorConfig, vcStore *sto
 	if err != nil {
 		return err
 	}
+	// The guest image is the first nvdimm device.
+	firstNvdimmID := 0
 	if initrdPath == "" && imagePath != "" {
-		q.nvdimmCount = 1
-	} else {
-		q.nvdimmCount = 0
+		firstNvdimmID = 1
 	}
 
 	var create bool
@@ -268,6 +386,11 @@ func (q *qemu) setup(id string, hypervisorConfig *HypervisorConfig, vcStore *sto
 
 	q.arch.setBridges(q.state.Bridges)
 
+	// The nvdimm ids of the devices already hotplugged are kept.
+	if q.state.NvdimmCount < firstNvdimmID {
+		q.state.NvdimmCount = firstNvdimmID
+	}
+
 	if create {
 		q.Logger().Debug("Creating bridges")
 		q.arch.bridges(q.config.DefaultBridges)
@@ -338,7 +461,11 @@ func (q *qemu) memoryTopology() (govmmQemu.Memory, error) {
 }
 
 func (q *qemu) qmpSocketPath(id string) (string, error) {
-	return utils.BuildSocketPath(store.RunVMStoragePath, id, qmpSocket)
+	return utils.BuildSocketPath(sandboxTmpDir(id), qmpSocket)
+}
+
+func (q *qemu) qmpExtSocketPath(id string) (string, error) {
+	return utils.BuildSocketPath(sandboxTmpDir(id), qmpExtSocket)
 }
 
 func (q *qemu) getQemuMachine() (govmmQemu.Machine, error) {
@@ -380,9 +507,15 @@ func (q *qemu) createQmpSocket() ([]govmmQemu.QMPSocket, error) {
 		return nil, err
 	}
 
+	extSockPath, err := q.qmpExtSocketPath(q.id)
+	if err != nil {
+		return nil, err
+	}
+
 	q.qmpMonitorCh = qmpChannel{
-		ctx:  q.ctx,
-		path: monitorSockPath,
+		ctx:     q.ctx,
+		path:    monitorSockPath,
+		extPath: extSockPath,
 	}
 
 	return []govmmQemu.QMPSocket{
@@ -392,6 +525,12 @@ func (q *qemu) createQmpSocket() ([]govmmQemu.QMPSocket, error) {
 			Server: true,
 			NoWait: true,
 		},
+		{
+			Type:   "unix",
+			Name:   q.qmpMonitorCh.extPath,
+			Server: true,
+			NoWait: true,
+		},
 	}, nil
 }
 
@@ -407,6 +546,20 @@ func (q *qemu) buildDevices(initrdPath string) ([]govmmQemu.Device, *govmmQemu.I
 	// bridge gets the first available PCI address i.e bridgePCIStartAddr
 	devices = q.arch.appendBridges(devices)
 
+	if q.config.ConfidentialGuest != NoConfidentialGuest {
+		devices, err = q.arch.appendProtectionDevice(devices)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if q.config.SGXEPCSize != 0 {
+		devices, err = q.arch.appendSGXEPCDevice(devices)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	devices, err = q.arch.appendConsole(devices, console)
 	if err != nil {
 		return nil, nil, err
@@ -449,7 +602,11 @@ func (q *qemu) setupTemplate(knobs *govmmQemu.Knobs, memory *govmmQemu.Memory) g
 
 func (q *qemu) setupFileBackedMem(knobs *govmmQemu.Knobs, memory *govmmQemu.Memory) {
 	var target string
-	if q.config.FileBackedMemRootDir != "" {
+	if q.config.HugePages {
+		// The memory is backed by the hugetlbfs mount set up by
+		// setupHugePages().
+		target = memory.Path
+	} else if q.config.FileBackedMemRootDir != "" {
 		target = q.config.FileBackedMemRootDir
 	} else {
 		target = fallbackFileBackedMemDir
@@ -464,6 +621,30 @@ func (q *qemu) setupFileBackedMem(knobs *govmmQemu.Knobs, memory *govmmQemu.Memo
 	memory.Path = target
 }
 
+// setupHugePages selects the hugetlbfs mount backing the guest memory, and
+// checks that the memory size is a multiple of its page size.
+func (q *qemu) setupHugePages(memory *govmmQemu.Memory) error {
+	path, pageSizeKB, err := resolveHugePages(q.config.HugePagesPath, q.config.HugePageSizeKB)
+	if err != nil {
+		return err
+	}
+
+	if align := hugePageAlignMB(pageSizeKB); q.config.MemorySize%align != 0 {
+		return fmt.Errorf("Memory size %d MiB is not a multiple of the %d KiB huge pages of %s",
+			q.config.MemorySize, pageSizeKB, path)
+	}
+
+	q.Logger().WithFields(logrus.Fields{
+		"path":          path,
+		"page-size-kiB": pageSizeKB,
+	}).Debug("Backing guest memory with huge pages")
+
+	memory.Path = path
+	q.hugePageSizeKB = pageSizeKB
+
+	return nil
+}
+
 // createSandbox is the Hypervisor sandbox creation implementation for govmmQemu.
 func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNamespace, hypervisorConfig *HypervisorConfig, vcStore *store.VCStore) error {
 	// Save the tracing context
@@ -476,6 +657,34 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		return err
 	}
 
+	if err := checkVMMRlimits(q.config.VMMRlimits, &q.config); err != nil {
+		return err
+	}
+
+	if err := checkVMMEnv(q.config.VMMEnv); err != nil {
+		return err
+	}
+
+	if err := checkVMMBindMounts(q.config.VMMBindMounts); err != nil {
+		return err
+	}
+
+	if err := checkVMMSlice(q.config.VMMSlice); err != nil {
+		return err
+	}
+
+	if q.config.VMMUser != "" {
+		if q.config.BootToBeTemplate || q.config.BootFromTemplate {
+			return errors.New("VM templating is not supported with an unprivileged hypervisor user")
+		}
+
+		u, err := lookupVMMUser(q.config.VMMUser)
+		if err != nil {
+			return err
+		}
+		q.vmmUser = u
+	}
+
 	machine, err := q.getQemuMachine()
 	if err != nil {
 		return err
@@ -515,7 +724,18 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		Params:     q.kernelParameters(),
 	}
 
+	var hugePagesPath string
+	if q.config.HugePages {
+		if err := q.setupHugePages(&memory); err != nil {
+			return err
+		}
+		hugePagesPath = memory.Path
+	}
+
 	incoming := q.setupTemplate(&knobs, &memory)
+	if q.config.IncomingMigration {
+		incoming.MigrationType = govmmQemu.MigrationDefer
+	}
 
 	// With the current implementations, VM templating will not work with file
 	// based memory (stand-alone) or virtiofs. This is because VM templating
@@ -533,6 +753,31 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		}
 	}
 
+	if q.config.HugePages {
+		// govmm always backs the huge pages with /dev/hugepages, they
+		// are backed by the files of the selected hugetlbfs mount
+		// instead, which takes precedence over the other backends.
+		knobs.HugePages = false
+		knobs.FileBackedMem = true
+		memory.Path = hugePagesPath
+	}
+
+	if q.vmmUser != nil && knobs.FileBackedMem {
+		// QEMU creates the memory backend files in a directory owned by
+		// the hypervisor user.
+		q.vmmMemoryDir = filepath.Join(memory.Path, "kata-"+q.id)
+		memory.Path = q.vmmMemoryDir
+	}
+
+	if q.config.MemPrealloc && q.config.MemPreallocAsync {
+		if knobs.FileBackedMem && !q.config.HugePages {
+			knobs.MemPrealloc = false
+			q.memPreallocDir = memory.Path
+		} else {
+			q.Logger().Warn("Asynchronous memory pre-allocation requires file backed memory without huge pages, pre-allocating the memory at boot")
+		}
+	}
+
 	rtc := govmmQemu.RTC{
 		Base:     "utc",
 		DriftFix: "slew",
@@ -564,6 +809,20 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		return err
 	}
 
+	q.compat = newQemuCompat(qemuPath, q.Logger())
+	q.arch.setCompat(q.compat)
+
+	// An unknown version may still provide the EPC backend, let QEMU fail
+	// at launch if it does not.
+	if q.config.SGXEPCSize != 0 && !q.compat.version.isZero() && !q.compat.supports(qemuFeatureSGXEPC) {
+		return fmt.Errorf("SGX enclaves require QEMU %s or later, %s is %s",
+			qemuFeatureSGXEPC.added, qemuPath, q.compat.version)
+	}
+
+	if !q.compat.supportsMachine(machine.Type) {
+		return fmt.Errorf("Machine type %s is not supported by %s", machine.Type, qemuPath)
+	}
+
 	qemuConfig := govmmQemu.Config{
 		Name:        fmt.Sprintf("sandbox-%s", q.id),
 		UUID:        q.state.UUID,
@@ -582,12 +841,16 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		VGA:         "none",
 		GlobalParam: "kvm-pit.lost_tick_policy=discard",
 		Bios:        firmwarePath,
-		PidFile:     filepath.Join(store.RunVMStoragePath, q.id, "pid"),
+		PidFile:     filepath.Join(sandboxTmpDir(q.id), "pid"),
 	}
 
 	if ioThread != nil {
 		qemuConfig.IOThreads = []govmmQemu.IOThread{*ioThread}
 	}
+
+	if q.vmmUser != nil {
+		qemuConfig.Devices = append(qemuConfig.Devices, qemuRunAs(q.vmmUser.name))
+	}
 	// Add RNG device to hypervisor
 	rngDev := config.RNGDev{
 		ID:       rngID,
@@ -598,20 +861,33 @@ func (q *qemu) createSandbox(ctx context.Context, id string, networkNS NetworkNa
 		return err
 	}
 
+	if sandbox := q.seccompSandbox(qemuPath); sandbox != "" {
+		qemuConfig.Devices = append(qemuConfig.Devices, qemuSeccompSandbox(sandbox))
+	}
+
 	q.qemuConfig = qemuConfig
 
 	return nil
 }
 
 func (q *qemu) vhostFSSocketPath(id string) (string, error) {
-	return utils.BuildSocketPath(store.RunVMStoragePath, id, vhostFSSocket)
+	return utils.BuildSocketPath(sandboxTmpDir(id), vhostFSSocket)
+}
+
+// virtioFSSocketPath returns the vhost-user socket of the virtio-fs device
+// tagged tag, that is either the sandbox shared directory or a volume.
+func (q *qemu) virtioFSSocketPath(tag string) (string, error) {
+	if tag == mountGuest9pTag {
+		return q.vhostFSSocketPath(q.id)
+	}
+
+	return utils.BuildSocketPath(sandboxTmpDir(q.id), fmt.Sprintf(vhostFSVolumeSocket, tag))
 }
 
-func (q *qemu) virtiofsdArgs(sockPath string) []string {
+func (q *qemu) virtiofsdArgs(sockPath, sourcePath string) []string {
 	// The daemon will terminate when the vhost-user socket
 	// connection with QEMU closes.  Therefore we do not keep track
 	// of this child process after returning from this function.
-	sourcePath := filepath.Join(kataHostSharedDir, q.id)
 	args := []string{
 		"-o", "vhost_user_socket=" + sockPath,
 		"-o", "source=" + sourcePath,
@@ -634,20 +910,76 @@ func (q *qemu) setupVirtiofsd(timeout int) (remain int, err error) {
 		return 0, err
 	}
 
-	cmd := exec.Command(q.config.VirtioFSDaemon, q.virtiofsdArgs(sockPath)...)
-	stderr, err := cmd.StderrPipe()
+	pid, remain, err := q.startVirtiofsd(sockPath, filepath.Join(kataHostSharedDir, q.id), timeout)
 	if err != nil {
 		return 0, err
 	}
+	q.state.VirtiofsdPid = pid
 
-	if err = cmd.Start(); err != nil {
-		return 0, err
+	return remain, nil
+}
+
+// setupVolumeVirtiofsd starts the virtiofsd daemons of the virtio-fs
+// volumes, each one sharing its volume through its own socket.
+func (q *qemu) setupVolumeVirtiofsd(timeout int) (remain int, err error) {
+	defer func() {
+		if err != nil {
+			q.stopVolumeVirtiofsd()
+		}
+	}()
+
+	for _, v := range q.config.VirtioFSVolumes {
+		var sockPath string
+		sockPath, err = q.virtioFSSocketPath(v.MountTag)
+		if err != nil {
+			return 0, err
+		}
+
+		var pid int
+		pid, timeout, err = q.startVirtiofsd(sockPath, v.HostPath, timeout)
+		if err != nil {
+			return 0, err
+		}
+
+		if q.state.VolumeVirtiofsdPids == nil {
+			q.state.VolumeVirtiofsdPids = make(map[string]int)
+		}
+		q.state.VolumeVirtiofsdPids[v.MountTag] = pid
+	}
+
+	return timeout, nil
+}
+
+// stopVolumeVirtiofsd stops the virtiofsd daemons of the virtio-fs volumes.
+func (q *qemu) stopVolumeVirtiofsd() {
+	for tag, pid := range q.state.VolumeVirtiofsdPids {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			q.Logger().WithError(err).WithFields(logrus.Fields{
+				"tag": tag,
+				"pid": pid,
+			}).Warn("failed to stop virtiofsd")
+		}
+	}
+
+	q.state.VolumeVirtiofsdPids = nil
+}
+
+// startVirtiofsd starts a virtiofsd daemon sharing sourcePath through
+// sockPath, and waits for it to be ready. It returns the daemon pid and the
+// remaining timeout.
+func (q *qemu) startVirtiofsd(sockPath, sourcePath string, timeout int) (pid int, remain int, err error) {
+	cmd := exec.Command(q.config.VirtioFSDaemon, q.virtiofsdArgs(sockPath, sourcePath)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = withVMMRlimits(q.config.VMMRlimits, cmd.Start); err != nil {
+		return 0, 0, err
 	}
 	defer func() {
 		if err != nil {
 			cmd.Process.Kill()
-		} else {
-			q.state.VirtiofsdPid = cmd.Process.Pid
 		}
 	}()
 
@@ -673,14 +1005,19 @@ func (q *qemu) setupVirtiofsd(timeout int) (remain int, err error) {
 				sockReady <- fmt.Errorf("virtiofsd did not announce socket connection")
 			}
 		}
-		q.Logger().Info("virtiofsd quits")
+		q.Logger().WithField("shared-path", sourcePath).Info("virtiofsd quits")
 		// Wait to release resources of virtiofsd process
 		cmd.Process.Wait()
 		q.stopSandbox()
 	}()
 
-	return q.waitVirtiofsd(timeStart, timeout, sockReady,
+	remain, err = q.waitVirtiofsd(timeStart, timeout, sockReady,
 		fmt.Sprintf("virtiofsd (pid=%d) socket %s", cmd.Process.Pid, sockPath))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return cmd.Process.Pid, remain, nil
 }
 
 func (q *qemu) waitVirtiofsd(start time.Time, timeout int, ready chan error, errMsg string) (int, error) {
@@ -732,41 +1069,86 @@ func (q *qemu) startSandbox(timeout int) error {
 		q.fds = []*os.File{}
 	}()
 
-	vmPath := filepath.Join(store.RunVMStoragePath, q.id)
+	vmPath := sandboxTmpDir(q.id)
 	err := os.MkdirAll(vmPath, store.DirMode)
 	if err != nil {
 		return err
 	}
+
+	if q.vmmUser != nil {
+		if err = q.setupVMMMemoryDir(); err != nil {
+			return err
+		}
+	}
 	// append logfile only on debug
 	if q.config.Debug {
-		q.qemuConfig.LogFile = filepath.Join(vmPath, "qemu.log")
+		if err = q.startLog(); err != nil {
+			return err
+		}
+		q.qemuConfig.LogFile = logPipePath(q.log.path)
 	}
 
+	// Adapted once all the devices are added.
+	q.qemuConfig.Devices = q.compat.adaptDevices(q.qemuConfig.Devices, q.Logger())
+	q.qemuConfig.Devices = planDevices(q.qemuConfig.Devices)
+
 	defer func() {
 		if err != nil {
-			if err := os.RemoveAll(vmPath); err != nil {
+			q.log.close()
+			q.log = nil
+			if err := removeVMDir(vmPath); err != nil {
 				q.Logger().WithError(err).Error("Fail to clean up vm directory")
 			}
 		}
 	}()
 
 	if q.config.SharedFS == config.VirtioFS {
-		timeout, err = q.setupVirtiofsd(timeout)
+		// virtiofsd takes from the QEMU start timeout, unless it has
+		// its own.
+		virtiofsdTimeout := timeout
+		if q.config.VirtiofsdStartTimeout > 0 {
+			virtiofsdTimeout = int(q.config.VirtiofsdStartTimeout)
+		}
+
+		var remain int
+		err = runBootPhase(span, q.Logger(), bootPhaseVirtiofsd, func() (err error) {
+			if remain, err = q.setupVirtiofsd(virtiofsdTimeout); err != nil {
+				return err
+			}
+			remain, err = q.setupVolumeVirtiofsd(remain)
+			return err
+		})
 		if err != nil {
 			return err
 		}
+		if q.config.VirtiofsdStartTimeout == 0 {
+			timeout = remain
+		}
+		defer func() {
+			if err != nil {
+				q.stopVolumeVirtiofsd()
+			}
+		}()
 		if err = q.storeState(); err != nil {
 			return err
 		}
 	}
 
-	var strErr string
-	strErr, err = govmmQemu.LaunchQemu(q.qemuConfig, newQMPLogger())
-	if err != nil {
-		return fmt.Errorf("fail to launch qemu: %s, error messages from qemu log: %s", err, strErr)
-	}
+	err = runBootPhase(span, q.Logger(), bootPhaseHypervisor, func() error {
+		var strErr string
+		logger := q.newQMPLogger()
 
-	err = q.waitSandbox(timeout) // the virtiofsd deferred checks err's value
+		err := withVMMRlimits(q.config.VMMRlimits, func() (launchErr error) {
+			strErr, launchErr = q.launchQemu(logger)
+			return launchErr
+		})
+		if err != nil {
+			return fmt.Errorf("fail to launch qemu: %s, error messages from qemu log: %s", err, strErr)
+		}
+		q.launchStderr = strErr
+
+		return q.waitSandbox(timeout)
+	}) // the virtiofsd deferred checks err's value
 	if err != nil {
 		return err
 	}
@@ -777,6 +1159,10 @@ func (q *qemu) startSandbox(timeout int) error {
 		}
 	}
 
+	if q.memPreallocDir != "" {
+		q.startMemPrealloc()
+	}
+
 	return err
 }
 
@@ -787,19 +1173,68 @@ func (q *qemu) bootFromTemplate() error {
 	}
 	defer q.qmpShutdown()
 
-	err = q.arch.setIgnoreSharedMemoryMigrationCaps(q.qmpMonitorCh.ctx, q.qmpMonitorCh.qmp)
+	err = q.setIgnoreSharedMemoryMigrationCaps()
 	if err != nil {
 		q.Logger().WithError(err).Error("set migration ignore shared memory")
 		return err
 	}
 	uri := fmt.Sprintf("exec:cat %s", q.config.DevicesStatePath)
-	err = q.qmpMonitorCh.qmp.ExecuteMigrationIncoming(q.qmpMonitorCh.ctx, uri)
+	err = qmpExecute("migrate-incoming", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteMigrationIncoming(q.qmpMonitorCh.ctx, uri)
+	})
 	if err != nil {
 		return err
 	}
 	return q.waitMigration()
 }
 
+// setIgnoreSharedMemoryMigrationCaps keeps the shared memory out of the
+// migration stream of the templates. The whole memory is migrated instead
+// when QEMU lacks the capability, which the templates and their clones
+// agree on as they run the same binary.
+func (q *qemu) setIgnoreSharedMemoryMigrationCaps() error {
+	if !q.compat.supportsMigrationCapability(qmpCapMigrationIgnoreShared) {
+		q.Logger().WithField("feature", qmpCapMigrationIgnoreShared).Warn("Feature not supported by this QEMU binary, migrating the shared memory")
+		return nil
+	}
+
+	return q.arch.setIgnoreSharedMemoryMigrationCaps(q.qmpMonitorCh.ctx, q.qmpMonitorCh.qmp)
+}
+
+// probeCapabilities probes the capabilities of the QEMU binary from its
+// first VM, for the next sandboxes to use them.
+func (q *qemu) probeCapabilities() {
+	if q.compat.caps != nil || q.compat.digest == "" {
+		return
+	}
+
+	ext, err := q.qmpExt()
+	if err != nil {
+		q.Logger().WithError(err).Warn("Could not probe the QEMU capabilities")
+		return
+	}
+
+	caps, err := probeQemuCapabilities(q.qmpMonitorCh.ctx, ext)
+	if err != nil {
+		q.Logger().WithError(err).Warn("Could not probe the QEMU capabilities")
+		return
+	}
+
+	if err := saveQemuCapabilities(q.compat.digest, caps); err != nil {
+		q.Logger().WithError(err).Warn("Could not cache the QEMU capabilities")
+	}
+
+	q.compat.caps = caps
+	q.arch.setCompat(q.compat)
+
+	q.Logger().WithFields(logrus.Fields{
+		"qemu-digest":    q.compat.digest,
+		"machines":       len(caps.Machines),
+		"qmp-commands":   len(caps.Commands),
+		"migration-caps": strings.Join(caps.MigrationCapabilities, ","),
+	}).Info("Probed QEMU capabilities")
+}
+
 // waitSandbox will wait for the Sandbox's VM to be up and running.
 func (q *qemu) waitSandbox(timeout int) error {
 	span, _ := q.trace("waitSandbox")
@@ -809,7 +1244,7 @@ func (q *qemu) waitSandbox(timeout int) error {
 		return fmt.Errorf("Invalid timeout %ds", timeout)
 	}
 
-	cfg := govmmQemu.QMPConfig{Logger: newQMPLogger()}
+	cfg := govmmQemu.QMPConfig{Logger: q.newQMPLogger()}
 
 	var qmp *govmmQemu.QMP
 	var disconnectCh chan struct{}
@@ -827,7 +1262,7 @@ func (q *qemu) waitSandbox(timeout int) error {
 		}
 
 		if int(time.Since(timeStart).Seconds()) > timeout {
-			return fmt.Errorf("Failed to connect to QEMU instance (timeout %ds): %v", timeout, err)
+			return q.diagnoseQMPFailure(fmt.Errorf("Failed to connect to QEMU instance (timeout %ds): %v", timeout, err))
 		}
 
 		time.Sleep(time.Duration(50) * time.Millisecond)
@@ -836,9 +1271,18 @@ func (q *qemu) waitSandbox(timeout int) error {
 	q.qmpMonitorCh.disconn = disconnectCh
 	defer q.qmpShutdown()
 
+	if err = q.placeInSlice(); err != nil {
+		return err
+	}
+
 	qemuMajorVersion = ver.Major
 	qemuMinorVersion = ver.Minor
 
+	if q.compat.version.isZero() {
+		q.compat.version = qemuVersion{ver.Major, ver.Minor, ver.Micro}
+		q.arch.setCompat(q.compat)
+	}
+
 	q.Logger().WithFields(logrus.Fields{
 		"qmp-major-version": ver.Major,
 		"qmp-minor-version": ver.Minor,
@@ -846,11 +1290,15 @@ func (q *qemu) waitSandbox(timeout int) error {
 		"qmp-capabilities":  strings.Join(ver.Capabilities, ","),
 	}).Infof("QMP details")
 
-	if err = q.qmpMonitorCh.qmp.ExecuteQMPCapabilities(q.qmpMonitorCh.ctx); err != nil {
+	if err = qmpExecute("qmp_capabilities", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteQMPCapabilities(q.qmpMonitorCh.ctx)
+	}); err != nil {
 		q.Logger().WithError(err).Error(qmpCapErrMsg)
 		return err
 	}
 
+	q.probeCapabilities()
+
 	return nil
 }
 
@@ -865,42 +1313,98 @@ func (q *qemu) stopSandbox() error {
 		return nil
 	}
 
+	// Set first, as virtiofsd stops the sandbox again when it exits.
+	q.stopped = true
+
 	defer func() {
+		q.stopVolumeVirtiofsd()
 		q.cleanupVM()
-		q.stopped = true
 	}()
 
-	if q.config.Debug && q.qemuConfig.LogFile != "" {
-		f, err := os.OpenFile(q.qemuConfig.LogFile, os.O_RDONLY, 0)
-		if err == nil {
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				q.Logger().Debug(scanner.Text())
-			}
-			if err := scanner.Err(); err != nil {
-				q.Logger().WithError(err).Debug("read qemu log failed")
-			}
+	if q.memPrealloc != nil {
+		q.memPrealloc.stop()
+	}
+
+	if q.config.Debug && q.log != nil {
+		scrubber := newLogScrubber(q.config.LogScrubParams)
+		err := replayLog(q.log.path, q.config.logReplaySize(), scrubber, func(line string) {
+			q.Logger().Debug(line)
+		})
+		if err != nil {
+			q.Logger().WithError(err).Debug("read qemu log failed")
 		}
 	}
 
+	// The pid file is removed with the VM directory.
+	pid := q.getPids()[0]
+
 	err := q.qmpSetup()
+	if err == nil {
+		err = qmpExecute("quit", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteQuit(q.qmpMonitorCh.ctx)
+		})
+	}
 	if err != nil {
-		return err
+		q.Logger().WithError(err).Error("Fail to execute qmp QUIT")
+		if pid == 0 {
+			return err
+		}
 	}
 
-	err = q.qmpMonitorCh.qmp.ExecuteQuit(q.qmpMonitorCh.ctx)
+	return q.stopVMMProcesses(pid)
+}
+
+// stopVMMProcesses waits for QEMU, asked to quit, and for virtiofsd, which
+// exits once QEMU closes its socket, and kills them if they do not exit in
+// time.
+func (q *qemu) stopVMMProcesses(pid int) error {
+	for _, p := range []struct {
+		name string
+		pid  int
+	}{
+		{"qemu", pid},
+		{"virtiofsd", q.state.VirtiofsdPid},
+	} {
+		if p.pid == 0 {
+			continue
+		}
+
+		killed, err := stopProcess(p.pid, vmmQuitTimeout)
+		if err != nil {
+			return err
+		}
+
+		if killed {
+			q.Logger().WithFields(logrus.Fields{
+				"process": p.name,
+				"pid":     p.pid,
+				"timeout": vmmQuitTimeout,
+			}).Warn("Process did not exit in time, killed")
+		}
+	}
+
+	return nil
+}
+
+// startLog starts copying the QEMU log to the VM directory.
+func (q *qemu) startLog() error {
+	l, err := startHypervisorLog(filepath.Join(sandboxTmpDir(q.id), "qemu.log"), q.config.logMaxSize())
 	if err != nil {
-		q.Logger().WithError(err).Error("Fail to execute qmp QUIT")
 		return err
 	}
 
+	q.log = l
+
 	return nil
 }
 
 func (q *qemu) cleanupVM() error {
 
+	q.log.close()
+	q.log = nil
+
 	// cleanup vm path
-	dir := filepath.Join(store.RunVMStoragePath, q.id)
+	dir := sandboxTmpDir(q.id)
 
 	// If it's a symlink, remove both dir and the target.
 	// This can happen when vm template links a sandbox to a vm.
@@ -911,9 +1415,15 @@ func (q *qemu) cleanupVM() error {
 	}
 	q.Logger().WithField("link", link).WithField("dir", dir).Infof("cleanup vm path")
 
-	if err := os.RemoveAll(dir); err != nil {
+	if err := removeVMDir(dir); err != nil {
 		q.Logger().WithError(err).Warnf("failed to remove vm path %s", dir)
 	}
+	q.revokeVMMUserGrants()
+	if q.vmmMemoryDir != "" {
+		if err := os.Remove(q.vmmMemoryDir); err != nil && !os.IsNotExist(err) {
+			q.Logger().WithError(err).WithField("dir", q.vmmMemoryDir).Warn("failed to remove memory backend directory")
+		}
+	}
 	if link != dir && link != "" {
 		if err := os.RemoveAll(link); err != nil {
 			q.Logger().WithError(err).WithField("link", link).Warn("failed to remove resolved vm path")
@@ -944,9 +1454,13 @@ func (q *qemu) togglePauseSandbox(pause bool) error {
 	}
 
 	if pause {
-		err = q.qmpMonitorCh.qmp.ExecuteStop(q.qmpMonitorCh.ctx)
+		err = qmpExecute("stop", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteStop(q.qmpMonitorCh.ctx)
+		})
 	} else {
-		err = q.qmpMonitorCh.qmp.ExecuteCont(q.qmpMonitorCh.ctx)
+		err = qmpExecute("cont", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteCont(q.qmpMonitorCh.ctx)
+		})
 	}
 
 	if err != nil {
@@ -961,7 +1475,7 @@ func (q *qemu) qmpSetup() error {
 		return nil
 	}
 
-	cfg := govmmQemu.QMPConfig{Logger: newQMPLogger()}
+	cfg := govmmQemu.QMPConfig{Logger: q.newQMPLogger()}
 
 	// Auto-closed by QMPStart().
 	disconnectCh := make(chan struct{})
@@ -972,7 +1486,9 @@ func (q *qemu) qmpSetup() error {
 		return err
 	}
 
-	err = qmp.ExecuteQMPCapabilities(q.qmpMonitorCh.ctx)
+	err = qmpExecute("qmp_capabilities", func() error {
+		return qmp.ExecuteQMPCapabilities(q.qmpMonitorCh.ctx)
+	})
 	if err != nil {
 		qmp.Shutdown()
 		q.Logger().WithError(err).Error(qmpCapErrMsg)
@@ -984,7 +1500,47 @@ func (q *qemu) qmpSetup() error {
 	return nil
 }
 
+// qmpExt returns the client of the QMP commands govmm does not provide,
+// connected until qmpShutdown.
+func (q *qemu) qmpExt() (*qmp.Client, error) {
+	if q.qmpMonitorCh.ext != nil {
+		return q.qmpMonitorCh.ext, nil
+	}
+
+	if q.qmpMonitorCh.extPath == "" {
+		return nil, errors.Wrap(vcTypes.ErrNotSupported, "no extra QMP monitor")
+	}
+
+	ctx, cancel := context.WithTimeout(q.qmpMonitorCh.ctx, qmpExtDialTimeout)
+	defer cancel()
+
+	ext, err := qmp.Dial(ctx, q.qmpMonitorCh.extPath)
+	if err != nil {
+		q.Logger().WithError(err).Error("Failed to connect to the extra QMP monitor")
+		return nil, err
+	}
+	q.qmpMonitorCh.ext = ext
+
+	return ext, nil
+}
+
+// qmpExecutor returns the extra QMP monitor client to the arch, without
+// handing it a typed nil on failure.
+func (q *qemu) qmpExecutor() (qmpExecutor, error) {
+	ext, err := q.qmpExt()
+	if err != nil {
+		return nil, err
+	}
+
+	return ext, nil
+}
+
 func (q *qemu) qmpShutdown() {
+	if q.qmpMonitorCh.ext != nil {
+		q.qmpMonitorCh.ext.Close()
+		q.qmpMonitorCh.ext = nil
+	}
+
 	if q.qmpMonitorCh.qmp != nil {
 		q.qmpMonitorCh.qmp.Shutdown()
 		// wait on disconnected channel to be sure that the qmp channel has
@@ -995,123 +1551,385 @@ func (q *qemu) qmpShutdown() {
 	}
 }
 
+// blockDeviceSize returns the size in bytes of a block device or of a
+// regular file backing a drive.
+func blockDeviceSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if st.Mode().IsRegular() {
+		return st.Size(), nil
+	}
+
+	var blocksize int64
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&blocksize))); errno != 0 {
+		return 0, errno
+	}
+
+	return blocksize, nil
+}
+
+func (q *qemu) hotplugAddVirtioPmemDevice(drive *config.BlockDrive, devID string) (err error) {
+	size, err := blockDeviceSize(drive.File)
+	if err != nil {
+		return err
+	}
+
+	addr, bridge, err := q.arch.hotplugAddDeviceToBridge(q.qmpMonitorCh.ctx, drive.ID, types.PCI)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			q.arch.removeDeviceFromBridge(drive.ID)
+		}
+	}()
+
+	if err = q.virtioPmemDeviceAdd(virtioPmemMemID(drive.ID), devID, drive.File, addr, bridge.ID, size); err != nil {
+		q.Logger().WithError(err).Errorf("Failed to add virtio-pmem device %s", drive.File)
+		return err
+	}
+
+	// PCI address is in the format bridge-addr/device-addr eg. "03/02"
+	drive.PCIAddr = fmt.Sprintf("%02x", bridge.Addr) + "/" + addr
+
+	return nil
+}
+
+// virtioPmemDeviceAdd hotplugs the virtio-pmem device devID on the PCI bus,
+// at addr, mapping the file mempath of size bytes through the memory backend
+// memID. The memory backend is removed if the device cannot be added.
+func (q *qemu) virtioPmemDeviceAdd(memID, devID, mempath, addr, bus string, size int64) error {
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"qom-type": "memory-backend-file",
+		"id":       memID,
+		"props": map[string]interface{}{
+			"mem-path": mempath,
+			"size":     size,
+			"share":    true,
+		},
+	}
+	if err := ext.Execute(q.qmpMonitorCh.ctx, "object-add", args, nil); err != nil {
+		return err
+	}
+
+	args = map[string]interface{}{
+		"driver": "virtio-pmem-pci",
+		"id":     devID,
+		"memdev": memID,
+		"addr":   addr,
+		"bus":    bus,
+	}
+	if err := ext.Execute(q.qmpMonitorCh.ctx, "device_add", args, nil); err != nil {
+		if delErr := ext.Execute(q.qmpMonitorCh.ctx, "object-del", map[string]interface{}{"id": memID}, nil); delErr != nil {
+			q.Logger().WithError(delErr).WithField("memdev", memID).Warn("Could not remove the memory backend of the virtio-pmem device")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// allocNvdimmID returns the id of the next nvdimm device, that is the index
+// of its /dev/pmem device in the guest. The guest reuses the lowest index
+// released by an unplugged nvdimm device.
+func (q *qemu) allocNvdimmID() int {
+	if len(q.state.NvdimmFreeIDs) > 0 {
+		id := q.state.NvdimmFreeIDs[0]
+		q.state.NvdimmFreeIDs = q.state.NvdimmFreeIDs[1:]
+		return id
+	}
+
+	id := q.state.NvdimmCount
+	q.state.NvdimmCount++
+	return id
+}
+
+// releaseNvdimmID makes the id of an unplugged nvdimm device available.
+func (q *qemu) releaseNvdimmID(id int) {
+	s := &q.state
+	if id < 0 || id >= s.NvdimmCount {
+		return
+	}
+
+	i := sort.SearchInts(s.NvdimmFreeIDs, id)
+	if i < len(s.NvdimmFreeIDs) && s.NvdimmFreeIDs[i] == id {
+		return
+	}
+	s.NvdimmFreeIDs = append(s.NvdimmFreeIDs, 0)
+	copy(s.NvdimmFreeIDs[i+1:], s.NvdimmFreeIDs[i:])
+	s.NvdimmFreeIDs[i] = id
+
+	// Shrink the count rather than keeping track of the trailing ids.
+	for n := len(s.NvdimmFreeIDs); n > 0 && s.NvdimmFreeIDs[n-1] == s.NvdimmCount-1; n-- {
+		s.NvdimmFreeIDs = s.NvdimmFreeIDs[:n-1]
+		s.NvdimmCount--
+	}
+}
+
+func (q *qemu) hotplugRemoveNvdimmDevice(drive *config.BlockDrive) error {
+	id, err := strconv.Atoi(drive.NvdimmID)
+	if err != nil {
+		return fmt.Errorf("Invalid nvdimm id %q for drive %s: %v", drive.NvdimmID, drive.ID, err)
+	}
+
+	// The memory backend can only be removed once the device is, which
+	// device_del waits for.
+	if err := qmpExecute("device_del", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, "nvdimm"+drive.ID)
+	}); err != nil {
+		q.Logger().WithError(err).Errorf("Failed to remove NVDIMM device %s", drive.File)
+		return err
+	}
+
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
+
+	if err := ext.Execute(q.qmpMonitorCh.ctx, "object-del", map[string]interface{}{"id": "nvdimmbackmem" + drive.ID}, nil); err != nil {
+		q.Logger().WithError(err).Errorf("Failed to remove the memory backend of NVDIMM device %s", drive.File)
+		return err
+	}
+
+	q.releaseNvdimmID(id)
+
+	return nil
+}
+
 func (q *qemu) hotplugAddBlockDevice(drive *config.BlockDrive, op operation, devID string) (err error) {
+	if q.config.BlockDeviceDriver == config.Nvdimm || q.config.BlockDeviceDriver == config.VirtioPmem {
+		if drive.Format != "" && drive.Format != config.BlockFormatRaw {
+			return errors.Wrapf(vcTypes.ErrNotSupported, "%s devices only support raw images, not %s", q.config.BlockDeviceDriver, drive.Format)
+		}
+		if drive.ReadOnly {
+			q.Logger().WithField("drive", drive.ID).Warnf("%s devices can't be write protected", q.config.BlockDeviceDriver)
+		}
+	}
+
 	if q.config.BlockDeviceDriver == config.Nvdimm {
-		var blocksize int64
-		file, err := os.Open(drive.File)
+		blocksize, err := blockDeviceSize(drive.File)
 		if err != nil {
 			return err
 		}
-		if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&blocksize))); err != 0 {
-			return err
-		}
-		if err = q.qmpMonitorCh.qmp.ExecuteNVDIMMDeviceAdd(q.qmpMonitorCh.ctx, drive.ID, drive.File, blocksize); err != nil {
+		if err = qmpExecute("device_add", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteNVDIMMDeviceAdd(q.qmpMonitorCh.ctx, drive.ID, drive.File, blocksize)
+		}); err != nil {
 			q.Logger().WithError(err).Errorf("Failed to add NVDIMM device %s", drive.File)
 			return err
 		}
-		drive.NvdimmID = strconv.Itoa(q.nvdimmCount)
-		q.nvdimmCount++
+		drive.NvdimmID = strconv.Itoa(q.allocNvdimmID())
 		return nil
 	}
 
-	if q.config.BlockDeviceCacheSet {
-		err = q.qmpMonitorCh.qmp.ExecuteBlockdevAddWithCache(q.qmpMonitorCh.ctx, drive.File, drive.ID, q.config.BlockDeviceCacheDirect, q.config.BlockDeviceCacheNoflush)
-	} else {
-		err = q.qmpMonitorCh.qmp.ExecuteBlockdevAdd(q.qmpMonitorCh.ctx, drive.File, drive.ID)
+	if q.config.BlockDeviceDriver == config.VirtioPmem {
+		return q.hotplugAddVirtioPmemDevice(drive, devID)
 	}
-	if err != nil {
+
+	if err = q.blockdevAdd(drive); err != nil {
 		return err
 	}
 
 	defer func() {
 		if err != nil {
-			q.qmpMonitorCh.qmp.ExecuteBlockdevDel(q.qmpMonitorCh.ctx, drive.ID)
+			qmpExecute("blockdev-del", func() error {
+				return q.qmpMonitorCh.qmp.ExecuteBlockdevDel(q.qmpMonitorCh.ctx, drive.ID)
+			})
 		}
 	}()
 
-	switch {
-	case q.config.BlockDeviceDriver == config.VirtioBlockCCW:
-		driver := "virtio-blk-ccw"
+	if err = q.arch.hotplugAddBlockDevice(q.qmpMonitorCh.ctx, q.qmpMonitorCh.qmp, drive, q.config.BlockDeviceDriver, devID); err != nil {
+		return err
+	}
 
-		addr, bridge, err := q.arch.addDeviceToBridge(drive.ID, types.CCW)
-		if err != nil {
-			return err
-		}
-		var devNoHotplug string
-		devNoHotplug, err = bridge.AddressFormatCCW(addr)
-		if err != nil {
-			return err
-		}
-		drive.DevNo, err = bridge.AddressFormatCCWForVirtServer(addr)
-		if err != nil {
-			return err
-		}
-		if err = q.qmpMonitorCh.qmp.ExecuteDeviceAdd(q.qmpMonitorCh.ctx, drive.ID, devID, driver, devNoHotplug, "", true, false); err != nil {
-			return err
-		}
-	case q.config.BlockDeviceDriver == config.VirtioBlock:
-		driver := "virtio-blk-pci"
-		addr, bridge, err := q.arch.addDeviceToBridge(drive.ID, types.PCI)
-		if err != nil {
+	if drive.IOLimits.IsSet() {
+		if err = q.blockSetIOThrottle(devID, drive.IOLimits); err != nil {
+			q.Logger().WithError(err).WithField("drive", drive.ID).Error("Failed to set block device I/O limits")
+			qmpExecute("device_del", func() error {
+				return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID)
+			})
+			if q.config.BlockDeviceDriver == config.VirtioBlock {
+				q.arch.removeDeviceFromBridge(drive.ID)
+			}
 			return err
 		}
+	}
 
-		defer func() {
-			if err != nil {
-				q.arch.removeDeviceFromBridge(drive.ID)
-			}
-		}()
+	return nil
+}
 
-		// PCI address is in the format bridge-addr/device-addr eg. "03/02"
-		drive.PCIAddr = fmt.Sprintf("%02x", bridge.Addr) + "/" + addr
+// blockdevAdd adds the block backend of drive. Write protected drives and
+// the image formats other than raw go through the extra QMP monitor, the
+// format being always given to QEMU so that it never probes it.
+func (q *qemu) blockdevAdd(drive *config.BlockDrive) error {
+	format := drive.Format
+	if format == "" {
+		format = config.BlockFormatRaw
+	}
 
-		if err = q.qmpMonitorCh.qmp.ExecutePCIDeviceAdd(q.qmpMonitorCh.ctx, drive.ID, devID, driver, addr, bridge.ID, romFile, 0, true, defaultDisableModern); err != nil {
-			return err
+	if format == config.BlockFormatRaw && !drive.ReadOnly {
+		if q.config.BlockDeviceCacheSet {
+			return qmpExecute("blockdev-add", func() error {
+				return q.qmpMonitorCh.qmp.ExecuteBlockdevAddWithCache(q.qmpMonitorCh.ctx, drive.File, drive.ID, q.config.BlockDeviceCacheDirect, q.config.BlockDeviceCacheNoflush)
+			})
 		}
-	case q.config.BlockDeviceDriver == config.VirtioSCSI:
-		driver := "scsi-hd"
+		return qmpExecute("blockdev-add", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteBlockdevAdd(q.qmpMonitorCh.ctx, drive.File, drive.ID)
+		})
+	}
+
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
 
-		// Bus exposed by the SCSI Controller
-		bus := scsiControllerID + ".0"
+	return ext.Execute(q.qmpMonitorCh.ctx, "blockdev-add", q.blockdevAddArgs(drive, format), nil)
+}
 
-		// Get SCSI-id and LUN based on the order of attaching drives.
-		scsiID, lun, err := utils.GetSCSIIdLun(drive.Index)
-		if err != nil {
-			return err
-		}
+// blockSetIOThrottle limits the I/O rate of the block device devID, through
+// the extra QMP monitor. A zero limit is no limit.
+func (q *qemu) blockSetIOThrottle(devID string, limits config.BlockIOLimits) error {
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
 
-		if err = q.qmpMonitorCh.qmp.ExecuteSCSIDeviceAdd(q.qmpMonitorCh.ctx, drive.ID, devID, driver, bus, romFile, scsiID, lun, true, defaultDisableModern); err != nil {
-			return err
+	args := map[string]interface{}{
+		"id":      devID,
+		"bps":     0,
+		"bps_rd":  limits.ReadBps,
+		"bps_wr":  limits.WriteBps,
+		"iops":    0,
+		"iops_rd": limits.ReadIops,
+		"iops_wr": limits.WriteIops,
+	}
+
+	return ext.Execute(q.qmpMonitorCh.ctx, "block_set_io_throttle", args, nil)
+}
+
+// blockdevAddArgs returns the blockdev-add arguments of drive in format.
+func (q *qemu) blockdevAddArgs(drive *config.BlockDrive, format string) map[string]interface{} {
+	args := map[string]interface{}{
+		"driver":    format,
+		"node-name": drive.ID,
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": drive.File,
+		},
+	}
+
+	if drive.ReadOnly {
+		args["read-only"] = true
+	}
+
+	if q.config.BlockDeviceCacheSet {
+		args["cache"] = map[string]interface{}{
+			"direct":   q.config.BlockDeviceCacheDirect,
+			"no-flush": q.config.BlockDeviceCacheNoflush,
 		}
-	default:
-		return fmt.Errorf("Block device %s not recognized", q.config.BlockDeviceDriver)
 	}
 
-	return nil
+	return args
 }
 
-func (q *qemu) hotplugBlockDevice(drive *config.BlockDrive, op operation) error {
-	err := q.qmpSetup()
+// hotplugRemoveSCSIDevice unplugs the scsi-hd device devID from the SCSI
+// controller. The SCSI address of the drive must match its index, which is
+// released once the drive has been unplugged.
+func (q *qemu) hotplugRemoveSCSIDevice(drive *config.BlockDrive, devID string) error {
+	scsiAddr, err := utils.GetSCSIAddress(drive.Index)
+	if err != nil {
+		return err
+	}
+
+	if drive.SCSIAddr != scsiAddr {
+		return fmt.Errorf("SCSI address %q of drive %s does not match its index %d", drive.SCSIAddr, drive.ID, drive.Index)
+	}
+
+	q.Logger().WithFields(logrus.Fields{
+		"drive":     drive.ID,
+		"scsi-addr": scsiAddr,
+	}).Info("Unplugging SCSI device")
+
+	if err := qmpExecute("device_del", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID)
+	}); err != nil {
+		return err
+	}
+
+	return qmpExecute("blockdev-del", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteBlockdevDel(q.qmpMonitorCh.ctx, drive.ID)
+	})
+}
+
+func (q *qemu) hotplugBlockDevice(drive *config.BlockDrive, op operation) (err error) {
+	// qemu cannot unplug virtio-pmem devices, the device and its memory
+	// backend stay in the VM until it is stopped.
+	if op == removeDevice && q.config.BlockDeviceDriver == config.VirtioPmem {
+		return errors.Wrapf(vcTypes.ErrNotSupported, "virtio-pmem device %s cannot be unplugged", drive.ID)
+	}
+
+	err = q.qmpSetup()
 	if err != nil {
 		return err
 	}
 
 	devID := "virtio-" + drive.ID
 
+	if q.vmmUser != nil {
+		// QEMU opens the file of the drive once unprivileged.
+		if op == addDevice {
+			if err = q.grantVMMUserAccess(drive.File); err != nil {
+				return err
+			}
+		}
+
+		defer func() {
+			if (op == addDevice && err != nil) || (op == removeDevice && err == nil) {
+				q.revokeVMMUserAccess(drive.File)
+			}
+		}()
+	}
+
 	if op == addDevice {
 		err = q.hotplugAddBlockDevice(drive, op, devID)
 	} else {
-		if q.config.BlockDeviceDriver == config.VirtioBlock {
+		if q.config.BlockDeviceDriver == config.Nvdimm {
+			return q.hotplugRemoveNvdimmDevice(drive)
+		}
+
+		if q.config.BlockDeviceDriver == config.VirtioSCSI {
+			return q.hotplugRemoveSCSIDevice(drive, devID)
+		}
+
+		if q.config.BlockDeviceDriver == config.VirtioBlock || q.config.BlockDeviceDriver == config.VirtioBlockCCW {
 			if err := q.arch.removeDeviceFromBridge(drive.ID); err != nil {
 				return err
 			}
 		}
 
-		if err := q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID); err != nil {
+		if err := qmpExecute("device_del", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID)
+		}); err != nil {
 			return err
 		}
 
-		if err := q.qmpMonitorCh.qmp.ExecuteBlockdevDel(q.qmpMonitorCh.ctx, drive.ID); err != nil {
+		if err := qmpExecute("blockdev-del", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteBlockdevDel(q.qmpMonitorCh.ctx, drive.ID)
+		}); err != nil {
 			return err
 		}
 	}
@@ -1127,6 +1945,26 @@ func (q *qemu) hotplugVFIODevice(device *config.VFIODev, op operation) (err erro
 
 	devID := device.ID
 
+	if q.vmmUser != nil {
+		// QEMU opens the VFIO group device once unprivileged.
+		var group string
+		if group, err = vfioGroupDevice(device); err != nil {
+			return err
+		}
+
+		if op == addDevice {
+			if err = q.grantVMMUserAccess(group); err != nil {
+				return err
+			}
+		}
+
+		defer func() {
+			if (op == addDevice && err != nil) || (op == removeDevice && err == nil) {
+				q.revokeVMMUserAccess(group)
+			}
+		}()
+	}
+
 	if op == addDevice {
 		// In case HotplugVFIOOnRootBus is true, devices are hotplugged on the root bus
 		// for pc machine type instead of bridge. This is useful for devices that require
@@ -1134,15 +1972,19 @@ func (q *qemu) hotplugVFIODevice(device *config.VFIODev, op operation) (err erro
 		if q.state.HotplugVFIOOnRootBus {
 			switch device.Type {
 			case config.VFIODeviceNormalType:
-				return q.qmpMonitorCh.qmp.ExecuteVFIODeviceAdd(q.qmpMonitorCh.ctx, devID, device.BDF, romFile)
+				return qmpExecute("device_add", func() error {
+					return q.qmpMonitorCh.qmp.ExecuteVFIODeviceAdd(q.qmpMonitorCh.ctx, devID, device.BDF, romFile)
+				})
 			case config.VFIODeviceMediatedType:
-				return q.qmpMonitorCh.qmp.ExecutePCIVFIOMediatedDeviceAdd(q.qmpMonitorCh.ctx, devID, device.SysfsDev, "", "", romFile)
+				return qmpExecute("device_add", func() error {
+					return q.qmpMonitorCh.qmp.ExecutePCIVFIOMediatedDeviceAdd(q.qmpMonitorCh.ctx, devID, device.SysfsDev, "", "", romFile)
+				})
 			default:
 				return fmt.Errorf("Incorrect VFIO device type found")
 			}
 		}
 
-		addr, bridge, err := q.arch.addDeviceToBridge(devID, types.PCI)
+		addr, bridge, err := q.arch.hotplugAddDeviceToBridge(q.qmpMonitorCh.ctx, devID, types.PCI)
 		if err != nil {
 			return err
 		}
@@ -1155,9 +1997,13 @@ func (q *qemu) hotplugVFIODevice(device *config.VFIODev, op operation) (err erro
 
 		switch device.Type {
 		case config.VFIODeviceNormalType:
-			return q.qmpMonitorCh.qmp.ExecutePCIVFIODeviceAdd(q.qmpMonitorCh.ctx, devID, device.BDF, addr, bridge.ID, romFile)
+			return qmpExecute("device_add", func() error {
+				return q.qmpMonitorCh.qmp.ExecutePCIVFIODeviceAdd(q.qmpMonitorCh.ctx, devID, device.BDF, addr, bridge.ID, romFile)
+			})
 		case config.VFIODeviceMediatedType:
-			return q.qmpMonitorCh.qmp.ExecutePCIVFIOMediatedDeviceAdd(q.qmpMonitorCh.ctx, devID, device.SysfsDev, addr, bridge.ID, romFile)
+			return qmpExecute("device_add", func() error {
+				return q.qmpMonitorCh.qmp.ExecutePCIVFIOMediatedDeviceAdd(q.qmpMonitorCh.ctx, devID, device.SysfsDev, addr, bridge.ID, romFile)
+			})
 		default:
 			return fmt.Errorf("Incorrect VFIO device type found")
 		}
@@ -1168,7 +2014,9 @@ func (q *qemu) hotplugVFIODevice(device *config.VFIODev, op operation) (err erro
 			}
 		}
 
-		if err := q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID); err != nil {
+		if err := qmpExecute("device_del", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID)
+		}); err != nil {
 			return err
 		}
 	}
@@ -1183,20 +2031,26 @@ func (q *qemu) hotAddNetDevice(name, hardAddr string, VMFds, VhostFds []*os.File
 	)
 	for i, VMFd := range VMFds {
 		fdName := fmt.Sprintf("fd%d", i)
-		if err := q.qmpMonitorCh.qmp.ExecuteGetFD(q.qmpMonitorCh.ctx, fdName, VMFd); err != nil {
+		if err := qmpExecute("getfd", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteGetFD(q.qmpMonitorCh.ctx, fdName, VMFd)
+		}); err != nil {
 			return err
 		}
 		VMFdNames = append(VMFdNames, fdName)
 	}
 	for i, VhostFd := range VhostFds {
 		fdName := fmt.Sprintf("vhostfd%d", i)
-		if err := q.qmpMonitorCh.qmp.ExecuteGetFD(q.qmpMonitorCh.ctx, fdName, VhostFd); err != nil {
+		if err := qmpExecute("getfd", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteGetFD(q.qmpMonitorCh.ctx, fdName, VhostFd)
+		}); err != nil {
 			return err
 		}
 		VhostFd.Close()
 		VhostFdNames = append(VhostFdNames, fdName)
 	}
-	return q.qmpMonitorCh.qmp.ExecuteNetdevAddByFds(q.qmpMonitorCh.ctx, "tap", name, VMFdNames, VhostFdNames)
+	return qmpExecute("netdev_add", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteNetdevAddByFds(q.qmpMonitorCh.ctx, "tap", name, VMFdNames, VhostFdNames)
+	})
 }
 
 func (q *qemu) hotplugNetDevice(endpoint Endpoint, op operation) (err error) {
@@ -1213,8 +2067,11 @@ func (q *qemu) hotplugNetDevice(endpoint Endpoint, op operation) (err error) {
 	case TapEndpointType:
 		drive := endpoint.(*TapEndpoint)
 		tap = drive.TapInterface
+	case TapFdEndpointType:
+		drive := endpoint.(*TapFdEndpoint)
+		tap = drive.TapInterface
 	default:
-		return fmt.Errorf("this endpoint is not supported")
+		return errors.Wrapf(vcTypes.ErrNotSupported, "endpoint type %s", endpoint.Type())
 	}
 
 	devID := "virtio-" + tap.ID
@@ -1225,51 +2082,104 @@ func (q *qemu) hotplugNetDevice(endpoint Endpoint, op operation) (err error) {
 
 		defer func() {
 			if err != nil {
-				q.qmpMonitorCh.qmp.ExecuteNetdevDel(q.qmpMonitorCh.ctx, tap.Name)
+				qmpExecute("netdev_del", func() error {
+					return q.qmpMonitorCh.qmp.ExecuteNetdevDel(q.qmpMonitorCh.ctx, tap.Name)
+				})
 			}
 		}()
 
-		addr, bridge, err := q.arch.addDeviceToBridge(tap.ID, types.PCI)
+		err = q.arch.hotplugAddNetDevice(q.qmpMonitorCh.ctx, q.qmpMonitorCh.qmp, endpoint, tap, devID, len(tap.VMFds))
 		if err != nil {
 			return err
 		}
 
-		defer func() {
-			if err != nil {
-				q.arch.removeDeviceFromBridge(tap.ID)
-			}
-		}()
-
-		pciAddr := fmt.Sprintf("%02x/%s", bridge.Addr, addr)
-		endpoint.SetPciAddr(pciAddr)
+		q.state.HotpluggedNetDevices = append(q.state.HotpluggedNetDevices, NetworkDevice{
+			ID:         tap.ID,
+			DevID:      devID,
+			Netdev:     tap.Name,
+			MACAddress: endpoint.HardwareAddr(),
+		})
+		return nil
+	}
 
-		var machine govmmQemu.Machine
-		machine, err = q.getQemuMachine()
-		if err != nil {
-			return err
-		}
-		if machine.Type == QemuCCWVirtio {
-			devNoHotplug := fmt.Sprintf("fe.%x.%x", bridge.Addr, addr)
-			return q.qmpMonitorCh.qmp.ExecuteNetCCWDeviceAdd(q.qmpMonitorCh.ctx, tap.Name, devID, endpoint.HardwareAddr(), devNoHotplug, int(q.config.NumVCPUs))
+	netdev := tap.Name
+	if dev := q.hotpluggedNetDevice(tap.ID); dev != nil {
+		// The file descriptors of the device are not restored with the
+		// endpoint, check it is the one that was hot-added.
+		if dev.MACAddress != endpoint.HardwareAddr() {
+			return fmt.Errorf("Network device %s has MAC address %s, not %s", dev.DevID, dev.MACAddress, endpoint.HardwareAddr())
 		}
-		return q.qmpMonitorCh.qmp.ExecuteNetPCIDeviceAdd(q.qmpMonitorCh.ctx, tap.Name, devID, endpoint.HardwareAddr(), addr, bridge.ID, romFile, int(q.config.NumVCPUs), defaultDisableModern)
+		devID = dev.DevID
+		netdev = dev.Netdev
+	}
 
+	// The extra QMP monitor returns the class of the errors, unlike govmm.
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
 	}
 
 	if err := q.arch.removeDeviceFromBridge(tap.ID); err != nil {
 		return err
 	}
 
-	if err := q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, devID); err != nil {
+	// The device and its backend may be gone already, if the previous
+	// runtime instance did not store the state once it removed them.
+	if err := qmpExecute("device_del", func() error {
+		return q.qmpDeviceDel(ext, devID)
+	}); err != nil && !qmp.IsErrorClass(err, qmp.ErrorClassDeviceNotFound) {
 		return err
 	}
-	if err := q.qmpMonitorCh.qmp.ExecuteNetdevDel(q.qmpMonitorCh.ctx, tap.Name); err != nil {
+	if err := qmpExecute("netdev_del", func() error {
+		return ext.Execute(q.qmpMonitorCh.ctx, "netdev_del", map[string]interface{}{"id": netdev}, nil)
+	}); err != nil && !qmp.IsErrorClass(err, qmp.ErrorClassDeviceNotFound) {
 		return err
 	}
 
+	q.removeHotpluggedNetDevice(tap.ID)
+
+	return nil
+}
+
+// hotpluggedNetDevice returns the hot-added network device of the TAP
+// interface ID, or nil.
+func (q *qemu) hotpluggedNetDevice(ID string) *NetworkDevice {
+	for i := range q.state.HotpluggedNetDevices {
+		if q.state.HotpluggedNetDevices[i].ID == ID {
+			return &q.state.HotpluggedNetDevices[i]
+		}
+	}
+
 	return nil
 }
 
+func (q *qemu) removeHotpluggedNetDevice(ID string) {
+	var devices []NetworkDevice
+	for _, dev := range q.state.HotpluggedNetDevices {
+		if dev.ID != ID {
+			devices = append(devices, dev)
+		}
+	}
+	q.state.HotpluggedNetDevices = devices
+}
+
+// qmpDeviceDel removes the device devID through the extra QMP monitor and
+// waits for QEMU to delete it, as govmm does.
+func (q *qemu) qmpDeviceDel(ext *qmp.Client, devID string) error {
+	if err := ext.Execute(q.qmpMonitorCh.ctx, "device_del", map[string]interface{}{"id": devID}, nil); err != nil {
+		return err
+	}
+
+	_, err := ext.WaitEvent(q.qmpMonitorCh.ctx, "DEVICE_DELETED", func(data json.RawMessage) bool {
+		var deleted struct {
+			Device string `json:"device"`
+		}
+		return json.Unmarshal(data, &deleted) == nil && deleted.Device == devID
+	})
+
+	return err
+}
+
 func (q *qemu) hotplugDevice(devInfo interface{}, devType deviceType, op operation) (interface{}, error) {
 	switch devType {
 	case blockDev:
@@ -1288,7 +2198,7 @@ func (q *qemu) hotplugDevice(devInfo interface{}, devType deviceType, op operati
 		device := devInfo.(Endpoint)
 		return nil, q.hotplugNetDevice(device, op)
 	default:
-		return nil, fmt.Errorf("cannot hotplug device: unsupported device type '%v'", devType)
+		return nil, errors.Wrapf(vcTypes.ErrNotSupported, "cannot hotplug device type '%v'", devType)
 	}
 }
 
@@ -1296,32 +2206,64 @@ func (q *qemu) hotplugAddDevice(devInfo interface{}, devType deviceType) (interf
 	span, _ := q.trace("hotplugAddDevice")
 	defer span.Finish()
 
+	bridges := q.bridgesCount()
+
+	start := time.Now()
 	data, err := q.hotplugDevice(devInfo, devType, addDevice)
+	observeHotplug(addDevice, devType, start, err)
+	auditLogFromContext(q.ctx).hotplug(addDevice, devType, devInfo, start, err)
 	if err != nil {
-		return data, err
+		// The bridge hotplugged for the device remains in the VM, it
+		// must not be hotplugged again by the next runtime instance.
+		if q.bridgesCount() != bridges {
+			if storeErr := q.storeState(); storeErr != nil {
+				q.Logger().WithError(storeErr).Error("Could not store the hotplugged bridges")
+			}
+		}
+		return data, newHotplugError(addDevice, devType, devInfo, err)
 	}
 
 	return data, q.storeState()
 }
 
+// bridgesCount returns the number of bridges of the VM, if it was set up.
+func (q *qemu) bridgesCount() int {
+	if q.arch == nil {
+		return 0
+	}
+
+	return len(q.arch.getBridges())
+}
+
 func (q *qemu) hotplugRemoveDevice(devInfo interface{}, devType deviceType) (interface{}, error) {
 	span, _ := q.trace("hotplugRemoveDevice")
 	defer span.Finish()
 
+	start := time.Now()
 	data, err := q.hotplugDevice(devInfo, devType, removeDevice)
+	observeHotplug(removeDevice, devType, start, err)
+	auditLogFromContext(q.ctx).hotplug(removeDevice, devType, devInfo, start, err)
 	if err != nil {
-		return data, err
+		return data, newHotplugError(removeDevice, devType, devInfo, err)
 	}
 
 	return data, q.storeState()
 }
 
+func (q *qemu) setBlockDeviceDriver(driver string) {
+	q.config.BlockDeviceDriver = driver
+}
+
 func (q *qemu) hotplugCPUs(vcpus uint32, op operation) (uint32, error) {
 	if vcpus == 0 {
 		q.Logger().Warnf("cannot hotplug 0 vCPUs")
 		return 0, nil
 	}
 
+	if !q.arch.supportGuestCPUHotplug() {
+		return 0, errors.Wrap(vcTypes.ErrNotSupported, "guest vCPU hotplug")
+	}
+
 	err := q.qmpSetup()
 	if err != nil {
 		return 0, err
@@ -1353,7 +2295,11 @@ func (q *qemu) hotplugAddCPUs(amount uint32) (uint32, error) {
 	}
 
 	// get the list of hotpluggable CPUs
-	hotpluggableVCPUs, err := q.qmpMonitorCh.qmp.ExecuteQueryHotpluggableCPUs(q.qmpMonitorCh.ctx)
+	var hotpluggableVCPUs []govmmQemu.HotpluggableCPU
+	err := qmpExecute("query-hotpluggable-cpus", func() (err error) {
+		hotpluggableVCPUs, err = q.qmpMonitorCh.qmp.ExecuteQueryHotpluggableCPUs(q.qmpMonitorCh.ctx)
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to query hotpluggable CPUs: %v", err)
 	}
@@ -1385,7 +2331,9 @@ func (q *qemu) hotplugAddCPUs(amount uint32) (uint32, error) {
 			dieID = ""
 		}
 
-		if err := q.qmpMonitorCh.qmp.ExecuteCPUDeviceAdd(q.qmpMonitorCh.ctx, driver, cpuID, socketID, dieID, coreID, threadID, romFile); err != nil {
+		if err := qmpExecute("device_add", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteCPUDeviceAdd(q.qmpMonitorCh.ctx, driver, cpuID, socketID, dieID, coreID, threadID, romFile)
+		}); err != nil {
 			// don't fail, let's try with other CPU
 			continue
 		}
@@ -1413,13 +2361,15 @@ func (q *qemu) hotplugRemoveCPUs(amount uint32) (uint32, error) {
 
 	// we can only remove hotplugged vCPUs
 	if amount > hotpluggedVCPUs {
-		return 0, fmt.Errorf("Unable to remove %d CPUs, currently there are only %d hotplugged CPUs", amount, hotpluggedVCPUs)
+		return 0, errors.Wrapf(vcTypes.ErrNotSupported, "Unable to remove %d CPUs, currently there are only %d hotplugged CPUs", amount, hotpluggedVCPUs)
 	}
 
 	for i := uint32(0); i < amount; i++ {
 		// get the last vCPUs and try to remove it
 		cpu := q.state.HotpluggedVCPUs[len(q.state.HotpluggedVCPUs)-1]
-		if err := q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, cpu.ID); err != nil {
+		if err := qmpExecute("device_del", func() error {
+			return q.qmpMonitorCh.qmp.ExecuteDeviceDel(q.qmpMonitorCh.ctx, cpu.ID)
+		}); err != nil {
 			q.storeState()
 			return i, fmt.Errorf("failed to hotunplug CPUs, only %d CPUs were hotunplugged: %v", i, err)
 		}
@@ -1434,7 +2384,7 @@ func (q *qemu) hotplugRemoveCPUs(amount uint32) (uint32, error) {
 func (q *qemu) hotplugMemory(memDev *memoryDevice, op operation) (int, error) {
 
 	if !q.arch.supportGuestMemoryHotplug() {
-		return 0, fmt.Errorf("guest memory hotplug not supported")
+		return 0, errors.Wrap(vcTypes.ErrNotSupported, "guest memory hotplug")
 	}
 	if memDev.sizeMB < 0 {
 		return 0, fmt.Errorf("cannot hotplug negative size (%d) memory", memDev.sizeMB)
@@ -1469,8 +2419,7 @@ func (q *qemu) hotplugMemory(memDev *memoryDevice, op operation) (int, error) {
 
 		// Don't exceed the maximum amount of memory
 		if currentMemory+memDev.sizeMB > int(maxMem) {
-			// Fixme: return a typed error
-			return 0, fmt.Errorf("Unable to hotplug %d MiB memory, the SB has %d MiB and the maximum amount is %d MiB",
+			return 0, errors.Wrapf(vcTypes.ErrResourceExhausted, "Unable to hotplug %d MiB memory, the SB has %d MiB and the maximum amount is %d MiB",
 				memDev.sizeMB, currentMemory, maxMem)
 		}
 		memoryAdded, err := q.hotplugAddMemory(memDev)
@@ -1502,10 +2451,10 @@ func (q *qemu) hotplugAddMemory(memDev *memoryDevice) (int, error) {
 		}
 		memDev.slot = maxSlot + 1
 	}
-	if q.qemuConfig.Knobs.HugePages {
-		// we are setting all the bits that govmm sets when hugepages are enabled.
-		// https://github.com/intel/govmm/blob/master/qemu/qemu.go#L1677
-		target = "/dev/hugepages"
+	if q.config.HugePages {
+		// The guest memory is backed by the files of the hugetlbfs
+		// mount, see createSandbox().
+		target = q.qemuConfig.Memory.Path
 		memoryBack = "memory-backend-file"
 		share = true
 	} else if q.config.SharedFS == config.VirtioFS || q.config.FileBackedMemRootDir != "" {
@@ -1562,15 +2511,8 @@ func (q *qemu) addDevice(devInfo interface{}, devType deviceType) error {
 		if q.config.SharedFS == config.VirtioFS {
 			q.Logger().WithField("volume-type", "virtio-fs").Info("adding volume")
 
-			var randBytes []byte
-			randBytes, err = utils.GenerateRandomBytes(8)
-			if err != nil {
-				return err
-			}
-			id := hex.EncodeToString(randBytes)
-
 			var sockPath string
-			sockPath, err = q.vhostFSSocketPath(q.id)
+			sockPath, err = q.virtioFSSocketPath(v.MountTag)
 			if err != nil {
 				return err
 			}
@@ -1582,7 +2524,8 @@ func (q *qemu) addDevice(devInfo interface{}, devType deviceType) error {
 				Cache:     q.config.VirtioFSCache,
 			}
 			vhostDev.SocketPath = sockPath
-			vhostDev.DevID = id
+			// The tag keeps the ID stable across boots.
+			vhostDev.DevID = v.MountTag
 
 			q.qemuConfig.Devices, err = q.arch.appendVhostUserDevice(q.qemuConfig.Devices, vhostDev)
 		} else {
@@ -1597,7 +2540,11 @@ func (q *qemu) addDevice(devInfo interface{}, devType deviceType) error {
 	case Endpoint:
 		q.qemuConfig.Devices, err = q.arch.appendNetwork(q.qemuConfig.Devices, v)
 	case config.BlockDrive:
-		q.qemuConfig.Devices, err = q.arch.appendBlockDevice(q.qemuConfig.Devices, v)
+		if q.config.BlockDeviceDriver == config.VirtioPmem {
+			q.qemuConfig.Devices, err = q.arch.appendVirtioPmemDevice(q.qemuConfig.Devices, v)
+		} else {
+			q.qemuConfig.Devices, err = q.arch.appendBlockDevice(q.qemuConfig.Devices, v)
+		}
 	case config.VhostUserDeviceAttrs:
 		q.qemuConfig.Devices, err = q.arch.appendVhostUserDevice(q.qemuConfig.Devices, v)
 	case config.VFIODev:
@@ -1615,7 +2562,7 @@ func (q *qemu) getSandboxConsole(id string) (string, error) {
 	span, _ := q.trace("getSandboxConsole")
 	defer span.Finish()
 
-	return utils.BuildSocketPath(store.RunVMStoragePath, id, consoleSocket)
+	return utils.BuildSocketPath(sandboxTmpDir(id), consoleSocket)
 }
 
 func (q *qemu) saveSandbox() error {
@@ -1629,7 +2576,7 @@ func (q *qemu) saveSandbox() error {
 	// BootToBeTemplate sets the VM to be a template that other VMs can clone from. We would want to
 	// bypass shared memory when saving the VM to a local file through migration exec.
 	if q.config.BootToBeTemplate {
-		err := q.arch.setIgnoreSharedMemoryMigrationCaps(q.qmpMonitorCh.ctx, q.qmpMonitorCh.qmp)
+		err := q.setIgnoreSharedMemoryMigrationCaps()
 		if err != nil {
 			q.Logger().WithError(err).Error("set migration ignore shared memory")
 			return err
@@ -1646,10 +2593,20 @@ func (q *qemu) saveSandbox() error {
 }
 
 func (q *qemu) waitMigration() error {
-	t := time.NewTimer(qmpMigrationWaitTimeout)
+	return q.waitMigrationTimeout(qmpMigrationWaitTimeout)
+}
+
+// waitMigrationTimeout waits for the migration in progress to complete,
+// failing once it failed or after timeout.
+func (q *qemu) waitMigrationTimeout(timeout time.Duration) error {
+	t := time.NewTimer(timeout)
 	defer t.Stop()
 	for {
-		status, err := q.qmpMonitorCh.qmp.ExecuteQueryMigration(q.qmpMonitorCh.ctx)
+		var status govmmQemu.MigrationStatus
+		err := qmpExecute("query-migrate", func() (err error) {
+			status, err = q.qmpMonitorCh.qmp.ExecuteQueryMigration(q.qmpMonitorCh.ctx)
+			return err
+		})
 		if err != nil {
 			q.Logger().WithError(err).Error("failed to query migration status")
 			return err
@@ -1657,11 +2614,14 @@ func (q *qemu) waitMigration() error {
 		if status.Status == "completed" {
 			break
 		}
+		if status.Status == "failed" || status.Status == "cancelled" {
+			return q.migrationError(status.Status)
+		}
 
 		select {
 		case <-t.C:
 			q.Logger().WithField("migration-status", status).Error("timeout waiting for qemu migration")
-			return fmt.Errorf("timed out after %d seconds waiting for qemu migration", qmpMigrationWaitTimeout)
+			return fmt.Errorf("timed out after %v waiting for qemu migration", timeout)
 		default:
 			// migration in progress
 			q.Logger().WithField("migration-status", status).Debug("migration in progress")
@@ -1702,7 +2662,7 @@ func (q *qemu) resizeMemory(reqMemMB uint32, memoryBlockSizeMB uint32, probe boo
 	case currentMemory < reqMemMB:
 		//hotplug
 		addMemMB := reqMemMB - currentMemory
-		memHotplugMB, err := calcHotplugMemMiBSize(addMemMB, memoryBlockSizeMB)
+		memHotplugMB, err := calcHotplugMemMiBSize(addMemMB, q.memoryAlignMB(memoryBlockSizeMB))
 		if err != nil {
 			return currentMemory, memoryDevice{}, err
 		}
@@ -1741,7 +2701,7 @@ func (q *qemu) resizeMemory(reqMemMB uint32, memoryBlockSizeMB uint32, probe boo
 		//FIXME: This is to check memory hotplugRemoveDevice reported 0, as this is not supported.
 		// In the future if this is implemented this validation should be removed.
 		if memoryRemoved != 0 {
-			return currentMemory, addMemDevice, fmt.Errorf("memory hot unplug is not supported, something went wrong")
+			return currentMemory, addMemDevice, errors.Wrap(vcTypes.ErrNotSupported, "memory hot unplug, something went wrong")
 		}
 		currentMemory -= uint32(memoryRemoved)
 	}
@@ -1844,6 +2804,24 @@ func (q *qemu) getThreadIDs() (vcpuThreadIDs, error) {
 		return tid, err
 	}
 
+	// query-cpus interrupts the vCPUs and is gone from recent QEMU
+	// versions, query-cpus-fast is preferred when available.
+	if q.compat.supports(qemuFeatureQueryCpusFast) || !q.compat.supports(qemuFeatureQueryCpus) {
+		cpuInfos, err := q.qmpMonitorCh.qmp.ExecQueryCpusFast(q.qmpMonitorCh.ctx)
+		if err != nil {
+			q.Logger().WithError(err).Error("failed to query cpu infos")
+			return tid, err
+		}
+
+		tid.vcpus = make(map[int]int, len(cpuInfos))
+		for _, i := range cpuInfos {
+			if i.ThreadID > 0 {
+				tid.vcpus[i.CPUIndex] = i.ThreadID
+			}
+		}
+		return tid, nil
+	}
+
 	cpuInfos, err := q.qmpMonitorCh.qmp.ExecQueryCpus(q.qmpMonitorCh.ctx)
 	if err != nil {
 		q.Logger().WithError(err).Error("failed to query cpu infos")
@@ -1859,6 +2837,82 @@ func (q *qemu) getThreadIDs() (vcpuThreadIDs, error) {
 	return tid, nil
 }
 
+func (q *qemu) metrics() (HypervisorMetrics, error) {
+	span, _ := q.trace("metrics")
+	defer span.Finish()
+
+	tids, err := q.getThreadIDs()
+	if err != nil {
+		return HypervisorMetrics{}, err
+	}
+
+	m, err := processMetrics(q.getPids()[0], tids)
+	if err != nil {
+		return m, err
+	}
+
+	if q.state.VirtiofsdPid != 0 {
+		m.VirtiofsdRSS, m.VirtiofsdCPUTime, err = procResources(q.state.VirtiofsdPid)
+		if err != nil {
+			return m, err
+		}
+	}
+
+	memoryDevices, err := q.qmpMonitorCh.qmp.ExecQueryMemoryDevices(q.qmpMonitorCh.ctx)
+	if err != nil {
+		return m, errors.Wrap(err, "failed to query memory devices")
+	}
+
+	for _, d := range memoryDevices {
+		if d.Data.Hotplugged {
+			m.HotpluggedMemoryMB += d.Data.Size >> 20
+		}
+	}
+
+	return m, nil
+}
+
+func (q *qemu) hotplugCapacity() (hotplugCapacity, error) {
+	span, _ := q.trace("hotplugCapacity")
+	defer span.Finish()
+
+	if err := q.qmpSetup(); err != nil {
+		return hotplugCapacity{}, err
+	}
+
+	memoryDevices, err := q.qmpMonitorCh.qmp.ExecQueryMemoryDevices(q.qmpMonitorCh.ctx)
+	if err != nil {
+		return hotplugCapacity{}, errors.Wrap(err, "failed to query memory devices")
+	}
+
+	c := hotplugCapacity{
+		bridgeSlots: q.arch.freeBridgeSlots(types.PCI),
+		memoryMB:    q.config.MemorySize + uint32(q.state.HotpluggedMemory),
+	}
+
+	if used := uint32(len(memoryDevices)); used < q.config.MemSlots {
+		c.memorySlots = q.config.MemSlots - used
+	}
+
+	return c, nil
+}
+
+// memoryAlignMB returns the alignment of the hotplugged memory, which must
+// be a multiple of both the guest memory block size and the huge page size.
+func (q *qemu) memoryAlignMB(memoryBlockSizeMB uint32) uint32 {
+	if q.hugePageSizeKB == 0 {
+		return memoryBlockSizeMB
+	}
+
+	// Both sizes are powers of two, the largest one is a multiple of
+	// the other.
+	if align := hugePageAlignMB(q.hugePageSizeKB); align > memoryBlockSizeMB {
+		return align
+	}
+
+	return memoryBlockSizeMB
+}
+
 func calcHotplugMemMiBSize(mem uint32, memorySectionSizeMB uint32) (uint32, error) {
 	if memorySectionSizeMB == 0 {
 		return mem, nil
@@ -1934,6 +2988,12 @@ func (q *qemu) getPids() []int {
 		pids = append(pids, q.state.VirtiofsdPid)
 	}
 
+	for _, v := range q.config.VirtioFSVolumes {
+		if pid, ok := q.state.VolumeVirtiofsdPids[v.MountTag]; ok {
+			pids = append(pids, pid)
+		}
+	}
+
 	return pids
 }
 
@@ -1962,11 +3022,13 @@ func (q *qemu) fromGrpc(ctx context.Context, hypervisorConfig *HypervisorConfig,
 	q.config = *hypervisorConfig
 	q.qmpMonitorCh.ctx = ctx
 	q.qmpMonitorCh.path = qp.QmpChannelpath
+	q.qmpMonitorCh.extPath, _ = q.qmpExtSocketPath(qp.ID)
 	q.qemuConfig.Ctx = ctx
 	q.state = qp.State
 	q.arch = newQemuArch(q.config)
+	q.arch.setQMPExt(q.qmpExecutor)
 	q.ctx = ctx
-	q.nvdimmCount = qp.NvdimmCount
+	q.state.NvdimmCount = qp.NvdimmCount
 
 	q.qemuConfig.SMP = qp.QemuSMP
 
@@ -1981,7 +3043,7 @@ func (q *qemu) toGrpc() ([]byte, error) {
 		ID:             q.id,
 		QmpChannelpath: q.qmpMonitorCh.path,
 		State:          q.state,
-		NvdimmCount:    q.nvdimmCount,
+		NvdimmCount:    q.state.NvdimmCount,
 
 		QemuSMP: q.qemuConfig.SMP,
 	}
@@ -1992,6 +3054,7 @@ func (q *qemu) toGrpc() ([]byte, error) {
 func (q *qemu) storeState() error {
 	if q.store != nil {
 		q.state.Bridges = q.arch.getBridges()
+		q.state.MemPreallocPending = q.memPrealloc.pending()
 		if err := q.store.Store(store.Hypervisor, q.state); err != nil {
 			return err
 		}
@@ -2005,6 +3068,11 @@ func (q *qemu) save() (s persistapi.HypervisorState) {
 		s.Pid = pids[0]
 	}
 	s.VirtiofsdPid = q.state.VirtiofsdPid
+	s.VolumeVirtiofsdPids = q.state.VolumeVirtiofsdPids
+	s.MemPreallocPending = q.memPrealloc.pending()
+	s.VMMUserGrants = q.state.VMMUserGrants
+	s.NvdimmCount = q.state.NvdimmCount
+	s.NvdimmFreeIDs = q.state.NvdimmFreeIDs
 	s.Type = string(QemuHypervisor)
 	s.UUID = q.state.UUID
 	s.HotpluggedMemory = q.state.HotpluggedMemory
@@ -2024,6 +3092,15 @@ func (q *qemu) save() (s persistapi.HypervisorState) {
 			ID: cpu.ID,
 		})
 	}
+
+	for _, dev := range q.state.HotpluggedNetDevices {
+		s.HotpluggedNetDevices = append(s.HotpluggedNetDevices, persistapi.NetworkDevice{
+			ID:         dev.ID,
+			DevID:      dev.DevID,
+			Netdev:     dev.Netdev,
+			MACAddress: dev.MACAddress,
+		})
+	}
 	return
 }
 
@@ -2032,6 +3109,22 @@ func (q *qemu) load(s persistapi.HypervisorState) {
 	q.state.HotpluggedMemory = s.HotpluggedMemory
 	q.state.HotplugVFIOOnRootBus = s.HotplugVFIOOnRootBus
 	q.state.VirtiofsdPid = s.VirtiofsdPid
+	q.state.VolumeVirtiofsdPids = s.VolumeVirtiofsdPids
+	q.state.MemPreallocPending = s.MemPreallocPending
+	q.state.VMMUserGrants = s.VMMUserGrants
+	q.state.NvdimmCount = s.NvdimmCount
+	q.state.NvdimmFreeIDs = s.NvdimmFreeIDs
+
+	// The QEMU started by a previous runtime writes its log to the pipe
+	// nobody reads anymore.
+	if q.config.Debug && q.log == nil {
+		path := filepath.Join(sandboxTmpDir(q.id), "qemu.log")
+		if _, err := os.Stat(logPipePath(path)); err == nil {
+			if err := q.startLog(); err != nil {
+				q.Logger().WithError(err).Warn("Failed to copy the qemu log")
+			}
+		}
+	}
 
 	for _, bridge := range s.Bridges {
 		q.state.Bridges = append(q.state.Bridges, types.NewBridge(types.Type(bridge.Type), bridge.ID, bridge.DeviceAddr, bridge.Addr))
@@ -2042,21 +3135,82 @@ func (q *qemu) load(s persistapi.HypervisorState) {
 			ID: cpu.ID,
 		})
 	}
+
+	for _, dev := range s.HotpluggedNetDevices {
+		q.state.HotpluggedNetDevices = append(q.state.HotpluggedNetDevices, NetworkDevice{
+			ID:         dev.ID,
+			DevID:      dev.DevID,
+			Netdev:     dev.Netdev,
+			MACAddress: dev.MACAddress,
+		})
+	}
+}
+
+// qmpDisconnected returns true when the QMP connection was closed, either
+// because QEMU exited or because the monitor socket was reset.
+func (q *qemu) qmpDisconnected() bool {
+	if q.qmpMonitorCh.qmp == nil || q.qmpMonitorCh.disconn == nil {
+		return false
+	}
+
+	select {
+	case <-q.qmpMonitorCh.disconn:
+		return true
+	default:
+		return false
+	}
+}
+
+// qemuAlive checks the QEMU process is still running.
+func (q *qemu) qemuAlive() error {
+	pid := q.getPids()[0]
+	if pid <= 0 {
+		return nil
+	}
+
+	if err := syscall.Kill(pid, syscall.Signal(0)); err != nil {
+		return errors.Wrapf(ErrVMMDead, "qemu process %d: %v", pid, err)
+	}
+
+	return nil
 }
 
-func (q *qemu) check() error {
+func (q *qemu) check(agentCheck func() error) error {
+	if q.qmpDisconnected() {
+		q.Logger().Warn("QMP channel disconnected, reconnecting")
+		// the channel is already closed, there is nothing to shutdown.
+		q.qmpMonitorCh.qmp = nil
+		q.qmpMonitorCh.disconn = nil
+
+		if err := q.qemuAlive(); err != nil {
+			return err
+		}
+	}
+
 	err := q.qmpSetup()
 	if err != nil {
-		return err
+		if aliveErr := q.qemuAlive(); aliveErr != nil {
+			return aliveErr
+		}
+		return errors.Wrap(err, "failed to reconnect QMP")
 	}
 
-	status, err := q.qmpMonitorCh.qmp.ExecuteQueryStatus(q.qmpMonitorCh.ctx)
+	var status govmmQemu.StatusInfo
+	err = qmpExecute("query-status", func() (err error) {
+		status, err = q.qmpMonitorCh.qmp.ExecuteQueryStatus(q.qmpMonitorCh.ctx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	if status.Status == "internal-error" || status.Status == "guest-panicked" {
-		return errors.Errorf("guest failure: %s", status.Status)
+	switch status.Status {
+	case "guest-panicked":
+		return errors.Wrap(ErrGuestPanicked, "guest failure")
+	case "internal-error":
+		return errors.Wrap(ErrHypervisorError, "guest failure")
+	case "running":
+		return checkAgent(agentCheck)
 	}
 
 	return nil
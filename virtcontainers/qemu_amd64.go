@@ -6,10 +6,14 @@
 package virtcontainers
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/sirupsen/logrus"
 
 	govmmQemu "github.com/intel/govmm/qemu"
 )
@@ -19,6 +23,13 @@ type qemuAmd64 struct {
 	qemuArchBase
 
 	vmFactory bool
+
+	protection ConfidentialGuestType
+
+	sevCertPath string
+	sevPolicy   uint32
+
+	sgxEPCSize int64
 }
 
 const defaultQemuPath = "/usr/bin/qemu-system-x86_64"
@@ -27,12 +38,42 @@ const defaultQemuMachineType = QemuPC
 
 const defaultQemuMachineOptions = "accel=kvm,kernel_irqchip,nvdimm"
 
+// microvmMachineOptions disables the legacy devices and the ACPI tables of
+// the microvm machine, which has neither PCI nor NVDIMM.
+const microvmMachineOptions = "accel=kvm,kernel_irqchip,acpi=off,pic=off,pit=off,rtc=off,isa-serial=off,x-option-roms=off"
+
 const qmpMigrationWaitTimeout = 5 * time.Second
 
+const (
+	// sevObjectID is the id of the SEV memory encryption object.
+	sevObjectID = "sev0"
+
+	// sevCbitPos is the page table bit marking a page as encrypted.
+	sevCbitPos = 47
+
+	// sevReducedPhysBits is the number of physical address bits lost
+	// when memory encryption is enabled.
+	sevReducedPhysBits = 1
+
+	// sevPolicyES is the guest policy bit requesting a SEV-ES guest.
+	sevPolicyES = 0x4
+
+	// tdxObjectID is the id of the TDX guest object.
+	tdxObjectID = "tdx0"
+
+	// sgxEPCObjectID is the id of the SGX enclave page cache object.
+	sgxEPCObjectID = "epc0"
+)
+
+// sgxVEPCDevice is the host device qemu allocates the guest enclave page
+// cache from.
+var sgxVEPCDevice = "/dev/sgx_vepc"
+
 var qemuPaths = map[string]string{
-	QemuPCLite: "/usr/bin/qemu-lite-system-x86_64",
-	QemuPC:     defaultQemuPath,
-	QemuQ35:    defaultQemuPath,
+	QemuPCLite:  "/usr/bin/qemu-lite-system-x86_64",
+	QemuPC:      defaultQemuPath,
+	QemuQ35:     defaultQemuPath,
+	QemuMicrovm: defaultQemuPath,
 }
 
 var kernelRootParams = commonNvdimmKernelRootParams
@@ -72,6 +113,10 @@ var supportedQemuMachines = []govmmQemu.Machine{
 		Type:    QemuVirt,
 		Options: defaultQemuMachineOptions,
 	},
+	{
+		Type:    QemuMicrovm,
+		Options: microvmMachineOptions,
+	},
 }
 
 // MaxQemuVCPUs returns the maximum number of vCPUs supported
@@ -94,13 +139,18 @@ func newQemuArch(config HypervisorConfig) qemuArch {
 		qemuArchBase: qemuArchBase{
 			machineType:           machineType,
 			memoryOffset:          config.MemOffset,
+			maxBridges:            config.MaxBridges,
 			qemuPaths:             qemuPaths,
 			supportedQemuMachines: supportedQemuMachines,
 			kernelParamsNonDebug:  kernelParamsNonDebug,
 			kernelParamsDebug:     kernelParamsDebug,
 			kernelParams:          kernelParams,
 		},
-		vmFactory: factory,
+		vmFactory:   factory,
+		protection:  config.ConfidentialGuest,
+		sevCertPath: config.SEVCertChainPath,
+		sevPolicy:   config.SEVGuestPolicy,
+		sgxEPCSize:  config.SGXEPCSize,
 	}
 
 	q.handleImagePath(config)
@@ -122,7 +172,27 @@ func (q *qemuAmd64) capabilities() types.Capabilities {
 	return caps
 }
 
+// handleImagePath boots microvm from the image as a virtio block device,
+// there is no NVDIMM to map it on.
+func (q *qemuAmd64) handleImagePath(config HypervisorConfig) {
+	if q.machineType != QemuMicrovm {
+		q.qemuArchBase.handleImagePath(config)
+		return
+	}
+
+	if config.ImagePath != "" {
+		q.kernelParams = append(q.kernelParams, commonVirtioblkKernelRootParams...)
+		q.kernelParamsNonDebug = append(q.kernelParamsNonDebug, kernelParamsSystemdNonDebug...)
+		q.kernelParamsDebug = append(q.kernelParamsDebug, kernelParamsSystemdDebug...)
+	}
+}
+
 func (q *qemuAmd64) bridges(number uint32) {
+	// microvm has no PCI bus to plug bridges on.
+	if q.machineType == QemuMicrovm {
+		return
+	}
+
 	q.Bridges = genericBridges(number, q.machineType)
 }
 
@@ -143,10 +213,24 @@ func (q *qemuAmd64) cpuModel() string {
 }
 
 func (q *qemuAmd64) memoryTopology(memoryMb, hostMemoryMb uint64, slots uint8) govmmQemu.Memory {
+	// The memory of microvm cannot be hotplugged, it has no slots.
+	if q.machineType == QemuMicrovm {
+		return govmmQemu.Memory{Size: fmt.Sprintf("%dM", memoryMb)}
+	}
+
 	return genericMemoryTopology(memoryMb, hostMemoryMb, slots, q.memoryOffset)
 }
 
 func (q *qemuAmd64) appendImage(devices []govmmQemu.Device, path string) ([]govmmQemu.Device, error) {
+	if q.machineType == QemuMicrovm {
+		n := len(devices)
+		devices, err := q.qemuArchBase.appendImage(devices, path)
+		if err != nil {
+			return nil, err
+		}
+		return q.virtioMMIO(devices, n), nil
+	}
+
 	imageFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -174,5 +258,285 @@ func (q *qemuAmd64) appendImage(devices []govmmQemu.Device, path string) ([]govm
 
 // appendBridges appends to devices the given bridges
 func (q *qemuAmd64) appendBridges(devices []govmmQemu.Device) []govmmQemu.Device {
+	if q.machineType == QemuMicrovm {
+		return devices
+	}
+
 	return genericAppendBridges(devices, q.Bridges, q.machineType)
 }
+
+// appendConsole appends a console to devices, on the virtio-mmio transport
+// for microvm.
+func (q *qemuAmd64) appendConsole(devices []govmmQemu.Device, path string) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendConsole(devices, path)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendSCSIController(devices []govmmQemu.Device, enableIOThreads bool) ([]govmmQemu.Device, *govmmQemu.IOThread, error) {
+	n := len(devices)
+	devices, t, err := q.qemuArchBase.appendSCSIController(devices, enableIOThreads)
+	return q.virtioMMIO(devices, n), t, err
+}
+
+func (q *qemuAmd64) append9PVolume(devices []govmmQemu.Device, volume types.Volume) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.append9PVolume(devices, volume)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendVSock(devices []govmmQemu.Device, vsock kataVSOCK) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendVSock(devices, vsock)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendNetwork(devices []govmmQemu.Device, endpoint Endpoint) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendNetwork(devices, endpoint)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendBlockDevice(devices []govmmQemu.Device, drive config.BlockDrive) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendBlockDevice(devices, drive)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendVhostUserDevice(devices []govmmQemu.Device, attr config.VhostUserDeviceAttrs) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendVhostUserDevice(devices, attr)
+	return q.virtioMMIO(devices, n), err
+}
+
+func (q *qemuAmd64) appendRNGDevice(devices []govmmQemu.Device, rngDev config.RNGDev) ([]govmmQemu.Device, error) {
+	n := len(devices)
+	devices, err := q.qemuArchBase.appendRNGDevice(devices, rngDev)
+	return q.virtioMMIO(devices, n), err
+}
+
+// virtioMMIO moves the devices appended from the index n to the virtio-mmio
+// transport on microvm, which has no PCI bus.
+func (q *qemuAmd64) virtioMMIO(devices []govmmQemu.Device, n int) []govmmQemu.Device {
+	if q.machineType != QemuMicrovm || n > len(devices) {
+		return devices
+	}
+
+	for i := n; i < len(devices); i++ {
+		devices[i] = mmioDevice{devices[i]}
+	}
+
+	return devices
+}
+
+func (q *qemuAmd64) machine() (govmmQemu.Machine, error) {
+	m, err := q.qemuArchBase.machine()
+	if err != nil {
+		return m, err
+	}
+
+	switch q.protection {
+	case SEVGuest:
+		m.Options += ",memory-encryption=" + sevObjectID
+	case TDXGuest:
+		if q.machineType != QemuQ35 {
+			return govmmQemu.Machine{}, fmt.Errorf("TDX guests require the %s machine type, not %s", QemuQ35, q.machineType)
+		}
+
+		// The TD cannot access an NVDIMM and its interrupt controller
+		// must be emulated by qemu.
+		var options []string
+		for _, o := range strings.Split(m.Options, ",") {
+			switch o {
+			case "nvdimm":
+			case "kernel_irqchip":
+				options = append(options, "kernel_irqchip=split")
+			default:
+				options = append(options, o)
+			}
+		}
+		options = append(options, "confidential-guest-support="+tdxObjectID)
+		m.Options = strings.Join(options, ",")
+	}
+
+	if q.sgxEPCSize != 0 {
+		m.Options += ",sgx-epc.0.memdev=" + sgxEPCObjectID
+	}
+
+	return m, nil
+}
+
+// supportGuestMemoryHotplug returns false for confidential guests,
+// hotplugged memory would not be part of the protected launch, and for
+// microvm, which cannot hotplug anything.
+func (q *qemuAmd64) supportGuestMemoryHotplug() bool {
+	return q.protection == NoConfidentialGuest && q.machineType != QemuMicrovm
+}
+
+// supportGuestCPUHotplug returns false for TDX guests, the TDX module
+// does not allow adding vCPUs to a running TD, and for microvm.
+func (q *qemuAmd64) supportGuestCPUHotplug() bool {
+	return q.protection != TDXGuest && q.machineType != QemuMicrovm
+}
+
+func (q *qemuAmd64) appendProtectionDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	switch q.protection {
+	case SEVGuest:
+		virtLog.WithFields(logrus.Fields{
+			"subsystem": "qemuAmd64",
+			"policy":    fmt.Sprintf("%#x", q.sevPolicy),
+			"sev-es":    q.sevPolicy&sevPolicyES != 0,
+		}).Info("Enabling SEV guest memory encryption")
+
+		return append(devices, sevGuest{
+			id:       sevObjectID,
+			certPath: q.sevCertPath,
+			policy:   q.sevPolicy,
+		}), nil
+	case TDXGuest:
+		virtLog.WithField("subsystem", "qemuAmd64").Info("Enabling TDX guest protection")
+
+		return append(devices, tdxGuest{id: tdxObjectID}), nil
+	}
+
+	return devices, fmt.Errorf("Unsupported confidential guest type %q", q.protection)
+}
+
+func (q *qemuAmd64) appendSGXEPCDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	if _, err := os.Stat(sgxVEPCDevice); err != nil {
+		return devices, fmt.Errorf("SGX enclaves are not supported by the host: %v", err)
+	}
+
+	virtLog.WithFields(logrus.Fields{
+		"subsystem": "qemuAmd64",
+		"epc-size":  q.sgxEPCSize,
+	}).Info("Enabling SGX enclave page cache")
+
+	return append(devices, sgxEPC{
+		id:   sgxEPCObjectID,
+		size: q.sgxEPCSize,
+	}), nil
+}
+
+// sevGuest is the memory encryption object of an AMD SEV guest.
+type sevGuest struct {
+	id       string
+	certPath string
+	policy   uint32
+}
+
+// Valid returns true if the object has an id.
+func (s sevGuest) Valid() bool {
+	return s.id != ""
+}
+
+// QemuParams returns the qemu parameters built out of the SEV object.
+func (s sevGuest) QemuParams(config *govmmQemu.Config) []string {
+	params := []string{
+		"sev-guest",
+		fmt.Sprintf("id=%s", s.id),
+		fmt.Sprintf("cbitpos=%d", sevCbitPos),
+		fmt.Sprintf("reduced-phys-bits=%d", sevReducedPhysBits),
+		fmt.Sprintf("policy=%#x", s.policy),
+	}
+
+	if s.certPath != "" {
+		params = append(params, fmt.Sprintf("dh-cert-file=%s", s.certPath))
+	}
+
+	return []string{"-object", strings.Join(params, ",")}
+}
+
+// tdxGuest is the object describing an Intel TDX guest.
+type tdxGuest struct {
+	id string
+}
+
+// Valid returns true if the object has an id.
+func (t tdxGuest) Valid() bool {
+	return t.id != ""
+}
+
+// QemuParams returns the qemu parameters built out of the TDX object.
+func (t tdxGuest) QemuParams(config *govmmQemu.Config) []string {
+	return []string{"-object", fmt.Sprintf("tdx-guest,id=%s", t.id)}
+}
+
+// sgxEPC is the enclave page cache section of an Intel SGX guest. Unlike
+// the guest RAM, it is always pre-allocated.
+type sgxEPC struct {
+	id   string
+	size int64
+}
+
+// Valid returns true if the object has an id and a size.
+func (e sgxEPC) Valid() bool {
+	return e.id != "" && e.size > 0
+}
+
+// QemuParams returns the qemu parameters built out of the EPC object.
+func (e sgxEPC) QemuParams(config *govmmQemu.Config) []string {
+	return []string{"-object", fmt.Sprintf("memory-backend-epc,id=%s,size=%d,prealloc=on", e.id, e.size)}
+}
+
+// mmioDrivers maps the drivers of the virtio PCI devices to the drivers of
+// the same devices on the virtio-mmio transport.
+var mmioDrivers = map[string]string{
+	string(govmmQemu.VirtioSerial):   "virtio-serial-device",
+	string(govmmQemu.VirtioBlock):    "virtio-blk-device",
+	string(govmmQemu.VirtioBlockPCI): "virtio-blk-device",
+	string(govmmQemu.VirtioNetPCI):   "virtio-net-device",
+	string(govmmQemu.VHostVSock):     "vhost-vsock-device",
+	string(govmmQemu.VirtioRng):      "virtio-rng-device",
+	string(govmmQemu.Virtio9P):       "virtio-9p-device",
+	string(govmmQemu.VhostUserFS):    "vhost-user-fs-device",
+	string(govmmQemu.VirtioScsi):     "virtio-scsi-device",
+}
+
+// mmioDevice is a virtio device plugged on the virtio-mmio transport of
+// microvm instead of a PCI bus.
+type mmioDevice struct {
+	govmmQemu.Device
+}
+
+// QemuParams returns the qemu parameters of the device, with the PCI
+// drivers replaced by their virtio-mmio ones and the PCI options dropped.
+func (d mmioDevice) QemuParams(config *govmmQemu.Config) []string {
+	params := d.Device.QemuParams(config)
+
+	for i := 1; i < len(params); i++ {
+		if params[i-1] == "-device" {
+			params[i] = mmioDeviceParams(params[i])
+		}
+	}
+
+	return params
+}
+
+// mmioDeviceParams rewrites the parameters of a -device option for the
+// virtio-mmio transport.
+func mmioDeviceParams(device string) string {
+	var params []string
+
+	for i, p := range strings.Split(device, ",") {
+		key := strings.SplitN(p, "=", 2)[0]
+		switch key {
+		case "romfile", "disable-modern", "vectors", "bus", "addr":
+			continue
+		}
+
+		if key == "driver" {
+			if driver, ok := mmioDrivers[strings.TrimPrefix(p, "driver=")]; ok {
+				p = "driver=" + driver
+			}
+		} else if i == 0 {
+			if driver, ok := mmioDrivers[p]; ok {
+				p = driver
+			}
+		}
+
+		params = append(params, p)
+	}
+
+	return strings.Join(params, ",")
+}
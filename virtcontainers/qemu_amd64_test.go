@@ -6,12 +6,15 @@
 package virtcontainers
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
 
 	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -190,3 +193,247 @@ func TestQemuAmd64AppendBridges(t *testing.T) {
 
 	assert.Equal(expectedOut, devices)
 }
+
+func TestQemuAmd64ConfidentialGuest(t *testing.T) {
+	assert := assert.New(t)
+
+	amd64 := newTestQemu(QemuPC)
+	assert.True(amd64.supportGuestMemoryHotplug())
+	assert.True(amd64.supportGuestCPUHotplug())
+
+	_, err := amd64.appendProtectionDevice(nil)
+	assert.Error(err)
+
+	m, err := amd64.machine()
+	assert.NoError(err)
+	assert.Equal(defaultQemuMachineOptions, m.Options)
+
+	config := HypervisorConfig{
+		HypervisorMachineType: QemuQ35,
+		ConfidentialGuest:     SEVGuest,
+		SEVCertChainPath:      "/foo/dh_cert",
+		SEVGuestPolicy:        sevPolicyES,
+	}
+	amd64 = newQemuArch(config)
+	assert.False(amd64.supportGuestMemoryHotplug())
+	assert.True(amd64.supportGuestCPUHotplug())
+
+	m, err = amd64.machine()
+	assert.NoError(err)
+	assert.Equal(defaultQemuMachineOptions+",memory-encryption="+sevObjectID, m.Options)
+
+	devices, err := amd64.appendProtectionDevice(nil)
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.True(devices[0].Valid())
+
+	expectedParams := []string{
+		"-object",
+		"sev-guest,id=sev0,cbitpos=47,reduced-phys-bits=1,policy=0x4,dh-cert-file=/foo/dh_cert",
+	}
+	assert.Equal(expectedParams, devices[0].QemuParams(nil))
+}
+
+func TestQemuAmd64TDXGuest(t *testing.T) {
+	assert := assert.New(t)
+
+	config := HypervisorConfig{
+		HypervisorMachineType: QemuPC,
+		ConfidentialGuest:     TDXGuest,
+	}
+	amd64 := newQemuArch(config)
+	assert.False(amd64.supportGuestMemoryHotplug())
+	assert.False(amd64.supportGuestCPUHotplug())
+
+	_, err := amd64.machine()
+	assert.Error(err)
+
+	config.HypervisorMachineType = QemuQ35
+	amd64 = newQemuArch(config)
+
+	m, err := amd64.machine()
+	assert.NoError(err)
+	assert.Equal("accel=kvm,kernel_irqchip=split,confidential-guest-support="+tdxObjectID, m.Options)
+
+	devices, err := amd64.appendProtectionDevice(nil)
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.True(devices[0].Valid())
+	assert.Equal([]string{"-object", "tdx-guest,id=tdx0"}, devices[0].QemuParams(nil))
+}
+
+func TestQemuAmd64SGXEPC(t *testing.T) {
+	assert := assert.New(t)
+
+	savedDevice := sgxVEPCDevice
+	defer func() { sgxVEPCDevice = savedDevice }()
+
+	dir, err := ioutil.TempDir("", "sgx")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := HypervisorConfig{
+		HypervisorMachineType: QemuQ35,
+		SGXEPCSize:            64 * 1024 * 1024,
+	}
+	amd64 := newQemuArch(config)
+
+	m, err := amd64.machine()
+	assert.NoError(err)
+	assert.Equal(defaultQemuMachineOptions+",sgx-epc.0.memdev="+sgxEPCObjectID, m.Options)
+
+	sgxVEPCDevice = dir + "/sgx_vepc"
+	_, err = amd64.appendSGXEPCDevice(nil)
+	assert.Error(err)
+
+	assert.NoError(ioutil.WriteFile(sgxVEPCDevice, nil, 0600))
+	devices, err := amd64.appendSGXEPCDevice(nil)
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.True(devices[0].Valid())
+	assert.Equal([]string{"-object", "memory-backend-epc,id=epc0,size=67108864,prealloc=on"}, devices[0].QemuParams(nil))
+}
+
+func TestQemuAmd64Microvm(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "img")
+	assert.NoError(err)
+	defer func() { _ = f.Close() }()
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	config := HypervisorConfig{
+		HypervisorMachineType: QemuMicrovm,
+		ImagePath:             f.Name(),
+	}
+	amd64 := newQemuArch(config)
+
+	m, err := amd64.machine()
+	assert.NoError(err)
+	assert.Equal(microvmMachineOptions, m.Options)
+
+	// the image is a virtio block device, not an NVDIMM
+	params := SerializeParams(amd64.kernelParameters(false), "=")
+	assert.Contains(params, "root=/dev/vda1")
+	assert.NotContains(params, "root=/dev/pmem0p1")
+
+	caps := amd64.capabilities()
+	assert.False(caps.IsBlockDeviceHotplugSupported())
+	assert.False(amd64.supportGuestMemoryHotplug())
+	assert.False(amd64.supportGuestCPUHotplug())
+	assert.Equal(govmmQemu.Memory{Size: "1024M"}, amd64.memoryTopology(1024, 4096, 10))
+
+	// no PCI bridge
+	amd64.bridges(5)
+	assert.Empty(amd64.getBridges())
+	assert.Empty(amd64.appendBridges(nil))
+
+	devices, err := amd64.appendConsole(nil, "console.sock")
+	assert.NoError(err)
+	assert.Len(devices, 2)
+	assert.Equal([]string{"-device", "virtio-serial-device,id=serial0"}, devices[0].QemuParams(&govmmQemu.Config{}))
+
+	devices, err = amd64.appendVSock(nil, kataVSOCK{contextID: 3})
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.Equal([]string{"-device", "vhost-vsock-device,id=vsock-3,guest-cid=3"}, devices[0].QemuParams(&govmmQemu.Config{}))
+
+	devices, err = amd64.appendImage(nil, f.Name())
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.Equal([]string{"-device", "virtio-blk-device,drive=image0,scsi=off,config-wce=off", "-drive", "id=image0,file=" + f.Name() + ",aio=threads,format=raw,if=none"}, devices[0].QemuParams(&govmmQemu.Config{}))
+}
+
+func TestMMIODeviceParams(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("driver=virtio-net-device,netdev=network-0,mac=02:42:ac:11:00:02,mq=on",
+		mmioDeviceParams("driver=virtio-net-pci,netdev=network-0,mac=02:42:ac:11:00:02,disable-modern=false,mq=on,vectors=4,romfile="))
+	assert.Equal("virtio-rng-device,rng=rng0", mmioDeviceParams("virtio-rng,rng=rng0,romfile="))
+	assert.Equal("virtconsole,chardev=charconsole0,id=console0", mmioDeviceParams("virtconsole,chardev=charconsole0,id=console0"))
+}
+
+// TestQemuDevicePlan makes sure that the devices of the generated QEMU
+// configuration keep the same order, whatever the order they are added in,
+// as the guest names them after it.
+func TestQemuDevicePlan(t *testing.T) {
+	assert := assert.New(t)
+
+	qemuConfig := newQemuConfig()
+	qemuConfig.InitrdPath = ""
+	qemuConfig.BlockDeviceDriver = config.VirtioSCSI
+	qemuConfig.EnableIOThreads = true
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		id:  "testSandbox",
+		config: &SandboxConfig{
+			HypervisorConfig: qemuConfig,
+		},
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+
+	parentDir := store.SandboxConfigurationRootPath(sandbox.id)
+	assert.NoError(os.MkdirAll(parentDir, store.DirMode))
+	defer os.RemoveAll(parentDir)
+
+	q := &qemu{}
+	assert.NoError(q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, vcStore))
+
+	assert.NoError(q.addDevice(config.VFIODev{ID: "vfio0", BDF: "02:10.0"}, vfioDev))
+	assert.NoError(q.addDevice(config.VhostUserDeviceAttrs{
+		DevID:      "xdp-eth0",
+		SocketPath: "/tmp/xdp-eth0.sock",
+		MacAddress: "02:00:ca:fe:00:00",
+		Type:       config.VhostUserNet,
+	}, vhostuserDev))
+	assert.NoError(q.addDevice(types.Socket{
+		DeviceID: "channel0",
+		ID:       "charch0",
+		HostPath: "/tmp/kata.sock",
+		Name:     "agent.channel.0",
+	}, serialPortDev))
+	assert.NoError(q.addDevice(types.Volume{
+		MountTag: mountGuest9pTag,
+		HostPath: "/tmp/shared",
+	}, fsDev))
+
+	var ids []string
+	for _, d := range planDevices(q.qemuConfig.Devices) {
+		switch v := d.(type) {
+		case govmmQemu.BridgeDevice:
+			ids = append(ids, v.ID)
+		case govmmQemu.SerialDevice:
+			ids = append(ids, v.ID)
+		case govmmQemu.CharDevice:
+			ids = append(ids, v.ID)
+		case govmmQemu.RngDevice:
+			ids = append(ids, v.ID)
+		case govmmQemu.SCSIController:
+			ids = append(ids, v.ID+"/"+v.IOThread)
+		case govmmQemu.Object:
+			ids = append(ids, v.DeviceID)
+		case govmmQemu.FSDevice:
+			ids = append(ids, v.ID)
+		case govmmQemu.VhostUserDevice:
+			ids = append(ids, v.TypeDevID)
+		case govmmQemu.VFIODevice:
+			ids = append(ids, v.BDF)
+		default:
+			t.Fatalf("unexpected device %#v", d)
+		}
+	}
+
+	assert.Equal([]string{
+		"pci-bridge-0",
+		"serial0", "charconsole0",
+		rngID,
+		scsiControllerID + "/" + scsiIOThreadID,
+		"nv0",
+		"extra-9p-" + mountGuest9pTag,
+		"net-xdp-eth0",
+		"02:10.0", "charch0",
+	}, ids)
+}
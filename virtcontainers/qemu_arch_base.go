@@ -7,8 +7,6 @@ package virtcontainers
 
 import (
 	"context"
-	"encoding/hex"
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -16,8 +14,10 @@ import (
 	govmmQemu "github.com/intel/govmm/qemu"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/kata-containers/runtime/virtcontainers/utils"
+	"github.com/pkg/errors"
 )
 
 type qemuArch interface {
@@ -88,6 +88,9 @@ type qemuArch interface {
 	// appendBlockDevice appends a block drive to devices
 	appendBlockDevice(devices []govmmQemu.Device, drive config.BlockDrive) ([]govmmQemu.Device, error)
 
+	// appendVirtioPmemDevice appends a block drive as a virtio-pmem device
+	appendVirtioPmemDevice(devices []govmmQemu.Device, drive config.BlockDrive) ([]govmmQemu.Device, error)
+
 	// appendVhostUserDevice appends a vhost user device to devices
 	appendVhostUserDevice(devices []govmmQemu.Device, drive config.VhostUserDeviceAttrs) ([]govmmQemu.Device, error)
 
@@ -100,9 +103,18 @@ type qemuArch interface {
 	// addDeviceToBridge adds devices to the bus
 	addDeviceToBridge(ID string, t types.Type) (string, types.Bridge, error)
 
+	// hotplugAddDeviceToBridge adds hotplugged devices to the bus,
+	// hotplugging a new bridge when the existing ones are full
+	hotplugAddDeviceToBridge(ctx context.Context, ID string, t types.Type) (string, types.Bridge, error)
+
 	// removeDeviceFromBridge removes devices to the bus
 	removeDeviceFromBridge(ID string) error
 
+	// freeBridgeSlots returns the number of devices of type t that can
+	// still be hotplugged on the bridges, including the bridges that
+	// can be hotplugged
+	freeBridgeSlots(t types.Type) uint32
+
 	// getBridges grants access to Bridges
 	getBridges() []types.Bridge
 
@@ -118,13 +130,40 @@ type qemuArch interface {
 	// supportGuestMemoryHotplug returns if the guest supports memory hotplug
 	supportGuestMemoryHotplug() bool
 
+	// supportGuestCPUHotplug returns if the guest supports vCPU hotplug
+	supportGuestCPUHotplug() bool
+
 	// setIgnoreSharedMemoryMigrationCaps set bypass-shared-memory capability for migration
 	setIgnoreSharedMemoryMigrationCaps(context.Context, *govmmQemu.QMP) error
+
+	// appendProtectionDevice appends the device encrypting the memory of a
+	// confidential guest
+	appendProtectionDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error)
+
+	// hotplugAddNetDevice hot adds the device of a network endpoint, the
+	// netdev of its tap interface being already added
+	hotplugAddNetDevice(ctx context.Context, qmp *govmmQemu.QMP, endpoint Endpoint, tap TapInterface, devID string, queues int) error
+
+	// appendSGXEPCDevice appends the Intel SGX enclave page cache section
+	// of the guest
+	appendSGXEPCDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error)
+
+	// setCompat sets the features available in the QEMU version in use
+	setCompat(compat qemuCompat)
+
+	// setQMPExt sets the function returning the client of the QMP
+	// commands the govmm client does not provide
+	setQMPExt(qmpExt func() (qmpExecutor, error))
+
+	// hotplugAddBlockDevice hot adds the device of a block drive using
+	// blockDeviceDriver, the drive being already added
+	hotplugAddBlockDevice(ctx context.Context, qmp *govmmQemu.QMP, drive *config.BlockDrive, blockDeviceDriver, devID string) error
 }
 
 type qemuArchBase struct {
 	machineType           string
 	memoryOffset          uint32
+	maxBridges            uint32
 	nestedRun             bool
 	vhost                 bool
 	networkIndex          int
@@ -134,6 +173,8 @@ type qemuArchBase struct {
 	kernelParamsDebug     []Param
 	kernelParams          []Param
 	Bridges               []types.Bridge
+	compat                qemuCompat
+	qmpExt                func() (qmpExecutor, error)
 }
 
 const (
@@ -152,6 +193,9 @@ const (
 // 0 is reserved.
 const bridgePCIStartAddr = 2
 
+// maxPCISlots is the number of slots of a PCI bus.
+const maxPCISlots = 32
+
 const (
 	// QemuPCLite is the QEMU pc-lite machine type for amd64
 	QemuPCLite = "pc-lite"
@@ -162,9 +206,12 @@ const (
 	// QemuQ35 is the QEMU Q35 machine type for amd64
 	QemuQ35 = "q35"
 
-	// QemuVirt is the QEMU virt machine type for aarch64 or amd64
+	// QemuVirt is the QEMU virt machine type for aarch64, amd64 or riscv64
 	QemuVirt = "virt"
 
+	// QemuMicrovm is the QEMU microvm machine type for amd64
+	QemuMicrovm = "microvm"
+
 	// QemuPseries is a QEMU virt machine type for ppc64le
 	QemuPseries = "pseries"
 
@@ -320,17 +367,10 @@ func genericImage(path string) (config.BlockDrive, error) {
 		return config.BlockDrive{}, err
 	}
 
-	randBytes, err := utils.GenerateRandomBytes(8)
-	if err != nil {
-		return config.BlockDrive{}, err
-	}
-
-	id := utils.MakeNameID("image", hex.EncodeToString(randBytes), maxDevIDSize)
-
 	drive := config.BlockDrive{
 		File:   path,
 		Format: "raw",
-		ID:     id,
+		ID:     imageID,
 	}
 
 	return drive, nil
@@ -357,10 +397,8 @@ func genericSCSIController(enableIOThreads, nestedRun bool) (govmmQemu.SCSIContr
 	var t *govmmQemu.IOThread
 
 	if enableIOThreads {
-		randBytes, _ := utils.GenerateRandomBytes(8)
-
 		t = &govmmQemu.IOThread{
-			ID: fmt.Sprintf("%s-%s", "iothread", hex.EncodeToString(randBytes)),
+			ID: scsiIOThreadID,
 		}
 
 		scsiController.IOThread = t.ID
@@ -514,6 +552,20 @@ func genericNetwork(endpoint Endpoint, vhost, nestedRun bool, index int) (govmmQ
 			FDs:           ep.VMFds,
 			VhostFDs:      ep.VhostFds,
 		}
+	case *TapFdEndpoint:
+		d = govmmQemu.NetDevice{
+			Type:          govmmQemu.TAP,
+			Driver:        govmmQemu.VirtioNet,
+			ID:            fmt.Sprintf("network-%d", index),
+			IFName:        ep.TapInterface.TAPIface.Name,
+			MACAddress:    ep.HardwareAddr(),
+			DownScript:    "no",
+			Script:        "no",
+			VHost:         vhost,
+			DisableModern: nestedRun,
+			FDs:           ep.TapInterface.VMFds,
+			VhostFDs:      ep.TapInterface.VhostFds,
+		}
 	default:
 		return govmmQemu.NetDevice{}, fmt.Errorf("Unknown type for endpoint")
 	}
@@ -552,12 +604,102 @@ func genericBlockDevice(drive config.BlockDrive, nestedRun bool) (govmmQemu.Bloc
 	}, nil
 }
 
+// serialBlockDevice is a block device exposing the serial number of its
+// drive to the guest.
+type serialBlockDevice struct {
+	govmmQemu.BlockDevice
+	Serial string
+}
+
+// QemuParams returns the qemu parameters of the block device, with the
+// serial number set on the guest device.
+func (d serialBlockDevice) QemuParams(config *govmmQemu.Config) []string {
+	params := d.BlockDevice.QemuParams(config)
+
+	for i := 1; i < len(params); i++ {
+		if params[i-1] == "-device" {
+			params[i] += ",serial=" + d.Serial
+		}
+	}
+
+	return params
+}
+
+// blockDeviceWithSerial returns the block device d, exposing serial to the
+// guest if set.
+func blockDeviceWithSerial(d govmmQemu.BlockDevice, serial string) govmmQemu.Device {
+	if serial == "" {
+		return d
+	}
+
+	return serialBlockDevice{d, serial}
+}
+
 func (q *qemuArchBase) appendBlockDevice(devices []govmmQemu.Device, drive config.BlockDrive) ([]govmmQemu.Device, error) {
 	d, err := genericBlockDevice(drive, q.nestedRun)
 	if err != nil {
 		return devices, fmt.Errorf("Failed to append block device %v", err)
 	}
-	devices = append(devices, d)
+	devices = append(devices, blockDeviceWithSerial(d, drive.Serial))
+	return devices, nil
+}
+
+// virtioPmemMemID returns the id of the memory backend of a virtio-pmem drive.
+func virtioPmemMemID(driveID string) string {
+	return "pmemmem-" + driveID
+}
+
+// virtioPmemDevice is a drive exposed to the guest as a virtio-pmem device,
+// mapping the drive into the guest address space to allow DAX.
+type virtioPmemDevice struct {
+	ID      string
+	MemPath string
+	Size    int64
+	Bus     string
+	Addr    string
+}
+
+// Valid returns true if the device has an id and a backing file.
+func (d virtioPmemDevice) Valid() bool {
+	return d.ID != "" && d.MemPath != "" && d.Size > 0
+}
+
+// QemuParams returns the qemu parameters built out of the virtio-pmem device.
+func (d virtioPmemDevice) QemuParams(config *govmmQemu.Config) []string {
+	memID := virtioPmemMemID(d.ID)
+
+	object := fmt.Sprintf("memory-backend-file,id=%s,mem-path=%s,size=%d,share=on", memID, d.MemPath, d.Size)
+	device := fmt.Sprintf("virtio-pmem-pci,memdev=%s,id=virtio-%s", memID, d.ID)
+	if d.Bus != "" {
+		device += fmt.Sprintf(",bus=%s,addr=%s", d.Bus, d.Addr)
+	}
+
+	return []string{"-object", object, "-device", device}
+}
+
+func (q *qemuArchBase) appendVirtioPmemDevice(devices []govmmQemu.Device, drive config.BlockDrive) ([]govmmQemu.Device, error) {
+	if drive.File == "" || drive.ID == "" {
+		return devices, fmt.Errorf("Empty File or ID for drive %v", drive)
+	}
+
+	size, err := blockDeviceSize(drive.File)
+	if err != nil {
+		return devices, err
+	}
+
+	addr, bridge, err := q.addDeviceToBridge(drive.ID, types.PCI)
+	if err != nil {
+		return devices, err
+	}
+
+	devices = append(devices, virtioPmemDevice{
+		ID:      drive.ID,
+		MemPath: drive.File,
+		Size:    size,
+		Bus:     bridge.ID,
+		Addr:    addr,
+	})
+
 	return devices, nil
 }
 
@@ -625,6 +767,10 @@ func (q *qemuArchBase) supportGuestMemoryHotplug() bool {
 	return true
 }
 
+func (q *qemuArchBase) supportGuestCPUHotplug() bool {
+	return true
+}
+
 func (q *qemuArchBase) setIgnoreSharedMemoryMigrationCaps(ctx context.Context, qmp *govmmQemu.QMP) error {
 	err := qmp.ExecSetMigrationCaps(ctx, []map[string]interface{}{
 		{
@@ -635,6 +781,88 @@ func (q *qemuArchBase) setIgnoreSharedMemoryMigrationCaps(ctx context.Context, q
 	return err
 }
 
+func (q *qemuArchBase) hotplugAddNetDevice(ctx context.Context, qmp *govmmQemu.QMP, endpoint Endpoint, tap TapInterface, devID string, queues int) (err error) {
+	addr, bridge, err := q.hotplugAddDeviceToBridge(ctx, tap.ID, types.PCI)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			q.removeDeviceFromBridge(tap.ID)
+		}
+	}()
+
+	// PCI address is in the format bridge-addr/device-addr eg. "03/02"
+	endpoint.SetPciAddr(fmt.Sprintf("%02x/%s", bridge.Addr, addr))
+
+	return qmpExecute("device_add", func() error {
+		return qmp.ExecuteNetPCIDeviceAdd(ctx, tap.Name, devID, endpoint.HardwareAddr(), addr, bridge.ID, romFile, queues, defaultDisableModern)
+	})
+}
+
+func (q *qemuArchBase) hotplugAddBlockDevice(ctx context.Context, qmp *govmmQemu.QMP, drive *config.BlockDrive, blockDeviceDriver, devID string) (err error) {
+	switch blockDeviceDriver {
+	case config.VirtioBlock:
+		driver := "virtio-blk-pci"
+
+		var addr string
+		var bridge types.Bridge
+		addr, bridge, err = q.hotplugAddDeviceToBridge(ctx, drive.ID, types.PCI)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err != nil {
+				q.removeDeviceFromBridge(drive.ID)
+			}
+		}()
+
+		// PCI address is in the format bridge-addr/device-addr eg. "03/02"
+		drive.PCIAddr = fmt.Sprintf("%02x", bridge.Addr) + "/" + addr
+
+		if drive.Serial == "" {
+			return qmpExecute("device_add", func() error {
+				return qmp.ExecutePCIDeviceAdd(ctx, drive.ID, devID, driver, addr, bridge.ID, romFile, 0, true, defaultDisableModern)
+			})
+		}
+
+		args := map[string]interface{}{
+			"id":       devID,
+			"driver":   driver,
+			"drive":    drive.ID,
+			"addr":     addr,
+			"bus":      bridge.ID,
+			"romfile":  romFile,
+			"share-rw": "on",
+			"serial":   drive.Serial,
+		}
+		if defaultDisableModern {
+			args["disable-modern"] = true
+		}
+
+		return q.deviceAdd(ctx, args)
+	case config.VirtioSCSI:
+		driver := "scsi-hd"
+
+		// Bus exposed by the SCSI Controller
+		bus := scsiControllerID + ".0"
+
+		// Get SCSI-id and LUN based on the order of attaching drives.
+		scsiID, lun, err := utils.GetSCSIIdLun(drive.Index)
+		if err != nil {
+			return err
+		}
+
+		return qmpExecute("device_add", func() error {
+			return qmp.ExecuteSCSIDeviceAdd(ctx, drive.ID, devID, driver, bus, romFile, scsiID, lun, true, defaultDisableModern)
+		})
+	default:
+		return fmt.Errorf("Block device %s not recognized", blockDeviceDriver)
+	}
+}
+
 func (q *qemuArchBase) addDeviceToBridge(ID string, t types.Type) (string, types.Bridge, error) {
 	var err error
 	var addr uint32
@@ -659,7 +887,165 @@ func (q *qemuArchBase) addDeviceToBridge(ID string, t types.Type) (string, types
 		}
 	}
 
-	return "", types.Bridge{}, fmt.Errorf("no more bridge slots available")
+	return "", types.Bridge{}, errors.Wrap(vcTypes.ErrResourceExhausted, "no more bridge slots available")
+}
+
+func (q *qemuArchBase) hotplugAddDeviceToBridge(ctx context.Context, ID string, t types.Type) (string, types.Bridge, error) {
+	addr, bridge, err := q.addDeviceToBridge(ID, t)
+	if err == nil || len(q.Bridges) == 0 {
+		return addr, bridge, err
+	}
+
+	if hotplugErr := q.hotplugBridge(ctx, t); hotplugErr != nil {
+		return "", types.Bridge{}, fmt.Errorf("%v: %v", err, hotplugErr)
+	}
+
+	return q.addDeviceToBridge(ID, t)
+}
+
+func (q *qemuArchBase) freeBridgeSlots(t types.Type) uint32 {
+	var free uint32
+
+	for _, b := range q.Bridges {
+		if b.Type == t && uint32(len(b.Devices)) < b.MaxCapacity {
+			free += b.MaxCapacity - uint32(len(b.Devices))
+		}
+	}
+
+	if len(q.Bridges) > 0 && uint32(len(q.Bridges)) < q.maxBridges &&
+		q.machineType == QemuPC && t == types.PCI && q.compat.supports(qemuFeatureSHPC) {
+		free += (q.maxBridges - uint32(len(q.Bridges))) * types.PCIBridgeMaxCapacity
+	}
+
+	return free
+}
+
+// hotplugBridge hotplugs a new bridge on the root bus, as long as there are
+// less than maxBridges bridges. Only the pc machine root bus supports
+// hotplugging bridges.
+func (q *qemuArchBase) hotplugBridge(ctx context.Context, t types.Type) error {
+	if q.machineType != QemuPC || t != types.PCI {
+		return fmt.Errorf("%s bridges cannot be hotplugged on %s machines", t, q.machineType)
+	}
+
+	// Only SHPC enumerates the devices of the bridges hotplugged after the
+	// ACPI tables have been built.
+	if !q.compat.supports(qemuFeatureSHPC) {
+		return fmt.Errorf("bridges cannot be hotplugged without SHPC on QEMU %s", q.compat.version)
+	}
+
+	idx := len(q.Bridges)
+	if uint32(idx) >= q.maxBridges {
+		return fmt.Errorf("the maximum number of bridges (%d) is reached", q.maxBridges)
+	}
+
+	ext, err := q.qmpExecutor()
+	if err != nil {
+		return err
+	}
+
+	var pciInfo []pciBusInfo
+	if err := ext.Execute(ctx, "query-pci", nil, &pciInfo); err != nil {
+		return err
+	}
+
+	slot, err := freeRootBusSlot(pciInfo)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%s-bridge-%d", t, idx)
+
+	// Each bridge is required to be assigned a unique chassis id > 0, and
+	// SHPC enumerates the devices hotplugged on it.
+	args := map[string]interface{}{
+		"driver":     "pci-bridge",
+		"id":         id,
+		"bus":        defaultPCBridgeBus,
+		"addr":       fmt.Sprintf("%02x", slot),
+		"chassis_nr": strconv.Itoa(idx + 1),
+		"shpc":       "on",
+	}
+	if err := ext.Execute(ctx, "device_add", args, nil); err != nil {
+		return err
+	}
+
+	q.addBridge(types.NewBridge(t, id, make(map[uint32]string), slot))
+
+	return nil
+}
+
+func (q *qemuArchBase) setCompat(compat qemuCompat) {
+	q.compat = compat
+}
+
+func (q *qemuArchBase) setQMPExt(qmpExt func() (qmpExecutor, error)) {
+	q.qmpExt = qmpExt
+}
+
+// qmpExecutor returns the client of the QMP commands the govmm client does
+// not provide.
+func (q *qemuArchBase) qmpExecutor() (qmpExecutor, error) {
+	if q.qmpExt == nil {
+		return nil, errors.Wrap(vcTypes.ErrNotSupported, "no extra QMP monitor")
+	}
+
+	return q.qmpExt()
+}
+
+// deviceAdd hotplugs a device with properties the govmm client cannot
+// pass, e.g. the serial number of a block device.
+func (q *qemuArchBase) deviceAdd(ctx context.Context, args map[string]interface{}) error {
+	ext, err := q.qmpExecutor()
+	if err != nil {
+		return err
+	}
+
+	return ext.Execute(ctx, "device_add", args, nil)
+}
+
+// qmpExecutor executes the QMP commands the govmm client does not provide.
+type qmpExecutor interface {
+	Execute(ctx context.Context, command string, arguments interface{}, result interface{}) error
+}
+
+// pciBusInfo is a PCI bus and the devices plugged on it, as returned by
+// query-pci.
+type pciBusInfo struct {
+	Bus     int             `json:"bus"`
+	Devices []pciDeviceInfo `json:"devices"`
+}
+
+// pciDeviceInfo is the address of a device plugged on a PCI bus.
+type pciDeviceInfo struct {
+	Bus  int `json:"bus"`
+	Slot int `json:"slot"`
+}
+
+// freeRootBusSlot returns the first free slot of the PCI root bus, that can
+// be used by a bridge.
+func freeRootBusSlot(pciInfo []pciBusInfo) (int, error) {
+	used := make(map[int]bool)
+
+	for _, bus := range pciInfo {
+		if bus.Bus != 0 {
+			continue
+		}
+
+		for _, dev := range bus.Devices {
+			if dev.Bus == 0 {
+				used[dev.Slot] = true
+			}
+		}
+	}
+
+	for slot := bridgePCIStartAddr; slot < maxPCISlots; slot++ {
+		if !used[slot] {
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free slot on the PCI root bus")
 }
 
 func (q *qemuArchBase) removeDeviceFromBridge(ID string) error {
@@ -686,3 +1072,11 @@ func (q *qemuArchBase) setBridges(bridges []types.Bridge) {
 func (q *qemuArchBase) addBridge(b types.Bridge) {
 	q.Bridges = append(q.Bridges, b)
 }
+
+func (q *qemuArchBase) appendProtectionDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	return devices, fmt.Errorf("Confidential guests are not supported on this architecture")
+}
+
+func (q *qemuArchBase) appendSGXEPCDevice(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	return devices, fmt.Errorf("SGX enclaves are not supported on this architecture")
+}
@@ -6,9 +6,11 @@
 package virtcontainers
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/pkg/errors"
@@ -175,8 +178,8 @@ func TestQemuAddDeviceToBridge(t *testing.T) {
 
 	// fail to add device to bridge cause no more available bridge slot
 	_, _, err := q.addDeviceToBridge("qemu-bridge-31", types.PCI)
-	exceptErr := errors.New("no more bridge slots available")
-	assert.Equal(exceptErr.Error(), err.Error())
+	assert.Equal(vcTypes.ErrResourceExhausted, errors.Cause(err))
+	assert.Contains(err.Error(), "no more bridge slots available")
 
 	// addDeviceToBridge fails cause q.Bridges == 0
 	q = newQemuArchBase()
@@ -184,11 +187,167 @@ func TestQemuAddDeviceToBridge(t *testing.T) {
 	q.bridges(0)
 	_, _, err = q.addDeviceToBridge("qemu-bridge", types.PCI)
 	if assert.Error(err) {
-		exceptErr = errors.New("failed to get available address from bridges")
+		exceptErr := errors.New("failed to get available address from bridges")
 		assert.Equal(exceptErr.Error(), err.Error())
 	}
 }
 
+func TestQemuHotplugAddDeviceToBridge(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newQemuArchBase()
+	q.machineType = QemuPC
+	q.maxBridges = 1
+
+	q.bridges(1)
+	for i := uint32(1); i <= types.PCIBridgeMaxCapacity; i++ {
+		_, _, err := q.hotplugAddDeviceToBridge(context.Background(), fmt.Sprintf("qemu-bridge-%d", i), types.PCI)
+		assert.NoError(err)
+	}
+
+	// the maximum number of bridges is reached
+	_, _, err := q.hotplugAddDeviceToBridge(context.Background(), "qemu-bridge-31", types.PCI)
+	assert.Error(err)
+	assert.Contains(err.Error(), "no more bridge slots available")
+	assert.Len(q.getBridges(), 1)
+}
+
+func TestQemuHotplugBridge(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newQemuArchBase()
+	q.machineType = QemuQ35
+	q.maxBridges = 2
+	q.bridges(1)
+
+	// the q35 root bus does not support hotplug
+	assert.Error(q.hotplugBridge(context.Background(), types.PCI))
+
+	q.machineType = QemuPC
+	assert.Error(q.hotplugBridge(context.Background(), types.PCIE))
+
+	q.maxBridges = 1
+	assert.Error(q.hotplugBridge(context.Background(), types.PCI))
+
+	// hotplugged bridges require SHPC
+	q.maxBridges = 2
+	q.setCompat(qemuCompat{version: qemuVersion{6, 1, 0}})
+	err := q.hotplugBridge(context.Background(), types.PCI)
+	assert.Error(err)
+	assert.Contains(err.Error(), "SHPC")
+}
+
+type mockQMPExecutor struct {
+	pciInfo []pciBusInfo
+	args    map[string]interface{}
+}
+
+func (m *mockQMPExecutor) Execute(ctx context.Context, command string, arguments interface{}, result interface{}) error {
+	switch command {
+	case "query-pci":
+		*result.(*[]pciBusInfo) = m.pciInfo
+	case "device_add":
+		m.args = arguments.(map[string]interface{})
+	default:
+		return fmt.Errorf("unexpected QMP command %s", command)
+	}
+	return nil
+}
+
+func TestQemuHotplugBridgeQMP(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newQemuArchBase()
+	q.machineType = QemuPC
+	q.maxBridges = 2
+	q.bridges(1)
+
+	// the bridges are hotplugged through the extra QMP monitor
+	err := q.hotplugBridge(context.Background(), types.PCI)
+	assert.Error(err)
+
+	ext := &mockQMPExecutor{
+		pciInfo: []pciBusInfo{{Bus: 0, Devices: []pciDeviceInfo{{Bus: 0, Slot: bridgePCIStartAddr}}}},
+	}
+	q.setQMPExt(func() (qmpExecutor, error) { return ext, nil })
+
+	assert.NoError(q.hotplugBridge(context.Background(), types.PCI))
+	assert.Equal(map[string]interface{}{
+		"driver":     "pci-bridge",
+		"id":         "pci-bridge-1",
+		"bus":        defaultPCBridgeBus,
+		"addr":       fmt.Sprintf("%02x", bridgePCIStartAddr+1),
+		"chassis_nr": "2",
+		"shpc":       "on",
+	}, ext.args)
+
+	bridges := q.getBridges()
+	assert.Len(bridges, 2)
+	assert.Equal("pci-bridge-1", bridges[1].ID)
+	assert.Equal(bridgePCIStartAddr+1, bridges[1].Addr)
+}
+
+func TestQemuFreeBridgeSlots(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newQemuArchBase()
+	q.machineType = QemuPC
+	q.maxBridges = 1
+	assert.Zero(q.freeBridgeSlots(types.PCI))
+
+	q.bridges(1)
+	assert.Equal(uint32(types.PCIBridgeMaxCapacity), q.freeBridgeSlots(types.PCI))
+	assert.Zero(q.freeBridgeSlots(types.PCIE))
+
+	_, _, err := q.addDeviceToBridge("qemu-bridge-1", types.PCI)
+	assert.NoError(err)
+	assert.Equal(uint32(types.PCIBridgeMaxCapacity-1), q.freeBridgeSlots(types.PCI))
+
+	// the bridges that can be hotplugged are accounted
+	q.maxBridges = 3
+	assert.Equal(uint32(3*types.PCIBridgeMaxCapacity-1), q.freeBridgeSlots(types.PCI))
+
+	q.setCompat(qemuCompat{version: qemuVersion{6, 1, 0}})
+	assert.Equal(uint32(types.PCIBridgeMaxCapacity-1), q.freeBridgeSlots(types.PCI))
+}
+
+func TestFreeRootBusSlot(t *testing.T) {
+	assert := assert.New(t)
+
+	slot, err := freeRootBusSlot(nil)
+	assert.NoError(err)
+	assert.Equal(bridgePCIStartAddr, slot)
+
+	pciInfo := []pciBusInfo{
+		{
+			Bus: 0,
+			Devices: []pciDeviceInfo{
+				{Bus: 0, Slot: 0},
+				{Bus: 0, Slot: 1},
+				{Bus: 0, Slot: 2},
+				{Bus: 0, Slot: 4},
+			},
+		},
+		{
+			Bus: 1,
+			Devices: []pciDeviceInfo{
+				{Bus: 1, Slot: 3},
+			},
+		},
+	}
+
+	slot, err = freeRootBusSlot(pciInfo)
+	assert.NoError(err)
+	assert.Equal(3, slot)
+
+	for i := 0; i < maxPCISlots; i++ {
+		pciInfo[0].Devices = append(pciInfo[0].Devices, pciDeviceInfo{Bus: 0, Slot: i})
+	}
+
+	_, err = freeRootBusSlot(pciInfo)
+	assert.Error(err)
+}
+
 func TestQemuArchBaseCPUTopology(t *testing.T) {
 	assert := assert.New(t)
 	qemuArchBase := newQemuArchBase()
@@ -392,15 +551,13 @@ func TestQemuArchBaseAppendBlockDevice(t *testing.T) {
 	file := "/root"
 	format := "raw"
 
-	expectedOut := []govmmQemu.Device{
-		govmmQemu.BlockDevice{
-			Driver:    govmmQemu.VirtioBlock,
-			ID:        id,
-			File:      "/root",
-			AIO:       govmmQemu.Threads,
-			Format:    govmmQemu.BlockDeviceFormat(format),
-			Interface: "none",
-		},
+	blockDevice := govmmQemu.BlockDevice{
+		Driver:    govmmQemu.VirtioBlock,
+		ID:        id,
+		File:      "/root",
+		AIO:       govmmQemu.Threads,
+		Format:    govmmQemu.BlockDeviceFormat(format),
+		Interface: "none",
 	}
 
 	drive := config.BlockDrive{
@@ -409,7 +566,53 @@ func TestQemuArchBaseAppendBlockDevice(t *testing.T) {
 		ID:     id,
 	}
 
-	testQemuArchBaseAppend(t, drive, expectedOut)
+	testQemuArchBaseAppend(t, drive, []govmmQemu.Device{blockDevice})
+
+	drive.Serial = "0123456789"
+	testQemuArchBaseAppend(t, drive, []govmmQemu.Device{serialBlockDevice{blockDevice, "0123456789"}})
+}
+
+func TestSerialBlockDeviceQemuParams(t *testing.T) {
+	assert := assert.New(t)
+
+	d := govmmQemu.BlockDevice{
+		Driver:    govmmQemu.VirtioBlock,
+		ID:        "drive-0",
+		File:      "/root",
+		AIO:       govmmQemu.Threads,
+		Format:    govmmQemu.BlockDeviceFormat("raw"),
+		Interface: "none",
+	}
+	params := d.QemuParams(nil)
+
+	assert.Equal(d, blockDeviceWithSerial(d, ""))
+
+	serialDev := blockDeviceWithSerial(d, "0123456789")
+	assert.True(serialDev.Valid())
+	assert.Equal([]string{
+		"-device", params[1] + ",serial=0123456789",
+		"-drive", params[3],
+	}, serialDev.QemuParams(nil))
+
+	assert.Equal(deviceClassOther, classifyDevice(serialDev))
+}
+
+func TestQemuHotplugAddBlockDeviceSerial(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newQemuArchBase()
+	q.bridges(1)
+
+	ext := &mockQMPExecutor{}
+	q.setQMPExt(func() (qmpExecutor, error) { return ext, nil })
+
+	drive := &config.BlockDrive{ID: "drive-0", Serial: "0123456789"}
+	assert.NoError(q.hotplugAddBlockDevice(context.Background(), nil, drive, config.VirtioBlock, "virtio-drive-0"))
+	assert.Equal("0123456789", ext.args["serial"])
+	assert.Equal("drive-0", ext.args["drive"])
+	assert.Equal("virtio-drive-0", ext.args["id"])
+	assert.Equal(q.getBridges()[0].ID, ext.args["bus"])
+	assert.NotEmpty(drive.PCIAddr)
 }
 
 func TestQemuArchBaseAppendVhostUserDevice(t *testing.T) {
@@ -562,3 +765,81 @@ func TestQemuArchBaseAppendNetwork(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(expectedOut, devices)
 }
+
+func TestQemuArchBaseAppendProtectionDevice(t *testing.T) {
+	assert := assert.New(t)
+	qemuArchBase := newQemuArchBase()
+
+	devices, err := qemuArchBase.appendProtectionDevice(nil)
+	assert.Error(err)
+	assert.Empty(devices)
+}
+
+func TestQemuArchBaseAppendVirtioPmemDevice(t *testing.T) {
+	assert := assert.New(t)
+	qemuArchBase := newQemuArchBase()
+	qemuArchBase.bridges(1)
+
+	f, err := ioutil.TempFile("", "pmem")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(make([]byte, 4096))
+	assert.NoError(err)
+	f.Close()
+
+	_, err = qemuArchBase.appendVirtioPmemDevice(nil, config.BlockDrive{})
+	assert.Error(err)
+
+	drive := config.BlockDrive{
+		File: f.Name(),
+		ID:   "pmemTest",
+	}
+	devices, err := qemuArchBase.appendVirtioPmemDevice(nil, drive)
+	assert.NoError(err)
+	assert.Len(devices, 1)
+	assert.True(devices[0].Valid())
+
+	bridges := qemuArchBase.getBridges()
+	expectedParams := []string{
+		"-object", fmt.Sprintf("memory-backend-file,id=pmemmem-pmemTest,mem-path=%s,size=4096,share=on", f.Name()),
+		"-device", fmt.Sprintf("virtio-pmem-pci,memdev=pmemmem-pmemTest,id=virtio-pmemTest,bus=%s,addr=01", bridges[0].ID),
+	}
+	assert.Equal(expectedParams, devices[0].QemuParams(nil))
+}
+
+func TestQemuArchBaseHotplugAddNetDevice(t *testing.T) {
+	assert := assert.New(t)
+	qemuArchBase := newQemuArchBase()
+
+	endpoint := &TapEndpoint{}
+	tap := TapInterface{ID: "tap-id", Name: "tap0"}
+
+	// no bridges to plug the device into
+	err := qemuArchBase.hotplugAddNetDevice(context.Background(), nil, endpoint, tap, "virtio-"+tap.ID, 1)
+	assert.Error(err)
+	assert.Empty(endpoint.PciAddr())
+}
+
+func TestQemuArchBaseHotplugAddBlockDevice(t *testing.T) {
+	assert := assert.New(t)
+	qemuArchBase := newQemuArchBase()
+	ctx := context.Background()
+
+	drive := &config.BlockDrive{ID: "drive-id", Index: -1}
+
+	err := qemuArchBase.hotplugAddBlockDevice(ctx, nil, drive, "foo", "virtio-"+drive.ID)
+	assert.Error(err)
+
+	// no bridges to plug the device into
+	err = qemuArchBase.hotplugAddBlockDevice(ctx, nil, drive, config.VirtioBlock, "virtio-"+drive.ID)
+	assert.Error(err)
+	assert.Empty(drive.PCIAddr)
+
+	// invalid drive index
+	err = qemuArchBase.hotplugAddBlockDevice(ctx, nil, drive, config.VirtioSCSI, "virtio-"+drive.ID)
+	assert.Error(err)
+
+	// virtio-blk-ccw is s390x specific
+	err = qemuArchBase.hotplugAddBlockDevice(ctx, nil, drive, config.VirtioBlockCCW, "virtio-"+drive.ID)
+	assert.Error(err)
+}
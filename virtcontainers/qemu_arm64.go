@@ -144,6 +144,7 @@ func newQemuArch(config HypervisorConfig) qemuArch {
 		qemuArchBase{
 			machineType:           machineType,
 			memoryOffset:          config.MemOffset,
+			maxBridges:            config.MaxBridges,
 			qemuPaths:             qemuPaths,
 			supportedQemuMachines: supportedQemuMachines,
 			kernelParamsNonDebug:  kernelParamsNonDebug,
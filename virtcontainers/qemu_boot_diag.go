@@ -0,0 +1,180 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// bootDiagnoser is implemented by the hypervisors telling why their VM
+// failed to boot.
+type bootDiagnoser interface {
+	diagnoseBootFailure(err error, readConsole func() (string, error)) error
+}
+
+// bootStage is the stage a VM failed to boot at.
+type bootStage string
+
+const (
+	bootStageHypervisor bootStage = "hypervisor"
+	bootStageFirmware   bootStage = "firmware"
+	bootStageKernel     bootStage = "kernel"
+	bootStageAgent      bootStage = "agent"
+)
+
+const (
+	// bootDiagLogLines is the number of hypervisor log lines reported
+	// when the hypervisor exited.
+	bootDiagLogLines = 5
+
+	// bootConsoleProbeMaxSize is the maximum size of the console output
+	// read to diagnose a boot failure.
+	bootConsoleProbeMaxSize = 64 << 10
+)
+
+// bootConsoleProbeTime is how long the console is read to diagnose a boot
+// failure.
+var bootConsoleProbeTime = time.Second
+
+var (
+	kernelPanicRegexp  = regexp.MustCompile(`Kernel panic - not syncing:.*`)
+	kernelOutputRegexp = regexp.MustCompile(`(?m)^\[\s*\d+\.\d+\]|Linux version`)
+)
+
+// bootError is returned when a VM fails to boot in time, with the stage it
+// failed at.
+type bootError struct {
+	stage  bootStage
+	reason string
+	err    error
+}
+
+func (e *bootError) Error() string {
+	return fmt.Sprintf("VM failed to boot (%s stage): %s: %v", e.stage, e.reason, e.err)
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// classifyConsoleOutput returns the stage the guest is stuck at, from its
+// console output.
+func classifyConsoleOutput(output string) (bootStage, string) {
+	if strings.TrimSpace(output) == "" {
+		return bootStageFirmware, "no output on the guest console, the firmware or the kernel is stuck"
+	}
+
+	if msg := kernelPanicRegexp.FindString(output); msg != "" {
+		return bootStageKernel, fmt.Sprintf("the guest kernel panicked: %q", strings.TrimSpace(msg))
+	}
+
+	if kernelOutputRegexp.MatchString(output) {
+		return bootStageAgent, fmt.Sprintf("the guest kernel booted but the agent did not start, last console output: %q", lastLine(output))
+	}
+
+	return bootStageFirmware, fmt.Sprintf("the guest kernel did not start, last console output: %q", lastLine(output))
+}
+
+// probeConsole returns what the guest writes on the console socket path
+// within bootConsoleProbeTime. The socket has a single reader, so it is
+// only probed while the console is not watched.
+func probeConsole(path string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, bootConsoleProbeTime)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(bootConsoleProbeTime)); err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(conn, bootConsoleProbeMaxSize))
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		err = nil
+	}
+
+	return string(data), err
+}
+
+// qemuLogTail returns the last lines of the QEMU log, if any.
+func (q *qemu) qemuLogTail() []string {
+	if q.log == nil {
+		return nil
+	}
+
+	var lines []string
+	scrubber := newLogScrubber(q.config.LogScrubParams)
+	replayLog(q.log.path, q.config.logReplaySize(), scrubber, func(line string) {
+		lines = append(lines, line)
+		if len(lines) > bootDiagLogLines {
+			lines = lines[1:]
+		}
+	})
+
+	return lines
+}
+
+// hypervisorExitReason returns why QEMU is not running, false if it is.
+func (q *qemu) hypervisorExitReason() (string, bool) {
+	pid := q.getPids()[0]
+	if pid != 0 && syscall.Kill(pid, 0) != syscall.ESRCH {
+		return "", false
+	}
+
+	reason := "QEMU is not running"
+
+	if q.launchStderr != "" {
+		reason += fmt.Sprintf(", error messages: %q", strings.TrimSpace(q.launchStderr))
+	}
+
+	if lines := q.qemuLogTail(); len(lines) > 0 {
+		reason += fmt.Sprintf(", last log lines: %q", strings.Join(lines, "\n"))
+	}
+
+	return reason, true
+}
+
+// diagnoseQMPFailure returns the reason why the QMP socket did not answer
+// in time, err being the error the runtime gave up on.
+func (q *qemu) diagnoseQMPFailure(err error) error {
+	reason, exited := q.hypervisorExitReason()
+	if !exited {
+		reason = "QEMU is running but does not answer on its QMP socket"
+	}
+
+	return &bootError{stage: bootStageHypervisor, reason: reason, err: err}
+}
+
+// diagnoseBootFailure returns the reason why the agent did not answer in
+// time, err being the error the runtime gave up on: whether QEMU is still
+// running and, if so, the stage the guest is stuck at from the output
+// readConsole returns.
+func (q *qemu) diagnoseBootFailure(err error, readConsole func() (string, error)) error {
+	if reason, exited := q.hypervisorExitReason(); exited {
+		return &bootError{stage: bootStageHypervisor, reason: reason, err: err}
+	}
+
+	output, consoleErr := readConsole()
+	if consoleErr != nil {
+		return &bootError{
+			stage:  bootStageHypervisor,
+			reason: fmt.Sprintf("QEMU is running but its console cannot be read: %v", consoleErr),
+			err:    err,
+		}
+	}
+
+	stage, reason := classifyConsoleOutput(output)
+	return &bootError{stage: stage, reason: reason, err: err}
+}
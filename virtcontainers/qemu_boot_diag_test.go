@@ -0,0 +1,141 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyConsoleOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	type testData struct {
+		output string
+		stage  bootStage
+	}
+
+	for _, d := range []testData{
+		{"", bootStageFirmware},
+		{"\r\n", bootStageFirmware},
+		{"SeaBIOS (version 1.12.0)\nBooting from ROM...\n", bootStageFirmware},
+		{"[    0.000000] Linux version 5.4.15\n[    0.512000] Kernel panic - not syncing: VFS: Unable to mount root fs\n", bootStageKernel},
+		{"[    0.000000] Linux version 5.4.15\n[    1.024000] Run /sbin/init as init process\n", bootStageAgent},
+	} {
+		stage, reason := classifyConsoleOutput(d.output)
+		assert.Equal(d.stage, stage, "%q", d.output)
+		assert.NotEmpty(reason)
+	}
+
+	_, reason := classifyConsoleOutput("[    0.512000] Kernel panic - not syncing: VFS: Unable to mount root fs\n")
+	assert.Contains(reason, "VFS: Unable to mount root fs")
+}
+
+func TestQemuDiagnoseBootFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "boot-diag")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	q := &qemu{id: "boot-diag"}
+	q.qemuConfig.PidFile = filepath.Join(dir, "pid")
+	q.log = &hypervisorLog{path: filepath.Join(dir, "qemu.log")}
+	assert.NoError(ioutil.WriteFile(q.log.path, []byte("line 1\nqemu: could not load kernel\n"), 0644))
+
+	timeoutErr := errors.New("timeout")
+	consoleErr := errors.New("no console")
+
+	readConsole := func(output string, err error) func() (string, error) {
+		return func() (string, error) {
+			return output, err
+		}
+	}
+
+	// QEMU is not running.
+	err = q.diagnoseBootFailure(timeoutErr, readConsole("", consoleErr))
+	bootErr, ok := err.(*bootError)
+	assert.True(ok)
+	assert.Equal(bootStageHypervisor, bootErr.stage)
+	assert.Contains(bootErr.Error(), "could not load kernel")
+	assert.Contains(bootErr.Error(), "timeout")
+
+	err = q.diagnoseQMPFailure(timeoutErr)
+	bootErr, ok = err.(*bootError)
+	assert.True(ok)
+	assert.Equal(bootStageHypervisor, bootErr.stage)
+	assert.Contains(bootErr.Error(), "could not load kernel")
+
+	// QEMU is running, its QMP socket does not answer.
+	assert.NoError(ioutil.WriteFile(q.qemuConfig.PidFile, []byte(strconv.Itoa(os.Getpid())), 0644))
+
+	err = q.diagnoseQMPFailure(timeoutErr)
+	bootErr, ok = err.(*bootError)
+	assert.True(ok)
+	assert.Equal(bootStageHypervisor, bootErr.stage)
+	assert.Contains(bootErr.Error(), "QMP")
+
+	// QEMU is running, without a console.
+	err = q.diagnoseBootFailure(timeoutErr, readConsole("", consoleErr))
+	bootErr, ok = err.(*bootError)
+	assert.True(ok)
+	assert.Equal(bootStageHypervisor, bootErr.stage)
+	assert.Contains(bootErr.Error(), "no console")
+
+	// The guest kernel panics.
+	err = q.diagnoseBootFailure(timeoutErr, readConsole("[    0.512000] Kernel panic - not syncing: Attempted to kill init!\n", nil))
+	bootErr, ok = err.(*bootError)
+	assert.True(ok)
+	assert.Equal(bootStageKernel, bootErr.stage)
+	assert.Contains(bootErr.Error(), "Attempted to kill init!")
+}
+
+func TestProbeConsole(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "boot-diag")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedProbeTime := bootConsoleProbeTime
+	defer func() {
+		bootConsoleProbeTime = savedProbeTime
+	}()
+	bootConsoleProbeTime = 100 * time.Millisecond
+
+	console := filepath.Join(dir, "console.sock")
+	_, err = probeConsole(console)
+	assert.Error(err)
+
+	l, err := net.Listen("unix", console)
+	assert.NoError(err)
+	defer l.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("Booting from ROM...\n"))
+		<-done
+	}()
+
+	output, err := probeConsole(console)
+	assert.NoError(err)
+	assert.Equal("Booting from ROM...\n", output)
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+)
+
+const (
+	// qemuPropDisableModern is the property of the virtio PCI devices
+	// disabling the virtio 1.0 interface.
+	qemuPropDisableModern = "disable-modern"
+
+	// qemuPropCacheSize is the property of the virtio-fs device sizing
+	// its DAX window.
+	qemuPropCacheSize = "cache-size"
+)
+
+// variables rather than consts to allow tests to modify them
+var (
+	// qemuCapabilitiesDir caches the capabilities probed from each QEMU
+	// binary, for the other runtime processes to use them.
+	qemuCapabilitiesDir = filepath.Join(store.DefaultRunRootPath, "qemu-capabilities")
+
+	// qemuProbedDevices are the devices whose properties are probed.
+	qemuProbedDevices = []string{string(govmmQemu.VirtioBlockPCI), string(govmmQemu.VhostUserFS)}
+)
+
+// qemuBinaryCapabilities are the capabilities of a QEMU binary, probed through
+// QMP from the first VM it runs, and cached under the digest of the binary.
+type qemuBinaryCapabilities struct {
+	Commands              []string
+	Machines              []string
+	MigrationCapabilities []string
+
+	// DeviceProperties are the properties of qemuProbedDevices, a device
+	// is missing when the binary does not provide it.
+	DeviceProperties map[string][]string
+}
+
+func stringsContain(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *qemuBinaryCapabilities) hasCommand(name string) bool {
+	return stringsContain(c.Commands, name)
+}
+
+func (c *qemuBinaryCapabilities) hasMachine(name string) bool {
+	return stringsContain(c.Machines, name)
+}
+
+func (c *qemuBinaryCapabilities) hasMigrationCapability(name string) bool {
+	return stringsContain(c.MigrationCapabilities, name)
+}
+
+func (c *qemuBinaryCapabilities) hasDeviceProperty(device, prop string) bool {
+	return stringsContain(c.DeviceProperties[device], prop)
+}
+
+// qemuQuerier is the part of the QMP client the capabilities are probed
+// through.
+type qemuQuerier interface {
+	Execute(ctx context.Context, command string, arguments interface{}, result interface{}) error
+}
+
+// probeQemuCapabilities queries the capabilities of the running QEMU.
+func probeQemuCapabilities(ctx context.Context, qmp qemuQuerier) (*qemuBinaryCapabilities, error) {
+	caps := &qemuBinaryCapabilities{
+		DeviceProperties: make(map[string][]string),
+	}
+
+	var schema []struct {
+		Name     string `json:"name"`
+		MetaType string `json:"meta-type"`
+	}
+	if err := qmp.Execute(ctx, "query-qmp-schema", nil, &schema); err != nil {
+		return nil, fmt.Errorf("Could not query the QMP schema: %v", err)
+	}
+	for _, info := range schema {
+		if info.MetaType == "command" {
+			caps.Commands = append(caps.Commands, info.Name)
+		}
+	}
+
+	var machines []struct {
+		Name  string `json:"name"`
+		Alias string `json:"alias"`
+	}
+	if err := qmp.Execute(ctx, "query-machines", nil, &machines); err != nil {
+		return nil, fmt.Errorf("Could not query the machine types: %v", err)
+	}
+	for _, m := range machines {
+		caps.Machines = append(caps.Machines, m.Name)
+		if m.Alias != "" {
+			caps.Machines = append(caps.Machines, m.Alias)
+		}
+	}
+
+	var migrationCaps []struct {
+		Capability string `json:"capability"`
+	}
+	if err := qmp.Execute(ctx, "query-migrate-capabilities", nil, &migrationCaps); err != nil {
+		return nil, fmt.Errorf("Could not query the migration capabilities: %v", err)
+	}
+	for _, m := range migrationCaps {
+		caps.MigrationCapabilities = append(caps.MigrationCapabilities, m.Capability)
+	}
+
+	for _, device := range qemuProbedDevices {
+		var props []struct {
+			Name string `json:"name"`
+		}
+		// Fails when the binary does not provide the device.
+		if err := qmp.Execute(ctx, "device-list-properties", map[string]string{"typename": device}, &props); err != nil {
+			continue
+		}
+
+		var names []string
+		for _, p := range props {
+			names = append(names, p.Name)
+		}
+		sort.Strings(names)
+		caps.DeviceProperties[device] = names
+	}
+
+	sort.Strings(caps.Commands)
+	sort.Strings(caps.Machines)
+	sort.Strings(caps.MigrationCapabilities)
+
+	return caps, nil
+}
+
+var (
+	qemuCapabilitiesLock sync.Mutex
+	qemuCapabilitiesMap  = make(map[string]*qemuBinaryCapabilities)
+)
+
+// getQemuBinaryDigest returns the digest identifying the QEMU binary path,
+// computed from its path, inode, size and modification time rather than
+// from its content for the runtime processes not to read the binary.
+func getQemuBinaryDigest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d", path, ino, info.Size(), info.ModTime().UnixNano())
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadQemuCapabilities returns the digest of the QEMU binary path and its
+// capabilities, nil when they have not been probed yet.
+func loadQemuCapabilities(path string) (string, *qemuBinaryCapabilities, error) {
+	qemuCapabilitiesLock.Lock()
+	defer qemuCapabilitiesLock.Unlock()
+
+	digest, err := getQemuBinaryDigest(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if caps, ok := qemuCapabilitiesMap[digest]; ok {
+		return digest, caps, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(qemuCapabilitiesDir, digest+".json"))
+	if os.IsNotExist(err) {
+		return digest, nil, nil
+	}
+	if err != nil {
+		return digest, nil, err
+	}
+
+	var caps qemuBinaryCapabilities
+	if err := json.Unmarshal(data, &caps); err != nil {
+		return digest, nil, err
+	}
+	qemuCapabilitiesMap[digest] = &caps
+
+	return digest, &caps, nil
+}
+
+// saveQemuCapabilities caches the capabilities of the QEMU binary digest.
+func saveQemuCapabilities(digest string, caps *qemuBinaryCapabilities) error {
+	qemuCapabilitiesLock.Lock()
+	defer qemuCapabilitiesLock.Unlock()
+
+	qemuCapabilitiesMap[digest] = caps
+
+	data, err := json.Marshal(caps)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(qemuCapabilitiesDir, store.DirMode); err != nil {
+		return err
+	}
+
+	// Renamed into place, for the other runtime processes not to read a
+	// partial file.
+	path := filepath.Join(qemuCapabilitiesDir, digest+".json")
+	tmpFile, err := ioutil.TempFile(qemuCapabilitiesDir, digest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
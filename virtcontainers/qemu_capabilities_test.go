@@ -0,0 +1,127 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/stretchr/testify/assert"
+)
+
+type qemuQuerierMock struct {
+	deviceProperties map[string]string
+}
+
+func (m *qemuQuerierMock) Execute(ctx context.Context, command string, arguments interface{}, result interface{}) error {
+	var response string
+
+	switch command {
+	case "query-qmp-schema":
+		response = `[{"meta-type": "command", "name": "query-cpus-fast"}, {"meta-type": "object", "name": "MachineInfo"}, {"meta-type": "command", "name": "device_add"}]`
+	case "query-machines":
+		response = `[{"name": "pc-q35-4.1", "alias": "q35"}, {"name": "none"}]`
+	case "query-migrate-capabilities":
+		response = `[{"capability": "xbzrle", "state": false}, {"capability": "` + qmpCapMigrationIgnoreShared + `", "state": false}]`
+	case "device-list-properties":
+		typeName := arguments.(map[string]string)["typename"]
+		props, ok := m.deviceProperties[typeName]
+		if !ok {
+			return fmt.Errorf("Parameter 'typename' expects device type, got %s", typeName)
+		}
+		response = props
+	default:
+		return fmt.Errorf("unexpected command %s", command)
+	}
+
+	return json.Unmarshal([]byte(response), result)
+}
+
+func TestProbeQemuCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	qmp := &qemuQuerierMock{
+		deviceProperties: map[string]string{
+			string(govmmQemu.VirtioBlockPCI): `[{"name": "disable-modern", "type": "bool"}, {"name": "addr", "type": "int32"}]`,
+		},
+	}
+
+	caps, err := probeQemuCapabilities(context.Background(), qmp)
+	assert.NoError(err)
+	assert.Equal([]string{"device_add", "query-cpus-fast"}, caps.Commands)
+	assert.Equal([]string{"none", "pc-q35-4.1", "q35"}, caps.Machines)
+	assert.Equal([]string{qmpCapMigrationIgnoreShared, "xbzrle"}, caps.MigrationCapabilities)
+
+	assert.True(caps.hasCommand("query-cpus-fast"))
+	assert.False(caps.hasCommand("query-cpus"))
+	assert.True(caps.hasMachine("q35"))
+	assert.False(caps.hasMachine("pc"))
+	assert.True(caps.hasMigrationCapability(qmpCapMigrationIgnoreShared))
+	assert.True(caps.hasDeviceProperty(string(govmmQemu.VirtioBlockPCI), qemuPropDisableModern))
+	assert.False(caps.hasDeviceProperty(string(govmmQemu.VhostUserFS), qemuPropCacheSize))
+}
+
+func TestQemuCapabilitiesCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "qemu-capabilities")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedDir := qemuCapabilitiesDir
+	qemuCapabilitiesDir = filepath.Join(dir, "cache")
+	defer func() {
+		qemuCapabilitiesDir = savedDir
+	}()
+
+	qemuPath := filepath.Join(dir, "qemu")
+	assert.NoError(ioutil.WriteFile(qemuPath, []byte("qemu"), 0700))
+
+	_, _, err = loadQemuCapabilities(filepath.Join(dir, "missing"))
+	assert.Error(err)
+
+	// not probed yet
+	digest, caps, err := loadQemuCapabilities(qemuPath)
+	assert.NoError(err)
+	assert.Len(digest, 64)
+	assert.Nil(caps)
+
+	probed := &qemuBinaryCapabilities{
+		Machines:         []string{"q35"},
+		DeviceProperties: map[string][]string{},
+	}
+	assert.NoError(saveQemuCapabilities(digest, probed))
+
+	_, caps, err = loadQemuCapabilities(qemuPath)
+	assert.NoError(err)
+	assert.Equal(probed, caps)
+
+	// another runtime process reads them from the disk
+	delete(qemuCapabilitiesMap, digest)
+	_, caps, err = loadQemuCapabilities(qemuPath)
+	assert.NoError(err)
+	assert.Equal(probed, caps)
+
+	// a new binary is probed again
+	assert.NoError(ioutil.WriteFile(qemuPath, []byte("new qemu"), 0700))
+	newDigest, caps, err := loadQemuCapabilities(qemuPath)
+	assert.NoError(err)
+	assert.NotEqual(digest, newDigest)
+	assert.Nil(caps)
+
+	// a binary of the same size installed in its place
+	assert.NoError(ioutil.WriteFile(qemuPath+".new", []byte("qemu new"), 0700))
+	assert.NoError(os.Rename(qemuPath+".new", qemuPath))
+	digest, _, err = loadQemuCapabilities(qemuPath)
+	assert.NoError(err)
+	assert.NotEqual(newDigest, digest)
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/sirupsen/logrus"
+)
+
+// qemuVersion is the version of a QEMU binary.
+type qemuVersion struct {
+	major int
+	minor int
+	micro int
+}
+
+func (v qemuVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.micro)
+}
+
+func (v qemuVersion) isZero() bool {
+	return v == qemuVersion{}
+}
+
+// atLeast returns true if v is the same or a later version than other.
+func (v qemuVersion) atLeast(other qemuVersion) bool {
+	if v.major != other.major {
+		return v.major > other.major
+	}
+
+	if v.minor != other.minor {
+		return v.minor > other.minor
+	}
+
+	return v.micro >= other.micro
+}
+
+var qemuVersionRegexp = regexp.MustCompile(`version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseQemuVersion parses the output of qemu --version, e.g.
+// "QEMU emulator version 4.1.0 (kata-static)".
+func parseQemuVersion(output string) (qemuVersion, error) {
+	m := qemuVersionRegexp.FindStringSubmatch(output)
+	if m == nil {
+		return qemuVersion{}, fmt.Errorf("Could not find the QEMU version in %q", output)
+	}
+
+	var v qemuVersion
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.micro, _ = strconv.Atoi(m[3])
+	}
+
+	return v, nil
+}
+
+var (
+	qemuVersionsLock sync.Mutex
+	qemuVersions     = make(map[string]qemuVersion)
+)
+
+var probeQemuVersionFunc = probeQemuVersion
+
+// probeQemuVersion returns the version of the QEMU binary path. Versions are
+// cached since the same binary is used by all the sandboxes.
+func probeQemuVersion(path string) (qemuVersion, error) {
+	qemuVersionsLock.Lock()
+	defer qemuVersionsLock.Unlock()
+
+	if v, ok := qemuVersions[path]; ok {
+		return v, nil
+	}
+
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return qemuVersion{}, fmt.Errorf("Could not get %s version: %v", path, err)
+	}
+
+	v, err := parseQemuVersion(string(output))
+	if err != nil {
+		return qemuVersion{}, err
+	}
+
+	qemuVersions[path] = v
+
+	return v, nil
+}
+
+// qemuFeature is a QEMU feature the driver relies on, which is not available
+// in all the QEMU versions.
+type qemuFeature struct {
+	name string
+
+	// added is the first version providing the feature, zero if the
+	// feature predates all the supported versions.
+	added qemuVersion
+
+	// removed is the first version no longer providing the feature, zero
+	// if the feature is still there.
+	removed qemuVersion
+
+	// command is set if the feature is a QMP command, looked for in the
+	// probed capabilities rather than guessed from the version.
+	command bool
+}
+
+var (
+	// qemuFeatureSHPC is the standard hot plug controller of the PCI
+	// bridges. QEMU 6.1 handles the bridges hotplug through ACPI by
+	// default, which requires SHPC to be turned off.
+	qemuFeatureSHPC = qemuFeature{
+		name:    "shpc",
+		removed: qemuVersion{6, 1, 0},
+	}
+
+	// qemuFeatureQueryCpus is the query-cpus QMP command, removed in QEMU
+	// 6.0 in favor of query-cpus-fast.
+	qemuFeatureQueryCpus = qemuFeature{
+		name:    "query-cpus",
+		removed: qemuVersion{6, 0, 0},
+		command: true,
+	}
+
+	// qemuFeatureQueryCpusFast is the query-cpus-fast QMP command, which
+	// does not interrupt the vCPUs.
+	qemuFeatureQueryCpusFast = qemuFeature{
+		name:    "query-cpus-fast",
+		added:   qemuVersion{2, 12, 0},
+		command: true,
+	}
+
+	// qemuFeatureSGXEPC is the memory-backend-epc object providing the
+	// SGX enclave page cache of the guest.
+	qemuFeatureSGXEPC = qemuFeature{
+		name:  "memory-backend-epc",
+		added: qemuVersion{6, 2, 0},
+	}
+)
+
+// qemuCompat adapts the QEMU command line and QMP commands to the features
+// available in the QEMU version in use, instead of failing at launch.
+type qemuCompat struct {
+	// version is zero when it could not be probed.
+	version qemuVersion
+
+	// digest is the digest of the QEMU binary, empty when it could not be
+	// read.
+	digest string
+
+	// caps are nil until a VM of the QEMU binary has been probed, the
+	// driver then makes the assumptions it always did.
+	caps *qemuBinaryCapabilities
+}
+
+func newQemuCompat(path string, logger *logrus.Entry) qemuCompat {
+	var c qemuCompat
+
+	v, err := probeQemuVersionFunc(path)
+	if err != nil {
+		logger.WithError(err).Warn("Unknown QEMU version, assuming all the legacy features are available")
+	} else {
+		logger.WithField("qemu-version", v.String()).Debug("Probed QEMU version")
+		c.version = v
+	}
+
+	c.digest, c.caps, err = loadQemuCapabilities(path)
+	if err != nil {
+		logger.WithError(err).Warn("Could not load the QEMU capabilities")
+	}
+
+	return c
+}
+
+// supports returns true if the QEMU version provides feature. When the
+// version is unknown, only the features predating all the supported
+// versions are assumed to be available, as the driver always did.
+func (c qemuCompat) supports(feature qemuFeature) bool {
+	if feature.command && c.caps != nil {
+		return c.caps.hasCommand(feature.name)
+	}
+
+	if c.version.isZero() {
+		return feature.added.isZero()
+	}
+
+	if !feature.added.isZero() && !c.version.atLeast(feature.added) {
+		return false
+	}
+
+	return feature.removed.isZero() || !c.version.atLeast(feature.removed)
+}
+
+// supportsMachine returns true if QEMU provides the machine type, which is
+// assumed until the capabilities are probed.
+func (c qemuCompat) supportsMachine(machineType string) bool {
+	return c.caps == nil || c.caps.hasMachine(machineType)
+}
+
+// supportsMigrationCapability returns true if QEMU provides the migration
+// capability, which is assumed until the capabilities are probed.
+func (c qemuCompat) supportsMigrationCapability(capability string) bool {
+	return c.caps == nil || c.caps.hasMigrationCapability(capability)
+}
+
+// supportsDeviceProperty returns true if the device of QEMU has the
+// property, which is assumed until the capabilities are probed.
+func (c qemuCompat) supportsDeviceProperty(device, prop string) bool {
+	return c.caps == nil || c.caps.hasDeviceProperty(device, prop)
+}
+
+// adaptDevices rewrites the options of the devices that the QEMU version
+// does not support anymore, or that the QEMU binary does not provide.
+func (c qemuCompat) adaptDevices(devices []govmmQemu.Device, logger *logrus.Entry) []govmmQemu.Device {
+	shpc := c.supports(qemuFeatureSHPC)
+	dax := c.supportsDeviceProperty(string(govmmQemu.VhostUserFS), qemuPropCacheSize)
+	disableModern := c.supportsDeviceProperty(string(govmmQemu.VirtioBlockPCI), qemuPropDisableModern)
+
+	for i, d := range devices {
+		switch dev := d.(type) {
+		case govmmQemu.BridgeDevice:
+			if dev.Type != govmmQemu.PCIBridge || !dev.SHPC || shpc {
+				continue
+			}
+
+			logger.WithFields(logrus.Fields{
+				"qemu-version": c.version.String(),
+				"feature":      qemuFeatureSHPC.name,
+				"bridge":       dev.ID,
+			}).Warn("Feature not supported by this QEMU version, relying on ACPI hotplug")
+
+			dev.SHPC = false
+			devices[i] = dev
+		case govmmQemu.VhostUserDevice:
+			if dev.VhostUserType != govmmQemu.VhostUserFS || dev.CacheSize == 0 || dax {
+				continue
+			}
+
+			logger.WithFields(logrus.Fields{
+				"feature": qemuPropCacheSize,
+				"device":  dev.TypeDevID,
+			}).Warn("Feature not supported by this QEMU binary, sharing files without DAX")
+
+			dev.CacheSize = 0
+			devices[i] = dev
+		default:
+			if disableModern {
+				continue
+			}
+
+			if dev, ok := clearDisableModern(d); ok {
+				logger.WithFields(logrus.Fields{
+					"feature": qemuPropDisableModern,
+					"device":  fmt.Sprintf("%T", d),
+				}).Warn("Feature not supported by this QEMU binary, using the modern virtio interface")
+
+				devices[i] = dev
+			}
+		}
+	}
+
+	return devices
+}
+
+// clearDisableModern returns the device without disable-modern, and true
+// if it was set.
+func clearDisableModern(d govmmQemu.Device) (govmmQemu.Device, bool) {
+	var set bool
+
+	switch dev := d.(type) {
+	case govmmQemu.FSDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.CharDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.NetDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.SerialDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.BlockDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case serialBlockDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.SCSIController:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.VSOCKDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	case govmmQemu.BalloonDevice:
+		set, dev.DisableModern = dev.DisableModern, false
+		d = dev
+	}
+
+	return d, set
+}
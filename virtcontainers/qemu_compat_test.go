@@ -0,0 +1,164 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"testing"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQemuVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := parseQemuVersion("QEMU emulator version 4.1.0 (kata-static)\nCopyright (c) 2003-2019 Fabrice Bellard and the QEMU Project developers\n")
+	assert.NoError(err)
+	assert.Equal(qemuVersion{4, 1, 0}, v)
+	assert.Equal("4.1.0", v.String())
+
+	v, err = parseQemuVersion("QEMU emulator version 2.11 (Debian 1:2.11+dfsg-1ubuntu7)")
+	assert.NoError(err)
+	assert.Equal(qemuVersion{2, 11, 0}, v)
+
+	_, err = parseQemuVersion("qemu-lite")
+	assert.Error(err)
+}
+
+func TestQemuVersionAtLeast(t *testing.T) {
+	assert := assert.New(t)
+
+	v := qemuVersion{4, 1, 0}
+	assert.True(v.atLeast(qemuVersion{4, 1, 0}))
+	assert.True(v.atLeast(qemuVersion{4, 0, 1}))
+	assert.True(v.atLeast(qemuVersion{3, 9, 9}))
+	assert.False(v.atLeast(qemuVersion{4, 1, 1}))
+	assert.False(v.atLeast(qemuVersion{5, 0, 0}))
+}
+
+func TestQemuCompatSupports(t *testing.T) {
+	assert := assert.New(t)
+
+	// unknown version: only the legacy features
+	c := qemuCompat{}
+	assert.True(c.supports(qemuFeatureSHPC))
+	assert.True(c.supports(qemuFeatureQueryCpus))
+	assert.False(c.supports(qemuFeatureQueryCpusFast))
+
+	c.version = qemuVersion{2, 11, 0}
+	assert.True(c.supports(qemuFeatureQueryCpus))
+	assert.False(c.supports(qemuFeatureQueryCpusFast))
+
+	c.version = qemuVersion{4, 1, 0}
+	assert.True(c.supports(qemuFeatureSHPC))
+	assert.True(c.supports(qemuFeatureQueryCpus))
+	assert.True(c.supports(qemuFeatureQueryCpusFast))
+
+	c.version = qemuVersion{6, 1, 0}
+	assert.False(c.supports(qemuFeatureSHPC))
+	assert.False(c.supports(qemuFeatureQueryCpus))
+	assert.True(c.supports(qemuFeatureQueryCpusFast))
+}
+
+func TestNewQemuCompat(t *testing.T) {
+	assert := assert.New(t)
+
+	savedFunc := probeQemuVersionFunc
+	defer func() {
+		probeQemuVersionFunc = savedFunc
+	}()
+
+	probeQemuVersionFunc = func(path string) (qemuVersion, error) {
+		return qemuVersion{}, fmt.Errorf("no QEMU at %s", path)
+	}
+	assert.Equal(qemuCompat{}, newQemuCompat("/foo/qemu", virtLog))
+
+	probeQemuVersionFunc = func(path string) (qemuVersion, error) {
+		return qemuVersion{5, 2, 0}, nil
+	}
+	assert.Equal(qemuCompat{version: qemuVersion{5, 2, 0}}, newQemuCompat("/foo/qemu", virtLog))
+}
+
+func TestQemuCompatAdaptDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	devices := func() []govmmQemu.Device {
+		return []govmmQemu.Device{
+			govmmQemu.BridgeDevice{Type: govmmQemu.PCIBridge, ID: "pci-bridge-0", SHPC: true},
+			govmmQemu.BridgeDevice{Type: govmmQemu.PCIEBridge, ID: "pcie-bridge-0"},
+			govmmQemu.RngDevice{ID: "rng0"},
+		}
+	}
+
+	c := qemuCompat{version: qemuVersion{4, 1, 0}}
+	assert.Equal(devices(), c.adaptDevices(devices(), virtLog))
+
+	c.version = qemuVersion{6, 1, 0}
+	expected := devices()
+	expected[0] = govmmQemu.BridgeDevice{Type: govmmQemu.PCIBridge, ID: "pci-bridge-0", SHPC: false}
+	assert.Equal(expected, c.adaptDevices(devices(), virtLog))
+}
+
+func TestQemuCompatCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	// unknown capabilities: the legacy assumptions
+	c := qemuCompat{version: qemuVersion{4, 1, 0}}
+	assert.True(c.supportsMachine("q35"))
+	assert.True(c.supportsMigrationCapability(qmpCapMigrationIgnoreShared))
+	assert.True(c.supportsDeviceProperty(string(govmmQemu.VhostUserFS), qemuPropCacheSize))
+	assert.True(c.supports(qemuFeatureQueryCpus))
+
+	c.caps = &qemuBinaryCapabilities{
+		Commands: []string{"query-cpus-fast"},
+		Machines: []string{"pc"},
+	}
+	assert.True(c.supportsMachine("pc"))
+	assert.False(c.supportsMachine("q35"))
+	assert.False(c.supportsMigrationCapability(qmpCapMigrationIgnoreShared))
+	assert.False(c.supportsDeviceProperty(string(govmmQemu.VhostUserFS), qemuPropCacheSize))
+
+	// the probed commands rather than the version
+	assert.False(c.supports(qemuFeatureQueryCpus))
+	assert.True(c.supports(qemuFeatureQueryCpusFast))
+	assert.True(c.supports(qemuFeatureSHPC))
+}
+
+func TestQemuCompatAdaptDevicesCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	devices := func() []govmmQemu.Device {
+		return []govmmQemu.Device{
+			govmmQemu.VhostUserDevice{VhostUserType: govmmQemu.VhostUserFS, TypeDevID: "fs-kataShared", CacheSize: 1024},
+			govmmQemu.BlockDevice{ID: "drive-0", DisableModern: true},
+			govmmQemu.VSOCKDevice{ID: "vsock-0", DisableModern: true},
+			govmmQemu.RngDevice{ID: "rng0"},
+		}
+	}
+
+	c := qemuCompat{version: qemuVersion{4, 1, 0}}
+	assert.Equal(devices(), c.adaptDevices(devices(), virtLog))
+
+	c.caps = &qemuBinaryCapabilities{
+		DeviceProperties: map[string][]string{
+			string(govmmQemu.VirtioBlockPCI): {qemuPropDisableModern},
+			string(govmmQemu.VhostUserFS):    {qemuPropCacheSize},
+		},
+	}
+	assert.Equal(devices(), c.adaptDevices(devices(), virtLog))
+
+	c.caps.DeviceProperties = map[string][]string{
+		string(govmmQemu.VhostUserFS): {"tag"},
+	}
+	expected := []govmmQemu.Device{
+		govmmQemu.VhostUserDevice{VhostUserType: govmmQemu.VhostUserFS, TypeDevID: "fs-kataShared"},
+		govmmQemu.BlockDevice{ID: "drive-0"},
+		govmmQemu.VSOCKDevice{ID: "vsock-0"},
+		govmmQemu.RngDevice{ID: "rng0"},
+	}
+	assert.Equal(expected, c.adaptDevices(devices(), virtLog))
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"sort"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+)
+
+// deviceClass is the rank of a class of devices in the device plan of the
+// VM. The guest enumerates the devices in the order of the QEMU command
+// line, planning them by class keeps the names the guest gives them stable
+// whatever the order the runtime adds them in.
+type deviceClass int
+
+const (
+	// The bridges come first, so that they get the first PCI addresses.
+	deviceClassBridge deviceClass = iota
+	deviceClassConsole
+	deviceClassRNG
+	deviceClassSCSI
+	deviceClassImage
+	deviceClassVolume
+	deviceClassNetwork
+
+	// deviceClassOther gathers the other devices, e.g. the vsock and the
+	// block or VFIO devices of the containers, and the objects.
+	deviceClassOther
+)
+
+// classifyDevice returns the class of a device of the QEMU configuration.
+func classifyDevice(device govmmQemu.Device) deviceClass {
+	switch d := device.(type) {
+	case govmmQemu.BridgeDevice:
+		return deviceClassBridge
+	case govmmQemu.SerialDevice:
+		return deviceClassConsole
+	case govmmQemu.CharDevice:
+		if d.Driver == govmmQemu.Console {
+			return deviceClassConsole
+		}
+	case govmmQemu.RngDevice:
+		return deviceClassRNG
+	case govmmQemu.SCSIController:
+		return deviceClassSCSI
+	case govmmQemu.BlockDevice:
+		if d.ID == imageID {
+			return deviceClassImage
+		}
+	case serialBlockDevice:
+		return classifyDevice(d.BlockDevice)
+	case govmmQemu.Object:
+		if d.Driver == govmmQemu.NVDIMM {
+			return deviceClassImage
+		}
+	case govmmQemu.FSDevice:
+		return deviceClassVolume
+	case govmmQemu.NetDevice:
+		return deviceClassNetwork
+	case govmmQemu.VhostUserDevice:
+		switch d.VhostUserType {
+		case govmmQemu.VhostUserFS:
+			return deviceClassVolume
+		case govmmQemu.VhostUserNet:
+			return deviceClassNetwork
+		}
+	}
+
+	return deviceClassOther
+}
+
+// planDevices returns devices ordered by class: console, RNG, SCSI
+// controller, image, volumes and network interfaces, after the bridges and
+// before the other devices. The devices of a class keep their order.
+func planDevices(devices []govmmQemu.Device) []govmmQemu.Device {
+	planned := make([]govmmQemu.Device, len(devices))
+	copy(planned, devices)
+
+	sort.SliceStable(planned, func(i, j int) bool {
+		return classifyDevice(planned[i]) < classifyDevice(planned[j])
+	})
+
+	return planned
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	devices := []govmmQemu.Device{
+		govmmQemu.VSOCKDevice{ID: "vsock-3"},
+		govmmQemu.NetDevice{ID: "network-0"},
+		govmmQemu.FSDevice{ID: "extra-9p-kataShared"},
+		govmmQemu.RngDevice{ID: rngID},
+		govmmQemu.BlockDevice{ID: "drive-1"},
+		govmmQemu.BlockDevice{ID: imageID},
+		govmmQemu.VhostUserDevice{TypeDevID: "net-xdp-eth1", VhostUserType: govmmQemu.VhostUserNet},
+		govmmQemu.SCSIController{ID: scsiControllerID},
+		govmmQemu.CharDevice{ID: "charconsole0", Driver: govmmQemu.Console},
+		govmmQemu.SerialDevice{ID: "serial0"},
+		govmmQemu.VhostUserDevice{TypeDevID: "fs-kataShared", VhostUserType: govmmQemu.VhostUserFS},
+		govmmQemu.BridgeDevice{ID: "pci-bridge-0"},
+	}
+
+	planned := planDevices(devices)
+	assert.Len(planned, len(devices))
+
+	var classes []deviceClass
+	for _, d := range planned {
+		classes = append(classes, classifyDevice(d))
+	}
+	assert.Equal([]deviceClass{
+		deviceClassBridge,
+		deviceClassConsole, deviceClassConsole,
+		deviceClassRNG,
+		deviceClassSCSI,
+		deviceClassImage,
+		deviceClassVolume, deviceClassVolume,
+		deviceClassNetwork, deviceClassNetwork,
+		deviceClassOther, deviceClassOther,
+	}, classes)
+
+	// the devices of a class keep their order
+	assert.Equal(govmmQemu.CharDevice{ID: "charconsole0", Driver: govmmQemu.Console}, planned[1])
+	assert.Equal(govmmQemu.NetDevice{ID: "network-0"}, planned[8])
+	assert.Equal(govmmQemu.VSOCKDevice{ID: "vsock-3"}, planned[10])
+
+	// the devices passed are left untouched
+	assert.Equal(govmmQemu.VSOCKDevice{ID: "vsock-3"}, devices[0])
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"golang.org/x/sys/unix"
+)
+
+// qemuSandboxHelpRegexp matches the -sandbox option in the help of the QEMU
+// binaries built with seccomp support.
+var qemuSandboxHelpRegexp = regexp.MustCompile(`(?m)^-sandbox\b`)
+
+var (
+	qemuSeccompLock sync.Mutex
+	qemuSeccomp     = make(map[string]bool)
+)
+
+var probeQemuSeccompFunc = probeQemuSeccomp
+
+// probeQemuSeccomp returns true if the QEMU binary path supports the
+// -sandbox option. Results are cached like the QEMU versions.
+func probeQemuSeccomp(path string) (bool, error) {
+	qemuSeccompLock.Lock()
+	defer qemuSeccompLock.Unlock()
+
+	if supported, ok := qemuSeccomp[path]; ok {
+		return supported, nil
+	}
+
+	output, err := exec.Command(path, "-help").Output()
+	if err != nil {
+		return false, fmt.Errorf("Could not get %s help: %v", path, err)
+	}
+
+	supported := qemuSandboxHelpRegexp.Match(output)
+	qemuSeccomp[path] = supported
+
+	return supported, nil
+}
+
+// seccompSandbox returns the -sandbox option QEMU is launched with, empty
+// when the seccomp filter is disabled or not supported by qemuPath. When
+// the support cannot be probed, the option is kept and QEMU fails at launch
+// if it does not know it, rather than running unconfined.
+func (q *qemu) seccompSandbox(qemuPath string) string {
+	if q.config.SeccompSandbox == "" {
+		return ""
+	}
+
+	supported, err := probeQemuSeccompFunc(qemuPath)
+	if err != nil {
+		q.Logger().WithError(err).Warn("Could not probe the QEMU seccomp support")
+		return q.config.SeccompSandbox
+	}
+
+	if !supported {
+		q.Logger().WithField("qemu", qemuPath).Warn("QEMU built without seccomp support, running it without the seccomp filter")
+		return ""
+	}
+
+	return q.config.SeccompSandbox
+}
+
+// qemuSeccompSandbox is the value of the -sandbox option, enabling the
+// QEMU seccomp filter.
+type qemuSeccompSandbox string
+
+// Valid returns true if the seccomp filter is enabled.
+func (s qemuSeccompSandbox) Valid() bool {
+	return s != ""
+}
+
+// QemuParams returns the qemu parameters enabling the seccomp filter.
+func (s qemuSeccompSandbox) QemuParams(config *govmmQemu.Config) []string {
+	return []string{"-sandbox", string(s)}
+}
+
+// The capabilities kept by QEMU when DropCapabilities is set: accessing the
+// sandbox files and devices, locking the guest memory, scheduling the
+// realtime vCPUs and raising the locked memory limit for VFIO.
+const (
+	capChown         = 0
+	capDacOverride   = 1
+	capDacReadSearch = 2
+	capFowner        = 3
+	capNetAdmin      = 12
+	capIPCLock       = 14
+	capSysNice       = 23
+	capSysResource   = 24
+)
+
+var qemuCapabilities = map[uintptr]bool{
+	capChown:         true,
+	capDacOverride:   true,
+	capDacReadSearch: true,
+	capFowner:        true,
+	capNetAdmin:      true,
+	capIPCLock:       true,
+	capSysNice:       true,
+	capSysResource:   true,
+}
+
+var capLastCapPath = "/proc/sys/kernel/cap_last_cap"
+
+// droppedCapabilities returns the capabilities known to the kernel that
+// QEMU does not need.
+func droppedCapabilities() ([]uintptr, error) {
+	data, err := ioutil.ReadFile(capLastCapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid last capability %q: %v", data, err)
+	}
+
+	var caps []uintptr
+	for c := uintptr(0); c <= uintptr(last); c++ {
+		if !qemuCapabilities[c] {
+			caps = append(caps, c)
+		}
+	}
+
+	return caps, nil
+}
+
+// dropCapabilities removes caps from the bounding set of the calling thread
+// and clears its ambient set, so that the processes it executes do not get
+// them.
+func dropCapabilities(caps []uintptr) error {
+	// The ambient capabilities do not exist before Linux 4.3.
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("Could not clear the ambient capabilities: %v", err)
+	}
+
+	for _, c := range caps {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, c, 0, 0, 0); err != nil {
+			return fmt.Errorf("Could not drop capability %d: %v", c, err)
+		}
+	}
+
+	return nil
+}
+
+// launchQemu launches QEMU with its environment variables, in its private
+// mount namespace and without the capabilities it does not need when
+// DropCapabilities is set. The mount namespace and the capabilities are per
+// thread: they are set up on a locked thread which is never unlocked, so
+// that it exits with the goroutine instead of being reused by the runtime.
+func (q *qemu) launchQemu(logger govmmQemu.QMPLog) (string, error) {
+	var strErr string
+
+	launch := func() (err error) {
+		strErr, err = govmmQemu.LaunchQemu(q.qemuConfig, logger)
+		return err
+	}
+
+	if !q.config.DropCapabilities && len(q.config.VMMBindMounts) == 0 {
+		err := withVMMEnv(q.config.VMMEnv, launch)
+		return strErr, err
+	}
+
+	var caps []uintptr
+	if q.config.DropCapabilities {
+		var err error
+		if caps, err = droppedCapabilities(); err != nil {
+			return "", err
+		}
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		// The namespace is set up first, as it needs CAP_SYS_ADMIN.
+		if len(q.config.VMMBindMounts) > 0 {
+			if err := setupVMMMountNamespace(q.config.VMMBindMounts); err != nil {
+				done <- err
+				return
+			}
+		}
+
+		if q.config.DropCapabilities {
+			if err := dropCapabilities(caps); err != nil {
+				done <- err
+				return
+			}
+		}
+
+		done <- withVMMEnv(q.config.VMMEnv, launch)
+	}()
+
+	err := <-done
+
+	return strErr, err
+}
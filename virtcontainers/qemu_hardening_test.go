@@ -0,0 +1,91 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQemuSandboxHelpRegexp(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(qemuSandboxHelpRegexp.MatchString("-no-hpet        disable HPET\n" +
+		"-sandbox on[,obsolete=allow|deny][,elevateprivileges=allow|deny|children]\n"))
+	assert.False(qemuSandboxHelpRegexp.MatchString("-no-hpet        disable HPET\n" +
+		"-D logfile      output log to logfile (default stderr)\n"))
+}
+
+func TestQemuSeccompSandbox(t *testing.T) {
+	assert := assert.New(t)
+
+	savedProbe := probeQemuSeccompFunc
+	defer func() {
+		probeQemuSeccompFunc = savedProbe
+	}()
+
+	q := &qemu{}
+	assert.Empty(q.seccompSandbox("qemu"))
+
+	sandbox := "on,obsolete=deny,spawn=deny,resourcecontrol=deny"
+	q.config.SeccompSandbox = sandbox
+
+	probeQemuSeccompFunc = func(path string) (bool, error) {
+		return true, nil
+	}
+	assert.Equal(sandbox, q.seccompSandbox("qemu"))
+
+	// Fall back to no filter when QEMU does not support it.
+	probeQemuSeccompFunc = func(path string) (bool, error) {
+		return false, nil
+	}
+	assert.Empty(q.seccompSandbox("qemu"))
+
+	// Let QEMU decide when the support is unknown.
+	probeQemuSeccompFunc = func(path string) (bool, error) {
+		return false, errors.New("no help")
+	}
+	assert.Equal(sandbox, q.seccompSandbox("qemu"))
+
+	assert.False(qemuSeccompSandbox("").Valid())
+	assert.True(qemuSeccompSandbox(sandbox).Valid())
+	assert.Equal([]string{"-sandbox", sandbox}, qemuSeccompSandbox(sandbox).QemuParams(nil))
+}
+
+func TestDroppedCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "caps")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPath := capLastCapPath
+	defer func() {
+		capLastCapPath = savedPath
+	}()
+
+	capLastCapPath = filepath.Join(dir, "cap_last_cap")
+
+	_, err = droppedCapabilities()
+	assert.Error(err)
+
+	assert.NoError(ioutil.WriteFile(capLastCapPath, []byte("37\n"), 0644))
+	caps, err := droppedCapabilities()
+	assert.NoError(err)
+	assert.Len(caps, 38-len(qemuCapabilities))
+	assert.Equal(uintptr(4), caps[0])
+	assert.Equal(uintptr(37), caps[len(caps)-1])
+	assert.NotContains(caps, uintptr(capIPCLock))
+
+	assert.NoError(ioutil.WriteFile(capLastCapPath, []byte("foo"), 0644))
+	_, err = droppedCapabilities()
+	assert.Error(err)
+}
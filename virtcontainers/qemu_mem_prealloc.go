@@ -0,0 +1,157 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// memPreallocChunk is the size of the memory pre-allocated at once, so that
+// the pre-allocation stops promptly with the VM.
+const memPreallocChunk = 64 << 20
+
+var (
+	procFdPath  = "/proc/%d/fd"
+	fallocateFn = unix.Fallocate
+)
+
+// memBackendFile opens the memory backend file the QEMU process pid created
+// in dir. QEMU unlinks the file once created, it can only be reached
+// through the file descriptors of the process.
+func memBackendFile(pid int, dir string) (*os.File, error) {
+	fdDir := fmt.Sprintf(procFdPath, pid)
+
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Join(dir, "qemu_back_mem.")
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(target, prefix) {
+			return os.OpenFile(filepath.Join(fdDir, fd.Name()), os.O_RDWR, 0)
+		}
+	}
+
+	return nil, fmt.Errorf("Could not find the memory backend file of QEMU in %s", dir)
+}
+
+// memPreallocator allocates the pages of a memory backend file in the
+// background, as QEMU does before booting the VM with MemPrealloc.
+type memPreallocator struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newMemPreallocator(f *os.File, logger *logrus.Entry) (*memPreallocator, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &memPreallocator{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go p.run(ctx, f, fi.Size(), logger)
+
+	return p, nil
+}
+
+func (p *memPreallocator) run(ctx context.Context, f *os.File, size int64, logger *logrus.Entry) {
+	defer close(p.done)
+	defer f.Close()
+
+	start := time.Now()
+	logger = logger.WithField("size", size)
+
+	for offset := int64(0); offset < size; offset += memPreallocChunk {
+		select {
+		case <-ctx.Done():
+			logger.WithField("allocated", offset).Info("Memory pre-allocation stopped")
+			return
+		default:
+		}
+
+		length := size - offset
+		if length > memPreallocChunk {
+			length = memPreallocChunk
+		}
+
+		if err := fallocateFn(int(f.Fd()), 0, offset, length); err != nil {
+			logger.WithError(err).WithField("allocated", offset).Warn("Memory pre-allocation failed, the remaining memory is allocated on demand")
+			return
+		}
+	}
+
+	logger.WithField("duration", time.Since(start)).Info("Memory pre-allocated")
+}
+
+// pending returns true while the memory is being pre-allocated.
+func (p *memPreallocator) pending() bool {
+	if p == nil {
+		return false
+	}
+
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// stop stops the pre-allocation and waits for it to return.
+func (p *memPreallocator) stop() {
+	p.cancel()
+	<-p.done
+}
+
+// startMemPrealloc pre-allocates the guest memory in the background. The
+// pre-allocation is best effort: the memory QEMU has not allocated yet is
+// allocated on demand, as without MemPrealloc. It stops if the runtime
+// exits.
+func (q *qemu) startMemPrealloc() {
+	logger := q.Logger().WithField("memory-path", q.memPreallocDir)
+
+	pids := q.getPids()
+	if len(pids) == 0 || pids[0] == 0 {
+		logger.Warn("Could not pre-allocate the memory: QEMU pid unknown")
+		return
+	}
+
+	f, err := memBackendFile(pids[0], q.memPreallocDir)
+	if err != nil {
+		logger.WithError(err).Warn("Could not pre-allocate the memory")
+		return
+	}
+
+	p, err := newMemPreallocator(f, logger)
+	if err != nil {
+		f.Close()
+		logger.WithError(err).Warn("Could not pre-allocate the memory")
+		return
+	}
+
+	q.memPrealloc = p
+	q.state.MemPreallocPending = true
+}
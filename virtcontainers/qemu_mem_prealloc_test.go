@@ -0,0 +1,173 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemBackendFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "mem-prealloc")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedProcFdPath := procFdPath
+	defer func() {
+		procFdPath = savedProcFdPath
+	}()
+
+	procFdPath = filepath.Join(dir, "%d")
+	fdDir := filepath.Join(dir, "1234")
+	memDir := filepath.Join(dir, "shm")
+	assert.NoError(os.MkdirAll(fdDir, 0755))
+	assert.NoError(os.MkdirAll(memDir, 0755))
+
+	backend := filepath.Join(memDir, "qemu_back_mem.dimm1.abcdef")
+	assert.NoError(ioutil.WriteFile(backend, []byte("memory"), 0600))
+
+	assert.NoError(os.Symlink("/dev/null", filepath.Join(fdDir, "0")))
+	assert.NoError(os.Symlink(filepath.Join(memDir, "other"), filepath.Join(fdDir, "3")))
+	assert.NoError(os.Symlink(backend, filepath.Join(fdDir, "7")))
+
+	f, err := memBackendFile(1234, memDir)
+	assert.NoError(err)
+	data, err := ioutil.ReadAll(f)
+	assert.NoError(err)
+	assert.Equal("memory", string(data))
+	f.Close()
+
+	_, err = memBackendFile(1234, dir)
+	assert.Error(err)
+
+	_, err = memBackendFile(4321, memDir)
+	assert.Error(err)
+}
+
+func TestMemPreallocator(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "mem-prealloc")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+
+	size := int64(2*memPreallocChunk + 4096)
+	assert.NoError(f.Truncate(size))
+
+	savedFallocateFn := fallocateFn
+	defer func() {
+		fallocateFn = savedFallocateFn
+	}()
+
+	var lock sync.Mutex
+	var allocated [][2]int64
+	fallocateFn = func(fd int, mode uint32, off int64, len int64) error {
+		lock.Lock()
+		defer lock.Unlock()
+		allocated = append(allocated, [2]int64{off, len})
+		return nil
+	}
+
+	var nilPrealloc *memPreallocator
+	assert.False(nilPrealloc.pending())
+
+	p, err := newMemPreallocator(f, virtLog)
+	assert.NoError(err)
+	<-p.done
+	assert.False(p.pending())
+
+	assert.Equal([][2]int64{
+		{0, memPreallocChunk},
+		{memPreallocChunk, memPreallocChunk},
+		{2 * memPreallocChunk, 4096},
+	}, allocated)
+
+	// Stopping interrupts the pre-allocation.
+	f, err = os.Open(f.Name())
+	assert.NoError(err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	allocated = nil
+	fallocateFn = func(fd int, mode uint32, off int64, len int64) error {
+		lock.Lock()
+		allocated = append(allocated, [2]int64{off, len})
+		lock.Unlock()
+		if off == 0 {
+			close(started)
+			<-release
+		}
+		return nil
+	}
+
+	p, err = newMemPreallocator(f, virtLog)
+	assert.NoError(err)
+	<-started
+	assert.True(p.pending())
+
+	p.cancel()
+	close(release)
+	p.stop()
+	assert.False(p.pending())
+	assert.Len(allocated, 1)
+}
+
+func TestQemuCreateSandboxMemPreallocAsync(t *testing.T) {
+	assert := assert.New(t)
+
+	memDir, err := ioutil.TempDir("", "mem-prealloc")
+	assert.NoError(err)
+	defer os.RemoveAll(memDir)
+
+	qemuConfig := newQemuConfig()
+	qemuConfig.MemPrealloc = true
+	qemuConfig.MemPreallocAsync = true
+	qemuConfig.FileBackedMemRootDir = memDir
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		id:  "testSandbox",
+		config: &SandboxConfig{
+			HypervisorConfig: qemuConfig,
+		},
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	sandbox.store = vcStore
+
+	testQemuPath := filepath.Join(testDir, testHypervisor)
+	_, err = os.Create(testQemuPath)
+	assert.NoError(err)
+
+	parentDir := store.SandboxConfigurationRootPath(sandbox.id)
+	assert.NoError(os.MkdirAll(parentDir, store.DirMode))
+	defer os.RemoveAll(parentDir)
+
+	q := &qemu{}
+	err = q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, sandbox.store)
+	assert.NoError(err)
+	assert.False(q.qemuConfig.Knobs.MemPrealloc)
+	assert.Equal(memDir, q.memPreallocDir)
+
+	// Without file backed memory, QEMU pre-allocates the memory.
+	sandbox.config.HypervisorConfig.FileBackedMemRootDir = ""
+
+	q = &qemu{}
+	err = q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, sandbox.store)
+	assert.NoError(err)
+	assert.True(q.qemuConfig.Knobs.MemPrealloc)
+	assert.Empty(q.memPreallocDir)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// multifdCompression is the compression method of the multifd channels.
+	multifdCompression = "zlib"
+
+	// migrationTLSCredsID is the id of the x509 credentials object the
+	// migration stream is authenticated with.
+	migrationTLSCredsID = "migtls0"
+
+	// The TLS endpoints of the migration source and target.
+	migrationTLSClient = "client"
+	migrationTLSServer = "server"
+)
+
+// migrationStatus is the part of the query-migrate reply govmm does not
+// decode.
+type migrationStatus struct {
+	Status    string `json:"status"`
+	ErrorDesc string `json:"error-desc"`
+}
+
+// migrationCapsParams returns the QEMU migration capabilities and parameters
+// of config, which both ends of the migration have to set.
+func migrationCapsParams(config MigrationConfig) ([]map[string]interface{}, map[string]interface{}) {
+	var caps []map[string]interface{}
+	params := make(map[string]interface{})
+
+	if config.MultifdChannels > 0 {
+		caps = append(caps, map[string]interface{}{
+			"capability": "multifd",
+			"state":      true,
+		})
+		params["multifd-channels"] = config.MultifdChannels
+
+		if config.Compress {
+			params["multifd-compression"] = multifdCompression
+		}
+	} else if config.Compress {
+		caps = append(caps, map[string]interface{}{
+			"capability": "compress",
+			"state":      true,
+		})
+	}
+
+	return caps, params
+}
+
+// migrationTLSCreds returns the object-add arguments of the x509
+// credentials of the TLS endpoint of config.
+func migrationTLSCreds(config MigrationConfig, endpoint string) map[string]interface{} {
+	return map[string]interface{}{
+		"qom-type": "tls-creds-x509",
+		"id":       migrationTLSCredsID,
+		"props": map[string]interface{}{
+			"dir":         config.TLSDir,
+			"endpoint":    endpoint,
+			"verify-peer": true,
+		},
+	}
+}
+
+// setupMigration sets the migration capabilities and parameters of config,
+// and its TLS credentials for the endpoint.
+func (q *qemu) setupMigration(config MigrationConfig, endpoint string) error {
+	caps, params := migrationCapsParams(config)
+
+	if len(caps) > 0 {
+		if err := q.qmpMonitorCh.qmp.ExecSetMigrationCaps(q.qmpMonitorCh.ctx, caps); err != nil {
+			return err
+		}
+	}
+
+	if config.TLSDir == "" && len(params) == 0 {
+		return nil
+	}
+
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
+
+	if config.TLSDir != "" {
+		if err := ext.Execute(q.qmpMonitorCh.ctx, "object-add", migrationTLSCreds(config, endpoint), nil); err != nil {
+			return err
+		}
+		params["tls-creds"] = migrationTLSCredsID
+	}
+
+	return ext.Execute(q.qmpMonitorCh.ctx, "migrate-set-parameters", params, nil)
+}
+
+// cancelMigration cancels the migration in progress, the VM keeps running
+// on this host.
+func (q *qemu) cancelMigration() error {
+	ext, err := q.qmpExt()
+	if err != nil {
+		return err
+	}
+
+	return ext.Execute(q.qmpMonitorCh.ctx, "migrate_cancel", nil, nil)
+}
+
+// migrationError returns the error the migration failed with.
+func (q *qemu) migrationError(status string) error {
+	var s migrationStatus
+
+	if ext, err := q.qmpExt(); err == nil {
+		if err := ext.Execute(q.qmpMonitorCh.ctx, "query-migrate", nil, &s); err == nil && s.ErrorDesc != "" {
+			return fmt.Errorf("qemu migration %s: %s", status, s.ErrorDesc)
+		}
+	}
+
+	return fmt.Errorf("qemu migration %s", status)
+}
+
+// checkMigratable returns an error if resources have been hotplugged in the
+// VM: the VM of the target is started with the devices of the configuration
+// only, and the device models of both ends have to match.
+func (q *qemu) checkMigratable() error {
+	if q.state.HotpluggedMemory > 0 || len(q.state.HotpluggedVCPUs) > 0 {
+		return errors.Wrap(vcTypes.ErrNotSupported, "live migration of a VM with hotplugged memory or vCPUs")
+	}
+
+	for _, b := range q.state.Bridges {
+		// The devices of the CCW bridges are cold plugged too.
+		if b.Type != types.CCW && len(b.Devices) > 0 {
+			return errors.Wrap(vcTypes.ErrNotSupported, "live migration of a VM with hotplugged devices")
+		}
+	}
+
+	return nil
+}
+
+func (q *qemu) migrateSandbox(config MigrationConfig) error {
+	span, _ := q.trace("migrateSandbox")
+	defer span.Finish()
+
+	if err := q.checkMigratable(); err != nil {
+		return err
+	}
+
+	if err := q.qmpSetup(); err != nil {
+		return err
+	}
+
+	if err := q.setupMigration(config, migrationTLSClient); err != nil {
+		return err
+	}
+
+	q.Logger().WithField("uri", config.URI).Info("Migrating sandbox")
+
+	if err := q.qmpMonitorCh.qmp.ExecSetMigrateArguments(q.qmpMonitorCh.ctx, config.URI); err != nil {
+		return err
+	}
+
+	if err := q.waitMigrationTimeout(config.timeout()); err != nil {
+		if err := q.cancelMigration(); err != nil {
+			q.Logger().WithError(err).Warn("failed to cancel the migration")
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (q *qemu) receiveMigration(config MigrationConfig) error {
+	span, _ := q.trace("receiveMigration")
+	defer span.Finish()
+
+	if !q.config.IncomingMigration {
+		return errors.New("The VM has not been started to receive a live migration")
+	}
+
+	if err := q.qmpSetup(); err != nil {
+		return err
+	}
+
+	if err := q.setupMigration(config, migrationTLSServer); err != nil {
+		return err
+	}
+
+	q.Logger().WithField("uri", config.URI).Info("Receiving sandbox migration")
+
+	if err := qmpExecute("migrate-incoming", func() error {
+		return q.qmpMonitorCh.qmp.ExecuteMigrationIncoming(q.qmpMonitorCh.ctx, config.URI)
+	}); err != nil {
+		return err
+	}
+
+	return q.waitMigrationTimeout(config.timeout())
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationCapsParams(t *testing.T) {
+	assert := assert.New(t)
+
+	caps, params := migrationCapsParams(MigrationConfig{})
+	assert.Empty(caps)
+	assert.Empty(params)
+
+	caps, params = migrationCapsParams(MigrationConfig{Compress: true})
+	assert.Equal([]map[string]interface{}{{"capability": "compress", "state": true}}, caps)
+	assert.Empty(params)
+
+	caps, params = migrationCapsParams(MigrationConfig{MultifdChannels: 4, Compress: true})
+	assert.Equal([]map[string]interface{}{{"capability": "multifd", "state": true}}, caps)
+	assert.Equal(map[string]interface{}{
+		"multifd-channels":    uint32(4),
+		"multifd-compression": multifdCompression,
+	}, params)
+}
+
+func TestMigrationTLSCreds(t *testing.T) {
+	assert := assert.New(t)
+
+	creds := migrationTLSCreds(MigrationConfig{TLSDir: "/etc/pki/qemu"}, migrationTLSServer)
+	assert.Equal("tls-creds-x509", creds["qom-type"])
+	assert.Equal(migrationTLSCredsID, creds["id"])
+	assert.Equal(map[string]interface{}{
+		"dir":         "/etc/pki/qemu",
+		"endpoint":    migrationTLSServer,
+		"verify-peer": true,
+	}, creds["props"])
+}
+
+func TestQemuCheckMigratable(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{}
+	assert.NoError(q.checkMigratable())
+
+	q.state.HotpluggedMemory = 1024
+	err := q.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+
+	q.state.HotpluggedMemory = 0
+	q.state.Bridges = []types.Bridge{types.NewBridge(types.CCW, "", map[uint32]string{1: "foo"}, 0)}
+	assert.NoError(q.checkMigratable())
+
+	q.state.Bridges = []types.Bridge{types.NewBridge(types.PCI, "", map[uint32]string{1: "foo"}, 0)}
+	err = q.checkMigratable()
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err))
+}
+
+func TestReceiveMigrationNotIncoming(t *testing.T) {
+	q := &qemu{}
+	assert.Error(t, q.receiveMigration(MigrationConfig{URI: "unix:/run/migration"}))
+}
+
+func TestFirecrackerMigrationNotSupported(t *testing.T) {
+	assert := assert.New(t)
+
+	fc := &firecracker{}
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(fc.migrateSandbox(MigrationConfig{})))
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(fc.receiveMigration(MigrationConfig{})))
+}
@@ -77,6 +77,7 @@ func newQemuArch(config HypervisorConfig) qemuArch {
 		qemuArchBase{
 			machineType:           machineType,
 			memoryOffset:          config.MemOffset,
+			maxBridges:            config.MaxBridges,
 			qemuPaths:             qemuPaths,
 			supportedQemuMachines: supportedQemuMachines,
 			kernelParamsNonDebug:  kernelParamsNonDebug,
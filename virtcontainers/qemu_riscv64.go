@@ -0,0 +1,125 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+)
+
+type qemuRiscv64 struct {
+	// inherit from qemuArchBase, overwrite methods if needed
+	qemuArchBase
+}
+
+const defaultQemuPath = "/usr/bin/qemu-system-riscv64"
+
+const defaultQemuMachineType = QemuVirt
+
+const defaultQemuMachineOptions = "accel=kvm"
+
+const qmpMigrationWaitTimeout = 10 * time.Second
+
+var qemuPaths = map[string]string{
+	QemuVirt: defaultQemuPath,
+}
+
+var kernelParams = []Param{
+	{"console", "hvc0"},
+	{"console", "hvc1"},
+}
+
+// The riscv64 virt machine has no NVDIMM support, the image is attached
+// as a virtio-blk device.
+var kernelRootParams = commonVirtioblkKernelRootParams
+
+var supportedQemuMachines = []govmmQemu.Machine{
+	{
+		Type:    QemuVirt,
+		Options: defaultQemuMachineOptions,
+	},
+}
+
+// MaxQemuVCPUs returns the maximum number of vCPUs supported
+func MaxQemuVCPUs() uint32 {
+	// Max number of harts of the virt machine. See VIRT_CPUS_MAX in
+	// https://github.com/qemu/qemu/blob/v7.0.0/include/hw/riscv/virt.h
+	return uint32(512)
+}
+
+func newQemuArch(config HypervisorConfig) qemuArch {
+	machineType := config.HypervisorMachineType
+	if machineType == "" {
+		machineType = defaultQemuMachineType
+	}
+
+	q := &qemuRiscv64{
+		qemuArchBase{
+			machineType:           machineType,
+			memoryOffset:          config.MemOffset,
+			maxBridges:            config.MaxBridges,
+			qemuPaths:             qemuPaths,
+			supportedQemuMachines: supportedQemuMachines,
+			kernelParamsNonDebug:  kernelParamsNonDebug,
+			kernelParamsDebug:     kernelParamsDebug,
+			kernelParams:          kernelParams,
+		},
+	}
+
+	q.handleImagePath(config)
+
+	return q
+}
+
+func (q *qemuRiscv64) capabilities() types.Capabilities {
+	var caps types.Capabilities
+
+	if q.machineType == QemuVirt {
+		caps.SetBlockDeviceHotplugSupport()
+	}
+
+	caps.SetMultiQueueSupport()
+
+	return caps
+}
+
+func (q *qemuRiscv64) bridges(number uint32) {
+	q.Bridges = genericBridges(number, q.machineType)
+}
+
+// appendBridges appends to devices the given bridges
+func (q *qemuRiscv64) appendBridges(devices []govmmQemu.Device) []govmmQemu.Device {
+	return genericAppendBridges(devices, q.Bridges, q.machineType)
+}
+
+// memoryTopology returns the memory topology without any hotplug slot,
+// the virt machine does not support memory hotplug.
+func (q *qemuRiscv64) memoryTopology(memoryMb, hostMemoryMb uint64, slots uint8) govmmQemu.Memory {
+	return govmmQemu.Memory{
+		Size: fmt.Sprintf("%dM", memoryMb),
+	}
+}
+
+// supportGuestMemoryHotplug returns false, the virt machine does not
+// support memory hotplug.
+func (q *qemuRiscv64) supportGuestMemoryHotplug() bool {
+	return false
+}
+
+// supportGuestCPUHotplug returns false, harts cannot be added to a running
+// virt machine.
+func (q *qemuRiscv64) supportGuestCPUHotplug() bool {
+	return false
+}
+
+func (q *qemuRiscv64) setIgnoreSharedMemoryMigrationCaps(_ context.Context, _ *govmmQemu.QMP) error {
+	// x-ignore-shared not support in riscv64 for now
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"testing"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQemu(machineType string) qemuArch {
+	config := HypervisorConfig{
+		HypervisorMachineType: machineType,
+	}
+	return newQemuArch(config)
+}
+
+func TestQemuRiscv64Capabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	riscv64 := newTestQemu(QemuVirt)
+	caps := riscv64.capabilities()
+	assert.True(caps.IsBlockDeviceHotplugSupported())
+	assert.True(caps.IsMultiQueueSupported())
+
+	assert.False(riscv64.supportGuestMemoryHotplug())
+	assert.False(riscv64.supportGuestCPUHotplug())
+}
+
+func TestQemuRiscv64Machine(t *testing.T) {
+	assert := assert.New(t)
+
+	riscv64 := newTestQemu("")
+	m, err := riscv64.machine()
+	assert.NoError(err)
+	assert.Equal(QemuVirt, m.Type)
+	assert.Equal(defaultQemuMachineOptions, m.Options)
+
+	path, err := riscv64.qemuPath()
+	assert.NoError(err)
+	assert.Equal(defaultQemuPath, path)
+
+	riscv64 = newTestQemu(QemuQ35)
+	_, err = riscv64.machine()
+	assert.Error(err)
+}
+
+func TestQemuRiscv64MemoryTopology(t *testing.T) {
+	assert := assert.New(t)
+	riscv64 := newTestQemu(QemuVirt)
+
+	expectedMemory := govmmQemu.Memory{
+		Size: fmt.Sprintf("%dM", 1024),
+	}
+
+	m := riscv64.memoryTopology(1024, 4096, 3)
+	assert.Equal(expectedMemory, m)
+}
+
+func TestQemuRiscv64Bridges(t *testing.T) {
+	assert := assert.New(t)
+	riscv64 := newTestQemu(QemuVirt)
+
+	riscv64.bridges(2)
+	bridges := riscv64.getBridges()
+	assert.Len(bridges, 2)
+	for _, b := range bridges {
+		assert.Equal(types.PCIE, b.Type)
+	}
+
+	devices := riscv64.appendBridges(nil)
+	assert.Len(devices, 2)
+	assert.Equal(govmmQemu.PCIEBridge, devices[0].(govmmQemu.BridgeDevice).Type)
+}
+
+func TestQemuRiscv64KernelParameters(t *testing.T) {
+	assert := assert.New(t)
+
+	config := HypervisorConfig{
+		HypervisorMachineType: QemuVirt,
+		ImagePath:             "/foo/image",
+	}
+	riscv64 := newQemuArch(config)
+
+	params := riscv64.kernelParameters(false)
+	for _, p := range commonVirtioblkKernelRootParams {
+		assert.Contains(params, p)
+	}
+	assert.Contains(params, Param{"console", "hvc0"})
+}
@@ -6,6 +6,7 @@
 package virtcontainers
 
 import (
+	"context"
 	"fmt"
 	govmmQemu "github.com/intel/govmm/qemu"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
@@ -66,6 +67,7 @@ func newQemuArch(config HypervisorConfig) qemuArch {
 		qemuArchBase{
 			machineType:           machineType,
 			memoryOffset:          config.MemOffset,
+			maxBridges:            config.MaxBridges,
 			qemuPaths:             qemuPaths,
 			supportedQemuMachines: supportedQemuMachines,
 			kernelParamsNonDebug:  kernelParamsNonDebug,
@@ -152,7 +154,7 @@ func (q *qemuS390x) appendBlockDevice(devices []govmmQemu.Device, drive config.B
 	if err != nil {
 		return devices, fmt.Errorf("Failed to append blk-dev %v", err)
 	}
-	devices = append(devices, d)
+	devices = append(devices, blockDeviceWithSerial(d, drive.Serial))
 	return devices, nil
 }
 
@@ -270,3 +272,72 @@ func (q *qemuS390x) appendVSock(devices []govmmQemu.Device, vsock kataVSOCK) ([]
 	return devices, nil
 
 }
+
+// hotplugAddNetDevice is the CCW version of the base implementation.
+func (q *qemuS390x) hotplugAddNetDevice(ctx context.Context, qmp *govmmQemu.QMP, endpoint Endpoint, tap TapInterface, devID string, queues int) (err error) {
+	addr, bridge, err := q.addDeviceToBridge(tap.ID, types.CCW)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			q.removeDeviceFromBridge(tap.ID)
+		}
+	}()
+
+	devNoHotplug, err := bridge.AddressFormatCCW(addr)
+	if err != nil {
+		return err
+	}
+
+	return qmpExecute("device_add", func() error {
+		return qmp.ExecuteNetCCWDeviceAdd(ctx, tap.Name, devID, endpoint.HardwareAddr(), devNoHotplug, queues)
+	})
+}
+
+// hotplugAddBlockDevice handles the virtio-blk-ccw driver, falling back to
+// the base implementation for the other drivers.
+func (q *qemuS390x) hotplugAddBlockDevice(ctx context.Context, qmp *govmmQemu.QMP, drive *config.BlockDrive, blockDeviceDriver, devID string) (err error) {
+	if blockDeviceDriver != config.VirtioBlockCCW {
+		return q.qemuArchBase.hotplugAddBlockDevice(ctx, qmp, drive, blockDeviceDriver, devID)
+	}
+
+	driver := "virtio-blk-ccw"
+
+	addr, bridge, err := q.addDeviceToBridge(drive.ID, types.CCW)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			q.removeDeviceFromBridge(drive.ID)
+		}
+	}()
+
+	devNoHotplug, err := bridge.AddressFormatCCW(addr)
+	if err != nil {
+		return err
+	}
+
+	drive.DevNo, err = bridge.AddressFormatCCWForVirtServer(addr)
+	if err != nil {
+		return err
+	}
+
+	if drive.Serial == "" {
+		return qmpExecute("device_add", func() error {
+			return qmp.ExecuteDeviceAdd(ctx, drive.ID, devID, driver, devNoHotplug, "", true, false)
+		})
+	}
+
+	return q.deviceAdd(ctx, map[string]interface{}{
+		"id":       devID,
+		"driver":   driver,
+		"drive":    drive.ID,
+		"devno":    devNoHotplug,
+		"share-rw": "on",
+		"serial":   drive.Serial,
+	})
+}
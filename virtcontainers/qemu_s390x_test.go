@@ -6,6 +6,7 @@
 package virtcontainers
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -65,3 +66,32 @@ func TestQemuS390xAppendVhostUserDevice(t *testing.T) {
 	_, err := qemu.appendVhostUserDevice(nil, vhostUserDevice)
 	assert.Error(err)
 }
+
+func TestQemuS390xHotplugAddNetDevice(t *testing.T) {
+	assert := assert.New(t)
+	s390x := newTestQemu(QemuCCWVirtio)
+
+	endpoint := &TapEndpoint{}
+	tap := TapInterface{ID: "tap-id", Name: "tap0"}
+
+	// no CCW bridge to plug the device into
+	err := s390x.hotplugAddNetDevice(context.Background(), nil, endpoint, tap, "virtio-"+tap.ID, 1)
+	assert.Error(err)
+}
+
+func TestQemuS390xHotplugAddBlockDevice(t *testing.T) {
+	assert := assert.New(t)
+	s390x := newTestQemu(QemuCCWVirtio)
+	ctx := context.Background()
+
+	drive := &config.BlockDrive{ID: "drive-id"}
+
+	// no CCW bridge to plug the device into
+	err := s390x.hotplugAddBlockDevice(ctx, nil, drive, config.VirtioBlockCCW, "virtio-"+drive.ID)
+	assert.Error(err)
+	assert.Empty(drive.DevNo)
+
+	// other drivers are handled by the base implementation
+	err = s390x.hotplugAddBlockDevice(ctx, nil, drive, "foo", "virtio-"+drive.ID)
+	assert.Error(err)
+}
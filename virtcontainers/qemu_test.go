@@ -6,10 +6,13 @@
 package virtcontainers
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -17,6 +20,8 @@ import (
 
 	govmmQemu "github.com/intel/govmm/qemu"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/qmp"
+	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
 	"github.com/pkg/errors"
@@ -495,22 +500,55 @@ func TestQemuVirtiofsdArgs(t *testing.T) {
 		},
 	}
 
-	savedKataHostSharedDir := kataHostSharedDir
-	kataHostSharedDir = "test-share-dir"
-	defer func() {
-		kataHostSharedDir = savedKataHostSharedDir
-	}()
-
 	result := "-o vhost_user_socket=bar1 -o source=test-share-dir/foo -o cache=none -d"
-	args := q.virtiofsdArgs("bar1")
+	args := q.virtiofsdArgs("bar1", "test-share-dir/foo")
 	assert.Equal(strings.Join(args, " "), result)
 
 	q.config.Debug = false
-	result = "-o vhost_user_socket=bar2 -o source=test-share-dir/foo -o cache=none -f"
-	args = q.virtiofsdArgs("bar2")
+	result = "-o vhost_user_socket=bar2 -o source=/srv/data -o cache=none -f"
+	args = q.virtiofsdArgs("bar2", "/srv/data")
 	assert.Equal(strings.Join(args, " "), result)
 }
 
+func TestQemuVirtioFSSocketPath(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{id: "foo"}
+
+	sharedSock, err := q.vhostFSSocketPath(q.id)
+	assert.NoError(err)
+
+	path, err := q.virtioFSSocketPath(mountGuest9pTag)
+	assert.NoError(err)
+	assert.Equal(sharedSock, path)
+
+	path, err = q.virtioFSSocketPath("data")
+	assert.NoError(err)
+	assert.Equal(filepath.Join(filepath.Dir(sharedSock), "vhost-fs-data.sock"), path)
+}
+
+func TestQemuStopVolumeVirtiofsd(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := exec.Command("sleep", "60")
+	assert.NoError(cmd.Start())
+
+	q := &qemu{
+		state: QemuState{
+			VolumeVirtiofsdPids: map[string]int{
+				"data": cmd.Process.Pid,
+			},
+		},
+	}
+
+	q.stopVolumeVirtiofsd()
+	assert.Nil(q.state.VolumeVirtiofsdPids)
+
+	err := cmd.Wait()
+	assert.Error(err)
+	assert.False(cmd.ProcessState.Success())
+}
+
 func TestQemuWaitVirtiofsd(t *testing.T) {
 	assert := assert.New(t)
 
@@ -566,4 +604,240 @@ func TestQemuGetpids(t *testing.T) {
 	assert.True(len(pids) == 2)
 	assert.True(pids[0] == 100)
 	assert.True(pids[1] == 200)
+
+	q.config.VirtioFSVolumes = []types.Volume{
+		{MountTag: "data", HostPath: "/srv/data"},
+		{MountTag: "models", HostPath: "/srv/models"},
+	}
+	q.state.VolumeVirtiofsdPids = map[string]int{
+		"models": 400,
+		"data":   300,
+	}
+	pids = q.getPids()
+	assert.Equal([]int{100, 200, 300, 400}, pids)
+}
+
+func TestQemuCheckQMPDisconnected(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		config: newQemuConfig(),
+	}
+	assert.False(q.qmpDisconnected())
+
+	disconnectCh := make(chan struct{})
+	q.qmpMonitorCh.ctx = context.Background()
+	q.qmpMonitorCh.qmp = &govmmQemu.QMP{}
+	q.qmpMonitorCh.disconn = disconnectCh
+	assert.False(q.qmpDisconnected())
+
+	close(disconnectCh)
+	assert.True(q.qmpDisconnected())
+
+	// reconnecting fails without a QMP socket
+	err := q.check(nil)
+	assert.Error(err)
+	assert.Nil(q.qmpMonitorCh.qmp)
+	assert.Nil(q.qmpMonitorCh.disconn)
+}
+
+func TestQemuNvdimmID(t *testing.T) {
+	assert := assert.New(t)
+
+	// the guest image is the first nvdimm device
+	q := &qemu{arch: &qemuArchBase{}, state: QemuState{NvdimmCount: 1}}
+
+	assert.Equal(1, q.allocNvdimmID())
+	assert.Equal(2, q.allocNvdimmID())
+	assert.Equal(3, q.allocNvdimmID())
+	assert.Equal(4, q.state.NvdimmCount)
+
+	// released ids are reused, lowest first
+	q.releaseNvdimmID(2)
+	q.releaseNvdimmID(1)
+	assert.Equal([]int{1, 2}, q.state.NvdimmFreeIDs)
+	assert.Equal(1, q.allocNvdimmID())
+
+	// unknown ids are ignored
+	q.releaseNvdimmID(2)
+	q.releaseNvdimmID(4)
+	q.releaseNvdimmID(-1)
+	assert.Equal([]int{2}, q.state.NvdimmFreeIDs)
+	assert.Equal(4, q.state.NvdimmCount)
+
+	// releasing the last id shrinks the count
+	q.releaseNvdimmID(3)
+	assert.Empty(q.state.NvdimmFreeIDs)
+	assert.Equal(2, q.state.NvdimmCount)
+	assert.Equal(2, q.allocNvdimmID())
+	assert.Equal(3, q.state.NvdimmCount)
+
+	// the ids survive a save/load cycle
+	q.releaseNvdimmID(1)
+	loaded := &qemu{}
+	loaded.load(q.save())
+	assert.Equal(3, loaded.state.NvdimmCount)
+	assert.Equal([]int{1}, loaded.state.NvdimmFreeIDs)
+	assert.Equal(1, loaded.allocNvdimmID())
+}
+
+func TestQemuHotplugAddVirtioPmemDeviceFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "pmem")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+	assert.NoError(f.Truncate(2 << 20))
+	f.Close()
+
+	q := &qemu{arch: newQemuArchBase()}
+	q.arch.bridges(1)
+
+	// the bridge slot is released when the device cannot be added
+	drive := &config.BlockDrive{ID: "drive-0", File: f.Name()}
+	assert.Error(q.hotplugAddVirtioPmemDevice(drive, "virtio-drive-0"))
+	assert.Empty(q.arch.getBridges()[0].Devices)
+	assert.Empty(drive.PCIAddr)
+}
+
+func TestQemuHotplugRemoveVirtioPmemDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		config: HypervisorConfig{BlockDeviceDriver: config.VirtioPmem},
+	}
+
+	_, err := q.hotplugRemoveDevice(&config.BlockDrive{ID: "drive-0"}, blockDev)
+	assert.Error(err)
+	assert.Equal(vcTypes.ErrNotSupported, errors.Cause(err.(*vcTypes.HotplugError).Err))
+	assert.Equal(vcTypes.ErrNotSupported, err.(*vcTypes.HotplugError).Kind)
+
+	// The sandbox falls back to virtio-blk when the agent cannot find
+	// the virtio-pmem devices.
+	q.setBlockDeviceDriver(config.VirtioBlock)
+	assert.Equal(config.VirtioBlock, q.hypervisorConfig().BlockDeviceDriver)
+}
+
+func TestQemuHotplugRemoveSCSIDevice(t *testing.T) {
+	assert := assert.New(t)
+	q := &qemu{}
+
+	// the SCSI address must match the drive index
+	err := q.hotplugRemoveSCSIDevice(&config.BlockDrive{ID: "foo", Index: 257, SCSIAddr: "0:1"}, "virtio-foo")
+	assert.Error(err)
+
+	err = q.hotplugRemoveSCSIDevice(&config.BlockDrive{ID: "foo", Index: -1}, "virtio-foo")
+	assert.Error(err)
+}
+
+func TestQemuBlockdevAddArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{}
+	drive := &config.BlockDrive{File: "/images/image.qcow2", ID: "drive-0", ReadOnly: true}
+
+	assert.Equal(map[string]interface{}{
+		"driver":    config.BlockFormatQcow2,
+		"node-name": "drive-0",
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": "/images/image.qcow2",
+		},
+		"read-only": true,
+	}, q.blockdevAddArgs(drive, config.BlockFormatQcow2))
+
+	drive.ReadOnly = false
+	q.config.BlockDeviceCacheSet = true
+	q.config.BlockDeviceCacheDirect = true
+	args := q.blockdevAddArgs(drive, config.BlockFormatRaw)
+	assert.Equal(config.BlockFormatRaw, args["driver"])
+	assert.NotContains(args, "read-only")
+	assert.Equal(map[string]interface{}{"direct": true, "no-flush": false}, args["cache"])
+}
+
+func TestQemuHotplugRemoveNvdimmDevice(t *testing.T) {
+	q := &qemu{}
+
+	err := q.hotplugRemoveNvdimmDevice(&config.BlockDrive{ID: "foo"})
+	assert.Error(t, err)
+}
+
+func TestQemuSaveLoadNetworkDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	dev := NetworkDevice{
+		ID:         "uniqueTestID",
+		DevID:      "virtio-uniqueTestID",
+		Netdev:     "br0_kata",
+		MACAddress: "02:00:ca:fe:00:04",
+	}
+
+	q := &qemu{
+		arch: &qemuArchBase{},
+		state: QemuState{
+			HotpluggedNetDevices: []NetworkDevice{dev},
+		},
+	}
+
+	loaded := &qemu{}
+	loaded.load(q.save())
+	assert.Equal([]NetworkDevice{dev}, loaded.state.HotpluggedNetDevices)
+
+	assert.Equal(&dev, loaded.hotpluggedNetDevice("uniqueTestID"))
+	assert.Nil(loaded.hotpluggedNetDevice("otherTestID"))
+
+	loaded.removeHotpluggedNetDevice("uniqueTestID")
+	assert.Nil(loaded.hotpluggedNetDevice("uniqueTestID"))
+	assert.Empty(loaded.state.HotpluggedNetDevices)
+}
+
+func TestQemuQMPDeviceDel(t *testing.T) {
+	assert := assert.New(t)
+
+	inR, inW, err := os.Pipe()
+	assert.NoError(err)
+	outR, outW, err := os.Pipe()
+	assert.NoError(err)
+	defer inW.Close()
+	defer outR.Close()
+
+	// QEMU deletes the device dev0 only.
+	go func() {
+		defer inR.Close()
+		defer outW.Close()
+
+		outW.Write([]byte(`{"QMP": {"version": {"qemu": {"major": 4}}, "capabilities": []}}` + "\n"))
+
+		scanner := bufio.NewScanner(inR)
+		for scanner.Scan() {
+			var cmd struct {
+				Execute   string
+				Arguments map[string]interface{}
+			}
+			json.Unmarshal(scanner.Bytes(), &cmd)
+
+			if cmd.Execute == "device_del" && cmd.Arguments["id"] != "dev0" {
+				outW.Write([]byte(`{"error": {"class": "DeviceNotFound", "desc": "Device not found"}}` + "\n"))
+				continue
+			}
+			outW.Write([]byte(`{"return": {}}` + "\n"))
+			if cmd.Execute == "device_del" {
+				outW.Write([]byte(`{"event": "DEVICE_DELETED", "data": {"device": "dev0"}}` + "\n"))
+			}
+		}
+	}()
+
+	q := &qemu{
+		qmpMonitorCh: qmpChannel{
+			ctx: context.Background(),
+		},
+	}
+
+	ext, err := qmp.New(q.qmpMonitorCh.ctx, inW, outR)
+	assert.NoError(err)
+
+	assert.NoError(q.qmpDeviceDel(ext, "dev0"))
+
+	err = q.qmpDeviceDel(ext, "dev1")
+	assert.True(qmp.IsErrorClass(err, qmp.ErrorClassDeviceNotFound))
 }
@@ -9,10 +9,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -42,8 +45,8 @@ import (
 )
 
 const (
-	// vmStartTimeout represents the time in seconds a sandbox can wait before
-	// to consider the VM starting operation failed.
+	// vmStartTimeout represents the default time in seconds a sandbox can
+	// wait before to consider the VM starting operation failed.
 	vmStartTimeout = 10
 )
 
@@ -56,6 +59,10 @@ type SandboxStatus struct {
 	Agent            AgentType
 	ContainersStatus []ContainerStatus
 
+	// AgentHealth is the state of the connection of the runtime to the
+	// agent. It is only known to the runtime process managing the sandbox.
+	AgentHealth AgentHealth
+
 	// Annotations allow clients to store arbitrary values,
 	// for example to add additional status values required
 	// to support particular specifications.
@@ -112,8 +119,62 @@ type SandboxConfig struct {
 
 	DisableGuestSeccomp bool
 
+	// Labels identify the workload of the sandbox on its host resources.
+	Labels SandboxLabels
+
+	// EmptyDirBlockSize is the size in megabytes of the sparse block
+	// devices backing the disk-backed emptyDir volumes in the guest. They
+	// are local directories of the guest when 0.
+	EmptyDirBlockSize uint32
+
+	// RootfsScratchSize is the size in megabytes of the sparse scratch
+	// disks holding the writable layer of the rootfs layered on read-only
+	// images. defaultRootfsScratchSizeMB is used when 0.
+	RootfsScratchSize uint32
+
+	// ScratchIntegrity is the algorithm of the dm-integrity target the
+	// agent sets up on the scratch disks, one of the block device
+	// integrity algorithms of the device config. They are not integrity
+	// protected when empty.
+	ScratchIntegrity string
+
+	// LUKSKeyProvider is the executable returning the keys of the
+	// encrypted rootfs given by their ID. It reads the ID from its
+	// standard input and writes the key to its standard output.
+	LUKSKeyProvider string
+
+	// LUKSKeyDir is the host directory the LUKS keys given by their name
+	// are read from, no key is read from the other host files.
+	LUKSKeyDir string
+
+	// TmpQuota is the size in megabytes of the tmpfs holding the
+	// temporary files of the sandbox on the host. They are not limited
+	// when 0.
+	TmpQuota uint32
+
+	// AuditLog records the QMP commands, the hotplug operations and the
+	// agent RPCs of the sandbox, with their redacted arguments and their
+	// result, in JSON lines in AuditLogDir.
+	AuditLog bool
+
+	// AuditLogDir is the persistent directory of the audit log, which is
+	// kept once the sandbox is deleted.
+	AuditLogDir string
+
+	// AuditLogMaxSizeMiB is the size above which the audit log is
+	// rotated.
+	AuditLogMaxSizeMiB uint32
+
 	// Experimental features enabled
 	Experimental []exp.Feature
+
+	// SizingConfig selects and tunes the policy deciding the vCPUs and
+	// memory of the sandbox.
+	SizingConfig SizingConfig
+
+	// AutoscaleConfig tunes the vertical autoscale controller, only used
+	// when the autoscale experimental feature is enabled.
+	AutoscaleConfig AutoscaleConfig
 }
 
 func (s *Sandbox) trace(name string) (opentracing.Span, context.Context) {
@@ -172,8 +233,21 @@ type Sandbox struct {
 	// store is used to replace VCStore step by step
 	newStore persistapi.PersistDriver
 
-	network Network
-	monitor *monitor
+	network        Network
+	monitor        *monitor
+	autoscaler     *autoscaler
+	networkWatcher *networkWatcher
+	sizing         sizingPolicy
+	sizingOnce     sync.Once
+
+	// sizedVCPUs are the vCPUs the sizing policy last sized the sandbox
+	// with, without the ones of the autoscale controller.
+	sizedVCPUs uint32
+
+	// opLock serializes the background tasks changing the sandbox, such
+	// as the network watcher, with the operations of the runtime. Unless
+	// the runtime sets it, it is only shared by the background tasks.
+	opLock sync.Locker
 
 	config *SandboxConfig
 
@@ -194,6 +268,13 @@ type Sandbox struct {
 
 	wg *sync.WaitGroup
 
+	hotplugJobs         hotplugJobRegistry
+	hotplugReservations hotplugReservationRegistry
+
+	// audit is the audit log of the QMP commands, of the hotplug
+	// operations and of the agent RPCs, nil when disabled.
+	audit *auditLog
+
 	shmSize           uint64
 	sharePidNs        bool
 	stateful          bool
@@ -208,12 +289,61 @@ func (s *Sandbox) ID() string {
 	return s.id
 }
 
+// SetOperationLock sets the lock the runtime holds during its operations on
+// the sandbox, which the background tasks of the sandbox take before they
+// change it.
+func (s *Sandbox) SetOperationLock(lock sync.Locker) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.opLock = lock
+}
+
+func (s *Sandbox) operationLock() sync.Locker {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.opLock == nil {
+		s.opLock = &sync.Mutex{}
+	}
+
+	return s.opLock
+}
+
+// lockUnlessClosed takes lock unless stopCh is closed first, as the runtime
+// may hold the lock while it stops the background task closing stopCh. It
+// returns false, leaving the lock free, if stopCh is closed.
+func lockUnlessClosed(lock sync.Locker, stopCh <-chan struct{}) bool {
+	locked := make(chan struct{})
+	go func() {
+		lock.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		select {
+		case <-stopCh:
+			lock.Unlock()
+			return false
+		default:
+			return true
+		}
+	case <-stopCh:
+		go func() {
+			<-locked
+			lock.Unlock()
+		}()
+		return false
+	}
+}
+
 // Logger returns a logrus logger appropriate for logging Sandbox messages
 func (s *Sandbox) Logger() *logrus.Entry {
 	return virtLog.WithFields(logrus.Fields{
 		"subsystem": "sandbox",
 		"sandbox":   s.id,
-	})
+	}).WithFields(s.labels().fields())
 }
 
 // Annotations returns any annotation that a user could have stored through the sandbox.
@@ -281,6 +411,8 @@ func (s *Sandbox) Release() error {
 	if s.monitor != nil {
 		s.monitor.stop()
 	}
+	s.stopAutoscaler()
+	s.stopNetworkWatcher()
 	s.hypervisor.disconnect()
 	return s.agent.disconnect()
 }
@@ -319,6 +451,7 @@ func (s *Sandbox) Status() SandboxStatus {
 		Hypervisor:       s.config.HypervisorType,
 		HypervisorConfig: s.config.HypervisorConfig,
 		Agent:            s.config.AgentType,
+		AgentHealth:      s.agent.health(),
 		ContainersStatus: contStatusList,
 		Annotations:      s.config.Annotations,
 	}
@@ -441,9 +574,19 @@ func (s *Sandbox) getAndStoreGuestDetails() error {
 		s.state.GuestMemoryBlockSizeMB = uint32(guestDetailRes.MemBlockSizeBytes >> 20)
 		if guestDetailRes.AgentDetails != nil {
 			s.seccompSupported = guestDetailRes.AgentDetails.SupportsSeccomp
+			s.state.GuestBlkSerial = agentSupportsBlkSerial(guestDetailRes.AgentDetails)
+			s.state.GuestLUKS = agentSupportsLUKS(guestDetailRes.AgentDetails)
+			s.state.GuestIntegrity = agentSupportsIntegrity(guestDetailRes.AgentDetails)
+			s.state.GuestVirtioPmem = agentSupportsVirtioPmem(guestDetailRes.AgentDetails)
+			s.state.GuestWatchableBind = agentSupportsWatchableBind(guestDetailRes.AgentDetails)
 		}
 		s.state.GuestMemoryHotplugProbe = guestDetailRes.SupportMemHotplugProbe
 
+		if s.config.HypervisorConfig.BlockDeviceDriver == config.VirtioPmem && !s.state.GuestVirtioPmem {
+			s.Logger().Warn("The agent cannot find virtio-pmem devices, falling back to virtio-blk")
+			s.setBlockDeviceDriver(config.VirtioBlock)
+		}
+
 		if !s.supportNewStore() {
 			if err = s.store.Store(store.State, s.state); err != nil {
 				return err
@@ -454,6 +597,14 @@ func (s *Sandbox) getAndStoreGuestDetails() error {
 	return nil
 }
 
+// setBlockDeviceDriver changes the driver the block devices are hotplugged
+// with, the agent being unable to find the ones of the configured driver.
+func (s *Sandbox) setBlockDeviceDriver(driver string) {
+	s.config.HypervisorConfig.BlockDeviceDriver = driver
+	s.devManager = deviceManager.NewDeviceManager(driver, s.devManager.GetAllDevices())
+	s.hypervisor.setBlockDeviceDriver(driver)
+}
+
 // createSandbox creates a sandbox from a sandbox description, the containers list, the hypervisor
 // and the agent passed through the Config structure.
 // It will create and store the sandbox structure, and then ask the hypervisor
@@ -506,12 +657,22 @@ func createSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Fac
 	}
 
 	// Below code path is called only during create, because of earlier check.
-	if err := s.agent.createSandbox(s); err != nil {
+	if err = s.tmp().setup(factory != nil); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			s.tmp().cleanup(s.Logger())
+		}
+	}()
+
+	if err = s.agent.createSandbox(s); err != nil {
 		return nil, err
 	}
 
 	// Set sandbox state
-	if err := s.setSandboxState(types.StateReady); err != nil {
+	if err = s.setSandboxState(types.StateReady); err != nil {
 		return nil, err
 	}
 
@@ -533,6 +694,14 @@ func newSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factor
 		return nil, err
 	}
 
+	// The hypervisor and the agent find the audit log in the context of
+	// the sandbox.
+	var audit *auditLog
+	if sandboxConfig.AuditLog {
+		audit = newAuditLog(sandboxConfig.AuditLogDir, sandboxConfig.ID, sandboxConfig.AuditLogMaxSizeMiB)
+		ctx = withAuditLog(ctx, audit)
+	}
+
 	s := &Sandbox{
 		id:              sandboxConfig.ID,
 		factory:         factory,
@@ -550,6 +719,7 @@ func newSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factor
 		sharePidNs:      sandboxConfig.SharePidNs,
 		stateful:        sandboxConfig.Stateful,
 		networkNS:       NetworkNamespace{NetNsPath: sandboxConfig.NetworkConfig.NetNSPath},
+		audit:           audit,
 		ctx:             ctx,
 	}
 
@@ -758,6 +928,10 @@ func (s *Sandbox) Delete() error {
 		return fmt.Errorf("Sandbox not ready, paused or stopped, impossible to delete")
 	}
 
+	// The temporary files are removed even if the sandbox cannot be
+	// entirely deleted, not to leave its tmpfs mounted.
+	defer s.tmp().cleanup(s.Logger())
+
 	for _, c := range s.containers {
 		if err := c.delete(); err != nil {
 			return err
@@ -774,12 +948,29 @@ func (s *Sandbox) Delete() error {
 		s.monitor.stop()
 	}
 
+	s.stopAutoscaler()
+	s.stopNetworkWatcher()
+
 	if err := s.hypervisor.cleanup(); err != nil {
 		s.Logger().WithError(err).Error("failed to cleanup hypervisor")
 	}
 
 	s.agent.cleanup(s)
 
+	// The sandbox may not have been stopped if its VM died.
+	if err := s.releaseKSMReference(); err != nil {
+		s.Logger().WithError(err).Warn("Could not restore the host KSM settings")
+	}
+
+	// Neither may its network have been removed.
+	if err := releaseMacAddresses(s.id); err != nil {
+		s.Logger().WithError(err).Warn("Could not release the MAC addresses")
+	}
+
+	s.cleanupRootfsScratch()
+
+	s.audit.close()
+
 	return s.store.Delete()
 }
 
@@ -831,6 +1022,12 @@ func (s *Sandbox) createNetwork() error {
 		NetNsCreated: s.config.NetworkConfig.NetNsCreated,
 	}
 
+	if err := applyMacAddressPolicy(s.networkNS.NetNsPath, s.id, s.config.NetworkConfig.MacAddressPolicy); err != nil {
+		return err
+	}
+
+	s.setupXDP()
+
 	// In case there is a factory, network interfaces are hotplugged
 	// after vm is started.
 	if s.factory == nil {
@@ -842,7 +1039,7 @@ func (s *Sandbox) createNetwork() error {
 
 		s.networkNS.Endpoints = endpoints
 
-		if s.config.NetworkConfig.NetmonConfig.Enable {
+		if s.config.NetworkConfig.NetmonConfig.Enable && !s.networkWatcherEnabled() {
 			if err := s.startNetworkMonitor(); err != nil {
 				return err
 			}
@@ -871,7 +1068,11 @@ func (s *Sandbox) removeNetwork() error {
 		}
 	}
 
-	return s.network.Remove(s.ctx, &s.networkNS, s.hypervisor)
+	if err := s.network.Remove(s.ctx, &s.networkNS, s.hypervisor); err != nil {
+		return err
+	}
+
+	return releaseMacAddresses(s.id)
 }
 
 func (s *Sandbox) generateNetInfo(inf *vcTypes.Interface) (NetworkInfo, error) {
@@ -917,10 +1118,20 @@ func (s *Sandbox) AddInterface(inf *vcTypes.Interface) (*vcTypes.Interface, erro
 	}
 
 	endpoint.SetProperties(netInfo)
+	if err := s.checkHotplugCapacity(1, 0); err != nil {
+		return nil, err
+	}
+	if err := setupEndpointVF(endpoint, s.config.NetworkConfig.SRIOV); err != nil {
+		return nil, err
+	}
 	if err := doNetNS(s.networkNS.NetNsPath, func(_ ns.NetNS) error {
 		s.Logger().WithField("endpoint-type", endpoint.Type()).Info("Hot attaching endpoint")
-		return endpoint.HotAttach(s.hypervisor)
+		if err := endpoint.HotAttach(s.hypervisor); err != nil {
+			return err
+		}
+		return setupBandwidth(endpoint, s.config.NetworkConfig)
 	}); err != nil {
+		restoreEndpointVF(endpoint)
 		return nil, err
 	}
 
@@ -982,6 +1193,32 @@ func (s *Sandbox) ListRoutes() ([]*vcTypes.Route, error) {
 	return s.agent.listRoutes()
 }
 
+// UpdateBandwidth changes the rates the traffic of the sandbox network
+// interfaces is limited to on the host, when the runtime limits it.
+func (s *Sandbox) UpdateBandwidth(bw BandwidthConfig) error {
+	if !s.config.NetworkConfig.EnableBandwidth {
+		return fmt.Errorf("The runtime does not limit the pod bandwidth, enable_pod_bandwidth is disabled")
+	}
+
+	config := s.config.NetworkConfig
+	config.Bandwidth = bw
+
+	if err := doNetNS(s.networkNS.NetNsPath, func(_ ns.NetNS) error {
+		for _, endpoint := range s.networkNS.Endpoints {
+			if err := setupBandwidth(endpoint, config); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	s.config.NetworkConfig.Bandwidth = bw
+
+	return s.storeSandbox()
+}
+
 // startVM starts the VM.
 func (s *Sandbox) startVM() (err error) {
 	span, ctx := s.trace("startVM")
@@ -999,14 +1236,16 @@ func (s *Sandbox) startVM() (err error) {
 				ProxyType:        s.config.ProxyType,
 				ProxyConfig:      s.config.ProxyConfig,
 			})
-			if err != nil {
-				return err
+			if err == nil {
+				return vm.assignSandbox(s)
 			}
 
-			return vm.assignSandbox(s)
+			// The network interfaces are hotplugged all the same
+			// after the VM is started.
+			s.Logger().WithError(err).Warn("Failed to get a VM from the factory, booting a new one")
 		}
 
-		return s.hypervisor.startSandbox(vmStartTimeout)
+		return s.hypervisor.startSandbox(s.config.HypervisorConfig.startTimeout())
 	}); err != nil {
 		return err
 	}
@@ -1027,7 +1266,7 @@ func (s *Sandbox) startVM() (err error) {
 
 		s.networkNS.Endpoints = endpoints
 
-		if s.config.NetworkConfig.NetmonConfig.Enable {
+		if s.config.NetworkConfig.NetmonConfig.Enable && !s.networkWatcherEnabled() {
 			if err := s.startNetworkMonitor(); err != nil {
 				return err
 			}
@@ -1040,6 +1279,30 @@ func (s *Sandbox) startVM() (err error) {
 		}
 	}
 
+	if err := s.setHypervisorOOMScoreAdj(); err != nil {
+		return err
+	}
+
+	if err := s.pinHypervisorVCPUs(); err != nil {
+		return err
+	}
+
+	if err := s.recordProvenance(); err != nil {
+		return err
+	}
+
+	s.recordLabels()
+
+	if err := s.takeKSMReference(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			s.releaseKSMReference()
+		}
+	}()
+
 	s.Logger().Info("VM started")
 
 	// Once the hypervisor is done starting the sandbox,
@@ -1116,6 +1379,14 @@ func (s *Sandbox) fetchContainers() error {
 // This should be called only when the sandbox is already created.
 // It will add new container config to sandbox.config.Containers
 func (s *Sandbox) CreateContainer(contConfig ContainerConfig) (VCContainer, error) {
+	if err := s.tmp().checkQuota(); err != nil {
+		return nil, err
+	}
+
+	// The capacity reserved for the devices and the memory of the
+	// container is now theirs.
+	s.claimHotplugReservation(contConfig.HotplugReservation)
+
 	storeAlreadyExists := store.VCContainerStoreExists(s.ctx, s.id, contConfig.ID)
 	// Create the container.
 	c, err := newContainer(s, contConfig)
@@ -1210,6 +1481,14 @@ func (s *Sandbox) StopContainer(containerID string, force bool) (VCContainer, er
 		return nil, err
 	}
 
+	// The vCPUs are hot removed when the sizing policy allows it, a
+	// failure does not prevent the container from being deleted.
+	if s.state.State == types.StateRunning {
+		if err := s.updateResources(); err != nil {
+			s.Logger().WithError(err).WithField("container", containerID).Warn("Could not resize the sandbox after deleting the container")
+		}
+	}
+
 	if err = s.storeSandbox(); err != nil {
 		return nil, err
 	}
@@ -1364,9 +1643,69 @@ func (s *Sandbox) StatsContainer(containerID string) (ContainerStats, error) {
 	if err != nil {
 		return ContainerStats{}, err
 	}
+
+	// The containers share the sandbox network namespace.
+	if len(stats.NetworkStats) == 0 {
+		netStats, err := s.NetworkStats()
+		if err != nil {
+			s.Logger().WithError(err).Warn("Could not get the host network stats")
+		} else {
+			stats.NetworkStats = netStats
+		}
+	}
+
 	return *stats, nil
 }
 
+// Metrics returns the host resources used by the sandbox hypervisor.
+func (s *Sandbox) Metrics() (HypervisorMetrics, error) {
+	if s.state.State != types.StateRunning {
+		return HypervisorMetrics{}, fmt.Errorf("Sandbox not running")
+	}
+
+	metrics, err := s.hypervisor.metrics()
+	if err != nil {
+		return HypervisorMetrics{}, err
+	}
+
+	if metrics.HostTmpUsage, metrics.HostScratchUsage, err = s.diskUsage(); err != nil {
+		s.Logger().WithError(err).Warn("Could not account the sandbox temporary files")
+	}
+
+	return metrics, nil
+}
+
+// AllocateVSockPort returns the guest vsock port of service, allocating
+// and persisting it first if needed.
+func (s *Sandbox) AllocateVSockPort(service string) (uint32, error) {
+	s.Lock()
+	port, err := s.state.VSockPorts.Allocate(service)
+	s.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return port, s.storeState()
+}
+
+// ReleaseVSockPort frees the guest vsock port of service.
+func (s *Sandbox) ReleaseVSockPort(service string) error {
+	s.Lock()
+	s.state.VSockPorts.Release(service)
+	s.Unlock()
+
+	return s.storeState()
+}
+
+// storeState stores the sandbox state.
+func (s *Sandbox) storeState() error {
+	if s.supportNewStore() {
+		return s.Save()
+	}
+
+	return s.store.Store(store.State, s.state)
+}
+
 // PauseContainer pauses a running container.
 func (s *Sandbox) PauseContainer(containerID string) error {
 	// Fetch the container.
@@ -1447,6 +1786,10 @@ func (s *Sandbox) Start() error {
 		return err
 	}
 
+	// a restarted sandbox has not been shutdown yet.
+	s.state.ShutdownReason = ""
+	s.state.ShutdownMessage = ""
+
 	if err := s.setSandboxState(types.StateRunning); err != nil {
 		return err
 	}
@@ -1461,6 +1804,9 @@ func (s *Sandbox) Start() error {
 		return err
 	}
 
+	s.startAutoscaler()
+	s.startNetworkWatcher()
+
 	s.Logger().Info("Sandbox is started")
 
 	return nil
@@ -1482,6 +1828,12 @@ func (s *Sandbox) Stop(force bool) error {
 		return err
 	}
 
+	// Nothing failed before, the user asked for the sandbox to stop.
+	s.setShutdownReason(types.ShutdownReasonUser, "")
+
+	s.stopAutoscaler()
+	s.stopNetworkWatcher()
+
 	for _, c := range s.containers {
 		if err := c.stop(force); err != nil {
 			return err
@@ -1492,6 +1844,10 @@ func (s *Sandbox) Stop(force bool) error {
 		return err
 	}
 
+	if err := s.releaseKSMReference(); err != nil {
+		s.Logger().WithError(err).Warn("Could not restore the host KSM settings")
+	}
+
 	if err := s.setSandboxState(types.StateStopped); err != nil {
 		return err
 	}
@@ -1522,6 +1878,9 @@ func (s *Sandbox) Pause() error {
 		s.monitor.stop()
 	}
 
+	s.stopAutoscaler()
+	s.stopNetworkWatcher()
+
 	if err := s.pauseSetStates(); err != nil {
 		return err
 	}
@@ -1547,6 +1906,9 @@ func (s *Sandbox) Resume() error {
 		return err
 	}
 
+	s.startAutoscaler()
+	s.startNetworkWatcher()
+
 	return nil
 }
 
@@ -1601,10 +1963,19 @@ func (s *Sandbox) resumeSetStates() error {
 // subsequent accesses. This index is used to maintain the index at which a
 // block device is assigned to a container in the sandbox.
 func (s *Sandbox) getAndSetSandboxBlockIndex() (int, error) {
-	currentIndex := s.state.BlockIndex
+	var currentIndex int
 
-	// Increment so that container gets incremented block index
-	s.state.BlockIndex++
+	// Reuse the lowest index released by an unplugged block device,
+	// so that attaching and detaching volumes does not exhaust indexes.
+	if len(s.state.FreeBlockIndexes) > 0 {
+		currentIndex = s.state.FreeBlockIndexes[0]
+		s.state.FreeBlockIndexes = s.state.FreeBlockIndexes[1:]
+	} else {
+		currentIndex = s.state.BlockIndex
+
+		// Increment so that container gets incremented block index
+		s.state.BlockIndex++
+	}
 
 	if !s.supportNewStore() {
 		// experimental runtime use "persist.json" which doesn't need "state.json" anymore
@@ -1617,10 +1988,30 @@ func (s *Sandbox) getAndSetSandboxBlockIndex() (int, error) {
 	return currentIndex, nil
 }
 
-// decrementSandboxBlockIndex decrements the current sandbox block index.
-// This is used to recover from failure while adding a block device.
-func (s *Sandbox) decrementSandboxBlockIndex() error {
-	s.state.BlockIndex--
+// unsetSandboxBlockIndex releases a sandbox block index. This is used once
+// a block device has been unplugged, or to recover from failure while adding
+// a block device.
+func (s *Sandbox) unsetSandboxBlockIndex(index int) error {
+	if index < 0 || index >= s.state.BlockIndex {
+		return fmt.Errorf("Invalid block index %d", index)
+	}
+
+	free := s.state.FreeBlockIndexes
+	i := sort.SearchInts(free, index)
+	if i < len(free) && free[i] == index {
+		return fmt.Errorf("Block index %d already released", index)
+	}
+
+	free = append(free, 0)
+	copy(free[i+1:], free[i:])
+	free[i] = index
+
+	// Forget about the highest indexes once released
+	for len(free) > 0 && free[len(free)-1] == s.state.BlockIndex-1 {
+		free = free[:len(free)-1]
+		s.state.BlockIndex--
+	}
+	s.state.FreeBlockIndexes = free
 
 	if !s.supportNewStore() {
 		// experimental runtime use "persist.json" which doesn't need "state.json" anymore
@@ -1695,6 +2086,12 @@ func (s *Sandbox) HotplugAddDevice(device api.Device, devType config.DeviceType)
 			return fmt.Errorf("device type mismatch, expect device type to be %s", devType)
 		}
 
+		if !s.config.HypervisorConfig.HotplugVFIOOnRootBus {
+			if err := s.checkHotplugCapacity(uint32(len(vfioDevices)), 0); err != nil {
+				return err
+			}
+		}
+
 		// adding a group of VFIO devices
 		for _, dev := range vfioDevices {
 			if _, err := s.hypervisor.hotplugAddDevice(dev, vfioDev); err != nil {
@@ -1713,6 +2110,11 @@ func (s *Sandbox) HotplugAddDevice(device api.Device, devType config.DeviceType)
 		if !ok {
 			return fmt.Errorf("device type mismatch, expect device type to be %s", devType)
 		}
+		if s.config.HypervisorConfig.BlockDeviceDriver == config.VirtioBlock {
+			if err := s.checkHotplugCapacity(1, 0); err != nil {
+				return err
+			}
+		}
 		_, err := s.hypervisor.hotplugAddDevice(blockDevice.BlockDrive, blockDev)
 		return err
 	case config.DeviceGeneric:
@@ -1765,10 +2167,10 @@ func (s *Sandbox) GetAndSetSandboxBlockIndex() (int, error) {
 	return s.getAndSetSandboxBlockIndex()
 }
 
-// DecrementSandboxBlockIndex decrease block indexes
+// UnsetSandboxBlockIndex releases a block index
 // Sandbox implement DeviceReceiver interface from device/api/interface.go
-func (s *Sandbox) DecrementSandboxBlockIndex() error {
-	return s.decrementSandboxBlockIndex()
+func (s *Sandbox) UnsetSandboxBlockIndex(index int) error {
+	return s.unsetSandboxBlockIndex(index)
 }
 
 // AppendDevice can only handle vhost user device currently, it adds a
@@ -1806,7 +2208,7 @@ func (s *Sandbox) AddDevice(info config.DeviceInfo) (api.Device, error) {
 	return b, nil
 }
 
-func (s *Sandbox) updateResources() error {
+func (s *Sandbox) updateResources() (err error) {
 	// the hypervisor.MemorySize is the amount of memory reserved for
 	// the VM and contaniners without memory limit
 
@@ -1818,12 +2220,22 @@ func (s *Sandbox) updateResources() error {
 		return fmt.Errorf("sandbox config is nil")
 	}
 
-	sandboxVCPUs := s.calculateSandboxCPUs()
-	// Add default vcpus for sandbox
-	sandboxVCPUs += s.hypervisor.hypervisorConfig().NumVCPUs
+	targetVCPUs := s.targetSize().vCPUs
+	sandboxVCPUs := s.size().vCPUs
+
+	sandboxMemoryByte := s.guestMemory()
 
-	sandboxMemoryByte := int64(s.hypervisor.hypervisorConfig().MemorySize) << utils.MibToBytesShift
-	sandboxMemoryByte += s.calculateSandboxMemory()
+	if err := s.checkHotplugCapacity(0, uint32(sandboxMemoryByte>>utils.MibToBytesShift)); err != nil {
+		return err
+	}
+
+	// Resizing the vCPUs and then the memory can take long, the job lets
+	// management layers follow it while the update blocks, and cancel it
+	// between both steps, the vCPUs being restored then.
+	job := s.hotplugJobs.start("update-resources", s.id, 2)
+	defer func() {
+		job.finish(err)
+	}()
 
 	// Update VCPUs
 	s.Logger().WithField("cpus-sandbox", sandboxVCPUs).Debugf("Request to hypervisor to update vCPUs")
@@ -1831,6 +2243,8 @@ func (s *Sandbox) updateResources() error {
 	if err != nil {
 		return err
 	}
+	sizedVCPUs := s.sizedVCPUs
+	s.sizedVCPUs = targetVCPUs
 	// The CPUs were increased, ask agent to online them
 	if oldCPUs < newCPUs {
 		vcpusAdded := newCPUs - oldCPUs
@@ -1838,7 +2252,26 @@ func (s *Sandbox) updateResources() error {
 			return err
 		}
 	}
+	if oldCPUs != newCPUs {
+		if err := s.pinHypervisorVCPUs(); err != nil {
+			return err
+		}
+	}
 	s.Logger().Debugf("Sandbox CPUs: %d", newCPUs)
+	job.stepDone()
+
+	if err := job.step(); err != nil {
+		s.Logger().WithField("cpus-sandbox", oldCPUs).Warn("Resources update cancelled, restoring vCPUs")
+		if _, _, rollbackErr := s.hypervisor.resizeVCPUs(oldCPUs); rollbackErr != nil {
+			s.Logger().WithError(rollbackErr).Error("rollback failed resizeVCPUs()")
+		} else {
+			s.sizedVCPUs = sizedVCPUs
+			if pinErr := s.pinHypervisorVCPUs(); pinErr != nil {
+				s.Logger().WithError(pinErr).Error("rollback failed pinHypervisorVCPUs()")
+			}
+		}
+		return err
+	}
 
 	// Update Memory
 	s.Logger().WithField("memory-sandbox-size-byte", sandboxMemoryByte).Debugf("Request to hypervisor to update memory")
@@ -1857,6 +2290,8 @@ func (s *Sandbox) updateResources() error {
 	if err := s.agent.onlineCPUMem(0, false); err != nil {
 		return err
 	}
+	job.stepDone()
+
 	return nil
 }
 
@@ -1871,6 +2306,10 @@ func (s *Sandbox) calculateSandboxMemory() int64 {
 }
 
 func (s *Sandbox) calculateSandboxCPUs() uint32 {
+	return utils.CalculateVCpusFromMilliCpus(s.calculateSandboxMilliCPUs())
+}
+
+func (s *Sandbox) calculateSandboxMilliCPUs() uint32 {
 	mCPU := uint32(0)
 
 	for _, c := range s.config.Containers {
@@ -1881,7 +2320,7 @@ func (s *Sandbox) calculateSandboxCPUs() uint32 {
 
 		}
 	}
-	return utils.CalculateVCpusFromMilliCpus(mCPU)
+	return mCPU
 }
 
 // GetHypervisorType is used for getting Hypervisor name currently used.
@@ -1890,6 +2329,56 @@ func (s *Sandbox) GetHypervisorType() string {
 	return string(s.config.HypervisorType)
 }
 
+// guestMemory returns the memory, in bytes, required by the sandbox.
+func (s *Sandbox) guestMemory() int64 {
+	return s.size().memory
+}
+
+// hypervisorMemoryCap returns the host memory limit, in bytes, of the
+// hypervisor processes, or zero when they are not capped. As guest memory
+// is never hot removed, the limit is never lowered.
+func (s *Sandbox) hypervisorMemoryCap() int64 {
+	hConfig := s.hypervisor.hypervisorConfig()
+	if !hConfig.HostMemoryCap {
+		return 0
+	}
+
+	guestMemory := s.guestMemory()
+
+	overhead := int64(hConfig.HostMemoryCapOverheadMB) << utils.MibToBytesShift
+	if overhead == 0 {
+		overhead = int64(defaultHostMemoryCapOverheadMiB)<<utils.MibToBytesShift + guestMemory/defaultHostMemoryCapOverheadRatio
+	}
+
+	if memoryCap := guestMemory + overhead; memoryCap > s.state.HypervisorMemoryCap {
+		s.state.HypervisorMemoryCap = memoryCap
+	}
+
+	return s.state.HypervisorMemoryCap
+}
+
+// setHypervisorOOMScoreAdj applies the configured oom_score_adj to the
+// hypervisor processes.
+func (s *Sandbox) setHypervisorOOMScoreAdj() error {
+	score := s.hypervisor.hypervisorConfig().OOMScoreAdj
+	if score == 0 {
+		return nil
+	}
+
+	for _, pid := range s.hypervisor.getPids() {
+		if pid <= 0 {
+			continue
+		}
+
+		path := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+		if err := ioutil.WriteFile(path, []byte(strconv.Itoa(score)), 0644); err != nil {
+			return fmt.Errorf("Could not set hypervisor PID %d oom_score_adj: %v", pid, err)
+		}
+	}
+
+	return nil
+}
+
 func (s *Sandbox) cgroupsUpdate() error {
 	if s.state.CgroupPath == "" {
 		s.Logger().Warn("sandbox's cgroup won't be updated: cgroup path is empty")
@@ -1971,6 +2460,11 @@ func (s *Sandbox) cgroupsDelete() error {
 }
 
 func (s *Sandbox) constrainHypervisorVCPUs(cgroup cgroups.Cgroup) error {
+	// The hypervisor stays in the scope of its slice, which constrains it.
+	if s.hypervisor.hypervisorConfig().VMMSlice != "" {
+		return nil
+	}
+
 	pids := s.hypervisor.getPids()
 	if len(pids) == 0 || pids[0] == 0 {
 		return fmt.Errorf("Invalid hypervisor PID: %+v", pids)
@@ -1979,6 +2473,17 @@ func (s *Sandbox) constrainHypervisorVCPUs(cgroup cgroups.Cgroup) error {
 	// Move hypervisor into cgroups without constraints,
 	// those cgroups are not yet supported.
 	resources := &specs.LinuxResources{}
+	if memoryCap := s.hypervisorMemoryCap(); memoryCap > 0 {
+		resources.Memory = &specs.LinuxMemory{
+			Limit: &memoryCap,
+		}
+	}
+
+	// The threads of a process cannot be spread over several cgroups of
+	// the unified hierarchy, the whole hypervisor is constrained.
+	if isCgroupV2() {
+		return s.constrainHypervisor(cgroup, pids, resources)
+	}
 	path := cgroupNoConstraintsPath(s.state.CgroupPath)
 	noConstraintsCgroup, err := cgroupsNewFunc(V1NoConstraints, cgroups.StaticPath(path), resources)
 	if err != nil {
@@ -2024,7 +2529,31 @@ func (s *Sandbox) constrainHypervisorVCPUs(cgroup cgroups.Cgroup) error {
 		}
 	}
 
-	return nil
+	// Joining the cpuset cgroup resets the vCPU threads affinity.
+	return s.pinHypervisorVCPUs()
+}
+
+// constrainHypervisor moves the hypervisor processes into the sandbox
+// cgroup of the unified hierarchy, capping its memory with resources.
+func (s *Sandbox) constrainHypervisor(cgroup cgroups.Cgroup, pids []int, resources *specs.LinuxResources) error {
+	if resources.Memory != nil {
+		if err := cgroup.Update(resources); err != nil {
+			return err
+		}
+	}
+
+	for _, pid := range pids {
+		if pid <= 0 {
+			s.Logger().Warnf("Invalid hypervisor pid: %d", pid)
+			continue
+		}
+
+		if err := cgroup.Add(cgroups.Process{Pid: pid}); err != nil {
+			return fmt.Errorf("Could not add hypervisor PID %d to cgroup %v: %v", pid, s.state.CgroupPath, err)
+		}
+	}
+
+	return s.pinHypervisorVCPUs()
 }
 
 func (s *Sandbox) resources() (specs.LinuxResources, error) {
@@ -14,6 +14,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
@@ -1593,3 +1594,159 @@ func TestSandbox_SetupSandboxCgroup(t *testing.T) {
 		})
 	}
 }
+
+func TestSandboxHypervisorMemoryCap(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		config: HypervisorConfig{
+			MemorySize: 1024,
+		},
+	}
+	s := &Sandbox{
+		hypervisor: q,
+		config:     &SandboxConfig{},
+	}
+
+	assert.Equal(int64(0), s.hypervisorMemoryCap())
+
+	// guest memory plus 256 MiB and 1/64 of the guest memory
+	q.config.HostMemoryCap = true
+	assert.Equal(int64(1024+256+16)<<20, s.hypervisorMemoryCap())
+
+	// guest memory is never hot removed, the cap is never lowered
+	q.config.MemorySize = 512
+	assert.Equal(int64(1024+256+16)<<20, s.hypervisorMemoryCap())
+
+	q.config.MemorySize = 2048
+	q.config.HostMemoryCapOverheadMB = 128
+	assert.Equal(int64(2048+128)<<20, s.hypervisorMemoryCap())
+}
+
+func TestSandboxSetHypervisorOOMScoreAdj(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(cmd.Start())
+	defer cmd.Process.Kill()
+
+	pidFile, err := ioutil.TempFile(testDir, "qemu-pid-")
+	assert.NoError(err)
+	defer os.Remove(pidFile.Name())
+
+	_, err = pidFile.WriteString(fmt.Sprintf("%d", cmd.Process.Pid))
+	assert.NoError(err)
+	pidFile.Close()
+
+	q := &qemu{}
+	q.qemuConfig.PidFile = pidFile.Name()
+	s := &Sandbox{
+		hypervisor: q,
+	}
+
+	// nothing to do by default
+	assert.NoError(s.setHypervisorOOMScoreAdj())
+
+	q.config.OOMScoreAdj = 500
+	assert.NoError(s.setHypervisorOOMScoreAdj())
+
+	score, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", cmd.Process.Pid))
+	assert.NoError(err)
+	assert.Equal("500", strings.TrimSpace(string(score)))
+}
+
+func TestSandboxAllocateVSockPort(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		id:     testSandboxID,
+		config: &SandboxConfig{},
+		ctx:    context.Background(),
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	sandbox.store = vcStore
+	defer vcStore.Delete()
+
+	port, err := sandbox.AllocateVSockPort(types.VSockMetricsService)
+	assert.NoError(err)
+	assert.Equal(types.VSockMetricsPort, port)
+
+	port, err = sandbox.AllocateVSockPort("foo")
+	assert.NoError(err)
+
+	var state types.SandboxState
+	assert.NoError(vcStore.Load(store.State, &state))
+	assert.Equal(port, state.VSockPorts["foo"])
+
+	assert.NoError(sandbox.ReleaseVSockPort("foo"))
+	state = types.SandboxState{}
+	assert.NoError(vcStore.Load(store.State, &state))
+	assert.NotContains(state.VSockPorts, "foo")
+}
+
+func TestSandboxBlockIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		id:     testSandboxID,
+		config: &SandboxConfig{},
+		ctx:    context.Background(),
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	sandbox.store = vcStore
+	defer vcStore.Delete()
+
+	for i := 0; i < 4; i++ {
+		index, err := sandbox.getAndSetSandboxBlockIndex()
+		assert.NoError(err)
+		assert.Equal(i, index)
+	}
+
+	// released indexes are reused, lowest first
+	assert.NoError(sandbox.unsetSandboxBlockIndex(2))
+	assert.NoError(sandbox.unsetSandboxBlockIndex(1))
+	assert.Equal([]int{1, 2}, sandbox.state.FreeBlockIndexes)
+
+	index, err := sandbox.getAndSetSandboxBlockIndex()
+	assert.NoError(err)
+	assert.Equal(1, index)
+
+	// invalid or already released indexes
+	assert.Error(sandbox.unsetSandboxBlockIndex(2))
+	assert.Error(sandbox.unsetSandboxBlockIndex(4))
+	assert.Error(sandbox.unsetSandboxBlockIndex(-1))
+	assert.Equal(4, sandbox.state.BlockIndex)
+
+	// releasing the highest index releases the free ones below it
+	assert.NoError(sandbox.unsetSandboxBlockIndex(3))
+	assert.Empty(sandbox.state.FreeBlockIndexes)
+	assert.Equal(2, sandbox.state.BlockIndex)
+
+	index, err = sandbox.getAndSetSandboxBlockIndex()
+	assert.NoError(err)
+	assert.Equal(2, index)
+}
+
+func TestSandboxSetBlockDeviceDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	sandbox := &Sandbox{
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				BlockDeviceDriver: config.VirtioPmem,
+			},
+		},
+		devManager: manager.NewDeviceManager(config.VirtioPmem, nil),
+		hypervisor: &mockHypervisor{},
+	}
+	dm := sandbox.devManager
+
+	sandbox.setBlockDeviceDriver(config.VirtioBlock)
+	assert.Equal(config.VirtioBlock, sandbox.config.HypervisorConfig.BlockDeviceDriver)
+	assert.NotEqual(dm, sandbox.devManager)
+	assert.Empty(sandbox.devManager.GetAllDevices())
+}
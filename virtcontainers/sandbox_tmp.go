@@ -0,0 +1,212 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxTmpDir returns the directory holding the temporary files of the
+// sandbox id on the host: the sockets of its VM, the vhost-user and console
+// sockets, the hypervisor log and pid files, and the files the runtime
+// stages there. They are all accounted and removed together.
+func sandboxTmpDir(id string) string {
+	return filepath.Join(store.RunVMStoragePath, id)
+}
+
+// sandboxTmp manages the temporary files of a sandbox on the host. With a
+// quota, its directory is a tmpfs of the quota size, so that the kernel
+// refuses the writes beyond it whoever does them: the runtime, the
+// hypervisor or its helper daemons.
+type sandboxTmp struct {
+	id string
+
+	// quota is the size of the directory in bytes, 0 when not limited.
+	quota uint64
+}
+
+// tmp returns the manager of the temporary files of the sandbox.
+func (s *Sandbox) tmp() sandboxTmp {
+	t := sandboxTmp{id: s.id}
+	if s.config != nil {
+		t.quota = uint64(s.config.TmpQuota) << 20
+	}
+
+	return t
+}
+
+func (t sandboxTmp) dir() string {
+	return sandboxTmpDir(t.id)
+}
+
+// tempDir creates a new directory in the directory of the sandbox, to be
+// removed by the caller, or along with the sandbox.
+func (t sandboxTmp) tempDir(prefix string) (string, error) {
+	if err := os.MkdirAll(t.dir(), store.DirMode); err != nil {
+		return "", err
+	}
+
+	return ioutil.TempDir(t.dir(), prefix)
+}
+
+// setup creates the directory of the sandbox, mounting a tmpfs of the quota
+// size on it if any. The VMs of a factory run from the directory of the
+// template they were created from, which is not limited.
+func (t sandboxTmp) setup(factory bool) error {
+	dir := t.dir()
+	if err := os.MkdirAll(dir, store.DirMode); err != nil {
+		return err
+	}
+
+	if t.quota == 0 {
+		return nil
+	}
+
+	if factory {
+		virtLog.WithField("sandbox", t.id).Warn("Not limiting the temporary files of a sandbox running a factory VM")
+		return nil
+	}
+
+	options := fmt.Sprintf("size=%d,mode=%o", t.quota, store.DirMode)
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, options); err != nil {
+		return fmt.Errorf("Could not limit the temporary files of the sandbox to %d bytes: %v", t.quota, err)
+	}
+
+	return nil
+}
+
+// removeVMDir removes the directory of a VM and its files. The tmpfs of
+// the quota of a sandbox is emptied, the sandbox unmounting it.
+func removeVMDir(dir string) error {
+	err := os.RemoveAll(dir)
+	if pe, ok := err.(*os.PathError); ok && pe.Path == dir && pe.Err == syscall.EBUSY {
+		return nil
+	}
+
+	return err
+}
+
+// dirDiskUsage returns the disk space allocated to the files under dir, in
+// bytes. The symbolic links are not followed, but dir itself is resolved as
+// the VM templates link it to the directory of their VM. A file with
+// several hard links is only accounted once.
+func dirDiskUsage(dir string) (uint64, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var usage uint64
+	inodes := make(map[uint64]struct{})
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The files may be removed while walking the directory.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		if _, seen := inodes[st.Ino]; seen {
+			return nil
+		}
+		inodes[st.Ino] = struct{}{}
+
+		// st_blocks is always in units of 512 bytes.
+		usage += uint64(st.Blocks) * 512
+
+		return nil
+	})
+
+	return usage, err
+}
+
+// usage returns the disk space used by the temporary files of the sandbox
+// on the host, in bytes.
+func (t sandboxTmp) usage() (uint64, error) {
+	return dirDiskUsage(t.dir())
+}
+
+// checkQuota fails when the temporary files of the sandbox fill its quota,
+// so that no container is added to a sandbox which cannot write them.
+func (t sandboxTmp) checkQuota() error {
+	if t.quota == 0 {
+		return nil
+	}
+
+	usage, err := t.usage()
+	if err != nil {
+		return err
+	}
+
+	if usage >= t.quota {
+		return fmt.Errorf("Sandbox temporary files use %d bytes, the quota is %d bytes", usage, t.quota)
+	}
+
+	return nil
+}
+
+// cleanup removes the temporary files of the sandbox, whatever the
+// hypervisor left behind, and unmounts the tmpfs of its quota. A directory
+// linked by a VM template is removed along with its target.
+func (t sandboxTmp) cleanup(logger *logrus.Entry) {
+	dir := t.dir()
+
+	// The tmpfs is looked for rather than the quota, which the sandbox
+	// may have been created with by a previous configuration.
+	if err := unix.Unmount(dir, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		logger.WithError(err).WithField("dir", dir).Warn("failed to unmount the sandbox temporary directory")
+	}
+
+	link, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WithError(err).WithField("dir", dir).Warn("failed to resolve the sandbox temporary directory")
+		}
+		link = ""
+	}
+
+	for _, path := range []string{dir, link} {
+		if path == "" {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			logger.WithError(err).WithField("dir", path).Warn("failed to remove the sandbox temporary directory")
+		}
+	}
+}
+
+// diskUsage returns the disk space the sandbox uses on the host, in bytes:
+// its temporary files and the scratch disks of its containers.
+func (s *Sandbox) diskUsage() (tmp uint64, scratch uint64, err error) {
+	if tmp, err = s.tmp().usage(); err != nil {
+		return 0, 0, err
+	}
+
+	if scratch, err = dirDiskUsage(filepath.Join(rootfsScratchDir, s.id)); err != nil {
+		return 0, 0, err
+	}
+
+	return tmp, scratch, nil
+}
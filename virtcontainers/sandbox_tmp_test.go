@@ -0,0 +1,157 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirDiskUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sandbox-tmp")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	usage, err := dirDiskUsage(filepath.Join(dir, "missing"))
+	assert.NoError(err)
+	assert.Zero(usage)
+
+	empty, err := dirDiskUsage(dir)
+	assert.NoError(err)
+
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = 1
+	}
+	file := filepath.Join(dir, "file")
+	assert.NoError(ioutil.WriteFile(file, data, 0600))
+
+	usage, err = dirDiskUsage(dir)
+	assert.NoError(err)
+	assert.True(usage >= empty+uint64(len(data)))
+
+	// A hard link is only accounted once.
+	assert.NoError(os.Link(file, filepath.Join(dir, "link")))
+	linked, err := dirDiskUsage(dir)
+	assert.NoError(err)
+	assert.Equal(usage, linked)
+
+	// The directory of a VM template is accounted through its link.
+	link := filepath.Join(dir, "..", filepath.Base(dir)+"-link")
+	assert.NoError(os.Symlink(dir, link))
+	defer os.Remove(link)
+	linked, err = dirDiskUsage(link)
+	assert.NoError(err)
+	assert.Equal(usage, linked)
+}
+
+func TestSandboxTmp(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sandbox-tmp")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedRunVMStoragePath := store.RunVMStoragePath
+	store.RunVMStoragePath = dir
+	defer func() {
+		store.RunVMStoragePath = savedRunVMStoragePath
+	}()
+
+	s := &Sandbox{
+		id:     "sandbox",
+		config: &SandboxConfig{},
+	}
+
+	tmpDir := s.tmp().dir()
+	assert.Equal(filepath.Join(dir, s.id), tmpDir)
+	assert.NoError(s.tmp().setup(false))
+	assert.NoError(ioutil.WriteFile(filepath.Join(tmpDir, "file"), make([]byte, 2<<20), 0600))
+
+	// Not limited without a quota.
+	assert.NoError(s.tmp().checkQuota())
+
+	s.config.TmpQuota = 1
+	assert.Error(s.tmp().checkQuota())
+
+	s.config.TmpQuota = 4
+	assert.NoError(s.tmp().checkQuota())
+
+	staging, err := s.tmp().tempDir("staging")
+	assert.NoError(err)
+	assert.Equal(tmpDir, filepath.Dir(staging))
+
+	// The target of a VM template link is removed as well.
+	target := filepath.Join(dir, "template")
+	assert.NoError(os.Rename(tmpDir, target))
+	assert.NoError(os.Symlink(target, tmpDir))
+
+	s.tmp().cleanup(s.Logger())
+	for _, path := range []string{tmpDir, target} {
+		_, err := os.Lstat(path)
+		assert.True(os.IsNotExist(err), path)
+	}
+
+	tmp, scratch, err := s.diskUsage()
+	assert.NoError(err)
+	assert.Zero(tmp)
+	assert.Zero(scratch)
+
+	// Cleaning up twice is harmless.
+	s.tmp().cleanup(s.Logger())
+}
+
+func TestSandboxTmpQuota(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(testDisabledAsNonRoot)
+	}
+
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sandbox-tmp")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedRunVMStoragePath := store.RunVMStoragePath
+	store.RunVMStoragePath = dir
+	defer func() {
+		store.RunVMStoragePath = savedRunVMStoragePath
+	}()
+
+	s := &Sandbox{
+		id:     "sandbox",
+		config: &SandboxConfig{TmpQuota: 1},
+	}
+
+	// The VMs of a factory are not limited.
+	assert.NoError(s.tmp().setup(true))
+	assert.NoError(ioutil.WriteFile(filepath.Join(s.tmp().dir(), "file"), make([]byte, 2<<20), 0600))
+	s.tmp().cleanup(s.Logger())
+
+	assert.NoError(s.tmp().setup(false))
+
+	// The writes beyond the quota fail.
+	err = ioutil.WriteFile(filepath.Join(s.tmp().dir(), "file"), make([]byte, 2<<20), 0600)
+	assert.Error(err)
+	assert.Error(s.tmp().checkQuota())
+
+	// The hypervisor empties the tmpfs, which the sandbox unmounts.
+	assert.NoError(removeVMDir(s.tmp().dir()))
+	_, err = os.Stat(s.tmp().dir())
+	assert.NoError(err)
+
+	s.tmp().cleanup(s.Logger())
+	_, err = os.Stat(s.tmp().dir())
+	assert.True(os.IsNotExist(err))
+}
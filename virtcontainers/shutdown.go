@@ -0,0 +1,138 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+)
+
+// Errors reported by the sandbox monitor, used to classify why a sandbox
+// died. Use errors.Cause() to compare them.
+var (
+	// ErrGuestPanicked means the guest kernel panicked.
+	ErrGuestPanicked = errors.New("guest panicked")
+
+	// ErrHypervisorError means the hypervisor reported an internal error.
+	ErrHypervisorError = errors.New("hypervisor internal error")
+
+	// ErrVMMDead means the hypervisor process exited.
+	ErrVMMDead = errors.New("hypervisor process exited")
+
+	// ErrVMMOOMKilled means the host OOM killer killed the hypervisor
+	// process.
+	ErrVMMOOMKilled = errors.New("hypervisor process killed by the OOM killer")
+
+	// ErrAgentUnresponsive means the guest is running but the agent does
+	// not answer.
+	ErrAgentUnresponsive = errors.New("agent unresponsive")
+)
+
+// checkAgent calls agentCheck, if any, on behalf of a hypervisor check().
+func checkAgent(agentCheck func() error) error {
+	if agentCheck == nil {
+		return nil
+	}
+
+	if err := agentCheck(); err != nil {
+		return errors.Wrap(ErrAgentUnresponsive, err.Error())
+	}
+
+	return nil
+}
+
+// hypervisorShutdownReason classifies an hypervisor check() failure.
+func (s *Sandbox) hypervisorShutdownReason(err error) types.ShutdownReason {
+	switch errors.Cause(err) {
+	case ErrGuestPanicked:
+		return types.ShutdownReasonGuestPanic
+	case ErrVMMDead:
+		if s.hypervisorOOMKilled() {
+			return types.ShutdownReasonHypervisorOOMKilled
+		}
+		return types.ShutdownReasonHypervisorExited
+	case ErrAgentUnresponsive:
+		return types.ShutdownReasonAgentLost
+	default:
+		return types.ShutdownReasonHypervisorError
+	}
+}
+
+// hypervisorOOMKilled returns true when the host OOM killer killed a
+// process of the sandbox memory cgroup, where the hypervisor runs.
+func (s *Sandbox) hypervisorOOMKilled() bool {
+	if s.state.CgroupPath == "" {
+		return false
+	}
+
+	var file string
+	if isCgroupV2() {
+		// The hypervisor runs in the sandbox cgroup.
+		file = filepath.Join(cgroupV2MountPoint, s.state.CgroupPath, "memory.events")
+	} else {
+		path := s.state.CgroupPath
+		if !s.config.SandboxCgroupOnly {
+			path = cgroupNoConstraintsPath(path)
+		}
+
+		mountPoint, err := cgroupV1MountPoint()
+		if err != nil {
+			return false
+		}
+
+		file = filepath.Join(mountPoint, "memory", path, "memory.oom_control")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		s.Logger().WithError(err).Debug("Could not read sandbox memory cgroup OOM control")
+		return false
+	}
+
+	return parseOOMKillCount(string(data)) > 0
+}
+
+// parseOOMKillCount returns the oom_kill counter of a memory.oom_control
+// or memory.events cgroup file, older kernels don't provide it.
+func parseOOMKillCount(data string) uint64 {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+
+		count, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return count
+	}
+
+	return 0
+}
+
+// setShutdownReason records why the sandbox is being stopped. Only the
+// first reason is kept, as it is the root cause of the shutdown.
+func (s *Sandbox) setShutdownReason(reason types.ShutdownReason, message string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.state.ShutdownReason != "" {
+		return
+	}
+
+	s.Logger().WithField("reason", reason).WithField("message", message).Info("Recording sandbox shutdown reason")
+
+	s.state.ShutdownReason = reason
+	s.state.ShutdownMessage = message
+}
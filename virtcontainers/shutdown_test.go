@@ -0,0 +1,59 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOOMKillCount(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint64(0), parseOOMKillCount(""))
+	assert.Equal(uint64(0), parseOOMKillCount("oom_kill_disable 0\nunder_oom 0\n"))
+	assert.Equal(uint64(0), parseOOMKillCount("oom_kill_disable 0\nunder_oom 0\noom_kill 0\n"))
+	assert.Equal(uint64(3), parseOOMKillCount("oom_kill_disable 0\nunder_oom 0\noom_kill 3\n"))
+	assert.Equal(uint64(0), parseOOMKillCount("oom_kill foo\n"))
+}
+
+func TestHypervisorShutdownReason(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+
+	assert.Equal(types.ShutdownReasonGuestPanic, s.hypervisorShutdownReason(errors.Wrap(ErrGuestPanicked, "guest failure")))
+	assert.Equal(types.ShutdownReasonHypervisorExited, s.hypervisorShutdownReason(errors.Wrap(ErrVMMDead, "qemu")))
+	assert.Equal(types.ShutdownReasonHypervisorError, s.hypervisorShutdownReason(errors.Wrap(ErrHypervisorError, "guest failure")))
+	assert.Equal(types.ShutdownReasonAgentLost, s.hypervisorShutdownReason(errors.Wrap(ErrAgentUnresponsive, "timeout")))
+	assert.Equal(types.ShutdownReasonHypervisorError, s.hypervisorShutdownReason(errors.New("unknown")))
+}
+
+func TestCheckAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkAgent(nil))
+	assert.NoError(checkAgent(func() error { return nil }))
+
+	err := checkAgent(func() error { return errors.New("timeout") })
+	assert.Error(err)
+	assert.Equal(ErrAgentUnresponsive, errors.Cause(err))
+}
+
+func TestSetShutdownReason(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{}
+
+	s.setShutdownReason(types.ShutdownReasonAgentLost, "agent timeout")
+	s.setShutdownReason(types.ShutdownReasonUser, "")
+
+	assert.Equal(types.ShutdownReasonAgentLost, s.state.ShutdownReason)
+	assert.Equal("agent timeout", s.state.ShutdownMessage)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+)
+
+const (
+	// StaticSizingPolicy sizes a sandbox with the default vCPUs and
+	// memory of the hypervisor plus the limits of its containers.
+	StaticSizingPolicy = "static"
+
+	// OverheadSizingPolicy adds the pod overhead and a burst headroom to
+	// the static size, and smooths the vCPU hot plugs with hysteresis.
+	OverheadSizingPolicy = "overhead"
+)
+
+// SizingConfig is the structure providing specific configuration for the
+// policy deciding the vCPUs and memory of a sandbox.
+type SizingConfig struct {
+	// Policy is the name of the sizing policy, StaticSizingPolicy when
+	// empty.
+	Policy string
+
+	// OverheadMilliCPUs and OverheadMemoryMB are the resources used by
+	// the pod on top of the limits of its containers.
+	OverheadMilliCPUs uint32
+	OverheadMemoryMB  uint32
+
+	// BurstPercent is the headroom added on top of the limits of the
+	// containers, in percent of them.
+	BurstPercent uint32
+
+	// MemoryStepMB is the granularity the memory is hot added with, so
+	// that small limit changes do not hot add memory each time.
+	MemoryStepMB uint32
+
+	// VCPUsHysteresis is the number of vCPUs the target must drop by
+	// before vCPUs are hot removed.
+	VCPUsHysteresis uint32
+}
+
+// sandboxSize is the number of vCPUs and the memory, in bytes, of a
+// sandbox.
+type sandboxSize struct {
+	vCPUs  uint32
+	memory int64
+}
+
+// sizingRequest is the resources requested by the containers of a sandbox,
+// the memory in bytes.
+type sizingRequest struct {
+	milliCPUs uint32
+	memory    int64
+}
+
+// sizingPolicy decides the size of a sandbox, whenever its containers are
+// created, updated or deleted. The policies are stateless, the same sizes
+// giving the same target.
+type sizingPolicy interface {
+	// target returns the size of a sandbox running containers requesting
+	// request, base being the size of the sandbox without containers and
+	// vCPUs the vCPUs it was last sized with.
+	target(base sandboxSize, request sizingRequest, vCPUs uint32) sandboxSize
+}
+
+func newSizingPolicy(config SizingConfig) sizingPolicy {
+	switch config.Policy {
+	case OverheadSizingPolicy:
+		return overheadSizing{config: config}
+	default:
+		return staticSizing{}
+	}
+}
+
+// staticSizing adds the container limits to the base size.
+type staticSizing struct{}
+
+func (staticSizing) target(base sandboxSize, request sizingRequest, vCPUs uint32) sandboxSize {
+	return sandboxSize{
+		vCPUs:  base.vCPUs + utils.CalculateVCpusFromMilliCpus(request.milliCPUs),
+		memory: base.memory + request.memory,
+	}
+}
+
+// overheadSizing adds the burst headroom and the pod overhead to the
+// container limits. The memory is rounded up to its step, and the vCPUs are
+// only removed once the target dropped by more than the hysteresis.
+type overheadSizing struct {
+	config SizingConfig
+}
+
+func (p overheadSizing) target(base sandboxSize, request sizingRequest, vCPUs uint32) sandboxSize {
+	request.milliCPUs += request.milliCPUs * p.config.BurstPercent / 100
+	request.memory += request.memory * int64(p.config.BurstPercent) / 100
+
+	request.milliCPUs += p.config.OverheadMilliCPUs
+	request.memory += int64(p.config.OverheadMemoryMB) << utils.MibToBytesShift
+
+	size := staticSizing{}.target(base, request, vCPUs)
+
+	if step := int64(p.config.MemoryStepMB) << utils.MibToBytesShift; step > 0 {
+		size.memory = (size.memory + step - 1) / step * step
+	}
+
+	if size.vCPUs < vCPUs && vCPUs-size.vCPUs <= p.config.VCPUsHysteresis {
+		size.vCPUs = vCPUs
+	}
+
+	return size
+}
+
+// getSizingPolicy returns the sizing policy of the sandbox, creating it on
+// first use.
+func (s *Sandbox) getSizingPolicy() sizingPolicy {
+	s.sizingOnce.Do(func() {
+		s.sizing = newSizingPolicy(s.config.SizingConfig)
+	})
+
+	return s.sizing
+}
+
+// targetSize returns the size the sizing policy decides for the sandbox,
+// which the autoscale controller, when it runs, sizes the sandbox within.
+// It does not change the sandbox, the vCPUs it was sized with are only
+// recorded once resized.
+func (s *Sandbox) targetSize() sandboxSize {
+	hConfig := s.hypervisor.hypervisorConfig()
+
+	base := sandboxSize{
+		vCPUs:  hConfig.NumVCPUs,
+		memory: int64(hConfig.MemorySize) << utils.MibToBytesShift,
+	}
+
+	request := sizingRequest{
+		milliCPUs: s.calculateSandboxMilliCPUs(),
+		memory:    s.calculateSandboxMemory(),
+	}
+
+	return s.getSizingPolicy().target(base, request, s.sizedVCPUs)
+}
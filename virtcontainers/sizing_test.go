@@ -0,0 +1,103 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMiB = int64(1) << 20
+
+func TestStaticSizing(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := newSizingPolicy(SizingConfig{})
+	assert.Equal(staticSizing{}, policy)
+
+	base := sandboxSize{vCPUs: 1, memory: 2048 * testMiB}
+	assert.Equal(base, policy.target(base, sizingRequest{}, 0))
+	assert.Equal(sandboxSize{vCPUs: 3, memory: 2560 * testMiB},
+		policy.target(base, sizingRequest{milliCPUs: 1500, memory: 512 * testMiB}, 5))
+}
+
+func TestOverheadSizing(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := newSizingPolicy(SizingConfig{
+		Policy:            OverheadSizingPolicy,
+		OverheadMilliCPUs: 250,
+		OverheadMemoryMB:  100,
+		BurstPercent:      50,
+		MemoryStepMB:      256,
+		VCPUsHysteresis:   1,
+	})
+
+	base := sandboxSize{vCPUs: 1, memory: 2048 * testMiB}
+
+	// 1000 + 50% + 250 milli CPUs, 200 + 50% + 100 MiB rounded up to
+	// the memory step.
+	assert.Equal(sandboxSize{vCPUs: 3, memory: 2560 * testMiB},
+		policy.target(base, sizingRequest{milliCPUs: 1000, memory: 200 * testMiB}, 0))
+
+	assert.Equal(sandboxSize{vCPUs: 5, memory: 2304 * testMiB},
+		policy.target(base, sizingRequest{milliCPUs: 2500, memory: 100 * testMiB}, 3))
+
+	// Dropping by one vCPU is within the hysteresis.
+	assert.Equal(uint32(5), policy.target(base, sizingRequest{milliCPUs: 1500}, 5).vCPUs)
+
+	// The target does not depend on the previous ones.
+	assert.Equal(uint32(4), policy.target(base, sizingRequest{milliCPUs: 1500}, 0).vCPUs)
+
+	// Dropping by more removes the vCPUs.
+	assert.Equal(uint32(2), policy.target(base, sizingRequest{}, 5).vCPUs)
+}
+
+func TestSandboxTargetSize(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := 512 * testMiB
+	quota := int64(150000)
+	period := uint64(100000)
+
+	s := &Sandbox{
+		config: &SandboxConfig{
+			Containers: []ContainerConfig{
+				{
+					Resources: specs.LinuxResources{
+						Memory: &specs.LinuxMemory{Limit: &limit},
+						CPU:    &specs.LinuxCPU{Quota: &quota, Period: &period},
+					},
+				},
+			},
+		},
+		hypervisor: &mockHypervisor{},
+	}
+
+	base := s.hypervisor.hypervisorConfig()
+	assert.Equal(sandboxSize{
+		vCPUs:  base.NumVCPUs + 2,
+		memory: int64(base.MemorySize)*testMiB + limit,
+	}, s.targetSize())
+	assert.Equal(uint32(1500), s.calculateSandboxMilliCPUs())
+	assert.Equal(uint32(2), s.calculateSandboxCPUs())
+
+	// The policy is created once.
+	s.config.SizingConfig = SizingConfig{Policy: OverheadSizingPolicy, OverheadMemoryMB: 128, VCPUsHysteresis: 1}
+	assert.Equal(int64(base.MemorySize)*testMiB+limit, s.targetSize().memory)
+
+	s = &Sandbox{config: s.config, hypervisor: s.hypervisor}
+	assert.Equal(int64(base.MemorySize)*testMiB+limit+128*testMiB, s.targetSize().memory)
+
+	// The hysteresis applies to the vCPUs the sandbox was sized with.
+	s.sizedVCPUs = base.NumVCPUs + 3
+	assert.Equal(base.NumVCPUs+3, s.targetSize().vCPUs)
+	assert.Equal(base.NumVCPUs+3, s.targetSize().vCPUs)
+	s.sizedVCPUs = base.NumVCPUs + 4
+	assert.Equal(base.NumVCPUs+2, s.targetSize().vCPUs)
+}
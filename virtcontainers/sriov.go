@@ -0,0 +1,351 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// VFLinkState is the link state of an SR-IOV virtual function, as seen from
+// the guest.
+type VFLinkState string
+
+const (
+	// VFLinkStateAuto makes the link state of the virtual function
+	// follow the one of its physical function.
+	VFLinkStateAuto VFLinkState = "auto"
+
+	// VFLinkStateEnable keeps the link of the virtual function up.
+	VFLinkStateEnable VFLinkState = "enable"
+
+	// VFLinkStateDisable keeps the link of the virtual function down.
+	VFLinkStateDisable VFLinkState = "disable"
+)
+
+var vfLinkStates = map[VFLinkState]uint32{
+	VFLinkStateAuto:    nl.IFLA_VF_LINK_STATE_AUTO,
+	VFLinkStateEnable:  nl.IFLA_VF_LINK_STATE_ENABLE,
+	VFLinkStateDisable: nl.IFLA_VF_LINK_STATE_DISABLE,
+}
+
+// SRIOVConfig gives the settings programmed on the physical function for
+// an SR-IOV virtual function passed through to the VM.
+type SRIOVConfig struct {
+	// VLAN is the VLAN ID the traffic of the virtual function is tagged
+	// with, 0 for none.
+	VLAN int
+
+	// Trust enables the trust mode of the virtual function, so that the
+	// guest can change its MAC address or enable the promiscuous mode.
+	Trust bool
+
+	// LinkState is the link state of the virtual function. It is left
+	// untouched when empty.
+	LinkState VFLinkState
+}
+
+// Valid checks the SR-IOV settings.
+func (c SRIOVConfig) Valid() error {
+	if c.VLAN < 0 || c.VLAN > 4095 {
+		return fmt.Errorf("Invalid VLAN ID %d", c.VLAN)
+	}
+
+	if _, ok := vfLinkStates[c.LinkState]; c.LinkState != "" && !ok {
+		return fmt.Errorf("Invalid VF link state %q", c.LinkState)
+	}
+
+	return nil
+}
+
+// ParseSRIOVInterfaces returns the settings of the SR-IOV virtual function
+// network interfaces configured by the operator, as interface=settings
+// entries, the settings being a comma separated list of vlan:ID,
+// trust:on|off and link_state:auto|enable|disable.
+func ParseSRIOVInterfaces(entries []string) (map[string]SRIOVConfig, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	interfaces := make(map[string]SRIOVConfig)
+	for _, entry := range entries {
+		fields := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("Invalid SR-IOV interface %q, expecting interface=settings", entry)
+		}
+
+		var sriov SRIOVConfig
+		for _, setting := range strings.Split(fields[1], ",") {
+			kv := strings.SplitN(strings.TrimSpace(setting), ":", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("Invalid SR-IOV setting %q of interface %s", setting, fields[0])
+			}
+
+			var err error
+			switch kv[0] {
+			case "vlan":
+				sriov.VLAN, err = strconv.Atoi(kv[1])
+			case "trust":
+				switch kv[1] {
+				case "on":
+					sriov.Trust = true
+				case "off":
+					sriov.Trust = false
+				default:
+					err = fmt.Errorf("expecting on or off")
+				}
+			case "link_state":
+				sriov.LinkState = VFLinkState(kv[1])
+			default:
+				err = fmt.Errorf("unknown setting")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid SR-IOV setting %q of interface %s: %v", setting, fields[0], err)
+			}
+		}
+
+		if err := sriov.Valid(); err != nil {
+			return nil, fmt.Errorf("Invalid SR-IOV interface %s: %v", fields[0], err)
+		}
+
+		interfaces[fields[0]] = sriov
+	}
+
+	return interfaces, nil
+}
+
+// SRIOVVF describes the SR-IOV virtual function behind a physical endpoint.
+type SRIOVVF struct {
+	SRIOVConfig
+
+	// PFName is the name of the network interface of the physical
+	// function.
+	PFName string
+
+	// Index is the index of the virtual function on its physical function.
+	Index int
+}
+
+// sriovVF returns the SR-IOV virtual function at the PCI address bdf, or
+// nil if the device is not a virtual function.
+func sriovVF(bdf string) (*SRIOVVF, error) {
+	physfn, err := os.Readlink(filepath.Join(sysPCIDevicesPath, bdf, "physfn"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pfBDF := filepath.Base(physfn)
+	pfPath := filepath.Join(sysPCIDevicesPath, pfBDF)
+
+	virtfns, err := filepath.Glob(filepath.Join(pfPath, "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for _, virtfn := range virtfns {
+		link, err := os.Readlink(virtfn)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.Base(link) != bdf {
+			continue
+		}
+
+		if index, err = strconv.Atoi(strings.TrimPrefix(filepath.Base(virtfn), "virtfn")); err != nil {
+			return nil, fmt.Errorf("Invalid virtual function %s: %v", virtfn, err)
+		}
+		break
+	}
+
+	if index < 0 {
+		return nil, fmt.Errorf("Could not find the virtual function %s on the physical function %s", bdf, pfBDF)
+	}
+
+	netdevs, err := ioutil.ReadDir(filepath.Join(pfPath, "net"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(netdevs) == 0 {
+		return nil, fmt.Errorf("Could not find the network interface of the physical function %s", pfBDF)
+	}
+
+	return &SRIOVVF{
+		PFName: netdevs[0].Name(),
+		Index:  index,
+	}, nil
+}
+
+// setup programs the virtual function on its physical function, so that it
+// keeps hardAddr as MAC address and gets the configured VLAN, trust mode and
+// link state once passed through. It must run in the host network namespace,
+// where the physical function lives.
+func (vf *SRIOVVF) setup(hardAddr string) error {
+	if err := vf.Valid(); err != nil {
+		return err
+	}
+
+	pf, err := netlink.LinkByName(vf.PFName)
+	if err != nil {
+		return fmt.Errorf("Could not find the physical function %s: %v", vf.PFName, err)
+	}
+
+	mac, err := net.ParseMAC(hardAddr)
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetVfHardwareAddr(pf, vf.Index, mac); err != nil {
+		return fmt.Errorf("Could not set MAC address %s of VF %d of %s: %v", hardAddr, vf.Index, vf.PFName, err)
+	}
+
+	if vf.VLAN != 0 {
+		if err := netlink.LinkSetVfVlan(pf, vf.Index, vf.VLAN); err != nil {
+			return fmt.Errorf("Could not set VLAN %d of VF %d of %s: %v", vf.VLAN, vf.Index, vf.PFName, err)
+		}
+	}
+
+	if vf.Trust {
+		if err := netlink.LinkSetVfTrust(pf, vf.Index, true); err != nil {
+			return fmt.Errorf("Could not trust VF %d of %s: %v", vf.Index, vf.PFName, err)
+		}
+	}
+
+	if vf.LinkState != "" {
+		if err := setVfLinkState(pf, vf.Index, vfLinkStates[vf.LinkState]); err != nil {
+			return fmt.Errorf("Could not set link state %s of VF %d of %s: %v", vf.LinkState, vf.Index, vf.PFName, err)
+		}
+	}
+
+	networkLogger().WithField("pf", vf.PFName).WithField("vf", vf.Index).Info("SR-IOV virtual function programmed")
+
+	return nil
+}
+
+// restore undoes the settings programmed by setup once the virtual function
+// is given back to the host. The MAC address is kept, it is the one the
+// virtual function had before being passed through.
+func (vf *SRIOVVF) restore() error {
+	pf, err := netlink.LinkByName(vf.PFName)
+	if err != nil {
+		return fmt.Errorf("Could not find the physical function %s: %v", vf.PFName, err)
+	}
+
+	if vf.VLAN != 0 {
+		if err := netlink.LinkSetVfVlan(pf, vf.Index, 0); err != nil {
+			return fmt.Errorf("Could not clear VLAN of VF %d of %s: %v", vf.Index, vf.PFName, err)
+		}
+	}
+
+	if vf.Trust {
+		if err := netlink.LinkSetVfTrust(pf, vf.Index, false); err != nil {
+			return fmt.Errorf("Could not untrust VF %d of %s: %v", vf.Index, vf.PFName, err)
+		}
+	}
+
+	if vf.LinkState != "" && vf.LinkState != VFLinkStateAuto {
+		if err := setVfLinkState(pf, vf.Index, nl.IFLA_VF_LINK_STATE_AUTO); err != nil {
+			return fmt.Errorf("Could not reset link state of VF %d of %s: %v", vf.Index, vf.PFName, err)
+		}
+	}
+
+	return nil
+}
+
+// setVfLinkState sets the link state of the virtual function vf of pf, which
+// the netlink package does not provide.
+// Equivalent to: `ip link set $pf vf $vf state $state`
+func setVfLinkState(pf netlink.Link, vf int, state uint32) error {
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(pf.Attrs().Index)
+	req.AddData(msg)
+
+	data := nl.NewRtAttr(nl.IFLA_VFINFO_LIST, nil)
+	info := nl.NewRtAttrChild(data, nl.IFLA_VF_INFO, nil)
+	vfmsg := nl.VfLinkState{
+		Vf:        uint32(vf),
+		LinkState: state,
+	}
+	nl.NewRtAttrChild(info, nl.IFLA_VF_LINK_STATE, vfmsg.Serialize())
+	req.AddData(data)
+
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// setupEndpointVF programs the physical function of endpoint, if it is an
+// SR-IOV virtual function, with its settings from sriov. It must run in the
+// host network namespace, before the endpoint is attached.
+func setupEndpointVF(endpoint Endpoint, sriov map[string]SRIOVConfig) error {
+	physical, ok := endpoint.(*PhysicalEndpoint)
+	if !ok || physical.VF == nil {
+		return nil
+	}
+
+	physical.VF.SRIOVConfig = sriov[physical.Name()]
+
+	return physical.VF.setup(physical.HardAddr)
+}
+
+// restoreEndpointVF undoes setupEndpointVF, when the endpoint could not be
+// attached.
+func restoreEndpointVF(endpoint Endpoint) {
+	physical, ok := endpoint.(*PhysicalEndpoint)
+	if !ok || physical.VF == nil {
+		return
+	}
+
+	if err := physical.VF.restore(); err != nil {
+		networkLogger().WithError(err).WithField("pf", physical.VF.PFName).Warn("Could not restore the SR-IOV virtual function")
+	}
+}
+
+func saveSRIOVVF(vf *SRIOVVF) *persistapi.SRIOVVF {
+	if vf == nil {
+		return nil
+	}
+
+	return &persistapi.SRIOVVF{
+		PFName:    vf.PFName,
+		Index:     vf.Index,
+		VLAN:      vf.VLAN,
+		Trust:     vf.Trust,
+		LinkState: string(vf.LinkState),
+	}
+}
+
+func loadSRIOVVF(vf *persistapi.SRIOVVF) *SRIOVVF {
+	if vf == nil {
+		return nil
+	}
+
+	return &SRIOVVF{
+		SRIOVConfig: SRIOVConfig{
+			VLAN:      vf.VLAN,
+			Trust:     vf.Trust,
+			LinkState: VFLinkState(vf.LinkState),
+		},
+		PFName: vf.PFName,
+		Index:  vf.Index,
+	}
+}
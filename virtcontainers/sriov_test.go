@@ -0,0 +1,116 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSRIOVConfigValid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(SRIOVConfig{}.Valid())
+	assert.NoError(SRIOVConfig{VLAN: 4095, Trust: true, LinkState: VFLinkStateDisable}.Valid())
+
+	assert.Error(SRIOVConfig{VLAN: -1}.Valid())
+	assert.Error(SRIOVConfig{VLAN: 4096}.Valid())
+	assert.Error(SRIOVConfig{LinkState: "up"}.Valid())
+}
+
+func TestParseSRIOVInterfaces(t *testing.T) {
+	assert := assert.New(t)
+
+	interfaces, err := ParseSRIOVInterfaces(nil)
+	assert.NoError(err)
+	assert.Empty(interfaces)
+
+	interfaces, err = ParseSRIOVInterfaces([]string{"eth1=vlan:100,trust:on", " eth2=link_state:enable"})
+	assert.NoError(err)
+	assert.Equal(map[string]SRIOVConfig{
+		"eth1": {VLAN: 100, Trust: true},
+		"eth2": {LinkState: VFLinkStateEnable},
+	}, interfaces)
+
+	for _, value := range []string{"eth1", "=vlan:1", "eth1=vlan", "eth1=vlan:a", "eth1=vlan:4096",
+		"eth1=trust:yes", "eth1=link_state:up", "eth1=mtu:1500"} {
+		_, err = ParseSRIOVInterfaces([]string{value})
+		assert.Error(err, "entry %q", value)
+	}
+}
+
+func TestSRIOVVF(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sriov")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPCI := sysPCIDevicesPath
+	defer func() {
+		sysPCIDevicesPath = savedPCI
+	}()
+	sysPCIDevicesPath = dir
+
+	pf := filepath.Join(dir, "0000:3b:00.0")
+	vf := filepath.Join(dir, "0000:3b:02.1")
+	nic := filepath.Join(dir, "0000:3c:00.0")
+	for _, d := range []string{filepath.Join(pf, "net", "ens1f0"), vf, nic} {
+		assert.NoError(os.MkdirAll(d, 0755))
+	}
+
+	assert.NoError(os.Symlink("../0000:3b:00.0", filepath.Join(vf, "physfn")))
+	assert.NoError(os.Symlink("../0000:3b:02.0", filepath.Join(pf, "virtfn0")))
+	assert.NoError(os.Symlink("../0000:3b:02.1", filepath.Join(pf, "virtfn1")))
+
+	// not a virtual function
+	res, err := sriovVF("0000:3c:00.0")
+	assert.NoError(err)
+	assert.Nil(res)
+
+	res, err = sriovVF("0000:3b:02.1")
+	assert.NoError(err)
+	assert.Equal(&SRIOVVF{PFName: "ens1f0", Index: 1}, res)
+
+	// the virtual function is not listed by its physical function
+	assert.NoError(os.Remove(filepath.Join(pf, "virtfn1")))
+	_, err = sriovVF("0000:3b:02.1")
+	assert.Error(err)
+}
+
+func TestSetupEndpointVF(t *testing.T) {
+	assert := assert.New(t)
+
+	// nothing to program for the other endpoints
+	assert.NoError(setupEndpointVF(&VethEndpoint{}, nil))
+	assert.NoError(setupEndpointVF(&PhysicalEndpoint{IfaceName: "eth0"}, nil))
+
+	endpoint := &PhysicalEndpoint{
+		IfaceName: "eth1",
+		VF:        &SRIOVVF{PFName: "ens1f0", Index: 1},
+	}
+	err := setupEndpointVF(endpoint, map[string]SRIOVConfig{"eth1": {VLAN: 4096}})
+	assert.Error(err)
+	assert.Equal(4096, endpoint.VF.VLAN)
+}
+
+func TestSaveLoadSRIOVVF(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(saveSRIOVVF(nil))
+	assert.Nil(loadSRIOVVF(nil))
+
+	vf := &SRIOVVF{
+		SRIOVConfig: SRIOVConfig{VLAN: 100, Trust: true, LinkState: VFLinkStateEnable},
+		PFName:      "ens1f0",
+		Index:       3,
+	}
+	assert.Equal(vf, loadSRIOVVF(saveSRIOVVF(vf)))
+}
@@ -63,17 +63,23 @@ const SandboxPathSuffix = "sbs"
 // VMPathSuffix is the suffix used for guest VMs.
 const VMPathSuffix = "vm"
 
+// DefaultConfigRootPath is the default root of the configuration storage.
+var DefaultConfigRootPath = filepath.Join("/var/lib", StoragePathSuffix)
+
+// DefaultRunRootPath is the default root of the runtime storage.
+var DefaultRunRootPath = filepath.Join("/run", StoragePathSuffix)
+
 // ConfigStoragePath is the sandbox configuration directory.
 // It will contain one config.json file for each created sandbox.
-var ConfigStoragePath = filepath.Join("/var/lib", StoragePathSuffix, SandboxPathSuffix)
+var ConfigStoragePath = filepath.Join(DefaultConfigRootPath, SandboxPathSuffix)
 
 // RunStoragePath is the sandbox runtime directory.
 // It will contain one state.json and one lock file for each created sandbox.
-var RunStoragePath = filepath.Join("/run", StoragePathSuffix, SandboxPathSuffix)
+var RunStoragePath = filepath.Join(DefaultRunRootPath, SandboxPathSuffix)
 
 // RunVMStoragePath is the vm directory.
 // It will contain all guest vm sockets and shared mountpoints.
-var RunVMStoragePath = filepath.Join("/run", StoragePathSuffix, VMPathSuffix)
+var RunVMStoragePath = filepath.Join(DefaultRunRootPath, VMPathSuffix)
 
 func itemToFile(item Item) (string, error) {
 	switch item {
@@ -189,7 +195,7 @@ func (f *filesystem) new(ctx context.Context, path string, host string) error {
 
 func (f *filesystem) delete() error {
 	f.logger().WithField("path", f.path).Debugf("Deleting files")
-	return os.RemoveAll(f.path)
+	return RemoveSandboxDir(f.path)
 }
 
 func (f *filesystem) load(item Item, data interface{}) error {
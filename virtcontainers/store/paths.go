@@ -0,0 +1,221 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxSandboxIDLen is the length of the longest sandbox IDs, as
+	// generated by the container managers.
+	maxSandboxIDLen = 64
+
+	// maxSocketNameLen is the length of the longest socket names created
+	// in the VM directories.
+	maxSocketNameLen = 16
+)
+
+// ValidateRunRootPath checks that the sockets created in the VM directories
+// fit within the unix socket path limit when the runtime storage is rooted
+// at root.
+func ValidateRunRootPath(root string) error {
+	if !filepath.IsAbs(root) {
+		return fmt.Errorf("Run root path %q must be absolute", root)
+	}
+
+	socketPathLen := len(filepath.Join(root, VMPathSuffix)) + 1 + maxSandboxIDLen + 1 + maxSocketNameLen
+	if socketPathLen > utils.MaxSocketPathLen {
+		return fmt.Errorf("Run root path %q is too long: socket paths would be up to %d bytes, the limit is %d",
+			root, socketPathLen, utils.MaxSocketPathLen)
+	}
+
+	return nil
+}
+
+// migratedDirs maps the storage directories the sandboxes were migrated to,
+// to the directories they were migrated from.
+var migratedDirs = make(map[string]string)
+
+// SetRootPaths relocates the configuration storage under configRoot and the
+// runtime storage under runRoot. An empty root keeps the current location.
+//
+// The sandboxes found at the previous locations are migrated to the new
+// ones, so that the sandboxes created before the relocation can still be
+// managed while their processes keep using the previous paths.
+func SetRootPaths(configRoot, runRoot string) error {
+	if configRoot != "" && !filepath.IsAbs(configRoot) {
+		return fmt.Errorf("Config root path %q must be absolute", configRoot)
+	}
+
+	if runRoot != "" {
+		if err := ValidateRunRootPath(runRoot); err != nil {
+			return err
+		}
+	}
+
+	moves := make(map[string]string)
+
+	if configRoot != "" {
+		path := filepath.Join(configRoot, SandboxPathSuffix)
+		moves[ConfigStoragePath] = path
+		ConfigStoragePath = path
+	}
+
+	if runRoot != "" {
+		path := filepath.Join(runRoot, SandboxPathSuffix)
+		moves[RunStoragePath] = path
+		RunStoragePath = path
+
+		path = filepath.Join(runRoot, VMPathSuffix)
+		moves[RunVMStoragePath] = path
+		RunVMStoragePath = path
+	}
+
+	for oldDir, newDir := range moves {
+		if filepath.Clean(oldDir) == filepath.Clean(newDir) {
+			continue
+		}
+
+		migratedDirs[filepath.Clean(newDir)] = filepath.Clean(oldDir)
+
+		if err := migrateSandboxDirs(oldDir, newDir); err != nil {
+			return fmt.Errorf("Could not migrate %s to %s: %v", oldDir, newDir, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateSandboxDirs migrates each directory of oldDir into newDir, unless
+// newDir already has an entry with the same name. The links left by a
+// previous migration are not directories, they are skipped.
+func migrateSandboxDirs(oldDir, newDir string) error {
+	entries, err := ioutil.ReadDir(oldDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if err := MigrateDir(filepath.Join(oldDir, entry.Name()), filepath.Join(newDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateDir moves the directory oldPath to newPath, unless newPath already
+// exists, and leaves a link to it at oldPath for the processes still using
+// the previous path. When both paths are on different filesystems, the
+// directory stays in place and newPath is a link to it instead.
+func MigrateDir(oldPath, newPath string) error {
+	if _, err := os.Lstat(newPath); err == nil {
+		return nil
+	}
+
+	fi, err := os.Lstat(oldPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !fi.IsDir() {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), DirMode); err != nil {
+		return err
+	}
+
+	logger := storeLog.WithFields(logrus.Fields{
+		"old-path": oldPath,
+		"new-path": newPath,
+	})
+
+	err = os.Rename(oldPath, newPath)
+	if linkErr, ok := err.(*os.LinkError); ok && linkErr.Err == syscall.EXDEV {
+		if err := os.Symlink(oldPath, newPath); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+		logger.Info("Linked directory to its new location")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Symlink(newPath, oldPath); err != nil {
+		return err
+	}
+
+	logger.Info("Moved directory to its new location")
+
+	return nil
+}
+
+// RemoveSandboxDir removes the sandbox directory path. If the sandbox was
+// migrated, the directory or link left at its previous location is removed
+// as well, but the links are never followed out of the storage directories.
+func RemoveSandboxDir(path string) error {
+	path = filepath.Clean(path)
+
+	if oldDir, ok := migratedDirs[filepath.Dir(path)]; ok {
+		if err := removeMigratedDir(path, filepath.Join(oldDir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(path)
+}
+
+// removeMigratedDir removes the previous location oldPath of the sandbox
+// directory path: the link to path left when it was moved, or the directory
+// path links to when it was kept in place.
+func removeMigratedDir(path, oldPath string) error {
+	fi, err := os.Lstat(oldPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	linked := func(link, target string) bool {
+		dest, err := os.Readlink(link)
+		return err == nil && filepath.Clean(dest) == target
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		if linked(oldPath, path) {
+			return os.Remove(oldPath)
+		}
+	case fi.IsDir():
+		if linked(path, oldPath) {
+			return os.RemoveAll(oldPath)
+		}
+	}
+
+	return nil
+}
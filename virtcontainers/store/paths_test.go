@@ -0,0 +1,139 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRunRootPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateRunRootPath(DefaultRunRootPath))
+	assert.NoError(ValidateRunRootPath("/" + strings.Repeat("a", 21)))
+
+	assert.Error(ValidateRunRootPath("run/vc"))
+	assert.Error(ValidateRunRootPath("/" + strings.Repeat("a", 22)))
+}
+
+func TestSetRootPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	savedConfig, savedRun, savedVM := ConfigStoragePath, RunStoragePath, RunVMStoragePath
+	savedMigrated := migratedDirs
+	defer func() {
+		ConfigStoragePath, RunStoragePath, RunVMStoragePath = savedConfig, savedRun, savedVM
+		migratedDirs = savedMigrated
+	}()
+	migratedDirs = make(map[string]string)
+
+	dir, err := ioutil.TempDir("", "store-paths")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ConfigStoragePath = filepath.Join(dir, "old-lib", SandboxPathSuffix)
+	RunStoragePath = filepath.Join(dir, "old-run", SandboxPathSuffix)
+	RunVMStoragePath = filepath.Join(dir, "old-run", VMPathSuffix)
+
+	// a sandbox created before the relocation
+	for _, path := range []string{ConfigStoragePath, RunStoragePath, RunVMStoragePath} {
+		assert.NoError(os.MkdirAll(filepath.Join(path, "sb1"), DirMode))
+	}
+	assert.NoError(ioutil.WriteFile(filepath.Join(RunStoragePath, "sb1", StateFile), []byte("{}"), 0640))
+
+	assert.Error(SetRootPaths("lib", ""))
+	assert.Error(SetRootPaths("", "run"))
+
+	// nothing changes
+	assert.NoError(SetRootPaths("", ""))
+	assert.Equal(filepath.Join(dir, "old-run", SandboxPathSuffix), RunStoragePath)
+
+	newRun, err := ioutil.TempDir("", "r")
+	assert.NoError(err)
+	defer os.RemoveAll(newRun)
+	newLib := filepath.Join(dir, "new-lib")
+
+	oldPaths := []string{ConfigStoragePath, RunStoragePath, RunVMStoragePath}
+
+	assert.NoError(SetRootPaths(newLib, newRun))
+	assert.Equal(filepath.Join(newLib, SandboxPathSuffix), ConfigStoragePath)
+	assert.Equal(filepath.Join(newRun, SandboxPathSuffix), RunStoragePath)
+	assert.Equal(filepath.Join(newRun, VMPathSuffix), RunVMStoragePath)
+
+	// the sandbox is moved, its previous location links to it
+	for i, path := range []string{ConfigStoragePath, RunStoragePath, RunVMStoragePath} {
+		fi, err := os.Lstat(filepath.Join(path, "sb1"))
+		assert.NoError(err)
+		assert.True(fi.IsDir())
+
+		target, err := os.Readlink(filepath.Join(oldPaths[i], "sb1"))
+		assert.NoError(err)
+		assert.Equal(filepath.Join(path, "sb1"), target)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(oldPaths[1], "sb1", StateFile))
+	assert.NoError(err)
+	assert.Equal("{}", string(data))
+
+	// relocating again is a no-op
+	assert.NoError(SetRootPaths(newLib, newRun))
+
+	assert.NoError(RemoveSandboxDir(filepath.Join(RunStoragePath, "sb1")))
+	for _, path := range []string{RunStoragePath, oldPaths[1]} {
+		_, err = os.Lstat(filepath.Join(path, "sb1"))
+		assert.True(os.IsNotExist(err))
+	}
+}
+
+func TestRemoveSandboxDir(t *testing.T) {
+	assert := assert.New(t)
+
+	savedMigrated := migratedDirs
+	defer func() {
+		migratedDirs = savedMigrated
+	}()
+
+	dir, err := ioutil.TempDir("", "store-paths")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	migratedDirs = map[string]string{newDir: oldDir}
+
+	// a sandbox kept in place, on another filesystem
+	assert.NoError(os.MkdirAll(filepath.Join(oldDir, "sb1", "sub"), DirMode))
+	assert.NoError(os.MkdirAll(newDir, DirMode))
+	assert.NoError(os.Symlink(filepath.Join(oldDir, "sb1"), filepath.Join(newDir, "sb1")))
+
+	assert.NoError(RemoveSandboxDir(filepath.Join(newDir, "sb1")))
+	for _, path := range []string{oldDir, newDir} {
+		_, err = os.Lstat(filepath.Join(path, "sb1"))
+		assert.True(os.IsNotExist(err))
+	}
+
+	// the links out of the storage directories are not followed
+	outside := filepath.Join(dir, "outside")
+	assert.NoError(os.MkdirAll(outside, DirMode))
+	assert.NoError(os.Symlink(outside, filepath.Join(newDir, "sb2")))
+	assert.NoError(os.MkdirAll(filepath.Join(oldDir, "sb2"), DirMode))
+
+	assert.NoError(RemoveSandboxDir(filepath.Join(newDir, "sb2")))
+	_, err = os.Lstat(filepath.Join(newDir, "sb2"))
+	assert.True(os.IsNotExist(err))
+	for _, path := range []string{outside, filepath.Join(oldDir, "sb2")} {
+		_, err = os.Stat(path)
+		assert.NoError(err)
+	}
+
+	assert.NoError(RemoveSandboxDir(filepath.Join(newDir, "missing")))
+}
@@ -196,6 +196,7 @@ func (endpoint *TapEndpoint) save() persistapi.NetworkEndpoint {
 		Type: string(endpoint.Type()),
 		Tap: &persistapi.TapEndpoint{
 			TapInterface: *tapif,
+			PCIAddr:      endpoint.PCIAddr,
 		},
 	}
 }
@@ -205,5 +206,6 @@ func (endpoint *TapEndpoint) load(s persistapi.NetworkEndpoint) {
 	if s.Tap != nil {
 		tapif := loadTapIf(&s.Tap.TapInterface)
 		endpoint.TapInterface = *tapif
+		endpoint.PCIAddr = s.Tap.PCIAddr
 	}
 }
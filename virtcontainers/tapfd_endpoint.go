@@ -0,0 +1,250 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/uuid"
+	"golang.org/x/sys/unix"
+)
+
+// tapFdTimeout is the time the TAP device plugin is given to send the file
+// descriptors.
+var tapFdTimeout = 5 * time.Second
+
+// TapFdEndpoint is an existing TAP device whose file descriptors are opened
+// by a device plugin and passed to the runtime over a unix socket, so that
+// the runtime neither creates nor configures the TAP device, and does not
+// need CAP_NET_ADMIN for it.
+//
+// The runtime connects to the socket and writes the name of the network
+// interface followed by a newline. The device plugin replies with a single
+// message carrying the file descriptors of the TAP device in SCM_RIGHTS
+// ancillary data, one per queue, opened with IFF_VNET_HDR. When it passes no
+// file descriptor, the payload of the message is the error message.
+type TapFdEndpoint struct {
+	TapInterface       TapInterface
+	EndpointProperties NetworkInfo
+	PCIAddr            string
+
+	// SocketPath is the unix socket of the device plugin.
+	SocketPath string
+}
+
+func createTapFdEndpoint(netInfo NetworkInfo, socketPath string) (*TapFdEndpoint, error) {
+	if netInfo.Iface.Type != "tap" {
+		return nil, fmt.Errorf("Network interface %s is not a TAP device but a %s", netInfo.Iface.Name, netInfo.Iface.Type)
+	}
+
+	return &TapFdEndpoint{
+		TapInterface: TapInterface{
+			ID:   uuid.Generate().String(),
+			Name: netInfo.Iface.Name,
+			TAPIface: NetworkInterface{
+				Name:     netInfo.Iface.Name,
+				HardAddr: netInfo.Iface.HardwareAddr.String(),
+			},
+		},
+		SocketPath: socketPath,
+	}, nil
+}
+
+// Properties returns the properties of the TAP device.
+func (endpoint *TapFdEndpoint) Properties() NetworkInfo {
+	return endpoint.EndpointProperties
+}
+
+// Name returns the name of the TAP device.
+func (endpoint *TapFdEndpoint) Name() string {
+	return endpoint.TapInterface.Name
+}
+
+// HardwareAddr returns the MAC address of the TAP device, which the guest
+// interface takes over.
+func (endpoint *TapFdEndpoint) HardwareAddr() string {
+	return endpoint.TapInterface.TAPIface.HardAddr
+}
+
+// Type identifies the endpoint as a TAP device passed by file descriptors.
+func (endpoint *TapFdEndpoint) Type() EndpointType {
+	return TapFdEndpointType
+}
+
+// PciAddr returns the PCI address of the endpoint.
+func (endpoint *TapFdEndpoint) PciAddr() string {
+	return endpoint.PCIAddr
+}
+
+// SetPciAddr sets the PCI address of the endpoint.
+func (endpoint *TapFdEndpoint) SetPciAddr(pciAddr string) {
+	endpoint.PCIAddr = pciAddr
+}
+
+// NetworkPair returns the network pair of the endpoint.
+func (endpoint *TapFdEndpoint) NetworkPair() *NetworkInterfacePair {
+	return nil
+}
+
+// SetProperties sets the properties of the endpoint.
+func (endpoint *TapFdEndpoint) SetProperties(properties NetworkInfo) {
+	endpoint.EndpointProperties = properties
+}
+
+// openFds receives the file descriptors of the TAP device from the device
+// plugin, and opens the vhost ones.
+func (endpoint *TapFdEndpoint) openFds(h hypervisor) error {
+	fds, err := receiveTapFds(endpoint.SocketPath, endpoint.Name())
+	if err != nil {
+		return err
+	}
+	endpoint.TapInterface.VMFds = fds
+
+	if !h.hypervisorConfig().DisableVhostNet {
+		vhostFds, err := createVhostFds(len(fds))
+		if err != nil {
+			endpoint.closeFds()
+			return fmt.Errorf("Could not setup vhost fds %s : %s", endpoint.Name(), err)
+		}
+		endpoint.TapInterface.VhostFds = vhostFds
+	}
+
+	return nil
+}
+
+func (endpoint *TapFdEndpoint) closeFds() {
+	for _, f := range append(endpoint.TapInterface.VMFds, endpoint.TapInterface.VhostFds...) {
+		f.Close()
+	}
+	endpoint.TapInterface.VMFds = nil
+	endpoint.TapInterface.VhostFds = nil
+}
+
+// Attach for the TAP fd endpoint passes the file descriptors of the TAP
+// device to the hypervisor.
+func (endpoint *TapFdEndpoint) Attach(h hypervisor) error {
+	if err := endpoint.openFds(h); err != nil {
+		return err
+	}
+
+	return h.addDevice(endpoint, netDev)
+}
+
+// Detach for the TAP fd endpoint closes the file descriptors of the TAP
+// device, which belongs to the device plugin.
+func (endpoint *TapFdEndpoint) Detach(netNsCreated bool, netNsPath string) error {
+	endpoint.closeFds()
+	return nil
+}
+
+// HotAttach for the TAP fd endpoint hot plugs the TAP device, passing its
+// file descriptors to the hypervisor.
+func (endpoint *TapFdEndpoint) HotAttach(h hypervisor) error {
+	networkLogger().Info("Hot attaching tap fd endpoint")
+	if err := endpoint.openFds(h); err != nil {
+		return err
+	}
+
+	if _, err := h.hotplugAddDevice(endpoint, netDev); err != nil {
+		endpoint.closeFds()
+		networkLogger().WithError(err).Error("Error attach tap fd ep")
+		return err
+	}
+	return nil
+}
+
+// HotDetach for the TAP fd endpoint hot unplugs the TAP device.
+func (endpoint *TapFdEndpoint) HotDetach(h hypervisor, netNsCreated bool, netNsPath string) error {
+	networkLogger().Info("Hot detaching tap fd endpoint")
+	if _, err := h.hotplugRemoveDevice(endpoint, netDev); err != nil {
+		networkLogger().WithError(err).Error("Error detach tap fd ep")
+		return err
+	}
+
+	endpoint.closeFds()
+	return nil
+}
+
+// receiveTapFds receives the file descriptors of the TAP device name from the
+// device plugin listening on socketPath.
+func receiveTapFds(socketPath, name string) ([]*os.File, error) {
+	conn, err := net.DialTimeout("unix", socketPath, tapFdTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to the TAP device plugin %s: %v", socketPath, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(tapFdTimeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte(name + "\n")); err != nil {
+		return nil, fmt.Errorf("Could not request the fds of %s from %s: %v", name, socketPath, err)
+	}
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(maxNetworkQueues*4))
+	n, oobn, flags, _, err := conn.(*net.UnixConn).ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("Could not receive the fds of %s from %s: %v", name, socketPath, err)
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []*os.File
+	for i := range msgs {
+		rights, err := unix.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range rights {
+			fds = append(fds, os.NewFile(uintptr(fd), fmt.Sprintf("%s-queue%d", name, len(fds))))
+		}
+	}
+
+	if flags&unix.MSG_CTRUNC != 0 {
+		for _, f := range fds {
+			f.Close()
+		}
+		return nil, fmt.Errorf("Too many fds for %s from %s, at most %d queues are supported", name, socketPath, maxNetworkQueues)
+	}
+
+	if len(fds) == 0 {
+		return nil, fmt.Errorf("TAP device plugin %s passed no fd for %s: %s", socketPath, name, strings.TrimSpace(string(buf[:n])))
+	}
+
+	return fds, nil
+}
+
+func (endpoint *TapFdEndpoint) save() persistapi.NetworkEndpoint {
+	tapif := saveTapIf(&endpoint.TapInterface)
+
+	return persistapi.NetworkEndpoint{
+		Type: string(endpoint.Type()),
+		TapFd: &persistapi.TapFdEndpoint{
+			TapInterface: *tapif,
+			PCIAddr:      endpoint.PCIAddr,
+			SocketPath:   endpoint.SocketPath,
+		},
+	}
+}
+
+func (endpoint *TapFdEndpoint) load(s persistapi.NetworkEndpoint) {
+	if s.TapFd != nil {
+		tapif := loadTapIf(&s.TapFd.TapInterface)
+		endpoint.TapInterface = *tapif
+		endpoint.PCIAddr = s.TapFd.PCIAddr
+		endpoint.SocketPath = s.TapFd.SocketPath
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// serveTapFds serves a single request of a TAP device plugin on socketPath,
+// passing files or the error message when there are none.
+func serveTapFds(t *testing.T, socketPath string, files []*os.File, message string) <-chan string {
+	l, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+
+	requested := make(chan string, 1)
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			close(requested)
+			return
+		}
+		defer conn.Close()
+
+		name, _ := bufio.NewReader(conn).ReadString('\n')
+		requested <- name
+
+		var fds []int
+		for _, f := range files {
+			fds = append(fds, int(f.Fd()))
+		}
+		var oob []byte
+		if len(fds) > 0 {
+			oob = unix.UnixRights(fds...)
+		}
+		conn.(*net.UnixConn).WriteMsgUnix([]byte(message), oob, nil)
+	}()
+
+	return requested
+}
+
+func TestReceiveTapFds(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "tapfd")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var files []*os.File
+	for _, name := range []string{"queue0", "queue1"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		assert.NoError(err)
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	socketPath := filepath.Join(dir, "plugin.sock")
+	requested := serveTapFds(t, socketPath, files, "ok")
+
+	fds, err := receiveTapFds(socketPath, "net1")
+	assert.NoError(err)
+	assert.Equal("net1\n", <-requested)
+	assert.Len(fds, 2)
+
+	for i, f := range fds {
+		var expected, received unix.Stat_t
+		assert.NoError(unix.Fstat(int(files[i].Fd()), &expected))
+		assert.NoError(unix.Fstat(int(f.Fd()), &received))
+		assert.Equal(expected.Ino, received.Ino)
+		f.Close()
+	}
+
+	// the plugin reports an error
+	os.Remove(socketPath)
+	serveTapFds(t, socketPath, nil, "unknown interface net2")
+	_, err = receiveTapFds(socketPath, "net2")
+	assert.Error(err)
+	assert.Contains(err.Error(), "unknown interface net2")
+
+	// no plugin
+	os.Remove(socketPath)
+	_, err = receiveTapFds(socketPath, "net1")
+	assert.Error(err)
+}
+
+func TestCreateTapFdEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	macAddr := net.HardwareAddr{0x02, 0x00, 0xCA, 0xFE, 0x00, 0x04}
+	netInfo := NetworkInfo{}
+	netInfo.Iface.Name = "net1"
+	netInfo.Iface.Type = "tap"
+	netInfo.Iface.HardwareAddr = macAddr
+
+	endpoint, err := createTapFdEndpoint(netInfo, "/run/tuntap/net1.sock")
+	assert.NoError(err)
+	assert.Equal("net1", endpoint.Name())
+	assert.Equal(macAddr.String(), endpoint.HardwareAddr())
+	assert.Equal(TapFdEndpointType, endpoint.Type())
+	assert.Nil(endpoint.NetworkPair())
+
+	loaded := &TapFdEndpoint{}
+	loaded.load(endpoint.save())
+	assert.Equal(endpoint.TapInterface, loaded.TapInterface)
+	assert.Equal(endpoint.SocketPath, loaded.SocketPath)
+
+	netInfo.Iface.Type = "veth"
+	_, err = createTapFdEndpoint(netInfo, "/run/tuntap/net1.sock")
+	assert.Error(err)
+}
+
+func TestTapFdEndpointType(t *testing.T) {
+	testEndpointTypeSet(t, "tap-fd", TapFdEndpointType)
+
+	endpointType := TapFdEndpointType
+	testEndpointTypeString(t, &endpointType, string(TapFdEndpointType))
+}
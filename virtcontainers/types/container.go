@@ -14,6 +14,10 @@ type ContainerState struct {
 	// File system of the rootfs incase it is block device
 	Fstype string `json:"fstype"`
 
+	// ScratchDeviceID is the block device of the writable layer of a
+	// rootfs layered on a read-only image.
+	ScratchDeviceID string `json:"scratchDeviceID,omitempty"`
+
 	// CgroupPath is the cgroup hierarchy where sandbox's processes
 	// including the hypervisor are placed.
 	CgroupPath string `json:"cgroupPath,omitempty"`
@@ -0,0 +1,50 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package types
+
+import "time"
+
+// The components recorded in the provenance of a sandbox.
+const (
+	ProvenanceHypervisor     = "hypervisor"
+	ProvenanceKernel         = "kernel"
+	ProvenanceImage          = "image"
+	ProvenanceInitrd         = "initrd"
+	ProvenanceFirmware       = "firmware"
+	ProvenanceVirtioFSDaemon = "virtiofsd"
+)
+
+// ProvenanceComponent identifies a component a sandbox runs on.
+type ProvenanceComponent struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Version is the version reported by the executable components.
+	Version string `json:"version,omitempty"`
+
+	// Digest is the "sha256:" prefixed digest of the component file.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Provenance records the exact components a sandbox has been started
+// with, so that the sandboxes running on vulnerable components can be
+// found.
+type Provenance struct {
+	RuntimeVersion string                `json:"runtimeVersion"`
+	Components     []ProvenanceComponent `json:"components"`
+	RecordedAt     time.Time             `json:"recordedAt"`
+}
+
+// Component returns the component name of the provenance.
+func (p *Provenance) Component(name string) (ProvenanceComponent, bool) {
+	for _, c := range p.Components {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return ProvenanceComponent{}, false
+}
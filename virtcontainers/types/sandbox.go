@@ -29,23 +29,94 @@ const (
 	StateStopped StateString = "stopped"
 )
 
+// ShutdownReason describes why a sandbox has been stopped.
+type ShutdownReason string
+
+const (
+	// ShutdownReasonUser means the sandbox was stopped on user request.
+	ShutdownReasonUser ShutdownReason = "user"
+
+	// ShutdownReasonGuestPanic means the guest kernel panicked.
+	ShutdownReasonGuestPanic ShutdownReason = "guest-panic"
+
+	// ShutdownReasonHypervisorError means the hypervisor reported an
+	// internal error.
+	ShutdownReasonHypervisorError ShutdownReason = "hypervisor-error"
+
+	// ShutdownReasonHypervisorExited means the hypervisor process
+	// exited unexpectedly.
+	ShutdownReasonHypervisorExited ShutdownReason = "hypervisor-exited"
+
+	// ShutdownReasonHypervisorOOMKilled means the hypervisor process was
+	// killed by the host OOM killer.
+	ShutdownReasonHypervisorOOMKilled ShutdownReason = "hypervisor-oom-killed"
+
+	// ShutdownReasonAgentLost means the agent stopped answering.
+	ShutdownReasonAgentLost ShutdownReason = "agent-lost"
+
+	// ShutdownReasonMigrated means the sandbox has been live migrated to
+	// another host, where it keeps running.
+	ShutdownReasonMigrated ShutdownReason = "migrated"
+)
+
 // SandboxState is a sandbox state structure
 type SandboxState struct {
 	State StateString `json:"state"`
 
+	// ShutdownReason records why the sandbox has been stopped.
+	ShutdownReason ShutdownReason `json:"shutdownReason,omitempty"`
+
+	// ShutdownMessage details the shutdown reason, e.g. the error
+	// reported by the hypervisor.
+	ShutdownMessage string `json:"shutdownMessage,omitempty"`
+
 	// Index of the block device passed to hypervisor.
 	BlockIndex int `json:"blockIndex"`
 
+	// FreeBlockIndexes are the sorted block indexes lower than BlockIndex
+	// which have been released by unplugged block devices.
+	FreeBlockIndexes []int `json:"freeBlockIndexes,omitempty"`
+
 	// GuestMemoryBlockSizeMB is the size of memory block of guestos
 	GuestMemoryBlockSizeMB uint32 `json:"guestMemoryBlockSize"`
 
 	// GuestMemoryHotplugProbe determines whether guest kernel supports memory hotplug probe interface
 	GuestMemoryHotplugProbe bool `json:"guestMemoryHotplugProbe"`
 
+	// GuestBlkSerial determines whether the agent finds virtio-blk devices
+	// by their serial number
+	GuestBlkSerial bool `json:"guestBlkSerial,omitempty"`
+
+	// GuestLUKS determines whether the agent opens LUKS encrypted storages
+	GuestLUKS bool `json:"guestLUKS,omitempty"`
+
+	// GuestIntegrity determines whether the agent sets up dm-integrity
+	// targets on the storages
+	GuestIntegrity bool `json:"guestIntegrity,omitempty"`
+
+	// GuestVirtioPmem determines whether the agent finds virtio-pmem
+	// devices and storages
+	GuestVirtioPmem bool `json:"guestVirtioPmem,omitempty"`
+
+	// GuestWatchableBind determines whether the agent mirrors the
+	// watchable mounts into the guest
+	GuestWatchableBind bool `json:"guestWatchableBind,omitempty"`
+
 	// CgroupPath is the cgroup hierarchy where sandbox's processes
 	// including the hypervisor are placed.
 	CgroupPath string `json:"cgroupPath,omitempty"`
 
+	// HypervisorMemoryCap is the host memory limit, in bytes, applied to
+	// the hypervisor processes.
+	HypervisorMemoryCap int64 `json:"hypervisorMemoryCap,omitempty"`
+
+	// VSockPorts are the vsock ports of the guest services.
+	VSockPorts VSockPorts `json:"vsockPorts,omitempty"`
+
+	// Provenance records the components the sandbox has been started
+	// with.
+	Provenance *Provenance `json:"provenance,omitempty"`
+
 	// PersistVersion indicates current storage api version.
 	// It's also known as ABI version of kata-runtime.
 	// Note: it won't be written to disk
@@ -0,0 +1,121 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package types
+
+import (
+	"fmt"
+)
+
+const (
+	// VSockAgentService is the agent gRPC service.
+	VSockAgentService = "agent"
+
+	// VSockDebugConsoleService is the agent debug console.
+	VSockDebugConsoleService = "debug-console"
+
+	// VSockMetricsService is the guest metrics exporter.
+	VSockMetricsService = "metrics"
+)
+
+const (
+	// VSockAgentPort is the well-known port of the agent.
+	VSockAgentPort uint32 = 1024
+
+	// VSockDebugConsolePort is the well-known port of the debug console.
+	VSockDebugConsolePort uint32 = 1026
+
+	// VSockMetricsPort is the well-known port of the metrics exporter.
+	VSockMetricsPort uint32 = 1027
+
+	// vsockDynamicPortMin and vsockDynamicPortMax bound the ports
+	// allocated to services without a well-known port.
+	vsockDynamicPortMin uint32 = 10000
+	vsockDynamicPortMax uint32 = 10999
+)
+
+var vsockWellKnownPorts = map[string]uint32{
+	VSockAgentService:        VSockAgentPort,
+	VSockDebugConsoleService: VSockDebugConsolePort,
+	VSockMetricsService:      VSockMetricsPort,
+}
+
+// VSockPorts is the registry of the guest services of a sandbox reachable
+// over vsock, mapping each service to its port.
+type VSockPorts map[string]uint32
+
+// owner returns the service owning port, either because it has been
+// registered or because it is the well-known port of the service.
+func (p VSockPorts) owner(port uint32) (string, bool) {
+	for service, registered := range p {
+		if registered == port {
+			return service, true
+		}
+	}
+
+	for service, wellKnown := range vsockWellKnownPorts {
+		if wellKnown == port {
+			return service, true
+		}
+	}
+
+	return "", false
+}
+
+// Register reserves port for service. It fails if the service is already
+// registered with another port, or if the port belongs to another service.
+func (p *VSockPorts) Register(service string, port uint32) error {
+	if service == "" {
+		return fmt.Errorf("Missing vsock service name")
+	}
+
+	if port == 0 {
+		return fmt.Errorf("Invalid vsock port 0 for service %s", service)
+	}
+
+	if registered, ok := (*p)[service]; ok {
+		if registered != port {
+			return fmt.Errorf("vsock service %s already uses port %d", service, registered)
+		}
+		return nil
+	}
+
+	if owner, ok := p.owner(port); ok && owner != service {
+		return fmt.Errorf("vsock port %d is reserved for service %s", port, owner)
+	}
+
+	if *p == nil {
+		*p = make(VSockPorts)
+	}
+	(*p)[service] = port
+
+	return nil
+}
+
+// Allocate returns the port of service, registering it first if needed.
+// Services get their well-known port if they have one, or the first free
+// port of the dynamic range otherwise.
+func (p *VSockPorts) Allocate(service string) (uint32, error) {
+	if port, ok := (*p)[service]; ok {
+		return port, nil
+	}
+
+	if port, ok := vsockWellKnownPorts[service]; ok {
+		return port, p.Register(service, port)
+	}
+
+	for port := vsockDynamicPortMin; port <= vsockDynamicPortMax; port++ {
+		if _, used := p.owner(port); !used {
+			return port, p.Register(service, port)
+		}
+	}
+
+	return 0, fmt.Errorf("No vsock port left for service %s", service)
+}
+
+// Release frees the port of service.
+func (p VSockPorts) Release(service string) {
+	delete(p, service)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVSockPortsRegister(t *testing.T) {
+	assert := assert.New(t)
+
+	var ports VSockPorts
+
+	assert.Error(ports.Register("", 2000))
+	assert.Error(ports.Register("foo", 0))
+
+	assert.NoError(ports.Register(VSockAgentService, VSockAgentPort))
+	assert.NoError(ports.Register(VSockAgentService, VSockAgentPort))
+	assert.Equal(VSockPorts{VSockAgentService: VSockAgentPort}, ports)
+
+	// A service keeps its port
+	assert.Error(ports.Register(VSockAgentService, 2000))
+
+	// Registered and well-known ports are reserved
+	assert.Error(ports.Register("foo", VSockAgentPort))
+	assert.Error(ports.Register("foo", VSockMetricsPort))
+
+	assert.NoError(ports.Register("foo", 2000))
+	assert.Error(ports.Register("bar", 2000))
+}
+
+func TestVSockPortsAllocate(t *testing.T) {
+	assert := assert.New(t)
+
+	var ports VSockPorts
+
+	port, err := ports.Allocate(VSockMetricsService)
+	assert.NoError(err)
+	assert.Equal(VSockMetricsPort, port)
+
+	port, err = ports.Allocate("foo")
+	assert.NoError(err)
+	assert.Equal(vsockDynamicPortMin, port)
+
+	port, err = ports.Allocate("foo")
+	assert.NoError(err)
+	assert.Equal(vsockDynamicPortMin, port)
+
+	port, err = ports.Allocate("bar")
+	assert.NoError(err)
+	assert.Equal(vsockDynamicPortMin+1, port)
+
+	ports.Release("foo")
+	port, err = ports.Allocate("baz")
+	assert.NoError(err)
+	assert.Equal(vsockDynamicPortMin, port)
+
+	for p := vsockDynamicPortMin; p <= vsockDynamicPortMax; p++ {
+		ports[string(rune(p))] = p
+	}
+	_, err = ports.Allocate("full")
+	assert.Error(err)
+}
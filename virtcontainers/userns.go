@@ -0,0 +1,282 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/kata-containers/agent/protocols/grpc"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// maxIDMappings is the maximum number of lines of the uid_map and gid_map
+// files of a user namespace, since Linux 4.15.
+const maxIDMappings = 340
+
+// hasUserNamespace returns true if the container runs in its own user
+// namespace.
+func hasUserNamespace(spec *specs.Spec) bool {
+	if spec == nil || spec.Linux == nil {
+		return false
+	}
+
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkIDMappings(kind string, mappings []specs.LinuxIDMapping) error {
+	if len(mappings) == 0 {
+		return fmt.Errorf("The user namespace has no %s mappings", kind)
+	}
+
+	if len(mappings) > maxIDMappings {
+		return fmt.Errorf("The user namespace has %d %s mappings, the guest kernel supports at most %d", len(mappings), kind, maxIDMappings)
+	}
+
+	for i, m := range mappings {
+		if m.Size == 0 {
+			return fmt.Errorf("The %s mapping %d:%d is empty", kind, m.ContainerID, m.HostID)
+		}
+
+		// The kernel rejects the ranges ending after the last valid
+		// ID, 4294967294.
+		if uint64(m.ContainerID)+uint64(m.Size) > math.MaxUint32 || uint64(m.HostID)+uint64(m.Size) > math.MaxUint32 {
+			return fmt.Errorf("The %s mapping %d:%d:%d is out of range", kind, m.ContainerID, m.HostID, m.Size)
+		}
+
+		for _, o := range mappings[:i] {
+			if rangesOverlap(m.ContainerID, o.ContainerID, m.Size, o.Size) || rangesOverlap(m.HostID, o.HostID, m.Size, o.Size) {
+				return fmt.Errorf("The %s mappings %d:%d:%d and %d:%d:%d overlap", kind,
+					o.ContainerID, o.HostID, o.Size, m.ContainerID, m.HostID, m.Size)
+			}
+		}
+	}
+
+	return nil
+}
+
+func rangesOverlap(a, b, aSize, bSize uint32) bool {
+	return uint64(a) < uint64(b)+uint64(bSize) && uint64(b) < uint64(a)+uint64(aSize)
+}
+
+// checkUserNamespace returns an error if the user namespace of the
+// container, if any, cannot be created by the guest kernel. The mappings
+// are applied as they are in the guest, where the host IDs are those of the
+// files shared with the host.
+func checkUserNamespace(spec *specs.Spec) error {
+	if !hasUserNamespace(spec) {
+		if spec != nil && spec.Linux != nil && (len(spec.Linux.UIDMappings) > 0 || len(spec.Linux.GIDMappings) > 0) {
+			return fmt.Errorf("The uid and gid mappings need a user namespace")
+		}
+		return nil
+	}
+
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace && ns.Path != "" {
+			return fmt.Errorf("Cannot join the user namespace %s: the container runs in the guest, which cannot see the host namespaces", ns.Path)
+		}
+	}
+
+	if err := checkIDMappings("uid", spec.Linux.UIDMappings); err != nil {
+		return err
+	}
+
+	return checkIDMappings("gid", spec.Linux.GIDMappings)
+}
+
+// idMappingsToGRPC converts the mappings, which the agent protocol helpers
+// cannot do as the gRPC size field is named differently.
+func idMappingsToGRPC(mappings []specs.LinuxIDMapping) []grpc.LinuxIDMapping {
+	var grpcMappings []grpc.LinuxIDMapping
+
+	for _, m := range mappings {
+		grpcMappings = append(grpcMappings, grpc.LinuxIDMapping{
+			HostID:      m.HostID,
+			ContainerID: m.ContainerID,
+			Size_:       m.Size,
+		})
+	}
+
+	return grpcMappings
+}
+
+// mapID returns the host ID of the container ID id, or false if it is not
+// mapped.
+func mapID(mappings []specs.LinuxIDMapping, id uint32) (uint32, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && uint64(id) < uint64(m.ContainerID)+uint64(m.Size) {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+
+	return 0, false
+}
+
+func isHostID(mappings []specs.LinuxIDMapping, id uint32) bool {
+	for _, m := range mappings {
+		if id >= m.HostID && uint64(id) < uint64(m.HostID)+uint64(m.Size) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remapID returns the ID a file owned by id is given so that the container
+// sees it owned by id. The IDs already mapped from the container are kept,
+// so that remapping is idempotent.
+func remapID(mappings []specs.LinuxIDMapping, id uint32) (uint32, bool) {
+	if isHostID(mappings, id) {
+		return id, false
+	}
+
+	hostID, ok := mapID(mappings, id)
+	if !ok || hostID == id {
+		return id, false
+	}
+
+	return hostID, true
+}
+
+// restoreID returns the container ID a file owned by the host ID id had
+// before its ownership was remapped.
+func restoreID(mappings []specs.LinuxIDMapping, id uint32) (uint32, bool) {
+	for _, m := range mappings {
+		if id >= m.HostID && uint64(id) < uint64(m.HostID)+uint64(m.Size) {
+			containerID := m.ContainerID + id - m.HostID
+			return containerID, containerID != id
+		}
+	}
+
+	return id, false
+}
+
+var (
+	lchown           = os.Lchown
+	runtimeScratchFn = store.SandboxRuntimeRootPath
+)
+
+// isRuntimeScratchDir returns true if path is within the runtime directory
+// of the sandbox, whose files the runtime owns. The files of the other
+// mounts, e.g. the hostPath volumes, must not be chowned.
+func isRuntimeScratchDir(path, sandboxID string) bool {
+	root, err := filepath.EvalSymlinks(runtimeScratchFn(sandboxID))
+	if err != nil {
+		return false
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// chownTree changes the owners of the files under path, the symbolic links
+// not being followed.
+func chownTree(path string, mapUID, mapGID func(uint32) (uint32, bool)) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		uid, changeUID := mapUID(stat.Uid)
+		gid, changeGID := mapGID(stat.Gid)
+		if !changeUID && !changeGID {
+			return nil
+		}
+
+		if err := lchown(p, int(uid), int(gid)); err != nil {
+			return fmt.Errorf("Could not change the ownership of %s to %d:%d: %v", p, uid, gid, err)
+		}
+
+		return nil
+	})
+}
+
+// remapOwnership gives the files under path, shared with the container, the
+// host IDs their owners are mapped to in the user namespace of the
+// container, which would see them owned by the overflow IDs otherwise. It
+// must only be used on the runtime scratch directories.
+func remapOwnership(path string, spec *specs.Spec) error {
+	return chownTree(path,
+		func(id uint32) (uint32, bool) { return remapID(spec.Linux.UIDMappings, id) },
+		func(id uint32) (uint32, bool) { return remapID(spec.Linux.GIDMappings, id) })
+}
+
+// restoreOwnership gives back the files under path the owners they had
+// before remapOwnership, once the container does not use them anymore. The
+// files the container created get the owners it saw.
+func restoreOwnership(path string, spec *specs.Spec) error {
+	return chownTree(path,
+		func(id uint32) (uint32, bool) { return restoreID(spec.Linux.UIDMappings, id) },
+		func(id uint32) (uint32, bool) { return restoreID(spec.Linux.GIDMappings, id) })
+}
+
+// checkProcessUser returns an error if the user or the groups of the
+// process are not mapped in the user namespace of the container, as the
+// guest kernel would refuse to run it.
+func checkProcessUser(spec *specs.Spec, cmd types.Cmd) error {
+	if !hasUserNamespace(spec) {
+		return nil
+	}
+
+	user := strings.Split(cmd.User, ":")
+
+	check := func(kind, value string, mappings []specs.LinuxIDMapping) error {
+		if value == "" {
+			return nil
+		}
+
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			// Names are resolved in the guest.
+			return nil
+		}
+
+		if _, ok := mapID(mappings, uint32(id)); !ok {
+			return fmt.Errorf("The %s %d of the process is not mapped in the user namespace of the container", kind, id)
+		}
+
+		return nil
+	}
+
+	if err := check("uid", user[0], spec.Linux.UIDMappings); err != nil {
+		return err
+	}
+
+	groups := append([]string{cmd.PrimaryGroup}, cmd.SupplementaryGroups...)
+	if len(user) > 1 {
+		groups = append(groups, user[1])
+	}
+
+	for _, g := range groups {
+		if err := check("gid", g, spec.Linux.GIDMappings); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kata-containers/agent/protocols/grpc"
+	"github.com/kata-containers/runtime/virtcontainers/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newUsernsSpec() *specs.Spec {
+	return &specs.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.UserNamespace},
+			},
+			UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+	}
+}
+
+func TestCheckUserNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkUserNamespace(nil))
+	assert.NoError(checkUserNamespace(&specs.Spec{Linux: &specs.Linux{}}))
+	assert.NoError(checkUserNamespace(newUsernsSpec()))
+
+	for _, update := range []func(*specs.Spec){
+		// mappings without a user namespace
+		func(s *specs.Spec) { s.Linux.Namespaces = s.Linux.Namespaces[:1] },
+		// a host user namespace
+		func(s *specs.Spec) { s.Linux.Namespaces[1].Path = "/proc/1/ns/user" },
+		func(s *specs.Spec) { s.Linux.GIDMappings = nil },
+		func(s *specs.Spec) { s.Linux.UIDMappings[0].Size = 0 },
+		func(s *specs.Spec) { s.Linux.UIDMappings[0].HostID = 4294967000 },
+		func(s *specs.Spec) {
+			s.Linux.GIDMappings = append(s.Linux.GIDMappings, specs.LinuxIDMapping{ContainerID: 65535, HostID: 200000, Size: 1})
+		},
+		func(s *specs.Spec) {
+			s.Linux.GIDMappings = append(s.Linux.GIDMappings, specs.LinuxIDMapping{ContainerID: 65536, HostID: 165535, Size: 1})
+		},
+		func(s *specs.Spec) {
+			s.Linux.UIDMappings = make([]specs.LinuxIDMapping, maxIDMappings+1)
+			for i := range s.Linux.UIDMappings {
+				s.Linux.UIDMappings[i] = specs.LinuxIDMapping{ContainerID: uint32(i), HostID: uint32(i), Size: 1}
+			}
+		},
+	} {
+		spec := newUsernsSpec()
+		update(spec)
+		assert.Error(checkUserNamespace(spec), "%+v", spec.Linux)
+	}
+
+	spec := newUsernsSpec()
+	spec.Linux.GIDMappings = append(spec.Linux.GIDMappings, specs.LinuxIDMapping{ContainerID: 65536, HostID: 165536, Size: 1})
+	assert.NoError(checkUserNamespace(spec))
+}
+
+func TestIDMappingsToGRPC(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := newUsernsSpec()
+
+	// the agent protocol helpers lose the size of the mappings
+	grpcSpec, err := grpc.OCItoGRPC(spec)
+	assert.NoError(err)
+	assert.Zero(grpcSpec.Linux.UIDMappings[0].Size_)
+
+	assert.Equal([]grpc.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size_: 65536}},
+		idMappingsToGRPC(spec.Linux.UIDMappings))
+	assert.Nil(idMappingsToGRPC(nil))
+}
+
+func TestRemapID(t *testing.T) {
+	assert := assert.New(t)
+
+	mappings := newUsernsSpec().Linux.UIDMappings
+
+	for _, d := range []struct {
+		id     uint32
+		mapped uint32
+		remap  bool
+	}{
+		{0, 100000, true},
+		{1000, 101000, true},
+		// already mapped
+		{100000, 100000, false},
+		{165535, 165535, false},
+		// not mapped
+		{65536, 65536, false},
+	} {
+		id, remap := remapID(mappings, d.id)
+		assert.Equal(d.mapped, id, "%d", d.id)
+		assert.Equal(d.remap, remap, "%d", d.id)
+	}
+
+	// the identity mappings leave the files untouched
+	_, remap := remapID([]specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 65536}}, 1000)
+	assert.False(remap)
+}
+
+func TestRemapOwnership(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "userns")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(os.Mkdir(filepath.Join(dir, "sub"), 0700))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sub", "file"), nil, 0600))
+	assert.NoError(os.Symlink("/etc/passwd", filepath.Join(dir, "link")))
+
+	owners := make(map[string][2]int)
+	savedLchown := lchown
+	lchown = func(path string, uid, gid int) error {
+		owners[path] = [2]int{uid, gid}
+		return nil
+	}
+	defer func() {
+		lchown = savedLchown
+	}()
+
+	spec := newUsernsSpec()
+	spec.Linux.UIDMappings[0].ContainerID = uint32(os.Geteuid())
+	spec.Linux.GIDMappings[0].ContainerID = uint32(os.Getegid())
+
+	assert.NoError(remapOwnership(dir, spec))
+
+	expected := [2]int{100000, 100000}
+	assert.Equal(map[string][2]int{
+		dir:                               expected,
+		filepath.Join(dir, "sub"):         expected,
+		filepath.Join(dir, "sub", "file"): expected,
+		filepath.Join(dir, "link"):        expected,
+	}, owners)
+
+	assert.Error(remapOwnership(filepath.Join(dir, "missing"), spec))
+
+	// the remapped files get their owners back
+	owners = make(map[string][2]int)
+	assert.NoError(restoreOwnership(dir, spec))
+	assert.Empty(owners)
+}
+
+func TestRestoreID(t *testing.T) {
+	assert := assert.New(t)
+
+	mappings := newUsernsSpec().Linux.UIDMappings
+
+	for _, id := range []uint32{0, 1000, 65535} {
+		hostID, remap := remapID(mappings, id)
+		assert.True(remap)
+		restored, restore := restoreID(mappings, hostID)
+		assert.True(restore)
+		assert.Equal(id, restored)
+	}
+
+	_, restore := restoreID(mappings, 1000)
+	assert.False(restore)
+}
+
+func TestIsRuntimeScratchDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "userns")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedRuntimeScratchFn := runtimeScratchFn
+	runtimeScratchFn = func(id string) string {
+		return filepath.Join(dir, id)
+	}
+	defer func() {
+		runtimeScratchFn = savedRuntimeScratchFn
+	}()
+
+	scratch := filepath.Join(dir, "sandbox", "scratch")
+	assert.NoError(os.MkdirAll(scratch, 0700))
+	assert.NoError(os.Symlink(dir, filepath.Join(scratch, "escape")))
+
+	assert.True(isRuntimeScratchDir(scratch, "sandbox"))
+	assert.False(isRuntimeScratchDir(scratch, "other"))
+	assert.False(isRuntimeScratchDir(filepath.Join(dir, "sandbox"), "sandbox"))
+	assert.False(isRuntimeScratchDir(filepath.Join(scratch, "escape"), "sandbox"))
+	assert.False(isRuntimeScratchDir("/etc", "sandbox"))
+}
+
+func TestCheckProcessUser(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := newUsernsSpec()
+	spec.Linux.UIDMappings[0].Size = 1000
+
+	assert.NoError(checkProcessUser(nil, types.Cmd{User: "5000"}))
+	assert.NoError(checkProcessUser(spec, types.Cmd{User: "0"}))
+	assert.NoError(checkProcessUser(spec, types.Cmd{User: "999:5000", PrimaryGroup: "5000", SupplementaryGroups: []string{"10"}}))
+	// names are resolved in the guest
+	assert.NoError(checkProcessUser(spec, types.Cmd{User: "nobody"}))
+
+	assert.Error(checkProcessUser(spec, types.Cmd{User: "1000"}))
+	assert.Error(checkProcessUser(spec, types.Cmd{User: "0:70000"}))
+	assert.Error(checkProcessUser(spec, types.Cmd{User: "0", SupplementaryGroups: []string{"70000"}}))
+}
@@ -9,9 +9,14 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // DefaultCgroupPath runtime-determined location in the cgroups hierarchy.
@@ -32,6 +37,9 @@ const MaxSocketPathLen = 107
 // VHostVSockDevicePath path to vhost-vsock device
 var VHostVSockDevicePath = "/dev/vhost-vsock"
 
+// PossibleCPUsPath lists the CPUs the host can have
+var PossibleCPUsPath = "/sys/devices/system/cpu/possible"
+
 // FileCopy copys files from srcPath to dstPath
 func FileCopy(srcPath, dstPath string) error {
 	if srcPath == "" {
@@ -252,3 +260,65 @@ func ValidCgroupPath(path string) string {
 	// clean up path and return a new path relative to defaultCgroupPath
 	return filepath.Join(DefaultCgroupPath, filepath.Clean("/"+path))
 }
+
+// hostCPUs returns the number of CPUs the host can have, the highest of
+// the possible CPUs plus one, or the number of CPUs of the process if they
+// cannot be read.
+func hostCPUs() int {
+	data, err := ioutil.ReadFile(PossibleCPUsPath)
+	if err == nil {
+		possible := strings.TrimSpace(string(data))
+		last, err := strconv.Atoi(possible[strings.LastIndexAny(possible, ",-")+1:])
+		if err == nil && last >= 0 {
+			return last + 1
+		}
+	}
+
+	return runtime.NumCPU()
+}
+
+// ParseCPUSet parses a cpuset list, as found in cpuset.cpus, e.g. "0-3,8,10-11",
+// and returns the sorted list of CPUs without duplicates. The CPUs must be
+// ones the host can have.
+func ParseCPUSet(cpuset string) ([]int, error) {
+	maxCPU := hostCPUs() - 1
+	seen := make(map[int]bool)
+
+	for _, r := range strings.Split(strings.TrimSpace(cpuset), ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(r, "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("Invalid cpuset %q: bad CPU %q", cpuset, bounds[0])
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("Invalid cpuset %q: bad range %q", cpuset, r)
+			}
+		}
+
+		if last > maxCPU {
+			return nil, fmt.Errorf("Invalid cpuset %q: the host has no CPU above %d", cpuset, maxCPU)
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	var cpus []int
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+
+	return cpus, nil
+}
@@ -287,3 +287,37 @@ func TestValidCgroupPath(t *testing.T) {
 	assert.Equal(DefaultCgroupPath, ValidCgroupPath("./../"))
 	assert.Equal(filepath.Join(DefaultCgroupPath, "o / g"), ValidCgroupPath("o / m /../ g"))
 }
+
+func TestParseCPUSet(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cpuset")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPath := PossibleCPUsPath
+	PossibleCPUsPath = filepath.Join(dir, "possible")
+	defer func() {
+		PossibleCPUsPath = savedPath
+	}()
+
+	assert.NoError(ioutil.WriteFile(PossibleCPUsPath, []byte("0-15\n"), 0644))
+	assert.Equal(16, hostCPUs())
+
+	cpus, err := ParseCPUSet("")
+	assert.NoError(err)
+	assert.Empty(cpus)
+
+	cpus, err = ParseCPUSet("0-3,8, 10-11\n")
+	assert.NoError(err)
+	assert.Equal([]int{0, 1, 2, 3, 8, 10, 11}, cpus)
+
+	cpus, err = ParseCPUSet("3,1-2,2")
+	assert.NoError(err)
+	assert.Equal([]int{1, 2, 3}, cpus)
+
+	for _, cpuset := range []string{"a", "1-", "-1", "3-1", "1-a", "1,,x", "16", "0-4294967295"} {
+		_, err := ParseCPUSet(cpuset)
+		assert.Error(err, "cpuset %q", cpuset)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+)
+
+var setThreadAffinityFunc = setThreadAffinity
+
+// setThreadAffinity pins the thread tid to the host CPU cpu.
+func setThreadAffinity(tid, cpu int) error {
+	const bitsPerWord = 64
+
+	mask := make([]uint64, cpu/bitsPerWord+1)
+	mask[cpu/bitsPerWord] = 1 << uint(cpu%bitsPerWord)
+
+	if _, _, errno := unix.RawSyscall(unix.SYS_SCHED_SETAFFINITY, uintptr(tid),
+		uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0]))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// cgroupCPUSet returns the host CPUs of the cpuset cgroup at path, relative
+// to the cgroups mount point.
+func cgroupCPUSet(path string) ([]int, error) {
+	file := filepath.Join(cgroupV2MountPoint, path, "cpuset.cpus.effective")
+	if !isCgroupV2() {
+		root, err := cgroupV1MountPoint()
+		if err != nil {
+			return nil, err
+		}
+
+		file = filepath.Join(root, "cpuset", path, "cpuset.cpus")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.ParseCPUSet(string(data))
+}
+
+// processCPUSet returns the host CPUs the process pid is allowed to run on.
+func processCPUSet(pid int) ([]int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) == 2 && fields[0] == "Cpus_allowed_list" {
+			return utils.ParseCPUSet(fields[1])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("Could not find the CPUs allowed to process %d", pid)
+}
+
+// vcpuCPUSet returns the host CPUs the vCPU threads are pinned to: the
+// configured cpuset, else the sandbox cpuset cgroup, else the CPUs the
+// hypervisor is allowed to run on.
+func (s *Sandbox) vcpuCPUSet() ([]int, error) {
+	if cpuset := s.hypervisor.hypervisorConfig().VCPUCPUSet; cpuset != "" {
+		return utils.ParseCPUSet(cpuset)
+	}
+
+	if s.state.CgroupPath != "" {
+		cpus, err := cgroupCPUSet(s.state.CgroupPath)
+		if err == nil && len(cpus) > 0 {
+			return cpus, nil
+		}
+		s.Logger().WithError(err).Debug("Could not read the sandbox cpuset cgroup")
+	}
+
+	pids := s.hypervisor.getPids()
+	if len(pids) == 0 || pids[0] <= 0 {
+		return nil, fmt.Errorf("Invalid hypervisor PID: %+v", pids)
+	}
+
+	return processCPUSet(pids[0])
+}
+
+// pinHypervisorVCPUs pins each vCPU thread of the hypervisor to a host CPU,
+// if enabled. It has to be called again whenever vCPUs are hotplugged, and
+// after the vCPU threads joined a cgroup since this resets their affinity.
+func (s *Sandbox) pinHypervisorVCPUs() error {
+	if !s.hypervisor.hypervisorConfig().PinVCPUs {
+		return nil
+	}
+
+	cpus, err := s.vcpuCPUSet()
+	if err != nil {
+		return fmt.Errorf("Could not get the host CPUs to pin vCPUs to: %v", err)
+	}
+
+	if len(cpus) == 0 {
+		return fmt.Errorf("No host CPU to pin vCPUs to")
+	}
+
+	tids, err := s.hypervisor.getThreadIDs()
+	if err != nil {
+		return fmt.Errorf("failed to get thread ids from hypervisor: %v", err)
+	}
+
+	vcpus := make([]int, 0, len(tids.vcpus))
+	for vcpu := range tids.vcpus {
+		vcpus = append(vcpus, vcpu)
+	}
+	sort.Ints(vcpus)
+
+	for _, vcpu := range vcpus {
+		tid := tids.vcpus[vcpu]
+		cpu := cpus[vcpu%len(cpus)]
+
+		s.Logger().WithFields(logrus.Fields{
+			"vcpu":     vcpu,
+			"tid":      tid,
+			"host-cpu": cpu,
+		}).Debug("Pinning vCPU")
+
+		if err := setThreadAffinityFunc(tid, cpu); err != nil {
+			return fmt.Errorf("Could not pin vCPU %d thread %d to host CPU %d: %v", vcpu, tid, cpu, err)
+		}
+	}
+
+	return nil
+}
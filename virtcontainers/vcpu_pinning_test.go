@@ -0,0 +1,92 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kata-containers/runtime/virtcontainers/utils"
+)
+
+type pinningHypervisor struct {
+	mockHypervisor
+	config HypervisorConfig
+	vcpus  map[int]int
+}
+
+func (h *pinningHypervisor) hypervisorConfig() HypervisorConfig {
+	return h.config
+}
+
+func (h *pinningHypervisor) getThreadIDs() (vcpuThreadIDs, error) {
+	return vcpuThreadIDs{h.vcpus}, nil
+}
+
+func TestProcessCPUSet(t *testing.T) {
+	assert := assert.New(t)
+
+	cpus, err := processCPUSet(os.Getpid())
+	assert.NoError(err)
+	assert.NotEmpty(cpus)
+
+	_, err = processCPUSet(-1)
+	assert.Error(err)
+}
+
+func TestSandboxPinHypervisorVCPUs(t *testing.T) {
+	assert := assert.New(t)
+
+	pinned := make(map[int]int)
+	savedFunc := setThreadAffinityFunc
+	setThreadAffinityFunc = func(tid, cpu int) error {
+		pinned[tid] = cpu
+		return nil
+	}
+	defer func() {
+		setThreadAffinityFunc = savedFunc
+	}()
+
+	dir, err := ioutil.TempDir("", "vcpu-pinning")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPath := utils.PossibleCPUsPath
+	utils.PossibleCPUsPath = filepath.Join(dir, "possible")
+	defer func() {
+		utils.PossibleCPUsPath = savedPath
+	}()
+	assert.NoError(ioutil.WriteFile(utils.PossibleCPUsPath, []byte("0-7"), 0644))
+
+	h := &pinningHypervisor{
+		mockHypervisor: mockHypervisor{mockPid: os.Getpid()},
+		vcpus:          map[int]int{0: 100, 1: 101, 2: 102},
+	}
+	s := &Sandbox{
+		hypervisor: h,
+	}
+
+	// nothing to do by default
+	assert.NoError(s.pinHypervisorVCPUs())
+	assert.Empty(pinned)
+
+	h.config.PinVCPUs = true
+	h.config.VCPUCPUSet = "4,6"
+	assert.NoError(s.pinHypervisorVCPUs())
+	assert.Equal(map[int]int{100: 4, 101: 6, 102: 4}, pinned)
+
+	// defaults to the CPUs the hypervisor may run on
+	h.config.VCPUCPUSet = ""
+	assert.NoError(s.pinHypervisorVCPUs())
+	assert.Len(pinned, 3)
+
+	h.config.VCPUCPUSet = "x"
+	assert.Error(s.pinHypervisorVCPUs())
+}
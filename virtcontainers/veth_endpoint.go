@@ -150,6 +150,7 @@ func (endpoint *VethEndpoint) save() persistapi.NetworkEndpoint {
 		Type: string(endpoint.Type()),
 		Veth: &persistapi.VethEndpoint{
 			NetPair: *netpair,
+			PCIAddr: endpoint.PCIAddr,
 		},
 	}
 }
@@ -160,5 +161,6 @@ func (endpoint *VethEndpoint) load(s persistapi.NetworkEndpoint) {
 	if s.Veth != nil {
 		netpair := loadNetIfPair(&s.Veth.NetPair)
 		endpoint.NetPair = *netpair
+		endpoint.PCIAddr = s.Veth.PCIAddr
 	}
 }
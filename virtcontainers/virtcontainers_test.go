@@ -130,6 +130,7 @@ func TestMain(m *testing.M) {
 	store.ConfigStoragePath = filepath.Join(testDir, store.StoragePathSuffix, "config")
 	store.RunStoragePath = filepath.Join(testDir, store.StoragePathSuffix, "run")
 	fs.TestSetRunStoragePath(filepath.Join(testDir, "vc", "sbs"))
+	macAddressRegistryPath = filepath.Join(testDir, store.StoragePathSuffix, "macs")
 
 	// set now that configStoragePath has been overridden.
 	sandboxDirConfig = filepath.Join(store.ConfigStoragePath, testSandboxID)
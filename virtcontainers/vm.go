@@ -185,7 +185,7 @@ func NewVM(ctx context.Context, config VMConfig) (*VM, error) {
 	}
 
 	// 3. boot up guest vm
-	if err = hypervisor.startSandbox(vmStartTimeout); err != nil {
+	if err = hypervisor.startSandbox(config.HypervisorConfig.startTimeout()); err != nil {
 		return nil, err
 	}
 
@@ -314,7 +314,8 @@ func (v *VM) Resume() error {
 // Start kicks off a configured VM.
 func (v *VM) Start() error {
 	v.logger().Info("start vm")
-	return v.hypervisor.startSandbox(vmStartTimeout)
+	conf := v.hypervisor.hypervisorConfig()
+	return v.hypervisor.startSandbox(conf.startTimeout())
 }
 
 // Disconnect agent and proxy connections to a VM
@@ -478,5 +479,11 @@ func (v *VM) GetVMStatus() *pb.GrpcVMStatus {
 		Pid:    int64(getHypervisorPid(v.hypervisor)),
 		Cpu:    v.cpu,
 		Memory: v.memory,
+		Id:     v.id,
 	}
 }
+
+// ID returns the ID of the VM.
+func (v *VM) ID() string {
+	return v.id
+}
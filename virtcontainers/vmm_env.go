@@ -0,0 +1,188 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// vmmEnvNameRegexp matches the valid names of environment variables.
+var vmmEnvNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The environment variables the hypervisor can be given, by prefix. They
+// configure QEMU, its plugins and the vhost-user backends it connects to,
+// while the variables changing how binaries are loaded, such as LD_PRELOAD,
+// are rejected.
+var vmmEnvPrefixes = []string{
+	"QEMU_",
+	"SPDK_",
+	"DPDK_",
+	"VHOST_",
+}
+
+// The environment variables the hypervisor can be given, by name.
+var vmmEnvNames = map[string]bool{
+	"TMPDIR":          true,
+	"XDG_RUNTIME_DIR": true,
+}
+
+func vmmEnvAllowed(name string) bool {
+	if vmmEnvNames[name] {
+		return true
+	}
+
+	for _, prefix := range vmmEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkVMMEnv returns an error if an environment variable of the
+// hypervisor is not of the form NAME=VALUE, or is not one it can be given.
+func checkVMMEnv(env []string) error {
+	seen := make(map[string]bool)
+
+	for _, e := range env {
+		i := strings.Index(e, "=")
+		if i < 0 {
+			return fmt.Errorf("Invalid hypervisor environment variable %q: expected NAME=VALUE", e)
+		}
+
+		name := e[:i]
+		if !vmmEnvNameRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid hypervisor environment variable name %q", name)
+		}
+
+		if !vmmEnvAllowed(name) {
+			return fmt.Errorf("Hypervisor environment variable %s is not allowed: only %s and the variables prefixed with %s are",
+				name, strings.Join(sortedKeys(vmmEnvNames), ", "), strings.Join(vmmEnvPrefixes, ", "))
+		}
+
+		if seen[name] {
+			return fmt.Errorf("Hypervisor environment variable %s is set twice", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+var vmmEnvLock sync.Mutex
+
+// withVMMEnv runs launch with the environment variables env set in the
+// runtime, so that the processes it starts inherit them. The variables are
+// restored once launch returns, and the launches are serialized.
+func withVMMEnv(env []string, launch func() error) error {
+	if len(env) == 0 {
+		return launch()
+	}
+
+	vmmEnvLock.Lock()
+	defer vmmEnvLock.Unlock()
+
+	for _, e := range env {
+		i := strings.Index(e, "=")
+		name, value := e[:i], e[i+1:]
+
+		saved, set := os.LookupEnv(name)
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("Could not set the hypervisor environment variable %s: %v", name, err)
+		}
+
+		defer func() {
+			if set {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		}()
+	}
+
+	return launch()
+}
+
+// VMMBindMount is a bind mount of the private mount namespace the
+// hypervisor is launched in.
+type VMMBindMount struct {
+	// Source is the host path mounted.
+	Source string
+
+	// Destination is the path the hypervisor sees Source at.
+	Destination string
+}
+
+// ParseVMMBindMount parses a bind mount of the form "source:destination".
+func ParseVMMBindMount(value string) (VMMBindMount, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return VMMBindMount{}, fmt.Errorf("Invalid hypervisor bind mount %q: expected source:destination", value)
+	}
+
+	return VMMBindMount{Source: parts[0], Destination: parts[1]}, nil
+}
+
+// checkVMMBindMounts returns an error if the paths of a bind mount are not
+// absolute or do not exist, as the hypervisor would otherwise fail to be
+// launched.
+func checkVMMBindMounts(mounts []VMMBindMount) error {
+	for _, m := range mounts {
+		for _, path := range []string{m.Source, m.Destination} {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("Hypervisor bind mount path %q is not absolute", path)
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("Invalid hypervisor bind mount %s:%s: %v", m.Source, m.Destination, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setupVMMMountNamespace moves the calling thread to a private mount
+// namespace with the bind mounts, which the processes it starts inherit.
+// The mounts of the host are still propagated to the namespace, but not
+// the other way around. The thread has to be locked and never reused.
+func setupVMMMountNamespace(mounts []VMMBindMount) error {
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("Could not create the hypervisor mount namespace: %v", err)
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_SLAVE, ""); err != nil {
+		return fmt.Errorf("Could not make the hypervisor mount namespace a slave: %v", err)
+	}
+
+	for _, m := range mounts {
+		if err := unix.Mount(m.Source, m.Destination, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("Could not bind mount %s on %s for the hypervisor: %v", m.Source, m.Destination, err)
+		}
+	}
+
+	return nil
+}
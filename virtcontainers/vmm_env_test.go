@@ -0,0 +1,100 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVMMEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkVMMEnv(nil))
+	assert.NoError(checkVMMEnv([]string{
+		"SPDK_RPC_SOCKET=/var/run/spdk.sock",
+		"QEMU_AUDIO_DRV=none",
+		"XDG_RUNTIME_DIR=/run/user/1000",
+		"VHOST_EMPTY=",
+	}))
+
+	for _, env := range [][]string{
+		{"LD_PRELOAD=/tmp/evil.so"},
+		{"PATH=/tmp"},
+		{"QEMU_AUDIO_DRV"},
+		{"=none"},
+		{"QEMU-DRV=none"},
+		{"TMPDIR=/tmp", "TMPDIR=/var/tmp"},
+	} {
+		assert.Error(checkVMMEnv(env), "%v", env)
+	}
+}
+
+func TestWithVMMEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("QEMU_TEST_SET", "runtime")
+	defer os.Unsetenv("QEMU_TEST_SET")
+	os.Unsetenv("QEMU_TEST_UNSET")
+
+	err := withVMMEnv([]string{"QEMU_TEST_SET=vmm=1", "QEMU_TEST_UNSET=vmm"}, func() error {
+		assert.Equal("vmm=1", os.Getenv("QEMU_TEST_SET"))
+		assert.Equal("vmm", os.Getenv("QEMU_TEST_UNSET"))
+		return nil
+	})
+	assert.NoError(err)
+
+	// the environment of the runtime is restored
+	assert.Equal("runtime", os.Getenv("QEMU_TEST_SET"))
+	_, set := os.LookupEnv("QEMU_TEST_UNSET")
+	assert.False(set)
+
+	// the launch errors are returned
+	launchErr := errors.New("launch")
+	assert.Equal(launchErr, withVMMEnv(nil, func() error { return launchErr }))
+}
+
+func TestParseVMMBindMount(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := ParseVMMBindMount("/var/run/spdk:/var/run/vhost")
+	assert.NoError(err)
+	assert.Equal(VMMBindMount{Source: "/var/run/spdk", Destination: "/var/run/vhost"}, m)
+
+	for _, value := range []string{"", "/var/run/spdk", ":/var/run/vhost", "/a:/b:/c"} {
+		_, err := ParseVMMBindMount(value)
+		assert.Error(err, "%q", value)
+	}
+}
+
+func TestCheckVMMBindMounts(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "bind-mounts")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	destination := filepath.Join(dir, "destination")
+	assert.NoError(os.Mkdir(source, 0700))
+
+	assert.NoError(checkVMMBindMounts(nil))
+
+	// the destination does not exist
+	mounts := []VMMBindMount{{Source: source, Destination: destination}}
+	assert.Error(checkVMMBindMounts(mounts))
+
+	assert.NoError(os.Mkdir(destination, 0700))
+	assert.NoError(checkVMMBindMounts(mounts))
+
+	mounts[0].Source = "source"
+	assert.Error(checkVMMBindMounts(mounts))
+}
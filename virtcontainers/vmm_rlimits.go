@@ -0,0 +1,177 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/docker/go-units"
+	"golang.org/x/sys/unix"
+)
+
+// VMMRlimit is a resource limit of the hypervisor processes.
+type VMMRlimit struct {
+	// Resource is the limited resource, e.g. unix.RLIMIT_MEMLOCK.
+	Resource int
+
+	// Limit is the soft limit of the resource, unix.RLIM_INFINITY for no
+	// limit.
+	Limit uint64
+}
+
+// The resources VMMRlimit can limit, by name.
+var vmmRlimitResources = map[string]int{
+	"nofile":  unix.RLIMIT_NOFILE,
+	"memlock": unix.RLIMIT_MEMLOCK,
+	"core":    unix.RLIMIT_CORE,
+}
+
+func vmmRlimitName(resource int) string {
+	for name, r := range vmmRlimitResources {
+		if r == resource {
+			return name
+		}
+	}
+
+	return strconv.Itoa(resource)
+}
+
+func formatRlimit(limit uint64) string {
+	if limit == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+
+	return strconv.FormatUint(limit, 10)
+}
+
+// ParseVMMRlimit parses the limit of the resource name, "nofile", "memlock"
+// or "core". The limit is "unlimited", a number of files for nofile or a
+// size such as "64M" for the others.
+func ParseVMMRlimit(name, value string) (VMMRlimit, error) {
+	resource, ok := vmmRlimitResources[name]
+	if !ok {
+		return VMMRlimit{}, fmt.Errorf("Unknown resource limit %q", name)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "unlimited" {
+		return VMMRlimit{Resource: resource, Limit: unix.RLIM_INFINITY}, nil
+	}
+
+	var limit int64
+	var err error
+
+	if resource == unix.RLIMIT_NOFILE {
+		limit, err = strconv.ParseInt(value, 10, 64)
+	} else {
+		limit, err = units.RAMInBytes(value)
+	}
+
+	if err != nil || limit < 0 {
+		return VMMRlimit{}, fmt.Errorf("Invalid %s limit %q", name, value)
+	}
+
+	return VMMRlimit{Resource: resource, Limit: uint64(limit)}, nil
+}
+
+var (
+	nrOpenPath = "/proc/sys/fs/nr_open"
+	getRlimit  = syscall.Getrlimit
+	setRlimit  = syscall.Setrlimit
+	geteuid    = os.Geteuid
+)
+
+// checkVMMRlimits returns an error if the runtime cannot apply limits to
+// the hypervisor processes, or if they are too low for the guest memory to
+// be locked, as QEMU would otherwise fail with obscure errors at launch or
+// when hotplugging devices.
+func checkVMMRlimits(limits []VMMRlimit, conf *HypervisorConfig) error {
+	for _, l := range limits {
+		name := vmmRlimitName(l.Resource)
+
+		var current syscall.Rlimit
+		if err := getRlimit(l.Resource, &current); err != nil {
+			return fmt.Errorf("Could not get the %s limit: %v", name, err)
+		}
+
+		if l.Limit > current.Max && geteuid() != 0 {
+			return fmt.Errorf("The %s limit %s is above the hard limit %s of the runtime: raise the hard limit or run the runtime as root",
+				name, formatRlimit(l.Limit), formatRlimit(current.Max))
+		}
+
+		switch l.Resource {
+		case unix.RLIMIT_NOFILE:
+			data, err := ioutil.ReadFile(nrOpenPath)
+			if err != nil {
+				return err
+			}
+
+			nrOpen, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid fs.nr_open %q: %v", data, err)
+			}
+
+			if l.Limit > nrOpen {
+				return fmt.Errorf("The nofile limit %s is above the host maximum fs.nr_open %d: lower it or raise fs.nr_open",
+					formatRlimit(l.Limit), nrOpen)
+			}
+		case unix.RLIMIT_MEMLOCK:
+			memory := uint64(conf.MemorySize) << 20
+			if (conf.HugePages || conf.Mlock) && l.Limit < memory {
+				return fmt.Errorf("The memlock limit %s is below the guest memory %dMiB, which is locked with huge pages or without swap: set it to %dM or unlimited",
+					formatRlimit(l.Limit), conf.MemorySize, conf.MemorySize)
+			}
+		}
+	}
+
+	return nil
+}
+
+var vmmRlimitsLock sync.Mutex
+
+// withVMMRlimits runs launch with the soft limits of the runtime set to
+// limits, so that the processes it starts inherit them. The limits are per
+// process: they are restored once launch returns, and the launches are
+// serialized. The hard limits are only raised, so that the runtime can
+// restore its own limits without privileges.
+func withVMMRlimits(limits []VMMRlimit, launch func() error) error {
+	if len(limits) == 0 {
+		return launch()
+	}
+
+	vmmRlimitsLock.Lock()
+	defer vmmRlimitsLock.Unlock()
+
+	for _, l := range limits {
+		var saved syscall.Rlimit
+		if err := getRlimit(l.Resource, &saved); err != nil {
+			return err
+		}
+
+		rlimit := syscall.Rlimit{Cur: l.Limit, Max: saved.Max}
+		if rlimit.Max < l.Limit {
+			rlimit.Max = l.Limit
+		}
+
+		if err := setRlimit(l.Resource, &rlimit); err != nil {
+			return fmt.Errorf("Could not set the %s limit to %s: %v", vmmRlimitName(l.Resource), formatRlimit(l.Limit), err)
+		}
+
+		defer func(resource int) {
+			if err := setRlimit(resource, &saved); err != nil {
+				virtLog.WithError(err).WithField("resource", vmmRlimitName(resource)).Warn("Could not restore the resource limit")
+			}
+		}(l.Resource)
+	}
+
+	return launch()
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseVMMRlimit(t *testing.T) {
+	assert := assert.New(t)
+
+	type testData struct {
+		name     string
+		value    string
+		resource int
+		limit    uint64
+	}
+
+	for _, d := range []testData{
+		{"nofile", "1024", unix.RLIMIT_NOFILE, 1024},
+		{"nofile", "unlimited", unix.RLIMIT_NOFILE, unix.RLIM_INFINITY},
+		{"memlock", "64M", unix.RLIMIT_MEMLOCK, 64 << 20},
+		{"memlock", " unlimited ", unix.RLIMIT_MEMLOCK, unix.RLIM_INFINITY},
+		{"core", "0", unix.RLIMIT_CORE, 0},
+	} {
+		l, err := ParseVMMRlimit(d.name, d.value)
+		assert.NoError(err, "%s %q", d.name, d.value)
+		assert.Equal(VMMRlimit{Resource: d.resource, Limit: d.limit}, l, "%s %q", d.name, d.value)
+	}
+
+	for _, d := range []testData{
+		{name: "stack", value: "8M"},
+		{name: "nofile", value: "64K"},
+		{name: "nofile", value: "-1"},
+		{name: "memlock", value: "lots"},
+		{name: "core", value: ""},
+	} {
+		_, err := ParseVMMRlimit(d.name, d.value)
+		assert.Error(err, "%s %q", d.name, d.value)
+	}
+}
+
+func TestCheckVMMRlimits(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "rlimits")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedNrOpenPath, savedGetRlimit, savedGeteuid := nrOpenPath, getRlimit, geteuid
+	defer func() {
+		nrOpenPath, getRlimit, geteuid = savedNrOpenPath, savedGetRlimit, savedGeteuid
+	}()
+
+	nrOpenPath = filepath.Join(dir, "nr_open")
+	assert.NoError(ioutil.WriteFile(nrOpenPath, []byte("1048576\n"), 0644))
+
+	getRlimit = func(resource int, rlimit *syscall.Rlimit) error {
+		*rlimit = syscall.Rlimit{Cur: 1024, Max: 4096}
+		return nil
+	}
+	geteuid = func() int { return 1000 }
+
+	conf := &HypervisorConfig{MemorySize: 2048, Mlock: true}
+
+	nofile := func(limit uint64) []VMMRlimit {
+		return []VMMRlimit{{Resource: unix.RLIMIT_NOFILE, Limit: limit}}
+	}
+	memlock := func(limit uint64) []VMMRlimit {
+		return []VMMRlimit{{Resource: unix.RLIMIT_MEMLOCK, Limit: limit}}
+	}
+
+	assert.NoError(checkVMMRlimits(nil, conf))
+	assert.NoError(checkVMMRlimits(nofile(4096), conf))
+
+	// Above the hard limit of an unprivileged runtime.
+	assert.Error(checkVMMRlimits(nofile(8192), conf))
+
+	geteuid = func() int { return 0 }
+	assert.NoError(checkVMMRlimits(nofile(8192), conf))
+
+	// Above the host maximum.
+	assert.Error(checkVMMRlimits(nofile(2097152), conf))
+
+	// The guest memory is locked.
+	assert.Error(checkVMMRlimits(memlock(64<<20), conf))
+	assert.NoError(checkVMMRlimits(memlock(2048<<20), conf))
+	assert.NoError(checkVMMRlimits(memlock(unix.RLIM_INFINITY), conf))
+
+	conf.Mlock = false
+	assert.NoError(checkVMMRlimits(memlock(64<<20), conf))
+
+	conf.HugePages = true
+	assert.Error(checkVMMRlimits(memlock(64<<20), conf))
+
+	getRlimit = func(resource int, rlimit *syscall.Rlimit) error {
+		return errors.New("no limit")
+	}
+	assert.Error(checkVMMRlimits(memlock(unix.RLIM_INFINITY), conf))
+}
+
+func TestWithVMMRlimits(t *testing.T) {
+	assert := assert.New(t)
+
+	var saved syscall.Rlimit
+	assert.NoError(syscall.Getrlimit(unix.RLIMIT_CORE, &saved))
+
+	if saved.Max == 0 {
+		t.Skip("core dumps cannot be limited further")
+	}
+
+	limits := []VMMRlimit{{Resource: unix.RLIMIT_CORE, Limit: 0}}
+
+	err := withVMMRlimits(limits, func() error {
+		var current syscall.Rlimit
+		assert.NoError(syscall.Getrlimit(unix.RLIMIT_CORE, &current))
+		assert.Equal(uint64(0), current.Cur)
+		assert.Equal(saved.Max, current.Max)
+		return errors.New("launch failed")
+	})
+	assert.EqualError(err, "launch failed")
+
+	var restored syscall.Rlimit
+	assert.NoError(syscall.Getrlimit(unix.RLIMIT_CORE, &restored))
+	assert.Equal(saved, restored)
+
+	called := false
+	assert.NoError(withVMMRlimits(nil, func() error {
+		called = true
+		return nil
+	}))
+	assert.True(called)
+}
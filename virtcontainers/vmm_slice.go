@@ -0,0 +1,91 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	systemdDbus "github.com/coreos/go-systemd/dbus"
+)
+
+// vmmScopeTimeout bounds the start of the transient scope of the hypervisor.
+const vmmScopeTimeout = 10 * time.Second
+
+// checkVMMSlice returns an error if slice is not the name of a systemd
+// slice.
+func checkVMMSlice(slice string) error {
+	if slice == "" {
+		return nil
+	}
+
+	if !strings.HasSuffix(slice, ".slice") || strings.Contains(slice, "/") {
+		return fmt.Errorf("Invalid hypervisor slice %q: expecting a systemd slice name", slice)
+	}
+
+	return nil
+}
+
+// vmmScopeName returns the name of the transient scope of the hypervisor of
+// the sandbox id.
+func vmmScopeName(id string) string {
+	return fmt.Sprintf("kata-vmm-%s.scope", id)
+}
+
+// startVMMScope places the processes pids in the transient scope name of
+// slice. The hypervisor is launched by the runtime, which gives it its file
+// descriptors, and only moved to the scope once running: systemd-run would
+// not pass them to it. systemd removes the scope once its processes exited.
+var startVMMScope = func(name, slice string, pids []int) error {
+	conn, err := systemdDbus.New()
+	if err != nil {
+		return fmt.Errorf("Could not connect to systemd: %v", err)
+	}
+	defer conn.Close()
+
+	var scopePids []uint32
+	for _, pid := range pids {
+		scopePids = append(scopePids, uint32(pid))
+	}
+
+	ch := make(chan string, 1)
+	if _, err := conn.StartTransientUnit(name, "replace", []systemdDbus.Property{
+		systemdDbus.PropSlice(slice),
+		systemdDbus.PropDescription("Kata Containers hypervisor"),
+		systemdDbus.PropPids(scopePids...),
+	}, ch); err != nil {
+		return fmt.Errorf("Could not start the hypervisor scope %s: %v", name, err)
+	}
+
+	select {
+	case result := <-ch:
+		if result != "done" {
+			return fmt.Errorf("Could not start the hypervisor scope %s: %s", name, result)
+		}
+	case <-time.After(vmmScopeTimeout):
+		return fmt.Errorf("Timed out starting the hypervisor scope %s", name)
+	}
+
+	return nil
+}
+
+// placeInSlice moves the hypervisor processes to their scope of the
+// configured slice.
+func (q *qemu) placeInSlice() error {
+	if q.config.VMMSlice == "" {
+		return nil
+	}
+
+	pids := q.getPids()
+	for _, pid := range pids {
+		if pid <= 0 {
+			return fmt.Errorf("Invalid hypervisor pid %d", pid)
+		}
+	}
+
+	return startVMMScope(vmmScopeName(q.id), q.config.VMMSlice, pids)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVMMSlice(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkVMMSlice(""))
+	assert.NoError(checkVMMSlice("kata-vmm.slice"))
+
+	assert.Error(checkVMMSlice("kata-vmm"))
+	assert.Error(checkVMMSlice("kata-vmm.scope"))
+	assert.Error(checkVMMSlice("../kata-vmm.slice"))
+}
+
+func TestQemuPlaceInSlice(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "slice")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var scope, slice string
+	var pids []int
+	savedStartVMMScope := startVMMScope
+	startVMMScope = func(name, s string, p []int) error {
+		scope, slice, pids = name, s, p
+		return nil
+	}
+	defer func() {
+		startVMMScope = savedStartVMMScope
+	}()
+
+	q := &qemu{
+		id: "foo",
+		qemuConfig: govmmQemu.Config{
+			PidFile: filepath.Join(dir, "pid"),
+		},
+	}
+
+	// No slice configured
+	assert.NoError(q.placeInSlice())
+	assert.Empty(scope)
+
+	q.config.VMMSlice = "kata-vmm.slice"
+
+	// The pid file is missing
+	assert.Error(q.placeInSlice())
+
+	assert.NoError(ioutil.WriteFile(q.qemuConfig.PidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600))
+	q.state.VirtiofsdPid = 42
+	assert.NoError(q.placeInSlice())
+	assert.Equal("kata-vmm-foo.scope", scope)
+	assert.Equal("kata-vmm.slice", slice)
+	assert.Equal([]int{os.Getpid(), 42}, pids)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// vmmQuitTimeout is the time the hypervisor and virtiofsd processes
+	// are given to exit once asked to, before they are killed.
+	vmmQuitTimeout = 10 * time.Second
+
+	processPollInterval = 50 * time.Millisecond
+)
+
+// sysPidfdOpen is the number of the pidfd_open system call, which follows
+// pidfd_send_signal by ten in the tables of all the architectures.
+const sysPidfdOpen = unix.SYS_PIDFD_SEND_SIGNAL + 10
+
+func pidfdOpen(pid int) (int, error) {
+	fd, _, errno := unix.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+func pidfdSendSignal(pidfd int, sig unix.Signal) error {
+	_, _, errno := unix.Syscall6(unix.SYS_PIDFD_SEND_SIGNAL, uintptr(pidfd), uintptr(sig), 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// processStat returns the fields of the stat file of the process pid which
+// follow its command name, starting with its state.
+func processStat(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name is in parentheses, and can contain spaces and
+	// parentheses itself.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid stat file of process %d", pid)
+	}
+
+	return strings.Fields(string(data[i+1:])), nil
+}
+
+// processExited returns true if the process pid exited, including when it
+// is a zombie waiting to be reaped by its parent.
+func processExited(pid int) bool {
+	fields, err := processStat(pid)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || len(fields) == 0 {
+		return false
+	}
+
+	state := fields[0]
+
+	return state == "Z" || state == "X"
+}
+
+// processStartTime returns the time the process pid started at, in clock
+// ticks since the boot. Along with the pid, it identifies the process.
+func processStartTime(pid int) (uint64, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// starttime is the 22nd field of the file, the 20th from the state.
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("Invalid stat file of process %d", pid)
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// killProcess kills the process pid started at startTime. The pid of a
+// process which exited can be reused: the process is signaled through a
+// pidfd, which keeps referring to the process it was opened for, once its
+// start time is checked. The kernels without pidfd only check it.
+func killProcess(pid int, startTime uint64) error {
+	pidfd, err := pidfdOpen(pid)
+	switch err {
+	case nil:
+		defer unix.Close(pidfd)
+	case unix.ENOSYS:
+		pidfd = -1
+	case unix.ESRCH:
+		return nil
+	default:
+		return fmt.Errorf("Could not open process %d: %v", pid, err)
+	}
+
+	current, err := processStartTime(pid)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != startTime {
+		virtLog.WithField("pid", pid).Info("Process exited, its pid was reused")
+		return nil
+	}
+
+	if pidfd < 0 {
+		err = unix.Kill(pid, unix.SIGKILL)
+	} else {
+		err = pidfdSendSignal(pidfd, unix.SIGKILL)
+	}
+
+	if err != nil && err != unix.ESRCH {
+		return fmt.Errorf("Could not kill process %d: %v", pid, err)
+	}
+
+	return nil
+}
+
+// stopProcess waits up to timeout for the process pid to exit, and kills
+// it otherwise. It returns true if the process had to be killed.
+func stopProcess(pid int, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+
+	for !processExited(pid) {
+		if time.Now().After(deadline) {
+			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return false, fmt.Errorf("Could not kill process %d: %v", pid, err)
+			}
+
+			return true, nil
+		}
+
+		time.Sleep(processPollInterval)
+	}
+
+	return false, nil
+}
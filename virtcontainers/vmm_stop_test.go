@@ -0,0 +1,76 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	savedInterval := processPollInterval
+	processPollInterval = time.Millisecond
+	defer func() {
+		processPollInterval = savedInterval
+	}()
+
+	// a process exiting in time is not killed
+	cmd := exec.Command("true")
+	assert.NoError(cmd.Start())
+	pid := cmd.Process.Pid
+
+	killed, err := stopProcess(pid, 5*time.Second)
+	assert.NoError(err)
+	assert.False(killed)
+	assert.True(processExited(pid))
+	cmd.Wait()
+
+	// one that does not is killed
+	cmd = exec.Command("sleep", "60")
+	assert.NoError(cmd.Start())
+	pid = cmd.Process.Pid
+	assert.False(processExited(pid))
+
+	killed, err = stopProcess(pid, 10*time.Millisecond)
+	assert.NoError(err)
+	assert.True(killed)
+	assert.Error(cmd.Wait())
+
+	// the reaped processes are gone
+	assert.True(processExited(pid))
+	killed, err = stopProcess(pid, 0)
+	assert.NoError(err)
+	assert.False(killed)
+}
+
+func TestKillProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := exec.Command("sleep", "60")
+	assert.NoError(cmd.Start())
+	pid := cmd.Process.Pid
+
+	startTime, err := processStartTime(pid)
+	assert.NoError(err)
+	assert.NotZero(startTime)
+
+	// a process started at another time reuses the pid
+	assert.NoError(killProcess(pid, startTime+1))
+	assert.False(processExited(pid))
+
+	assert.NoError(killProcess(pid, startTime))
+	assert.Error(cmd.Wait())
+
+	// the reaped processes are gone
+	assert.NoError(killProcess(pid, startTime))
+	_, err = processStartTime(pid)
+	assert.Error(err)
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	govmmQemu "github.com/intel/govmm/qemu"
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	vfioDevicePath  = "/dev/vfio"
+	lookupVMMUserFn = user.Lookup
+)
+
+// POSIX ACL extended attribute, as encoded by the kernel.
+const (
+	aclXattr       = "system.posix_acl_access"
+	aclVersion     = 2
+	aclHeaderSize  = 4
+	aclEntrySize   = 8
+	aclUndefinedID = 0xffffffff
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclRead  = 0x4
+	aclWrite = 0x2
+)
+
+// vmmUser is the unprivileged user the hypervisor processes run as.
+type vmmUser struct {
+	name string
+	uid  uint32
+	gid  uint32
+}
+
+func parseID(id string) (uint32, error) {
+	v, err := strconv.ParseUint(id, 10, 32)
+	return uint32(v), err
+}
+
+// lookupVMMUser returns the user name, which must not be root.
+func lookupVMMUser(name string) (*vmmUser, error) {
+	u, err := lookupVMMUserFn(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find the hypervisor user %q: %v", name, err)
+	}
+
+	vu := &vmmUser{name: name}
+
+	if vu.uid, err = parseID(u.Uid); err != nil {
+		return nil, fmt.Errorf("Invalid uid %q of user %q", u.Uid, name)
+	}
+
+	if vu.uid == 0 {
+		return nil, fmt.Errorf("The hypervisor user %q is root", name)
+	}
+
+	if vu.gid, err = parseID(u.Gid); err != nil {
+		return nil, fmt.Errorf("Invalid gid %q of user %q", u.Gid, name)
+	}
+
+	return vu, nil
+}
+
+// grantAccess lets the user read and write path, through an entry of the
+// ACL of path naming the user. The owner, group and mode of path are left
+// untouched.
+func (u *vmmUser) grantAccess(path string) error {
+	return setUserACL(path, u.uid, aclRead|aclWrite)
+}
+
+// revokeAccess removes the entry of the user from the ACL of path, which
+// gets back its mode if no other user or group is named.
+func (u *vmmUser) revokeAccess(path string) error {
+	return setUserACL(path, u.uid, 0)
+}
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// modeACL returns the minimal ACL equivalent to mode.
+func modeACL(mode os.FileMode) []aclEntry {
+	return []aclEntry{
+		{aclUserObj, uint16(mode>>6) & 7, aclUndefinedID},
+		{aclGroupObj, uint16(mode>>3) & 7, aclUndefinedID},
+		{aclOther, uint16(mode) & 7, aclUndefinedID},
+	}
+}
+
+func decodeACL(data []byte) ([]aclEntry, error) {
+	if len(data) < aclHeaderSize || (len(data)-aclHeaderSize)%aclEntrySize != 0 ||
+		binary.LittleEndian.Uint32(data) != aclVersion {
+		return nil, fmt.Errorf("Invalid ACL of %d bytes", len(data))
+	}
+
+	var entries []aclEntry
+	for b := data[aclHeaderSize:]; len(b) > 0; b = b[aclEntrySize:] {
+		entries = append(entries, aclEntry{
+			tag:  binary.LittleEndian.Uint16(b),
+			perm: binary.LittleEndian.Uint16(b[2:]),
+			id:   binary.LittleEndian.Uint32(b[4:]),
+		})
+	}
+
+	return entries, nil
+}
+
+func encodeACL(entries []aclEntry) []byte {
+	data := make([]byte, aclHeaderSize, aclHeaderSize+len(entries)*aclEntrySize)
+	binary.LittleEndian.PutUint32(data, aclVersion)
+	for _, e := range entries {
+		var b [aclEntrySize]byte
+		binary.LittleEndian.PutUint16(b[:], e.tag)
+		binary.LittleEndian.PutUint16(b[2:], e.perm)
+		binary.LittleEndian.PutUint32(b[4:], e.id)
+		data = append(data, b[:]...)
+	}
+
+	return data
+}
+
+// withUserEntry returns the ACL entries with the entry of uid set to perm,
+// or removed if perm is 0. The mask is the union of the permissions of the
+// group class, and is dropped along with the last named entry, which
+// brings the minimal ACL back. The entries are sorted by tag and id, as the
+// kernel expects them.
+func withUserEntry(entries []aclEntry, uid uint32, perm uint16) []aclEntry {
+	var acl []aclEntry
+	var mask uint16
+	named := false
+
+	for _, e := range entries {
+		if e.tag == aclMask || (e.tag == aclUser && e.id == uid) {
+			continue
+		}
+		if e.tag == aclUser || e.tag == aclGroup {
+			named = true
+		}
+		if e.tag == aclUser || e.tag == aclGroup || e.tag == aclGroupObj {
+			mask |= e.perm
+		}
+		acl = append(acl, e)
+	}
+
+	if perm != 0 {
+		acl = append(acl, aclEntry{aclUser, perm, uid})
+		mask |= perm
+		named = true
+	}
+
+	if named {
+		acl = append(acl, aclEntry{aclMask, mask, aclUndefinedID})
+	}
+
+	sort.Slice(acl, func(i, j int) bool {
+		if acl[i].tag != acl[j].tag {
+			return acl[i].tag < acl[j].tag
+		}
+		return acl[i].id < acl[j].id
+	})
+
+	return acl
+}
+
+// setUserACL sets the permissions of uid in the ACL of path. Setting the
+// minimal ACL back removes it, and restores the group permissions of the
+// mode, which the mask stood for.
+func setUserACL(path string, uid uint32, perm uint16) error {
+	var entries []aclEntry
+
+	size, err := unix.Getxattr(path, aclXattr, nil)
+	if err == nil {
+		data := make([]byte, size)
+		if size, err = unix.Getxattr(path, aclXattr, data); err == nil {
+			entries, err = decodeACL(data[:size])
+		}
+	}
+
+	switch {
+	case err == unix.ENODATA:
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		entries = modeACL(fi.Mode())
+	case err != nil:
+		return fmt.Errorf("Could not read the ACL of %s: %v", path, err)
+	}
+
+	if err := unix.Setxattr(path, aclXattr, encodeACL(withUserEntry(entries, uid, perm)), 0); err != nil {
+		return fmt.Errorf("Could not set the ACL of %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// vfioGroupDevice returns the /dev/vfio device of the IOMMU group of dev.
+func vfioGroupDevice(dev *config.VFIODev) (string, error) {
+	sysfsDev := dev.SysfsDev
+	if dev.Type == config.VFIODeviceNormalType {
+		bdf := dev.BDF
+		if strings.Count(bdf, ":") == 1 {
+			bdf = "0000:" + bdf
+		}
+		sysfsDev = filepath.Join(sysPCIDevicesPath, bdf)
+	}
+
+	group, err := os.Readlink(filepath.Join(sysfsDev, "iommu_group"))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(vfioDevicePath, filepath.Base(group)), nil
+}
+
+// qemuRunAs makes QEMU drop its privileges to the user once it opened
+// /dev/kvm, its sockets, pid and log files, memory backends and the
+// devices of the VM, which stay owned by root.
+type qemuRunAs string
+
+// Valid returns true if the user is set.
+func (u qemuRunAs) Valid() bool {
+	return u != ""
+}
+
+// QemuParams returns the qemu parameters dropping the privileges to the
+// user.
+func (u qemuRunAs) QemuParams(config *govmmQemu.Config) []string {
+	return []string{"-runas", string(u)}
+}
+
+// setupVMMMemoryDir creates the directory owned by the hypervisor user
+// where QEMU, once unprivileged, creates the backend files of the hotplugged
+// memory. The directory is removed when the VM is cleaned up.
+func (q *qemu) setupVMMMemoryDir() error {
+	if q.vmmMemoryDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(q.vmmMemoryDir, 0700); err != nil {
+		return err
+	}
+
+	return os.Chown(q.vmmMemoryDir, int(q.vmmUser.uid), int(q.vmmUser.gid))
+}
+
+// grantVMMUserAccess lets the unprivileged QEMU open path, the host file
+// of a device hotplugged after it dropped its privileges. The grant is
+// recorded, to be revoked when the device is unplugged or the VM is
+// cleaned up.
+func (q *qemu) grantVMMUserAccess(path string) error {
+	if q.vmmUser == nil {
+		return nil
+	}
+
+	if err := q.vmmUser.grantAccess(path); err != nil {
+		return err
+	}
+
+	q.state.VMMUserGrants = append(q.state.VMMUserGrants, path)
+	return nil
+}
+
+// revokeVMMUserAccess revokes a grant of path. The ACL entry of the user is
+// only removed with the last grant, as several devices can share a file,
+// e.g. the VFIO devices of an IOMMU group.
+func (q *qemu) revokeVMMUserAccess(path string) {
+	grants := q.state.VMMUserGrants
+	found := false
+	for i := len(grants) - 1; i >= 0; i-- {
+		if grants[i] == path {
+			grants = append(grants[:i], grants[i+1:]...)
+			found = true
+			break
+		}
+	}
+	q.state.VMMUserGrants = grants
+
+	if !found {
+		return
+	}
+
+	for _, p := range grants {
+		if p == path {
+			return
+		}
+	}
+
+	if q.vmmUser == nil {
+		q.Logger().WithField("path", path).Warn("Could not revoke the access of the hypervisor user, it is not configured anymore")
+		return
+	}
+
+	if err := q.vmmUser.revokeAccess(path); err != nil {
+		q.Logger().WithError(err).WithField("path", path).Warn("Could not revoke the access of the hypervisor user")
+	}
+}
+
+// revokeVMMUserGrants revokes the grants of the devices still plugged when
+// the VM is cleaned up.
+func (q *qemu) revokeVMMUserGrants() {
+	for len(q.state.VMMUserGrants) > 0 {
+		q.revokeVMMUserAccess(q.state.VMMUserGrants[0])
+	}
+}
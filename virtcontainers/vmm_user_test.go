@@ -0,0 +1,297 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestLookupVMMUser(t *testing.T) {
+	assert := assert.New(t)
+
+	savedLookup := lookupVMMUserFn
+	defer func() {
+		lookupVMMUserFn = savedLookup
+	}()
+
+	users := map[string]*user.User{
+		"kata-vmm": {Uid: "1001", Gid: "1002"},
+		"root":     {Uid: "0", Gid: "0"},
+		"broken":   {Uid: "foo", Gid: "1002"},
+		"nogroup":  {Uid: "1003", Gid: "bar"},
+	}
+
+	lookupVMMUserFn = func(name string) (*user.User, error) {
+		if u, ok := users[name]; ok {
+			return u, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+
+	u, err := lookupVMMUser("kata-vmm")
+	assert.NoError(err)
+	assert.Equal(&vmmUser{name: "kata-vmm", uid: 1001, gid: 1002}, u)
+
+	for _, name := range []string{"root", "broken", "nogroup", "missing"} {
+		_, err := lookupVMMUser(name)
+		assert.Error(err, "user %s", name)
+	}
+}
+
+func TestWithUserEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	minimal := modeACL(0640)
+	assert.Equal([]aclEntry{
+		{aclUserObj, 6, aclUndefinedID},
+		{aclGroupObj, 4, aclUndefinedID},
+		{aclOther, 0, aclUndefinedID},
+	}, minimal)
+
+	granted := withUserEntry(minimal, 1001, aclRead|aclWrite)
+	entries, err := decodeACL(encodeACL(granted))
+	assert.NoError(err)
+	assert.Equal([]aclEntry{
+		{aclUserObj, 6, aclUndefinedID},
+		{aclUser, 6, 1001},
+		{aclGroupObj, 4, aclUndefinedID},
+		{aclMask, 6, aclUndefinedID},
+		{aclOther, 0, aclUndefinedID},
+	}, entries)
+
+	// The entries of the other users are kept.
+	entries = withUserEntry(append(entries, aclEntry{aclUser, 4, 1000}), 1001, 0)
+	assert.Equal([]aclEntry{
+		{aclUserObj, 6, aclUndefinedID},
+		{aclUser, 4, 1000},
+		{aclGroupObj, 4, aclUndefinedID},
+		{aclMask, 4, aclUndefinedID},
+		{aclOther, 0, aclUndefinedID},
+	}, entries)
+
+	// Revoking the last named entry brings the minimal ACL back.
+	assert.Equal(minimal, withUserEntry(granted, 1001, 0))
+
+	_, err = decodeACL([]byte{1, 0, 0, 0})
+	assert.Error(err)
+	_, err = decodeACL([]byte{2, 0, 0, 0, 1})
+	assert.Error(err)
+}
+
+// aclTestFile returns a file of mode 0600 whose ACL can be set, or skips the
+// test if the filesystem does not support ACLs.
+func aclTestFile(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "device")
+	assert.NoError(t, ioutil.WriteFile(path, nil, 0600))
+	assert.NoError(t, os.Chmod(path, 0600))
+
+	err := unix.Setxattr(path, aclXattr, encodeACL(modeACL(0600)), 0)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		t.Skip("The filesystem does not support ACLs")
+	}
+	assert.NoError(t, err)
+
+	return path
+}
+
+func TestVMMUserGrantAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "vmm-user")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := aclTestFile(t, dir)
+	u := &vmmUser{uid: 1001, gid: 1001}
+
+	assert.NoError(u.grantAccess(path))
+
+	fi, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0660), fi.Mode().Perm())
+	assert.Equal(uint32(os.Getuid()), fi.Sys().(*syscall.Stat_t).Uid)
+
+	assert.NoError(u.revokeAccess(path))
+
+	fi, err = os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+	_, err = unix.Getxattr(path, aclXattr, nil)
+	assert.Equal(unix.ENODATA, err)
+
+	assert.Error(u.grantAccess(filepath.Join(dir, "missing")))
+}
+
+func TestQemuVMMUserGrants(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "vmm-user")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := aclTestFile(t, dir)
+
+	q := &qemu{}
+	assert.NoError(q.grantVMMUserAccess(path))
+	assert.Empty(q.state.VMMUserGrants)
+
+	q.vmmUser = &vmmUser{uid: 1001, gid: 1001}
+
+	// Two devices of the same file.
+	assert.NoError(q.grantVMMUserAccess(path))
+	assert.NoError(q.grantVMMUserAccess(path))
+	assert.Equal([]string{path, path}, q.state.VMMUserGrants)
+
+	q.revokeVMMUserAccess(path)
+	assert.Equal([]string{path}, q.state.VMMUserGrants)
+	_, err = unix.Getxattr(path, aclXattr, nil)
+	assert.NoError(err)
+
+	q.revokeVMMUserGrants()
+	assert.Empty(q.state.VMMUserGrants)
+	_, err = unix.Getxattr(path, aclXattr, nil)
+	assert.Equal(unix.ENODATA, err)
+
+	assert.Error(q.grantVMMUserAccess(filepath.Join(dir, "missing")))
+	assert.Empty(q.state.VMMUserGrants)
+}
+
+func TestFCDriveGrants(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "vmm-user")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := aclTestFile(t, dir)
+	other := filepath.Join(dir, "other")
+	assert.NoError(ioutil.WriteFile(other, nil, 0600))
+
+	fc := &firecracker{}
+	assert.NoError(fc.grantDriveAccess("drive_0", path))
+	assert.Empty(fc.info.DriveGrants)
+
+	fc.vmmUser = &vmmUser{uid: 1001, gid: 1001}
+
+	// Two drives of the same file.
+	assert.NoError(fc.grantDriveAccess("drive_0", path))
+	assert.NoError(fc.grantDriveAccess("drive_1", path))
+	assert.Equal(map[string]string{"drive_0": path, "drive_1": path}, fc.info.DriveGrants)
+
+	fc.revokeDriveAccess("drive_0")
+	_, err = unix.Getxattr(path, aclXattr, nil)
+	assert.NoError(err)
+
+	// Replacing the file of the last drive revokes its grant.
+	assert.NoError(fc.grantDriveAccess("drive_1", other))
+	assert.Equal(map[string]string{"drive_1": other}, fc.info.DriveGrants)
+	_, err = unix.Getxattr(path, aclXattr, nil)
+	assert.Equal(unix.ENODATA, err)
+
+	fc.revokeDriveGrants()
+	assert.Empty(fc.info.DriveGrants)
+	_, err = unix.Getxattr(other, aclXattr, nil)
+	assert.Equal(unix.ENODATA, err)
+
+	assert.Error(fc.grantDriveAccess("drive_0", filepath.Join(dir, "missing")))
+	assert.Empty(fc.info.DriveGrants)
+}
+
+func TestVFIOGroupDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "vmm-user")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedPCI, savedVFIO := sysPCIDevicesPath, vfioDevicePath
+	defer func() {
+		sysPCIDevicesPath, vfioDevicePath = savedPCI, savedVFIO
+	}()
+
+	sysPCIDevicesPath = filepath.Join(dir, "devices")
+	vfioDevicePath = filepath.Join(dir, "vfio")
+
+	pciDev := filepath.Join(sysPCIDevicesPath, "0000:02:00.0")
+	assert.NoError(os.MkdirAll(pciDev, 0755))
+	assert.NoError(os.Symlink("../../../kernel/iommu_groups/15", filepath.Join(pciDev, "iommu_group")))
+
+	assert.NoError(os.MkdirAll(vfioDevicePath, 0755))
+	group := filepath.Join(vfioDevicePath, "15")
+	assert.NoError(ioutil.WriteFile(group, nil, 0600))
+
+	dev := &config.VFIODev{Type: config.VFIODeviceNormalType, BDF: "02:00.0"}
+
+	path, err := vfioGroupDevice(dev)
+	assert.NoError(err)
+	assert.Equal(group, path)
+
+	_, err = vfioGroupDevice(&config.VFIODev{Type: config.VFIODeviceNormalType, BDF: "03:00.0"})
+	assert.Error(err)
+}
+
+func TestQemuCreateSandboxVMMUser(t *testing.T) {
+	assert := assert.New(t)
+
+	savedLookup := lookupVMMUserFn
+	defer func() {
+		lookupVMMUserFn = savedLookup
+	}()
+
+	lookupVMMUserFn = func(name string) (*user.User, error) {
+		return &user.User{Uid: "1001", Gid: "1002"}, nil
+	}
+
+	qemuConfig := newQemuConfig()
+	qemuConfig.VMMUser = "kata-vmm"
+
+	sandbox := &Sandbox{
+		ctx: context.Background(),
+		id:  "testSandbox",
+		config: &SandboxConfig{
+			HypervisorConfig: qemuConfig,
+		},
+	}
+
+	vcStore, err := store.NewVCSandboxStore(sandbox.ctx, sandbox.id)
+	assert.NoError(err)
+	sandbox.store = vcStore
+
+	testQemuPath := filepath.Join(testDir, testHypervisor)
+	_, err = os.Create(testQemuPath)
+	assert.NoError(err)
+
+	parentDir := store.SandboxConfigurationRootPath(sandbox.id)
+	assert.NoError(os.MkdirAll(parentDir, store.DirMode))
+	defer os.RemoveAll(parentDir)
+
+	q := &qemu{}
+	err = q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, sandbox.store)
+	assert.NoError(err)
+	assert.Contains(q.qemuConfig.Devices, qemuRunAs("kata-vmm"))
+	assert.Equal([]string{"-runas", "kata-vmm"}, qemuRunAs("kata-vmm").QemuParams(nil))
+
+	// VM templating is not supported.
+	sandbox.config.HypervisorConfig.BootToBeTemplate = true
+	sandbox.config.HypervisorConfig.MemoryPath = "/tmp/memory"
+	sandbox.config.HypervisorConfig.DevicesStatePath = "/tmp/state"
+
+	q = &qemu{}
+	err = q.createSandbox(context.Background(), sandbox.id, NetworkNamespace{}, &sandbox.config.HypervisorConfig, sandbox.store)
+	assert.Error(err)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+)
+
+const (
+	// watchableMountMaxSize and watchableMountMaxFiles bound the size of
+	// the mounts mirrored into the guest memory, the larger ones are only
+	// shared.
+	watchableMountMaxSize  = 1 << 20
+	watchableMountMaxFiles = 64
+)
+
+// WatchableMountsFeature is the experimental feature letting the agent
+// mirror the small Kubernetes volumes into the guest and keep them in sync.
+var WatchableMountsFeature = exp.Feature{
+	Name:        "watchable_mounts",
+	Description: "Mirror the ConfigMap, Secret and projected volumes into the guest so that their updates trigger inotify events.",
+	ExpRelease:  "2.0",
+}
+
+func init() {
+	if err := exp.Register(WatchableMountsFeature); err != nil {
+		virtLog.WithError(err).Error("failed to register watchable mounts experimental feature")
+	}
+}
+
+// watchableMountSources are the host path elements of the Kubernetes
+// volumes the applications watch for updates, which the guest does not
+// get notified of through 9p or virtio-fs.
+var watchableMountSources = []string{
+	"/kubernetes.io~configmap/",
+	"/kubernetes.io~secret/",
+	"/kubernetes.io~projected/",
+	"/kubernetes.io~downward-api/",
+}
+
+// errNotWatchable is returned for the mounts too large, or empty, to be
+// mirrored into the guest.
+var errNotWatchable = errors.New("mount cannot be watched")
+
+func isWatchableMount(source string) bool {
+	for _, s := range watchableMountSources {
+		if strings.Contains(source, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchableFiles returns the paths, relative to the mount source, of its
+// regular files. The Kubernetes volume internals, whose names start with
+// "..", are skipped.
+func watchableFiles(source string) ([]string, error) {
+	var files []string
+	var size int64
+
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		path := filepath.Join(source, rel)
+
+		// Follow the symbolic links to the current files.
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fi.Mode().IsRegular():
+			size += fi.Size()
+			files = append(files, rel)
+			if size > watchableMountMaxSize || len(files) > watchableMountMaxFiles {
+				return errNotWatchable
+			}
+		case fi.IsDir():
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if rel == "" && strings.HasPrefix(e.Name(), "..") {
+					continue
+				}
+				if err := walk(filepath.Join(rel, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, errNotWatchable
+	}
+
+	return files, nil
+}
+
+// watchableGuestPath returns the guest path the agent mirrors the shared
+// directory sharedGuestPath into.
+func watchableGuestPath(sharedGuestPath string) string {
+	return filepath.Join(kataGuestWatchableDir, filepath.Base(sharedGuestPath))
+}
+
+func (s *Sandbox) watchableMountsEnabled() bool {
+	for _, f := range s.config.Experimental {
+		if f == WatchableMountsFeature && exp.Get(WatchableMountsFeature.Name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isWatched returns whether the bind mount m is mirrored into the guest by
+// the agent, rather than only shared.
+func (c *Container) isWatched(m Mount) bool {
+	if !c.sandbox.watchableMountsEnabled() || !isWatchableMount(m.Source) {
+		return false
+	}
+
+	logger := c.Logger().WithField("source", m.Source)
+
+	if !c.sandbox.state.GuestWatchableBind {
+		logger.Warn("The agent cannot mirror watchable mounts, sharing the mount")
+		return false
+	}
+
+	if _, err := watchableFiles(m.Source); err != nil {
+		logger.WithError(err).Debug("Sharing the mount")
+		return false
+	}
+
+	return true
+}
+
+// shareWatchedMount shares the bind mount m with the guest, for the agent to
+// mirror it into the guest path it returns.
+func (c *Container) shareWatchedMount(m Mount, idx int, hostSharedDir, guestSharedDir string) (string, bool, error) {
+	guestDest, ignore, err := c.shareFiles(m, idx, hostSharedDir, guestSharedDir)
+	if err != nil || ignore {
+		return "", ignore, err
+	}
+
+	c.mounts[idx].WatchableSource = guestDest
+
+	return watchableGuestPath(guestDest), false, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKubernetesVolume writes the files of a Kubernetes volume in dir as
+// kubelet does, in a timestamped directory the files link to.
+func writeKubernetesVolume(t *testing.T, dir, timestamp string, files map[string]string) {
+	assert := assert.New(t)
+
+	dataDir := filepath.Join(dir, timestamp)
+	assert.NoError(os.MkdirAll(dataDir, 0755))
+	for name, content := range files {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dataDir, name), []byte(content), 0644))
+	}
+
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	assert.NoError(os.Symlink(timestamp, tmpLink))
+	assert.NoError(os.Rename(tmpLink, filepath.Join(dir, "..data")))
+
+	for name := range files {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			assert.NoError(os.Symlink(filepath.Join("..data", name), link))
+		}
+	}
+}
+
+func TestIsWatchableMount(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isWatchableMount("/var/lib/kubelet/pods/1234/volumes/kubernetes.io~configmap/config"))
+	assert.True(isWatchableMount("/var/lib/kubelet/pods/1234/volumes/kubernetes.io~secret/token"))
+	assert.False(isWatchableMount("/var/lib/kubelet/pods/1234/volumes/kubernetes.io~empty-dir/data"))
+	assert.False(isWatchableMount("/srv/data"))
+}
+
+func TestWatchableFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "watchable-mount")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// Empty volumes are shared.
+	_, err = watchableFiles(dir)
+	assert.Equal(errNotWatchable, err)
+
+	writeKubernetesVolume(t, dir, "..2019_01", map[string]string{"foo": "1", "bar": "2"})
+
+	files, err := watchableFiles(dir)
+	assert.NoError(err)
+	assert.Len(files, 2)
+	assert.Contains(files, "foo")
+	assert.Contains(files, "bar")
+
+	// So are the large ones.
+	big := strings.Repeat("x", watchableMountMaxSize)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "big"), []byte(big), 0644))
+	_, err = watchableFiles(dir)
+	assert.Equal(errNotWatchable, err)
+
+	files, err = watchableFiles(filepath.Join(dir, "foo"))
+	assert.NoError(err)
+	assert.Len(files, 1)
+	assert.Contains(files, "")
+}
+
+func TestContainerIsWatched(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "watchable-mount")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "kubernetes.io~configmap", "config")
+	assert.NoError(os.MkdirAll(source, 0755))
+	writeKubernetesVolume(t, source, "..2019_01", map[string]string{"foo": "1"})
+
+	other := filepath.Join(dir, "data")
+	assert.NoError(os.MkdirAll(other, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(other, "foo"), []byte("1"), 0644))
+
+	s := &Sandbox{config: &SandboxConfig{}}
+	c := &Container{id: "foo", sandbox: s}
+
+	// The feature is disabled.
+	s.state.GuestWatchableBind = true
+	assert.False(c.isWatched(Mount{Source: source}))
+
+	s.config.Experimental = []exp.Feature{WatchableMountsFeature}
+	assert.True(c.isWatched(Mount{Source: source}))
+	assert.False(c.isWatched(Mount{Source: other}))
+
+	// The agent cannot mirror the mount.
+	s.state.GuestWatchableBind = false
+	assert.False(c.isWatched(Mount{Source: source}))
+}
+
+func TestHandleWatchableMounts(t *testing.T) {
+	assert := assert.New(t)
+
+	k := &kataAgent{}
+	c := &Container{
+		mounts: []Mount{
+			{Source: "/srv/data", HostPath: "/run/kata-containers/shared/sandboxes/foo/foo-0123-data"},
+			{
+				Source:          "/var/lib/kubelet/pods/1234/volumes/kubernetes.io~configmap/config",
+				HostPath:        "/run/kata-containers/shared/sandboxes/foo/foo-4567-config",
+				WatchableSource: filepath.Join(kataGuestSharedDir, "foo-4567-config"),
+			},
+		},
+	}
+
+	storages := k.handleWatchableMounts(c)
+	assert.Len(storages, 1)
+	assert.Equal(kataWatchableBindDevType, storages[0].Driver)
+	assert.Equal(filepath.Join(kataGuestSharedDir, "foo-4567-config"), storages[0].Source)
+	assert.Equal(filepath.Join(kataGuestWatchableDir, "foo-4567-config"), storages[0].MountPoint)
+}
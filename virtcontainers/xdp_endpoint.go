@@ -0,0 +1,335 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
+	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/sirupsen/logrus"
+)
+
+// XDPFeature is the experimental feature forwarding the traffic of the
+// network interfaces selected by annotation through AF_XDP sockets.
+var XDPFeature = exp.Feature{
+	Name:        "af_xdp",
+	Description: "Forward the traffic of the selected network interfaces between AF_XDP sockets and vhost-user devices, instead of TAP devices.",
+	ExpRelease:  "2.0",
+}
+
+func init() {
+	if err := exp.Register(XDPFeature); err != nil {
+		virtLog.WithError(err).Error("failed to register AF_XDP experimental feature")
+	}
+}
+
+// xdpForwarderTimeout is the time the AF_XDP forwarder is given to create
+// its socket.
+var xdpForwarderTimeout = 5 * time.Second
+
+// XDPConfig selects the network interfaces forwarded through AF_XDP
+// sockets, and the forwarder serving them to the guest.
+type XDPConfig struct {
+	// ForwarderPath is the path of the forwarder binary. It binds an
+	// AF_XDP socket to a queue of a network interface and forwards its
+	// traffic to the vhost-user socket it serves.
+	ForwarderPath string
+
+	// Interfaces maps the names of the forwarded network interfaces to
+	// the queue their AF_XDP socket is bound to.
+	Interfaces map[string]uint32
+
+	// SocketDir is the directory the vhost-user sockets are created in,
+	// set by the sandbox.
+	SocketDir string
+}
+
+// selects returns whether the traffic of the network interface name is
+// forwarded through an AF_XDP socket.
+func (c XDPConfig) selects(name string) bool {
+	_, ok := c.Interfaces[name]
+	return ok
+}
+
+// XDPEndpoint is a veth interface whose traffic is forwarded between an
+// AF_XDP socket and a vhost-user device of the guest, by a forwarder in
+// the network namespace. It falls back to the TAP device of the veth
+// endpoint when the forwarder cannot be started.
+type XDPEndpoint struct {
+	VethEndpoint
+
+	// SocketPath is the vhost-user socket served by the forwarder.
+	SocketPath    string
+	Queue         uint32
+	ForwarderPath string
+	ForwarderPid  int
+
+	// ForwarderStartTime identifies the forwarder process along with its
+	// pid, which can be reused once it exited.
+	ForwarderStartTime uint64
+
+	// Fallback is set when the interface is attached through the TAP
+	// device of the veth endpoint.
+	Fallback bool
+}
+
+func createXDPEndpoint(veth *VethEndpoint, xdp XDPConfig) *XDPEndpoint {
+	name := veth.Name()
+
+	return &XDPEndpoint{
+		VethEndpoint:  *veth,
+		SocketPath:    filepath.Join(xdp.SocketDir, "xdp-"+name+".sock"),
+		Queue:         xdp.Interfaces[name],
+		ForwarderPath: xdp.ForwarderPath,
+	}
+}
+
+func (endpoint *XDPEndpoint) logger() *logrus.Entry {
+	return networkLogger().WithFields(logrus.Fields{
+		"interface": endpoint.Name(),
+		"queue":     endpoint.Queue,
+	})
+}
+
+// Type identifies the endpoint as an AF_XDP endpoint.
+func (endpoint *XDPEndpoint) Type() EndpointType {
+	return XDPEndpointType
+}
+
+// HardwareAddr returns the mac address of the veth interface, which the
+// guest interface takes over.
+func (endpoint *XDPEndpoint) HardwareAddr() string {
+	if endpoint.Fallback || endpoint.EndpointProperties.Iface.HardwareAddr == nil {
+		return endpoint.VethEndpoint.HardwareAddr()
+	}
+
+	return endpoint.EndpointProperties.Iface.HardwareAddr.String()
+}
+
+// NetworkPair returns the network pair of the endpoint, only used when it
+// fell back to its TAP device.
+func (endpoint *XDPEndpoint) NetworkPair() *NetworkInterfacePair {
+	if !endpoint.Fallback {
+		return nil
+	}
+
+	return endpoint.VethEndpoint.NetworkPair()
+}
+
+// Attach for the AF_XDP endpoint starts the forwarder and adds its
+// vhost-user socket to the hypervisor, or attaches the veth endpoint if
+// the forwarder cannot be started.
+func (endpoint *XDPEndpoint) Attach(h hypervisor) error {
+	if err := endpoint.startForwarder(); err != nil {
+		endpoint.logger().WithError(err).Warn("Could not start the AF_XDP forwarder, falling back to the TAP device")
+		endpoint.Fallback = true
+		return endpoint.VethEndpoint.Attach(h)
+	}
+
+	d := config.VhostUserDeviceAttrs{
+		DevID:      "xdp-" + endpoint.Name(),
+		SocketPath: endpoint.SocketPath,
+		MacAddress: endpoint.HardwareAddr(),
+		Type:       config.VhostUserNet,
+	}
+
+	if err := h.addDevice(d, vhostuserDev); err != nil {
+		endpoint.stopForwarder()
+		return err
+	}
+
+	endpoint.logger().Info("AF_XDP forwarder attached")
+
+	return nil
+}
+
+// Detach for the AF_XDP endpoint stops the forwarder, or tears down the
+// TAP device the endpoint fell back to.
+func (endpoint *XDPEndpoint) Detach(netNsCreated bool, netNsPath string) error {
+	if endpoint.Fallback {
+		return endpoint.VethEndpoint.Detach(netNsCreated, netNsPath)
+	}
+
+	return endpoint.stopForwarder()
+}
+
+// HotAttach for the AF_XDP endpoint always falls back to the TAP device,
+// the vhost-user devices cannot be hot plugged.
+func (endpoint *XDPEndpoint) HotAttach(h hypervisor) error {
+	endpoint.logger().Warn("AF_XDP endpoints cannot be hot attached, falling back to the TAP device")
+	endpoint.Fallback = true
+
+	return endpoint.VethEndpoint.HotAttach(h)
+}
+
+// HotDetach for the AF_XDP endpoint detaches the TAP device it fell back to.
+func (endpoint *XDPEndpoint) HotDetach(h hypervisor, netNsCreated bool, netNsPath string) error {
+	if !endpoint.Fallback {
+		return fmt.Errorf("XDPEndpoint does not support Hot detach")
+	}
+
+	return endpoint.VethEndpoint.HotDetach(h, netNsCreated, netNsPath)
+}
+
+// startForwarder starts the forwarder in the current network namespace
+// and waits for its vhost-user socket.
+func (endpoint *XDPEndpoint) startForwarder() error {
+	if endpoint.ForwarderPath == "" {
+		return fmt.Errorf("AF_XDP forwarder path is empty")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(endpoint.SocketPath), store.DirMode); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(endpoint.ForwarderPath,
+		"--interface", endpoint.Name(),
+		"--queue", strconv.FormatUint(uint64(endpoint.Queue), 10),
+		"--socket", endpoint.SocketPath)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// The forwarder is not reaped yet, its start time can be read even
+	// if it exited already.
+	startTime, err := processStartTime(cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	endpoint.ForwarderPid = cmd.Process.Pid
+	endpoint.ForwarderStartTime = startTime
+
+	if err := waitXDPSocket(endpoint.SocketPath, exited); err != nil {
+		endpoint.stopForwarder()
+		return err
+	}
+
+	return nil
+}
+
+func waitXDPSocket(path string, exited <-chan error) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	timeout := time.After(xdpForwarderTimeout)
+
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+
+		select {
+		case err := <-exited:
+			return fmt.Errorf("AF_XDP forwarder exited: %v", err)
+		case <-timeout:
+			return fmt.Errorf("AF_XDP forwarder did not create %s after %v", path, xdpForwarderTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (endpoint *XDPEndpoint) stopForwarder() error {
+	if endpoint.ForwarderPid > 0 {
+		if err := killProcess(endpoint.ForwarderPid, endpoint.ForwarderStartTime); err != nil {
+			return err
+		}
+		endpoint.ForwarderPid = 0
+		endpoint.ForwarderStartTime = 0
+	}
+
+	if err := os.Remove(endpoint.SocketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (endpoint *XDPEndpoint) save() persistapi.NetworkEndpoint {
+	netpair := saveNetIfPair(&endpoint.NetPair)
+
+	return persistapi.NetworkEndpoint{
+		Type: string(endpoint.Type()),
+		XDP: &persistapi.XDPEndpoint{
+			NetPair:      *netpair,
+			PCIAddr:      endpoint.PCIAddr,
+			SocketPath:   endpoint.SocketPath,
+			Queue:        endpoint.Queue,
+			ForwarderPid: endpoint.ForwarderPid,
+			Fallback:     endpoint.Fallback,
+
+			ForwarderStartTime: endpoint.ForwarderStartTime,
+		},
+	}
+}
+
+func (endpoint *XDPEndpoint) load(s persistapi.NetworkEndpoint) {
+	endpoint.EndpointType = VethEndpointType
+
+	if s.XDP != nil {
+		netpair := loadNetIfPair(&s.XDP.NetPair)
+		endpoint.NetPair = *netpair
+		endpoint.PCIAddr = s.XDP.PCIAddr
+		endpoint.SocketPath = s.XDP.SocketPath
+		endpoint.Queue = s.XDP.Queue
+		endpoint.ForwarderPid = s.XDP.ForwarderPid
+		endpoint.ForwarderStartTime = s.XDP.ForwarderStartTime
+		endpoint.Fallback = s.XDP.Fallback
+	}
+}
+
+// setupXDP checks that the interfaces selected for the AF_XDP datapath can
+// use it, and sets the directory of their sockets. They are attached
+// through their TAP device otherwise.
+func (s *Sandbox) setupXDP() {
+	xdp := &s.config.NetworkConfig.XDP
+	if len(xdp.Interfaces) == 0 {
+		return
+	}
+
+	var reason string
+	switch {
+	case !s.xdpEnabled():
+		reason = "the af_xdp experimental feature is not enabled"
+	case s.config.HypervisorType != QemuHypervisor:
+		reason = "only QEMU supports vhost-user network devices"
+	case s.config.HypervisorConfig.SharedFS != config.VirtioFS && s.config.HypervisorConfig.FileBackedMemRootDir == "":
+		reason = "vhost-user network devices require the guest memory to be shared"
+	}
+
+	if reason != "" {
+		s.Logger().WithField("reason", reason).Warn("Not using the AF_XDP datapath")
+		xdp.Interfaces = nil
+		return
+	}
+
+	xdp.SocketDir = s.tmp().dir()
+}
+
+func (s *Sandbox) xdpEnabled() bool {
+	for _, f := range s.config.Experimental {
+		if f == XDPFeature && exp.Get(XDPFeature.Name) != nil {
+			return true
+		}
+	}
+
+	return false
+}
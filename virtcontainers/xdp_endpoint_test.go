@@ -0,0 +1,169 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kata-containers/runtime/virtcontainers/device/config"
+	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// writeXDPForwarder writes a forwarder script running body, its last
+// argument being the socket path.
+func writeXDPForwarder(t *testing.T, dir, body string) string {
+	path := filepath.Join(dir, "forwarder")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\nfor sock; do :; done\n"+body+"\n"), 0755)
+	assert.NoError(t, err)
+
+	return path
+}
+
+func TestXDPEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	veth, err := createVethNetworkEndpoint(0, "eth0", NetXConnectTCFilterModel)
+	assert.NoError(err)
+
+	xdp := XDPConfig{
+		ForwarderPath: "/usr/bin/forwarder",
+		Interfaces:    map[string]uint32{"eth0": 2},
+		SocketDir:     "/run/vc/vm/sandbox",
+	}
+	assert.True(xdp.selects("eth0"))
+	assert.False(xdp.selects("eth1"))
+
+	endpoint := createXDPEndpoint(veth, xdp)
+	assert.Equal(XDPEndpointType, endpoint.Type())
+	assert.Equal("eth0", endpoint.Name())
+	assert.Equal(uint32(2), endpoint.Queue)
+	assert.Equal("/run/vc/vm/sandbox/xdp-eth0.sock", endpoint.SocketPath)
+	assert.Nil(endpoint.NetworkPair())
+
+	hwAddr, err := net.ParseMAC("02:00:ca:fe:00:01")
+	assert.NoError(err)
+	endpoint.SetProperties(NetworkInfo{Iface: NetlinkIface{LinkAttrs: netlink.LinkAttrs{Name: "eth0", HardwareAddr: hwAddr}}})
+	assert.Equal("02:00:ca:fe:00:01", endpoint.HardwareAddr())
+
+	endpoint.Fallback = true
+	assert.Equal(&endpoint.NetPair, endpoint.NetworkPair())
+
+	name, reversed, ok := endpointStatsLink(endpoint)
+	assert.True(ok)
+	assert.False(reversed)
+	assert.Equal("eth0", name)
+
+	// The hypervisor code handles the veth endpoint the endpoint falls
+	// back to.
+	assert.Equal(VethEndpointType, endpoint.VethEndpoint.Type())
+
+	endpoint.ForwarderPid = 1234
+	endpoint.ForwarderStartTime = 5678
+	saved := endpoint.save()
+	assert.Equal(string(XDPEndpointType), saved.Type)
+
+	var loaded XDPEndpoint
+	loaded.load(saved)
+	assert.Equal(endpoint.SocketPath, loaded.SocketPath)
+	assert.Equal(endpoint.Queue, loaded.Queue)
+	assert.Equal(endpoint.ForwarderPid, loaded.ForwarderPid)
+	assert.Equal(endpoint.ForwarderStartTime, loaded.ForwarderStartTime)
+	assert.True(loaded.Fallback)
+	assert.Equal(VethEndpointType, loaded.VethEndpoint.Type())
+}
+
+func TestXDPEndpointForwarder(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "xdp")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	savedTimeout := xdpForwarderTimeout
+	xdpForwarderTimeout = 500 * time.Millisecond
+	defer func() {
+		xdpForwarderTimeout = savedTimeout
+	}()
+
+	veth, err := createVethNetworkEndpoint(0, "eth0", NetXConnectTCFilterModel)
+	assert.NoError(err)
+
+	endpoint := createXDPEndpoint(veth, XDPConfig{
+		Interfaces: map[string]uint32{"eth0": 0},
+		SocketDir:  filepath.Join(dir, "sandbox"),
+	})
+
+	// No forwarder configured.
+	assert.Error(endpoint.startForwarder())
+
+	// The forwarder exits, or never creates its socket.
+	endpoint.ForwarderPath = writeXDPForwarder(t, dir, "exit 1")
+	assert.Error(endpoint.startForwarder())
+	assert.Zero(endpoint.ForwarderPid)
+
+	endpoint.ForwarderPath = writeXDPForwarder(t, dir, "sleep 10")
+	assert.Error(endpoint.startForwarder())
+	assert.Zero(endpoint.ForwarderPid)
+
+	endpoint.ForwarderPath = writeXDPForwarder(t, dir, "touch \"$sock\"\nsleep 10")
+	assert.NoError(endpoint.Attach(&mockHypervisor{}))
+	assert.False(endpoint.Fallback)
+	assert.True(endpoint.ForwarderPid > 0)
+	_, err = os.Stat(endpoint.SocketPath)
+	assert.NoError(err)
+
+	// A forwarder whose pid was reused is not killed.
+	pid, startTime := endpoint.ForwarderPid, endpoint.ForwarderStartTime
+	endpoint.ForwarderStartTime++
+	assert.NoError(endpoint.stopForwarder())
+	assert.False(processExited(pid))
+
+	endpoint.ForwarderPid, endpoint.ForwarderStartTime = pid, startTime
+	assert.NoError(endpoint.Detach(false, ""))
+	assert.Zero(endpoint.ForwarderPid)
+	assert.Zero(endpoint.ForwarderStartTime)
+	_, err = os.Stat(endpoint.SocketPath)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestSandboxSetupXDP(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{
+		id: "sandbox",
+		config: &SandboxConfig{
+			HypervisorType: QemuHypervisor,
+			HypervisorConfig: HypervisorConfig{
+				SharedFS: config.VirtioFS,
+			},
+			NetworkConfig: NetworkConfig{
+				XDP: XDPConfig{Interfaces: map[string]uint32{"eth0": 0}},
+			},
+		},
+	}
+
+	// The experimental feature is not enabled.
+	s.setupXDP()
+	assert.Empty(s.config.NetworkConfig.XDP.Interfaces)
+
+	s.config.Experimental = []exp.Feature{XDPFeature}
+	s.config.NetworkConfig.XDP.Interfaces = map[string]uint32{"eth0": 0}
+	s.setupXDP()
+	assert.NotEmpty(s.config.NetworkConfig.XDP.Interfaces)
+	assert.Equal(sandboxTmpDir(s.id), s.config.NetworkConfig.XDP.SocketDir)
+
+	// The guest memory is not shared.
+	s.config.HypervisorConfig.SharedFS = config.Virtio9P
+	s.setupXDP()
+	assert.Empty(s.config.NetworkConfig.XDP.Interfaces)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The commands, types and constants of the bpf system call the forwarder
+// uses, from include/uapi/linux/bpf.h.
+const (
+	bpfMapCreate     = 0
+	bpfMapUpdateElem = 2
+	bpfProgLoad      = 5
+	bpfLinkCreate    = 28
+
+	bpfMapTypeXSKMap = 17
+	bpfProgTypeXDP   = 6
+	bpfAttachXDP     = 37
+
+	bpfPseudoMapFD = 1
+
+	// bpfFuncRedirectMap is the id of the bpf_redirect_map helper.
+	bpfFuncRedirectMap = 51
+)
+
+// The opcodes of the instructions of the XDP program.
+const (
+	bpfLdxMemW  = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	bpfLdImm64  = 0x18 // BPF_LD | BPF_IMM | BPF_DW
+	bpfMovImm64 = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	bpfCall     = 0x85 // BPF_JMP | BPF_CALL
+	bpfExit     = 0x95 // BPF_JMP | BPF_EXIT
+)
+
+// bpfObjNameLen is the size of the names of the maps and programs.
+const bpfObjNameLen = 16
+
+var nativeLittleEndian = func() bool {
+	i := uint16(1)
+	return *(*byte)(unsafe.Pointer(&i)) == 1
+}()
+
+// bpfInsn is an instruction of a BPF program, struct bpf_insn.
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// newBPFInsn encodes the registers in the bit fields of struct bpf_insn,
+// whose order follows the byte order.
+func newBPFInsn(code, dst, src uint8, off int16, imm int32) bpfInsn {
+	regs := dst&0xf | src<<4
+	if !nativeLittleEndian {
+		regs = dst<<4 | src&0xf
+	}
+
+	return bpfInsn{code: code, regs: regs, off: off, imm: imm}
+}
+
+type bpfMapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+	innerMapFd uint32
+	numaNode   uint32
+	mapName    [bpfObjNameLen]byte
+}
+
+type bpfMapUpdateElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+	progName    [bpfObjNameLen]byte
+}
+
+type bpfLinkCreateAttr struct {
+	progFd     uint32
+	targetFd   uint32
+	attachType uint32
+	flags      uint32
+}
+
+func bpfObjName(name string) (n [bpfObjNameLen]byte) {
+	copy(n[:bpfObjNameLen-1], name)
+	return n
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+// newXSKMap creates a map of AF_XDP sockets, indexed by the queues.
+func newXSKMap(name string, maxEntries uint32) (int, error) {
+	attr := bpfMapCreateAttr{
+		mapType:    bpfMapTypeXSKMap,
+		keySize:    4,
+		valueSize:  4,
+		maxEntries: maxEntries,
+		mapName:    bpfObjName(name),
+	}
+
+	return bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func bpfMapUpdate(mapFd int, key, value uint32) error {
+	attr := bpfMapUpdateElemAttr{
+		mapFd: uint32(mapFd),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+
+	_, err := bpf(bpfMapUpdateElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&key)
+	runtime.KeepAlive(&value)
+
+	return err
+}
+
+// loadXDPRedirect loads the XDP program redirecting the packets of the
+// queues to the sockets of the map, and letting the ones of the queues
+// without socket through the kernel.
+func loadXDPRedirect(name string, mapFd int) (int, error) {
+	insns := []bpfInsn{
+		// r2 = ctx->rx_queue_index
+		newBPFInsn(bpfLdxMemW, 2, 1, xdpRxQueueIndexOffset, 0),
+		// r1 = map, on two instructions
+		newBPFInsn(bpfLdImm64, 1, bpfPseudoMapFD, 0, int32(mapFd)),
+		newBPFInsn(0, 0, 0, 0, 0),
+		// r3 = XDP_PASS, the action of the missing entries
+		newBPFInsn(bpfMovImm64, 3, 0, 0, xdpPass),
+		// return bpf_redirect_map(r1, r2, r3)
+		newBPFInsn(bpfCall, 0, 0, 0, bpfFuncRedirectMap),
+		newBPFInsn(bpfExit, 0, 0, 0, 0),
+	}
+	license := []byte("Apache-2.0\x00")
+
+	attr := bpfProgLoadAttr{
+		progType: bpfProgTypeXDP,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		progName: bpfObjName(name),
+	}
+
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+
+	return fd, err
+}
+
+// attachXDP attaches the XDP program to the network interface through a
+// BPF link, which the kernel detaches once its fd is closed.
+func attachXDP(progFd, ifindex int) (int, error) {
+	attr := bpfLinkCreateAttr{
+		progFd:     uint32(progFd),
+		targetFd:   uint32(ifindex),
+		attachType: bpfAttachXDP,
+	}
+
+	return bpf(bpfLinkCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBPFAttrLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	// The sizes of the fields of union bpf_attr the commands use.
+	assert.Equal(uintptr(8), unsafe.Sizeof(bpfInsn{}))
+	assert.Equal(uintptr(44), unsafe.Sizeof(bpfMapCreateAttr{}))
+	assert.Equal(uintptr(32), unsafe.Sizeof(bpfMapUpdateElemAttr{}))
+	assert.Equal(uintptr(64), unsafe.Sizeof(bpfProgLoadAttr{}))
+	assert.Equal(uintptr(16), unsafe.Sizeof(bpfLinkCreateAttr{}))
+}
+
+func TestNewBPFInsn(t *testing.T) {
+	assert := assert.New(t)
+
+	insn := newBPFInsn(bpfLdxMemW, 2, 1, xdpRxQueueIndexOffset, 0)
+	assert.Equal(uint8(bpfLdxMemW), insn.code)
+	assert.Equal(int16(xdpRxQueueIndexOffset), insn.off)
+
+	if nativeLittleEndian {
+		assert.Equal(uint8(0x12), insn.regs)
+	} else {
+		assert.Equal(uint8(0x21), insn.regs)
+	}
+
+	assert.Equal(uint8('k'), bpfObjName("kata_xdp_redirect_long")[0])
+	assert.Equal(uint8(0), bpfObjName("kata_xdp_redirect_long")[bpfObjNameLen-1])
+}
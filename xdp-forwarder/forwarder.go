@@ -0,0 +1,328 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// forwarder forwards the frames between a port and the vhost-user device
+// it serves to one frontend at a time. Everything runs in the loop of run,
+// which owns the device and its queues.
+type forwarder struct {
+	port     port
+	listener int
+	conn     int
+	dev      *device
+
+	// done is signaled to stop the loop.
+	done int
+
+	// rxBlocked is set when the guest has no buffer for the received
+	// frames: the port is not polled until the guest kicks the rx
+	// queue.
+	rxBlocked bool
+
+	logger *logrus.Entry
+}
+
+func newForwarder(p port, listener int, logger *logrus.Entry) (*forwarder, error) {
+	done, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forwarder{
+		port:     p,
+		listener: listener,
+		conn:     -1,
+		dev:      newDevice(),
+		done:     done,
+		logger:   logger,
+	}, nil
+}
+
+// stop stops the loop, from any goroutine.
+func (f *forwarder) stop() error {
+	return signalEventFd(f.done)
+}
+
+type pollHandler struct {
+	fd     int
+	handle func() error
+}
+
+func (f *forwarder) handlers() []pollHandler {
+	handlers := []pollHandler{{fd: f.done}}
+
+	if f.conn < 0 {
+		return append(handlers, pollHandler{f.listener, f.accept})
+	}
+
+	handlers = append(handlers, pollHandler{f.conn, f.handleMessage})
+
+	rx := &f.dev.queues[rxQueue]
+	if rx.ready() {
+		handlers = append(handlers, pollHandler{rx.kick, func() error {
+			if err := drainEventFd(rx.kick); err != nil {
+				return err
+			}
+			f.rxBlocked = false
+			return f.receive()
+		}})
+
+		if !f.rxBlocked {
+			handlers = append(handlers, pollHandler{f.port.fd(), f.receive})
+		}
+	}
+
+	tx := &f.dev.queues[txQueue]
+	if tx.ready() {
+		handlers = append(handlers, pollHandler{tx.kick, func() error {
+			if err := drainEventFd(tx.kick); err != nil {
+				return err
+			}
+			return f.transmit()
+		}})
+	}
+
+	return handlers
+}
+
+// run forwards the frames until stopped.
+func (f *forwarder) run() error {
+	defer f.disconnect()
+
+	for {
+		handlers := f.handlers()
+
+		fds := make([]unix.PollFd, len(handlers))
+		for i, h := range handlers {
+			fds[i] = unix.PollFd{Fd: int32(h.fd), Events: unix.POLLIN}
+		}
+
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+
+		if fds[0].Revents != 0 {
+			return nil
+		}
+
+		for i, h := range handlers[1:] {
+			if fds[i+1].Revents == 0 {
+				continue
+			}
+
+			if err := h.handle(); err != nil {
+				f.logger.WithError(err).Error("Disconnecting the frontend")
+				f.disconnect()
+				break
+			}
+
+			// The frontend may have changed the queues, the other
+			// handlers are polled again.
+			if i == 0 {
+				break
+			}
+		}
+	}
+}
+
+func (f *forwarder) accept() error {
+	conn, _, err := unix.Accept4(f.listener, unix.SOCK_CLOEXEC)
+	if err != nil {
+		if err == unix.EAGAIN || err == unix.EINTR {
+			return nil
+		}
+		return err
+	}
+
+	f.logger.Info("Frontend connected")
+	f.conn = conn
+
+	return nil
+}
+
+// disconnect resets the device, for the frontend to reconnect.
+func (f *forwarder) disconnect() {
+	if f.conn < 0 {
+		return
+	}
+
+	f.dev.reset()
+	closeFd(&f.conn)
+	f.rxBlocked = false
+}
+
+func (f *forwarder) handleMessage() error {
+	msg, err := readMessage(f.conn)
+	if err == io.EOF {
+		f.logger.Info("Frontend disconnected")
+		f.disconnect()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	f.logger.WithField("request", msg.request).Debug("vhost-user request")
+
+	reply, err := f.dev.handle(msg)
+	if err != nil {
+		return err
+	}
+
+	if reply != nil {
+		return writeReply(f.conn, msg.request, reply)
+	}
+
+	return nil
+}
+
+// receive copies the frames received by the port to the buffers of the
+// rx queue, behind a virtio_net_hdr without offload.
+func (f *forwarder) receive() error {
+	rx := &f.dev.queues[rxQueue]
+	if !rx.ready() {
+		return nil
+	}
+
+	var used int
+	var popErr error
+
+	err := f.port.receive(func(frame []byte) bool {
+		c, ok, err := rx.pop(f.dev.memory)
+		if err != nil {
+			popErr = err
+			return false
+		}
+		if !ok {
+			f.rxBlocked = true
+			return false
+		}
+
+		var hdr [virtioNetHdrSize]byte
+		binary.LittleEndian.PutUint16(hdr[10:], 1)
+
+		n, complete := c.write(hdr[:], frame)
+		if !complete {
+			f.logger.WithField("size", len(frame)).Debug("Dropping a frame larger than the guest buffer")
+			n = 0
+		}
+
+		rx.push(c.head, uint32(n))
+		used++
+
+		return true
+	})
+	if err == nil {
+		err = popErr
+	}
+
+	if used > 0 {
+		if nerr := rx.notify(); err == nil {
+			err = nerr
+		}
+	}
+
+	return err
+}
+
+// transmit transmits the frames of the chains of the tx queue, stripped of
+// their virtio_net_hdr.
+func (f *forwarder) transmit() error {
+	tx := &f.dev.queues[txQueue]
+
+	var used int
+	var err error
+
+	for {
+		c, ok, perr := tx.pop(f.dev.memory)
+		if perr != nil {
+			err = perr
+			break
+		}
+		if !ok {
+			break
+		}
+
+		frame := c.read(virtioNetHdrSize)
+		if terr := f.port.transmit(frame); terr != nil {
+			f.logger.WithError(terr).Debug("Dropping a frame")
+		}
+
+		tx.push(c.head, 0)
+		used++
+	}
+
+	if used > 0 {
+		if ferr := f.port.flush(); ferr != nil {
+			f.logger.WithError(ferr).Warn("Could not transmit the frames")
+		}
+
+		if nerr := tx.notify(); err == nil {
+			err = nerr
+		}
+	}
+
+	return err
+}
+
+// write writes the parts to the writable buffers of the chain, returning
+// the number of bytes written and whether they all fit.
+func (c *chain) write(parts ...[]byte) (int, bool) {
+	var n int
+	bufs := c.buffers
+
+	for _, p := range parts {
+		for len(p) > 0 {
+			for len(bufs) > 0 && (!bufs[0].write || len(bufs[0].data) == 0) {
+				bufs = bufs[1:]
+			}
+			if len(bufs) == 0 {
+				return n, false
+			}
+
+			m := copy(bufs[0].data, p)
+			bufs[0].data = bufs[0].data[m:]
+			p = p[m:]
+			n += m
+		}
+	}
+
+	return n, true
+}
+
+// read returns the content of the readable buffers of the chain, skipping
+// its first skip bytes.
+func (c *chain) read(skip int) []byte {
+	var data []byte
+
+	for _, b := range c.buffers {
+		if b.write {
+			continue
+		}
+
+		d := b.data
+		if skip >= len(d) {
+			skip -= len(d)
+			continue
+		}
+
+		data = append(data, d[skip:]...)
+		skip = 0
+	}
+
+	return data
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	testMemorySize = 1 << 20
+	testUserAddr   = 0x7f0000000000
+	testQueueSize  = 8
+)
+
+// seqpacketPort is a port whose frames are the packets of a socket.
+type seqpacketPort struct {
+	sock int
+}
+
+func (p *seqpacketPort) fd() int {
+	return p.sock
+}
+
+func (p *seqpacketPort) receive(fn func(frame []byte) bool) error {
+	buf := make([]byte, 65536)
+
+	for {
+		n, _, err := unix.Recvfrom(p.sock, buf, unix.MSG_PEEK|unix.MSG_DONTWAIT)
+		if err == unix.EAGAIN {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fn(buf[:n]) {
+			return nil
+		}
+
+		if _, _, err := unix.Recvfrom(p.sock, buf, unix.MSG_DONTWAIT); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *seqpacketPort) transmit(frame []byte) error {
+	_, err := unix.Write(p.sock, frame)
+	return err
+}
+
+func (p *seqpacketPort) flush() error {
+	return nil
+}
+
+func (p *seqpacketPort) close() error {
+	return unix.Close(p.sock)
+}
+
+// testQueue is the layout of a queue in the test guest memory.
+type testQueue struct {
+	desc, avail, used uint64
+	kick, call        int
+}
+
+// testFrontend plays the hypervisor, driving the forwarder through its
+// vhost-user socket.
+type testFrontend struct {
+	t      *testing.T
+	conn   int
+	memfd  int
+	mem    []byte
+	queues [numQueues]testQueue
+}
+
+func (fe *testFrontend) send(request uint32, payload []byte, fds ...int) {
+	b := make([]byte, vhostUserHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(b[0:], request)
+	binary.LittleEndian.PutUint32(b[4:], vhostUserVersion)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(payload)))
+	copy(b[vhostUserHeaderSize:], payload)
+
+	var oob []byte
+	if len(fds) > 0 {
+		oob = unix.UnixRights(fds...)
+	}
+
+	err := unix.Sendmsg(fe.conn, b, oob, nil, 0)
+	assert.NoError(fe.t, err)
+}
+
+func (fe *testFrontend) reply(request uint32) []byte {
+	hdr := make([]byte, vhostUserHeaderSize)
+	assert.NoError(fe.t, readFull(fe.conn, hdr))
+	assert.Equal(fe.t, request, binary.LittleEndian.Uint32(hdr))
+	assert.Equal(fe.t, uint32(vhostUserVersion|vhostUserReplyFlag), binary.LittleEndian.Uint32(hdr[4:]))
+
+	payload := make([]byte, binary.LittleEndian.Uint32(hdr[8:]))
+	assert.NoError(fe.t, readFull(fe.conn, payload))
+
+	return payload
+}
+
+func u32s(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+func u64s(values ...uint64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(b[8*i:], v)
+	}
+	return b
+}
+
+// setup negotiates the device, with the rings of queue i at i*0x1000 of
+// the guest memory.
+func (fe *testFrontend) setup() {
+	fe.send(vhostUserGetFeatures, nil)
+	assert.Equal(fe.t, virtioFVersion1, binary.LittleEndian.Uint64(fe.reply(vhostUserGetFeatures)))
+
+	fe.send(vhostUserSetFeatures, u64s(virtioFVersion1))
+	fe.send(vhostUserSetOwner, nil)
+
+	table := append(u32s(1, 0), u64s(0, testMemorySize, testUserAddr, 0)...)
+	fe.send(vhostUserSetMemTable, table, fe.memfd)
+
+	for i := range fe.queues {
+		q := &fe.queues[i]
+		q.desc = uint64(i) * 0x1000
+		q.avail = q.desc + 0x100
+		q.used = q.desc + 0x200
+
+		var err error
+		q.kick, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+		assert.NoError(fe.t, err)
+		q.call, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+		assert.NoError(fe.t, err)
+
+		fe.send(vhostUserSetVringNum, u32s(uint32(i), testQueueSize))
+		fe.send(vhostUserSetVringBase, u32s(uint32(i), 0))
+		fe.send(vhostUserSetVringAddr, append(u32s(uint32(i), 0),
+			u64s(testUserAddr+q.desc, testUserAddr+q.used, testUserAddr+q.avail, 0)...))
+		fe.send(vhostUserSetVringCall, u64s(uint64(i)), q.call)
+		fe.send(vhostUserSetVringKick, u64s(uint64(i)), q.kick)
+	}
+}
+
+// makeAvailable makes the chain of the descriptors available in queue i,
+// and kicks it.
+func (fe *testFrontend) makeAvailable(i int, descs ...[3]uint64) {
+	q := fe.queues[i]
+
+	for n, d := range descs {
+		flags := uint16(d[2])
+		if n < len(descs)-1 {
+			flags |= vringDescFNext
+		}
+
+		desc := fe.mem[q.desc+uint64(n)*vringDescSize:]
+		binary.LittleEndian.PutUint64(desc[0:], d[0])
+		binary.LittleEndian.PutUint32(desc[8:], uint32(d[1]))
+		binary.LittleEndian.PutUint16(desc[12:], flags)
+		binary.LittleEndian.PutUint16(desc[14:], uint16(n+1))
+	}
+
+	avail := fe.mem[q.avail:]
+	idx := binary.LittleEndian.Uint16(avail[2:])
+	binary.LittleEndian.PutUint16(avail[4+2*(idx%testQueueSize):], 0)
+	storeRingIdx(avail, idx+1)
+
+	assert.NoError(fe.t, signalEventFd(q.kick))
+}
+
+// waitUsed waits for the queue i to use its chain, returning the length
+// written to it.
+func (fe *testFrontend) waitUsed(i int, idx uint16) uint32 {
+	q := fe.queues[i]
+	used := fe.mem[q.used:]
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, current := loadRingHeader(used); current == idx {
+			var b [8]byte
+			_, err := unix.Read(q.call, b[:])
+			assert.NoError(fe.t, err, "the guest was not interrupted")
+
+			return binary.LittleEndian.Uint32(used[4+8*((idx-1)%testQueueSize)+4:])
+		}
+	}
+
+	fe.t.Fatalf("queue %d did not use its buffers", i)
+	return 0
+}
+
+func newTestForwarder(t *testing.T) (*forwarder, int, string) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "xdp-forwarder")
+	assert.NoError(err)
+
+	listener, err := listen(filepath.Join(dir, "vhost-user.sock"))
+	assert.NoError(err)
+
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	assert.NoError(err)
+
+	f, err := newForwarder(&seqpacketPort{pair[0]}, listener, logrus.NewEntry(logrus.New()))
+	assert.NoError(err)
+
+	return f, pair[1], dir
+}
+
+func newTestFrontend(t *testing.T, path string) *testFrontend {
+	assert := assert.New(t)
+
+	memfd, err := unix.MemfdCreate("guest", unix.MFD_CLOEXEC)
+	assert.NoError(err)
+	assert.NoError(unix.Ftruncate(memfd, testMemorySize))
+
+	mem, err := unix.Mmap(memfd, 0, testMemorySize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	assert.NoError(err)
+
+	conn, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	assert.NoError(err)
+	assert.NoError(unix.Connect(conn, &unix.SockaddrUnix{Name: path}))
+
+	return &testFrontend{t: t, conn: conn, memfd: memfd, mem: mem}
+}
+
+func TestForwarder(t *testing.T) {
+	assert := assert.New(t)
+
+	f, peer, dir := newTestForwarder(t)
+	defer os.RemoveAll(dir)
+	defer unix.Close(peer)
+
+	done := make(chan error)
+	go func() {
+		done <- f.run()
+	}()
+
+	fe := newTestFrontend(t, filepath.Join(dir, "vhost-user.sock"))
+	defer unix.Munmap(fe.mem)
+	fe.setup()
+
+	// A frame received on the port goes to the rx buffer of the guest,
+	// behind its virtio_net_hdr, once the guest provides the buffer.
+	frame := []byte("received frame")
+	_, err := unix.Write(peer, frame)
+	assert.NoError(err)
+
+	fe.makeAvailable(rxQueue, [3]uint64{0x10000, 2048, vringDescFWrite})
+	length := fe.waitUsed(rxQueue, 1)
+	assert.Equal(uint32(virtioNetHdrSize+len(frame)), length)
+	assert.Equal(uint16(1), binary.LittleEndian.Uint16(fe.mem[0x10000+10:]))
+	assert.Equal(frame, fe.mem[0x10000+virtioNetHdrSize:0x10000+virtioNetHdrSize+len(frame)])
+
+	// A frame larger than the buffer of the guest is dropped.
+	_, err = unix.Write(peer, make([]byte, 128))
+	assert.NoError(err)
+	fe.makeAvailable(rxQueue, [3]uint64{0x10000, 64, vringDescFWrite})
+	assert.Equal(uint32(0), fe.waitUsed(rxQueue, 2))
+
+	// A frame of the guest is transmitted without its virtio_net_hdr,
+	// whichever descriptors it spans.
+	copy(fe.mem[0x20000:], "transmitted")
+	copy(fe.mem[0x30000:], " frame")
+	fe.makeAvailable(txQueue,
+		[3]uint64{0x20100, virtioNetHdrSize, 0},
+		[3]uint64{0x20000, 11, 0},
+		[3]uint64{0x30000, 6, 0})
+	fe.waitUsed(txQueue, 1)
+
+	buf := make([]byte, 2048)
+	n, err := unix.Read(peer, buf)
+	assert.NoError(err)
+	assert.Equal("transmitted frame", string(buf[:n]))
+
+	// Stopping a queue returns its next available descriptor.
+	fe.send(vhostUserGetVringBase, u32s(rxQueue, 0))
+	assert.Equal(u32s(rxQueue, 2), fe.reply(vhostUserGetVringBase))
+
+	// The device is reset when the frontend disconnects, and a new
+	// frontend can connect.
+	assert.NoError(unix.Close(fe.conn))
+
+	fe = newTestFrontend(t, filepath.Join(dir, "vhost-user.sock"))
+	defer unix.Munmap(fe.mem)
+	fe.setup()
+
+	fe.send(vhostUserGetVringBase, u32s(txQueue, 0))
+	assert.Equal(u32s(txQueue, 0), fe.reply(vhostUserGetVringBase))
+
+	assert.NoError(f.stop())
+	assert.NoError(<-done)
+}
+
+func TestForwarderInvalidRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	f, peer, dir := newTestForwarder(t)
+	defer os.RemoveAll(dir)
+	defer unix.Close(peer)
+
+	done := make(chan error)
+	go func() {
+		done <- f.run()
+	}()
+
+	fe := newTestFrontend(t, filepath.Join(dir, "vhost-user.sock"))
+	defer unix.Munmap(fe.mem)
+
+	// The frontend is disconnected when it requests a feature the
+	// device does not offer.
+	fe.send(vhostUserSetFeatures, u64s(virtioFVersion1|1))
+
+	var b [1]byte
+	n, err := unix.Read(fe.conn, b[:])
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	assert.NoError(f.stop())
+	assert.NoError(<-done)
+}
+
+func TestGuestMemoryTranslate(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make([]byte, 0x2000)
+	mem := guestMemory{
+		{guestAddr: 0x1000, userAddr: 0x10000, data: data[:0x1000]},
+		{guestAddr: 0x4000, userAddr: 0x20000, data: data[0x1000:]},
+	}
+
+	b, err := mem.translate(0x4010, 16)
+	assert.NoError(err)
+	assert.Equal(&data[0x1010], &b[0])
+	assert.Equal(16, cap(b))
+
+	b, err = mem.translateUser(0x10ff0, 16)
+	assert.NoError(err)
+	assert.Equal(&data[0xff0], &b[0])
+
+	// The buffers cannot span regions, nor be out of them.
+	_, err = mem.translate(0x1ff0, 32)
+	assert.Error(err)
+	_, err = mem.translate(0x2000, 1)
+	assert.Error(err)
+	_, err = mem.translateUser(0x4010, 1)
+	assert.Error(err)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"golang.org/x/sys/unix"
+)
+
+const forwarderName = "kata-xdp-forwarder"
+
+var (
+	// version is the forwarder version. This variable is populated at
+	// build time.
+	version = "unknown"
+
+	forwarderLog = logrus.New()
+)
+
+type forwarderParams struct {
+	iface      string
+	queue      uint
+	socketPath string
+	logLevel   string
+}
+
+func printVersion() {
+	fmt.Printf("%s version %s\n", forwarderName, version)
+}
+
+const componentDescription = `forwards the traffic of a queue of a network interface between an AF_XDP
+socket and a vhost-user net device. It is started by the runtime in the
+network namespace of the sandbox, and serves the device on the vhost-user
+socket the hypervisor connects to.
+`
+
+func parseOptions() forwarderParams {
+	var version, help bool
+
+	params := forwarderParams{}
+
+	flag.BoolVar(&help, "h", false, "describe component usage")
+	flag.BoolVar(&help, "help", false, "")
+	flag.BoolVar(&version, "v", false, "display program version and exit")
+	flag.BoolVar(&version, "version", false, "")
+	flag.StringVar(&params.iface, "interface", "", "network interface (required)")
+	flag.UintVar(&params.queue, "queue", 0, "queue of the network interface")
+	flag.StringVar(&params.socketPath, "socket", "", "vhost-user socket path (required)")
+	flag.StringVar(&params.logLevel, "log", "warn",
+		"log messages above specified level: debug, warn, error, fatal or panic")
+
+	flag.Parse()
+
+	if help {
+		fmt.Printf("\n%s %s\n", forwarderName, componentDescription)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	if version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if params.iface == "" || params.socketPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: the network interface and the socket path must be provided\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	return params
+}
+
+func setupLogger(params forwarderParams) (*logrus.Entry, error) {
+	level, err := logrus.ParseLevel(params.logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarderLog.SetLevel(level)
+	forwarderLog.Formatter = &logrus.TextFormatter{TimestampFormat: time.RFC3339Nano}
+
+	hook, err := lSyslog.NewSyslogHook("", "", syslog.LOG_INFO|syslog.LOG_USER, forwarderName)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarderLog.AddHook(hook)
+
+	return forwarderLog.WithFields(logrus.Fields{
+		"name":      forwarderName,
+		"pid":       os.Getpid(),
+		"source":    "xdp-forwarder",
+		"interface": params.iface,
+		"queue":     params.queue,
+	}), nil
+}
+
+// listen creates the vhost-user socket. The runtime waits for it to exist,
+// so it is only created once the AF_XDP socket is ready.
+func listen(path string) (int, error) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	if err := unix.Listen(fd, 1); err != nil {
+		unix.Close(fd)
+		os.Remove(path)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+func run(params forwarderParams, logger *logrus.Entry) error {
+	x, err := newXSK(params.iface, uint32(params.queue))
+	if err != nil {
+		return err
+	}
+	defer x.close()
+
+	listener, err := listen(params.socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(params.socketPath)
+	defer unix.Close(listener)
+
+	f, err := newForwarder(x, listener, logger)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-sigCh
+		logger.WithField("signal", sig).Info("Stopping")
+		f.stop()
+	}()
+
+	logger.WithField("socket", params.socketPath).Info("Forwarding")
+
+	return f.run()
+}
+
+func main() {
+	params := parseOptions()
+
+	logger, err := setupLogger(params)
+	if err != nil {
+		forwarderLog.WithError(err).Fatal("setupLogger()")
+	}
+
+	if err := run(params, logger); err != nil {
+		logger.WithError(err).Fatal("run()")
+	}
+}
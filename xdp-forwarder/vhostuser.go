@@ -0,0 +1,446 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/sys/unix"
+)
+
+// The requests of the vhost-user protocol the forwarder handles.
+const (
+	vhostUserGetFeatures  = 1
+	vhostUserSetFeatures  = 2
+	vhostUserSetOwner     = 3
+	vhostUserResetOwner   = 4
+	vhostUserSetMemTable  = 5
+	vhostUserSetVringNum  = 8
+	vhostUserSetVringAddr = 9
+	vhostUserSetVringBase = 10
+	vhostUserGetVringBase = 11
+	vhostUserSetVringKick = 12
+	vhostUserSetVringCall = 13
+	vhostUserSetVringErr  = 14
+)
+
+const (
+	vhostUserHeaderSize = 12
+	vhostUserVersion    = 0x1
+	vhostUserReplyFlag  = 0x4
+
+	// vhostUserMaxPayload is the size of the largest message handled,
+	// a memory table of vhostUserMaxRegions.
+	vhostUserMaxPayload = 8 + vhostUserMaxRegions*32
+	vhostUserMaxRegions = 8
+
+	vhostUserVringIdxMask = 0xff
+	vhostUserVringNoFd    = 0x100
+
+	// virtioFVersion1 is the only feature offered: the device has
+	// no offload, and its virtio_net_hdr always has num_buffers.
+	virtioFVersion1 = uint64(1) << 32
+
+	virtioNetHdrSize = 12
+)
+
+// The queues of the device.
+const (
+	rxQueue = iota
+	txQueue
+	numQueues
+)
+
+type vhostUserMsg struct {
+	request uint32
+	payload []byte
+	fds     []int
+}
+
+// closeFds closes the file descriptors of the message not taken by its
+// handler.
+func (msg *vhostUserMsg) closeFds() {
+	for i := range msg.fds {
+		closeFd(&msg.fds[i])
+	}
+}
+
+// takeFd returns the file descriptor of the message, which the caller now
+// owns.
+func (msg *vhostUserMsg) takeFd() (int, error) {
+	if len(msg.fds) != 1 {
+		return -1, fmt.Errorf("Request %d expects one file descriptor, got %d", msg.request, len(msg.fds))
+	}
+
+	fd := msg.fds[0]
+	msg.fds = nil
+
+	return fd, nil
+}
+
+func readFull(fd int, b []byte) error {
+	for len(b) > 0 {
+		n, err := unix.Read(fd, b)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+// readMessage reads a message of the frontend from conn. The file
+// descriptors come along with its header.
+func readMessage(conn int) (*vhostUserMsg, error) {
+	hdr := make([]byte, vhostUserHeaderSize)
+	oob := make([]byte, unix.CmsgSpace(vhostUserMaxRegions*4))
+
+	n, oobn, _, _, err := unix.Recvmsg(conn, hdr, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+
+	msg := &vhostUserMsg{}
+
+	cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	for i := range cmsgs {
+		fds, err := unix.ParseUnixRights(&cmsgs[i])
+		if err != nil {
+			msg.closeFds()
+			return nil, err
+		}
+		msg.fds = append(msg.fds, fds...)
+	}
+
+	if err := readFull(conn, hdr[n:]); err != nil {
+		msg.closeFds()
+		return nil, err
+	}
+
+	msg.request = binary.LittleEndian.Uint32(hdr[0:])
+	size := binary.LittleEndian.Uint32(hdr[8:])
+
+	if size > vhostUserMaxPayload {
+		msg.closeFds()
+		return nil, fmt.Errorf("Request %d has a payload of %d bytes", msg.request, size)
+	}
+
+	msg.payload = make([]byte, size)
+	if err := readFull(conn, msg.payload); err != nil {
+		msg.closeFds()
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeReply replies to the request of the frontend.
+func writeReply(conn int, request uint32, payload []byte) error {
+	b := make([]byte, vhostUserHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(b[0:], request)
+	binary.LittleEndian.PutUint32(b[4:], vhostUserVersion|vhostUserReplyFlag)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(payload)))
+	copy(b[vhostUserHeaderSize:], payload)
+
+	_, err := unix.Write(conn, b)
+
+	return err
+}
+
+// memoryRegion is a region of the guest memory, mapped in the forwarder.
+type memoryRegion struct {
+	guestAddr uint64
+	userAddr  uint64
+	data      []byte
+
+	// mapping is the whole mapping of the file of the region, which
+	// starts at an offset in it.
+	mapping []byte
+}
+
+type guestMemory []memoryRegion
+
+func (m guestMemory) lookup(addr, length uint64, user bool) ([]byte, error) {
+	for _, r := range m {
+		start := r.guestAddr
+		if user {
+			start = r.userAddr
+		}
+
+		if addr < start || addr-start >= uint64(len(r.data)) {
+			continue
+		}
+
+		off := addr - start
+		if length > uint64(len(r.data))-off {
+			break
+		}
+
+		return r.data[off : off+length : off+length], nil
+	}
+
+	return nil, fmt.Errorf("Address %#x+%d is not in the guest memory", addr, length)
+}
+
+// translate maps length bytes at the guest physical address addr.
+func (m guestMemory) translate(addr, length uint64) ([]byte, error) {
+	return m.lookup(addr, length, false)
+}
+
+// translateUser maps length bytes at the address addr of the frontend,
+// which the addresses of the rings are given in.
+func (m guestMemory) translateUser(addr, length uint64) ([]byte, error) {
+	return m.lookup(addr, length, true)
+}
+
+func (m guestMemory) unmap() {
+	for _, r := range m {
+		unix.Munmap(r.mapping)
+	}
+}
+
+// device is the vhost-user net device served to the frontend.
+type device struct {
+	features uint64
+	memory   guestMemory
+	queues   [numQueues]virtqueue
+}
+
+func newDevice() *device {
+	d := &device{}
+	d.reset()
+
+	return d
+}
+
+// reset stops the queues and unmaps the guest memory, when the frontend
+// resets or disconnects.
+func (d *device) reset() {
+	for i := range d.queues {
+		d.queues[i].reset()
+	}
+
+	d.memory.unmap()
+	d.memory = nil
+	d.features = 0
+}
+
+func (d *device) queue(payload []byte, size int) (*virtqueue, error) {
+	if len(payload) < size {
+		return nil, fmt.Errorf("Payload of %d bytes, expected %d", len(payload), size)
+	}
+
+	index := binary.LittleEndian.Uint32(payload) & vhostUserVringIdxMask
+	if index >= numQueues {
+		return nil, fmt.Errorf("Invalid queue %d", index)
+	}
+
+	return &d.queues[index], nil
+}
+
+func payloadU64(payload []byte) (uint64, error) {
+	if len(payload) < 8 {
+		return 0, fmt.Errorf("Payload of %d bytes, expected 8", len(payload))
+	}
+
+	return binary.LittleEndian.Uint64(payload), nil
+}
+
+// handle handles a request of the frontend, returning the payload of its
+// reply if it has one.
+func (d *device) handle(msg *vhostUserMsg) ([]byte, error) {
+	defer msg.closeFds()
+
+	switch msg.request {
+	case vhostUserGetFeatures:
+		reply := make([]byte, 8)
+		binary.LittleEndian.PutUint64(reply, virtioFVersion1)
+		return reply, nil
+
+	case vhostUserSetFeatures:
+		features, err := payloadU64(msg.payload)
+		if err != nil {
+			return nil, err
+		}
+		if features&^virtioFVersion1 != 0 {
+			return nil, fmt.Errorf("Unsupported features %#x", features)
+		}
+		d.features = features
+		return nil, nil
+
+	case vhostUserSetOwner:
+		return nil, nil
+
+	case vhostUserResetOwner:
+		d.reset()
+		return nil, nil
+
+	case vhostUserSetMemTable:
+		return nil, d.setMemTable(msg)
+
+	case vhostUserSetVringNum:
+		vq, err := d.queue(msg.payload, 8)
+		if err != nil {
+			return nil, err
+		}
+		num := binary.LittleEndian.Uint32(msg.payload[4:])
+		if num == 0 || num > vringMaxSize || num&(num-1) != 0 {
+			return nil, fmt.Errorf("Invalid queue size %d", num)
+		}
+		vq.size = uint16(num)
+		return nil, nil
+
+	case vhostUserSetVringAddr:
+		return nil, d.setVringAddr(msg.payload)
+
+	case vhostUserSetVringBase:
+		vq, err := d.queue(msg.payload, 8)
+		if err != nil {
+			return nil, err
+		}
+		vq.lastAvail = uint16(binary.LittleEndian.Uint32(msg.payload[4:]))
+		return nil, nil
+
+	case vhostUserGetVringBase:
+		vq, err := d.queue(msg.payload, 8)
+		if err != nil {
+			return nil, err
+		}
+		reply := make([]byte, 8)
+		copy(reply, msg.payload[:4])
+		binary.LittleEndian.PutUint32(reply[4:], uint32(vq.stop()))
+		return reply, nil
+
+	case vhostUserSetVringKick, vhostUserSetVringCall, vhostUserSetVringErr:
+		return nil, d.setVringFd(msg)
+	}
+
+	return nil, fmt.Errorf("Unsupported request %d", msg.request)
+}
+
+func (d *device) setMemTable(msg *vhostUserMsg) error {
+	if len(msg.payload) < 8 {
+		return fmt.Errorf("Invalid memory table")
+	}
+
+	n := int(binary.LittleEndian.Uint32(msg.payload))
+	if n > vhostUserMaxRegions || len(msg.payload) < 8+n*32 || len(msg.fds) != n {
+		return fmt.Errorf("Invalid memory table of %d regions, %d file descriptors", n, len(msg.fds))
+	}
+
+	var memory guestMemory
+
+	for i := 0; i < n; i++ {
+		p := msg.payload[8+i*32:]
+		size := binary.LittleEndian.Uint64(p[8:])
+		offset := binary.LittleEndian.Uint64(p[24:])
+
+		mapping, err := unix.Mmap(msg.fds[i], 0, int(offset+size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if err != nil {
+			memory.unmap()
+			return fmt.Errorf("Could not map the guest memory: %v", err)
+		}
+
+		memory = append(memory, memoryRegion{
+			guestAddr: binary.LittleEndian.Uint64(p[0:]),
+			userAddr:  binary.LittleEndian.Uint64(p[16:]),
+			data:      mapping[offset:],
+			mapping:   mapping,
+		})
+	}
+
+	// The rings are in the previous mappings, the frontend sets them
+	// again after changing the memory table.
+	for i := range d.queues {
+		vq := &d.queues[i]
+		vq.enabled = false
+		vq.desc, vq.avail, vq.used = nil, nil, nil
+	}
+
+	d.memory.unmap()
+	d.memory = memory
+
+	return nil
+}
+
+func (d *device) setVringAddr(payload []byte) error {
+	vq, err := d.queue(payload, 40)
+	if err != nil {
+		return err
+	}
+
+	if vq.size == 0 {
+		return fmt.Errorf("The size of the queue is not set")
+	}
+
+	num := uint64(vq.size)
+
+	desc, err := d.memory.translateUser(binary.LittleEndian.Uint64(payload[8:]), num*vringDescSize)
+	if err != nil {
+		return err
+	}
+
+	used, err := d.memory.translateUser(binary.LittleEndian.Uint64(payload[16:]), 6+num*8)
+	if err != nil {
+		return err
+	}
+
+	avail, err := d.memory.translateUser(binary.LittleEndian.Uint64(payload[24:]), 6+num*2)
+	if err != nil {
+		return err
+	}
+
+	return vq.setAddr(desc, avail, used)
+}
+
+// setVringFd sets the kick or call eventfd of a queue. The queue starts
+// when the kick is set, the protocol features not being negotiated. The
+// error eventfd is not used.
+func (d *device) setVringFd(msg *vhostUserMsg) error {
+	vq, err := d.queue(msg.payload, 8)
+	if err != nil {
+		return err
+	}
+
+	fd := -1
+	if binary.LittleEndian.Uint64(msg.payload)&vhostUserVringNoFd == 0 {
+		if fd, err = msg.takeFd(); err != nil {
+			return err
+		}
+	}
+
+	switch msg.request {
+	case vhostUserSetVringKick:
+		if fd < 0 {
+			return fmt.Errorf("Polling the queues is not supported")
+		}
+		closeFd(&vq.kick)
+		vq.kick = fd
+		vq.start()
+	case vhostUserSetVringCall:
+		closeFd(&vq.call)
+		vq.call = fd
+	default:
+		closeFd(&fd)
+	}
+
+	return nil
+}
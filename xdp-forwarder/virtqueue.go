@@ -0,0 +1,236 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	vringDescSize = 16
+
+	vringDescFNext     = 0x1
+	vringDescFWrite    = 0x2
+	vringDescFIndirect = 0x4
+
+	vringAvailFNoInterrupt = 0x1
+
+	// vringMaxSize is the largest size of a split virtqueue.
+	vringMaxSize = 32768
+)
+
+// The rings are little endian, the CPUs sharing them are not.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// loadRingHeader reads the flags and the index at the start of the avail
+// or used ring. The driver updates them from other CPUs: the 32-bit word
+// holding them is read atomically, which orders the accesses to the ring
+// entries after it.
+func loadRingHeader(ring []byte) (flags uint16, idx uint16) {
+	w := atomic.LoadUint32((*uint32)(unsafe.Pointer(&ring[0])))
+
+	var b [4]byte
+	nativeEndian.PutUint32(b[:], w)
+
+	return binary.LittleEndian.Uint16(b[0:]), binary.LittleEndian.Uint16(b[2:])
+}
+
+// storeRingIdx publishes the index of the used ring, atomically so that
+// the driver sees the ring entries written before it.
+func storeRingIdx(ring []byte, idx uint16) {
+	var b [4]byte
+	copy(b[:2], ring[:2])
+	binary.LittleEndian.PutUint16(b[2:], idx)
+
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&ring[0])), nativeEndian.Uint32(b[:]))
+}
+
+// buffer is a descriptor of a chain, mapped in the memory of the forwarder.
+type buffer struct {
+	data  []byte
+	write bool
+}
+
+// chain is a chain of descriptors the driver made available.
+type chain struct {
+	head    uint16
+	buffers []buffer
+}
+
+// virtqueue is a split virtqueue of the guest. It is only accessed from the
+// loop of the forwarder.
+type virtqueue struct {
+	size uint16
+
+	desc  []byte
+	avail []byte
+	used  []byte
+
+	lastAvail uint16
+	lastUsed  uint16
+
+	// kick is signaled by the driver when it makes descriptors
+	// available, call is signaled to interrupt it. They are -1 when not
+	// set.
+	kick int
+	call int
+
+	// enabled is set when the driver started the queue.
+	enabled bool
+}
+
+func newVirtqueue() virtqueue {
+	return virtqueue{kick: -1, call: -1}
+}
+
+func (vq *virtqueue) ready() bool {
+	return vq.enabled && vq.desc != nil
+}
+
+// setAddr sets the rings of the queue. The headers of the avail and used
+// rings are accessed as 32-bit words, which must be aligned.
+func (vq *virtqueue) setAddr(desc, avail, used []byte) error {
+	for _, ring := range [][]byte{avail, used} {
+		if uintptr(unsafe.Pointer(&ring[0]))%4 != 0 {
+			return fmt.Errorf("Unaligned rings are not supported")
+		}
+	}
+
+	vq.desc, vq.avail, vq.used = desc, avail, used
+
+	return nil
+}
+
+// start starts the queue, from the last used index of the driver.
+func (vq *virtqueue) start() {
+	if vq.used != nil {
+		_, vq.lastUsed = loadRingHeader(vq.used)
+	}
+	vq.enabled = true
+}
+
+// stop stops the queue, returning the index of the next available
+// descriptor to process.
+func (vq *virtqueue) stop() uint16 {
+	vq.enabled = false
+	closeFd(&vq.kick)
+
+	return vq.lastAvail
+}
+
+// reset stops the queue and forgets its rings, whose memory is unmapped.
+func (vq *virtqueue) reset() {
+	vq.stop()
+	closeFd(&vq.call)
+	*vq = newVirtqueue()
+}
+
+// pop returns the next chain the driver made available, false if there is
+// none.
+func (vq *virtqueue) pop(mem guestMemory) (*chain, bool, error) {
+	if _, idx := loadRingHeader(vq.avail); idx == vq.lastAvail {
+		return nil, false, nil
+	}
+
+	slot := 4 + 2*(int(vq.lastAvail)%int(vq.size))
+	head := binary.LittleEndian.Uint16(vq.avail[slot:])
+	vq.lastAvail++
+
+	c := &chain{head: head}
+
+	for i, n := head, 0; ; n++ {
+		if i >= vq.size || n >= int(vq.size) {
+			return nil, false, fmt.Errorf("Invalid descriptor chain %d", head)
+		}
+
+		d := vq.desc[int(i)*vringDescSize:]
+		addr := binary.LittleEndian.Uint64(d[0:])
+		length := binary.LittleEndian.Uint32(d[8:])
+		flags := binary.LittleEndian.Uint16(d[12:])
+
+		if flags&vringDescFIndirect != 0 {
+			return nil, false, fmt.Errorf("Indirect descriptors are not supported")
+		}
+
+		data, err := mem.translate(addr, uint64(length))
+		if err != nil {
+			return nil, false, err
+		}
+
+		c.buffers = append(c.buffers, buffer{data: data, write: flags&vringDescFWrite != 0})
+
+		if flags&vringDescFNext == 0 {
+			return c, true, nil
+		}
+
+		i = binary.LittleEndian.Uint16(d[14:])
+	}
+}
+
+// push gives the chain head back to the driver, length bytes having been
+// written to it.
+func (vq *virtqueue) push(head uint16, length uint32) {
+	slot := 4 + 8*(int(vq.lastUsed)%int(vq.size))
+	binary.LittleEndian.PutUint32(vq.used[slot:], uint32(head))
+	binary.LittleEndian.PutUint32(vq.used[slot+4:], length)
+
+	vq.lastUsed++
+	storeRingIdx(vq.used, vq.lastUsed)
+}
+
+// notify interrupts the driver, unless it disabled the interrupts.
+func (vq *virtqueue) notify() error {
+	if vq.call < 0 {
+		return nil
+	}
+
+	if flags, _ := loadRingHeader(vq.avail); flags&vringAvailFNoInterrupt != 0 {
+		return nil
+	}
+
+	return signalEventFd(vq.call)
+}
+
+func signalEventFd(fd int) error {
+	var b [8]byte
+	nativeEndian.PutUint64(b[:], 1)
+
+	// The counter only overflows when the reader is far behind, which
+	// has been signaled already.
+	if _, err := unix.Write(fd, b[:]); err != nil && err != unix.EAGAIN {
+		return err
+	}
+
+	return nil
+}
+
+func drainEventFd(fd int) error {
+	var b [8]byte
+
+	if _, err := unix.Read(fd, b[:]); err != nil && err != unix.EAGAIN {
+		return err
+	}
+
+	return nil
+}
+
+func closeFd(fd *int) {
+	if *fd >= 0 {
+		unix.Close(*fd)
+		*fd = -1
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xskFrameSize = 2048
+	xskNumFrames = 4096
+
+	// The first half of the frames of the UMEM receive, the other half
+	// transmits. Each ring holds all the frames of its half, so that
+	// they never overflow.
+	xskRingSize = xskNumFrames / 2
+
+	// xdpPass is the action of the XDP program on the packets of the
+	// queues without socket.
+	xdpPass = 2
+
+	// xdpRxQueueIndexOffset is the offset of rx_queue_index in the
+	// xdp_md context of the XDP program.
+	xdpRxQueueIndexOffset = 16
+)
+
+var errXSKBusy = errors.New("No frame available to transmit")
+
+// port is the side of the network interface the forwarder exchanges the
+// frames with.
+type port interface {
+	// fd is polled for the received frames.
+	fd() int
+
+	// receive passes the received frames to fn, until it returns
+	// false. The frame fn returns false for is received again by the
+	// next call.
+	receive(fn func(frame []byte) bool) error
+
+	// transmit queues a frame, flush transmits the queued frames.
+	transmit(frame []byte) error
+	flush() error
+
+	close() error
+}
+
+// xskRing is a ring of an AF_XDP socket, shared with the kernel.
+type xskRing struct {
+	producer *uint32
+	consumer *uint32
+	descs    unsafe.Pointer
+	mapping  []byte
+}
+
+func (r *xskRing) mmap(fd int, offset int64, off unix.XDPRingOffset, descSize uintptr) error {
+	mapping, err := unix.Mmap(fd, offset, int(off.Desc)+xskRingSize*int(descSize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+
+	r.mapping = mapping
+	r.producer = (*uint32)(unsafe.Pointer(&mapping[off.Producer]))
+	r.consumer = (*uint32)(unsafe.Pointer(&mapping[off.Consumer]))
+	r.descs = unsafe.Pointer(&mapping[off.Desc])
+
+	return nil
+}
+
+func (r *xskRing) addr(i uint32) *uint64 {
+	return (*uint64)(unsafe.Pointer(uintptr(r.descs) + uintptr(i%xskRingSize)*8))
+}
+
+func (r *xskRing) desc(i uint32) *unix.XDPDesc {
+	return (*unix.XDPDesc)(unsafe.Pointer(uintptr(r.descs) + uintptr(i%xskRingSize)*unsafe.Sizeof(unix.XDPDesc{})))
+}
+
+func (r *xskRing) unmap() {
+	if r.mapping != nil {
+		unix.Munmap(r.mapping)
+		r.mapping = nil
+	}
+}
+
+// xsk is an AF_XDP socket bound to a queue of a network interface, which
+// an XDP program redirects the packets received on the queue to. The
+// program is attached through a BPF link, which the kernel detaches when
+// the forwarder exits, whichever way.
+type xsk struct {
+	sock int
+	umem []byte
+
+	fill       xskRing
+	completion xskRing
+	rx         xskRing
+	tx         xskRing
+
+	// txFrames are the addresses of the frames free to transmit.
+	txFrames []uint64
+
+	xsks int
+	prog int
+	link int
+}
+
+func setsockopt(fd, level, name int, val unsafe.Pointer, size uintptr) error {
+	if _, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(val), size, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func getsockopt(fd, level, name int, val unsafe.Pointer, size uintptr) error {
+	l := uint32(size)
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(val), uintptr(unsafe.Pointer(&l)), 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// newXSK binds an AF_XDP socket to the queue of the network interface
+// ifname, and redirects the packets of the queue to it.
+func newXSK(ifname string, queue uint32) (x *xsk, err error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	x = &xsk{sock: -1, xsks: -1, prog: -1, link: -1}
+	defer func() {
+		if err != nil {
+			x.close()
+		}
+	}()
+
+	if x.sock, err = unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0); err != nil {
+		return nil, fmt.Errorf("Could not create the AF_XDP socket: %v", err)
+	}
+
+	if x.umem, err = unix.Mmap(-1, 0, xskNumFrames*xskFrameSize, unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE); err != nil {
+		return nil, err
+	}
+
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&x.umem[0]))),
+		Len:  uint64(len(x.umem)),
+		Size: xskFrameSize,
+	}
+	if err = setsockopt(x.sock, unix.SOL_XDP, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return nil, fmt.Errorf("Could not register the UMEM: %v", err)
+	}
+
+	for _, ring := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING, unix.XDP_TX_RING} {
+		if err = unix.SetsockoptInt(x.sock, unix.SOL_XDP, ring, xskRingSize); err != nil {
+			return nil, fmt.Errorf("Could not size the rings: %v", err)
+		}
+	}
+
+	var off unix.XDPMmapOffsets
+	if err = getsockopt(x.sock, unix.SOL_XDP, unix.XDP_MMAP_OFFSETS, unsafe.Pointer(&off), unsafe.Sizeof(off)); err != nil {
+		return nil, err
+	}
+
+	descSize := unsafe.Sizeof(unix.XDPDesc{})
+	for _, r := range []struct {
+		ring     *xskRing
+		offset   int64
+		off      unix.XDPRingOffset
+		descSize uintptr
+	}{
+		{&x.fill, unix.XDP_UMEM_PGOFF_FILL_RING, off.Fr, 8},
+		{&x.completion, unix.XDP_UMEM_PGOFF_COMPLETION_RING, off.Cr, 8},
+		{&x.rx, unix.XDP_PGOFF_RX_RING, off.Rx, descSize},
+		{&x.tx, unix.XDP_PGOFF_TX_RING, off.Tx, descSize},
+	} {
+		if err = r.ring.mmap(x.sock, r.offset, r.off, r.descSize); err != nil {
+			return nil, fmt.Errorf("Could not map the rings: %v", err)
+		}
+	}
+
+	for i := uint32(0); i < xskRingSize; i++ {
+		*x.fill.addr(i) = uint64(i) * xskFrameSize
+		x.txFrames = append(x.txFrames, uint64(i+xskRingSize)*xskFrameSize)
+	}
+	atomic.StoreUint32(x.fill.producer, xskRingSize)
+
+	if err = unix.Bind(x.sock, &unix.SockaddrXDP{Ifindex: uint32(iface.Index), QueueID: queue}); err != nil {
+		return nil, fmt.Errorf("Could not bind the AF_XDP socket to queue %d of %s: %v", queue, ifname, err)
+	}
+
+	if err = x.attach(iface.Index, queue); err != nil {
+		return nil, fmt.Errorf("Could not attach the XDP program to %s: %v", ifname, err)
+	}
+
+	return x, nil
+}
+
+// attach attaches the XDP program redirecting the packets of the queue to
+// the socket. The packets of the other queues go through the kernel.
+func (x *xsk) attach(ifindex int, queue uint32) (err error) {
+	if x.xsks, err = newXSKMap("kata_xsks", queue+1); err != nil {
+		return err
+	}
+
+	if err = bpfMapUpdate(x.xsks, queue, uint32(x.sock)); err != nil {
+		return err
+	}
+
+	if x.prog, err = loadXDPRedirect("kata_xdp_redirect", x.xsks); err != nil {
+		return err
+	}
+
+	x.link, err = attachXDP(x.prog, ifindex)
+
+	return err
+}
+
+func (x *xsk) fd() int {
+	return x.sock
+}
+
+func (x *xsk) receive(fn func(frame []byte) bool) error {
+	cons := *x.rx.consumer
+	prod := atomic.LoadUint32(x.rx.producer)
+
+	var frames []uint64
+	for ; cons != prod; cons++ {
+		d := x.rx.desc(cons)
+		if !fn(x.umem[d.Addr : d.Addr+uint64(d.Len)]) {
+			break
+		}
+		frames = append(frames, d.Addr)
+	}
+
+	if len(frames) == 0 {
+		return nil
+	}
+
+	atomic.StoreUint32(x.rx.consumer, cons)
+
+	// The frames go back to the fill ring, which holds all of them.
+	fill := *x.fill.producer
+	for _, addr := range frames {
+		*x.fill.addr(fill) = addr &^ (xskFrameSize - 1)
+		fill++
+	}
+	atomic.StoreUint32(x.fill.producer, fill)
+
+	return nil
+}
+
+// reclaim takes back the frames the kernel transmitted.
+func (x *xsk) reclaim() {
+	cons := *x.completion.consumer
+	prod := atomic.LoadUint32(x.completion.producer)
+
+	for ; cons != prod; cons++ {
+		x.txFrames = append(x.txFrames, *x.completion.addr(cons))
+	}
+
+	atomic.StoreUint32(x.completion.consumer, cons)
+}
+
+func (x *xsk) transmit(frame []byte) error {
+	if len(frame) > xskFrameSize {
+		return fmt.Errorf("Frame of %d bytes is too large", len(frame))
+	}
+
+	if len(x.txFrames) == 0 {
+		x.reclaim()
+		if len(x.txFrames) == 0 {
+			return errXSKBusy
+		}
+	}
+
+	addr := x.txFrames[len(x.txFrames)-1]
+	x.txFrames = x.txFrames[:len(x.txFrames)-1]
+
+	copy(x.umem[addr:], frame)
+
+	prod := *x.tx.producer
+	*x.tx.desc(prod) = unix.XDPDesc{Addr: addr, Len: uint32(len(frame))}
+	atomic.StoreUint32(x.tx.producer, prod+1)
+
+	return nil
+}
+
+// flush wakes the kernel up to transmit the frames of the tx ring.
+func (x *xsk) flush() error {
+	if _, _, errno := unix.Syscall6(unix.SYS_SENDTO, uintptr(x.sock), 0, 0, unix.MSG_DONTWAIT, 0, 0); errno != 0 {
+		switch errno {
+		case unix.EAGAIN, unix.EBUSY, unix.ENOBUFS:
+		default:
+			return errno
+		}
+	}
+
+	x.reclaim()
+
+	return nil
+}
+
+func (x *xsk) close() error {
+	closeFd(&x.link)
+	closeFd(&x.prog)
+	closeFd(&x.xsks)
+
+	for _, r := range []*xskRing{&x.fill, &x.completion, &x.rx, &x.tx} {
+		r.unmap()
+	}
+
+	closeFd(&x.sock)
+
+	if x.umem != nil {
+		unix.Munmap(x.umem)
+		x.umem = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019 HyperHQ Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const testDisabledAsNonRoot = "Test disabled as requires root privileges"
+
+var tc ktu.TestConstraint
+
+func init() {
+	tc = ktu.NewTestConstraint(false)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func testFrame(src, dst net.HardwareAddr, payload string) []byte {
+	frame := append(append(append([]byte{}, dst...), src...), 0x88, 0xb5)
+	return append(frame, []byte(payload)...)
+}
+
+func TestXSK(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(testDisabledAsNonRoot)
+	}
+
+	assert := assert.New(t)
+
+	// The veth pair is created in a new network namespace, the current
+	// one being restored after the test.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	assert.NoError(err)
+	defer origin.Close()
+
+	ns, err := netns.New()
+	assert.NoError(err)
+	defer ns.Close()
+	defer netns.Set(origin)
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "xsk0"},
+		PeerName:  "xsk1",
+	}
+	assert.NoError(netlink.LinkAdd(veth))
+
+	peer, err := netlink.LinkByName("xsk1")
+	assert.NoError(err)
+	assert.NoError(netlink.LinkSetUp(veth))
+	assert.NoError(netlink.LinkSetUp(peer))
+
+	x, err := newXSK("xsk0", 0)
+	if err != nil {
+		t.Skipf("AF_XDP sockets are not available: %v", err)
+	}
+	defer x.close()
+
+	packet, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	assert.NoError(err)
+	defer unix.Close(packet)
+	assert.NoError(unix.Bind(packet, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: peer.Attrs().Index}))
+
+	src := peer.Attrs().HardwareAddr
+	dst := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	// The packets received on the queue are redirected to the socket.
+	sent := testFrame(src, dst, "to the socket")
+	_, err = unix.Write(packet, sent)
+	assert.NoError(err)
+
+	var received []byte
+	for start := time.Now(); received == nil && time.Since(start) < 5*time.Second; {
+		_, err := unix.Poll([]unix.PollFd{{Fd: int32(x.fd()), Events: unix.POLLIN}}, 100)
+		assert.NoError(err)
+
+		assert.NoError(x.receive(func(frame []byte) bool {
+			received = append([]byte{}, frame...)
+			return true
+		}))
+	}
+	assert.Equal(sent, received)
+
+	// The frames of the socket are transmitted on the interface.
+	transmitted := testFrame(src, dst, "from the socket")
+	assert.NoError(x.transmit(transmitted))
+	assert.NoError(x.flush())
+
+	buf := make([]byte, 2048)
+	found := false
+	for start := time.Now(); !found && time.Since(start) < 5*time.Second; {
+		n, err := unix.Poll([]unix.PollFd{{Fd: int32(packet), Events: unix.POLLIN}}, 100)
+		assert.NoError(err)
+		if n == 0 {
+			continue
+		}
+
+		n, _, err = unix.Recvfrom(packet, buf, 0)
+		assert.NoError(err)
+		found = bytes.Equal(transmitted, buf[:n])
+	}
+	assert.True(found)
+}